@@ -0,0 +1,217 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "strings"
+
+    "pwr-stateful-vida/accountrules"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// failurePendingNotFound rejects an approval of an unknown pending operation
+const failurePendingNotFound = "pending_not_found"
+
+// reportAccountRulesError reports an unexpected error reading or writing account rules
+func reportAccountRulesError(ctx context.Context, err error, account []byte) {
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        "accountRules",
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"account": hex.EncodeToString(account)},
+    })
+}
+
+// checkAccountRules returns the rule of the sender a transfer breaks, if any
+func (a *App) checkAccountRules(ctx context.Context, sender, receiver []byte, token string, amount *big.Int, block int64) string {
+    violation, err := accountrules.Check(a.db, sender, receiver, token, amount, block, a.chainParams().AccountRules.BlocksPerDay)
+    if err != nil {
+        reportAccountRulesError(ctx, err, sender)
+        return failureInvalidPayload
+    }
+    if violation != "" {
+        syncLogger.InfoContext(ctx, "transfer rejected by account rules", "reason", violation, "amount", amount,
+            "sender", hex.EncodeToString(sender), "receiver", hex.EncodeToString(receiver))
+    }
+    return violation
+}
+
+// recordAccountSpend counts a completed transfer against the daily and rolling spend
+// limits of its sender
+func (a *App) recordAccountSpend(ctx context.Context, sender []byte, token string, amount *big.Int, block int64) {
+    if !tokens.IsNative(token) {
+        return
+    }
+    if err := accountrules.RecordSpend(a.db, sender, amount, block, a.chainParams().AccountRules.BlocksPerDay); err != nil {
+        reportAccountRulesError(ctx, err, sender)
+    }
+}
+
+// holdForCoSigner stores the transfer of an account with a co-signer until the
+// co-signer approves it, returning true when it was held
+func (a *App) holdForCoSigner(ctx context.Context, sender, receiver []byte, token string, amount *big.Int, transaction rpc.VidaDataTransaction) (bool, string) {
+    needed, err := accountrules.NeedsCoSigner(a.db, sender)
+    if err != nil {
+        reportAccountRulesError(ctx, err, sender)
+        return false, failureInvalidPayload
+    }
+    if !needed {
+        return false, ""
+    }
+    if amount == nil || amount.Sign() <= 0 || len(receiver) == 0 {
+        return false, failureInvalidAmount
+    }
+    if err := accountrules.HoldTransfer(a.db, sender, receiver, token, amount, transaction.Hash, int64(transaction.BlockNumber)); err != nil {
+        reportAccountRulesError(ctx, err, sender)
+        return false, failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "transfer held for the co-signer", "hash", transaction.Hash, "amount", amount, "sender", transaction.Sender)
+    return true, ""
+}
+
+// accountRulesPayload is the payload of an accountRules action
+type accountRulesPayload struct {
+    envelope
+    DailyLimit          number   `json:"dailyLimit"`
+    AllowedDestinations []string `json:"allowedDestinations"`
+    CoSigner            string   `json:"coSigner"`
+}
+
+// handleAccountRules replaces the rules of the sender's account. It returns the
+// reason the change was rejected, or an empty string on success.
+func (a *App) handleAccountRules(ctx context.Context, p *accountRulesPayload, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    rules := accountrules.Rules{
+        DailyLimit:          p.DailyLimit.String(),
+        AllowedDestinations: p.AllowedDestinations,
+        CoSigner:            p.CoSigner,
+    }
+
+    held, err := accountrules.Set(a.db, sender, rules, transaction.Hash, int64(transaction.BlockNumber))
+    if errors.Is(err, accountrules.ErrInvalidRules) {
+        syncLogger.WarnContext(ctx, "skipping invalid account rules", "payload", p, "error", err)
+        return failureInvalidPayload
+    }
+    if err != nil {
+        reportAccountRulesError(ctx, err, sender)
+        return failureInvalidPayload
+    }
+    if held {
+        syncLogger.InfoContext(ctx, "account rules change held for the co-signer", "hash", transaction.Hash, "sender", transaction.Sender)
+        return ""
+    }
+    syncLogger.InfoContext(ctx, "account rules updated", "sender", transaction.Sender)
+    return ""
+}
+
+// spendLimitPayload is the payload of a spendLimit action
+type spendLimitPayload struct {
+    envelope
+    Amount number `json:"amount"`
+    Blocks number `json:"blocks"`
+    Remove bool   `json:"remove"`
+
+    amount *big.Int
+    blocks int64
+}
+
+func (p *spendLimitPayload) validate(a *App) error {
+    if p.Remove {
+        return nil
+    }
+    var ok bool
+    if p.amount, ok = p.Amount.integer(); !ok {
+        return invalidField("amount", "must be an integer")
+    }
+    if p.blocks, ok = payloadInt(p.Blocks); !ok {
+        return invalidField("blocks", "must be a non-negative integer")
+    }
+    return nil
+}
+
+// handleSpendLimit sets the rolling limit of the sender's account to "amount" per
+// "blocks", or removes it with "remove". A looser limit only applies one window of
+// the current limit later. It returns the reason the change was rejected, or an
+// empty string on success.
+func (a *App) handleSpendLimit(ctx context.Context, p *spendLimitPayload, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    amount, blocks := p.amount, p.blocks
+
+    from, err := accountrules.SetSpendLimit(a.db, sender, amount, blocks, int64(transaction.BlockNumber))
+    if errors.Is(err, accountrules.ErrInvalidRules) {
+        syncLogger.WarnContext(ctx, "skipping invalid spend limit", "payload", p, "error", err)
+        return failureInvalidPayload
+    }
+    if err != nil {
+        reportAccountRulesError(ctx, err, sender)
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "spend limit set", "amount", amount, "blocks", blocks, "from", from, "sender", transaction.Sender)
+    return ""
+}
+
+// cosignPayload is the payload of a cosign action
+type cosignPayload struct {
+    envelope
+    // Transaction is the hash of the pending transaction
+    Transaction string `json:"transaction"`
+    // Op is "reject" to reject it, and approves it otherwise
+    Op string `json:"op"`
+}
+
+func (p *cosignPayload) validate(a *App) error {
+    if p.Transaction == "" {
+        return invalidField("transaction", "required")
+    }
+    return nil
+}
+
+// handleCosign approves or rejects a pending transfer or rule change. Approved
+// transfers are executed with the checks of the current block. It returns the
+// reason the approval or the transfer was rejected, or an empty string on success.
+func (a *App) handleCosign(ctx context.Context, p *cosignPayload, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    hash := p.Transaction
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    approve := !strings.EqualFold(p.Op, "reject")
+
+    pending, err := accountrules.Resolve(a.db, sender, hash, approve)
+    switch {
+    case errors.Is(err, accountrules.ErrNotFound):
+        return failurePendingNotFound
+    case errors.Is(err, accountrules.ErrNotCoSigner):
+        syncLogger.WarnContext(ctx, "co-sign from an account other than the co-signer", "transaction", hash, "sender", transaction.Sender)
+        return failureUnauthorized
+    case err != nil:
+        reportAccountRulesError(ctx, err, sender)
+        return failureInvalidPayload
+    }
+    if !approve {
+        syncLogger.InfoContext(ctx, "pending operation rejected", "transaction", hash, "sender", transaction.Sender)
+        return ""
+    }
+    if pending.Kind == accountrules.KindRules {
+        syncLogger.InfoContext(ctx, "account rules change approved", "transaction", hash, "account", pending.Account)
+        return ""
+    }
+
+    account, _ := hex.DecodeString(pending.Account)
+    receiver, _ := hex.DecodeString(pending.Receiver)
+    amount := dbservice.ParseAmount(pending.Amount)
+    return a.executeTransfer(ctx, account, receiver, pending.Token, amount, int64(transaction.BlockNumber))
+}
@@ -0,0 +1,342 @@
+// Package accountrules keeps the rules accounts attach to themselves and that the
+// transfer handler enforces: a daily spend limit, a rolling spend limit over a
+// number of blocks, a set of allowed destinations and a co-signer that has to
+// approve transfers and rule changes. Days are counted in blocks so every node
+// agrees on them. Everything is part of the Merkle state.
+package accountrules
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "sort"
+    "strings"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// Kinds of pending operations
+const (
+    KindTransfer = "transfer"
+    KindRules    = "rules"
+)
+
+// MaxDestinations is the largest allowed destination set
+const MaxDestinations = 100
+
+// Errors of rejected rule changes and approvals
+var (
+    ErrInvalidRules = errors.New("invalid account rules")
+    ErrNotFound     = errors.New("pending operation not found")
+    ErrNotCoSigner  = errors.New("sender is not the co-signer")
+)
+
+// Reasons a transfer breaks the rules of its sender
+const (
+    ViolationDailyLimit  = "daily_limit_exceeded"
+    ViolationDestination = "destination_not_allowed"
+)
+
+// Rules are the rules of an account. Empty fields do not restrict anything.
+type Rules struct {
+    // DailyLimit is the most of the native token the account can transfer per day
+    DailyLimit string `json:"dailyLimit,omitempty"`
+    // AllowedDestinations are the only addresses the account can transfer to
+    AllowedDestinations []string `json:"allowedDestinations,omitempty"`
+    // CoSigner must approve the transfers and rule changes of the account
+    CoSigner string `json:"coSigner,omitempty"`
+}
+
+// Pending is a transfer or rule change waiting for the co-signer
+type Pending struct {
+    Hash     string `json:"hash"`
+    Account  string `json:"account"`
+    Kind     string `json:"kind"`
+    Receiver string `json:"receiver,omitempty"`
+    Token    string `json:"token,omitempty"`
+    Amount   string `json:"amount,omitempty"`
+    Rules    *Rules `json:"rules,omitempty"`
+    Block    int64  `json:"block"`
+}
+
+// spent is what an account transferred on a day
+type spent struct {
+    Day    int64  `json:"day"`
+    Amount string `json:"amount"`
+}
+
+// key returns the tree key of a record of an account or operation
+func key(kind, id string) []byte {
+    return []byte(dbservice.AccountRulesPrefix + kind + "/" + id)
+}
+
+// Get returns the rules of an account
+func Get(db *dbservice.DatabaseService, account []byte) (Rules, error) {
+    var rules Rules
+    _, err := db.LoadJSON(key("rules", hex.EncodeToString(account)), &rules)
+    return rules, err
+}
+
+// Normalize validates rules and puts their addresses in canonical form
+func Normalize(rules Rules) (Rules, error) {
+    if rules.DailyLimit != "" {
+        limit, ok := new(big.Int).SetString(rules.DailyLimit, 10)
+        if !ok || limit.Sign() < 0 {
+            return rules, fmt.Errorf("%w: dailyLimit must be a non-negative integer", ErrInvalidRules)
+        }
+        rules.DailyLimit = limit.String()
+    }
+    if len(rules.AllowedDestinations) > MaxDestinations {
+        return rules, fmt.Errorf("%w: at most %d allowed destinations", ErrInvalidRules, MaxDestinations)
+    }
+    destinations := make([]string, 0, len(rules.AllowedDestinations))
+    for _, destination := range rules.AllowedDestinations {
+        address, ok := canonical(destination)
+        if !ok {
+            return rules, fmt.Errorf("%w: %q is not an address", ErrInvalidRules, destination)
+        }
+        destinations = append(destinations, address)
+    }
+    sort.Strings(destinations)
+    rules.AllowedDestinations = destinations
+    if len(destinations) == 0 {
+        rules.AllowedDestinations = nil
+    }
+    if rules.CoSigner != "" {
+        address, ok := canonical(rules.CoSigner)
+        if !ok {
+            return rules, fmt.Errorf("%w: the co-signer is not an address", ErrInvalidRules)
+        }
+        rules.CoSigner = address
+    }
+    return rules, nil
+}
+
+// canonical returns the lower case hex of an address without 0x prefix
+func canonical(address string) (string, bool) {
+    if len(address) > 2 && (address[:2] == "0x" || address[:2] == "0X") {
+        address = address[2:]
+    }
+    decoded, err := hex.DecodeString(address)
+    if err != nil || len(decoded) != dbservice.AddressLength {
+        return "", false
+    }
+    return hex.EncodeToString(decoded), true
+}
+
+// Set replaces the rules of an account. When the account has a co-signer the change
+// waits for its approval and Set returns true.
+func Set(db *dbservice.DatabaseService, account []byte, rules Rules, hash string, block int64) (bool, error) {
+    rules, err := Normalize(rules)
+    if err != nil {
+        return false, err
+    }
+    current, err := Get(db, account)
+    if err != nil {
+        return false, err
+    }
+    if current.CoSigner != "" {
+        return true, addPending(db, Pending{Hash: hash, Account: hex.EncodeToString(account), Kind: KindRules, Rules: &rules, Block: block})
+    }
+    return false, db.SaveJSON(key("rules", hex.EncodeToString(account)), rules)
+}
+
+// Check returns the rule a transfer of amount of token from account to receiver at
+// a block breaks, or an empty string
+func Check(db *dbservice.DatabaseService, account, receiver []byte, token string, amount *big.Int, block, blocksPerDay int64) (string, error) {
+    rules, err := Get(db, account)
+    if err != nil {
+        return "", err
+    }
+    if rules.AllowedDestinations != nil {
+        receiverHex := hex.EncodeToString(receiver)
+        i := sort.SearchStrings(rules.AllowedDestinations, receiverHex)
+        if i == len(rules.AllowedDestinations) || rules.AllowedDestinations[i] != receiverHex {
+            return ViolationDestination, nil
+        }
+    }
+    if rules.DailyLimit != "" && tokens.IsNative(token) && amount != nil {
+        limit := dbservice.ParseAmount(rules.DailyLimit)
+        used, err := SpentToday(db, account, block, blocksPerDay)
+        if err != nil {
+            return "", err
+        }
+        if new(big.Int).Add(used, amount).Cmp(limit) > 0 {
+            return ViolationDailyLimit, nil
+        }
+    }
+    if tokens.IsNative(token) && amount != nil {
+        return checkSpendLimit(db, account, amount, block)
+    }
+    return "", nil
+}
+
+// day returns the day a block falls on
+func day(block, blocksPerDay int64) int64 {
+    if blocksPerDay <= 0 {
+        return 0
+    }
+    return block / blocksPerDay
+}
+
+// SpentToday returns the native amount account transferred on the day of block
+func SpentToday(db *dbservice.DatabaseService, account []byte, block, blocksPerDay int64) (*big.Int, error) {
+    var record spent
+    found, err := db.LoadJSON(key("spent", hex.EncodeToString(account)), &record)
+    if err != nil {
+        return nil, err
+    }
+    if !found || record.Day != day(block, blocksPerDay) {
+        return new(big.Int), nil
+    }
+    amount := dbservice.ParseAmount(record.Amount)
+    return amount, nil
+}
+
+// RecordSpend adds a native transfer to what account spent on the day of block and
+// in the window of its spend limit. It only keeps track for accounts with a limit.
+func RecordSpend(db *dbservice.DatabaseService, account []byte, amount *big.Int, block, blocksPerDay int64) error {
+    if err := recordWindowSpend(db, account, amount, block); err != nil {
+        return err
+    }
+    rules, err := Get(db, account)
+    if err != nil || rules.DailyLimit == "" {
+        return err
+    }
+    used, err := SpentToday(db, account, block, blocksPerDay)
+    if err != nil {
+        return err
+    }
+    record := spent{Day: day(block, blocksPerDay), Amount: new(big.Int).Add(used, amount).String()}
+    return db.SaveJSON(key("spent", hex.EncodeToString(account)), record)
+}
+
+// Unrestricted reports whether account has no rules and no spend limit at block,
+// so that nothing but its balance checks or records its transfers
+func Unrestricted(db *dbservice.DatabaseService, account []byte, block int64) (bool, error) {
+    rules, err := Get(db, account)
+    if err != nil || rules.DailyLimit != "" || rules.AllowedDestinations != nil || rules.CoSigner != "" {
+        return false, err
+    }
+    limits, err := LookupSpendLimits(db, account, block)
+    return err == nil && limits.window() == 0, err
+}
+
+// NeedsCoSigner reports whether the transfers of account wait for a co-signer
+func NeedsCoSigner(db *dbservice.DatabaseService, account []byte) (bool, error) {
+    rules, err := Get(db, account)
+    return rules.CoSigner != "", err
+}
+
+// pendingIndex returns the hashes of the pending operations of an account
+func pendingIndex(db *dbservice.DatabaseService, account string) ([]string, error) {
+    var hashes []string
+    _, err := db.LoadJSON(key("pendingIndex", account), &hashes)
+    return hashes, err
+}
+
+// savePendingIndex writes the hashes of the pending operations of an account
+func savePendingIndex(db *dbservice.DatabaseService, account string, hashes []string) error {
+    if len(hashes) == 0 {
+        return db.SaveJSON(key("pendingIndex", account), nil)
+    }
+    return db.SaveJSON(key("pendingIndex", account), hashes)
+}
+
+// normalizeHash returns a transaction hash in lower case without 0x prefix
+func normalizeHash(hash string) string {
+    return strings.TrimPrefix(strings.ToLower(hash), "0x")
+}
+
+// addPending stores an operation waiting for the co-signer
+func addPending(db *dbservice.DatabaseService, pending Pending) error {
+    pending.Hash = normalizeHash(pending.Hash)
+    if err := db.SaveJSON(key("pending", pending.Hash), pending); err != nil {
+        return err
+    }
+    hashes, err := pendingIndex(db, pending.Account)
+    if err != nil {
+        return err
+    }
+    return savePendingIndex(db, pending.Account, append(hashes, pending.Hash))
+}
+
+// HoldTransfer stores a transfer of a co-signed account until it is approved
+func HoldTransfer(db *dbservice.DatabaseService, account, receiver []byte, token string, amount *big.Int, hash string, block int64) error {
+    return addPending(db, Pending{
+        Hash:     hash,
+        Account:  hex.EncodeToString(account),
+        Kind:     KindTransfer,
+        Receiver: hex.EncodeToString(receiver),
+        Token:    token,
+        Amount:   amount.String(),
+        Block:    block,
+    })
+}
+
+// PendingOf returns the operations of an account waiting for its co-signer
+func PendingOf(db *dbservice.DatabaseService, account []byte) ([]Pending, error) {
+    hashes, err := pendingIndex(db, hex.EncodeToString(account))
+    if err != nil {
+        return nil, err
+    }
+    operations := []Pending{}
+    for _, hash := range hashes {
+        var pending Pending
+        found, err := db.LoadJSON(key("pending", hash), &pending)
+        if err != nil {
+            return nil, err
+        }
+        if found {
+            operations = append(operations, pending)
+        }
+    }
+    return operations, nil
+}
+
+// Resolve removes a pending operation on behalf of sender. The co-signer can
+// approve or reject it and the account itself can only reject it. An approved rule
+// change is applied; an approved transfer is returned for the caller to execute.
+func Resolve(db *dbservice.DatabaseService, sender []byte, hash string, approve bool) (Pending, error) {
+    hash = normalizeHash(hash)
+    var pending Pending
+    found, err := db.LoadJSON(key("pending", hash), &pending)
+    if err != nil {
+        return pending, err
+    }
+    if !found {
+        return pending, ErrNotFound
+    }
+    account, _ := hex.DecodeString(pending.Account)
+    rules, err := Get(db, account)
+    if err != nil {
+        return pending, err
+    }
+    senderHex := hex.EncodeToString(sender)
+    if senderHex != rules.CoSigner && (approve || senderHex != pending.Account) {
+        return pending, ErrNotCoSigner
+    }
+
+    if err := db.SaveJSON(key("pending", hash), nil); err != nil {
+        return pending, err
+    }
+    hashes, err := pendingIndex(db, pending.Account)
+    if err != nil {
+        return pending, err
+    }
+    remaining := hashes[:0]
+    for _, other := range hashes {
+        if other != hash {
+            remaining = append(remaining, other)
+        }
+    }
+    if err := savePendingIndex(db, pending.Account, remaining); err != nil {
+        return pending, err
+    }
+    if approve && pending.Kind == KindRules {
+        return pending, db.SaveJSON(key("rules", pending.Account), pending.Rules)
+    }
+    return pending, nil
+}
@@ -0,0 +1,184 @@
+package accountrules
+
+import (
+    "encoding/hex"
+    "fmt"
+    "math/big"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// ViolationSpendLimit is the reason a transfer goes over the rolling spend limit
+const ViolationSpendLimit = "spend_limit_exceeded"
+
+// SpendLimit caps the native amount an account transfers in any window of Blocks
+// consecutive blocks. It is set apart from the other rules and needs no co-signer:
+// a tighter limit applies at once, while a looser one, or its removal, only applies
+// one window later, so a stolen key cannot lift the limit and drain the account.
+type SpendLimit struct {
+    // Amount is the most transferred per window, empty for a removed limit
+    Amount string `json:"amount,omitempty"`
+    Blocks int64  `json:"blocks,omitempty"`
+    // From is the first block the limit applies at
+    From int64 `json:"from"`
+}
+
+// SpendLimits are the limit of an account in force and the looser one replacing it
+type SpendLimits struct {
+    Current *SpendLimit `json:"current,omitempty"`
+    Next    *SpendLimit `json:"next,omitempty"`
+}
+
+// windowSpend is what an account transferred in a block
+type windowSpend struct {
+    Block  int64  `json:"block"`
+    Amount string `json:"amount"`
+}
+
+// active returns the limit in force at block, promoting the next one once due
+func (l SpendLimits) active(block int64) SpendLimits {
+    if l.Next != nil && block >= l.Next.From {
+        l.Current, l.Next = l.Next, nil
+        if l.Current.Amount == "" {
+            l.Current = nil
+        }
+    }
+    return l
+}
+
+// window returns the longest window of the limits, which the spends are kept for
+func (l SpendLimits) window() int64 {
+    var blocks int64
+    for _, limit := range []*SpendLimit{l.Current, l.Next} {
+        if limit != nil && limit.Blocks > blocks {
+            blocks = limit.Blocks
+        }
+    }
+    return blocks
+}
+
+// LookupSpendLimits returns the spend limits of an account as of block
+func LookupSpendLimits(db *dbservice.DatabaseService, account []byte, block int64) (SpendLimits, error) {
+    var limits SpendLimits
+    _, err := db.LoadJSON(key("spendLimit", hex.EncodeToString(account)), &limits)
+    return limits.active(block), err
+}
+
+// tighter reports whether limit never allows more than current
+func tighter(limit, current *SpendLimit) bool {
+    if current == nil {
+        return true
+    }
+    if limit == nil {
+        return false
+    }
+    amount := dbservice.ParseAmount(limit.Amount)
+    currentAmount := dbservice.ParseAmount(current.Amount)
+    return amount.Cmp(currentAmount) <= 0 && limit.Blocks >= current.Blocks
+}
+
+// SetSpendLimit sets the rolling limit of an account at block to amount per blocks,
+// or removes it for a nil amount, and returns the block the change applies at
+func SetSpendLimit(db *dbservice.DatabaseService, account []byte, amount *big.Int, blocks, block int64) (int64, error) {
+    var limit *SpendLimit
+    if amount != nil {
+        if amount.Sign() < 0 || blocks <= 0 {
+            return 0, fmt.Errorf("%w: the spend limit needs a non-negative amount and a positive number of blocks", ErrInvalidRules)
+        }
+        limit = &SpendLimit{Amount: amount.String(), Blocks: blocks, From: block}
+    }
+    limits, err := LookupSpendLimits(db, account, block)
+    if err != nil {
+        return 0, err
+    }
+    if tighter(limit, limits.Current) {
+        limits.Current, limits.Next = limit, nil
+    } else {
+        from := block + limits.Current.Blocks
+        if limit == nil {
+            limit = &SpendLimit{}
+        }
+        limit.From = from
+        limits.Next = limit
+    }
+    effective := block
+    if limits.Next != nil {
+        effective = limits.Next.From
+    }
+    if limits.Current == nil && limits.Next == nil {
+        return effective, db.SaveJSON(key("spendLimit", hex.EncodeToString(account)), nil)
+    }
+    return effective, db.SaveJSON(key("spendLimit", hex.EncodeToString(account)), limits)
+}
+
+// spends returns the recorded spends of an account
+func spends(db *dbservice.DatabaseService, account []byte) ([]windowSpend, error) {
+    var list []windowSpend
+    _, err := db.LoadJSON(key("window", hex.EncodeToString(account)), &list)
+    return list, err
+}
+
+// SpentInWindow returns the native amount account transferred in the window of
+// blocks ending at block
+func SpentInWindow(db *dbservice.DatabaseService, account []byte, block, blocks int64) (*big.Int, error) {
+    list, err := spends(db, account)
+    if err != nil {
+        return nil, err
+    }
+    total := new(big.Int)
+    for _, spend := range list {
+        if spend.Block > block-blocks && spend.Block <= block {
+            amount := dbservice.ParseAmount(spend.Amount)
+            total.Add(total, amount)
+        }
+    }
+    return total, nil
+}
+
+// checkSpendLimit returns ViolationSpendLimit when a native transfer of amount at
+// block would go over the limit in force
+func checkSpendLimit(db *dbservice.DatabaseService, account []byte, amount *big.Int, block int64) (string, error) {
+    limits, err := LookupSpendLimits(db, account, block)
+    if err != nil || limits.Current == nil {
+        return "", err
+    }
+    used, err := SpentInWindow(db, account, block, limits.Current.Blocks)
+    if err != nil {
+        return "", err
+    }
+    limit := dbservice.ParseAmount(limits.Current.Amount)
+    if used.Add(used, amount).Cmp(limit) > 0 {
+        return ViolationSpendLimit, nil
+    }
+    return "", nil
+}
+
+// recordWindowSpend adds a native transfer at block to the spends of an account
+// with a spend limit, dropping those older than its longest window
+func recordWindowSpend(db *dbservice.DatabaseService, account []byte, amount *big.Int, block int64) error {
+    limits, err := LookupSpendLimits(db, account, block)
+    if err != nil {
+        return err
+    }
+    blocks := limits.window()
+    if blocks == 0 {
+        return nil
+    }
+    list, err := spends(db, account)
+    if err != nil {
+        return err
+    }
+    kept := list[:0]
+    for _, spend := range list {
+        if spend.Block > block-blocks {
+            kept = append(kept, spend)
+        }
+    }
+    if n := len(kept); n > 0 && kept[n-1].Block == block {
+        total := dbservice.ParseAmount(kept[n-1].Amount)
+        kept[n-1].Amount = total.Add(total, amount).String()
+    } else {
+        kept = append(kept, windowSpend{Block: block, Amount: amount.String()})
+    }
+    return db.SaveJSON(key("window", hex.EncodeToString(account)), kept)
+}
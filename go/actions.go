@@ -0,0 +1,305 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "math/big"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    "pwr-stateful-vida/sdk"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// payloadError rejects a payload for one of its fields, naming the field, why it
+// is invalid and the failure the transaction is counted under
+type payloadError struct {
+    field   string
+    reason  string
+    failure string
+}
+
+func (e *payloadError) Error() string {
+    if e.field == "" {
+        return e.reason
+    }
+    return e.field + ": " + e.reason
+}
+
+// invalidField rejects a payload with failureInvalidPayload
+func invalidField(field, reason string) error {
+    return &payloadError{field: field, reason: reason, failure: failureInvalidPayload}
+}
+
+// invalidAmount rejects a payload with failureInvalidAmount
+func invalidAmount(field, reason string) error {
+    return &payloadError{field: field, reason: reason, failure: failureInvalidAmount}
+}
+
+// number is an integer or amount field given as a decimal string or a JSON number.
+// The text is kept as sent, so it is read exactly, and for an amount with the
+// decimals of its token, once the handler knows the token.
+type number struct {
+    raw interface{}
+}
+
+// UnmarshalJSON accepts a string or a number, leaving the field unset for null
+func (n *number) UnmarshalJSON(data []byte) error {
+    switch {
+    case string(data) == "null":
+        n.raw = nil
+    case data[0] == '"':
+        var text string
+        if err := json.Unmarshal(data, &text); err != nil {
+            return err
+        }
+        n.raw = text
+    case data[0] == '-' || data[0] >= '0' && data[0] <= '9':
+        n.raw = json.Number(data)
+    default:
+        kind := map[byte]string{'{': "object", '[': "array", 't': "bool", 'f': "bool"}[data[0]]
+        return &json.UnmarshalTypeError{Value: kind, Type: reflect.TypeOf(n).Elem()}
+    }
+    return nil
+}
+
+// MarshalJSON writes the number as it was sent
+func (n number) MarshalJSON() ([]byte, error) {
+    return json.Marshal(n.raw)
+}
+
+func (n number) String() string {
+    switch v := n.raw.(type) {
+    case string:
+        return v
+    case json.Number:
+        return v.String()
+    }
+    return ""
+}
+
+// set reports whether the field was given
+func (n number) set() bool {
+    return n.raw != nil
+}
+
+// integer reads the number as an integer
+func (n number) integer() (*big.Int, bool) {
+    return sdk.Integer(n.raw)
+}
+
+// envelope holds the fields any payload may carry besides those of its action
+type envelope struct {
+    Action string `json:"action"`
+    // ValidUntilBlock is the last block the transaction may be included in
+    ValidUntilBlock number `json:"validUntilBlock"`
+    // OnBehalfOf is the account a controller acts for
+    OnBehalfOf *string `json:"onBehalfOf"`
+
+    validUntil int64
+    account    []byte
+    // text is the payload as sent, which is what logs show
+    text string
+}
+
+func (e *envelope) common() *envelope {
+    return e
+}
+
+// validate accepts the payload of an action without fields to check
+func (e *envelope) validate(a *App) error {
+    return nil
+}
+
+func (e *envelope) String() string {
+    return e.text
+}
+
+// check validates the fields of the envelope
+func (e *envelope) check() error {
+    if e.ValidUntilBlock.set() {
+        validUntil, ok := payloadInt(e.ValidUntilBlock)
+        if !ok {
+            return invalidField("validUntilBlock", "must be a block number")
+        }
+        e.validUntil = validUntil
+    }
+    if e.OnBehalfOf != nil {
+        if e.account = payloadAddress(*e.OnBehalfOf); e.account == nil {
+            return invalidField("onBehalfOf", "must be an address")
+        }
+    }
+    return nil
+}
+
+// actionPayload is the payload of an action, a struct embedding the envelope.
+// validate checks what the payload says on its own, leaving to the handler what
+// depends on the state.
+type actionPayload interface {
+    common() *envelope
+    validate(a *App) error
+}
+
+// actionHandler decodes the payloads of an action and applies them
+type actionHandler struct {
+    // label is the metric label of the action, shared by related actions
+    label  string
+    decode func(a *App, data []byte) (actionPayload, error)
+    apply  func(a *App, ctx context.Context, payload actionPayload, transaction rpc.VidaDataTransaction) string
+}
+
+// typedAction returns the handler of an action whose payload decodes into P
+func typedAction[P any, PP interface {
+    *P
+    actionPayload
+}](label string, handle func(*App, context.Context, PP, rpc.VidaDataTransaction) string) *actionHandler {
+    return &actionHandler{
+        label: label,
+        decode: func(a *App, data []byte) (actionPayload, error) {
+            payload := PP(new(P))
+            if err := decodeAction(data, payload); err != nil {
+                return nil, err
+            }
+            if err := payload.common().check(); err != nil {
+                return nil, err
+            }
+            return payload, payload.validate(a)
+        },
+        apply: func(a *App, ctx context.Context, payload actionPayload, transaction rpc.VidaDataTransaction) string {
+            return handle(a, ctx, payload.(PP), transaction)
+        },
+    }
+}
+
+// decodeAction decodes a payload into the struct of its action. A field the action
+// does not have is rejected rather than ignored, and field names must match exactly,
+// since encoding/json would otherwise also match "Amount" to amount and leave the
+// payload to mean whichever of two spellings came last.
+func decodeAction(data []byte, payload actionPayload) error {
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(data, &fields); err != nil {
+        return invalidField("", "must be a JSON object")
+    }
+    known := payloadFields(reflect.TypeOf(payload).Elem())
+    names := make([]string, 0, len(fields))
+    for name := range fields {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if !known[name] {
+            return invalidField(name, "unknown field")
+        }
+    }
+
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    decoder.DisallowUnknownFields()
+    err := decoder.Decode(payload)
+    var typeErr *json.UnmarshalTypeError
+    switch {
+    case err == nil:
+        payload.common().text = string(data)
+        return nil
+    case errors.As(err, &typeErr):
+        field := typeErr.Field
+        if field == "" {
+            field = failingField(payload, fields, names)
+        }
+        return invalidField(field, "must be "+jsonTypeName(typeErr.Type))
+    case strings.HasPrefix(err.Error(), "json: unknown field "):
+        name, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+        return invalidField(name, "unknown field")
+    }
+    return invalidField("", err.Error())
+}
+
+// failingField finds the field a type error came from by decoding each field on its
+// own. encoding/json does not name the field of an error returned by the decoder of
+// a field type, such as that of number.
+func failingField(payload actionPayload, fields map[string]json.RawMessage, names []string) string {
+    for _, name := range names {
+        single, _ := json.Marshal(map[string]json.RawMessage{name: fields[name]})
+        if json.Unmarshal(single, reflect.New(reflect.TypeOf(payload).Elem()).Interface()) != nil {
+            return name
+        }
+    }
+    return ""
+}
+
+// payloadFieldCache holds the field names of each payload struct
+var payloadFieldCache sync.Map
+
+// payloadFields returns the JSON names of the fields of a payload struct, including
+// those of embedded structs
+func payloadFields(t reflect.Type) map[string]bool {
+    if cached, ok := payloadFieldCache.Load(t); ok {
+        return cached.(map[string]bool)
+    }
+    fields := map[string]bool{}
+    for i := 0; i < t.NumField(); i++ {
+        field := t.Field(i)
+        name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+        switch {
+        case field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct:
+            for embedded := range payloadFields(field.Type) {
+                fields[embedded] = true
+            }
+        case field.IsExported() && name != "-":
+            if name == "" {
+                name = field.Name
+            }
+            fields[name] = true
+        }
+    }
+    payloadFieldCache.Store(t, fields)
+    return fields
+}
+
+// jsonTypeName describes the JSON a field of type t accepts
+func jsonTypeName(t reflect.Type) string {
+    if t == reflect.TypeOf(number{}) {
+        return "a decimal string or an integer"
+    }
+    switch t.Kind() {
+    case reflect.Pointer:
+        return jsonTypeName(t.Elem())
+    case reflect.String:
+        return "a string"
+    case reflect.Bool:
+        return "true or false"
+    case reflect.Slice, reflect.Array:
+        return "an array"
+    case reflect.Map, reflect.Struct:
+        return "an object"
+    }
+    return "a number"
+}
+
+// reencode returns JSON with its object keys sorted and whitespace removed, as the
+// nested payloads of proposals and messages are stored
+func reencode(raw json.RawMessage) ([]byte, bool) {
+    decoder := json.NewDecoder(bytes.NewReader(raw))
+    decoder.UseNumber()
+    var value interface{}
+    if err := decoder.Decode(&value); err != nil || value == nil {
+        return nil, false
+    }
+    data, err := json.Marshal(value)
+    return data, err == nil
+}
+
+// payloadFailure logs why a payload was rejected and returns the failure it is
+// counted under
+func payloadFailure(ctx context.Context, transaction rpc.VidaDataTransaction, action string, err error) string {
+    invalid := &payloadError{reason: err.Error(), failure: failureInvalidPayload}
+    errors.As(err, &invalid)
+    syncLogger.WarnContext(ctx, "rejecting invalid payload", "hash", transaction.Hash, "action", action, "field", invalid.field, "reason", invalid.reason)
+    return invalid.failure
+}
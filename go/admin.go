@@ -0,0 +1,92 @@
+package main
+
+import (
+    "errors"
+
+    "pwr-stateful-vida/api"
+    "pwr-stateful-vida/prune"
+    "pwr-stateful-vida/publish"
+)
+
+// errNotSyncing is returned by admin actions before the subscription has started
+var errNotSyncing = errors.New("synchronization has not started")
+
+// adminActions returns the node operations served by the admin API
+func (a *App) adminActions() api.AdminActions {
+    return api.AdminActions{
+        PauseSync:  a.pauseSync,
+        ResumeSync: a.resumeSync,
+        SyncPaused: a.adminPaused.Load,
+        Prune:      startPrune,
+        Revert:     a.revertToCheckpoint,
+        PublishCursor: func() (publish.Cursor, uint64, bool) {
+            if a.publisher == nil {
+                return publish.Cursor{}, 0, false
+            }
+            cursor, pending := a.publisher.Cursor()
+            return cursor, pending, true
+        },
+    }
+}
+
+// pauseSync stops the subscription after the batch in progress
+func (a *App) pauseSync() error {
+    a.adminMutex.Lock()
+    defer a.adminMutex.Unlock()
+    if a.subscription == nil {
+        return errNotSyncing
+    }
+    a.subscription.Pause()
+    a.adminPaused.Store(true)
+    logger.Warn("sync paused through the admin API")
+    return nil
+}
+
+// resumeSync continues a subscription paused with pauseSync
+func (a *App) resumeSync() error {
+    a.adminMutex.Lock()
+    defer a.adminMutex.Unlock()
+    if a.subscription == nil {
+        return errNotSyncing
+    }
+    a.adminPaused.Store(false)
+    if readOnly.Load() {
+        return errors.New("the node is read-only until disk space recovers")
+    }
+    if a.syncHalted() {
+        return errors.New("the node is halted until POST /admin/revert succeeds")
+    }
+    a.subscription.Resume()
+    logger.Info("sync resumed through the admin API")
+    return nil
+}
+
+// startPrune starts a pruning run in the background
+func startPrune() error {
+    if prune.Status().Running {
+        return prune.ErrRunning
+    }
+    go pruneLive()
+    return nil
+}
+
+// revertToCheckpoint discards the changes after the last checkpoint, such as those
+// left by a failed flush, and processes the blocks after it again
+func (a *App) revertToCheckpoint() (int64, error) {
+    a.adminMutex.Lock()
+    defer a.adminMutex.Unlock()
+    if a.subscription == nil {
+        return 0, errNotSyncing
+    }
+
+    a.subscription.Pause()
+    lastCheckedBlock, err := a.syncNode().Discard()
+    if err != nil {
+        return 0, err
+    }
+    if !a.adminPaused.Load() && !readOnly.Load() {
+        a.subscription.Resume()
+    }
+    logger.Warn("reverted to the last checkpoint through the admin API", "block", lastCheckedBlock)
+    return lastCheckedBlock, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "strings"
+
+    "pwr-stateful-vida/airdrop"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons an airdrop or claim is rejected
+const (
+    failureAirdropExists   = "airdrop_exists"
+    failureAirdropNotFound = "airdrop_not_found"
+    failureAlreadyClaimed  = "already_claimed"
+    failureInvalidProof    = "invalid_proof"
+)
+
+// airdropPayload is the payload of an airdrop
+type airdropPayload struct {
+    envelope
+    ID    string `json:"id"`
+    Token string `json:"token"`
+    Total number `json:"total"`
+    // Root is the hex Merkle root of the allocations
+    Root string `json:"root"`
+
+    root []byte
+}
+
+func (p *airdropPayload) validate(a *App) error {
+    root, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(p.Root), "0x"))
+    if err != nil {
+        return invalidField("root", "must be hex")
+    }
+    p.root = root
+    return nil
+}
+
+// claimPayload is the payload of a claim of an airdrop allocation
+type claimPayload struct {
+    envelope
+    ID     string `json:"id"`
+    Amount number `json:"amount"`
+    // Proof is the hex hashes proving the leaf of the allocation
+    Proof []string `json:"proof"`
+
+    amount *big.Int
+    proof  [][]byte
+}
+
+func (p *claimPayload) validate(a *App) error {
+    if p.amount = parsePositiveAmount(p.Amount); p.amount == nil {
+        return invalidField("amount", "must be a positive integer")
+    }
+    if len(p.Proof) > airdrop.MaxProofLength {
+        return invalidField("proof", "too long")
+    }
+    p.proof = make([][]byte, 0, len(p.Proof))
+    for _, hashHex := range p.Proof {
+        hash, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(hashHex), "0x"))
+        if err != nil || len(hash) == 0 {
+            return &payloadError{field: "proof", reason: "must be hex hashes", failure: failureInvalidProof}
+        }
+        p.proof = append(p.proof, hash)
+    }
+    return nil
+}
+
+// handleAirdrop publishes the Merkle "root" of an airdrop of "total" of a token from
+// a governor. It returns the reason the airdrop was rejected, or an empty string on
+// success.
+func (a *App) handleAirdrop(ctx context.Context, p *airdropPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if !a.isGovernor(senderHex) {
+        syncLogger.WarnContext(ctx, "airdrop from a non-governor", "sender", senderHex)
+        return failureUnauthorized
+    }
+    total := a.parseTokenAmount(p.Total, p.Token)
+    if total == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid airdrop", "payload", p)
+        return failureInvalidPayload
+    }
+    if err := airdrop.Publish(a.db, sender, p.ID, p.Token, p.root, total, int64(transaction.BlockNumber)); err != nil {
+        return airdropFailure(ctx, err, "airdrop", p.ID, p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "airdrop published", "id", p.ID, "token", p.Token, "total", total, "sender", senderHex)
+    return ""
+}
+
+// handleClaim pays the sender its allocation of "amount" from an airdrop given the
+// "proof" of the leaf. It returns the reason the claim was rejected, or an empty
+// string on success.
+func (a *App) handleClaim(ctx context.Context, p *claimPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if err := airdrop.ClaimAllocation(a.db, sender, p.ID, p.amount, p.proof, int64(transaction.BlockNumber)); err != nil {
+        return airdropFailure(ctx, err, "claim", p.ID, p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "airdrop claimed", "id", p.ID, "amount", p.amount, "sender", senderHex)
+    return ""
+}
+
+// airdropFailure maps an error of the airdrop package to the reason a transaction
+// is rejected
+func airdropFailure(ctx context.Context, err error, action, id string, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, airdrop.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid airdrop", "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, airdrop.ErrExists):
+        return failureAirdropExists
+    case errors.Is(err, airdrop.ErrNotFound):
+        return failureAirdropNotFound
+    case errors.Is(err, airdrop.ErrClaimed):
+        return failureAlreadyClaimed
+    case errors.Is(err, airdrop.ErrInvalidProof):
+        syncLogger.InfoContext(ctx, "claim failed: invalid proof", "id", id, "sender", senderHex)
+        return failureInvalidProof
+    case errors.Is(err, airdrop.ErrInsufficientFunds), errors.Is(err, airdrop.ErrExhausted):
+        syncLogger.InfoContext(ctx, action+" failed: insufficient funds", "id", id, "sender", senderHex)
+        return failureInsufficientFunds
+    }
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": senderHex, "airdrop": id},
+    })
+    return failureInvalidPayload
+}
@@ -0,0 +1,242 @@
+// Package airdrop pays token allocations out of a published Merkle root. A governor
+// publishes the root of a tree of (address, amount) leaves and locks the total in
+// the airdrop account, and each recipient claims its own allocation with an
+// inclusion proof, so one transaction funds any number of recipients. Airdrops and
+// their claims are part of the Merkle state.
+//
+// A leaf is sha256(0x00 || address || amount as 32 bytes big endian) and a node is
+// sha256(0x01 || lower child || higher child), children ordered bytewise, so a proof
+// is the list of sibling hashes from the leaf up without positions.
+package airdrop
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "regexp"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// Errors of rejected airdrop actions
+var (
+    ErrInvalid           = errors.New("invalid airdrop")
+    ErrExists            = errors.New("airdrop already exists")
+    ErrNotFound          = errors.New("airdrop not found")
+    ErrClaimed           = errors.New("allocation already claimed")
+    ErrInvalidProof      = errors.New("invalid inclusion proof")
+    ErrExhausted         = errors.New("airdrop total exhausted")
+    ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// MaxProofLength is the most hashes a proof can have, enough for 2^64 leaves
+const MaxProofLength = 64
+
+// Address is the account holding the unclaimed totals of airdrops
+var Address = dbservice.ModuleAddress("airdrop")
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Airdrop is a published airdrop and what has been claimed of it
+type Airdrop struct {
+    ID        string `json:"id"`
+    Token     string `json:"token"`
+    Root      string `json:"root"`
+    Total     string `json:"total"`
+    Claimed   string `json:"claimed"`
+    Claims    int64  `json:"claims"`
+    Publisher string `json:"publisher"`
+    Block     int64  `json:"block"`
+}
+
+// Claim is the allocation an account claimed from an airdrop
+type Claim struct {
+    Amount string `json:"amount"`
+    Block  int64  `json:"block"`
+}
+
+// airdropKey returns the tree key of an airdrop
+func airdropKey(id string) []byte {
+    return []byte(dbservice.AirdropPrefix + "drop/" + id)
+}
+
+// claimKey returns the tree key of the claim of an account on an airdrop
+func claimKey(id string, account []byte) []byte {
+    return []byte(dbservice.AirdropPrefix + "claim/" + id + "/" + hex.EncodeToString(account))
+}
+
+// ValidID reports whether id can name an airdrop
+func ValidID(id string) bool {
+    return idPattern.MatchString(id)
+}
+
+// Leaf returns the leaf hash of the allocation of amount to account
+func Leaf(account []byte, amount *big.Int) []byte {
+    data := make([]byte, 1+len(account)+32)
+    copy(data[1:], account)
+    amount.FillBytes(data[1+len(account):])
+    sum := sha256.Sum256(data)
+    return sum[:]
+}
+
+// Node returns the hash of two children, which does not depend on their order
+func Node(a, b []byte) []byte {
+    if bytes.Compare(a, b) > 0 {
+        a, b = b, a
+    }
+    sum := sha256.Sum256(append(append([]byte{1}, a...), b...))
+    return sum[:]
+}
+
+// Verify reports whether proof leads from the leaf of account and amount to root
+func Verify(root, account []byte, amount *big.Int, proof [][]byte) bool {
+    if amount.Sign() <= 0 || amount.BitLen() > 256 || len(proof) > MaxProofLength {
+        return false
+    }
+    hash := Leaf(account, amount)
+    for _, sibling := range proof {
+        hash = Node(hash, sibling)
+    }
+    return bytes.Equal(hash, root)
+}
+
+// Build returns the root of the tree of leaves and the proof of each leaf. An odd
+// node at the end of a level moves up unchanged.
+func Build(leaves [][]byte) ([]byte, [][][]byte) {
+    proofs := make([][][]byte, len(leaves))
+    if len(leaves) == 0 {
+        return nil, proofs
+    }
+    // positions[i] is the index of the node above leaf i on the current level
+    positions := make([]int, len(leaves))
+    for i := range positions {
+        positions[i] = i
+    }
+    level := leaves
+    for len(level) > 1 {
+        next := make([][]byte, 0, (len(level)+1)/2)
+        for i := 0; i < len(level); i += 2 {
+            if i+1 < len(level) {
+                next = append(next, Node(level[i], level[i+1]))
+            } else {
+                next = append(next, level[i])
+            }
+        }
+        for leaf, position := range positions {
+            if sibling := position ^ 1; sibling < len(level) {
+                proofs[leaf] = append(proofs[leaf], level[sibling])
+            }
+            positions[leaf] = position / 2
+        }
+        level = next
+    }
+    return level[0], proofs
+}
+
+// Lookup returns an airdrop, and false when there is none with that ID
+func Lookup(db *dbservice.DatabaseService, id string) (Airdrop, bool, error) {
+    var airdrop Airdrop
+    if !ValidID(id) {
+        return airdrop, false, nil
+    }
+    found, err := db.LoadJSON(airdropKey(id), &airdrop)
+    return airdrop, found, err
+}
+
+// LookupClaim returns the claim of account on an airdrop, and false when it has not
+// claimed
+func LookupClaim(db *dbservice.DatabaseService, id string, account []byte) (Claim, bool, error) {
+    var claim Claim
+    if !ValidID(id) {
+        return claim, false, nil
+    }
+    found, err := db.LoadJSON(claimKey(id, account), &claim)
+    return claim, found, err
+}
+
+// Publish records an airdrop of token under root and moves its total from the
+// publisher to the airdrop account
+func Publish(db *dbservice.DatabaseService, publisher []byte, id, token string, root []byte, total *big.Int, block int64) error {
+    if !ValidID(id) {
+        return fmt.Errorf("%w: the id must be 1 to 64 letters, digits, '.', '_' or '-'", ErrInvalid)
+    }
+    if len(root) != sha256.Size {
+        return fmt.Errorf("%w: the root must be a %d byte hash", ErrInvalid, sha256.Size)
+    }
+    if !tokens.IsNative(token) {
+        if _, found, err := tokens.Lookup(db, token); err != nil || !found {
+            if err != nil {
+                return err
+            }
+            return fmt.Errorf("%w: token %q is not registered", ErrInvalid, token)
+        }
+    }
+    if _, found, err := Lookup(db, id); err != nil || found {
+        if found {
+            return ErrExists
+        }
+        return err
+    }
+    ok, err := tokens.Transfer(db, token, publisher, Address, total)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return ErrInsufficientFunds
+    }
+    return db.SaveJSON(airdropKey(id), Airdrop{
+        ID:        id,
+        Token:     token,
+        Root:      hex.EncodeToString(root),
+        Total:     total.String(),
+        Claimed:   "0",
+        Publisher: hex.EncodeToString(publisher),
+        Block:     block,
+    })
+}
+
+// ClaimAllocation pays account its allocation of amount from an airdrop at block,
+// once its proof checks out against the root
+func ClaimAllocation(db *dbservice.DatabaseService, account []byte, id string, amount *big.Int, proof [][]byte, block int64) error {
+    airdrop, found, err := Lookup(db, id)
+    if err != nil {
+        return err
+    }
+    if !found {
+        return ErrNotFound
+    }
+    if _, claimed, err := LookupClaim(db, id, account); err != nil || claimed {
+        if claimed {
+            return ErrClaimed
+        }
+        return err
+    }
+    root, _ := hex.DecodeString(airdrop.Root)
+    if !Verify(root, account, amount, proof) {
+        return ErrInvalidProof
+    }
+    total := dbservice.ParseAmount(airdrop.Total)
+    claimed := dbservice.ParseAmount(airdrop.Claimed)
+    claimed.Add(claimed, amount)
+    if claimed.Cmp(total) > 0 {
+        return ErrExhausted
+    }
+
+    ok, err := tokens.Transfer(db, airdrop.Token, Address, account, amount)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return ErrExhausted
+    }
+    airdrop.Claimed = claimed.String()
+    airdrop.Claims++
+    if err := db.SaveJSON(claimKey(id, account), Claim{Amount: amount.String(), Block: block}); err != nil {
+        return err
+    }
+    return db.SaveJSON(airdropKey(id), airdrop)
+}
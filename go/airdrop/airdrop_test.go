@@ -0,0 +1,176 @@
+package airdrop
+
+import (
+    "bytes"
+    "errors"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// account returns the address of the nth test account
+func account(n byte) []byte {
+    return bytes.Repeat([]byte{n}, dbservice.AddressLength)
+}
+
+func TestBuildProofsVerify(t *testing.T) {
+    for _, count := range []int{1, 2, 3, 5, 8, 13} {
+        leaves := make([][]byte, count)
+        for i := range leaves {
+            leaves[i] = Leaf(account(byte(i+1)), big.NewInt(int64(10*(i+1))))
+        }
+        root, proofs := Build(leaves)
+
+        for i, proof := range proofs {
+            address, amount := account(byte(i+1)), big.NewInt(int64(10*(i+1)))
+            if !Verify(root, address, amount, proof) {
+                t.Errorf("%d leaves: proof of leaf %d does not verify", count, i)
+            }
+            if Verify(root, address, new(big.Int).Add(amount, big.NewInt(1)), proof) {
+                t.Errorf("%d leaves: proof of leaf %d verifies another amount", count, i)
+            }
+            if Verify(root, account(99), amount, proof) {
+                t.Errorf("%d leaves: proof of leaf %d verifies another account", count, i)
+            }
+        }
+    }
+}
+
+func TestClaimAllocation(t *testing.T) {
+    publisher := byte(9)
+    allocations := []int64{100, 250, 50}
+
+    tests := []struct {
+        name  string
+        total int64
+        // claimed are the accounts that claimed their allocation before
+        claimed  []byte
+        claimant byte
+        // allocation is the account whose leaf and proof are presented
+        allocation  byte
+        amount      int64
+        id          string
+        wantErr     error
+        wantBalance int64
+    }{
+        {name: "own allocation", total: 400, claimant: 1, allocation: 1, amount: 100, id: "launch", wantBalance: 100},
+        {name: "after others", total: 400, claimed: []byte{1, 3}, claimant: 2, allocation: 2, amount: 250, id: "launch", wantBalance: 250},
+        {name: "claimed twice", total: 400, claimed: []byte{2}, claimant: 2, allocation: 2, amount: 250, id: "launch", wantErr: ErrClaimed, wantBalance: 250},
+        {name: "more than allocated", total: 400, claimant: 3, allocation: 3, amount: 51, id: "launch", wantErr: ErrInvalidProof},
+        {name: "allocation of another account", total: 400, claimant: 4, allocation: 1, amount: 100, id: "launch", wantErr: ErrInvalidProof},
+        {name: "unknown airdrop", total: 400, claimant: 1, allocation: 1, amount: 100, id: "other", wantErr: ErrNotFound},
+        {name: "total exhausted", total: 120, claimed: []byte{1}, claimant: 2, allocation: 2, amount: 250, id: "launch", wantErr: ErrExhausted},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := dbservice.New("airdrop")
+            db.SetDir(t.TempDir())
+            defer db.Close()
+            if err := db.SetBalance(account(publisher), big.NewInt(1000)); err != nil {
+                t.Fatal(err)
+            }
+            leaves := make([][]byte, len(allocations))
+            for i, amount := range allocations {
+                leaves[i] = Leaf(account(byte(i+1)), big.NewInt(amount))
+            }
+            root, proofs := Build(leaves)
+            if err := Publish(db, account(publisher), "launch", "", root, big.NewInt(test.total), 5); err != nil {
+                t.Fatal(err)
+            }
+            for _, n := range test.claimed {
+                if err := ClaimAllocation(db, account(n), "launch", big.NewInt(allocations[n-1]), proofs[n-1], 6); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            err := ClaimAllocation(db, account(test.claimant), test.id, big.NewInt(test.amount), proofs[test.allocation-1], 7)
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("ClaimAllocation error = %v, want %v", err, test.wantErr)
+            }
+            balance, err := db.GetBalance(account(test.claimant))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if balance.Int64() != test.wantBalance {
+                t.Errorf("claimant balance = %s, want %d", balance, test.wantBalance)
+            }
+
+            airdrop, _, err := Lookup(db, "launch")
+            if err != nil {
+                t.Fatal(err)
+            }
+            claims, claimed := int64(len(test.claimed)), int64(0)
+            for _, n := range test.claimed {
+                claimed += allocations[n-1]
+            }
+            if test.wantErr == nil {
+                claims, claimed = claims+1, claimed+test.amount
+            }
+            if airdrop.Claims != claims || airdrop.Claimed != big.NewInt(claimed).String() {
+                t.Errorf("airdrop has %d claims of %s, want %d of %d", airdrop.Claims, airdrop.Claimed, claims, claimed)
+            }
+        })
+    }
+}
+
+func TestPublish(t *testing.T) {
+    publisher := account(9)
+    root := Leaf(account(1), big.NewInt(10))
+
+    tests := []struct {
+        name    string
+        id      string
+        token   string
+        root    []byte
+        total   int64
+        wantErr error
+    }{
+        {name: "native token", id: "launch", root: root, total: 500},
+        {name: "whole balance", id: "season-2", token: "native", root: root, total: 1000},
+        {name: "existing ID", id: "first", root: root, total: 10, wantErr: ErrExists},
+        {name: "invalid ID", id: "launch day", root: root, total: 10, wantErr: ErrInvalid},
+        {name: "short root", id: "launch", root: root[:20], total: 10, wantErr: ErrInvalid},
+        {name: "unregistered token", id: "launch", token: "gold", root: root, total: 10, wantErr: ErrInvalid},
+        {name: "total above the balance", id: "launch", root: root, total: 1001, wantErr: ErrInsufficientFunds},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := dbservice.New("airdrop")
+            db.SetDir(t.TempDir())
+            defer db.Close()
+            if err := db.SetBalance(publisher, big.NewInt(1000)); err != nil {
+                t.Fatal(err)
+            }
+            if err := db.SetBalance(account(8), big.NewInt(10)); err != nil {
+                t.Fatal(err)
+            }
+            if err := Publish(db, account(8), "first", "", root, big.NewInt(10), 1); err != nil {
+                t.Fatal(err)
+            }
+
+            err := Publish(db, publisher, test.id, test.token, test.root, big.NewInt(test.total), 2)
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("Publish error = %v, want %v", err, test.wantErr)
+            }
+            locked := int64(0)
+            if test.wantErr == nil {
+                locked = test.total
+            }
+            balance, err := db.GetBalance(publisher)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if balance.Int64() != 1000-locked {
+                t.Errorf("publisher balance = %s, want %d", balance, 1000-locked)
+            }
+            held, err := db.GetBalance(Address)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if held.Int64() != 10+locked {
+                t.Errorf("airdrop account holds %s, want %d", held, 10+locked)
+            }
+        })
+    }
+}
@@ -0,0 +1,134 @@
+// Package alert notifies operators of consensus anomalies through pluggable
+// notifiers, dropping repeats of the same alert within a deduplication window.
+package alert
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "strings"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/logging"
+)
+
+// Severity orders alerts by urgency
+type Severity int
+
+const (
+    Info Severity = iota
+    Warning
+    Critical
+)
+
+func (s Severity) String() string {
+    switch s {
+    case Info:
+        return "info"
+    case Warning:
+        return "warning"
+    default:
+        return "critical"
+    }
+}
+
+// ParseSeverity parses info, warning or critical
+func ParseSeverity(name string) (Severity, error) {
+    switch strings.ToLower(name) {
+    case "info":
+        return Info, nil
+    case "warning":
+        return Warning, nil
+    case "critical":
+        return Critical, nil
+    }
+    return 0, fmt.Errorf("unknown severity %q", name)
+}
+
+// Kinds of alerts raised by the node
+const (
+    KindRootMismatch     = "root_mismatch"
+    KindPeerDisagreement = "peer_disagreement"
+    KindSyncStall        = "sync_stall"
+    KindFlushFailure     = "flush_failure"
+    KindTransactionPanic = "transaction_panic"
+    KindCheckpointPanic  = "checkpoint_panic"
+    KindDiskSpace        = "disk_space"
+    KindFailover         = "failover"
+    KindRevertFailure    = "revert_failure"
+)
+
+// Alert describes an anomaly. Alerts with the same kind and subject are duplicates.
+type Alert struct {
+    Kind     string    `json:"kind"`
+    Severity Severity  `json:"-"`
+    Subject  string    `json:"subject,omitempty"`
+    Message  string    `json:"message"`
+    Block    int64     `json:"block,omitempty"`
+    Time     time.Time `json:"time"`
+}
+
+// key identifies duplicates of an alert
+func (a Alert) key() string {
+    return a.Kind + "\x00" + a.Subject
+}
+
+// Notifier delivers alerts to an operator
+type Notifier interface {
+    Notify(a Alert) error
+}
+
+// Dispatcher sends alerts at or above a minimum severity to its notifiers
+type Dispatcher struct {
+    notifiers   []Notifier
+    minSeverity Severity
+    dedupWindow time.Duration
+
+    mutex sync.Mutex
+    sent  map[string]time.Time
+}
+
+// NewDispatcher returns a dispatcher. A zero dedupWindow sends every alert.
+func NewDispatcher(notifiers []Notifier, minSeverity Severity, dedupWindow time.Duration) *Dispatcher {
+    return &Dispatcher{notifiers: notifiers, minSeverity: minSeverity, dedupWindow: dedupWindow, sent: make(map[string]time.Time)}
+}
+
+var logger = logging.For("alert")
+
+// Raise sends an alert to every notifier in the background unless it is below the
+// minimum severity or a duplicate was sent within the deduplication window. It
+// reports whether the alert was sent.
+func (d *Dispatcher) Raise(a Alert) bool {
+    if a.Time.IsZero() {
+        a.Time = time.Now()
+    }
+    level := slog.LevelInfo
+    switch a.Severity {
+    case Warning:
+        level = slog.LevelWarn
+    case Critical:
+        level = slog.LevelError
+    }
+    logger.Log(context.Background(), level, a.Message, "alert", a.Kind, "severity", a.Severity.String(), "subject", a.Subject, "block", a.Block)
+    if d == nil || a.Severity < d.minSeverity {
+        return false
+    }
+
+    d.mutex.Lock()
+    if last, ok := d.sent[a.key()]; ok && a.Time.Sub(last) < d.dedupWindow {
+        d.mutex.Unlock()
+        return false
+    }
+    d.sent[a.key()] = a.Time
+    d.mutex.Unlock()
+
+    for _, notifier := range d.notifiers {
+        go func(notifier Notifier) {
+            if err := notifier.Notify(a); err != nil {
+                logger.Error("failed to deliver alert", "kind", a.Kind, "notifier", fmt.Sprintf("%T", notifier), "error", err)
+            }
+        }(notifier)
+    }
+    return true
+}
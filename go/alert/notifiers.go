@@ -0,0 +1,99 @@
+package alert
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/smtp"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// payload is the JSON document sent by the webhook and command notifiers
+func payload(a Alert) []byte {
+    data, _ := json.Marshal(struct {
+        Alert
+        Severity string `json:"severity"`
+    }{a, a.Severity.String()})
+    return data
+}
+
+// Webhook posts alerts as JSON to a URL
+type Webhook struct {
+    URL string
+}
+
+// Notify posts the alert, failing on any non-2xx response
+func (w Webhook) Notify(a Alert) error {
+    client := &http.Client{Timeout: 10 * time.Second}
+    resp, err := client.Post(w.URL, "application/json", bytes.NewReader(payload(a)))
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("webhook %s returned HTTP %d", w.URL, resp.StatusCode)
+    }
+    return nil
+}
+
+// Email sends alerts through an SMTP server
+type Email struct {
+    Addr     string
+    From     string
+    To       []string
+    Username string
+    Password string
+}
+
+// Notify sends a plain text message describing the alert
+func (e Email) Notify(a Alert) error {
+    var auth smtp.Auth
+    if e.Username != "" {
+        host, _, _ := strings.Cut(e.Addr, ":")
+        auth = smtp.PlainAuth("", e.Username, e.Password, host)
+    }
+
+    var message bytes.Buffer
+    fmt.Fprintf(&message, "From: %s\r\nTo: %s\r\n", e.From, strings.Join(e.To, ", "))
+    fmt.Fprintf(&message, "Subject: [%s] VIDA node %s\r\n\r\n", a.Severity, a.Kind)
+    fmt.Fprintf(&message, "%s\r\n\r\nTime: %s\r\n", a.Message, a.Time.Format(time.RFC3339))
+    if a.Block > 0 {
+        fmt.Fprintf(&message, "Block: %d\r\n", a.Block)
+    }
+    return smtp.SendMail(e.Addr, auth, e.From, e.To, message.Bytes())
+}
+
+// commandTimeout bounds how long an alert command may run
+const commandTimeout = 30 * time.Second
+
+// Command runs a program for every alert, passing the alert as JSON on standard
+// input and its fields in VIDA_ALERT_* environment variables
+type Command struct {
+    Path string
+    Args []string
+}
+
+// Notify runs the command and fails if it exits with an error
+func (c Command) Notify(a Alert) error {
+    ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+    defer cancel()
+    cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+    cmd.Stdin = bytes.NewReader(payload(a))
+    cmd.Env = append(os.Environ(),
+        "VIDA_ALERT_KIND="+a.Kind,
+        "VIDA_ALERT_SEVERITY="+a.Severity.String(),
+        "VIDA_ALERT_SUBJECT="+a.Subject,
+        "VIDA_ALERT_MESSAGE="+a.Message,
+        "VIDA_ALERT_BLOCK="+strconv.FormatInt(a.Block, 10),
+    )
+    if output, err := cmd.CombinedOutput(); err != nil {
+        return fmt.Errorf("%s: %v: %s", c.Path, err, bytes.TrimSpace(output))
+    }
+    return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "pwr-stateful-vida/alert"
+    "pwr-stateful-vida/health"
+)
+
+// alerts delivers anomaly notifications; nil only logs them
+var alerts *alert.Dispatcher
+
+// setupAlerts builds the alert dispatcher from the configuration and starts the sync stall watchdog
+func (a *App) setupAlerts() error {
+    cfg := a.config().Alerts
+    minSeverity, err := alert.ParseSeverity(cfg.MinSeverity)
+    if err != nil {
+        return err
+    }
+    var dedupWindow time.Duration
+    if cfg.DedupWindow != "" {
+        if dedupWindow, err = time.ParseDuration(cfg.DedupWindow); err != nil {
+            return err
+        }
+    }
+
+    var notifiers []alert.Notifier
+    for _, url := range cfg.Webhooks {
+        notifiers = append(notifiers, alert.Webhook{URL: url})
+    }
+    for _, command := range cfg.Commands {
+        notifiers = append(notifiers, alert.Command{Path: command[0], Args: command[1:]})
+    }
+    if email := cfg.Email; email.SMTPAddr != "" {
+        notifiers = append(notifiers, alert.Email{Addr: email.SMTPAddr, From: email.From, To: email.To, Username: email.Username, Password: email.Password})
+    }
+    alerts = alert.NewDispatcher(notifiers, minSeverity, dedupWindow)
+
+    if cfg.SyncStall != "" {
+        stall, err := time.ParseDuration(cfg.SyncStall)
+        if err != nil {
+            return err
+        }
+        go a.watchSyncStall(stall)
+    }
+    return nil
+}
+
+// recordPeerAgreement tracks whether a peer agreed with the local root and alerts once
+// it has disagreed on the configured number of consecutive blocks
+func (a *App) recordPeerAgreement(peer string, agreed bool, blockNumber int) {
+    health.RecordPeer(peer, agreed)
+    if agreed {
+        delete(a.peerDisagreements, peer)
+        return
+    }
+    if a.peerDisagreements == nil {
+        a.peerDisagreements = make(map[string]int)
+    }
+    a.peerDisagreements[peer]++
+    if count := a.peerDisagreements[peer]; count >= a.config().Alerts.PeerDisagreements {
+        alerts.Raise(alert.Alert{
+            Kind:     alert.KindPeerDisagreement,
+            Severity: alert.Warning,
+            Subject:  peer,
+            Message:  fmt.Sprintf("peer %s disagreed with the local root hash on %d consecutive blocks", peer, count),
+            Block:    int64(blockNumber),
+        })
+    }
+}
+
+// watchSyncStall raises an alert whenever no checkpoint was processed for the given duration
+func (a *App) watchSyncStall(stall time.Duration) {
+    ticker := time.NewTicker(max(stall/4, time.Second))
+    defer ticker.Stop()
+
+    for range ticker.C {
+        idle := time.Since(health.LastProgress())
+        if idle < stall {
+            continue
+        }
+        lastBlock, _ := a.db.GetLastCheckedBlock()
+        alerts.Raise(alert.Alert{
+            Kind:     alert.KindSyncStall,
+            Severity: alert.Critical,
+            Message:  fmt.Sprintf("no block processed for %s, last checked block is %d", idle.Round(time.Second), lastBlock),
+            Block:    lastBlock,
+        })
+    }
+}
+
+// rootMismatchAlert describes a batch reverted for lack of a peer quorum
+func rootMismatchAlert(blockNumber, matches int, peers []string) alert.Alert {
+    return alert.Alert{
+        Kind:     alert.KindRootMismatch,
+        Severity: alert.Critical,
+        Message:  fmt.Sprintf("root hash for block %d reached only %d/%d peers (%s), batch reverted", blockNumber, matches, len(peers), strings.Join(peers, ", ")),
+        Block:    int64(blockNumber),
+    }
+}
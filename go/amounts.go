@@ -0,0 +1,41 @@
+package main
+
+import (
+    "math/big"
+    "strings"
+
+    "pwr-stateful-vida/sdk"
+    "pwr-stateful-vida/tokens"
+)
+
+// tokenDecimals returns the decimals of a token: those of the genesis params for the
+// native token, or those of a registered token
+func (a *App) tokenDecimals(token string) (int, bool) {
+    if tokens.IsNative(token) {
+        return a.chainParams().Payload.NativeDecimals, true
+    }
+    metadata, found, err := tokens.Lookup(a.db, token)
+    return metadata.Decimals, found && err == nil
+}
+
+// payloadAmount reads an amount of a token: an integer in base units, or with
+// payload.decimalAmounts set a decimal string in whole tokens such as "12.5"
+func (a *App) payloadAmount(raw number, token string) (*big.Int, bool) {
+    if text, ok := raw.raw.(string); ok && strings.Contains(text, ".") && a.chainParams().Payload.DecimalAmounts {
+        decimals, ok := a.tokenDecimals(token)
+        if !ok {
+            return nil, false
+        }
+        return sdk.DecimalAmount(text, decimals)
+    }
+    return raw.integer()
+}
+
+// parseTokenAmount reads a positive amount of a token, returning nil when it is not
+func (a *App) parseTokenAmount(raw number, token string) *big.Int {
+    amount, ok := a.payloadAmount(raw, token)
+    if !ok || amount.Sign() <= 0 {
+        return nil
+    }
+    return amount
+}
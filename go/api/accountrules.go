@@ -0,0 +1,67 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/accountrules"
+    "pwr-stateful-vida/dbservice"
+)
+
+// accountRulesState is the response body of /accountRules
+type accountRulesState struct {
+    Address    string                 `json:"address"`
+    Rules      accountrules.Rules     `json:"rules"`
+    SpentToday string                 `json:"spentToday"`
+    Pending    []accountrules.Pending `json:"pending"`
+    // SpendLimit is the rolling limit, with SpentInWindow counted against it
+    SpendLimit    accountrules.SpendLimits `json:"spendLimit"`
+    SpentInWindow string                   `json:"spentInWindow,omitempty"`
+}
+
+// registerAccountRulesRoutes registers /accountRules
+func registerAccountRulesRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/accountRules", func(c *gin.Context) {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Query("address")), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        rules, err := accountrules.Get(db, address)
+        if err != nil {
+            internalError(c, "Failed to read account rules", err)
+            return
+        }
+        genesis, ok := genesisParams(db, c)
+        if !ok {
+            return
+        }
+        lastCheckedBlock, _ := db.GetLastCheckedBlockContext(c.Request.Context())
+        spent, err := accountrules.SpentToday(db, address, lastCheckedBlock, genesis.AccountRules.BlocksPerDay)
+        if err != nil {
+            internalError(c, "Failed to read account rules", err)
+            return
+        }
+        pending, err := accountrules.PendingOf(db, address)
+        if err != nil {
+            internalError(c, "Failed to read account rules", err)
+            return
+        }
+        state := accountRulesState{Address: hex.EncodeToString(address), Rules: rules, SpentToday: spent.String(), Pending: pending}
+        if state.SpendLimit, err = accountrules.LookupSpendLimits(db, address, lastCheckedBlock); err != nil {
+            internalError(c, "Failed to read account rules", err)
+            return
+        }
+        if limit := state.SpendLimit.Current; limit != nil {
+            inWindow, err := accountrules.SpentInWindow(db, address, lastCheckedBlock, limit.Blocks)
+            if err != nil {
+                internalError(c, "Failed to read account rules", err)
+                return
+            }
+            state.SpentInWindow = inWindow.String()
+        }
+        c.JSON(http.StatusOK, state)
+    })
+}
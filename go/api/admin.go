@@ -0,0 +1,112 @@
+package api
+
+import (
+    "encoding/hex"
+    "errors"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/health"
+    "pwr-stateful-vida/prune"
+    "pwr-stateful-vida/publish"
+)
+
+// AdminActions are the node operations exposed to operators and admins. The node
+// provides them because they act on its subscription.
+type AdminActions struct {
+    // PauseSync stops applying new blocks, ResumeSync continues
+    PauseSync  func() error
+    ResumeSync func() error
+    // SyncPaused reports whether an operator paused syncing
+    SyncPaused func() bool
+    // Prune starts a pruning run in the background
+    Prune func() error
+    // Revert discards the changes after the last checkpoint and resumes from it,
+    // returning the checkpoint block
+    Revert func() (int64, error)
+    // PublishCursor returns the last event the broker acknowledged and the number of
+    // events waiting for it, false when publishing is disabled
+    PublishCursor func() (publish.Cursor, uint64, bool)
+}
+
+// nodeStatus is the response of /status
+type nodeStatus struct {
+    LastCheckedBlock int64   `json:"lastCheckedBlock"`
+    RootHash         string  `json:"rootHash"`
+    HealthScore      float64 `json:"healthScore"`
+    Ready            bool    `json:"ready"`
+    ReadOnly         bool    `json:"readOnly"`
+    SyncPaused       bool    `json:"syncPaused"`
+}
+
+// RegisterAdminRoutes registers /status for readers and the /admin operations for
+// operators and admins
+func RegisterAdminRoutes(db *dbservice.DatabaseService, router *gin.Engine, actions AdminActions) {
+    routes := router.Group("/", authenticate())
+
+    routes.GET("/status", Require(RoleReader), func(c *gin.Context) {
+        lastCheckedBlock, rootHash, _ := db.CheckpointRootHashContext(c.Request.Context())
+        report := health.Evaluate()
+        c.JSON(http.StatusOK, nodeStatus{
+            LastCheckedBlock: lastCheckedBlock,
+            RootHash:         hex.EncodeToString(rootHash),
+            HealthScore:      report.Score,
+            Ready:            report.Ready,
+            ReadOnly:         report.ReadOnly,
+            SyncPaused:       actions.SyncPaused(),
+        })
+    })
+
+    operator := routes.Group("/admin", Require(RoleOperator))
+    operator.POST("/sync/pause", func(c *gin.Context) {
+        if err := actions.PauseSync(); err != nil {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"syncPaused": true})
+    })
+    operator.POST("/sync/resume", func(c *gin.Context) {
+        if err := actions.ResumeSync(); err != nil {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"syncPaused": false})
+    })
+    operator.POST("/prune", func(c *gin.Context) {
+        err := actions.Prune()
+        if errors.Is(err, prune.ErrRunning) {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        }
+        if err != nil {
+            internalError(c, "Failed to start pruning", err)
+            return
+        }
+        c.JSON(http.StatusAccepted, prune.Status())
+    })
+    operator.GET("/publish", func(c *gin.Context) {
+        cursor, pending, ok := actions.PublishCursor()
+        if !ok {
+            c.String(http.StatusNotFound, "Publishing is disabled")
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"cursor": cursor, "pending": pending})
+    })
+
+    // An auditor only follows the chain and a replica its primary, their state is
+    // never rewritten by hand
+    if config.Get().Auditor.Enabled || config.Get().Replica.Primary != "" {
+        return
+    }
+    admin := routes.Group("/admin", Require(RoleAdmin))
+    admin.POST("/revert", func(c *gin.Context) {
+        checkpoint, err := actions.Revert()
+        if err != nil {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"lastCheckedBlock": checkpoint})
+    })
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/airdrop"
+    "pwr-stateful-vida/dbservice"
+)
+
+// registerAirdropRoutes registers the airdrops and their claims
+func registerAirdropRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/airdrop/:id", func(c *gin.Context) {
+        id := c.Param("id")
+        if !airdrop.ValidID(id) {
+            c.String(http.StatusBadRequest, "Invalid airdrop ID")
+            return
+        }
+        drop, ok, err := airdrop.Lookup(db, id)
+        if err != nil {
+            internalError(c, "Failed to read airdrop", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "Airdrop not found: "+id)
+            return
+        }
+        c.JSON(http.StatusOK, drop)
+    })
+
+    routes.GET("/airdrop/:id/claim/:address", func(c *gin.Context) {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Param("address")), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        claim, ok, err := airdrop.LookupClaim(db, c.Param("id"), address)
+        if err != nil {
+            internalError(c, "Failed to read claim", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "No claim by this address")
+            return
+        }
+        c.JSON(http.StatusOK, claim)
+    })
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/config"
+)
+
+// Role is the access level of an API client. Each role includes the permissions of
+// the roles below it.
+type Role int
+
+// Roles from least to most privileged
+const (
+    RoleNone Role = iota
+    RoleReader
+    RoleOperator
+    RoleAdmin
+)
+
+// roleNames are the names used in the configuration and JWT claims
+var roleNames = map[Role]string{RoleNone: "none", RoleReader: "reader", RoleOperator: "operator", RoleAdmin: "admin"}
+
+func (r Role) String() string {
+    return roleNames[r]
+}
+
+// ParseRole parses reader, operator or admin
+func ParseRole(name string) (Role, error) {
+    for role, roleName := range roleNames {
+        if role != RoleNone && strings.EqualFold(name, roleName) {
+            return role, nil
+        }
+    }
+    return RoleNone, fmt.Errorf("unknown role %q", name)
+}
+
+// roleKey is the gin context key of the authenticated role
+const roleKey = "role"
+
+// authenticate resolves the role of each request from its bearer credentials: an API
+// key from the configuration or an HS256 JWT with a role claim. Requests without
+// credentials get the anonymous role.
+func authenticate() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        role, err := Authorize(c.GetHeader("Authorization"))
+        if err != nil {
+            c.Header("WWW-Authenticate", `Bearer realm="vida"`)
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+            return
+        }
+        c.Set(roleKey, role)
+        c.Next()
+    }
+}
+
+// Authorize returns the role of an Authorization header value, which the gRPC API
+// shares with the HTTP API: the role of a bearer API key or JWT, or the anonymous
+// role without credentials
+func Authorize(authorization string) (Role, error) {
+    cfg := config.Get().Auth
+    token, found := strings.CutPrefix(authorization, "Bearer ")
+    if !found || token == "" {
+        role, _ := ParseRole(cfg.AnonymousRole)
+        return role, nil
+    }
+    return roleForToken(cfg, token)
+}
+
+// Require rejects requests whose role is below role
+func Require(role Role) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        value, _ := c.Get(roleKey)
+        granted, _ := value.(Role)
+        if granted >= role {
+            c.Next()
+            return
+        }
+        if granted == RoleNone {
+            c.Header("WWW-Authenticate", `Bearer realm="vida"`)
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "credentials required"})
+            return
+        }
+        c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("role %s required, have %s", role, granted)})
+    }
+}
+
+// roleForToken returns the role of an API key or JWT
+func roleForToken(cfg config.AuthConfig, token string) (Role, error) {
+    for key, name := range cfg.APIKeys {
+        if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+            return ParseRole(name)
+        }
+    }
+    if cfg.JWTSecret != "" && strings.Count(token, ".") == 2 {
+        return roleForJWT(token, []byte(cfg.JWTSecret))
+    }
+    return RoleNone, errors.New("invalid credentials")
+}
+
+// jwtClaims are the claims read from a JWT
+type jwtClaims struct {
+    Role      string `json:"role"`
+    ExpiresAt int64  `json:"exp"`
+    NotBefore int64  `json:"nbf"`
+}
+
+// roleForJWT verifies an HS256 JWT and returns the role in its claims
+func roleForJWT(token string, secret []byte) (Role, error) {
+    parts := strings.Split(token, ".")
+    var header struct {
+        Algorithm string `json:"alg"`
+    }
+    if err := decodeSegment(parts[0], &header); err != nil || header.Algorithm != "HS256" {
+        return RoleNone, errors.New("unsupported token, expected an HS256 JWT")
+    }
+
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(parts[0] + "." + parts[1]))
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+        return RoleNone, errors.New("invalid token signature")
+    }
+
+    var claims jwtClaims
+    if err := decodeSegment(parts[1], &claims); err != nil {
+        return RoleNone, errors.New("invalid token claims")
+    }
+    now := time.Now().Unix()
+    if claims.ExpiresAt != 0 && now >= claims.ExpiresAt {
+        return RoleNone, errors.New("token expired")
+    }
+    if claims.NotBefore != 0 && now < claims.NotBefore {
+        return RoleNone, errors.New("token not valid yet")
+    }
+    return ParseRole(claims.Role)
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+    data, err := base64.RawURLEncoding.DecodeString(segment)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/snapshot"
+)
+
+// errNotRecorded is returned when neither a snapshot nor the archive hold the state
+// of a block
+var errNotRecorded = errors.New("state not recorded")
+
+// balanceAtResponse is the response body of /balanceAt. The state of a block is
+// the state of the last checkpoint at or before it, since the node commits batches
+// of blocks; Checkpoint is that block and RootHash its validated root hash.
+type balanceAtResponse struct {
+    Address     string `json:"address"`
+    Balance     string `json:"balance"`
+    BlockNumber int64  `json:"blockNumber"`
+    Checkpoint  int64  `json:"checkpoint"`
+    RootHash    string `json:"rootHash"`
+}
+
+// balanceAt returns the balance of address as of blockNumber, read from the newest
+// snapshot at or before the block and carried forward with the archived changes of
+// the checkpoints after it. Each change must start from the value carried so far,
+// so a gap in the archive is an error rather than a wrong balance.
+func balanceAt(db *dbservice.DatabaseService, address []byte, blockNumber int64) (*balanceAtResponse, error) {
+    base, err := snapshot.Latest(config.Get().SnapshotDir, blockNumber)
+    if err != nil {
+        return nil, fmt.Errorf("%w: no snapshot at or before block %d", errNotRecorded, blockNumber)
+    }
+    file, err := dbfile.Open(base.Path, true)
+    if err != nil {
+        return nil, err
+    }
+    value, err := file.Get(address)
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    rootHash, err := file.Get(db.BlockRootHashKey(base.BlockNumber))
+    file.Close()
+    if err != nil {
+        return nil, err
+    }
+    response := &balanceAtResponse{
+        Address:     hex.EncodeToString(address),
+        BlockNumber: blockNumber,
+        Checkpoint:  base.BlockNumber,
+        RootHash:    hex.EncodeToString(rootHash),
+    }
+
+    if blockNumber > base.BlockNumber {
+        dir := config.Get().ArchiveDir
+        if dir == "" {
+            return nil, fmt.Errorf("%w: the blocks after snapshot %d are not archived", errNotRecorded, base.BlockNumber)
+        }
+        records, err := archive.Between(dir, base.BlockNumber-1, blockNumber)
+        if err != nil && !os.IsNotExist(err) {
+            return nil, err
+        }
+        // Without a record of the snapshot block the archive may start after it
+        if len(records) == 0 || records[0].BlockNumber != base.BlockNumber {
+            return nil, fmt.Errorf("%w: the archive does not reach back to snapshot %d", errNotRecorded, base.BlockNumber)
+        }
+
+        key := hex.EncodeToString(address)
+        current := hex.EncodeToString(value)
+        for _, record := range records[1:] {
+            for _, change := range record.Changes {
+                if change.Key != key {
+                    continue
+                }
+                if change.Old != current {
+                    return nil, fmt.Errorf("archive record of block %d changes %s from %q, not %q", record.BlockNumber, key, change.Old, current)
+                }
+                current = change.New
+            }
+            response.Checkpoint, response.RootHash = record.BlockNumber, record.RootHash
+        }
+        if value, err = hex.DecodeString(current); err != nil {
+            return nil, err
+        }
+    }
+
+    response.Balance = new(big.Int).SetBytes(value).String()
+    return response, nil
+}
+
+// getBalanceAt serves the balance of an address in db as of a past block
+func getBalanceAt(db *dbservice.DatabaseService) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Query("address")), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        blockNumber, err := strconv.ParseInt(c.Query("blockNumber"), 10, 64)
+        lastCheckedBlock, _ := db.GetLastCheckedBlockContext(c.Request.Context())
+        if err != nil || blockNumber < 1 || blockNumber > lastCheckedBlock {
+            c.String(http.StatusBadRequest, "Invalid block number")
+            return
+        }
+        // A snapshot file holds one shard only
+        if config.Get().Shards > 1 {
+            c.String(http.StatusNotFound, "Historical balances are not available for a sharded state")
+            return
+        }
+
+        response, err := balanceAt(db, address, blockNumber)
+        if errors.Is(err, errNotRecorded) {
+            c.String(http.StatusNotFound, "Balance not available: "+err.Error())
+            return
+        }
+        if err != nil {
+            internalError(c, "Failed to read historical balance", err)
+            return
+        }
+        body, _ := json.Marshal(response)
+        writeSigned(c, applicationJSON, body, true)
+    }
+}
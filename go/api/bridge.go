@@ -0,0 +1,59 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/bridge"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// registerBridgeRoutes registers the bridged supplies, deposits and withdrawals
+func registerBridgeRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/bridge/supply/:token", func(c *gin.Context) {
+        token := c.Param("token")
+        if !tokens.ValidID(token) {
+            c.String(http.StatusBadRequest, "Invalid token ID")
+            return
+        }
+        supply, err := bridge.SupplyOf(db, token)
+        if err != nil {
+            internalError(c, "Failed to read bridged supply", err)
+            return
+        }
+        c.JSON(http.StatusOK, supply)
+    })
+
+    routes.GET("/bridge/deposit/:ref", func(c *gin.Context) {
+        ref := c.Param("ref")
+        if !bridge.ValidRef(ref) {
+            c.String(http.StatusBadRequest, "Invalid external transaction")
+            return
+        }
+        deposit, ok, err := bridge.LookupDeposit(db, ref)
+        if err != nil {
+            internalError(c, "Failed to read deposit", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "Deposit not found: "+ref)
+            return
+        }
+        c.JSON(http.StatusOK, deposit)
+    })
+
+    routes.GET("/bridge/withdrawal/:hash", func(c *gin.Context) {
+        hash := c.Param("hash")
+        withdrawal, ok, err := bridge.LookupWithdrawal(db, hash)
+        if err != nil {
+            internalError(c, "Failed to read withdrawal", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "Withdrawal not found: "+hash)
+            return
+        }
+        c.JSON(http.StatusOK, withdrawal)
+    })
+}
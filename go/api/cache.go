@@ -0,0 +1,53 @@
+package api
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    // finalizedCacheControl is sent for root hashes of blocks before the checkpoint
+    finalizedCacheControl = "public, max-age=86400"
+    // latestCacheControl is sent for responses that change when the checkpoint advances
+    latestCacheControl = "no-cache"
+)
+
+// etagFor returns a strong ETag for the given response body
+func etagFor(body []byte) string {
+    sum := sha256.Sum256(body)
+    return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header matches the ETag
+func etagMatches(header, etag string) bool {
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+        if candidate == etag || candidate == "*" {
+            return true
+        }
+    }
+    return false
+}
+
+// writeCached writes body with ETag and Cache-Control headers, answering
+// 304 Not Modified when the client already holds the same representation
+func writeCached(c *gin.Context, contentType string, body []byte, finalized bool) {
+    etag := etagFor(body)
+    c.Header("ETag", etag)
+    if finalized {
+        c.Header("Cache-Control", finalizedCacheControl)
+    } else {
+        c.Header("Cache-Control", latestCacheControl)
+    }
+
+    if header := c.GetHeader("If-None-Match"); header != "" && etagMatches(header, etag) {
+        c.Status(http.StatusNotModified)
+        return
+    }
+
+    c.Data(http.StatusOK, contentType, body)
+}
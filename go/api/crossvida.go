@@ -0,0 +1,38 @@
+package api
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/crossvida"
+    "pwr-stateful-vida/dbservice"
+)
+
+// registerCrossVidaRoutes registers the cross-VIDA outboxes and inboxes
+func registerCrossVidaRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    // The outbox holds the messages for a target VIDA, the inbox those from a source
+    for _, box := range []string{"outbox", "inbox"} {
+        box := box
+        routes.GET("/crossVida/"+box+"/:vida", func(c *gin.Context) {
+            vida, err := strconv.ParseInt(c.Param("vida"), 10, 64)
+            if err != nil || vida <= 0 {
+                c.String(http.StatusBadRequest, "Invalid VIDA ID")
+                return
+            }
+            var after uint64
+            if raw := c.Query("after"); raw != "" {
+                if after, err = strconv.ParseUint(raw, 10, 64); err != nil {
+                    c.String(http.StatusBadRequest, "Invalid sequence")
+                    return
+                }
+            }
+            messages, err := crossvida.List(db, box, vida, after, parseLimit(c))
+            if err != nil {
+                internalError(c, "Failed to read cross-VIDA messages", err)
+                return
+            }
+            c.JSON(http.StatusOK, gin.H{"messages": messages})
+        })
+    }
+}
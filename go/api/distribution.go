@@ -0,0 +1,73 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/distribution"
+)
+
+// registerDistributionRoutes registers the balance snapshots and the dividends paid
+// out of them
+func registerDistributionRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/snapshot/:name", func(c *gin.Context) {
+        name := c.Param("name")
+        if !distribution.ValidName(name) {
+            c.String(http.StatusBadRequest, "Invalid snapshot name")
+            return
+        }
+        snapshot, ok, err := distribution.Lookup(db, name)
+        if err != nil {
+            internalError(c, "Failed to read snapshot", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "Snapshot not found: "+name)
+            return
+        }
+        c.JSON(http.StatusOK, snapshot)
+    })
+
+    // A dividend, with what an address is paid by it when one is given
+    routes.GET("/dividends/:id", func(c *gin.Context) {
+        id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+        if err != nil {
+            c.String(http.StatusBadRequest, "Invalid dividend ID")
+            return
+        }
+        dividend, ok, err := distribution.LookupDividend(db, id)
+        if err != nil {
+            internalError(c, "Failed to read dividend", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "No dividend with this ID")
+            return
+        }
+        response := gin.H{"dividend": dividend}
+        if raw := c.Query("address"); raw != "" {
+            address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(raw), "0x"))
+            if err != nil || len(address) != dbservice.AddressLength {
+                c.String(http.StatusBadRequest, "Invalid address")
+                return
+            }
+            snapshot, _, err := distribution.Lookup(db, dividend.Snapshot)
+            if err != nil {
+                internalError(c, "Failed to read snapshot", err)
+                return
+            }
+            claimed, err := distribution.Claimed(db, id, address)
+            if err != nil {
+                internalError(c, "Failed to read dividend claim", err)
+                return
+            }
+            response["payout"] = dividend.Payout(distribution.HolderBalance(snapshot, address)).String()
+            response["claimed"] = claimed
+        }
+        c.JSON(http.StatusOK, response)
+    })
+}
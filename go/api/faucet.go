@@ -0,0 +1,108 @@
+package api
+
+import (
+    "encoding/hex"
+    "math/big"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+)
+
+// FaucetSender submits a transfer of amount from the faucet wallet to receiver and
+// returns the hash of the VIDA transaction
+type FaucetSender interface {
+    Address() []byte
+    Send(receiver []byte, amount *big.Int) (string, error)
+}
+
+// faucetRequest is the request body of POST /faucet
+type faucetRequest struct {
+    Address string `json:"address"`
+}
+
+// faucetCooldowns holds when each address last received from the faucet
+type faucetCooldowns struct {
+    mutex sync.Mutex
+    last  map[string]time.Time
+}
+
+// reserve records a request for address, returning how long it has to wait when
+// the previous one is less than cooldown ago
+func (f *faucetCooldowns) reserve(address string, cooldown time.Duration, now time.Time) (bool, time.Duration) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if last, ok := f.last[address]; ok && now.Sub(last) < cooldown {
+        return false, cooldown - now.Sub(last)
+    }
+    if len(f.last) >= maxPeerBuckets {
+        for other, last := range f.last {
+            if now.Sub(last) >= cooldown {
+                delete(f.last, other)
+            }
+        }
+    }
+    f.last[address] = now
+    return true, 0
+}
+
+// release forgets a request that was not sent, so the address can retry
+func (f *faucetCooldowns) release(address string) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    delete(f.last, address)
+}
+
+// RegisterFaucetRoutes registers POST /faucet, which sends the configured amount to
+// the requested address at most once per cooldown. The transfer is a VIDA
+// transaction, so the balance changes once the node applies its block.
+func RegisterFaucetRoutes(db *dbservice.DatabaseService, router *gin.Engine, sender FaucetSender) {
+    cfg := config.Get().Faucet
+    amount, _ := new(big.Int).SetString(cfg.Amount, 10)
+    cooldown, _ := time.ParseDuration(cfg.Cooldown)
+    cooldowns := &faucetCooldowns{last: make(map[string]time.Time)}
+
+    router.POST("/faucet", func(c *gin.Context) {
+        var request faucetRequest
+        if err := c.ShouldBindJSON(&request); err != nil {
+            c.String(http.StatusBadRequest, "Invalid request body")
+            return
+        }
+        receiver, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(request.Address), "0x"))
+        if err != nil || len(receiver) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+
+        balance, err := db.GetBalanceContext(c.Request.Context(), sender.Address())
+        if err != nil {
+            internalError(c, "Failed to read the faucet balance", err)
+            return
+        }
+        if balance.Cmp(amount) < 0 {
+            c.String(http.StatusServiceUnavailable, "The faucet is empty")
+            return
+        }
+
+        addressHex := hex.EncodeToString(receiver)
+        ok, wait := cooldowns.reserve(addressHex, cooldown, time.Now())
+        if !ok {
+            c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+            c.String(http.StatusTooManyRequests, "Address is cooling down")
+            return
+        }
+        hash, err := sender.Send(receiver, amount)
+        if err != nil {
+            cooldowns.release(addressHex)
+            internalError(c, "Failed to submit the faucet transfer", err)
+            return
+        }
+        logger.InfoContext(c.Request.Context(), "faucet transfer submitted", "receiver", addressHex, "amount", amount, "hash", hash)
+        c.JSON(http.StatusAccepted, gin.H{"address": addressHex, "amount": amount.String(), "hash": hash})
+    })
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/fees"
+)
+
+// registerFeeRoutes registers /fees
+func registerFeeRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    // The transfer fee of the current block and the volume it adjusts to
+    routes.GET("/fees", func(c *gin.Context) {
+        genesis, ok := genesisParams(db, c)
+        if !ok {
+            return
+        }
+        cfg := genesis.Fees
+        state, found, err := fees.Lookup(db)
+        if err != nil {
+            internalError(c, "Failed to read the transfer fee", err)
+            return
+        }
+        collected, err := db.GetBalanceContext(c.Request.Context(), fees.CollectorAddress)
+        if err != nil {
+            internalError(c, "Failed to read the fee collector", err)
+            return
+        }
+        if !found {
+            state.BaseFee = cfg.InitialBaseFee
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "baseFee":         state.BaseFee,
+            "block":           state.Block,
+            "transfers":       state.Transfers,
+            "targetTransfers": cfg.TargetTransfers,
+            "minBaseFee":      cfg.MinBaseFee,
+            "collector":       hex.EncodeToString(fees.CollectorAddress),
+            "collected":       collected.String(),
+        })
+    })
+}
@@ -1,27 +1,198 @@
 package api
 
 import (
+    "context"
     "encoding/hex"
+    "encoding/json"
+    "errors"
+    "math/big"
     "net/http"
+    "os"
     "strconv"
+    "strings"
 
     "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/audit"
+    "pwr-stateful-vida/config"
     "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/health"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/params"
+    "pwr-stateful-vida/prune"
+    "pwr-stateful-vida/staking"
 )
 
-func RegisterRoutes(router *gin.Engine) {
-    router.GET("/rootHash", func(c *gin.Context) {
+const (
+    defaultPageSize = 100
+    maxPageSize     = 1000
+
+    textPlain       = "text/plain; charset=utf-8"
+    applicationJSON = "application/json; charset=utf-8"
+)
+
+// accountEntry is a single account in the /accounts listing
+type accountEntry struct {
+    Address string `json:"address"`
+    Balance string `json:"balance"`
+}
+
+// balanceResponse is the response body of /balance
+type balanceResponse struct {
+    Address     string `json:"address"`
+    Balance     string `json:"balance"`
+    BlockNumber int64  `json:"blockNumber"`
+}
+
+// accountsPage is the response body of /accounts
+type accountsPage struct {
+    Accounts []accountEntry `json:"accounts"`
+    Next     string         `json:"next,omitempty"`
+}
+
+// richListEntry is a single holder in the /richlist response
+type richListEntry struct {
+    Rank    int    `json:"rank"`
+    Address string `json:"address"`
+    Balance string `json:"balance"`
+    Share   string `json:"share"`
+}
+
+// richList is the response body of /richlist
+type richList struct {
+    TotalSupply string          `json:"totalSupply"`
+    Holders     []richListEntry `json:"holders"`
+}
+
+// supply is the response body of /supply
+type supply struct {
+    TotalSupply       string `json:"totalSupply"`
+    Burned            string `json:"burned"`
+    Locked            string `json:"locked"`
+    Staked            string `json:"staked"`
+    CirculatingSupply string `json:"circulatingSupply"`
+}
+
+// blockEntry is a checkpoint in the /blocks listing
+type blockEntry struct {
+    BlockNumber int64  `json:"blockNumber"`
+    RootHash    string `json:"rootHash"`
+}
+
+// sumBalances adds up the balances of the given hex addresses, counting each address once
+func sumBalances(ctx context.Context, db *dbservice.DatabaseService, addresses []string) (*big.Int, error) {
+    sum := big.NewInt(0)
+    seen := make(map[string]bool)
+    for _, addressHex := range addresses {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(addressHex), "0x"))
+        if err != nil || seen[string(address)] {
+            continue
+        }
+        seen[string(address)] = true
+
+        balance, err := db.GetBalanceContext(ctx, address)
+        if err != nil {
+            return nil, err
+        }
+        sum.Add(sum, balance)
+    }
+    return sum, nil
+}
+
+// parseLimit reads the limit query parameter, clamped to the allowed page size
+func parseLimit(c *gin.Context) int {
+    limit, err := strconv.Atoi(c.Query("limit"))
+    if err != nil || limit <= 0 {
+        return defaultPageSize
+    }
+    if limit > maxPageSize {
+        return maxPageSize
+    }
+    return limit
+}
+
+// genesisParams returns the params recorded at genesis, answering with an error
+// when they cannot be read
+func genesisParams(db *dbservice.DatabaseService, c *gin.Context) (config.Params, bool) {
+    current, err := params.Current(db)
+    if err != nil {
+        internalError(c, "Failed to read the genesis params", err)
+        return current, false
+    }
+    return current, true
+}
+
+// RegisterRoutes registers the read API of the state for readers, route group by
+// route group, and the health probes, which need no credentials
+func RegisterRoutes(db *dbservice.DatabaseService, router *gin.Engine) {
+    // Health probes stay open for orchestrators, everything else needs the reader role
+    routes := router.Group("/", authenticate(), Require(RoleReader))
+
+    registerStateRoutes(db, routes)
+    registerBlockRoutes(db, routes)
+    registerNodeKeyRoutes(db, routes)
+    registerStakingRoutes(db, routes)
+    registerTokenRoutes(db, routes)
+    registerSwapRoutes(db, routes)
+    registerDistributionRoutes(db, routes)
+    registerAccountRulesRoutes(db, routes)
+    registerRecoveryRoutes(db, routes)
+    registerNameRoutes(db, routes)
+    registerBridgeRoutes(db, routes)
+    registerPaymasterRoutes(db, routes)
+    registerCrossVidaRoutes(db, routes)
+    registerSavingsRoutes(db, routes)
+    registerGovernanceRoutes(db, routes)
+    registerAirdropRoutes(db, routes)
+    registerMonetaryRoutes(db, routes)
+    registerReferralRoutes(db, routes)
+    registerStreamRoutes(db, routes)
+    registerSettlementRoutes(db, routes)
+    registerFeeRoutes(db, routes)
+    registerOTCRoutes(db, routes)
+    registerNFTRoutes(db, routes)
+    registerPolicyRoutes(db, routes)
+
+    router.GET("/health", func(c *gin.Context) {
+        c.JSON(http.StatusOK, health.Evaluate())
+    })
+
+    router.GET("/readyz", func(c *gin.Context) {
+        report := health.Evaluate()
+        if !report.Ready {
+            c.JSON(http.StatusServiceUnavailable, report)
+            return
+        }
+        c.JSON(http.StatusOK, report)
+    })
+
+    routes.GET("/metrics", func(c *gin.Context) {
+        c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        metrics.WritePrometheus(c.Writer)
+    })
+
+    routes.GET("/events/roots", streamRoots)
+
+    // Snapshots as verifiable chunks for nodes syncing or repairing their state
+    routes.GET("/state/manifest", peerEndpoint(), stateManifest)
+    routes.GET("/state/chunk", peerEndpoint(), stateChunk)
+}
+
+// registerStateRoutes registers the root hashes peers compare and the balances and
+// supply of the native token
+func registerStateRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/rootHash", peerEndpoint(), func(c *gin.Context) {
         blockNumber, _ := strconv.ParseInt(c.Query("blockNumber"), 10, 64)
-        lastCheckedBlock, _ := dbservice.GetLastCheckedBlock()
+        lastCheckedBlock, checkpointRoot, _ := db.CheckpointRootHashContext(c.Request.Context())
 
         if blockNumber == lastCheckedBlock {
-            if rootHash, _ := dbservice.GetRootHash(); rootHash != nil {
-                c.String(http.StatusOK, hex.EncodeToString(rootHash))
+            if checkpointRoot != nil {
+                writeSigned(c, textPlain, []byte(hex.EncodeToString(checkpointRoot)), false)
                 return
             }
         } else if blockNumber < lastCheckedBlock && blockNumber > 1 {
-            if blockRootHash, _ := dbservice.GetBlockRootHash(blockNumber); blockRootHash != nil {
-                c.String(http.StatusOK, hex.EncodeToString(blockRootHash))
+            if blockRootHash, _ := db.GetBlockRootHashContext(c.Request.Context(), blockNumber); blockRootHash != nil {
+                writeSigned(c, textPlain, []byte(hex.EncodeToString(blockRootHash)), true)
                 return
             }
             c.String(http.StatusBadRequest, "Block root hash not found for block number: "+c.Query("blockNumber"))
@@ -30,4 +201,260 @@ func RegisterRoutes(router *gin.Engine) {
 
         c.String(http.StatusBadRequest, "Invalid block number")
     })
+
+    routes.GET("/rootHashes", peerEndpoint(), func(c *gin.Context) {
+        from, errFrom := strconv.ParseInt(c.Query("from"), 10, 64)
+        to, errTo := strconv.ParseInt(c.Query("to"), 10, 64)
+        if errFrom != nil || errTo != nil || from > to || to-from >= maxPageSize {
+            c.String(http.StatusBadRequest, "Invalid block range")
+            return
+        }
+
+        lastCheckedBlock, checkpointRoot, _ := db.CheckpointRootHashContext(c.Request.Context())
+        rootHashes := make(map[string]string)
+        for blockNumber := max(from, 2); blockNumber <= to && blockNumber <= lastCheckedBlock; blockNumber++ {
+            var rootHash []byte
+            if blockNumber == lastCheckedBlock {
+                rootHash = checkpointRoot
+            } else {
+                rootHash, _ = db.GetBlockRootHashContext(c.Request.Context(), blockNumber)
+            }
+            if rootHash != nil {
+                rootHashes[strconv.FormatInt(blockNumber, 10)] = hex.EncodeToString(rootHash)
+            }
+        }
+
+        // Map keys are sorted by encoding/json, so equal ranges produce equal ETags
+        body, _ := json.Marshal(rootHashes)
+        writeSigned(c, applicationJSON, body, to < lastCheckedBlock)
+    })
+
+    routes.GET("/balance", func(c *gin.Context) {
+        addressHex := c.Query("address")
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(addressHex), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        balance, blockNumber, err := db.CommittedBalanceBlockContext(c.Request.Context(), address)
+        if err != nil {
+            internalError(c, "Failed to read balance", err)
+            return
+        }
+
+        body, _ := json.Marshal(balanceResponse{
+            Address:     hex.EncodeToString(address),
+            Balance:     balance.String(),
+            BlockNumber: blockNumber,
+        })
+        writeSigned(c, applicationJSON, body, false)
+    })
+
+    routes.GET("/balanceAt", getBalanceAt(db))
+
+    routes.GET("/accounts", func(c *gin.Context) {
+        limit := parseLimit(c)
+
+        var after []byte
+        if cursor := c.Query("after"); cursor != "" {
+            decoded, err := hex.DecodeString(strings.TrimPrefix(cursor, "0x"))
+            if err != nil {
+                c.String(http.StatusBadRequest, "Invalid cursor: "+cursor)
+                return
+            }
+            after = decoded
+        }
+
+        minBalance := big.NewInt(0)
+        if value := c.Query("minBalance"); value != "" {
+            if _, ok := minBalance.SetString(value, 10); !ok {
+                c.String(http.StatusBadRequest, "Invalid minBalance: "+value)
+                return
+            }
+        }
+
+        page := accountsPage{Accounts: []accountEntry{}}
+        var last []byte
+        err := db.ForEachAccountContext(c.Request.Context(), after, func(address []byte, balance *big.Int) bool {
+            if len(page.Accounts) == limit {
+                page.Next = hex.EncodeToString(last)
+                return false
+            }
+            last = address
+            if balance.Cmp(minBalance) >= 0 {
+                page.Accounts = append(page.Accounts, accountEntry{
+                    Address: hex.EncodeToString(address),
+                    Balance: balance.String(),
+                })
+            }
+            return true
+        })
+        if err != nil {
+            internalError(c, "Failed to list accounts", err)
+            return
+        }
+
+        c.JSON(http.StatusOK, page)
+    })
+
+    routes.GET("/richlist", func(c *gin.Context) {
+        accounts, total, err := db.TopAccountsContext(c.Request.Context(), parseLimit(c))
+        if err != nil {
+            internalError(c, "Failed to build rich list", err)
+            return
+        }
+
+        response := richList{TotalSupply: total.String(), Holders: []richListEntry{}}
+        for i, account := range accounts {
+            // Share of total supply as a percentage with 4 decimal places
+            share := "0.0000"
+            if total.Sign() > 0 {
+                ratio := new(big.Rat).SetFrac(new(big.Int).Mul(account.Balance, big.NewInt(100)), total)
+                share = ratio.FloatString(4)
+            }
+            response.Holders = append(response.Holders, richListEntry{
+                Rank:    i + 1,
+                Address: hex.EncodeToString(account.Address),
+                Balance: account.Balance.String(),
+                Share:   share,
+            })
+        }
+
+        c.JSON(http.StatusOK, response)
+    })
+
+    routes.GET("/supply", func(c *gin.Context) {
+        cfg := config.Get().Supply
+
+        all, err := db.TotalBalanceContext(c.Request.Context())
+        if err != nil {
+            internalError(c, "Failed to compute supply", err)
+            return
+        }
+        burned, err := sumBalances(c.Request.Context(), db, cfg.BurnAddresses)
+        if err != nil {
+            internalError(c, "Failed to compute supply", err)
+            return
+        }
+        locked, err := sumBalances(c.Request.Context(), db, cfg.LockedAddresses)
+        if err != nil {
+            internalError(c, "Failed to compute supply", err)
+            return
+        }
+
+        // Bonded and unbonding tokens are held by the staking account
+        staked, err := db.GetBalanceContext(c.Request.Context(), staking.EscrowAddress)
+        if err != nil {
+            internalError(c, "Failed to compute supply", err)
+            return
+        }
+
+        total := new(big.Int).Sub(all, burned)
+        circulating := new(big.Int).Sub(total, locked)
+        circulating.Sub(circulating, staked)
+        c.JSON(http.StatusOK, supply{
+            TotalSupply:       total.String(),
+            Burned:            burned.String(),
+            Locked:            locked.String(),
+            Staked:            staked.String(),
+            CirculatingSupply: circulating.String(),
+        })
+    })
+}
+
+// registerBlockRoutes registers the checkpoints, the audit history and the archived
+// batches of the node
+func registerBlockRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/blocks", func(c *gin.Context) {
+        limit := parseLimit(c)
+        lastCheckedBlock, checkpointRoot, _ := db.CheckpointRootHashContext(c.Request.Context())
+        blocks := []blockEntry{}
+        // Checkpoints are a few blocks apart, so the scan is bounded rather than the result
+        for blockNumber := lastCheckedBlock; blockNumber >= max(2, lastCheckedBlock-maxPageSize+1) && len(blocks) < limit; blockNumber-- {
+            var rootHash []byte
+            if blockNumber == lastCheckedBlock {
+                rootHash = checkpointRoot
+            } else {
+                rootHash, _ = db.GetBlockRootHashContext(c.Request.Context(), blockNumber)
+            }
+            if rootHash != nil {
+                blocks = append(blocks, blockEntry{BlockNumber: blockNumber, RootHash: hex.EncodeToString(rootHash)})
+            }
+        }
+        c.JSON(http.StatusOK, blocks)
+    })
+
+    routes.GET("/history", func(c *gin.Context) {
+        path := config.Get().Audit.Path
+        if path == "" {
+            c.String(http.StatusNotFound, "Audit log is disabled")
+            return
+        }
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Query("address")), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        entries, err := audit.History(path, hex.EncodeToString(address), parseLimit(c))
+        if err != nil {
+            internalError(c, "Failed to read audit log", err)
+            return
+        }
+        if entries == nil {
+            entries = []audit.Entry{}
+        }
+        c.JSON(http.StatusOK, entries)
+    })
+
+    routes.GET("/archive", func(c *gin.Context) {
+        dir := config.Get().ArchiveDir
+        if dir == "" {
+            c.String(http.StatusNotFound, "Archive is disabled")
+            return
+        }
+        blockNumber, err := strconv.ParseInt(c.Query("blockNumber"), 10, 64)
+        if err != nil || blockNumber < 0 {
+            c.String(http.StatusBadRequest, "Invalid block number")
+            return
+        }
+
+        record, err := archive.Find(dir, blockNumber)
+        if errors.Is(err, archive.ErrNotFound) || os.IsNotExist(err) {
+            c.String(http.StatusNotFound, "Block not archived: "+c.Query("blockNumber"))
+            return
+        }
+        if err != nil {
+            internalError(c, "Failed to read archive", err)
+            return
+        }
+        c.JSON(http.StatusOK, record)
+    })
+
+    // Replicas follow the node by applying the archived changes of its batches
+    routes.GET("/archive/after", peerEndpoint(), func(c *gin.Context) {
+        dir := config.Get().ArchiveDir
+        if dir == "" {
+            c.String(http.StatusNotFound, "Archive is disabled")
+            return
+        }
+        blockNumber, err := strconv.ParseInt(c.Query("blockNumber"), 10, 64)
+        if err != nil || blockNumber < 0 {
+            c.String(http.StatusBadRequest, "Invalid block number")
+            return
+        }
+
+        records, err := archive.After(dir, blockNumber, parseLimit(c))
+        if err != nil && !os.IsNotExist(err) {
+            internalError(c, "Failed to read archive", err)
+            return
+        }
+        if records == nil {
+            records = []archive.Record{}
+        }
+        c.JSON(http.StatusOK, records)
+    })
+
+    routes.GET("/pruning", func(c *gin.Context) {
+        c.JSON(http.StatusOK, prune.Status())
+    })
 }
@@ -0,0 +1,103 @@
+package api
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/distribution"
+    "pwr-stateful-vida/governance"
+)
+
+// proposalTally is a proposal in the /governance/proposals responses
+type proposalTally struct {
+    governance.Proposal
+    Status string `json:"status"`
+    // Total is the voting power in the snapshot of the proposal, once taken
+    Total string `json:"total,omitempty"`
+}
+
+// tallyProposal returns a proposal with its status after the last checked block
+func tallyProposal(ctx context.Context, db *dbservice.DatabaseService, proposal governance.Proposal) (proposalTally, error) {
+    lastCheckedBlock, err := db.GetLastCheckedBlockContext(ctx)
+    if err != nil {
+        return proposalTally{}, err
+    }
+    tally := proposalTally{Proposal: proposal}
+    if tally.Status, err = governance.Status(db, proposal, lastCheckedBlock+1); err != nil {
+        return tally, err
+    }
+    snapshot, found, err := distribution.Lookup(db, proposal.Snapshot)
+    if found && snapshot.Taken {
+        tally.Total = snapshot.Total
+    }
+    return tally, err
+}
+
+// registerGovernanceRoutes registers the governance params and proposals
+func registerGovernanceRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/governance/params", func(c *gin.Context) {
+        genesis, ok := genesisParams(db, c)
+        if !ok {
+            return
+        }
+        cfg := genesis.Governance
+        params, err := governance.CurrentParams(db, governance.Params{Quorum: cfg.Quorum, Threshold: cfg.Threshold, VotingPeriod: cfg.VotingPeriod, Deposit: cfg.Deposit})
+        if err != nil {
+            internalError(c, "Failed to read governance params", err)
+            return
+        }
+        c.JSON(http.StatusOK, params)
+    })
+
+    routes.GET("/governance/proposals", func(c *gin.Context) {
+        var after uint64
+        if raw := c.Query("after"); raw != "" {
+            var err error
+            if after, err = strconv.ParseUint(raw, 10, 64); err != nil {
+                c.String(http.StatusBadRequest, "Invalid proposal ID")
+                return
+            }
+        }
+        proposals, err := governance.List(db, after, parseLimit(c))
+        if err != nil {
+            internalError(c, "Failed to read proposals", err)
+            return
+        }
+        tallies := make([]proposalTally, 0, len(proposals))
+        for _, proposal := range proposals {
+            tally, err := tallyProposal(c.Request.Context(), db, proposal)
+            if err != nil {
+                internalError(c, "Failed to tally proposal", err)
+                return
+            }
+            tallies = append(tallies, tally)
+        }
+        c.JSON(http.StatusOK, tallies)
+    })
+
+    routes.GET("/governance/proposals/:id", func(c *gin.Context) {
+        id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+        if err != nil {
+            c.String(http.StatusBadRequest, "Invalid proposal ID")
+            return
+        }
+        proposal, ok, err := governance.Lookup(db, id)
+        if err != nil {
+            internalError(c, "Failed to read proposal", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "Proposal not found: "+c.Param("id"))
+            return
+        }
+        tally, err := tallyProposal(c.Request.Context(), db, proposal)
+        if err != nil {
+            internalError(c, "Failed to tally proposal", err)
+            return
+        }
+        c.JSON(http.StatusOK, tally)
+    })
+}
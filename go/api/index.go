@@ -0,0 +1,107 @@
+package api
+
+import (
+    "encoding/hex"
+    "math/big"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/index"
+)
+
+// indexFilter reads the filter query parameters shared by the index endpoints,
+// writing a 400 response when one is invalid
+func indexFilter(c *gin.Context, addressParam string) (index.Filter, bool) {
+    filter := index.Filter{Token: c.Query("token"), Action: c.Query("action"), Limit: parseLimit(c)}
+    if value := c.Query(addressParam); value != "" {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(value), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid "+addressParam)
+            return filter, false
+        }
+        filter.Address = hex.EncodeToString(address)
+    }
+    for name, block := range map[string]*int64{"fromBlock": &filter.FromBlock, "toBlock": &filter.ToBlock} {
+        if value := c.Query(name); value != "" {
+            parsed, err := strconv.ParseInt(value, 10, 64)
+            if err != nil || parsed < 0 {
+                c.String(http.StatusBadRequest, "Invalid "+name)
+                return filter, false
+            }
+            *block = parsed
+        }
+    }
+    if value := c.Query("failed"); value != "" {
+        failed, err := strconv.ParseBool(value)
+        if err != nil {
+            c.String(http.StatusBadRequest, "Invalid failed")
+            return filter, false
+        }
+        filter.Failed = &failed
+    }
+    return filter, true
+}
+
+// RegisterIndexRoutes registers the /index endpoints, which query the SQLite index
+// of committed receipts, transfers and balances
+func RegisterIndexRoutes(router *gin.Engine, idx *index.Index) {
+    routes := router.Group("/index", authenticate(), Require(RoleReader))
+
+    routes.GET("/transfers", func(c *gin.Context) {
+        filter, ok := indexFilter(c, "address")
+        if !ok {
+            return
+        }
+        transfers, err := idx.Transfers(filter)
+        if err != nil {
+            internalError(c, "Failed to query transfers", err)
+            return
+        }
+        c.JSON(http.StatusOK, transfers)
+    })
+
+    routes.GET("/receipts", func(c *gin.Context) {
+        filter, ok := indexFilter(c, "sender")
+        if !ok {
+            return
+        }
+        receipts, err := idx.Receipts(filter)
+        if err != nil {
+            internalError(c, "Failed to query receipts", err)
+            return
+        }
+        c.JSON(http.StatusOK, receipts)
+    })
+
+    routes.GET("/balances", func(c *gin.Context) {
+        minBalance := big.NewInt(0)
+        if value := c.Query("minBalance"); value != "" {
+            if _, ok := minBalance.SetString(value, 10); !ok {
+                c.String(http.StatusBadRequest, "Invalid minBalance: "+value)
+                return
+            }
+        }
+        balances, err := idx.Balances(minBalance, parseLimit(c))
+        if err != nil {
+            internalError(c, "Failed to query balances", err)
+            return
+        }
+        c.JSON(http.StatusOK, balances)
+    })
+
+    routes.GET("/stats", func(c *gin.Context) {
+        filter, ok := indexFilter(c, "address")
+        if !ok {
+            return
+        }
+        stats, err := idx.Stats(filter)
+        if err != nil {
+            internalError(c, "Failed to aggregate the index", err)
+            return
+        }
+        c.JSON(http.StatusOK, stats)
+    })
+}
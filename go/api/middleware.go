@@ -0,0 +1,87 @@
+package api
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "math"
+    mathrand "math/rand"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+)
+
+// RequestIDHeader carries the request ID in requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+// accessLogger writes access log entries in the configured log format, logger the
+// errors of API handlers
+var (
+    accessLogger = logging.For("access")
+    logger       = logging.For("api")
+)
+
+// newRequestID returns a random 16 byte hex identifier
+func newRequestID() string {
+    id := make([]byte, 16)
+    rand.Read(id)
+    return hex.EncodeToString(id)
+}
+
+// RequestID assigns every request an ID, reusing the one sent by the client if present.
+// The ID is the correlation ID of everything logged or reported for the request.
+func RequestID() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        requestID := c.GetHeader(RequestIDHeader)
+        if requestID == "" || len(requestID) > 64 {
+            requestID = newRequestID()
+        }
+        c.Set("requestID", requestID)
+        c.Header(RequestIDHeader, requestID)
+        c.Request = c.Request.WithContext(logging.WithCorrelationID(c.Request.Context(), requestID))
+        c.Next()
+    }
+}
+
+// AccessLog logs method, path, status, latency, client IP and request ID of each request.
+// Only a sampleRate fraction of successful requests is logged; errors are always logged.
+func AccessLog(sampleRate float64) gin.HandlerFunc {
+    sampleRate = math.Max(0, math.Min(1, sampleRate))
+
+    return func(c *gin.Context) {
+        start := time.Now()
+        c.Next()
+
+        status := c.Writer.Status()
+        if status < http.StatusBadRequest && mathrand.Float64() >= sampleRate {
+            return
+        }
+
+        accessLogger.InfoContext(c.Request.Context(), "http request",
+            "method", c.Request.Method,
+            "path", c.Request.URL.Path,
+            "status", status,
+            "latencyMs", float64(time.Since(start).Microseconds())/1000,
+            "clientIp", c.ClientIP(),
+        )
+    }
+}
+
+// internalError responds with a 500 status and message, logging and reporting err
+// with the correlation ID of the request. A request whose context ended, because the
+// client went away or its deadline passed, gets a 503 status and is not reported.
+func internalError(c *gin.Context, message string, err error) {
+    ctx := c.Request.Context()
+    if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        logger.DebugContext(ctx, message, "path", c.Request.URL.Path, "error", err)
+        c.String(http.StatusServiceUnavailable, message)
+        return
+    }
+    logger.ErrorContext(ctx, message, "path", c.Request.URL.Path, "error", err)
+    reporting.Report(err, reporting.Context{Module: "api", CorrelationID: logging.CorrelationID(ctx), Extra: map[string]string{"path": c.Request.URL.Path}})
+    c.String(http.StatusInternalServerError, message)
+}
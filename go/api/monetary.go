@@ -0,0 +1,46 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/monetary"
+)
+
+// registerMonetaryRoutes registers /monetary
+func registerMonetaryRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    // The monetary policy in force and the supply, with what the schedule still
+    // allows issuing at the next block
+    routes.GET("/monetary", func(c *gin.Context) {
+        lastCheckedBlock, err := db.GetLastCheckedBlockContext(c.Request.Context())
+        if err != nil {
+            internalError(c, "Failed to read the last checked block", err)
+            return
+        }
+        policy, err := monetary.Current(db)
+        if err != nil {
+            internalError(c, "Failed to read the monetary policy", err)
+            return
+        }
+        state, err := monetary.Lookup(db)
+        if err != nil {
+            internalError(c, "Failed to read the supply", err)
+            return
+        }
+        response := gin.H{
+            "policy": policy,
+            "supply": state.Supply,
+            "issued": state.Issued,
+            "burned": state.Burned,
+        }
+        if allowance := policy.Allowance(lastCheckedBlock + 1); allowance != nil {
+            issued := dbservice.ParseAmount(state.Issued)
+            if allowance.Sub(allowance, issued).Sign() < 0 {
+                allowance.SetInt64(0)
+            }
+            response["issuable"] = allowance.String()
+        }
+        c.JSON(http.StatusOK, response)
+    })
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/names"
+)
+
+// registerNameRoutes registers /resolve
+func registerNameRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/resolve/:name", func(c *gin.Context) {
+        name := strings.ToLower(c.Param("name"))
+        if !names.ValidName(name) {
+            c.String(http.StatusBadRequest, "Invalid name")
+            return
+        }
+        record, ok, err := names.Lookup(db, name)
+        if err != nil {
+            internalError(c, "Failed to read name", err)
+            return
+        }
+        lastCheckedBlock, _ := db.GetLastCheckedBlockContext(c.Request.Context())
+        if !ok || !record.Active(lastCheckedBlock) {
+            c.String(http.StatusNotFound, "Name not registered: "+name)
+            return
+        }
+        c.JSON(http.StatusOK, record)
+    })
+}
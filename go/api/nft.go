@@ -0,0 +1,56 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/nft"
+)
+
+// nftPage is the response body of /nfts
+type nftPage struct {
+    Items []nft.Item `json:"items"`
+    Next  string     `json:"next,omitempty"`
+}
+
+// registerNFTRoutes registers the NFT items and listings
+func registerNFTRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/nft/:item", func(c *gin.Context) {
+        id := c.Param("item")
+        if !nft.ValidID(id) {
+            c.String(http.StatusBadRequest, "Invalid item ID")
+            return
+        }
+        item, ok, err := nft.Lookup(db, id)
+        if err != nil {
+            internalError(c, "Failed to read item", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "Item not found: "+id)
+            return
+        }
+        c.JSON(http.StatusOK, item)
+    })
+
+    routes.GET("/nfts", func(c *gin.Context) {
+        var owner []byte
+        if ownerHex := c.Query("owner"); ownerHex != "" {
+            decoded, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(ownerHex), "0x"))
+            if err != nil || len(decoded) != dbservice.AddressLength {
+                c.String(http.StatusBadRequest, "Invalid owner")
+                return
+            }
+            owner = decoded
+        }
+        items, next, err := nft.List(db, owner, c.Query("after"), parseLimit(c))
+        if err != nil {
+            internalError(c, "Failed to list items", err)
+            return
+        }
+        c.JSON(http.StatusOK, nftPage{Items: items, Next: next})
+    })
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/nodekeys"
+)
+
+// nodeKeys is the response body of /nodeKeys
+type nodeKeys struct {
+    Node string `json:"node"`
+    nodekeys.Entry
+    // ValidKeys are the keys that verify the node's signatures at BlockNumber
+    ValidKeys   []string `json:"validKeys"`
+    BlockNumber int64    `json:"blockNumber"`
+}
+
+// registerNodeKeyRoutes registers /nodeKeys
+func registerNodeKeyRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/nodeKeys", func(c *gin.Context) {
+        node := c.Query("node")
+        if node == "" {
+            node = config.Get().Signing.NodeID
+        }
+        if node == "" {
+            c.String(http.StatusBadRequest, "Missing node")
+            return
+        }
+        entry, ok, err := nodekeys.Lookup(db, node)
+        if err != nil {
+            internalError(c, "Failed to read node keys", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "No keys registered for node: "+node)
+            return
+        }
+        lastCheckedBlock, _ := db.GetLastCheckedBlockContext(c.Request.Context())
+        c.JSON(http.StatusOK, nodeKeys{Node: node, Entry: entry, ValidKeys: entry.ValidKeys(lastCheckedBlock), BlockNumber: lastCheckedBlock})
+    })
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/otc"
+)
+
+// registerOTCRoutes registers the OTC offers
+func registerOTCRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/otc/offers/:id", func(c *gin.Context) {
+        id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+        if err != nil {
+            c.String(http.StatusBadRequest, "Invalid offer ID")
+            return
+        }
+        offer, ok, err := otc.Lookup(db, id)
+        if err != nil {
+            internalError(c, "Failed to read offer", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "No open offer with this ID")
+            return
+        }
+        c.JSON(http.StatusOK, offer)
+    })
+}
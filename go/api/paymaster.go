@@ -0,0 +1,36 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/paymaster"
+)
+
+// registerPaymasterRoutes registers /sponsor
+func registerPaymasterRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/sponsor", func(c *gin.Context) {
+        var addresses [2][]byte
+        for i, name := range []string{"sponsor", "account"} {
+            address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Query(name)), "0x"))
+            if err != nil || len(address) != dbservice.AddressLength {
+                c.String(http.StatusBadRequest, "Invalid "+name+" address")
+                return
+            }
+            addresses[i] = address
+        }
+        allowance, ok, err := paymaster.Lookup(db, addresses[0], addresses[1])
+        if err != nil {
+            internalError(c, "Failed to read sponsorship", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "No sponsorship allowance")
+            return
+        }
+        c.JSON(http.StatusOK, allowance)
+    })
+}
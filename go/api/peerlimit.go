@@ -0,0 +1,118 @@
+package api
+
+import (
+    "math"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/health"
+    "pwr-stateful-vida/metrics"
+)
+
+// maxPeerBuckets is the number of client budgets kept before full ones are dropped
+const maxPeerBuckets = 10000
+
+// peerBucket is the token bucket of one client IP
+type peerBucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// peerLimiter holds the request budgets of the peer endpoints
+type peerLimiter struct {
+    mutex   sync.Mutex
+    buckets map[string]*peerBucket
+}
+
+var peerBudgets = &peerLimiter{buckets: make(map[string]*peerBucket)}
+
+// allow takes a token from the bucket of ip, returning how long to wait otherwise
+func (l *peerLimiter) allow(ip string, rate float64, burst int, now time.Time) (bool, time.Duration) {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+
+    bucket, ok := l.buckets[ip]
+    if !ok {
+        if len(l.buckets) >= maxPeerBuckets {
+            l.dropFull(rate, burst, now)
+        }
+        bucket = &peerBucket{tokens: float64(burst), last: now}
+        l.buckets[ip] = bucket
+    }
+
+    bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+    bucket.last = now
+    if bucket.tokens < 1 {
+        return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+    }
+    bucket.tokens--
+    return true, 0
+}
+
+// dropFull forgets the clients whose buckets have refilled, which behave like new ones
+func (l *peerLimiter) dropFull(rate float64, burst int, now time.Time) {
+    for ip, bucket := range l.buckets {
+        if bucket.tokens+now.Sub(bucket.last).Seconds()*rate >= float64(burst) {
+            delete(l.buckets, ip)
+        }
+    }
+}
+
+// peerEndpoint protects the endpoints peers validate root hashes with, which auditors,
+// replicas and standbys do not serve at all: when a client
+// CA is configured only validators with a certificate it signed are served, each
+// client IP gets a request budget, and a response the client is slow to read is cut
+// off. The IP is taken from the connection, since forwarded headers can be forged.
+func peerEndpoint() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if refusal := PeerRefusal(); refusal != "" {
+            c.String(http.StatusNotFound, refusal)
+            c.Abort()
+            return
+        }
+        cfg := config.Get().HTTP
+        if cfg.TLS.ClientCAFile != "" && (c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0) {
+            c.String(http.StatusForbidden, "A validator client certificate is required")
+            c.Abort()
+            return
+        }
+        if allowed, wait := AllowPeer(c.RemoteIP()); !allowed {
+            metrics.ThrottledRequests.Inc(c.FullPath())
+            c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+            c.AbortWithStatus(http.StatusTooManyRequests)
+            return
+        }
+        if timeout, err := time.ParseDuration(cfg.PeerWriteTimeout); err == nil && timeout > 0 {
+            http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(timeout))
+        }
+        c.Next()
+    }
+}
+
+// PeerRefusal returns why the node does not serve peers, as an auditor, replica or
+// standby, or an empty string if it does
+func PeerRefusal() string {
+    switch {
+    case config.Get().Auditor.Enabled:
+        return "This node is an auditor and does not serve peers"
+    case config.Get().Replica.Primary != "":
+        return "This node is a replica and does not serve peers"
+    case health.Standby():
+        return "This node is a standby and does not serve peers"
+    }
+    return ""
+}
+
+// AllowPeer takes a request from the budget of the client IP on the peer endpoints,
+// shared by the HTTP and gRPC APIs, returning how long to wait otherwise
+func AllowPeer(ip string) (bool, time.Duration) {
+    cfg := config.Get().HTTP
+    if cfg.PeerRequestsPerSecond <= 0 {
+        return true, 0
+    }
+    return peerBudgets.allow(ip, cfg.PeerRequestsPerSecond, cfg.PeerBurst, time.Now())
+}
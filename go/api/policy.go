@@ -0,0 +1,49 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/policy"
+)
+
+// policyState is the response body of /policy
+type policyState struct {
+    policy.Rules
+    Governors []string        `json:"governors"`
+    Account   *policy.Account `json:"account,omitempty"`
+}
+
+// registerPolicyRoutes registers /policy
+func registerPolicyRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/policy", func(c *gin.Context) {
+        rules, err := policy.CurrentRules(db)
+        if err != nil {
+            internalError(c, "Failed to read policy", err)
+            return
+        }
+        genesis, ok := genesisParams(db, c)
+        if !ok {
+            return
+        }
+        addressHex := c.Query("address")
+        if addressHex == "" {
+            c.JSON(http.StatusOK, policyState{Rules: rules, Governors: genesis.Policy.Governors})
+            return
+        }
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(addressHex), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        account, err := policy.AccountPolicy(db, address)
+        if err != nil {
+            internalError(c, "Failed to read policy", err)
+            return
+        }
+        c.JSON(http.StatusOK, policyState{Rules: rules, Governors: genesis.Policy.Governors, Account: &account})
+    })
+}
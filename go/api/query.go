@@ -0,0 +1,55 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// StateQuerier answers queries about the state of the application the node runs
+type StateQuerier interface {
+    // Query returns the answer to path, encoded as JSON, for the query parameters
+    Query(path string, params map[string]string) (interface{}, error)
+}
+
+// Errors a StateQuerier returns for queries it cannot answer, wrapped with the
+// reason for an invalid one
+var (
+    ErrUnknownQuery = errors.New("unknown query")
+    ErrInvalidQuery = errors.New("invalid query")
+)
+
+// RegisterQueryRoutes registers GET /query/<path>, which answers with what querier
+// returns for the path and the query parameters
+func RegisterQueryRoutes(router *gin.Engine, querier StateQuerier) {
+    routes := router.Group("/", authenticate(), Require(RoleReader))
+
+    routes.GET("/query/*path", func(c *gin.Context) {
+        params := make(map[string]string)
+        for name, values := range c.Request.URL.Query() {
+            params[name] = values[0]
+        }
+        result, err := querier.Query(strings.TrimPrefix(c.Param("path"), "/"), params)
+        switch {
+        case errors.Is(err, ErrUnknownQuery):
+            c.String(http.StatusNotFound, "Unknown query")
+            return
+        case errors.Is(err, ErrInvalidQuery):
+            c.String(http.StatusBadRequest, err.Error())
+            return
+        case err != nil:
+            internalError(c, "Failed to answer query", err)
+            return
+        }
+
+        body, err := json.Marshal(result)
+        if err != nil {
+            internalError(c, "Failed to encode query result", err)
+            return
+        }
+        writeSigned(c, applicationJSON, body, false)
+    })
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/recovery"
+)
+
+// recoveryState is the response body of /recovery
+type recoveryState struct {
+    Address    string             `json:"address"`
+    Controller string             `json:"controller"`
+    Guardians  recovery.Guardians `json:"guardians"`
+    Pending    *recovery.Request  `json:"pending,omitempty"`
+}
+
+// registerRecoveryRoutes registers /recovery
+func registerRecoveryRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/recovery", func(c *gin.Context) {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Query("address")), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        guardians, err := recovery.GuardiansOf(db, address)
+        if err != nil {
+            internalError(c, "Failed to read guardians", err)
+            return
+        }
+        controller, err := recovery.Controller(db, address)
+        if err != nil {
+            internalError(c, "Failed to read controller", err)
+            return
+        }
+        state := recoveryState{Address: hex.EncodeToString(address), Controller: hex.EncodeToString(controller), Guardians: guardians}
+        request, ok, err := recovery.PendingOf(db, address)
+        if err != nil {
+            internalError(c, "Failed to read pending recovery", err)
+            return
+        }
+        if ok {
+            state.Pending = &request
+        }
+        c.JSON(http.StatusOK, state)
+    })
+}
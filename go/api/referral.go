@@ -0,0 +1,62 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/referral"
+)
+
+// registerReferralRoutes registers /referral
+func registerReferralRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    // The referral payout rules and pool, with the referrer and earnings of an
+    // address when one is given
+    routes.GET("/referral", func(c *gin.Context) {
+        genesis, ok := genesisParams(db, c)
+        if !ok {
+            return
+        }
+        cfg := genesis.Referral
+        params, err := referral.CurrentParams(db, referral.Params{Rate: cfg.Rate, MaxPayout: cfg.MaxPayout, MinTransfer: cfg.MinTransfer})
+        if err != nil {
+            internalError(c, "Failed to read referral params", err)
+            return
+        }
+        pool, err := db.GetBalanceContext(c.Request.Context(), referral.PoolAddress)
+        if err != nil {
+            internalError(c, "Failed to read the referral pool", err)
+            return
+        }
+        response := gin.H{
+            "params":      params,
+            "pool":        hex.EncodeToString(referral.PoolAddress),
+            "poolBalance": pool.String(),
+        }
+        if raw := c.Query("address"); raw != "" {
+            address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(raw), "0x"))
+            if err != nil || len(address) != dbservice.AddressLength {
+                c.String(http.StatusBadRequest, "Invalid address")
+                return
+            }
+            referrer, err := referral.Referrer(db, address)
+            if err != nil {
+                internalError(c, "Failed to read the referrer", err)
+                return
+            }
+            earnings, err := referral.EarningsOf(db, address)
+            if err != nil {
+                internalError(c, "Failed to read referral earnings", err)
+                return
+            }
+            if referrer != nil {
+                response["referrer"] = hex.EncodeToString(referrer)
+            }
+            response["referees"] = earnings.Referees
+            response["earned"] = earnings.Earned
+        }
+        c.JSON(http.StatusOK, response)
+    })
+}
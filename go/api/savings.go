@@ -0,0 +1,53 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/savings"
+)
+
+// registerSavingsRoutes registers /savings
+func registerSavingsRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    // The savings pool as accrued at the last checked block, with the shares and
+    // their value of an address when one is given
+    routes.GET("/savings", func(c *gin.Context) {
+        lastCheckedBlock, err := db.GetLastCheckedBlockContext(c.Request.Context())
+        if err != nil {
+            internalError(c, "Failed to read the last checked block", err)
+            return
+        }
+        state, err := savings.Preview(db, lastCheckedBlock)
+        if err != nil {
+            internalError(c, "Failed to read the savings pool", err)
+            return
+        }
+        totalShares := dbservice.ParseAmount(state.TotalShares)
+        response := gin.H{
+            "rate":        state.Rate,
+            "index":       state.Index,
+            "totalShares": state.TotalShares,
+            "totalValue":  savings.Value(state, totalShares).String(),
+            "pool":        hex.EncodeToString(savings.PoolAddress),
+            "block":       state.LastBlock,
+        }
+        if raw := c.Query("address"); raw != "" {
+            address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(raw), "0x"))
+            if err != nil || len(address) != dbservice.AddressLength {
+                c.String(http.StatusBadRequest, "Invalid address")
+                return
+            }
+            shares, err := savings.SharesOf(db, address)
+            if err != nil {
+                internalError(c, "Failed to read savings shares", err)
+                return
+            }
+            response["shares"] = shares.String()
+            response["value"] = savings.Value(state, shares).String()
+        }
+        c.JSON(http.StatusOK, response)
+    })
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "net"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/config"
+)
+
+// NewServer returns the HTTP server of the API with the timeouts of the configuration,
+// so that clients sending requests slowly cannot hold connections open
+func NewServer(handler http.Handler, cfg config.HTTPConfig) *http.Server {
+    server := &http.Server{
+        Handler:        handler,
+        MaxHeaderBytes: 16 << 10,
+    }
+    if timeout, err := time.ParseDuration(cfg.ReadHeaderTimeout); err == nil {
+        server.ReadHeaderTimeout = timeout
+        // Requests have no bodies worth waiting for
+        server.ReadTimeout = 2 * timeout
+    }
+    if timeout, err := time.ParseDuration(cfg.IdleTimeout); err == nil {
+        server.IdleTimeout = timeout
+    }
+    return server
+}
+
+// ServerTLS returns the TLS configuration of the API server, or nil to serve plain
+// HTTP. With a client CA, certificates are verified when presented and required by
+// the peer endpoints only, so other clients can still connect without one.
+func ServerTLS(cfg config.TLSConfig) (*tls.Config, error) {
+    if cfg.CertFile == "" {
+        return nil, nil
+    }
+    certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+    if err != nil {
+        return nil, err
+    }
+    tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+
+    if cfg.ClientCAFile != "" {
+        pem, err := os.ReadFile(cfg.ClientCAFile)
+        if err != nil {
+            return nil, err
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("%s holds no PEM certificates", cfg.ClientCAFile)
+        }
+        tlsConfig.ClientCAs = pool
+        tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+    }
+    return tlsConfig, nil
+}
+
+// limitListener accepts at most n connections at a time
+type limitListener struct {
+    net.Listener
+    slots chan struct{}
+}
+
+// LimitListener returns a listener that waits for a connection to close before
+// accepting more than n; n of 0 returns l unchanged
+func LimitListener(l net.Listener, n int) net.Listener {
+    if n <= 0 {
+        return l
+    }
+    return &limitListener{Listener: l, slots: make(chan struct{}, n)}
+}
+
+// Accept waits for a free slot, then for a connection
+func (l *limitListener) Accept() (net.Conn, error) {
+    l.slots <- struct{}{}
+    conn, err := l.Listener.Accept()
+    if err != nil {
+        <-l.slots
+        return nil, err
+    }
+    return &limitConn{Conn: conn, release: func() { <-l.slots }}, nil
+}
+
+// limitConn frees its slot once closed
+type limitConn struct {
+    net.Conn
+    release func()
+    once    sync.Once
+}
+
+// Close closes the connection and frees its slot
+func (c *limitConn) Close() error {
+    err := c.Conn.Close()
+    c.once.Do(c.release)
+    return err
+}
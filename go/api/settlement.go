@@ -0,0 +1,32 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/settlement"
+)
+
+// registerSettlementRoutes registers the settlements of operators
+func registerSettlementRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/settlement/:operator/:id", func(c *gin.Context) {
+        operator, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Param("operator")), "0x"))
+        if err != nil || len(operator) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid operator address")
+            return
+        }
+        batch, ok, err := settlement.Lookup(db, operator, c.Param("id"))
+        if err != nil {
+            internalError(c, "Failed to read settlement batch", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "Batch not settled")
+            return
+        }
+        c.JSON(http.StatusOK, batch)
+    })
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/signing"
+)
+
+// writeSigned writes a cached response like writeCached, signing the body with the
+// node key when one is configured. The signature covers the request path and query,
+// so a consumer can prove which question the node answered.
+func writeSigned(c *gin.Context, contentType string, body []byte, finalized bool) {
+    if signature, ok := signing.Sign(c.Request.URL.RequestURI(), body, time.Now()); ok {
+        c.Header(signing.SignatureHeader, signature.Signature)
+        c.Header(signing.TimestampHeader, signature.Timestamp)
+        c.Header(signing.KeyHeader, signature.Key)
+        if signature.PreviousSignature != "" {
+            c.Header(signing.PreviousSignatureHeader, signature.PreviousSignature)
+            c.Header(signing.PreviousKeyHeader, signature.PreviousKey)
+        }
+    }
+    writeCached(c, contentType, body, finalized)
+}
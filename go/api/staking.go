@@ -0,0 +1,44 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/staking"
+)
+
+// stakingState is the response body of /staking
+type stakingState struct {
+    TotalBonded string `json:"totalBonded"`
+    Escrow      string `json:"escrow"`
+    *staking.Account
+}
+
+// registerStakingRoutes registers /staking
+func registerStakingRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/staking", func(c *gin.Context) {
+        total, err := staking.TotalBonded(db)
+        if err != nil {
+            internalError(c, "Failed to read staking", err)
+            return
+        }
+        response := stakingState{TotalBonded: total.String(), Escrow: hex.EncodeToString(staking.EscrowAddress)}
+        if addressHex := c.Query("address"); addressHex != "" {
+            address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(addressHex), "0x"))
+            if err != nil || len(address) != dbservice.AddressLength {
+                c.String(http.StatusBadRequest, "Invalid address")
+                return
+            }
+            account, err := staking.AccountState(db, address)
+            if err != nil {
+                internalError(c, "Failed to read staking", err)
+                return
+            }
+            response.Account = &account
+        }
+        c.JSON(http.StatusOK, response)
+    })
+}
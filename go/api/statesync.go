@@ -0,0 +1,88 @@
+package api
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "sync"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/statesync"
+)
+
+const applicationOctetStream = "application/octet-stream"
+
+// stateSources keeps the snapshot last served for state sync, since a syncing node
+// asks for its chunks one after another
+var stateSources struct {
+    mutex       sync.Mutex
+    source      *statesync.Source
+    chunkLeaves int
+}
+
+// stateSource returns the source of the snapshot of the blockNumber query parameter,
+// or of the newest snapshot without one, writing the error response when there is none
+func stateSource(c *gin.Context) (*statesync.Source, bool) {
+    blockNumber := int64(-1)
+    if raw := c.Query("blockNumber"); raw != "" {
+        var err error
+        if blockNumber, err = strconv.ParseInt(raw, 10, 64); err != nil || blockNumber < 0 {
+            c.String(http.StatusBadRequest, "Invalid block number")
+            return nil, false
+        }
+    }
+    chunkLeaves := statesync.DefaultChunkLeaves
+    if raw := c.Query("chunkLeaves"); raw != "" {
+        var err error
+        if chunkLeaves, err = strconv.Atoi(raw); err != nil {
+            c.String(http.StatusBadRequest, "Invalid chunk size")
+            return nil, false
+        }
+    }
+
+    stateSources.mutex.Lock()
+    defer stateSources.mutex.Unlock()
+    if source := stateSources.source; source != nil && source.Manifest.BlockNumber == blockNumber && stateSources.chunkLeaves == chunkLeaves {
+        return source, true
+    }
+    source, err := statesync.OpenSnapshot(config.Get().SnapshotDir, blockNumber, chunkLeaves)
+    if errors.Is(err, statesync.ErrNoSnapshot) {
+        c.String(http.StatusNotFound, "No snapshot of block "+c.Query("blockNumber"))
+        return nil, false
+    }
+    if err != nil {
+        internalError(c, "Failed to open snapshot", err)
+        return nil, false
+    }
+    stateSources.source, stateSources.chunkLeaves = source, chunkLeaves
+    return source, true
+}
+
+// stateManifest serves the manifest of a snapshot
+func stateManifest(c *gin.Context) {
+    source, ok := stateSource(c)
+    if !ok {
+        return
+    }
+    writeSigned(c, applicationOctetStream, source.Manifest.Encode(), c.Query("blockNumber") != "")
+}
+
+// stateChunk serves a chunk of a snapshot
+func stateChunk(c *gin.Context) {
+    index, err := strconv.Atoi(c.Query("index"))
+    if err != nil {
+        c.String(http.StatusBadRequest, "Invalid chunk index")
+        return
+    }
+    source, ok := stateSource(c)
+    if !ok {
+        return
+    }
+    entries, err := source.Chunk(index)
+    if err != nil {
+        c.String(http.StatusBadRequest, err.Error())
+        return
+    }
+    writeSigned(c, applicationOctetStream, statesync.EncodeChunk(index, entries), c.Query("blockNumber") != "")
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+    "encoding/hex"
+    "io"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/events"
+)
+
+// rootEvent is the data of a "root" server-sent event
+type rootEvent struct {
+    BlockNumber int64  `json:"blockNumber"`
+    RootHash    string `json:"rootHash"`
+    Validated   bool   `json:"validated"`
+}
+
+// streamRoots sends a server-sent event for every completed checkpoint
+func streamRoots(c *gin.Context) {
+    ch, cancel := events.SubscribeRoots()
+    defer cancel()
+
+    c.Header("Cache-Control", "no-cache")
+    c.Header("X-Accel-Buffering", "no")
+
+    c.Stream(func(w io.Writer) bool {
+        select {
+        case <-c.Request.Context().Done():
+            return false
+        case event, ok := <-ch:
+            if !ok {
+                return false
+            }
+            c.SSEvent("root", rootEvent{
+                BlockNumber: event.BlockNumber,
+                RootHash:    hex.EncodeToString(event.RootHash),
+                Validated:   event.Validated,
+            })
+            return true
+        }
+    })
+}
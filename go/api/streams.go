@@ -0,0 +1,43 @@
+package api
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/stream"
+)
+
+// registerStreamRoutes registers the payment streams
+func registerStreamRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    // An open stream with what its receiver can withdraw as of the last checked block
+    routes.GET("/streams/:id", func(c *gin.Context) {
+        id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+        if err != nil {
+            c.String(http.StatusBadRequest, "Invalid stream ID")
+            return
+        }
+        lastCheckedBlock, err := db.GetLastCheckedBlockContext(c.Request.Context())
+        if err != nil {
+            internalError(c, "Failed to read the last checked block", err)
+            return
+        }
+        open, ok, err := stream.Lookup(db, id)
+        if err != nil {
+            internalError(c, "Failed to read stream", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "No open stream with this ID")
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "stream":       open,
+            "end":          open.End(),
+            "vested":       open.Vested(lastCheckedBlock).String(),
+            "withdrawable": open.Withdrawable(lastCheckedBlock).String(),
+            "block":        lastCheckedBlock,
+        })
+    })
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/swap"
+    "pwr-stateful-vida/tokens"
+)
+
+// swapPool is the response body of /swap/pool
+type swapPool struct {
+    swap.Pool
+    // ProviderShares are the shares of the provider query parameter
+    ProviderShares string `json:"providerShares,omitempty"`
+    // AmountOut is what a swap of amountIn of tokenIn would pay out
+    AmountOut string `json:"amountOut,omitempty"`
+}
+
+// registerSwapRoutes registers /swap/pool
+func registerSwapRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/swap/pool", func(c *gin.Context) {
+        pool, err := swap.Lookup(db, c.Query("tokenA"), c.Query("tokenB"))
+        if errors.Is(err, swap.ErrInvalidPair) {
+            c.String(http.StatusBadRequest, err.Error())
+            return
+        }
+        if err != nil {
+            internalError(c, "Failed to read pool", err)
+            return
+        }
+        response := swapPool{Pool: pool}
+        if providerHex := c.Query("provider"); providerHex != "" {
+            provider, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(providerHex), "0x"))
+            if err != nil || len(provider) != dbservice.AddressLength {
+                c.String(http.StatusBadRequest, "Invalid provider")
+                return
+            }
+            shares, err := swap.Shares(db, pool.TokenA, pool.TokenB, provider)
+            if err != nil {
+                internalError(c, "Failed to read pool", err)
+                return
+            }
+            response.ProviderShares = shares.String()
+        }
+        if value := c.Query("amountIn"); value != "" {
+            amountIn, ok := new(big.Int).SetString(value, 10)
+            if !ok || amountIn.Sign() <= 0 {
+                c.String(http.StatusBadRequest, "Invalid amountIn: "+value)
+                return
+            }
+            tokenIn := c.DefaultQuery("tokenIn", pool.TokenA)
+            if tokens.IsNative(tokenIn) {
+                tokenIn = tokens.Native
+            }
+            if tokenIn != pool.TokenA && tokenIn != pool.TokenB {
+                c.String(http.StatusBadRequest, "tokenIn is not in the pool: "+tokenIn)
+                return
+            }
+            tokenOut := pool.TokenA
+            if tokenIn == pool.TokenA {
+                tokenOut = pool.TokenB
+            }
+            genesis, ok := genesisParams(db, c)
+            if !ok {
+                return
+            }
+            amountOut, err := swap.Quote(db, tokenIn, tokenOut, amountIn, genesis.Swap.FeeBasisPoints)
+            if err == nil {
+                response.AmountOut = amountOut.String()
+            }
+        }
+        c.JSON(http.StatusOK, response)
+    })
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// tokenBalance is the response body of /token/:id/balance
+type tokenBalance struct {
+    Token   string `json:"token"`
+    Address string `json:"address"`
+    Balance string `json:"balance"`
+}
+
+// registerTokenRoutes registers the metadata and balances of registered tokens
+func registerTokenRoutes(db *dbservice.DatabaseService, routes *gin.RouterGroup) {
+    routes.GET("/token/:id", func(c *gin.Context) {
+        id := c.Param("id")
+        if !tokens.ValidID(id) {
+            c.String(http.StatusBadRequest, "Invalid token ID")
+            return
+        }
+        metadata, ok, err := tokens.Lookup(db, id)
+        if err != nil {
+            internalError(c, "Failed to read token metadata", err)
+            return
+        }
+        if !ok {
+            c.String(http.StatusNotFound, "Token not registered: "+id)
+            return
+        }
+        c.JSON(http.StatusOK, metadata)
+    })
+
+    routes.GET("/token/:id/balance", func(c *gin.Context) {
+        id := c.Param("id")
+        if !tokens.IsNative(id) && !tokens.ValidID(id) {
+            c.String(http.StatusBadRequest, "Invalid token ID")
+            return
+        }
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Query("address")), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        balance, err := tokens.Balance(db, id, address)
+        if err != nil {
+            internalError(c, "Failed to read balance", err)
+            return
+        }
+        c.JSON(http.StatusOK, tokenBalance{Token: id, Address: hex.EncodeToString(address), Balance: balance.String()})
+    })
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/audit"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/crossvida"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/index"
+    "pwr-stateful-vida/lease"
+    "pwr-stateful-vida/mirror"
+    "pwr-stateful-vida/publish"
+    "pwr-stateful-vida/sdk"
+    "pwr-stateful-vida/txlog"
+
+    "google.golang.org/grpc"
+)
+
+// StateStore is the storage the node keeps its state in
+type StateStore interface {
+    dbservice.Tree
+}
+
+// App is a node: its configuration, the services it depends on and the state of its
+// synchronization. Each App keeps its state in its own database service. The node
+// binary runs app; the host command runs one hosted App per tenant.
+type App struct {
+    // Config is installed with config.Set when the app starts, unless it is hosted
+    Config *config.Config
+    // Store replaces the tree file of the configuration when set
+    Store         StateStore
+    Peers         sdk.PeerClient
+    RPC           sdk.RPCClient
+    PeerAddresses []string
+    // Machine is the application the node syncs, the token ledger of this VIDA
+    // when nil
+    Machine StateMachine
+
+    // db is the database service the app keeps its state in
+    db *dbservice.DatabaseService
+    // hosted apps run under the host command, which serves their API through handler
+    // and keeps the process configuration and servers its own
+    hosted  bool
+    handler http.Handler
+
+    // node runs the sync loop, and is the subscription while syncing
+    node              *sdk.Node
+    subscription      sdk.Subscription
+    feedSubscriptions []sdk.Subscription
+    // crossVidaFeeds buffer the messages of the configured source VIDAs
+    crossVidaFeeds []*crossvida.Feed
+    transactionLog *txlog.Log
+    blockArchive   *archive.Writer
+    auditLog       *audit.Log
+    index          *index.Index
+    publisher      *publish.Publisher
+    mirror         *mirror.Mirror
+    cloudSnapshots *cloudUploader
+    httpServer     *http.Server
+    grpcServer     *grpc.Server
+    // archivedTransactions holds the hashes of the transactions applied since the
+    // last committed block
+    archivedTransactions []string
+    // queuedTransfers are the plain transfers of the current block not applied yet
+    queuedTransfers []queuedTransfer
+    // replicaStop ends following the primary in replica mode, replicaDone is closed
+    // once the follower returned
+    replicaStop chan struct{}
+    replicaDone chan struct{}
+    // lease fences the checkpoints of a failover pair. failoverStop ends the
+    // failover loop, failoverDone is closed once it returned.
+    lease        *lease.File
+    failoverStop chan struct{}
+    failoverDone chan struct{}
+    // adminPaused is set while an operator has paused syncing through the admin
+    // API. adminMutex serializes the admin actions on the subscription.
+    adminPaused atomic.Bool
+    adminMutex  sync.Mutex
+    // deferredFrom is the first block left for the next batch because a memory
+    // budget was reached, or 0 while the whole batch is applied. lastAppliedBlock
+    // is the block of the last transaction applied since the checkpoint.
+    deferredFrom     int
+    lastAppliedBlock int
+    // peerDisagreements counts the consecutive blocks on which each peer reported
+    // a different root
+    peerDisagreements map[string]int
+}
+
+// app is the App of the node binary, keeping its state in the default database
+// service. Before it starts it has no subscription or peers.
+var app = &App{db: dbservice.Default()}
+
+// config returns the configuration of the app, the installed one when it has none
+func (a *App) config() *config.Config {
+    if a.Config != nil {
+        return a.Config
+    }
+    return config.Get()
+}
+
+// NewApp returns an app for cfg that checks root hashes with peers, or with the
+// configured peers when none are given, over HTTP and syncs from the configured RPC
+// node. Replace its fields before Start to use other services.
+func NewApp(cfg *config.Config, peers []string) (*App, error) {
+    peerClient, err := newHTTPPeers(cfg.PeerTLS, cfg.Auth.PeerToken, 10*time.Second)
+    if err != nil {
+        return nil, err
+    }
+    if len(peers) > 0 {
+        logger.Info("using peers from args", "peers", peers)
+    } else {
+        peers = cfg.Peers
+        logger.Info("using configured peers", "peers", peers)
+    }
+    return &App{
+        Config:        cfg,
+        Peers:         peerClient,
+        RPC:           sdk.NewRPCNode(cfg.RPCURL),
+        PeerAddresses: peers,
+        db:            dbservice.Default(),
+    }, nil
+}
+
+// Start installs the configuration and store of the app, opens its logs, starts
+// the APIs and subscribes to the source VIDAs and then to the VIDA from the block
+// after the last checkpoint. In replica mode it follows the primary instead, and
+// in a failover pair it does either depending on whether it takes the lease.
+func (a *App) Start() error {
+    if a.Config != nil && !a.hosted {
+        config.Set(a.Config)
+    }
+    if problems := a.config().Validate(); len(problems) > 0 {
+        return fmt.Errorf("invalid configuration: %v (run config check for details)", problems[0])
+    }
+    if a.Store != nil {
+        a.db.UseTree(a.Store)
+    }
+    if !a.hosted {
+        app = a
+    }
+
+    if cfg := a.config().Audit; cfg.Path != "" {
+        a.auditLog = audit.Open(cfg.Path, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+    }
+    if path := a.config().Index.Path; path != "" {
+        idx, err := a.openIndex(path)
+        if err != nil {
+            a.Stop()
+            return err
+        }
+        a.index = idx
+    }
+    if cfg := a.config().Publish; cfg.Broker != "" {
+        publisher, err := openPublisher(cfg)
+        if err != nil {
+            a.Stop()
+            return err
+        }
+        a.publisher = publisher
+    }
+    if cfg := a.config().Mirror; cfg.DSN != "" {
+        m, err := a.openMirror(cfg)
+        if err != nil {
+            a.Stop()
+            return err
+        }
+        a.mirror = m
+    }
+    if cfg := a.config().CloudSnapshots; cfg.URL != "" {
+        uploader, err := a.openCloudUploader(cfg)
+        if err != nil {
+            a.Stop()
+            return err
+        }
+        a.cloudSnapshots = uploader
+    }
+    if a.auditLog != nil || a.index != nil || a.mirror != nil {
+        a.db.ObserveBalances(a.observeBalance)
+    }
+
+    // Initialize database with initial balances if needed
+    a.initInitialBalances()
+
+    // A replica applies no transactions, so it keeps no transaction log
+    if path := a.config().TxLog; path != "" && a.config().Replica.Primary == "" {
+        log, err := txlog.Open(path)
+        if err != nil {
+            a.Stop()
+            return err
+        }
+        a.transactionLog = log
+    }
+
+    if dir := a.config().ArchiveDir; dir != "" {
+        writer, err := archive.NewWriter(dir)
+        if err != nil {
+            a.Stop()
+            return err
+        }
+        a.blockArchive = writer
+        a.db.TrackChanges(true)
+    }
+
+    if a.hosted {
+        a.handler = a.newRouter()
+    } else {
+        a.startAPIServer()
+        a.startGRPCServer()
+    }
+
+    if primary := a.config().Replica.Primary; primary != "" {
+        if err := a.startReplica(primary); err != nil {
+            a.Stop()
+            return err
+        }
+        return nil
+    }
+    if a.config().Failover.Peer != "" {
+        if err := a.startFailover(); err != nil {
+            a.Stop()
+            return err
+        }
+        return nil
+    }
+
+    a.startSync()
+    return nil
+}
+
+// Handler returns the HTTP API of a hosted app once it started
+func (a *App) Handler() http.Handler {
+    return a.handler
+}
+
+// Halted reports whether the app stopped applying blocks after a failed revert
+func (a *App) Halted() bool {
+    return a.syncHalted()
+}
+
+// startSync subscribes to the source VIDAs and then to the VIDA from the block after
+// the last checkpoint
+func (a *App) startSync() {
+    // Subscribe to the source VIDAs first, so their messages can be delivered
+    a.startCrossVidaFeeds()
+    if err := a.syncNode().Start(); err != nil {
+        syncLogger.Error("failed to start synchronization", "error", err)
+        return
+    }
+    a.subscription = a.node
+    syncLogger.Info("subscribed to VIDA transactions", "vidaId", a.config().VidaID)
+}
+
+// stopSync ends the subscriptions after the batches in progress
+func (a *App) stopSync() {
+    if a.subscription != nil {
+        a.subscription.Stop()
+        a.subscription = nil
+    }
+    for _, subscription := range a.feedSubscriptions {
+        subscription.Stop()
+    }
+    a.feedSubscriptions, a.crossVidaFeeds = nil, nil
+}
+
+// Stop ends the subscriptions after the batches in progress, shuts the APIs down
+// and closes the logs of the app. The database stays open.
+func (a *App) Stop() {
+    a.stopFailover()
+    a.stopSync()
+    a.stopReplica()
+    a.releaseLease()
+    if a.httpServer != nil {
+        a.httpServer.Close()
+        a.httpServer = nil
+    }
+    if a.grpcServer != nil {
+        a.grpcServer.Stop()
+        a.grpcServer = nil
+    }
+    if a.transactionLog != nil {
+        a.transactionLog.Close()
+        a.transactionLog = nil
+    }
+    a.db.ObserveBalances(nil)
+    if a.auditLog != nil {
+        a.auditLog.Close()
+        a.auditLog = nil
+    }
+    if a.index != nil {
+        a.index.Close()
+        a.index = nil
+    }
+    if a.publisher != nil {
+        a.publisher.Close()
+        a.publisher = nil
+    }
+    if a.mirror != nil {
+        a.mirror.Close()
+        a.mirror = nil
+    }
+    if a.cloudSnapshots != nil {
+        a.cloudSnapshots.Close()
+        a.cloudSnapshots = nil
+    }
+    if a.blockArchive != nil {
+        a.db.TrackChanges(false)
+        a.blockArchive = nil
+    }
+    logger.Info("node stopped")
+}
+
+// syncNode returns the node running the sync loop of the app, creating it on first
+// use. Commands replaying logged blocks use it without starting it.
+func (a *App) syncNode() *sdk.Node {
+    if a.node == nil {
+        cfg := a.config()
+        a.node = sdk.New(sdk.Config{
+            VidaID:     cfg.VidaID,
+            StartBlock: cfg.StartBlock,
+            Peers:      a.PeerAddresses,
+            DB:         a.db,
+            Machine:    a.machine(),
+            Hooks: sdk.Hooks{
+                Skip:         a.skipTransaction,
+                Received:     a.receiveTransaction,
+                Applied:      a.recordTransaction,
+                Prepare:      a.prepareCheckpoint,
+                Validate:     a.checkRootHashValidityAndSave,
+                Checkpointed: a.logCheckpoint,
+                Flushed:      a.commitCheckpoint,
+                Discarded:    a.discardPending,
+                Halted:       a.onHalt,
+                Panicked:     onCheckpointPanic,
+            },
+        }, a.RPC, a.Peers)
+    }
+    return a.node
+}
@@ -0,0 +1,248 @@
+// Package archive stores a record of every committed block: its root hash, the
+// transactions applied in it and the state it changed.
+package archive
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// segmentBlocks is the number of blocks covered by one archive file
+const segmentBlocks = 10000
+
+// ErrNotFound is returned when no record covers the requested block
+var ErrNotFound = errors.New("block not archived")
+
+// Change is a state key written in a block, hex encoded. Old is empty for new keys.
+// The changes of a record are in the order their keys were first written, so new
+// keys can be added to a tree in the same order to reproduce its root hash.
+type Change struct {
+    Key string `json:"key"`
+    Old string `json:"old,omitempty"`
+    New string `json:"new"`
+}
+
+// Record describes a committed block. The node commits batches of blocks, so a
+// record covers every block after the previous record up to BlockNumber.
+type Record struct {
+    BlockNumber  int64    `json:"blockNumber"`
+    RootHash     string   `json:"rootHash"`
+    Transactions []string `json:"transactions"`
+    Changes      []Change `json:"changes"`
+}
+
+// segmentName returns the file holding the record of blockNumber
+func segmentName(blockNumber int64) string {
+    return strconv.FormatInt(blockNumber/segmentBlocks*segmentBlocks, 10) + ".jsonl"
+}
+
+// Writer appends records to the segment files of a directory
+type Writer struct {
+    mutex sync.Mutex
+    dir   string
+}
+
+// NewWriter returns a writer for dir, creating it if needed
+func NewWriter(dir string) (*Writer, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+    return &Writer{dir: dir}, nil
+}
+
+// Append writes record to its segment file and syncs it
+func (w *Writer) Append(record Record) error {
+    line, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    file, err := os.OpenFile(filepath.Join(w.dir, segmentName(record.BlockNumber)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    if _, err := file.Write(append(line, '\n')); err != nil {
+        return err
+    }
+    return file.Sync()
+}
+
+// segments returns the segment files of dir ordered by their first block
+func segments(dir string) ([]int64, error) {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    var starts []int64
+    for _, entry := range entries {
+        start, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".jsonl"), 10, 64)
+        if err == nil && strings.HasSuffix(entry.Name(), ".jsonl") {
+            starts = append(starts, start)
+        }
+    }
+    sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+    return starts, nil
+}
+
+// Find returns the record covering blockNumber, which is the first record at or
+// after it. A block reprocessed after a rollback has several records; the last one wins.
+func Find(dir string, blockNumber int64) (*Record, error) {
+    starts, err := segments(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, start := range starts {
+        if start+segmentBlocks <= blockNumber {
+            continue
+        }
+        var found *Record
+        err := forEach(filepath.Join(dir, strconv.FormatInt(start, 10)+".jsonl"), func(record Record) {
+            if record.BlockNumber < blockNumber {
+                return
+            }
+            if found == nil || record.BlockNumber <= found.BlockNumber {
+                copy := record
+                found = &copy
+            }
+        })
+        if err != nil {
+            return nil, err
+        }
+        if found != nil {
+            return found, nil
+        }
+    }
+    return nil, ErrNotFound
+}
+
+// forEach calls fn for every record of a segment file
+func forEach(path string, fn func(Record)) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+    for scanner.Scan() {
+        var record Record
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            return fmt.Errorf("%s: %v", path, err)
+        }
+        fn(record)
+    }
+    return scanner.Err()
+}
+
+// Between returns the records after block from up to and including block to, in
+// block order. Where a block was archived more than once the last record wins.
+func Between(dir string, from, to int64) ([]Record, error) {
+    starts, err := segments(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    byBlock := make(map[int64]Record)
+    for _, start := range starts {
+        if start+segmentBlocks <= from || start > to {
+            continue
+        }
+        err := forEach(filepath.Join(dir, strconv.FormatInt(start, 10)+".jsonl"), func(record Record) {
+            if record.BlockNumber > from && record.BlockNumber <= to {
+                byBlock[record.BlockNumber] = record
+            }
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    records := make([]Record, 0, len(byBlock))
+    for _, record := range byBlock {
+        records = append(records, record)
+    }
+    sort.Slice(records, func(i, j int) bool { return records[i].BlockNumber < records[j].BlockNumber })
+    return records, nil
+}
+
+// After returns up to limit records after block from, in block order, reading no
+// more segment files than needed. Where a block was archived more than once the
+// last record wins.
+func After(dir string, from int64, limit int) ([]Record, error) {
+    starts, err := segments(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var records []Record
+    for _, start := range starts {
+        if start+segmentBlocks <= from {
+            continue
+        }
+        byBlock := make(map[int64]Record)
+        err := forEach(filepath.Join(dir, strconv.FormatInt(start, 10)+".jsonl"), func(record Record) {
+            if record.BlockNumber > from {
+                byBlock[record.BlockNumber] = record
+            }
+        })
+        if err != nil {
+            return nil, err
+        }
+        for _, record := range byBlock {
+            records = append(records, record)
+        }
+        if len(records) >= limit {
+            break
+        }
+    }
+
+    sort.Slice(records, func(i, j int) bool { return records[i].BlockNumber < records[j].BlockNumber })
+    if len(records) > limit {
+        records = records[:limit]
+    }
+    return records, nil
+}
+
+// Prune removes the segment files holding only blocks before cutoff, returning the
+// number of files and bytes removed
+func Prune(dir string, cutoff int64) (int, int64, error) {
+    starts, err := segments(dir)
+    if os.IsNotExist(err) {
+        return 0, 0, nil
+    }
+    if err != nil {
+        return 0, 0, err
+    }
+
+    files, freed := 0, int64(0)
+    for _, start := range starts {
+        if start+segmentBlocks > cutoff {
+            break
+        }
+        path := filepath.Join(dir, strconv.FormatInt(start, 10)+".jsonl")
+        info, err := os.Stat(path)
+        if err != nil {
+            return files, freed, err
+        }
+        if err := os.Remove(path); err != nil {
+            return files, freed, err
+        }
+        files++
+        freed += info.Size()
+    }
+    return files, freed, nil
+}
@@ -0,0 +1,141 @@
+// Package audit keeps an append-only log of every committed balance change and the
+// transaction that caused it.
+package audit
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "math/big"
+    "os"
+    "slices"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/logfile"
+)
+
+// Entry is a line of the audit log. Tx is empty for changes made outside a
+// transaction, such as the initial balances.
+type Entry struct {
+    Time    time.Time `json:"time"`
+    Block   int64     `json:"block"`
+    Tx      string    `json:"tx,omitempty"`
+    Address string    `json:"address"`
+    Old     string    `json:"old"`
+    New     string    `json:"new"`
+}
+
+// Log buffers the balance changes of a batch and appends them once the batch is
+// committed, so reverted changes never reach the file
+type Log struct {
+    writer *logfile.Writer
+
+    mutex   sync.Mutex
+    tx      string
+    block   int64
+    pending []Entry
+}
+
+// Open returns a log appending to path. The file is rotated once it grows past
+// maxSize bytes, keeping maxBackups rotated files; zero values keep everything.
+func Open(path string, maxSize int64, maxBackups int) *Log {
+    return &Log{writer: &logfile.Writer{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}}
+}
+
+// SetTransaction attributes the following changes to a transaction
+func (l *Log) SetTransaction(hash string, block int64) {
+    l.mutex.Lock()
+    l.tx, l.block = hash, block
+    l.mutex.Unlock()
+}
+
+// Record buffers a balance change of the current transaction
+func (l *Log) Record(address []byte, old, new *big.Int) {
+    if old.Cmp(new) == 0 {
+        return
+    }
+    l.mutex.Lock()
+    l.pending = append(l.pending, Entry{
+        Time:    time.Now().UTC(),
+        Block:   l.block,
+        Tx:      l.tx,
+        Address: hex.EncodeToString(address),
+        Old:     old.String(),
+        New:     new.String(),
+    })
+    l.mutex.Unlock()
+}
+
+// Commit appends the buffered changes and syncs the file
+func (l *Log) Commit() error {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+
+    l.tx = ""
+    if len(l.pending) == 0 {
+        return nil
+    }
+    for i, entry := range l.pending {
+        line, _ := json.Marshal(entry)
+        if _, err := l.writer.Write(append(line, '\n')); err != nil {
+            // Keep the entries that were not written for the next commit
+            l.pending = l.pending[i:]
+            return err
+        }
+    }
+    l.pending = nil
+    return l.writer.Sync()
+}
+
+// Discard drops the buffered changes of a reverted batch
+func (l *Log) Discard() {
+    l.mutex.Lock()
+    l.tx = ""
+    l.pending = nil
+    l.mutex.Unlock()
+}
+
+// Close closes the file
+func (l *Log) Close() error {
+    return l.writer.Close()
+}
+
+// History returns the last limit changes of the balance of address, newest first,
+// from the log at path and its rotated files
+func History(path, address string, limit int) ([]Entry, error) {
+    files, err := logfile.Files(path)
+    if err != nil {
+        return nil, err
+    }
+    var entries []Entry
+    for _, name := range files {
+        file, err := os.Open(name)
+        if err != nil {
+            return nil, err
+        }
+        scanner := bufio.NewScanner(file)
+        scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+        for scanner.Scan() {
+            // Matching the text first skips decoding the lines of other accounts
+            if !bytes.Contains(scanner.Bytes(), []byte(address)) {
+                continue
+            }
+            var entry Entry
+            if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Address == address {
+                entries = append(entries, entry)
+            }
+        }
+        err = scanner.Err()
+        file.Close()
+        if err != nil {
+            return nil, err
+        }
+        if len(entries) > limit {
+            entries = entries[len(entries)-limit:]
+        }
+    }
+    slices.Reverse(entries)
+    return entries, nil
+}
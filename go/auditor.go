@@ -0,0 +1,77 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "time"
+
+    "pwr-stateful-vida/alert"
+    "pwr-stateful-vida/events"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/verification"
+)
+
+// verificationLog receives the per-block reports in auditor mode
+var verificationLog *verification.Log
+
+// auditRootHash compares the local root of a checkpoint with every peer and reports
+// the outcome. An auditor keeps the state it computed whether or not the peers agree,
+// since its purpose is to show where the network diverges from it.
+func (a *App) auditRootHash(blockNumber int, localRoot []byte) bool {
+    report := verification.Report{
+        Time:      time.Now().UTC(),
+        Block:     int64(blockNumber),
+        LocalRoot: hex.EncodeToString(localRoot),
+        Peers:     []verification.PeerResult{},
+    }
+
+    answered := 0
+    for _, peer := range a.PeerAddresses {
+        result := verification.PeerResult{Peer: peer}
+        if success, peerRoot := a.Peers.RootHash(peer, blockNumber); success && peerRoot != nil {
+            answered++
+            result.RootHash = hex.EncodeToString(peerRoot)
+            result.Agreed = string(peerRoot) == string(localRoot)
+            if result.Agreed {
+                report.Matches++
+            } else {
+                metrics.PeerMismatches.Inc(peer)
+            }
+            a.recordPeerAgreement(peer, result.Agreed, blockNumber)
+        }
+        report.Peers = append(report.Peers, result)
+    }
+    report.Quorum = (answered*2)/3 + 1
+    report.Agreed = answered > 0 && report.Matches >= report.Quorum
+
+    a.db.SetBlockRootHash(blockNumber, localRoot)
+    a.publishRoot(events.RootEvent{BlockNumber: int64(blockNumber), RootHash: localRoot, Validated: report.Agreed})
+    if report.Agreed {
+        peerLogger.Info("network agrees with the audited root", "block", blockNumber, "matches", report.Matches, "quorum", report.Quorum)
+    } else {
+        peerLogger.Warn("network disagrees with the audited root", "block", blockNumber, "matches", report.Matches, "answered", answered)
+        alerts.Raise(alert.Alert{
+            Kind:     alert.KindRootMismatch,
+            Severity: alert.Critical,
+            Message:  fmt.Sprintf("audited root hash for block %d reached only %d/%d answering peers", blockNumber, report.Matches, answered),
+            Block:    int64(blockNumber),
+        })
+    }
+
+    if err := verificationLog.Append(report); err != nil {
+        peerLogger.Error("failed to write verification report", "block", blockNumber, "error", err)
+        reporting.Report(err, reporting.Context{Module: "sync", Block: int64(blockNumber)})
+    }
+    return true
+}
+
+// setupAuditor opens the verification log when the node runs in auditor mode
+func (a *App) setupAuditor() {
+    cfg := a.config().Auditor
+    if !cfg.Enabled {
+        return
+    }
+    verificationLog = verification.Open(cfg.ReportPath, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+    logger.Info("running in auditor mode, peer endpoints are disabled", "reports", cfg.ReportPath)
+}
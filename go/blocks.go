@@ -0,0 +1,46 @@
+package main
+
+import (
+    "context"
+
+    "pwr-stateful-vida/distribution"
+    "pwr-stateful-vida/fees"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/staking"
+)
+
+// blockHooks advance block driven state, in this order, before the first
+// transaction applied in a block. Snapshots come first so they see the balances
+// left by the previous transactions.
+var blockHooks = []struct {
+    name    string
+    advance func(a *App, block int64) error
+}{
+    {"distribution", func(a *App, block int64) error { return distribution.BeginBlock(a.db, block) }},
+    {"staking", func(a *App, block int64) error { return staking.BeginBlock(a.db, block, a.stakingParams()) }},
+    {"fees", func(a *App, block int64) error { return fees.BeginBlock(a.db, block, a.feeParams()) }},
+    {"crossVida", (*App).deliverCrossVidaMessages},
+}
+
+// endBlock completes a block once the first transaction of the next one arrives,
+// applying its queued transfers and exposing its state to API reads
+func (a *App) endBlock(ctx context.Context, block int64) {
+    a.flushTransfers()
+    if err := a.db.EndBlock(block); err != nil {
+        syncLogger.ErrorContext(ctx, "failed to complete block", "block", block, "error", err)
+        reporting.Report(err, reporting.Context{Module: "handler", Block: block, CorrelationID: logging.CorrelationID(ctx)})
+    }
+}
+
+// beginBlock advances the block driven state to the block of a transaction before
+// it is applied. It runs from the transactions rather than from checkpoints, whose
+// boundaries differ between nodes, so every node makes the same changes.
+func (a *App) beginBlock(ctx context.Context, block int64) {
+    for _, hook := range blockHooks {
+        if err := hook.advance(a, block); err != nil {
+            syncLogger.ErrorContext(ctx, "failed to advance block state", "module", hook.name, "block", block, "error", err)
+            reporting.Report(err, reporting.Context{Module: "handler", Block: block, Action: hook.name, CorrelationID: logging.CorrelationID(ctx)})
+        }
+    }
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strings"
+
+    "pwr-stateful-vida/bridge"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons a bridge action is rejected
+const (
+    failureUnknownToken       = "unknown_token"
+    failureAlreadyBridged     = "already_bridged"
+    failureWithdrawalNotFound = "withdrawal_not_found"
+    failureAlreadyReleased    = "already_released"
+)
+
+// isBridgeOperator reports whether the sender of a transaction may mint wrapped
+// tokens and record releases
+func (a *App) isBridgeOperator(senderHex string) bool {
+    sender := strings.TrimPrefix(strings.ToLower(senderHex), "0x")
+    for _, operator := range a.chainParams().Bridge.Operators {
+        if strings.TrimPrefix(strings.ToLower(operator), "0x") == sender {
+            return true
+        }
+    }
+    return false
+}
+
+// bridgeMintPayload is the payload of a bridgeMint
+type bridgeMintPayload struct {
+    envelope
+    Token    string `json:"token"`
+    Receiver string `json:"receiver"`
+    Amount   number `json:"amount"`
+    // ExternalTx is the deposit on the other chain, which can only be bridged once
+    ExternalTx string `json:"externalTx"`
+
+    receiver []byte
+}
+
+func (p *bridgeMintPayload) validate(a *App) error {
+    if p.receiver = payloadAddress(p.Receiver); p.receiver == nil {
+        return invalidField("receiver", "must be an address")
+    }
+    return nil
+}
+
+// bridgeBurnPayload is the payload of a bridgeBurn
+type bridgeBurnPayload struct {
+    envelope
+    Token  string `json:"token"`
+    Amount number `json:"amount"`
+    // Destination is the receiver on the other chain
+    Destination string `json:"destination"`
+}
+
+// bridgeReleasePayload is the payload of a bridgeRelease
+type bridgeReleasePayload struct {
+    envelope
+    Withdrawal string `json:"withdrawal"`
+    ExternalTx string `json:"externalTx"`
+}
+
+// handleBridgeMint mints wrapped tokens for a deposit on the other chain, sent by an
+// operator. It returns the reason the mint was rejected, or an empty string on
+// success.
+func (a *App) handleBridgeMint(ctx context.Context, p *bridgeMintPayload, transaction rpc.VidaDataTransaction) string {
+    sender, failure := a.bridgeSender(ctx, transaction, true)
+    if failure != "" {
+        return failure
+    }
+    amount := a.parseTokenAmount(p.Amount, p.Token)
+    if amount == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid bridge mint", "payload", p)
+        return failureInvalidAmount
+    }
+    err := bridge.Mint(a.db, sender, p.receiver, p.Token, amount, p.ExternalTx, int64(transaction.BlockNumber))
+    return bridgeResult(ctx, err, "bridgeMint", p.Token, p, transaction.Sender)
+}
+
+// handleBridgeBurn burns wrapped tokens of the sender to be paid out on the other
+// chain. It returns the reason the burn was rejected, or an empty string on success.
+func (a *App) handleBridgeBurn(ctx context.Context, p *bridgeBurnPayload, transaction rpc.VidaDataTransaction) string {
+    sender, failure := a.bridgeSender(ctx, transaction, false)
+    if failure != "" {
+        return failure
+    }
+    amount := a.parseTokenAmount(p.Amount, p.Token)
+    if amount == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid bridge burn", "payload", p)
+        return failureInvalidAmount
+    }
+    err := bridge.Burn(a.db, sender, p.Token, amount, p.Destination, transaction.Hash, int64(transaction.BlockNumber))
+    return bridgeResult(ctx, err, "bridgeBurn", p.Token, p, transaction.Sender)
+}
+
+// handleBridgeRelease records that an operator paid out a withdrawal on the other
+// chain. It returns the reason the release was rejected, or an empty string on
+// success.
+func (a *App) handleBridgeRelease(ctx context.Context, p *bridgeReleasePayload, transaction rpc.VidaDataTransaction) string {
+    sender, failure := a.bridgeSender(ctx, transaction, true)
+    if failure != "" {
+        return failure
+    }
+    err := bridge.Release(a.db, sender, p.Withdrawal, p.ExternalTx, int64(transaction.BlockNumber))
+    return bridgeResult(ctx, err, "bridgeRelease", "", p, transaction.Sender)
+}
+
+// bridgeSender returns the sender of a bridge action, checking that it is an
+// operator when the action needs one
+func (a *App) bridgeSender(ctx context.Context, transaction rpc.VidaDataTransaction, operator bool) ([]byte, string) {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return nil, failureInvalidPayload
+    }
+    if operator && !a.isBridgeOperator(transaction.Sender) {
+        syncLogger.WarnContext(ctx, "bridge action from a non-operator", "sender", transaction.Sender)
+        return nil, failureUnauthorized
+    }
+    return sender, ""
+}
+
+// bridgeResult maps the result of a bridge action to the reason it was rejected, or
+// an empty string on success
+func bridgeResult(ctx context.Context, err error, action, token string, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, bridge.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid bridge action", "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, bridge.ErrUnknownToken):
+        syncLogger.InfoContext(ctx, "bridge action failed: token not registered", "action", action, "token", token, "sender", senderHex)
+        return failureUnknownToken
+    case errors.Is(err, bridge.ErrDuplicate):
+        syncLogger.WarnContext(ctx, "bridge mint failed: external transaction already bridged", "payload", payload, "sender", senderHex)
+        return failureAlreadyBridged
+    case errors.Is(err, bridge.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, "bridge burn failed: insufficient funds", "token", token, "sender", senderHex)
+        return failureInsufficientFunds
+    case errors.Is(err, bridge.ErrNotFound):
+        syncLogger.InfoContext(ctx, "bridge release failed: withdrawal not found", "payload", payload, "sender", senderHex)
+        return failureWithdrawalNotFound
+    case errors.Is(err, bridge.ErrReleased):
+        syncLogger.WarnContext(ctx, "bridge release failed: already released", "payload", payload, "sender", senderHex)
+        return failureAlreadyReleased
+    case err != nil:
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        action,
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "token": token},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "bridge action applied", "action", action, "token", token, "sender", senderHex)
+    return ""
+}
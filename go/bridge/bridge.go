@@ -0,0 +1,226 @@
+// Package bridge represents assets locked on other chains as registered tokens. A
+// bridge operator mints the wrapped token once the asset is locked elsewhere, and a
+// holder burns it to have the operator release the asset there. Every deposit,
+// withdrawal and the minted and burned totals of each token are kept in the Merkle
+// state, so the wrapped supply can be audited against the locked assets.
+package bridge
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "regexp"
+    "strings"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// Errors of rejected bridge actions
+var (
+    ErrInvalid           = errors.New("invalid bridge action")
+    ErrUnknownToken      = errors.New("token not registered")
+    ErrDuplicate         = errors.New("external transaction already bridged")
+    ErrNotFound          = errors.New("withdrawal not found")
+    ErrReleased          = errors.New("withdrawal already released")
+    ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// MaxDestinationLength is the longest external address a withdrawal can name
+const MaxDestinationLength = 128
+
+// refPattern matches the references of transactions on other chains
+var refPattern = regexp.MustCompile(`^[A-Za-z0-9:._-]{1,128}$`)
+
+// Deposit is a mint of a wrapped token for an asset locked on another chain
+type Deposit struct {
+    Ref      string `json:"ref"`
+    Token    string `json:"token"`
+    Receiver string `json:"receiver"`
+    Amount   string `json:"amount"`
+    Operator string `json:"operator"`
+    Block    int64  `json:"block"`
+}
+
+// Withdrawal is a burn of a wrapped token whose asset an operator releases to
+// Destination on the other chain
+type Withdrawal struct {
+    Hash        string `json:"hash"`
+    Token       string `json:"token"`
+    Account     string `json:"account"`
+    Amount      string `json:"amount"`
+    Destination string `json:"destination"`
+    Block       int64  `json:"block"`
+    Released    bool   `json:"released"`
+    ReleaseRef  string `json:"releaseRef,omitempty"`
+    ReleasedBy  string `json:"releasedBy,omitempty"`
+    ReleasedAt  int64  `json:"releasedAt,omitempty"`
+}
+
+// Supply is what the bridge minted and burned of a token. Outstanding, their
+// difference, is the amount that should be locked on the other chain.
+type Supply struct {
+    Token       string `json:"token"`
+    Minted      string `json:"minted"`
+    Burned      string `json:"burned"`
+    Outstanding string `json:"outstanding,omitempty"`
+}
+
+// key returns the tree key of a bridge record
+func key(kind, id string) []byte {
+    return []byte(dbservice.BridgePrefix + kind + "/" + id)
+}
+
+// ValidRef reports whether ref can name a transaction on another chain
+func ValidRef(ref string) bool {
+    return refPattern.MatchString(ref)
+}
+
+// LookupDeposit returns the deposit minted for an external transaction
+func LookupDeposit(db *dbservice.DatabaseService, ref string) (Deposit, bool, error) {
+    var deposit Deposit
+    if !ValidRef(ref) {
+        return deposit, false, nil
+    }
+    found, err := db.LoadJSON(key("deposit", ref), &deposit)
+    return deposit, found, err
+}
+
+// normalizeHash returns a transaction hash in lower case without 0x prefix
+func normalizeHash(hash string) string {
+    return strings.TrimPrefix(strings.ToLower(hash), "0x")
+}
+
+// LookupWithdrawal returns the withdrawal of a burn transaction
+func LookupWithdrawal(db *dbservice.DatabaseService, hash string) (Withdrawal, bool, error) {
+    hash = normalizeHash(hash)
+    var withdrawal Withdrawal
+    if !ValidRef(hash) {
+        return withdrawal, false, nil
+    }
+    found, err := db.LoadJSON(key("withdrawal", hash), &withdrawal)
+    return withdrawal, found, err
+}
+
+// SupplyOf returns the bridged supply of a token
+func SupplyOf(db *dbservice.DatabaseService, token string) (Supply, error) {
+    supply := Supply{Token: token, Minted: "0", Burned: "0", Outstanding: "0"}
+    if _, err := db.LoadJSON(key("supply", token), &supply); err != nil {
+        return supply, err
+    }
+    minted := dbservice.ParseAmount(supply.Minted)
+    burned := dbservice.ParseAmount(supply.Burned)
+    supply.Outstanding = new(big.Int).Sub(minted, burned).String()
+    return supply, nil
+}
+
+// addSupply adds to the minted or burned total of a token
+func addSupply(db *dbservice.DatabaseService, token string, minted, burned *big.Int) error {
+    supply, err := SupplyOf(db, token)
+    if err != nil {
+        return err
+    }
+    total := dbservice.ParseAmount(supply.Minted)
+    supply.Minted = total.Add(total, minted).String()
+    total = dbservice.ParseAmount(supply.Burned)
+    supply.Burned = total.Add(total, burned).String()
+    supply.Outstanding = ""
+    return db.SaveJSON(key("supply", token), supply)
+}
+
+// checkToken fails unless token is a registered token other than the native one
+func checkToken(db *dbservice.DatabaseService, token string) error {
+    if tokens.IsNative(token) {
+        return fmt.Errorf("%w: the native token cannot be bridged", ErrInvalid)
+    }
+    if _, found, err := tokens.Lookup(db, token); err != nil || !found {
+        if err != nil {
+            return err
+        }
+        return ErrUnknownToken
+    }
+    return nil
+}
+
+// Mint credits amount of a wrapped token to receiver for the external transaction
+// ref, which can only be minted once
+func Mint(db *dbservice.DatabaseService, operator, receiver []byte, token string, amount *big.Int, ref string, block int64) error {
+    if !ValidRef(ref) {
+        return fmt.Errorf("%w: the external transaction must be 1 to 128 letters, digits, ':', '.', '_' or '-'", ErrInvalid)
+    }
+    if err := checkToken(db, token); err != nil {
+        return err
+    }
+    if _, found, err := LookupDeposit(db, ref); err != nil || found {
+        if found {
+            return ErrDuplicate
+        }
+        return err
+    }
+    if err := tokens.Credit(db, token, receiver, amount); err != nil {
+        return err
+    }
+    if err := addSupply(db, token, amount, new(big.Int)); err != nil {
+        return err
+    }
+    return db.SaveJSON(key("deposit", ref), Deposit{
+        Ref:      ref,
+        Token:    token,
+        Receiver: hex.EncodeToString(receiver),
+        Amount:   amount.String(),
+        Operator: hex.EncodeToString(operator),
+        Block:    block,
+    })
+}
+
+// Burn removes amount of a wrapped token from account and records a withdrawal to
+// destination under the hash of the burn transaction
+func Burn(db *dbservice.DatabaseService, account []byte, token string, amount *big.Int, destination, hash string, block int64) error {
+    if destination == "" || len(destination) > MaxDestinationLength {
+        return fmt.Errorf("%w: the destination must be 1 to %d characters", ErrInvalid, MaxDestinationLength)
+    }
+    if err := checkToken(db, token); err != nil {
+        return err
+    }
+    ok, err := tokens.Debit(db, token, account, amount)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return ErrInsufficientFunds
+    }
+    if err := addSupply(db, token, new(big.Int), amount); err != nil {
+        return err
+    }
+    hash = normalizeHash(hash)
+    return db.SaveJSON(key("withdrawal", hash), Withdrawal{
+        Hash:        hash,
+        Token:       token,
+        Account:     hex.EncodeToString(account),
+        Amount:      amount.String(),
+        Destination: destination,
+        Block:       block,
+    })
+}
+
+// Release records that operator released the asset of a withdrawal on the other
+// chain in the external transaction ref
+func Release(db *dbservice.DatabaseService, operator []byte, hash, ref string, block int64) error {
+    if !ValidRef(ref) {
+        return fmt.Errorf("%w: the external transaction must be 1 to 128 letters, digits, ':', '.', '_' or '-'", ErrInvalid)
+    }
+    withdrawal, found, err := LookupWithdrawal(db, hash)
+    if err != nil {
+        return err
+    }
+    if !found {
+        return ErrNotFound
+    }
+    if withdrawal.Released {
+        return ErrReleased
+    }
+    withdrawal.Released, withdrawal.ReleaseRef = true, ref
+    withdrawal.ReleasedBy, withdrawal.ReleasedAt = hex.EncodeToString(operator), block
+    return db.SaveJSON(key("withdrawal", withdrawal.Hash), withdrawal)
+}
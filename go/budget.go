@@ -0,0 +1,63 @@
+package main
+
+import (
+    "pwr-stateful-vida/metrics"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Memory budgets that cut a batch short, used as metric labels
+const (
+    budgetPendingWrites = "pending_writes"
+    budgetCatchUpQueue  = "catch_up_queue"
+)
+
+// deferTransaction reports whether a transaction is left for the next batch. Batches
+// are only cut between blocks, so that the checkpoint covers whole blocks, and
+// never before their first block, so that every batch makes progress.
+func (a *App) deferTransaction(transaction rpc.VidaDataTransaction) bool {
+    block := transaction.BlockNumber
+    if a.deferredFrom != 0 {
+        return block >= a.deferredFrom
+    }
+    if a.lastAppliedBlock != 0 && block != a.lastAppliedBlock {
+        if budget := a.exceededBudget(); budget != "" {
+            a.deferredFrom = block
+            metrics.DeferredBatches.Inc(budget)
+            syncLogger.Warn("memory budget reached, deferring the rest of the batch",
+                "budget", budget,
+                "fromBlock", block,
+                "pendingWrites", a.db.PendingWrites(),
+                "transactions", len(a.syncNode().Batch()),
+            )
+            return true
+        }
+    }
+    a.lastAppliedBlock = block
+    return false
+}
+
+// exceededBudget returns the memory budget the current batch has reached, if any
+func (a *App) exceededBudget() string {
+    cfg := a.config().Memory
+    if cfg.MaxPendingWrites > 0 && a.db.PendingWrites() >= cfg.MaxPendingWrites {
+        return budgetPendingWrites
+    }
+    if cfg.MaxCatchUpQueue > 0 && len(a.syncNode().Batch()) >= cfg.MaxCatchUpQueue {
+        return budgetCatchUpQueue
+    }
+    return ""
+}
+
+// checkpointBlock returns the block a checkpoint reported for blockNumber commits,
+// which is the last whole block applied when the batch was cut short, and resets
+// the batch budget
+func (a *App) checkpointBlock(blockNumber int) (int, bool) {
+    deferred := a.deferredFrom != 0
+    if deferred {
+        blockNumber = a.deferredFrom - 1
+    }
+    a.deferredFrom = 0
+    a.lastAppliedBlock = 0
+    return blockNumber, deferred
+}
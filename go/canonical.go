@@ -0,0 +1,49 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+
+    "pwr-stateful-vida/canonical"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// applyCanonical applies a transaction by the rules of package canonical, which only
+// know plain transfers, and returns its metric label and failure
+func (a *App) applyCanonical(ctx context.Context, transaction rpc.VidaDataTransaction) (string, string) {
+    data, err := hex.DecodeString(transaction.Data)
+    if err != nil {
+        return "other", failureInvalidPayload
+    }
+    transfer, isTransfer, ok := canonical.ParseTransfer(data)
+    if !isTransfer {
+        return "other", failureUnsupportedAction
+    }
+    sender, validSender := canonical.ParseSender(transaction.Sender)
+    if !ok || !validSender {
+        syncLogger.WarnContext(ctx, "skipping invalid transfer", "hash", transaction.Hash)
+        return "transfer", failureInvalidPayload
+    }
+
+    senderHex, receiverHex := hex.EncodeToString(sender), hex.EncodeToString(transfer.Receiver)
+    success, err := a.db.Transfer(sender, transfer.Receiver, transfer.Amount)
+    if err != nil {
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "transfer",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "receiver": receiverHex},
+        })
+    }
+    if !success {
+        syncLogger.InfoContext(ctx, "transfer failed: insufficient funds", "amount", transfer.Amount, "sender", senderHex, "receiver", receiverHex)
+        return "transfer", failureInsufficientFunds
+    }
+    syncLogger.InfoContext(ctx, "transfer succeeded", "amount", transfer.Amount, "sender", senderHex, "receiver", receiverHex)
+    a.observeTransfer(ctx, int64(transaction.BlockNumber), sender, transfer.Receiver, tokens.Native, transfer.Amount)
+    return "transfer", ""
+}
@@ -0,0 +1,145 @@
+// Package canonical specifies the state of this VIDA independently of any
+// implementation, so that nodes written in other languages compute byte-identical
+// root hashes and can validate checkpoints with each other. A node follows it when
+// the canonical flag of its genesis configuration is set.
+//
+// State. The state is a Merkle tree of key-value leaves in the order their keys were
+// first written. A leaf hashes to Keccak-256(key || value); a parent hashes to
+// Keccak-256(left || right) with an odd last node of a level hashed with itself, up
+// to a single root. Writing an existing key replaces its value in place. An empty
+// tree has no root hash.
+//
+// Keys and values. An account is keyed by its 20 address bytes and holds its
+// balance as a minimal big-endian unsigned integer, zero being the single byte 0x00;
+// a missing key or an empty value reads as zero. The key "lastCheckedBlock" holds
+// the checkpoint block as 8 big-endian bytes, and "blockRootHash_" followed by the
+// block number in decimal ASCII holds the root hash of a validated checkpoint.
+//
+// Genesis. Before the first block, the Genesis accounts are written in their listed
+// order with a balance of GenesisBalance each.
+//
+// Transactions. The data of a transaction is a UTF-8 JSON object; anything else is
+// ignored, as is every action but "transfer", compared ignoring ASCII case. A
+// transfer carries "receiver", a 20 byte address as hex with an optional 0x prefix,
+// and "amount", a positive integer given as a JSON string of decimal digits or as a
+// JSON number without fraction or exponent. A transfer missing either, or with any
+// other form, is ignored. When the balance of the sender is below the amount the
+// transfer fails without writes; otherwise the sender is written with its balance
+// less the amount, then the receiver, read after that write, with its balance plus
+// the amount. Unknown fields are ignored.
+//
+// Checkpoints. At a checkpoint block N a node writes "lastCheckedBlock" = N, takes
+// the root hash and asks its peers for theirs at N. When more than two thirds of the
+// peers that answer agree, it writes "blockRootHash_N" with that root hash and keeps
+// the state; otherwise it discards every write since the previous checkpoint. The
+// root hash of block N is served as lower case hex by GET /rootHash?blockNumber=N.
+//
+// The conformance vectors of Vectors check an implementation against these rules.
+package canonical
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "math/big"
+    "strconv"
+    "strings"
+)
+
+// AddressLength is the length of an account address
+const AddressLength = 20
+
+// BlockRootPrefix is the key prefix of the root hashes of validated checkpoints
+const BlockRootPrefix = "blockRootHash_"
+
+// Genesis lists the initial accounts in the order they are written
+var Genesis = []string{
+    "c767ea1d613eefe0ce1610b18cb047881bafb829",
+    "3b4412f57828d1ceb0dbf0d460f7eb1f21fed8b4",
+    "9282d39ca205806473f4fde5bac48ca6dfb9d300",
+    "e68191b7913e72e6f1759531fbfaa089ff02308a",
+}
+
+// GenesisBalance is the initial balance of every genesis account
+var GenesisBalance = big.NewInt(1_000_000_000_000)
+
+// EncodeBalance returns the stored form of a balance
+func EncodeBalance(balance *big.Int) []byte {
+    if balance.Sign() == 0 {
+        return []byte{0}
+    }
+    return balance.Bytes()
+}
+
+// BlockRootHashKey returns the key of the root hash of a checkpoint block
+func BlockRootHashKey(blockNumber int64) []byte {
+    return []byte(BlockRootPrefix + strconv.FormatInt(blockNumber, 10))
+}
+
+// Transfer is a transfer the rules apply
+type Transfer struct {
+    Receiver []byte
+    Amount   *big.Int
+}
+
+// ParseTransfer reads the transfer in transaction data. It returns false for data
+// the rules ignore, and reports whether the action was a transfer at all.
+func ParseTransfer(data []byte) (transfer Transfer, isTransfer bool, ok bool) {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    var payload map[string]interface{}
+    if err := decoder.Decode(&payload); err != nil || decoder.More() {
+        return transfer, false, false
+    }
+    action, _ := payload["action"].(string)
+    if !equalASCIIFold(action, "transfer") {
+        return transfer, false, false
+    }
+
+    var digits string
+    switch amount := payload["amount"].(type) {
+    case string:
+        digits = amount
+    case json.Number:
+        digits = amount.String()
+    }
+    if digits == "" || strings.Trim(digits, "0123456789") != "" {
+        return transfer, true, false
+    }
+    transfer.Amount, _ = new(big.Int).SetString(digits, 10)
+    if transfer.Amount.Sign() <= 0 {
+        return transfer, true, false
+    }
+
+    receiverHex, _ := payload["receiver"].(string)
+    receiver, err := hex.DecodeString(strings.TrimPrefix(receiverHex, "0x"))
+    if err != nil || len(receiver) != AddressLength {
+        return transfer, true, false
+    }
+    transfer.Receiver = receiver
+    return transfer, true, true
+}
+
+// equalASCIIFold compares strings ignoring ASCII case only, as strings.EqualFold
+// would also match the likes of "tranſfer"
+func equalASCIIFold(s, lower string) bool {
+    if len(s) != len(lower) {
+        return false
+    }
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        if 'A' <= c && c <= 'Z' {
+            c += 'a' - 'A'
+        }
+        if c != lower[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// ParseSender decodes the sender of a transaction, hex with an optional 0x prefix
+func ParseSender(senderHex string) ([]byte, bool) {
+    sender, err := hex.DecodeString(strings.TrimPrefix(senderHex, "0x"))
+    return sender, err == nil && len(sender) == AddressLength
+}
@@ -0,0 +1,83 @@
+package canonical
+
+import (
+    _ "embed"
+    "encoding/json"
+)
+
+//go:embed vectors.json
+var vectorsJSON []byte
+
+// VectorSet holds the conformance vectors. Keys, values and hashes are lower case hex.
+type VectorSet struct {
+    Leaves        []LeafVector         `json:"leaves"`
+    Balances      []BalanceVector      `json:"balances"`
+    BlockRootKeys []BlockRootKeyVector `json:"blockRootKeys"`
+    Trees         []TreeVector         `json:"trees"`
+    Chain         []BlockVector        `json:"chain"`
+}
+
+// LeafVector is the hash of a single leaf
+type LeafVector struct {
+    Key   string `json:"key"`
+    Value string `json:"value"`
+    Hash  string `json:"hash"`
+}
+
+// BalanceVector is the stored form of a balance given in decimal
+type BalanceVector struct {
+    Balance string `json:"balance"`
+    Encoded string `json:"encoded"`
+}
+
+// BlockRootKeyVector is the key of the root hash of a checkpoint block
+type BlockRootKeyVector struct {
+    Block int64  `json:"block"`
+    Key   string `json:"key"`
+}
+
+// TreeVector is the root hash after a sequence of writes to an empty tree
+type TreeVector struct {
+    Name   string  `json:"name"`
+    Writes []Write `json:"writes"`
+    Root   string  `json:"root"`
+}
+
+// Write is a write of a value to a key
+type Write struct {
+    Key   string `json:"key"`
+    Value string `json:"value"`
+}
+
+// BlockVector is a block of the conformance chain, which starts from the genesis
+// state. A reverted block is discarded instead of checkpointed and has no root hash.
+type BlockVector struct {
+    Number       int64               `json:"number"`
+    Transactions []TransactionVector `json:"transactions"`
+    Reverted     bool                `json:"reverted,omitempty"`
+    Root         string              `json:"root,omitempty"`
+}
+
+// TransactionVector is a transaction with its data as text and the outcome the
+// rules give it
+type TransactionVector struct {
+    Note    string `json:"note"`
+    Sender  string `json:"sender"`
+    Data    string `json:"data"`
+    Applied bool   `json:"applied"`
+}
+
+// Vectors returns the conformance vectors
+func Vectors() (*VectorSet, error) {
+    var vectors VectorSet
+    if err := json.Unmarshal(vectorsJSON, &vectors); err != nil {
+        return nil, err
+    }
+    return &vectors, nil
+}
+
+// VectorsJSON returns the conformance vectors as JSON, for the test suites of the
+// other implementations
+func VectorsJSON() []byte {
+    return vectorsJSON
+}
@@ -0,0 +1,24 @@
+// Package chaos injects faults into peer requests, RPC requests and flushes so the
+// recovery paths of the node can be exercised. Faults are only injected by binaries
+// built with -tags chaos; other builds refuse a configuration that enables them.
+package chaos
+
+import (
+    "errors"
+    "fmt"
+)
+
+// Faults that can be injected
+const (
+    PeerTimeout  = "peer_timeout"
+    RPCError     = "rpc_error"
+    FlushFailure = "flush_failure"
+)
+
+// ErrInjected is wrapped by every error returned for an injected fault
+var ErrInjected = errors.New("injected fault")
+
+// Error returns the error reported for an injected fault
+func Error(fault string) error {
+    return fmt.Errorf("%w: %s", ErrInjected, fault)
+}
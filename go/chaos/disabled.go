@@ -0,0 +1,23 @@
+//go:build !chaos
+
+package chaos
+
+import (
+    "errors"
+
+    "pwr-stateful-vida/config"
+)
+
+// Setup refuses a configuration that enables faults, because this binary was built
+// without the chaos tag
+func Setup(cfg config.ChaosConfig, rpcURL string) error {
+    if cfg.PeerTimeout > 0 || cfg.RPCError > 0 || cfg.FlushFailure > 0 {
+        return errors.New("chaos faults are configured but the binary was built without -tags chaos")
+    }
+    return nil
+}
+
+// Inject never injects a fault in builds without the chaos tag
+func Inject(fault string) bool {
+    return false
+}
@@ -0,0 +1,102 @@
+//go:build chaos
+
+package chaos
+
+import (
+    "io"
+    "math/rand"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/metrics"
+)
+
+// injected counts the faults injected, by fault
+var injected = metrics.NewCounter("vida_chaos_faults_total", "Faults injected by chaos mode.", "fault")
+
+var logger = logging.For("chaos")
+
+var (
+    mutex         sync.Mutex
+    random        *rand.Rand
+    probabilities = make(map[string]float64)
+)
+
+// Setup enables the faults of the configuration. RPC errors are injected into
+// requests to the host of rpcURL made through http.DefaultTransport.
+func Setup(cfg config.ChaosConfig, rpcURL string) error {
+    seed := cfg.Seed
+    if seed == 0 {
+        seed = time.Now().UnixNano()
+    }
+
+    mutex.Lock()
+    random = rand.New(rand.NewSource(seed))
+    probabilities = map[string]float64{
+        PeerTimeout:  cfg.PeerTimeout,
+        RPCError:     cfg.RPCError,
+        FlushFailure: cfg.FlushFailure,
+    }
+    mutex.Unlock()
+
+    if cfg.RPCError > 0 {
+        parsed, err := url.Parse(rpcURL)
+        if err != nil {
+            return err
+        }
+        http.DefaultTransport = &faultyTransport{next: http.DefaultTransport, host: parsed.Host}
+    }
+    if cfg.PeerTimeout > 0 || cfg.RPCError > 0 || cfg.FlushFailure > 0 {
+        logger.Warn("chaos mode is injecting faults",
+            "peerTimeout", cfg.PeerTimeout,
+            "rpcError", cfg.RPCError,
+            "flushFailure", cfg.FlushFailure,
+            "seed", seed,
+        )
+    }
+    return nil
+}
+
+// Inject reports whether fault should be injected now
+func Inject(fault string) bool {
+    mutex.Lock()
+    probability := probabilities[fault]
+    hit := probability > 0 && random.Float64() < probability
+    mutex.Unlock()
+
+    if hit {
+        injected.Inc(fault)
+        logger.Warn("injecting fault", "fault", fault)
+    }
+    return hit
+}
+
+// faultyTransport answers some requests to host with the error response of an
+// overloaded RPC node instead of sending them
+type faultyTransport struct {
+    next http.RoundTripper
+    host string
+}
+
+func (t *faultyTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+    if request.URL.Host != t.host || !Inject(RPCError) {
+        return t.next.RoundTrip(request)
+    }
+    body := `{"message":"` + Error(RPCError).Error() + `"}`
+    return &http.Response{
+        Status:        "503 Service Unavailable",
+        StatusCode:    http.StatusServiceUnavailable,
+        Proto:         "HTTP/1.1",
+        ProtoMajor:    1,
+        ProtoMinor:    1,
+        Header:        http.Header{"Content-Type": []string{"application/json"}},
+        Body:          io.NopCloser(strings.NewReader(body)),
+        ContentLength: int64(len(body)),
+        Request:       request,
+    }, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "sort"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/logging"
+)
+
+// command is a CLI subcommand
+type command struct {
+    name    string
+    summary string
+    run     func(args []string) error
+}
+
+// commands holds every registered subcommand by name
+var commands = make(map[string]command)
+
+// configPath is the configuration file given with -config
+var configPath = config.DefaultPath
+
+// registerCommand adds a subcommand to the CLI
+func registerCommand(name, summary string, run func(args []string) error) {
+    commands[name] = command{name: name, summary: summary, run: run}
+}
+
+func init() {
+    registerCommand("serve", "synchronize VIDA transactions and serve the APIs (default)", runServe)
+}
+
+// newFlagSet returns a flag set for a subcommand with a usage line listing its arguments
+func newFlagSet(name, arguments string) *flag.FlagSet {
+    flags := flag.NewFlagSet(name, flag.ContinueOnError)
+    flags.Usage = func() {
+        fmt.Fprintf(flags.Output(), "Usage: %s [-config file] %s [flags] %s\n", os.Args[0], name, arguments)
+        flags.PrintDefaults()
+    }
+    return flags
+}
+
+// dbFlag registers the -db flag shared by commands working on the database file
+func dbFlag(flags *flag.FlagSet) *string {
+    return flags.String("db", dbservice.TreePath(), "path of the database file")
+}
+
+// grpcFlags registers the flags authenticating commands that call the gRPC API of a node
+func grpcFlags(flags *flag.FlagSet) (token, caFile *string) {
+    token = flags.String("token", "", "API key or JWT sent to the node")
+    caFile = flags.String("ca", "", "PEM bundle verifying the TLS certificate of the node, empty connects without TLS")
+    return token, caFile
+}
+
+// printUsage lists the available subcommands
+func printUsage() {
+    fmt.Fprintf(os.Stderr, "Usage: %s [-config file] <command> [flags]\n\nCommands:\n", os.Args[0])
+
+    names := make([]string, 0, len(commands))
+    for name := range commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+    }
+}
+
+// configureDatabase applies the database settings of cfg to db
+func configureDatabase(db *dbservice.DatabaseService, cfg *config.Config) {
+    db.SetBalanceCacheSize(cfg.Memory.BalanceCacheSize)
+    db.SetShards(cfg.Shards)
+    db.SetCanonical(cfg.Canonical)
+}
+
+// newCommandDatabase returns a database service for a command working on a
+// temporary database, keeping its files under dir and configured by cfg
+func newCommandDatabase(dir string, cfg *config.Config) *dbservice.DatabaseService {
+    db := dbservice.New("database")
+    db.SetDir(dir)
+    configureDatabase(db, cfg)
+    return db
+}
+
+// runCLI loads the configuration and runs the requested subcommand, returning the exit code
+func runCLI(args []string) int {
+    global := flag.NewFlagSet("pwr-stateful-vida", flag.ContinueOnError)
+    global.Usage = printUsage
+    global.StringVar(&configPath, "config", config.DefaultPath, "path of the configuration file")
+    if err := global.Parse(args); err != nil {
+        return 2
+    }
+
+    cfg, err := config.Load(configPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        return 1
+    }
+    if err := cfg.ResolveSecrets(); err != nil {
+        fmt.Fprintf(os.Stderr, "failed to resolve secrets: %v\n", err)
+        return 1
+    }
+    config.Set(cfg)
+    if err := logging.Setup(cfg.Logging); err != nil {
+        fmt.Fprintf(os.Stderr, "invalid logging configuration: %v\n", err)
+        return 1
+    }
+    if cfg.SlowTreeOperation != "" {
+        threshold, err := time.ParseDuration(cfg.SlowTreeOperation)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "invalid slowTreeOperation: %v\n", err)
+            return 1
+        }
+        dbservice.SetSlowOperationThreshold(threshold)
+    }
+    configureDatabase(app.db, cfg)
+
+    args = global.Args()
+    name := "serve"
+    if len(args) > 0 {
+        name, args = args[0], args[1:]
+    }
+
+    if name == "help" {
+        printUsage()
+        return 0
+    }
+
+    cmd, ok := commands[name]
+    if !ok {
+        fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+        printUsage()
+        return 2
+    }
+
+    if err := cmd.run(args); err != nil {
+        if errors.Is(err, flag.ErrHelp) {
+            return 0
+        }
+        fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+        return 1
+    }
+    return 0
+}
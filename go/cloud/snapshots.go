@@ -0,0 +1,147 @@
+package cloud
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "time"
+)
+
+// catalogKey names the object listing the uploaded snapshots
+const catalogKey = "snapshots.json"
+
+// Snapshot describes an uploaded snapshot
+type Snapshot struct {
+    BlockNumber int64 `json:"blockNumber"`
+    // RootHash is the root hash of the block that peers agreed on. The file holds
+    // it under the root hash key of the block, which changes the root of the file.
+    RootHash string `json:"rootHash"`
+    // PreviousRootHash is the value the root hash key of the block had before it
+    // was written, empty when the key was new; it lets RootHash be recomputed
+    PreviousRootHash string    `json:"previousRootHash,omitempty"`
+    Key              string    `json:"key"`
+    Size             int64     `json:"size"`
+    SHA256           string    `json:"sha256"`
+    Uploaded         time.Time `json:"uploaded"`
+}
+
+// Catalog returns the uploaded snapshots, oldest first
+func (s *Store) Catalog(ctx context.Context) ([]Snapshot, error) {
+    body, _, err := s.Get(ctx, catalogKey)
+    if errors.Is(err, ErrNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer body.Close()
+    var snapshots []Snapshot
+    if err := json.NewDecoder(body).Decode(&snapshots); err != nil {
+        return nil, fmt.Errorf("invalid snapshot catalog: %v", err)
+    }
+    return snapshots, nil
+}
+
+// Find returns the newest snapshot at or before maxBlock, the newest of all for a
+// negative maxBlock
+func (s *Store) Find(ctx context.Context, maxBlock int64) (*Snapshot, error) {
+    snapshots, err := s.Catalog(ctx)
+    if err != nil {
+        return nil, err
+    }
+    for i := len(snapshots) - 1; i >= 0; i-- {
+        if maxBlock < 0 || snapshots[i].BlockNumber <= maxBlock {
+            return &snapshots[i], nil
+        }
+    }
+    return nil, fmt.Errorf("no snapshot at or before block %d: %w", maxBlock, ErrNotFound)
+}
+
+// Upload stores the snapshot file at path and adds it to the catalog, described by
+// the block and root hashes of snapshot. Only the newest keep snapshots stay in the
+// store, all of them for keep 0. A single node should upload to a prefix, since the
+// catalog is rewritten whole.
+func (s *Store) Upload(ctx context.Context, path string, snapshot Snapshot, keep int) (*Snapshot, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    hasher := sha256.New()
+    size, err := io.Copy(hasher, file)
+    file.Close()
+    if err != nil {
+        return nil, err
+    }
+
+    blockNumber := snapshot.BlockNumber
+    snapshot.Key = strconv.FormatInt(blockNumber, 10) + ".db"
+    snapshot.Size = size
+    snapshot.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+    snapshot.Uploaded = time.Now().UTC()
+    if err := s.PutFile(ctx, snapshot.Key, path); err != nil {
+        return nil, err
+    }
+
+    snapshots, err := s.Catalog(ctx)
+    if err != nil {
+        return nil, err
+    }
+    kept := snapshots[:0]
+    for _, existing := range snapshots {
+        if existing.BlockNumber < blockNumber {
+            kept = append(kept, existing)
+        }
+    }
+    snapshots = append(kept, snapshot)
+    var dropped []Snapshot
+    if keep > 0 && len(snapshots) > keep {
+        dropped = snapshots[:len(snapshots)-keep]
+        snapshots = snapshots[len(snapshots)-keep:]
+    }
+    data, _ := json.MarshalIndent(snapshots, "", "  ")
+    if err := s.PutBytes(ctx, catalogKey, data); err != nil {
+        return nil, err
+    }
+    // Dropped files are only deleted once the catalog no longer lists them
+    for _, old := range dropped {
+        if err := s.Delete(ctx, old.Key); err != nil && !errors.Is(err, ErrNotFound) {
+            return &snapshot, fmt.Errorf("failed to delete snapshot of block %d: %v", old.BlockNumber, err)
+        }
+    }
+    return &snapshot, nil
+}
+
+// Download writes an uploaded snapshot to out, failing when its size or SHA-256
+// hash differs from the catalog
+func (s *Store) Download(ctx context.Context, snapshot *Snapshot, out string) error {
+    body, _, err := s.Get(ctx, snapshot.Key)
+    if err != nil {
+        return err
+    }
+    defer body.Close()
+    file, err := os.Create(out)
+    if err != nil {
+        return err
+    }
+    hasher := sha256.New()
+    size, err := io.Copy(io.MultiWriter(file, hasher), body)
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil && size != snapshot.Size {
+        err = fmt.Errorf("downloaded %d bytes, the catalog lists %d", size, snapshot.Size)
+    }
+    if sum := hex.EncodeToString(hasher.Sum(nil)); err == nil && sum != snapshot.SHA256 {
+        err = fmt.Errorf("downloaded SHA-256 %s, the catalog lists %s", sum, snapshot.SHA256)
+    }
+    if err != nil {
+        os.Remove(out)
+    }
+    return err
+}
@@ -0,0 +1,333 @@
+// Package cloud stores snapshots in S3 or in Google Cloud Storage through its S3
+// compatible XML API, signing requests with AWS Signature Version 4. Snapshots are
+// listed in a catalog object next to them, so a new node finds the latest one with
+// a single read.
+package cloud
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/xml"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// partSize is the size of the parts of a multipart upload. Files up to it are
+// uploaded with a single request.
+const partSize = 64 << 20
+
+// unsignedPayload is the content hash of uploads, whose bodies are too large to
+// hash before sending; TLS protects them instead
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// ErrNotFound is returned for an object that does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Credentials sign the requests. GCS takes the HMAC keys of a service account.
+type Credentials struct {
+    AccessKeyID     string
+    SecretAccessKey string
+    SessionToken    string
+}
+
+// Store is a bucket and key prefix of an S3 compatible object store
+type Store struct {
+    // endpoint is the base URL of the service; the bucket is the first segment of
+    // the path unless virtualHost is set, where it is part of the host
+    endpoint    *url.URL
+    virtualHost bool
+    region      string
+    bucket      string
+    prefix      string
+    credentials Credentials
+    client      *http.Client
+}
+
+// Open returns the store of a URL of the form s3://bucket/prefix or
+// gs://bucket/prefix. endpoint replaces the endpoint of the scheme, for other S3
+// compatible services; region is the S3 region.
+func Open(rawURL, endpoint, region string, credentials Credentials) (*Store, error) {
+    location, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+    if location.Host == "" {
+        return nil, fmt.Errorf("%s has no bucket", rawURL)
+    }
+    s := &Store{
+        region:      region,
+        bucket:      location.Host,
+        prefix:      strings.Trim(location.Path, "/"),
+        credentials: credentials,
+        client:      &http.Client{Timeout: time.Hour},
+    }
+    switch {
+    case endpoint != "":
+    case location.Scheme == "s3":
+        if region == "" {
+            s.region = "us-east-1"
+        }
+        endpoint = "https://" + s.bucket + ".s3." + s.region + ".amazonaws.com"
+        s.virtualHost = true
+    case location.Scheme == "gs":
+        endpoint = "https://storage.googleapis.com"
+        s.region = "auto"
+    default:
+        return nil, fmt.Errorf("%s is not an s3:// or gs:// URL", rawURL)
+    }
+    if s.endpoint, err = url.Parse(strings.TrimSuffix(endpoint, "/")); err != nil {
+        return nil, err
+    }
+    if s.region == "" {
+        s.region = "us-east-1"
+    }
+    return s, nil
+}
+
+// objectURL returns the URL of the object named key under the prefix
+func (s *Store) objectURL(key string, query url.Values) *url.URL {
+    if s.prefix != "" {
+        key = s.prefix + "/" + key
+    }
+    u := *s.endpoint
+    if !s.virtualHost {
+        key = s.bucket + "/" + key
+    }
+    u.Path = "/" + key
+    u.RawPath = escapePath(u.Path)
+    u.RawQuery = query.Encode()
+    return &u
+}
+
+// escapePath percent-encodes every byte of a path but the unreserved characters and
+// the slashes, as Signature Version 4 expects
+func escapePath(path string) string {
+    var escaped strings.Builder
+    for i := 0; i < len(path); i++ {
+        c := path[i]
+        if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+            escaped.WriteByte(c)
+        } else {
+            fmt.Fprintf(&escaped, "%%%02X", c)
+        }
+    }
+    return escaped.String()
+}
+
+// do signs and sends a request, failing on a status other than 2xx
+func (s *Store) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+    request, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+    if err != nil {
+        return nil, err
+    }
+    request.ContentLength = size
+    s.sign(request, payloadHash, time.Now().UTC())
+    resp, err := s.client.Do(request)
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode/100 != 2 {
+        message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        resp.Body.Close()
+        if resp.StatusCode == http.StatusNotFound {
+            return nil, fmt.Errorf("%s: %w", u.Path, ErrNotFound)
+        }
+        return nil, fmt.Errorf("%s %s returned HTTP %d: %s", method, u.Path, resp.StatusCode, strings.TrimSpace(string(message)))
+    }
+    return resp, nil
+}
+
+// sign adds the Signature Version 4 authorization of the request
+func (s *Store) sign(request *http.Request, payloadHash string, now time.Time) {
+    date := now.Format("20060102")
+    request.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+    request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+    if s.credentials.SessionToken != "" {
+        request.Header.Set("X-Amz-Security-Token", s.credentials.SessionToken)
+    }
+
+    names := []string{"host"}
+    for name := range request.Header {
+        names = append(names, strings.ToLower(name))
+    }
+    sort.Strings(names)
+    var headers strings.Builder
+    for _, name := range names {
+        value := request.Host
+        if name != "host" {
+            value = strings.TrimSpace(request.Header.Get(name))
+        }
+        headers.WriteString(name + ":" + value + "\n")
+    }
+    signedHeaders := strings.Join(names, ";")
+
+    canonical := strings.Join([]string{
+        request.Method,
+        request.URL.EscapedPath(),
+        strings.ReplaceAll(request.URL.Query().Encode(), "+", "%20"),
+        headers.String(),
+        signedHeaders,
+        payloadHash,
+    }, "\n")
+    scope := date + "/" + s.region + "/s3/aws4_request"
+    toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hashHex([]byte(canonical))
+
+    key := hmacSHA256([]byte("AWS4"+s.credentials.SecretAccessKey), date)
+    for _, part := range []string{s.region, "s3", "aws4_request"} {
+        key = hmacSHA256(key, part)
+    }
+    signature := hex.EncodeToString(hmacSHA256(key, toSign))
+    request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+        s.credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+// Get returns the content of the object named key and its size
+func (s *Store) Get(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+    resp, err := s.do(ctx, http.MethodGet, s.objectURL(key, nil), nil, 0, hashHex(nil))
+    if err != nil {
+        return nil, 0, err
+    }
+    return resp.Body, resp.ContentLength, nil
+}
+
+// PutBytes stores a small object
+func (s *Store) PutBytes(ctx context.Context, key string, data []byte) error {
+    resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, nil), bytes.NewReader(data), int64(len(data)), hashHex(data))
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// Delete removes the object named key
+func (s *Store) Delete(ctx context.Context, key string) error {
+    resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, nil), nil, 0, hashHex(nil))
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    return nil
+}
+
+// PutFile uploads the file at path as the object named key, in parts when it is
+// larger than partSize
+func (s *Store) PutFile(ctx context.Context, key, path string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    stat, err := file.Stat()
+    if err != nil {
+        return err
+    }
+    size := stat.Size()
+    if size <= partSize {
+        resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, nil), io.NewSectionReader(file, 0, size), size, unsignedPayload)
+        if err != nil {
+            return err
+        }
+        resp.Body.Close()
+        return nil
+    }
+
+    uploadID, err := s.startUpload(ctx, key)
+    if err != nil {
+        return err
+    }
+    var parts completedParts
+    for offset, number := int64(0), 1; offset < size; offset, number = offset+partSize, number+1 {
+        length := min(partSize, size-offset)
+        query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+        resp, err := s.do(ctx, http.MethodPut, s.objectURL(key, query), io.NewSectionReader(file, offset, length), length, unsignedPayload)
+        if err != nil {
+            s.abortUpload(key, uploadID)
+            return fmt.Errorf("part %d: %v", number, err)
+        }
+        resp.Body.Close()
+        parts.Parts = append(parts.Parts, completedPart{Number: number, ETag: resp.Header.Get("ETag")})
+    }
+    if err := s.completeUpload(ctx, key, uploadID, parts); err != nil {
+        s.abortUpload(key, uploadID)
+        return err
+    }
+    return nil
+}
+
+// completedPart and completedParts are the body of a CompleteMultipartUpload
+type completedPart struct {
+    Number int    `xml:"PartNumber"`
+    ETag   string `xml:"ETag"`
+}
+
+type completedParts struct {
+    XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+    Parts   []completedPart `xml:"Part"`
+}
+
+// startUpload starts a multipart upload and returns its ID
+func (s *Store) startUpload(ctx context.Context, key string) (string, error) {
+    resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploads": {""}}), nil, 0, hashHex(nil))
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+    var result struct {
+        UploadID string `xml:"UploadId"`
+    }
+    if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+        return "", fmt.Errorf("invalid multipart upload response: %v", err)
+    }
+    return result.UploadID, nil
+}
+
+// completeUpload joins the uploaded parts into the object
+func (s *Store) completeUpload(ctx context.Context, key, uploadID string, parts completedParts) error {
+    body, _ := xml.Marshal(parts)
+    resp, err := s.do(ctx, http.MethodPost, s.objectURL(key, url.Values{"uploadId": {uploadID}}), bytes.NewReader(body), int64(len(body)), hashHex(body))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    // A failure after the status line is reported in the body of a 200 response
+    var result struct {
+        XMLName xml.Name
+        Message string `xml:"Message"`
+    }
+    if err := xml.NewDecoder(resp.Body).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+        return fmt.Errorf("completing upload of %s failed: %s", key, result.Message)
+    }
+    return nil
+}
+
+// abortUpload drops the parts of a failed multipart upload
+func (s *Store) abortUpload(key, uploadID string) {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+    if resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key, url.Values{"uploadId": {uploadID}}), nil, 0, hashHex(nil)); err == nil {
+        resp.Body.Close()
+    }
+}
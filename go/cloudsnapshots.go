@@ -0,0 +1,183 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "sync/atomic"
+
+    "pwr-stateful-vida/cloud"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/reporting"
+)
+
+// cloudUploader uploads a snapshot of the database every intervalBlocks checkpoints
+type cloudUploader struct {
+    db    *dbservice.DatabaseService
+    store *cloud.Store
+    cfg   config.CloudSnapshotsConfig
+    // snapshotDir holds the copies being uploaded
+    snapshotDir string
+    // interval is the number of the last interval a snapshot was taken in
+    interval int64
+    // lastBlock and lastRoot are the previous checkpoint and its root hash
+    lastBlock int64
+    lastRoot  []byte
+    uploading atomic.Bool
+    ctx       context.Context
+    cancel    context.CancelFunc
+    wg        sync.WaitGroup
+}
+
+// openCloudStore returns the store of the configuration, signing with the AWS
+// environment variables when no keys are configured
+func openCloudStore(cfg config.CloudSnapshotsConfig) (*cloud.Store, error) {
+    credentials := cloud.Credentials{AccessKeyID: cfg.AccessKeyID, SecretAccessKey: cfg.SecretAccessKey}
+    if credentials.AccessKeyID == "" {
+        credentials = cloud.Credentials{
+            AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+            SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+            SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+        }
+    }
+    if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+        return nil, errors.New("cloud snapshots need an access key, configured or in AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+    }
+    return cloud.Open(cfg.URL, cfg.Endpoint, cfg.Region, credentials)
+}
+
+// openCloudUploader returns the uploader of the configuration. The interval of the
+// checkpoint is skipped, so a restarted node does not upload again right away.
+func (a *App) openCloudUploader(cfg config.CloudSnapshotsConfig) (*cloudUploader, error) {
+    store, err := openCloudStore(cfg)
+    if err != nil {
+        return nil, err
+    }
+    checkpoint, _ := a.db.GetLastCheckedBlock()
+    lastRoot, _ := a.db.GetBlockRootHash(checkpoint)
+    ctx, cancel := context.WithCancel(context.Background())
+    return &cloudUploader{
+        db:          a.db,
+        snapshotDir: a.config().SnapshotDir,
+        store:       store,
+        cfg:         cfg,
+        interval:    checkpoint / cfg.IntervalBlocks,
+        lastBlock:   checkpoint,
+        lastRoot:    lastRoot,
+        ctx:         ctx,
+        cancel:      cancel,
+    }, nil
+}
+
+// Commit copies the database once a flushed checkpoint enters a new interval and
+// uploads the copy in the background. A checkpoint reached while an upload runs
+// waits for the next interval.
+func (u *cloudUploader) Commit(blockNumber int64, rootHash []byte) {
+    // Root hash keys of distant blocks can be the same, so the key of this block
+    // may have held the root of the previous checkpoint
+    var previous []byte
+    if bytes.Equal(u.db.BlockRootHashKey(u.lastBlock), u.db.BlockRootHashKey(blockNumber)) {
+        previous = u.lastRoot
+    }
+    u.lastBlock, u.lastRoot = blockNumber, rootHash
+
+    interval := blockNumber / u.cfg.IntervalBlocks
+    if interval <= u.interval || rootHash == nil || !u.uploading.CompareAndSwap(false, true) {
+        return
+    }
+    u.interval = interval
+
+    snapshot := cloud.Snapshot{
+        BlockNumber:      blockNumber,
+        RootHash:         hex.EncodeToString(rootHash),
+        PreviousRootHash: hex.EncodeToString(previous),
+    }
+    fileRoot, err := u.db.GetRootHash()
+    path := filepath.Join(u.snapshotDir, "cloud-"+strconv.FormatInt(blockNumber, 10)+".upload")
+    if err == nil {
+        err = u.db.CopyTree(func(paths []string) error {
+            if len(paths) != 1 {
+                return fmt.Errorf("the state is split across %d files", len(paths))
+            }
+            return copyDatabase(paths[0], path)
+        })
+    }
+    if err != nil {
+        u.uploading.Store(false)
+        u.fail(blockNumber, fmt.Errorf("failed to copy database: %v", err))
+        return
+    }
+
+    u.wg.Add(1)
+    go func() {
+        defer u.wg.Done()
+        defer u.uploading.Store(false)
+        defer os.Remove(path)
+        if err := u.upload(path, snapshot, rootHash, fileRoot); err != nil {
+            u.fail(blockNumber, err)
+        }
+    }()
+}
+
+// upload verifies the copy of a checkpoint against the root hash of the tree and the
+// root hash stored for the block, and uploads it
+func (u *cloudUploader) upload(path string, snapshot cloud.Snapshot, rootHash, fileRoot []byte) error {
+    file, err := dbfile.Open(path, true)
+    if err != nil {
+        return err
+    }
+    problems, err := file.Verify()
+    root := file.RootHash()
+    stored, _ := file.Get(u.db.BlockRootHashKey(snapshot.BlockNumber))
+    file.Close()
+    if err != nil {
+        return err
+    }
+    if len(problems) > 0 {
+        return fmt.Errorf("copy failed verification: %s", problems[0])
+    }
+    if !bytes.Equal(root, fileRoot) {
+        return fmt.Errorf("copy has root hash %x, the tree %x", root, fileRoot)
+    }
+    if !bytes.Equal(stored, rootHash) {
+        return fmt.Errorf("copy holds root hash %x for the block, the checkpoint %x", stored, rootHash)
+    }
+
+    uploaded, err := u.store.Upload(u.ctx, path, snapshot, u.cfg.Keep)
+    if uploaded != nil {
+        syncLogger.Info("snapshot uploaded", "block", snapshot.BlockNumber, "key", uploaded.Key, "size", uploaded.Size)
+    }
+    return err
+}
+
+// fail logs and reports a snapshot that was not uploaded
+func (u *cloudUploader) fail(blockNumber int64, err error) {
+    if u.ctx.Err() != nil {
+        return
+    }
+    syncLogger.Error("failed to upload snapshot", "block", blockNumber, "error", err)
+    metrics.CloudSnapshotErrors.Inc()
+    reporting.Report(err, reporting.Context{Module: "cloud", Block: blockNumber})
+}
+
+// Close cancels an upload in progress and waits for it
+func (u *cloudUploader) Close() {
+    u.cancel()
+    u.wg.Wait()
+}
+
+// commitCloudSnapshot passes a flushed checkpoint to the uploader
+func (a *App) commitCloudSnapshot(blockNumber int64, rootHash []byte) {
+    if a.cloudSnapshots != nil {
+        a.cloudSnapshots.Commit(blockNumber, rootHash)
+    }
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math/big"
+    "os"
+    "strings"
+
+    "pwr-stateful-vida/airdrop"
+    "pwr-stateful-vida/dbservice"
+)
+
+// airdropAllocation is the allocation and proof of one recipient in an airdrop tree
+type airdropAllocation struct {
+    Address string   `json:"address"`
+    Amount  string   `json:"amount"`
+    Proof   []string `json:"proof"`
+}
+
+// airdropTree is the output of the airdrop-tree command
+type airdropTree struct {
+    Root        string              `json:"root"`
+    Total       string              `json:"total"`
+    Allocations []airdropAllocation `json:"allocations"`
+}
+
+func init() {
+    registerCommand("airdrop-tree", "build the Merkle root and claim proofs of an airdrop", runAirdropTree)
+}
+
+// runAirdropTree reads "address,amount" lines and prints the root, the total to
+// publish and the proof each recipient claims with
+func runAirdropTree(args []string) error {
+    flags := newFlagSet("airdrop-tree", "[csv file]")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    var in io.Reader = os.Stdin
+    if flags.NArg() > 0 {
+        f, err := os.Open(flags.Arg(0))
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        in = f
+    }
+    records, err := csv.NewReader(in).ReadAll()
+    if err != nil {
+        return err
+    }
+
+    tree := airdropTree{Allocations: []airdropAllocation{}}
+    total := new(big.Int)
+    seen := make(map[string]bool)
+    var leaves [][]byte
+    for line, record := range records {
+        if len(record) != 2 {
+            return fmt.Errorf("line %d: expected address,amount", line+1)
+        }
+        address, err := decodeHex(strings.TrimSpace(record[0]))
+        if err != nil || len(address) != dbservice.AddressLength {
+            return fmt.Errorf("line %d: invalid address %q", line+1, record[0])
+        }
+        amount, ok := new(big.Int).SetString(strings.TrimSpace(record[1]), 10)
+        if !ok || amount.Sign() <= 0 || amount.BitLen() > 256 {
+            return fmt.Errorf("line %d: invalid amount %q", line+1, record[1])
+        }
+        addressHex := hex.EncodeToString(address)
+        if seen[addressHex] {
+            return fmt.Errorf("line %d: %s is listed twice, but can only claim once", line+1, addressHex)
+        }
+        seen[addressHex] = true
+        total.Add(total, amount)
+        leaves = append(leaves, airdrop.Leaf(address, amount))
+        tree.Allocations = append(tree.Allocations, airdropAllocation{Address: addressHex, Amount: amount.String()})
+    }
+    if len(leaves) == 0 {
+        return errors.New("no allocations given")
+    }
+
+    root, proofs := airdrop.Build(leaves)
+    tree.Root, tree.Total = hex.EncodeToString(root), total.String()
+    for i, proof := range proofs {
+        tree.Allocations[i].Proof = []string{}
+        for _, hash := range proof {
+            tree.Allocations[i].Proof = append(tree.Allocations[i].Proof, hex.EncodeToString(hash))
+        }
+    }
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(tree)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "os"
+
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/config"
+)
+
+func init() {
+    registerCommand("archive", "print the archived root, transactions and state diff of a block", runArchive)
+}
+
+// runArchive prints the archive record covering a block as JSON
+func runArchive(args []string) error {
+    flags := newFlagSet("archive", "")
+    dir := flags.String("dir", config.Get().ArchiveDir, "archive directory")
+    block := flags.Int64("block", -1, "block number to look up")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *dir == "" {
+        return errors.New("no archive directory, set archiveDir in the configuration or pass -dir")
+    }
+    if *block < 0 {
+        return errors.New("-block is required")
+    }
+
+    record, err := archive.Find(*dir, *block)
+    if err != nil {
+        return err
+    }
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(record)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+    "math/big"
+    "math/rand"
+    "os"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+)
+
+func init() {
+    registerCommand("bench", "measure transfer, flush and root hash throughput on a temporary database", runBench)
+}
+
+// latencies accumulates duration samples
+type latencies struct {
+    total time.Duration
+    max   time.Duration
+    count int
+}
+
+func (l *latencies) add(d time.Duration) {
+    l.total += d
+    l.count++
+    if d > l.max {
+        l.max = d
+    }
+}
+
+func (l *latencies) String() string {
+    if l.count == 0 {
+        return "n/a"
+    }
+    return fmt.Sprintf("avg %v, max %v over %d samples", l.total/time.Duration(l.count), l.max, l.count)
+}
+
+// benchAddress derives a deterministic 20 byte address for account i
+func benchAddress(i int) []byte {
+    address := make([]byte, dbservice.AddressLength)
+    binary.BigEndian.PutUint64(address[12:], uint64(i))
+    address[0] = 0xbe
+    return address
+}
+
+// runBench applies random transfers in blocks, flushing after each block, in a throwaway directory
+func runBench(args []string) error {
+    flags := newFlagSet("bench", "")
+    accounts := flags.Int("accounts", 10000, "number of funded accounts")
+    transfers := flags.Int("transfers", 100000, "number of transfers to apply")
+    blockSize := flags.Int("block-size", 1000, "transfers per block; the database is flushed after each block")
+    seed := flags.Int64("seed", 1, "random seed for the workload")
+    keep := flags.Bool("keep", false, "keep the temporary database directory")
+    baseline := flags.Bool("baseline", false, "use the pwrgo tree, which rehashes the path of every write, instead of the tree file of the node")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *accounts < 2 || *transfers < 1 || *blockSize < 1 {
+        return fmt.Errorf("need at least 2 accounts, 1 transfer and a block size of 1")
+    }
+
+    dir, err := os.MkdirTemp("", "vida-bench-")
+    if err != nil {
+        return err
+    }
+    if !*keep {
+        defer os.RemoveAll(dir)
+    }
+    db := newCommandDatabase(dir, config.Get())
+    defer db.Close()
+
+    fmt.Printf("Benchmark database: %s\n", dir)
+    if *baseline {
+        tree, err := openPwrgoTree(dir, "baseline")
+        if err != nil {
+            return err
+        }
+        db.UseTree(tree)
+        fmt.Println("Tree: pwrgo baseline")
+    }
+
+    setupStart := time.Now()
+    for i := 0; i < *accounts; i++ {
+        if err := db.SetBalance(benchAddress(i), big.NewInt(1_000_000_000)); err != nil {
+            return err
+        }
+    }
+    if err := db.Flush(); err != nil {
+        return err
+    }
+    fmt.Printf("Funded %d accounts in %v\n", *accounts, time.Since(setupStart))
+
+    random := rand.New(rand.NewSource(*seed))
+    var apply, flush, root latencies
+    failed := 0
+
+    start := time.Now()
+    for done := 0; done < *transfers; {
+        blockStart := time.Now()
+        for i := 0; i < *blockSize && done < *transfers; i++ {
+            sender := benchAddress(random.Intn(*accounts))
+            receiver := benchAddress(random.Intn(*accounts))
+            amount := big.NewInt(random.Int63n(1000) + 1)
+
+            ok, err := db.Transfer(sender, receiver, amount)
+            if err != nil {
+                return err
+            }
+            if !ok {
+                failed++
+            }
+            done++
+        }
+        apply.add(time.Since(blockStart))
+
+        // Writes are buffered until the block ends, so the root hash read
+        // includes writing the changed leaves and rehashing their paths
+        rootStart := time.Now()
+        if _, err := db.GetRootHash(); err != nil {
+            return err
+        }
+        root.add(time.Since(rootStart))
+
+        flushStart := time.Now()
+        if err := db.Flush(); err != nil {
+            return err
+        }
+        flush.add(time.Since(flushStart))
+    }
+    elapsed := time.Since(start)
+
+    rootHash, _ := db.GetRootHash()
+    fmt.Printf("Transfers:          %d (%d rejected)\n", *transfers, failed)
+    fmt.Printf("Elapsed:            %v\n", elapsed)
+    fmt.Printf("Throughput:         %.0f transfers/sec\n", float64(*transfers)/elapsed.Seconds())
+    fmt.Printf("Block apply time:   %s\n", &apply)
+    fmt.Printf("Root hash read:     %s\n", &root)
+    fmt.Printf("Flush latency:      %s\n", &flush)
+    fmt.Printf("Final root hash:    %x\n", rootHash)
+    return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "pwr-stateful-vida/cloud"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/snapshot"
+)
+
+func init() {
+    registerCommand("bootstrap", "create the database from the latest cloud snapshot whose root hash peers agree on", runBootstrap)
+}
+
+// agreedRootHash asks peers for the root hash of a block and reports whether more
+// than two thirds of the peers that answered agree with rootHash
+func agreedRootHash(client httpPeers, peers []string, blockNumber int64, rootHash []byte) error {
+    answered, agreeing := 0, 0
+    for _, peer := range peers {
+        ok, root := client.RootHash(peer, int(blockNumber))
+        if !ok || root == nil {
+            fmt.Printf("  %s: no root hash\n", peer)
+            continue
+        }
+        answered++
+        if bytes.Equal(root, rootHash) {
+            agreeing++
+        }
+        fmt.Printf("  %s: root hash %x\n", peer, root)
+    }
+    if answered == 0 {
+        return errors.New("no peer answered")
+    }
+    if agreeing < answered*2/3+1 {
+        return fmt.Errorf("%d of %d peers agree with the snapshot root hash %x", agreeing, answered, rootHash)
+    }
+    return nil
+}
+
+// checkpointRoot recomputes the root hash the checkpoint of a snapshot file had
+// before its own root hash was stored, by rebuilding the tree with the root hash key
+// of the block removed or set back to its previous value
+func checkpointRoot(path string, latest *cloud.Snapshot, rootHash []byte) ([]byte, error) {
+    previous, err := hex.DecodeString(latest.PreviousRootHash)
+    if err != nil {
+        return nil, fmt.Errorf("invalid previous root hash in the snapshot catalog: %v", err)
+    }
+    file, err := dbfile.Open(path, true)
+    if err != nil {
+        return nil, err
+    }
+    entries, err := file.Entries()
+    file.Close()
+    if err != nil {
+        return nil, err
+    }
+
+    key := app.db.BlockRootHashKey(latest.BlockNumber)
+    found := -1
+    for i, entry := range entries {
+        if bytes.Equal(entry.Key, key) {
+            found = i
+        }
+    }
+    switch {
+    case found < 0:
+        return nil, fmt.Errorf("snapshot holds no root hash for block %d", latest.BlockNumber)
+    case !bytes.Equal(entries[found].Value, rootHash):
+        return nil, fmt.Errorf("snapshot holds root hash %x for block %d", entries[found].Value, latest.BlockNumber)
+    case len(previous) > 0:
+        entries[found].Value = previous
+    case found != len(entries)-1:
+        return nil, errors.New("the root hash key of the block was not the last one written")
+    default:
+        entries = entries[:found]
+    }
+
+    check := path + ".check"
+    defer os.Remove(check)
+    return rebuildTree(entries, check)
+}
+
+// runBootstrap downloads the newest cloud snapshot, checks its root hash against
+// peers or -root and installs it as the database, so the node syncs from its block
+func runBootstrap(args []string) error {
+    flags := newFlagSet("bootstrap", "[peer ...]")
+    dbPath := dbFlag(flags)
+    cfg := config.Get()
+    location := flags.String("url", cfg.CloudSnapshots.URL, "s3:// or gs:// bucket URL of the snapshots")
+    block := flags.Int64("block", -1, "newest block the snapshot may be taken at; the latest snapshot by default")
+    rootHex := flags.String("root", "", "root hash the snapshot must have, instead of a peer quorum")
+    force := flags.Bool("force", false, "replace an existing database")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *location == "" {
+        return errors.New("-url is required when cloudSnapshots.url is not configured")
+    }
+    peers := flags.Args()
+    if len(peers) == 0 {
+        peers = cfg.Peers
+    }
+    var expected []byte
+    if *rootHex != "" {
+        var err error
+        if expected, err = hex.DecodeString(strings.TrimPrefix(*rootHex, "0x")); err != nil {
+            return fmt.Errorf("invalid -root: %v", err)
+        }
+    } else if len(peers) == 0 {
+        return errors.New("no peers given or configured")
+    }
+    absPath, err := filepath.Abs(*dbPath)
+    if err != nil {
+        return err
+    }
+    if _, err := os.Stat(absPath); err == nil && !*force {
+        return fmt.Errorf("%s exists; use -force to replace it", absPath)
+    }
+
+    storeCfg := cfg.CloudSnapshots
+    storeCfg.URL = *location
+    store, err := openCloudStore(storeCfg)
+    if err != nil {
+        return err
+    }
+    ctx := context.Background()
+    latest, err := store.Find(ctx, *block)
+    if err != nil {
+        return err
+    }
+    rootHash, err := hex.DecodeString(latest.RootHash)
+    if err != nil {
+        return fmt.Errorf("invalid root hash in the snapshot catalog: %v", err)
+    }
+    fmt.Printf("Snapshot of block %d: %d bytes, root hash %x\n", latest.BlockNumber, latest.Size, rootHash)
+
+    // The catalog is not trusted: the root it lists must be the one agreed on
+    if expected != nil {
+        if !bytes.Equal(expected, rootHash) {
+            return fmt.Errorf("snapshot root hash %x differs from -root", rootHash)
+        }
+    } else {
+        client, err := newHTTPPeers(cfg.PeerTLS, cfg.Auth.PeerToken, time.Minute)
+        if err != nil {
+            return err
+        }
+        fmt.Println("Peers:")
+        if err := agreedRootHash(client, peers, latest.BlockNumber, rootHash); err != nil {
+            return err
+        }
+    }
+
+    if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+        return err
+    }
+    download := absPath + ".download"
+    start := time.Now()
+    if err := store.Download(ctx, latest, download); err != nil {
+        return err
+    }
+    defer os.Remove(download)
+    fmt.Printf("Downloaded %s in %v\n", latest.Key, time.Since(start))
+
+    // The state of the file must hash to the agreed root, whatever the catalog says
+    downloaded, err := snapshot.Inspect(download)
+    if err != nil {
+        return err
+    }
+    if downloaded.BlockNumber != latest.BlockNumber {
+        return fmt.Errorf("downloaded file is at block %d", downloaded.BlockNumber)
+    }
+    recomputed, err := checkpointRoot(download, latest, rootHash)
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(recomputed, rootHash) {
+        return fmt.Errorf("downloaded state has root hash %x, not the agreed %x", recomputed, rootHash)
+    }
+    info, err := snapshot.Restore(download, absPath)
+    if err != nil {
+        return err
+    }
+    if err := app.db.ResetAccountIndex(); err != nil {
+        return err
+    }
+
+    fmt.Printf("Bootstrapped block %d to %s, root hash %x verified\n", info.BlockNumber, absPath, rootHash)
+    return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+)
+
+func init() {
+    registerCommand("config", "validate the configuration and print the effective values (check)", runConfig)
+}
+
+// runConfig dispatches to the config actions
+func runConfig(args []string) error {
+    if len(args) == 0 || args[0] != "check" {
+        return errors.New("expected an action: check")
+    }
+    return runConfigCheck(args[1:])
+}
+
+// checkWritableDir reports whether files can be created in dir, creating it if needed
+func checkWritableDir(dir string) error {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    file, err := os.CreateTemp(dir, ".config-check-")
+    if err != nil {
+        return err
+    }
+    file.Close()
+    return os.Remove(file.Name())
+}
+
+// checkEndpoint reports whether an HTTP GET of url answers with status 200
+func checkEndpoint(client *http.Client, url string) error {
+    resp, err := client.Get(url)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("HTTP %d", resp.StatusCode)
+    }
+    return nil
+}
+
+// runConfigCheck validates the loaded configuration and the paths it points to,
+// optionally contacting the RPC node and peers
+func runConfigCheck(args []string) error {
+    flags := newFlagSet("config check", "")
+    online := flags.Bool("online", false, "also contact the RPC node and every peer")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    cfg := config.Get()
+    var problems []string
+    report := func(err error) {
+        problems = append(problems, err.Error())
+    }
+
+    if _, err := os.Stat(configPath); os.IsNotExist(err) {
+        fmt.Printf("Config file %s not found, using defaults\n", configPath)
+    } else if err := config.CheckFields(configPath); err != nil {
+        report(fmt.Errorf("%s: %v", configPath, err))
+    }
+
+    for _, err := range cfg.Validate() {
+        report(err)
+    }
+
+    // Directories the node writes to
+    dirs := []string{filepath.Dir(dbservice.TreePath()), cfg.SnapshotDir}
+    if cfg.TxLog != "" {
+        dirs = append(dirs, filepath.Dir(cfg.TxLog))
+    }
+    for _, dir := range dirs {
+        if err := checkWritableDir(dir); err != nil {
+            report(fmt.Errorf("directory %s is not writable: %v", dir, err))
+        }
+    }
+
+    // An existing database must be readable; a running node holding it is fine
+    if _, err := os.Stat(dbservice.TreePath()); err == nil {
+        file, err := dbfile.Open(dbservice.TreePath(), true)
+        switch {
+        case errors.Is(err, dbfile.ErrInUse):
+            fmt.Printf("Database %s is in use by a running node\n", dbservice.TreePath())
+        case err != nil:
+            report(err)
+        default:
+            file.Close()
+        }
+    }
+
+    // The initial balances are built in, so there is no genesis file to check
+    if err := loadSigningKeys(cfg.Signing); err != nil {
+        report(err)
+    }
+
+    if *online {
+        client := &http.Client{Timeout: 10 * time.Second}
+        if err := checkEndpoint(client, strings.TrimSuffix(cfg.RPCURL, "/")+"/blockNumber"); err != nil {
+            report(fmt.Errorf("rpc %s: %v", cfg.RPCURL, err))
+        }
+        if peerClient, scheme, err := newPeerClient(cfg.PeerTLS, 10*time.Second); err != nil {
+            report(err)
+        } else {
+            for _, peer := range cfg.Peers {
+                if err := checkEndpoint(peerClient, fmt.Sprintf("%s://%s/rootHash", scheme, peer)); err != nil {
+                    report(fmt.Errorf("peer %s: %v", peer, err))
+                }
+            }
+        }
+    }
+
+    // Secret references are resolved by now, so credentials are not printed
+    effective, err := json.MarshalIndent(cfg.Redacted(), "", "  ")
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Effective configuration:\n%s\n", effective)
+
+    if len(problems) > 0 {
+        for _, problem := range problems {
+            fmt.Printf("  %s\n", problem)
+        }
+        return fmt.Errorf("%d problems found", len(problems))
+    }
+    fmt.Println("Configuration OK")
+    return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "encoding/hex"
+    "fmt"
+    "math/big"
+    "os"
+    "path/filepath"
+
+    "pwr-stateful-vida/canonical"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/txlog"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+)
+
+func init() {
+    registerCommand("conformance", "check this node against the canonical state vectors, or write them out for other implementations", runConformance)
+}
+
+// conformanceChecker collects the vectors an implementation gets wrong
+type conformanceChecker struct {
+    checked  int
+    failures []string
+}
+
+// expect records a vector, failing it when got differs from want
+func (c *conformanceChecker) expect(name string, got []byte, want string) {
+    c.checked++
+    if hex.EncodeToString(got) != want {
+        c.failures = append(c.failures, fmt.Sprintf("%s: got %x, want %s", name, got, want))
+    }
+}
+
+// runConformance checks the encodings, the tree and the transaction rules of the
+// canonical mode against the conformance vectors
+func runConformance(args []string) error {
+    flags := newFlagSet("conformance", "")
+    write := flags.String("write", "", "write the vectors as JSON to this file instead of checking them")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *write != "" {
+        return os.WriteFile(*write, canonical.VectorsJSON(), 0644)
+    }
+    vectors, err := canonical.Vectors()
+    if err != nil {
+        return err
+    }
+
+    dir, err := os.MkdirTemp("", "vida-conformance-")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(dir)
+    cfg := *config.Get()
+    cfg.Canonical = true
+    cfg.TxLog = ""
+    a := newCommandApp(dir, &cfg)
+    defer a.db.Close()
+
+    var checker conformanceChecker
+    for i, vector := range vectors.Leaves {
+        key, _ := hex.DecodeString(vector.Key)
+        value, _ := hex.DecodeString(vector.Value)
+        checker.expect(fmt.Sprintf("leaf %d", i), merkletree.CalculateLeafHash(key, value), vector.Hash)
+    }
+    for _, vector := range vectors.Balances {
+        balance, ok := new(big.Int).SetString(vector.Balance, 10)
+        if !ok {
+            return fmt.Errorf("invalid balance vector %q", vector.Balance)
+        }
+        checker.expect("balance "+vector.Balance, canonical.EncodeBalance(balance), vector.Encoded)
+    }
+    for _, vector := range vectors.BlockRootKeys {
+        checker.expect(fmt.Sprintf("block root key %d", vector.Block), a.db.BlockRootHashKey(vector.Block), vector.Key)
+    }
+    for i, vector := range vectors.Trees {
+        entries := make([]dbfile.Entry, len(vector.Writes))
+        for j, write := range vector.Writes {
+            entries[j].Key, _ = hex.DecodeString(write.Key)
+            entries[j].Value, _ = hex.DecodeString(write.Value)
+        }
+        root, err := rebuildTree(entries, filepath.Join(dir, fmt.Sprintf("tree%d", i)))
+        if err != nil {
+            return err
+        }
+        checker.expect("tree "+vector.Name, root, vector.Root)
+    }
+
+    // Handler output would drown the report
+    stdout := os.Stdout
+    os.Stdout, _ = os.Open(os.DevNull)
+    a.initInitialBalances()
+    for _, block := range vectors.Chain {
+        for i, transaction := range block.Transactions {
+            a.syncNode().Process(txlog.Record{
+                Type:   txlog.TypeTransaction,
+                Block:  block.Number,
+                Hash:   fmt.Sprintf("0x%064x", block.Number*1000+int64(i)),
+                Sender: transaction.Sender,
+                Data:   hex.EncodeToString([]byte(transaction.Data)),
+            }.Transaction())
+        }
+        if block.Reverted {
+            a.syncNode().Discard()
+            continue
+        }
+        root, err := a.commitBlock(block.Number)
+        if err != nil {
+            os.Stdout = stdout
+            return err
+        }
+        checker.expect(fmt.Sprintf("block %d", block.Number), root, block.Root)
+    }
+    os.Stdout = stdout
+
+    for _, failure := range checker.failures {
+        fmt.Println(failure)
+    }
+    if len(checker.failures) > 0 {
+        return fmt.Errorf("%d of %d vectors failed", len(checker.failures), checker.checked)
+    }
+    fmt.Printf("All %d vectors passed\n", checker.checked)
+    return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/logfile"
+)
+
+// daemonEnv marks a serve process started by daemon start
+const daemonEnv = "VIDA_DAEMON"
+
+func init() {
+    registerCommand("daemon", "run the node in the background with a PID file and rotated logs (start, stop, status)", runDaemon)
+}
+
+// runDaemon dispatches to the daemon actions
+func runDaemon(args []string) error {
+    if len(args) > 0 {
+        switch args[0] {
+        case "start":
+            return runDaemonStart(args[1:])
+        case "stop":
+            return runDaemonStop(args[1:])
+        case "status":
+            return runDaemonStatus(args[1:])
+        }
+    }
+    return errors.New("expected an action: start, stop or status")
+}
+
+// readPID returns the process recorded in the PID file if it is still running
+func readPID(path string) (*os.Process, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+    if err != nil {
+        return nil, fmt.Errorf("invalid PID file %s: %v", path, err)
+    }
+    process, err := os.FindProcess(pid)
+    if err != nil {
+        return nil, err
+    }
+    if err := process.Signal(syscall.Signal(0)); err != nil {
+        return nil, fmt.Errorf("process %d is not running", pid)
+    }
+    return process, nil
+}
+
+// startDaemonChild is called by serve in a daemonized process. It writes the PID
+// file and sends all further output to the rotated log file. The returned
+// function removes the PID file.
+func startDaemonChild() (func(), error) {
+    cfg := config.Get().Daemon
+
+    if err := os.MkdirAll(filepath.Dir(cfg.PIDFile), 0755); err != nil {
+        return nil, err
+    }
+    if err := os.WriteFile(cfg.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+        return nil, err
+    }
+
+    maxAge, _ := time.ParseDuration(cfg.MaxLogAge)
+    writer := &logfile.Writer{
+        Path:       cfg.LogFile,
+        MaxSize:    int64(cfg.MaxLogSizeMB) << 20,
+        MaxAge:     maxAge,
+        MaxBackups: cfg.MaxLogBackups,
+    }
+
+    // Output goes through a pipe so that every fmt.Print and log call is rotated
+    reader, pipe, err := os.Pipe()
+    if err != nil {
+        return nil, err
+    }
+    go io.Copy(writer, reader)
+    os.Stdout = pipe
+    os.Stderr = pipe
+    log.SetOutput(pipe)
+
+    return func() {
+        os.Remove(cfg.PIDFile)
+    }, nil
+}
+
+// runDaemonStart starts serve as a detached process and waits until it has written its PID file
+func runDaemonStart(args []string) error {
+    flags := newFlagSet("daemon start", "[peer ...]")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    cfg := config.Get().Daemon
+    if process, err := readPID(cfg.PIDFile); err == nil {
+        return fmt.Errorf("already running with PID %d", process.Pid)
+    }
+    os.Remove(cfg.PIDFile)
+
+    executable, err := os.Executable()
+    if err != nil {
+        return err
+    }
+
+    // Output before the log rotation is set up, including crashes, is appended to the log file
+    if err := os.MkdirAll(filepath.Dir(cfg.LogFile), 0755); err != nil {
+        return err
+    }
+    logFile, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    defer logFile.Close()
+
+    cmd := exec.Command(executable, append([]string{"-config", configPath, "serve"}, flags.Args()...)...)
+    cmd.Env = append(os.Environ(), daemonEnv+"=1")
+    cmd.Stdout = logFile
+    cmd.Stderr = logFile
+    cmd.SysProcAttr = detachedProcess()
+    if err := cmd.Start(); err != nil {
+        return err
+    }
+
+    exited := make(chan error, 1)
+    go func() {
+        exited <- cmd.Wait()
+    }()
+
+    deadline := time.After(10 * time.Second)
+    for {
+        select {
+        case err := <-exited:
+            return fmt.Errorf("daemon exited during startup (%v), see %s", err, cfg.LogFile)
+        case <-deadline:
+            return fmt.Errorf("daemon did not write %s, see %s", cfg.PIDFile, cfg.LogFile)
+        case <-time.After(100 * time.Millisecond):
+        }
+        if process, err := readPID(cfg.PIDFile); err == nil {
+            fmt.Printf("Started with PID %d, logging to %s\n", process.Pid, cfg.LogFile)
+            return nil
+        }
+    }
+}
+
+// runDaemonStop asks the daemon to shut down and waits for it to exit
+func runDaemonStop(args []string) error {
+    flags := newFlagSet("daemon stop", "")
+    timeout := flags.Duration("timeout", 30*time.Second, "how long to wait for the daemon to exit")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    pidFile := config.Get().Daemon.PIDFile
+    process, err := readPID(pidFile)
+    if err != nil {
+        return fmt.Errorf("not running: %v", err)
+    }
+    if err := process.Signal(syscall.SIGTERM); err != nil {
+        return err
+    }
+
+    deadline := time.Now().Add(*timeout)
+    for time.Now().Before(deadline) {
+        if err := process.Signal(syscall.Signal(0)); err != nil {
+            os.Remove(pidFile)
+            fmt.Printf("Stopped PID %d\n", process.Pid)
+            return nil
+        }
+        time.Sleep(100 * time.Millisecond)
+    }
+    return fmt.Errorf("PID %d did not exit within %v", process.Pid, *timeout)
+}
+
+// runDaemonStatus reports whether the daemon is running
+func runDaemonStatus(args []string) error {
+    flags := newFlagSet("daemon status", "")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    process, err := readPID(config.Get().Daemon.PIDFile)
+    if err != nil {
+        return fmt.Errorf("not running: %v", err)
+    }
+    fmt.Printf("Running with PID %d\n", process.Pid)
+    return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "net/http"
+    "net/url"
+    "sort"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+)
+
+func init() {
+    registerCommand("diff", "list keys whose values differ between a database file and another file or a peer", runDiff)
+}
+
+// difference is a key with different values on both sides; nil means missing
+type difference struct {
+    key   []byte
+    local []byte
+    other []byte
+}
+
+// loadEntries reads every key and value of a database file
+func loadEntries(path string) (map[string][]byte, error) {
+    file, err := dbfile.Open(path, true)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    entries := make(map[string][]byte)
+    err = file.ForEach(func(key, value []byte) error {
+        entries[string(key)] = bytes.Clone(value)
+        return nil
+    })
+    return entries, err
+}
+
+// loadPeerAccounts pages through the /accounts endpoint of a peer
+func loadPeerAccounts(peer string) (map[string][]byte, error) {
+    client, scheme, err := newPeerClient(config.Get().PeerTLS, 30*time.Second)
+    if err != nil {
+        return nil, err
+    }
+    entries := make(map[string][]byte)
+
+    after := ""
+    for {
+        query := url.Values{"limit": {"1000"}}
+        if after != "" {
+            query.Set("after", after)
+        }
+        resp, err := client.Get(fmt.Sprintf("%s://%s/accounts?%s", scheme, peer, query.Encode()))
+        if err != nil {
+            return nil, err
+        }
+
+        var page struct {
+            Accounts []struct {
+                Address string `json:"address"`
+                Balance string `json:"balance"`
+            } `json:"accounts"`
+            Next string `json:"next"`
+        }
+        err = json.NewDecoder(resp.Body).Decode(&page)
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            return nil, fmt.Errorf("peer %s returned HTTP %d", peer, resp.StatusCode)
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        for _, account := range page.Accounts {
+            address, err := hex.DecodeString(account.Address)
+            balance, ok := new(big.Int).SetString(account.Balance, 10)
+            if err != nil || !ok {
+                return nil, fmt.Errorf("peer %s returned an invalid account", peer)
+            }
+            entries[string(address)] = balance.Bytes()
+        }
+
+        if page.Next == "" {
+            return entries, nil
+        }
+        after = page.Next
+    }
+}
+
+// diffEntries returns the keys that are missing on one side or hold different values, sorted by key
+func diffEntries(local, other map[string][]byte) []difference {
+    var differences []difference
+    for key, value := range local {
+        if otherValue, ok := other[key]; !ok || !bytes.Equal(value, otherValue) {
+            differences = append(differences, difference{key: []byte(key), local: value, other: otherValue})
+        }
+    }
+    for key, value := range other {
+        if _, ok := local[key]; !ok {
+            differences = append(differences, difference{key: []byte(key), other: value})
+        }
+    }
+
+    sort.Slice(differences, func(i, j int) bool {
+        return bytes.Compare(differences[i].key, differences[j].key) < 0
+    })
+    return differences
+}
+
+// formatValue renders a value for the diff output
+func formatValue(value []byte) string {
+    if value == nil {
+        return "<missing>"
+    }
+    return hex.EncodeToString(value)
+}
+
+// runDiff compares the database with another file, or its accounts with a peer's
+func runDiff(args []string) error {
+    flags := newFlagSet("diff", "")
+    dbPath := dbFlag(flags)
+    otherPath := flags.String("other", "", "database file to compare with")
+    peer := flags.String("peer", "", "peer (host:port) whose account balances to compare with")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if (*otherPath == "") == (*peer == "") {
+        return errors.New("exactly one of -other or -peer is required")
+    }
+
+    local, err := loadEntries(*dbPath)
+    if err != nil {
+        return err
+    }
+
+    var other map[string][]byte
+    if *otherPath != "" {
+        other, err = loadEntries(*otherPath)
+    } else {
+        // Peers only expose balances, so compare the account keys alone
+        for key := range local {
+            if len(key) != dbservice.AddressLength {
+                delete(local, key)
+            }
+        }
+        other, err = loadPeerAccounts(*peer)
+    }
+    if err != nil {
+        return err
+    }
+
+    differences := diffEntries(local, other)
+    for _, d := range differences {
+        fmt.Printf("%x\n  local: %s\n  other: %s\n", d.key, formatValue(d.local), formatValue(d.other))
+    }
+    fmt.Printf("%d keys compared, %d differ\n", len(local), len(differences))
+    return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+
+    "pwr-stateful-vida/dbfile"
+)
+
+// exportHeader is the first line of an export file
+type exportHeader struct {
+    RootHash string `json:"rootHash"`
+    Leaves   int    `json:"leaves"`
+}
+
+// exportEntry is a single key/value line of an export file
+type exportEntry struct {
+    Key   string `json:"key"`
+    Value string `json:"value"`
+}
+
+func init() {
+    registerCommand("export", "write all keys and values of a database file as JSON lines", runExport)
+    registerCommand("import", "load an export file into an empty database", runImport)
+}
+
+// runExport writes the database in insertion order so that importing it reproduces the root hash
+func runExport(args []string) error {
+    flags := newFlagSet("export", "")
+    dbPath := dbFlag(flags)
+    out := flags.String("out", "", "output file (default stdout)")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    file, err := dbfile.Open(*dbPath, true)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    entries, err := file.Entries()
+    if err != nil {
+        return err
+    }
+
+    var w io.Writer = os.Stdout
+    if *out != "" {
+        f, err := os.Create(*out)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        w = f
+    }
+
+    buffered := bufio.NewWriter(w)
+    encoder := json.NewEncoder(buffered)
+    if err := encoder.Encode(exportHeader{RootHash: hex.EncodeToString(file.RootHash()), Leaves: len(entries)}); err != nil {
+        return err
+    }
+    for _, entry := range entries {
+        if err := encoder.Encode(exportEntry{Key: hex.EncodeToString(entry.Key), Value: hex.EncodeToString(entry.Value)}); err != nil {
+            return err
+        }
+    }
+    if err := buffered.Flush(); err != nil {
+        return err
+    }
+
+    fmt.Fprintf(os.Stderr, "Exported %d entries\n", len(entries))
+    return nil
+}
+
+// runImport replays an export file into the node database and checks the resulting root hash
+func runImport(args []string) error {
+    flags := newFlagSet("import", "")
+    in := flags.String("in", "", "export file to import")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *in == "" {
+        return errors.New("-in is required")
+    }
+
+    f, err := os.Open(*in)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    if rootHash, _ := app.db.GetRootHash(); rootHash != nil {
+        return errors.New("database is not empty")
+    }
+    defer app.db.Close()
+
+    decoder := json.NewDecoder(bufio.NewReader(f))
+    var header exportHeader
+    if err := decoder.Decode(&header); err != nil {
+        return fmt.Errorf("invalid export header: %v", err)
+    }
+
+    count := 0
+    for {
+        var entry exportEntry
+        err := decoder.Decode(&entry)
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return fmt.Errorf("invalid entry %d: %v", count+1, err)
+        }
+
+        key, errKey := hex.DecodeString(entry.Key)
+        value, errValue := hex.DecodeString(entry.Value)
+        if errKey != nil || errValue != nil {
+            return fmt.Errorf("invalid hex in entry %d", count+1)
+        }
+        if err := app.db.SetData(key, value); err != nil {
+            return err
+        }
+        count++
+    }
+
+    rootHash, _ := app.db.GetRootHash()
+    expected, _ := hex.DecodeString(header.RootHash)
+    if !bytes.Equal(rootHash, expected) {
+        app.db.RevertUnsavedChanges()
+        return fmt.Errorf("root hash mismatch after import: got %x, expected %s", rootHash, header.RootHash)
+    }
+
+    if err := app.db.Flush(); err != nil {
+        return err
+    }
+    fmt.Printf("Imported %d entries, root hash %x\n", count, rootHash)
+    return nil
+}
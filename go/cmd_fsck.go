@@ -0,0 +1,141 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "pwr-stateful-vida/dbfile"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+)
+
+func init() {
+    registerCommand("fsck", "check a database file and optionally rebuild its tree", runFsck)
+}
+
+// openPwrgoTree opens the pwrgo tree named name in the merkleTree directory under
+// dir. Pwrgo always opens its trees relative to the working directory, so it is
+// changed to dir while the tree file is opened.
+func openPwrgoTree(dir, name string) (*merkletree.MerkleTree, error) {
+    cwd, err := os.Getwd()
+    if err != nil {
+        return nil, err
+    }
+    if err := os.Chdir(dir); err != nil {
+        return nil, err
+    }
+    defer os.Chdir(cwd)
+    return merkletree.NewMerkleTree(name)
+}
+
+// rebuildTree inserts entries in order into a new tree file at outPath and returns its root hash
+func rebuildTree(entries []dbfile.Entry, outPath string) ([]byte, error) {
+    outPath, err := filepath.Abs(outPath)
+    if err != nil {
+        return nil, err
+    }
+
+    dir, err := os.MkdirTemp("", "vida-rebuild-")
+    if err != nil {
+        return nil, err
+    }
+    defer os.RemoveAll(dir)
+
+    tree, err := openPwrgoTree(dir, "rebuild")
+    if err != nil {
+        return nil, err
+    }
+    for _, entry := range entries {
+        if err := tree.AddOrUpdateData(entry.Key, entry.Value); err != nil {
+            tree.Close()
+            return nil, err
+        }
+    }
+    if err := tree.FlushToDisk(); err != nil {
+        tree.Close()
+        return nil, err
+    }
+    rootHash, _ := tree.GetRootHash()
+    if err := tree.Close(); err != nil {
+        return nil, err
+    }
+
+    data, err := os.ReadFile(filepath.Join(dir, tree.GetPath()))
+    if err != nil {
+        return nil, err
+    }
+    return rootHash, os.WriteFile(outPath, data, 0600)
+}
+
+// runFsck verifies the tree and, with -repair, rebuilds it from the salvageable entries
+func runFsck(args []string) error {
+    flags := newFlagSet("fsck", "")
+    dbPath := dbFlag(flags)
+    repair := flags.Bool("repair", false, "rebuild the tree, dropping leaves without data; the original is kept as .bak")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    absPath, err := filepath.Abs(*dbPath)
+    if err != nil {
+        return err
+    }
+
+    file, err := dbfile.Open(absPath, true)
+    if err != nil {
+        return err
+    }
+    problems, err := file.Verify()
+    if err != nil {
+        file.Close()
+        return err
+    }
+    ordered, orphans, dropped, err := file.Salvage()
+    storedRoot := file.RootHash()
+    file.Close()
+    if err != nil {
+        return err
+    }
+
+    for _, problem := range problems {
+        fmt.Printf("  %s\n", problem)
+    }
+    fmt.Printf("Problems:           %d\n", len(problems))
+    fmt.Printf("Reachable entries:  %d\n", len(ordered))
+    fmt.Printf("Unreachable keys:   %d\n", len(orphans))
+    fmt.Printf("Corrupted leaves:   %d\n", len(dropped))
+
+    // Rebuilding from the reachable entries shows whether the stored root can be reproduced
+    rebuiltPath := absPath + ".rebuild"
+    rebuiltRoot, err := rebuildTree(append(ordered, orphans...), rebuiltPath)
+    if err != nil {
+        return err
+    }
+    reproducible := bytes.Equal(rebuiltRoot, storedRoot)
+    fmt.Printf("Stored root:        %x\n", storedRoot)
+    fmt.Printf("Rebuilt root:       %x\n", rebuiltRoot)
+    fmt.Printf("Root reproducible:  %v\n", reproducible)
+
+    if !*repair || (len(problems) == 0 && reproducible) {
+        os.Remove(rebuiltPath)
+        if len(problems) > 0 {
+            return fmt.Errorf("found %d problems, run with -repair to rebuild the tree", len(problems))
+        }
+        return nil
+    }
+
+    if err := os.Rename(absPath, absPath+".bak"); err != nil {
+        return err
+    }
+    if err := os.Rename(rebuiltPath, absPath); err != nil {
+        return err
+    }
+    if err := app.db.ResetAccountIndex(); err != nil {
+        return err
+    }
+
+    fmt.Printf("Tree rebuilt, original kept at %s.bak\n", absPath)
+    return nil
+}
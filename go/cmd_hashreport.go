@@ -0,0 +1,201 @@
+package main
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/txlog"
+
+    "golang.org/x/crypto/sha3"
+)
+
+func init() {
+    registerCommand("hash-report", "replay a fixed transaction fixture and print the root of every block", runHashReport)
+}
+
+// fixtureBlocks is the number of blocks in the built-in fixture
+const fixtureBlocks = 20
+
+// builtinFixtureDigest is the digest of the built-in fixture. A change that alters it
+// changes consensus and must be rolled out to every node at the same block.
+const builtinFixtureDigest = "5c31887d7b86c67c856b8795ff536d70b4b8cba2b1c41f985ad7c20d95b4e4b9"
+
+// fixtureAddress derives the address of fixture account i
+func fixtureAddress(i int) string {
+    return fmt.Sprintf("f1%038x", i)
+}
+
+// fixtureTransaction builds a transaction record carrying a raw JSON payload
+func fixtureTransaction(block int64, index int, sender, payload string) txlog.Record {
+    return txlog.Record{
+        Type:   txlog.TypeTransaction,
+        Block:  block,
+        Hash:   fmt.Sprintf("0x%064x", block*1000+int64(index)),
+        Sender: "0x" + sender,
+        Data:   hex.EncodeToString([]byte(payload)),
+    }
+}
+
+// builtinFixture returns transfers between the initial accounts and new accounts,
+// mixed with the malformed and edge case payloads the handler has to tolerate.
+// It must never change, or reports from different versions cannot be compared.
+func builtinFixture() []txlog.Record {
+    genesis := []string{
+        "3b4412f57828d1ceb0dbf0d460f7eb1f21fed8b4",
+        "9282d39ca205806473f4fde5bac48ca6dfb9d300",
+        "c767ea1d613eefe0ce1610b18cb047881bafb829",
+        "e68191b7913e72e6f1759531fbfaa089ff02308a",
+    }
+
+    var records []txlog.Record
+    for block := int64(1); block <= fixtureBlocks; block++ {
+        var payloads []string
+        var senders []string
+        for i := 0; i < 5; i++ {
+            sender := genesis[(int(block)+i)%len(genesis)]
+            receiver := fixtureAddress(int(block)*5 + i)
+            amount := new(big.Int).Mul(big.NewInt(block*1000+int64(i)), big.NewInt(1_000_003))
+            senders = append(senders, sender)
+            payloads = append(payloads, fmt.Sprintf(`{"action":"transfer","amount":"%s","receiver":"%s"}`, amount, receiver))
+        }
+
+        // New accounts spend part of what they received in earlier blocks
+        if block > 1 {
+            senders = append(senders, fixtureAddress(int(block-1)*5))
+            payloads = append(payloads, fmt.Sprintf(`{"action":"transfer","amount":"%d","receiver":"%s"}`, block, fixtureAddress(int(block)*5+1)))
+        }
+
+        switch block % 7 {
+        case 1:
+            senders = append(senders, fixtureAddress(9999))
+            payloads = append(payloads, `{"action":"transfer","amount":"1","receiver":"`+genesis[0]+`"}`)
+        case 2:
+            senders = append(senders, genesis[1])
+            payloads = append(payloads, `{"action":"transfer","amount":12345,"receiver":"0x`+fixtureAddress(int(block))+`"}`)
+        case 3:
+            senders = append(senders, genesis[2])
+            payloads = append(payloads, `{"action":"TRANSFER","amount":"7","receiver":"`+genesis[3]+`"}`)
+        case 4:
+            senders = append(senders, genesis[3], genesis[0])
+            payloads = append(payloads, `{"action":"mint","amount":"1000"}`, `not json`)
+        case 5:
+            senders = append(senders, genesis[0], genesis[1])
+            payloads = append(payloads, `{"action":"transfer","amount":"5"}`, `{"action":"transfer","amount":"-5","receiver":"`+genesis[2]+`"}`)
+        }
+
+        for i := range payloads {
+            records = append(records, fixtureTransaction(block, i, senders[i], payloads[i]))
+        }
+        records = append(records, txlog.Record{Type: txlog.TypeBlock, Block: block})
+    }
+    return records
+}
+
+// loadFixture reads a fixture in transaction log format
+func loadFixture(path string) ([]txlog.Record, error) {
+    var records []txlog.Record
+    err := txlog.Read(path, func(record txlog.Record) error {
+        records = append(records, record)
+        return nil
+    })
+    return records, err
+}
+
+// runHashReport applies a fixture to a fresh database and prints the root hash of
+// every block followed by a digest over all of them
+func runHashReport(args []string) error {
+    flags := newFlagSet("hash-report", "")
+    fixture := flags.String("fixture", "", "fixture in transaction log format (default: the built-in fixture)")
+    expect := flags.String("expect", "", "fail unless the digest equals this hex value (default: the reference digest of the built-in fixture)")
+    asJSON := flags.Bool("json", false, "print the report as JSON")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    records := builtinFixture()
+    if *fixture == "" && *expect == "" {
+        *expect = builtinFixtureDigest
+    }
+    if *fixture != "" {
+        path, err := filepath.Abs(*fixture)
+        if err != nil {
+            return err
+        }
+        if records, err = loadFixture(path); err != nil {
+            return err
+        }
+    }
+
+    dir, err := os.MkdirTemp("", "vida-hash-report-")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(dir)
+    a := newCommandApp(dir, config.Get())
+    defer a.db.Close()
+
+    // Handler output would drown the report
+    stdout := os.Stdout
+    os.Stdout, _ = os.Open(os.DevNull)
+    a.initInitialBalances()
+    roots := make(map[int64]string)
+    for _, record := range records {
+        switch record.Type {
+        case txlog.TypeTransaction:
+            a.syncNode().Process(record.Transaction())
+        case txlog.TypeBlock:
+            if record.Reverted {
+                a.syncNode().Discard()
+                continue
+            }
+            root, err := a.commitBlock(record.Block)
+            if err != nil {
+                os.Stdout = stdout
+                return err
+            }
+            roots[record.Block] = hex.EncodeToString(root)
+        }
+    }
+    os.Stdout = stdout
+
+    blocks := make([]int64, 0, len(roots))
+    for block := range roots {
+        blocks = append(blocks, block)
+    }
+    sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+
+    hasher := sha3.NewLegacyKeccak256()
+    for _, block := range blocks {
+        fmt.Fprintf(hasher, "%d:%s\n", block, roots[block])
+    }
+    digest := hex.EncodeToString(hasher.Sum(nil))
+
+    if *asJSON {
+        report := struct {
+            Roots  map[int64]string `json:"roots"`
+            Digest string           `json:"digest"`
+        }{roots, digest}
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        if err := encoder.Encode(report); err != nil {
+            return err
+        }
+    } else {
+        for _, block := range blocks {
+            fmt.Printf("%8d %s\n", block, roots[block])
+        }
+        fmt.Printf("Digest:  %s\n", digest)
+    }
+
+    if *expect != "" && !strings.EqualFold(*expect, digest) {
+        return fmt.Errorf("digest %s does not match expected %s", digest, *expect)
+    }
+    return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/hosting"
+)
+
+func init() {
+    registerCommand("host", "run the nodes of several VIDAs listed in a hosting file, serving their APIs on one port", runHost)
+}
+
+// newTenantApp returns a hosted app for the configuration of a tenant, keeping its
+// state in db
+func newTenantApp(tenant *hosting.Tenant, db *dbservice.DatabaseService) (hosting.Node, error) {
+    cfg := tenant.Node()
+    db.SetBalanceCacheSize(cfg.Memory.BalanceCacheSize)
+    db.SetCanonical(cfg.Canonical)
+    a, err := NewApp(cfg, nil)
+    if err != nil {
+        return nil, err
+    }
+    a.db, a.hosted = db, true
+    return a, nil
+}
+
+// runHost starts a node for each tenant of the hosting file and serves their APIs
+// until interrupted, then stops the nodes
+func runHost(args []string) error {
+    flags := newFlagSet("host", "")
+    path := flags.String("file", "hosting.json", "hosting file listing the tenants")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    cfg, err := hosting.Load(*path)
+    if err != nil {
+        return err
+    }
+    if problems := cfg.Validate(); len(problems) > 0 {
+        for _, problem := range problems {
+            fmt.Println(problem)
+        }
+        return fmt.Errorf("invalid hosting file: %v", problems[0])
+    }
+
+    host, err := hosting.Start(cfg, newTenantApp)
+    if err != nil {
+        return err
+    }
+    server := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: host.Handler()}
+    go func() {
+        var err error
+        if cfg.CertFile != "" {
+            err = server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+        } else {
+            err = server.ListenAndServe()
+        }
+        if err != nil && !errors.Is(err, http.ErrServerClosed) {
+            logger.Error("host HTTP server failed", "port", cfg.Port, "error", err)
+        }
+    }()
+
+    c := make(chan os.Signal, 1)
+    signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+    <-c
+    logger.Info("stopping hosted nodes")
+    server.Close()
+    return host.Stop()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/hosting"
+    "pwr-stateful-vida/testkit"
+)
+
+func TestHostedAppsKeepSeparateStates(t *testing.T) {
+    dir := t.TempDir()
+    sender, receiver := account(1), account(2)
+
+    tests := []struct {
+        name    string
+        genesis int64
+        amounts []int64
+        // want are the balances of the sender and the receiver
+        want [2]int64
+    }{
+        {name: "a", genesis: 500, amounts: []int64{100}, want: [2]int64{400, 100}},
+        {name: "b", genesis: 90, amounts: []int64{7, 8, 100}, want: [2]int64{75, 15}},
+    }
+
+    // Each tenant agrees with its own database, which its node is given on start
+    dbs := make(map[string]*dbservice.DatabaseService)
+    rpcs := make(map[string]*testkit.RPC)
+    hostingFile := hosting.Config{Port: 8080}
+    for i, test := range tests {
+        tenant := test.name
+        peer := testkit.NewPeer(func(int) []byte {
+            root, _ := dbs[tenant].GetRootHash()
+            return root
+        })
+        defer peer.Close()
+        rpcs[tenant] = &testkit.RPC{}
+
+        tenantDir := filepath.Join(dir, "tenants", tenant)
+        writeJSON(t, filepath.Join(tenantDir, "genesis.json"), map[string]interface{}{
+            "balances": map[string]string{hex.EncodeToString(sender): fmt.Sprint(test.genesis)},
+        })
+        writeJSON(t, filepath.Join(tenantDir, "config.json"), map[string]interface{}{
+            "vidaId":      100 + i,
+            "rpcUrl":      "http://rpc.invalid",
+            "peers":       []string{peer.Addr()},
+            "genesisFile": "genesis.json",
+        })
+        hostingFile.Tenants = append(hostingFile.Tenants, hosting.Tenant{Name: tenant})
+    }
+    writeJSON(t, filepath.Join(dir, "hosting.json"), hostingFile)
+
+    cfg, err := hosting.Load(filepath.Join(dir, "hosting.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if problems := cfg.Validate(); len(problems) > 0 {
+        t.Fatal(problems)
+    }
+    host, err := hosting.Start(cfg, func(tenant *hosting.Tenant, db *dbservice.DatabaseService) (hosting.Node, error) {
+        node, err := newTenantApp(tenant, db)
+        if err != nil {
+            return nil, err
+        }
+        dbs[tenant.Name] = db
+        node.(*App).RPC = rpcs[tenant.Name]
+        return node, nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer host.Stop()
+
+    for _, test := range tests {
+        subscription := rpcs[test.name].Subscription
+        for i, amount := range test.amounts {
+            subscription.AddBlock(i+1, testkit.Transfer(sender, receiver, big.NewInt(amount)))
+        }
+        subscription.Sync(10)
+    }
+
+    for i, test := range tests {
+        for j, address := range [][]byte{sender, receiver} {
+            recorder := httptest.NewRecorder()
+            host.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/"+test.name+"/balance?address="+hex.EncodeToString(address), nil))
+            var response struct {
+                Balance string `json:"balance"`
+            }
+            if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+                t.Fatalf("%s: %v (%s)", test.name, err, recorder.Body)
+            }
+            if want := fmt.Sprint(test.want[j]); response.Balance != want {
+                t.Errorf("%s: balance of account %d = %s, want %s", test.name, j+1, response.Balance, want)
+            }
+        }
+        if status := host.Status()[i]; status.LastCheckedBlock != int64(len(test.amounts)) {
+            t.Errorf("%s: last checked block = %d, want %d", test.name, status.LastCheckedBlock, len(test.amounts))
+        }
+    }
+}
+
+// writeJSON writes value to path as JSON, creating its directory
+func writeJSON(t *testing.T, path string, value interface{}) {
+    t.Helper()
+    data, err := json.Marshal(value)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(path, data, 0644); err != nil {
+        t.Fatal(err)
+    }
+}
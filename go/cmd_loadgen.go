@@ -0,0 +1,231 @@
+//go:build wallet
+
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math"
+    "math/big"
+    "math/rand"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/grpcapi"
+    "pwr-stateful-vida/grpcapi/vidapb"
+
+    "github.com/pwrlabs/pwrgo/config/transactions"
+)
+
+func init() {
+    registerCommand("loadgen", "submit transfers to a test VIDA and measure when the node applies them", runLoadgen)
+}
+
+// pendingTransfer is a submitted transfer waiting to show up in its receiver's balance
+type pendingTransfer struct {
+    expected  *big.Int
+    submitted time.Time
+}
+
+// loadAmount draws an amount from the chosen distribution around mean
+func loadAmount(rng *rand.Rand, dist string, mean int64) int64 {
+    var amount int64
+    switch dist {
+    case "uniform":
+        amount = 1 + rng.Int63n(2*mean)
+    case "exp":
+        amount = int64(math.Ceil(rng.ExpFloat64() * float64(mean)))
+    default:
+        amount = mean
+    }
+    if amount < 1 {
+        amount = 1
+    }
+    return amount
+}
+
+// runLoadgen submits transfers at a fixed rate from one wallet to a set of receivers and
+// polls their balances over gRPC to measure the delay until the node applies each transfer
+func runLoadgen(args []string) error {
+    flags := newFlagSet("loadgen", "")
+    path, password := walletFlags(flags)
+    count := flags.Int("transactions", 100, "number of transfers to submit")
+    rate := flags.Float64("rate", 5, "transfers submitted per second")
+    accounts := flags.Int("accounts", 10, "number of receiver accounts")
+    amount := flags.Int64("amount", 10, "fixed amount, or mean amount for the uniform and exp distributions")
+    dist := flags.String("dist", "fixed", "amount distribution: fixed, uniform or exp")
+    seed := flags.Int64("seed", time.Now().UnixNano(), "random seed for receivers and amounts")
+    node := flags.String("node", fmt.Sprintf("localhost:%d", config.Get().GRPC.Port), "gRPC address of the node to observe")
+    timeout := flags.Duration("timeout", 2*time.Minute, "how long to wait for submitted transfers to be applied")
+    token, caFile := grpcFlags(flags)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *count < 1 || *rate <= 0 || *accounts < 1 || *amount < 1 {
+        return errors.New("-transactions, -rate, -accounts and -amount must be positive")
+    }
+    if *dist != "fixed" && *dist != "uniform" && *dist != "exp" {
+        return fmt.Errorf("unknown distribution %q", *dist)
+    }
+
+    w, err := loadWallet(*path, *password)
+    if err != nil {
+        return err
+    }
+
+    conn, err := grpcapi.Dial(*node, *token, *caFile)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    client := vidapb.NewVidaStateClient(conn)
+
+    balanceOf := func(address []byte) (*big.Int, error) {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        resp, err := client.GetBalance(ctx, &vidapb.GetBalanceRequest{Address: hex.EncodeToString(address)})
+        if err != nil {
+            return nil, err
+        }
+        balance, _ := new(big.Int).SetString(resp.Balance, 10)
+        return balance, nil
+    }
+
+    // Receivers are offset by the seed so separate runs do not share accounts
+    rng := rand.New(rand.NewSource(*seed))
+    base := rng.Intn(1 << 30)
+    receivers := make([][]byte, *accounts)
+    expected := make([]*big.Int, *accounts)
+    for i := range receivers {
+        receivers[i] = benchAddress(base + i)
+        if expected[i], err = balanceOf(receivers[i]); err != nil {
+            return fmt.Errorf("failed to query %s: %v", *node, err)
+        }
+    }
+
+    // Nonces are tracked locally since the RPC only reports included transactions
+    feePerByte := w.GetRpc().GetFeePerByte()
+    nonce := w.GetNonce()
+    if nonce == 0 {
+        if response := w.SetPublicKey(w.PublicKey, feePerByte); !response.Success {
+            return fmt.Errorf("failed to register public key: %s", response.Error)
+        }
+        nonce++
+    }
+
+    var mutex sync.Mutex
+    pending := make(map[int][]pendingTransfer)
+    var applied latencies
+    stop := make(chan struct{})
+    stopped := make(chan struct{})
+
+    // A receiver's transfers are applied in order, so its balance tells how many have landed
+    go func() {
+        defer close(stopped)
+        ticker := time.NewTicker(200 * time.Millisecond)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C:
+            }
+
+            mutex.Lock()
+            waiting := make([]int, 0, len(pending))
+            for i := range pending {
+                waiting = append(waiting, i)
+            }
+            mutex.Unlock()
+
+            for _, i := range waiting {
+                balance, err := balanceOf(receivers[i])
+                if err != nil {
+                    continue
+                }
+                now := time.Now()
+                mutex.Lock()
+                queue := pending[i]
+                for len(queue) > 0 && balance.Cmp(queue[0].expected) >= 0 {
+                    applied.add(now.Sub(queue[0].submitted))
+                    queue = queue[1:]
+                }
+                if len(queue) == 0 {
+                    delete(pending, i)
+                } else {
+                    pending[i] = queue
+                }
+                mutex.Unlock()
+            }
+        }
+    }()
+
+    vidaID := int64(config.Get().VidaID)
+    interval := time.Duration(float64(time.Second) / *rate)
+    submitted, rejected := 0, 0
+    start := time.Now()
+    for n := 0; n < *count; n++ {
+        if wait := time.Until(start.Add(time.Duration(n) * interval)); wait > 0 {
+            time.Sleep(wait)
+        }
+
+        i := rng.Intn(*accounts)
+        value := big.NewInt(loadAmount(rng, *dist, *amount))
+        payload, err := encodeTransfer(receivers[i], value)
+        if err != nil {
+            return err
+        }
+        txn, err := transactions.PayableVidaDataTransaction(vidaID, payload, 0, nonce, w.Address, feePerByte)
+        if err != nil {
+            return err
+        }
+        signed, err := w.SignTx(txn)
+        if err != nil {
+            return err
+        }
+
+        sentAt := time.Now()
+        response := w.GetRpc().BroadcastTransaction(signed)
+        if !response.Success {
+            rejected++
+            fmt.Printf("Transfer %d rejected: %s\n", n, response.Error)
+            continue
+        }
+        nonce++
+        submitted++
+
+        mutex.Lock()
+        expected[i] = new(big.Int).Add(expected[i], value)
+        pending[i] = append(pending[i], pendingTransfer{expected: expected[i], submitted: sentAt})
+        mutex.Unlock()
+    }
+    elapsed := time.Since(start)
+
+    deadline := time.Now().Add(*timeout)
+    for time.Now().Before(deadline) {
+        mutex.Lock()
+        remaining := len(pending)
+        mutex.Unlock()
+        if remaining == 0 {
+            break
+        }
+        time.Sleep(200 * time.Millisecond)
+    }
+    close(stop)
+    <-stopped
+
+    outstanding := 0
+    for _, queue := range pending {
+        outstanding += len(queue)
+    }
+
+    fmt.Printf("Submitted:   %d in %v (%.1f tx/s)\n", submitted, elapsed.Round(time.Millisecond), float64(submitted)/elapsed.Seconds())
+    fmt.Printf("Rejected:    %d\n", rejected)
+    fmt.Printf("Applied:     %d\n", applied.count)
+    fmt.Printf("Not applied: %d (insufficient funds or still pending after %v)\n", outstanding, *timeout)
+    fmt.Printf("Latency:     %s\n", &applied)
+    return nil
+}
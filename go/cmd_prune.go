@@ -0,0 +1,121 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/prune"
+    "pwr-stateful-vida/txlog"
+)
+
+func init() {
+    registerCommand("prune", "remove snapshots, archive files and log records older than the retention window", runPrune)
+}
+
+// printPruned prints the outcome of a pruning run
+func printPruned(result prune.Progress) {
+    fmt.Printf("Pruned history before block %d: %d snapshots, %d archive files, %d log records, %d bytes freed\n",
+        result.Cutoff, result.SnapshotsRemoved, result.ArchiveFilesRemoved, result.LogRecordsRemoved, result.BytesFreed)
+}
+
+// runPrune prunes once while the node is stopped; a running node prunes on its configured schedule
+func runPrune(args []string) error {
+    cfg := config.Get()
+    flags := newFlagSet("prune", "")
+    dbPath := dbFlag(flags)
+    keepBlocks := flags.Int64("keep-blocks", cfg.Pruning.KeepBlocks, "number of recent blocks whose history is kept")
+    keepSnapshots := flags.Int("keep-snapshots", cfg.Pruning.KeepSnapshots, "minimum number of snapshots kept")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    // A writable open fails while a node holds the database, which also protects its transaction log
+    file, err := dbfile.Open(*dbPath, false)
+    if errors.Is(err, dbfile.ErrInUse) {
+        return fmt.Errorf("%v; set pruning.schedule to prune from the running node", err)
+    }
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    data, err := file.Get(dbservice.LastCheckedBlockKey)
+    if err != nil {
+        return err
+    }
+
+    options := prune.Options{
+        Checkpoint:    dbservice.DecodeBlockNumber(data),
+        KeepBlocks:    *keepBlocks,
+        KeepSnapshots: *keepSnapshots,
+        SnapshotDir:   cfg.SnapshotDir,
+        ArchiveDir:    cfg.ArchiveDir,
+    }
+    if path := cfg.TxLog; path != "" {
+        options.CompactLog = func(keepAfter int64) (int, error) {
+            return txlog.Compact(path, keepAfter)
+        }
+    }
+
+    result, err := prune.Run(options)
+    if err != nil {
+        return err
+    }
+    printPruned(result)
+    return nil
+}
+
+// pruneLogger logs scheduled pruning runs
+var pruneLogger = logging.For("prune")
+
+// startPruneScheduler prunes in the background on the configured schedule
+func startPruneScheduler() {
+    cfg := config.Get()
+    if cfg.Pruning.Schedule == "" {
+        return
+    }
+
+    go func() {
+        for {
+            next, err := cfg.Pruning.NextRun(time.Now())
+            if err != nil {
+                pruneLogger.Error("pruning disabled", "error", err)
+                return
+            }
+            prune.SetNextRun(next)
+            time.Sleep(time.Until(next))
+
+            pruneLive()
+        }
+    }()
+}
+
+// pruneLive runs pruning against the running node, logging the outcome
+func pruneLive() error {
+    cfg := config.Get()
+    checkpoint, _ := app.db.GetLastCheckedBlock()
+    options := prune.Options{
+        Checkpoint:    checkpoint,
+        KeepBlocks:    cfg.Pruning.KeepBlocks,
+        KeepSnapshots: cfg.Pruning.KeepSnapshots,
+        SnapshotDir:   cfg.SnapshotDir,
+        ArchiveDir:    cfg.ArchiveDir,
+    }
+    if app.transactionLog != nil {
+        options.CompactLog = app.transactionLog.Compact
+    }
+
+    pruneLogger.Info("pruning history", "keepBlocks", cfg.Pruning.KeepBlocks, "checkpoint", checkpoint)
+    result, err := prune.Run(options)
+    if err != nil {
+        pruneLogger.Error("pruning failed", "error", err)
+        return err
+    }
+    pruneLogger.Info("pruning finished", "cutoff", result.Cutoff, "snapshots", result.SnapshotsRemoved,
+        "archiveFiles", result.ArchiveFilesRemoved, "logRecords", result.LogRecordsRemoved, "bytesFreed", result.BytesFreed)
+    return nil
+}
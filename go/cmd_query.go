@@ -0,0 +1,116 @@
+package main
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "strconv"
+    "strings"
+
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+)
+
+func init() {
+    registerCommand("query", "read state from a database file without starting the node", runQuery)
+}
+
+// decodeHex decodes a hex string with or without the 0x prefix
+func decodeHex(value string) ([]byte, error) {
+    decoded, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(value), "0x"))
+    if err != nil {
+        return nil, fmt.Errorf("invalid hex %q", value)
+    }
+    return decoded, nil
+}
+
+// runQuery opens the database read-only and prints the requested value
+func runQuery(args []string) error {
+    flags := newFlagSet("query", "balance <address> | root <block> | checkpoint | raw <hex key> | proof <hex key>")
+    dbPath := dbFlag(flags)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if flags.NArg() == 0 {
+        flags.Usage()
+        return errors.New("missing query")
+    }
+
+    file, err := dbfile.Open(*dbPath, true)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    what, params := flags.Arg(0), flags.Args()[1:]
+    switch {
+    case what == "balance" && len(params) == 1:
+        address, err := decodeHex(params[0])
+        if err != nil {
+            return err
+        }
+        data, err := file.Get(address)
+        if err != nil {
+            return err
+        }
+        fmt.Println(new(big.Int).SetBytes(data))
+
+    case what == "root" && len(params) == 1:
+        blockNumber, err := strconv.ParseInt(params[0], 10, 64)
+        if err != nil {
+            return fmt.Errorf("invalid block number %q", params[0])
+        }
+        rootHash, err := file.Get(app.db.BlockRootHashKey(blockNumber))
+        if err != nil {
+            return err
+        }
+        if rootHash == nil {
+            return fmt.Errorf("no root hash stored for block %d", blockNumber)
+        }
+        fmt.Println(hex.EncodeToString(rootHash))
+
+    case what == "checkpoint" && len(params) == 0:
+        data, err := file.Get(dbservice.LastCheckedBlockKey)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Last checked block: %d\n", dbservice.DecodeBlockNumber(data))
+        fmt.Printf("Root hash:          %x\n", file.RootHash())
+
+    case what == "raw" && len(params) == 1:
+        key, err := decodeHex(params[0])
+        if err != nil {
+            return err
+        }
+        value, err := file.Get(key)
+        if err != nil {
+            return err
+        }
+        if value == nil {
+            return fmt.Errorf("key %x not found", key)
+        }
+        fmt.Println(hex.EncodeToString(value))
+
+    case what == "proof" && len(params) == 1:
+        key, err := decodeHex(params[0])
+        if err != nil {
+            return err
+        }
+        proof, err := file.Proof(key)
+        if err != nil {
+            return err
+        }
+        encoded, err := json.MarshalIndent(proof, "", "  ")
+        if err != nil {
+            return err
+        }
+        fmt.Println(string(encoded))
+
+    default:
+        flags.Usage()
+        return fmt.Errorf("invalid query: %s", strings.Join(flags.Args(), " "))
+    }
+    return nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math/big"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/grpcapi"
+    "pwr-stateful-vida/grpcapi/vidapb"
+    "pwr-stateful-vida/sdk"
+
+    "google.golang.org/grpc"
+)
+
+func init() {
+    registerCommand("repl", "interactive shell for inspecting a database file or a running node", runREPL)
+}
+
+// replHelp lists the commands understood by the shell
+const replHelp = `Commands:
+  balance <address>       balance of an account
+  root [block]            root hash of a block, or the current root
+  checkpoint              last checked block and current root hash
+  diff <from> <to>        root hashes of two blocks and the keys changed between them
+  decode <hex>            decode a transaction payload
+  help                    show this list
+  quit                    leave the shell`
+
+// stateSource is the state a REPL session reads from
+type stateSource interface {
+    balance(address []byte) (*big.Int, error)
+    // rootHash returns the root hash of a block, or the current root for a negative block
+    rootHash(blockNumber int64) ([]byte, error)
+    checkpoint() (int64, []byte, error)
+    close()
+}
+
+// fileSource reads a database file directly
+type fileSource struct {
+    file *dbfile.File
+}
+
+func (s *fileSource) balance(address []byte) (*big.Int, error) {
+    data, err := s.file.Get(address)
+    if err != nil {
+        return nil, err
+    }
+    return new(big.Int).SetBytes(data), nil
+}
+
+func (s *fileSource) rootHash(blockNumber int64) ([]byte, error) {
+    checkpoint, root, err := s.checkpoint()
+    if err != nil || blockNumber < 0 || blockNumber == checkpoint {
+        return root, err
+    }
+    rootHash, err := s.file.Get(app.db.BlockRootHashKey(blockNumber))
+    if err == nil && rootHash == nil {
+        err = fmt.Errorf("no root hash stored for block %d", blockNumber)
+    }
+    return rootHash, err
+}
+
+func (s *fileSource) checkpoint() (int64, []byte, error) {
+    data, err := s.file.Get(dbservice.LastCheckedBlockKey)
+    if err != nil {
+        return 0, nil, err
+    }
+    return dbservice.DecodeBlockNumber(data), s.file.RootHash(), nil
+}
+
+func (s *fileSource) close() {
+    s.file.Close()
+}
+
+// nodeSource queries a running node over gRPC
+type nodeSource struct {
+    conn   *grpc.ClientConn
+    client vidapb.VidaStateClient
+}
+
+// call runs fn with a request timeout
+func (s *nodeSource) call(fn func(ctx context.Context) error) error {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    return fn(ctx)
+}
+
+func (s *nodeSource) balance(address []byte) (*big.Int, error) {
+    var balance *big.Int
+    err := s.call(func(ctx context.Context) error {
+        resp, err := s.client.GetBalance(ctx, &vidapb.GetBalanceRequest{Address: hex.EncodeToString(address)})
+        if err != nil {
+            return err
+        }
+        balance, _ = new(big.Int).SetString(resp.Balance, 10)
+        return nil
+    })
+    return balance, err
+}
+
+func (s *nodeSource) rootHash(blockNumber int64) ([]byte, error) {
+    if blockNumber < 0 {
+        _, root, err := s.checkpoint()
+        return root, err
+    }
+    var rootHash []byte
+    err := s.call(func(ctx context.Context) error {
+        resp, err := s.client.GetRootHash(ctx, &vidapb.GetRootHashRequest{BlockNumber: blockNumber})
+        if err != nil {
+            return err
+        }
+        rootHash = resp.RootHash
+        return nil
+    })
+    return rootHash, err
+}
+
+func (s *nodeSource) checkpoint() (int64, []byte, error) {
+    var resp *vidapb.GetStatusResponse
+    err := s.call(func(ctx context.Context) (err error) {
+        resp, err = s.client.GetStatus(ctx, &vidapb.GetStatusRequest{})
+        return err
+    })
+    if err != nil {
+        return 0, nil, err
+    }
+    return resp.LastCheckedBlock, resp.RootHash, nil
+}
+
+func (s *nodeSource) close() {
+    s.conn.Close()
+}
+
+// runREPL reads commands from standard input until quit or end of input
+func runREPL(args []string) error {
+    flags := newFlagSet("repl", "")
+    dbPath := dbFlag(flags)
+    node := flags.String("node", "", "gRPC address (host:port) of a running node to query instead of the database file")
+    archiveDir := flags.String("archive", config.Get().ArchiveDir, "archive directory used by diff")
+    token, caFile := grpcFlags(flags)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    var source stateSource
+    if *node != "" {
+        conn, err := grpcapi.Dial(*node, *token, *caFile)
+        if err != nil {
+            return err
+        }
+        source = &nodeSource{conn: conn, client: vidapb.NewVidaStateClient(conn)}
+        fmt.Printf("Connected to node %s\n", *node)
+    } else {
+        file, err := dbfile.Open(*dbPath, true)
+        if errors.Is(err, dbfile.ErrInUse) {
+            return fmt.Errorf("%v; use -node to query the running node", err)
+        }
+        if err != nil {
+            return err
+        }
+        source = &fileSource{file: file}
+        fmt.Printf("Opened %s read-only\n", *dbPath)
+    }
+    defer source.close()
+
+    fmt.Println(`Type "help" for a list of commands.`)
+    return replLoop(os.Stdin, source, *archiveDir)
+}
+
+// replLoop executes one command per input line, printing errors without stopping
+func replLoop(input io.Reader, source stateSource, archiveDir string) error {
+    scanner := bufio.NewScanner(input)
+    for {
+        fmt.Print("vida> ")
+        if !scanner.Scan() {
+            fmt.Println()
+            return scanner.Err()
+        }
+
+        fields := strings.Fields(scanner.Text())
+        if len(fields) == 0 {
+            continue
+        }
+        if fields[0] == "quit" || fields[0] == "exit" {
+            return nil
+        }
+        if err := replCommand(fields[0], fields[1:], source, archiveDir); err != nil {
+            fmt.Printf("error: %v\n", err)
+        }
+    }
+}
+
+// parseBlock parses a block number argument
+func parseBlock(value string) (int64, error) {
+    blockNumber, err := strconv.ParseInt(value, 10, 64)
+    if err != nil || blockNumber < 0 {
+        return 0, fmt.Errorf("invalid block number %q", value)
+    }
+    return blockNumber, nil
+}
+
+// replCommand executes a single shell command
+func replCommand(name string, params []string, source stateSource, archiveDir string) error {
+    switch {
+    case name == "help":
+        fmt.Println(replHelp)
+
+    case name == "balance" && len(params) == 1:
+        address, err := decodeHex(params[0])
+        if err != nil {
+            return err
+        }
+        balance, err := source.balance(address)
+        if err != nil {
+            return err
+        }
+        fmt.Println(balance)
+
+    case name == "root" && len(params) <= 1:
+        blockNumber := int64(-1)
+        if len(params) == 1 {
+            parsed, err := parseBlock(params[0])
+            if err != nil {
+                return err
+            }
+            blockNumber = parsed
+        }
+        rootHash, err := source.rootHash(blockNumber)
+        if err != nil {
+            return err
+        }
+        fmt.Println(hex.EncodeToString(rootHash))
+
+    case name == "checkpoint" && len(params) == 0:
+        blockNumber, rootHash, err := source.checkpoint()
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Last checked block: %d\n", blockNumber)
+        fmt.Printf("Root hash:          %x\n", rootHash)
+
+    case name == "diff" && len(params) == 2:
+        from, err := parseBlock(params[0])
+        if err != nil {
+            return err
+        }
+        to, err := parseBlock(params[1])
+        if err != nil {
+            return err
+        }
+        return diffBlocks(source, archiveDir, from, to)
+
+    case name == "decode" && len(params) == 1:
+        return decodePayload(params[0])
+
+    default:
+        return fmt.Errorf("unknown command %q, type help for a list", strings.Join(append([]string{name}, params...), " "))
+    }
+    return nil
+}
+
+// diffBlocks prints the roots of two blocks and, when an archive is available, the
+// net state changes committed after from up to to
+func diffBlocks(source stateSource, archiveDir string, from, to int64) error {
+    if from > to {
+        from, to = to, from
+    }
+    for _, blockNumber := range []int64{from, to} {
+        rootHash, err := source.rootHash(blockNumber)
+        if err != nil {
+            fmt.Printf("Block %d: %v\n", blockNumber, err)
+        } else {
+            fmt.Printf("Block %d: %x\n", blockNumber, rootHash)
+        }
+    }
+
+    if archiveDir == "" {
+        fmt.Println("No archive configured, state changes are not available")
+        return nil
+    }
+    records, err := archive.Between(archiveDir, from, to)
+    if err != nil {
+        return err
+    }
+
+    // Keep the value before the first change and after the last one
+    changes := make(map[string]*archive.Change)
+    transactions := 0
+    for _, record := range records {
+        transactions += len(record.Transactions)
+        for _, change := range record.Changes {
+            if existing, ok := changes[change.Key]; ok {
+                existing.New = change.New
+            } else {
+                copy := change
+                changes[change.Key] = &copy
+            }
+        }
+    }
+
+    keys := make([]string, 0, len(changes))
+    for key, change := range changes {
+        if change.Old != change.New {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+
+    fmt.Printf("%d archived batches, %d transactions, %d keys changed\n", len(records), transactions, len(keys))
+    for _, key := range keys {
+        change := changes[key]
+        raw, _ := hex.DecodeString(key)
+        fmt.Printf("  %-12s %s: %s -> %s\n", dbservice.KeyNamespace(raw), key, formatArchived(change.Old), formatArchived(change.New))
+    }
+    return nil
+}
+
+// formatArchived renders an archived hex value, marking keys that did not exist
+func formatArchived(value string) string {
+    if value == "" {
+        return "<missing>"
+    }
+    return value
+}
+
+// decodePayload prints a hex transaction payload as indented JSON and describes transfers
+func decodePayload(value string) error {
+    data, err := decodeHex(value)
+    if err != nil {
+        return err
+    }
+
+    payload, err := sdk.DecodePayload(data)
+    if err != nil {
+        fmt.Printf("Not a JSON payload (%d bytes): %q\n", len(data), data)
+        return nil
+    }
+    encoded, _ := json.MarshalIndent(payload, "", "  ")
+    fmt.Println(string(encoded))
+
+    action, _ := payload["action"].(string)
+    if strings.ToLower(action) == "transfer" {
+        receiver, _ := payload["receiver"].(string)
+        fmt.Printf("Transfer of %v to %s\n", payload["amount"], receiver)
+    } else {
+        fmt.Printf("Action %q is ignored by the node\n", action)
+    }
+    return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/txlog"
+)
+
+func init() {
+    registerCommand("replay", "rebuild the state from the transaction log and verify every block root", runReplay)
+}
+
+// copyDatabase writes a consistent copy of the database file at from to to
+func copyDatabase(from, to string) error {
+    file, err := dbfile.Open(from, true)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+        return err
+    }
+    return file.CopyTo(to)
+}
+
+// newCommandApp returns an app for a command replaying blocks offline into a
+// temporary database under dir, configured by cfg
+func newCommandApp(dir string, cfg *config.Config) *App {
+    return &App{Config: cfg, db: newCommandDatabase(dir, cfg)}
+}
+
+// commitBlock checkpoints a block the way a node does once its root reached a quorum
+// and returns the root hash that was compared with peers
+func (a *App) commitBlock(blockNumber int64) ([]byte, error) {
+    root, err := a.syncNode().Commit(int(blockNumber))
+    if err != nil {
+        return nil, err
+    }
+    a.commitIndex(blockNumber)
+    a.commitMirror(blockNumber, root)
+    return root, nil
+}
+
+// runReplay applies the logged transactions to a fresh database in a temporary
+// directory, committing and reverting batches the way the node did
+func runReplay(args []string) error {
+    flags := newFlagSet("replay", "")
+    logPath := flags.String("log", config.Get().TxLog, "transaction log to replay")
+    base := flags.String("base", "", "database or snapshot to start from instead of the initial balances")
+    out := flags.String("out", "", "write the rebuilt database to this file")
+    indexPath := flags.String("index", "", "rebuild the SQLite index at this file from the log")
+    mirrored := flags.Bool("mirror", false, "write the replayed blocks to the configured PostgreSQL mirror, filling its gaps")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *logPath == "" {
+        return errors.New("-log is required")
+    }
+    if *mirrored && config.Get().Mirror.DSN == "" {
+        return errors.New("-mirror needs mirror.dsn in the configuration")
+    }
+
+    paths := []*string{logPath, base, out, indexPath}
+    for _, path := range paths {
+        if *path != "" {
+            abs, err := filepath.Abs(*path)
+            if err != nil {
+                return err
+            }
+            *path = abs
+        }
+    }
+
+    dir, err := os.MkdirTemp("", "vida-replay-")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(dir)
+    a := newCommandApp(dir, config.Get())
+    if *base != "" {
+        if err := copyDatabase(*base, a.db.TreePath()); err != nil {
+            return err
+        }
+    }
+    if *indexPath != "" {
+        // Opened before the initial balances are written, so it observes them
+        if err := os.Remove(*indexPath); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        idx, err := a.openIndex(*indexPath)
+        if err != nil {
+            return err
+        }
+        defer idx.Close()
+        a.index = idx
+    }
+    if *mirrored {
+        m, err := a.openMirror(config.Get().Mirror)
+        if err != nil {
+            return err
+        }
+        // Every replayed block is written, however far the database falls behind
+        m.Blocking = true
+        a.mirror = m
+    }
+    if a.index != nil || a.mirror != nil {
+        a.db.ObserveBalances(a.observeBalance)
+    }
+
+    a.initInitialBalances()
+    checkpoint, err := a.db.GetLastCheckedBlock()
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Replaying %s from block %d\n", *logPath, checkpoint)
+
+    transactions, blocks, reverted, skipped, mismatches := 0, 0, 0, 0, 0
+    err = txlog.Read(*logPath, func(record txlog.Record) error {
+        // Blocks up to the checkpoint were already applied, for example by a
+        // node that reprocessed them after a rollback
+        if record.Block <= checkpoint {
+            skipped++
+            return nil
+        }
+
+        switch record.Type {
+        case txlog.TypeTransaction:
+            a.syncNode().Process(record.Transaction())
+            transactions++
+        case txlog.TypeBlock:
+            if record.Reverted {
+                reverted++
+                _, err := a.syncNode().Discard()
+                return err
+            }
+
+            root, err := a.commitBlock(record.Block)
+            if err != nil {
+                return err
+            }
+            if hex.EncodeToString(root) != record.RootHash {
+                mismatches++
+                fmt.Printf("Block %d: replayed root %x, logged %s\n", record.Block, root, record.RootHash)
+            }
+            checkpoint = record.Block
+            blocks++
+        }
+        return nil
+    })
+    // Transactions after the last block record were never committed by the node
+    a.db.RevertUnsavedChanges()
+    a.db.Close()
+    if a.mirror != nil {
+        a.mirror.Close()
+    }
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Transactions:  %d\n", transactions)
+    fmt.Printf("Blocks:        %d (%d reverted batches, %d records skipped)\n", blocks, reverted, skipped)
+    fmt.Printf("Checkpoint:    %d\n", checkpoint)
+    fmt.Printf("Root mismatch: %d\n", mismatches)
+
+    if *out != "" {
+        if err := copyDatabase(a.db.TreePath(), *out); err != nil {
+            return err
+        }
+        fmt.Printf("Rebuilt database written to %s\n", *out)
+    }
+    if *indexPath != "" {
+        fmt.Printf("Index rebuilt at %s\n", *indexPath)
+    }
+    if *mirrored {
+        fmt.Printf("Mirror written up to block %d\n", checkpoint)
+    }
+    if mismatches > 0 {
+        return fmt.Errorf("%d block roots differ from the log", mismatches)
+    }
+    return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/signing"
+)
+
+func init() {
+    registerCommand("signing-key", "create the key API responses are signed with", runSigningKey)
+}
+
+// runSigningKey creates a node key and prints its public key for consumers to verify signatures with
+func runSigningKey(args []string) error {
+    flags := newFlagSet("signing-key", "")
+    out := flags.String("out", config.Get().Signing.KeyFile, "file to write the key to")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *out == "" {
+        return errors.New("-out is required when signing.keyFile is not configured")
+    }
+
+    publicKey, err := signing.GenerateKey(*out)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Key written to %s\n", *out)
+    fmt.Printf("Public key: %s\n", hex.EncodeToString(publicKey))
+    return nil
+}
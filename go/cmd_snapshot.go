@@ -0,0 +1,113 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "strconv"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/snapshot"
+)
+
+func init() {
+    registerCommand("snapshot", "create or restore database snapshots (create, restore)", runSnapshot)
+    registerCommand("rollback", "restore the newest snapshot at or before a block", runRollback)
+}
+
+// runSnapshot dispatches to the create and restore actions. Without an action a
+// snapshot named after the current checkpoint is written to the snapshot directory.
+func runSnapshot(args []string) error {
+    if len(args) > 0 {
+        switch args[0] {
+        case "create":
+            return runSnapshotCreate(args[1:])
+        case "restore":
+            return runSnapshotRestore(args[1:])
+        }
+    }
+    return runSnapshotCreate(args)
+}
+
+// runSnapshotCreate copies the database to a snapshot file
+func runSnapshotCreate(args []string) error {
+    flags := newFlagSet("snapshot create", "")
+    dbPath := dbFlag(flags)
+    dir := flags.String("dir", config.Get().SnapshotDir, "snapshot directory used when -out is not set")
+    block := flags.Int64("block", -1, "block the snapshot must be taken at; fails if the database is at another block")
+    out := flags.String("out", "", "snapshot file to write")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    var info *snapshot.Info
+    var err error
+    switch {
+    case *out != "":
+        info, err = snapshot.CreateFile(*dbPath, *out, *block)
+    case *block >= 0:
+        info, err = snapshot.CreateFile(*dbPath, filepath.Join(*dir, strconv.FormatInt(*block, 10)+".db"), *block)
+    default:
+        info, err = snapshot.Create(*dbPath, *dir)
+    }
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Snapshot of block %d written to %s (root hash %x)\n", info.BlockNumber, info.Path, info.RootHash)
+    return nil
+}
+
+// runSnapshotRestore replaces the database with a verified snapshot file
+func runSnapshotRestore(args []string) error {
+    flags := newFlagSet("snapshot restore", "")
+    dbPath := dbFlag(flags)
+    in := flags.String("in", "", "snapshot file to restore")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *in == "" {
+        return errors.New("-in is required")
+    }
+
+    info, err := snapshot.Restore(*in, *dbPath)
+    if err != nil {
+        return err
+    }
+    if err := app.db.ResetAccountIndex(); err != nil {
+        return err
+    }
+
+    fmt.Printf("Restored block %d from %s, root hash %x verified\n", info.BlockNumber, *in, info.RootHash)
+    return nil
+}
+
+// runRollback replaces the database with a verified snapshot so the node resumes from its block
+func runRollback(args []string) error {
+    flags := newFlagSet("rollback", "")
+    dbPath := dbFlag(flags)
+    dir := flags.String("dir", config.Get().SnapshotDir, "snapshot directory")
+    block := flags.Int64("block", -1, "block to roll back to")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *block < 0 {
+        return errors.New("-block is required")
+    }
+
+    latest, err := snapshot.Latest(*dir, *block)
+    if err != nil {
+        return err
+    }
+
+    info, err := snapshot.Restore(latest.Path, *dbPath)
+    if err != nil {
+        return err
+    }
+    if err := app.db.ResetAccountIndex(); err != nil {
+        return err
+    }
+
+    fmt.Printf("Rolled back to block %d from %s (root hash %x)\n", info.BlockNumber, latest.Path, info.RootHash)
+    return nil
+}
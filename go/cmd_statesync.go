@@ -0,0 +1,167 @@
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/statesync"
+)
+
+func init() {
+    registerCommand("statesync", "build or repair a database file from verified chunks of peer snapshots", runStateSync)
+}
+
+// agreedManifest asks peers for the manifest of a snapshot and returns it with the
+// peers that serve it. With an expected root hash it is the manifest of that root;
+// otherwise more than two thirds of the peers that answered must agree on it.
+func agreedManifest(client httpPeers, peers []string, blockNumber int64, chunkLeaves int, expected []byte) (*statesync.Manifest, []string, error) {
+    manifests := make(map[string]*statesync.Manifest)
+    sources := make(map[string][]string)
+    answered := 0
+    for _, peer := range peers {
+        manifest, err := client.StateManifest(peer, blockNumber, chunkLeaves)
+        if err != nil {
+            fmt.Printf("  %s: %v\n", peer, err)
+            continue
+        }
+        // The first answer fixes the block the others are asked for
+        blockNumber = manifest.BlockNumber
+        answered++
+        root := string(manifest.RootHash)
+        manifests[root] = manifest
+        sources[root] = append(sources[root], peer)
+        fmt.Printf("  %s: block %d, root hash %x\n", peer, manifest.BlockNumber, manifest.RootHash)
+    }
+    if expected != nil {
+        if manifest, ok := manifests[string(expected)]; ok {
+            return manifest, sources[string(expected)], nil
+        }
+        return nil, nil, fmt.Errorf("no peer serves root hash %x", expected)
+    }
+    for root, agreeing := range sources {
+        if len(agreeing) >= answered*2/3+1 {
+            return manifests[root], agreeing, nil
+        }
+    }
+    return nil, nil, fmt.Errorf("%d peers answered without a two-thirds agreement on a root hash", answered)
+}
+
+// runStateSync fetches the chunks of a peer snapshot that the local database file
+// does not hold, verifying each against the agreed manifest, and rebuilds the file
+func runStateSync(args []string) error {
+    flags := newFlagSet("statesync", "[peer ...]")
+    dbPath := dbFlag(flags)
+    out := flags.String("out", "", "file to write; by default the database is replaced and the original kept as .bak")
+    block := flags.Int64("block", -1, "block of the snapshot to sync; the newest snapshot of the first peer by default")
+    chunkLeaves := flags.Int("chunk-leaves", statesync.DefaultChunkLeaves, "leaves per chunk, a power of two")
+    rootHex := flags.String("root", "", "root hash the snapshot must have, instead of a peer quorum")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    cfg := config.Get()
+    peers := flags.Args()
+    if len(peers) == 0 {
+        peers = cfg.Peers
+    }
+    if len(peers) == 0 {
+        return errors.New("no peers given or configured")
+    }
+    var expected []byte
+    if *rootHex != "" {
+        var err error
+        if expected, err = hex.DecodeString(strings.TrimPrefix(*rootHex, "0x")); err != nil {
+            return fmt.Errorf("invalid -root: %v", err)
+        }
+    }
+    absPath, err := filepath.Abs(*dbPath)
+    if err != nil {
+        return err
+    }
+
+    client, err := newHTTPPeers(cfg.PeerTLS, cfg.Auth.PeerToken, time.Minute)
+    if err != nil {
+        return err
+    }
+    fmt.Println("Manifests:")
+    manifest, sources, err := agreedManifest(client, peers, *block, *chunkLeaves, expected)
+    if err != nil {
+        return err
+    }
+
+    // The reachable part of a damaged file still saves the chunks before the damage
+    var local []dbfile.Entry
+    if _, err := os.Stat(absPath); err == nil {
+        file, err := dbfile.Open(absPath, true)
+        if err != nil {
+            return err
+        }
+        local, _, _, err = file.Salvage()
+        file.Close()
+        if err != nil {
+            return err
+        }
+    }
+    missing := statesync.Diff(manifest, local)
+    fmt.Printf("Block %d: %d leaves in %d chunks, %d to fetch\n", manifest.BlockNumber, manifest.Leaves, manifest.Count(), len(missing))
+
+    entries := make([]dbfile.Entry, manifest.Leaves)
+    copy(entries, local)
+    fetchStart := time.Now()
+    for n, index := range missing {
+        var chunk []dbfile.Entry
+        // Spread the chunks over the sources, moving on to the next when one fails
+        for attempt := 0; attempt < len(sources); attempt++ {
+            peer := sources[(n+attempt)%len(sources)]
+            if chunk, err = client.StateChunk(peer, manifest, index); err == nil {
+                break
+            }
+            fmt.Printf("  chunk %d from %s: %v\n", index, peer, err)
+        }
+        if err != nil {
+            return fmt.Errorf("no peer served chunk %d", index)
+        }
+        start, _ := manifest.Range(index)
+        copy(entries[start:], chunk)
+    }
+    if len(missing) > 0 {
+        fmt.Printf("Fetched %d chunks in %v\n", len(missing), time.Since(fetchStart))
+    }
+
+    target := absPath
+    if *out != "" {
+        target = *out
+    }
+    rebuiltPath := target + ".sync"
+    rootHash, err := rebuildTree(entries, rebuiltPath)
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(rootHash, manifest.RootHash) {
+        os.Remove(rebuiltPath)
+        return fmt.Errorf("rebuilt root hash %x does not match the manifest", rootHash)
+    }
+
+    if *out == "" && local != nil {
+        if err := os.Rename(absPath, absPath+".bak"); err != nil {
+            return err
+        }
+    }
+    if err := os.Rename(rebuiltPath, target); err != nil {
+        return err
+    }
+    if *out == "" {
+        if err := app.db.ResetAccountIndex(); err != nil {
+            return err
+        }
+    }
+    fmt.Printf("Synced block %d to %s, root hash %x verified\n", manifest.BlockNumber, target, rootHash)
+    return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "math/bits"
+    "os"
+    "sort"
+
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+)
+
+func init() {
+    registerCommand("stats", "print statistics about a database file", runStats)
+}
+
+// namespaceStats counts the keys and value bytes of one key namespace
+type namespaceStats struct {
+    keys       int
+    valueBytes int
+}
+
+// sizeBucket returns the histogram bucket of a value size: 0 for empty values,
+// otherwise n for sizes in [2^(n-1), 2^n)
+func sizeBucket(size int) int {
+    return bits.Len(uint(size))
+}
+
+// sizeBucketLabel describes the size range of a histogram bucket
+func sizeBucketLabel(bucket int) string {
+    if bucket == 0 {
+        return "0 B"
+    }
+    low, high := 1<<(bucket-1), 1<<bucket-1
+    if low == high {
+        return fmt.Sprintf("%d B", low)
+    }
+    return fmt.Sprintf("%d-%d B", low, high)
+}
+
+// runStats prints the tree metadata, key counts per namespace, a value size
+// histogram and the page usage of a database file
+func runStats(args []string) error {
+    flags := newFlagSet("stats", "")
+    dbPath := dbFlag(flags)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    file, err := dbfile.Open(*dbPath, true)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    keys := 0
+    namespaces := make(map[string]*namespaceStats)
+    histogram := make(map[int]int)
+    var lastCheckedBlock int64
+    err = file.ForEach(func(key, value []byte) error {
+        keys++
+        namespace := dbservice.KeyNamespace(key)
+        if namespaces[namespace] == nil {
+            namespaces[namespace] = &namespaceStats{}
+        }
+        namespaces[namespace].keys++
+        namespaces[namespace].valueBytes += len(value)
+        histogram[sizeBucket(len(value))]++
+
+        if bytes.Equal(key, dbservice.LastCheckedBlockKey) {
+            lastCheckedBlock = dbservice.DecodeBlockNumber(value)
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    var fileSize int64
+    fmt.Printf("Database:           %s\n", file.Path())
+    if info, err := os.Stat(file.Path()); err == nil {
+        fileSize = info.Size()
+        fmt.Printf("File size:          %d bytes\n", fileSize)
+    }
+    fmt.Printf("Root hash:          %x\n", file.RootHash())
+    fmt.Printf("Last checked block: %d\n", lastCheckedBlock)
+    fmt.Printf("Depth:              %d\n", file.Depth())
+    fmt.Printf("Keys:               %d\n", keys)
+    fmt.Printf("Accounts:           %d\n", namespaces[dbservice.NamespaceAccount].count())
+
+    names := make([]string, 0, len(namespaces))
+    for name := range namespaces {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    fmt.Printf("\nNamespace       Keys   Value bytes\n")
+    for _, name := range names {
+        fmt.Printf("%-12s %7d %13d\n", name, namespaces[name].keys, namespaces[name].valueBytes)
+    }
+
+    buckets := make([]int, 0, len(histogram))
+    for bucket := range histogram {
+        buckets = append(buckets, bucket)
+    }
+    sort.Ints(buckets)
+    fmt.Printf("\nValue size      Keys\n")
+    for _, bucket := range buckets {
+        fmt.Printf("%-12s %7d\n", sizeBucketLabel(bucket), histogram[bucket])
+    }
+
+    // Pages not allocated to any bucket are free; partially filled pages are
+    // fragmented. Both are reclaimed by rewriting the file, e.g. with migrate.
+    bucketStats, err := file.BucketStats()
+    if err != nil {
+        return err
+    }
+    pageSize := file.PageSize()
+    allocated, inUse := 2*pageSize, 2*pageSize
+    fmt.Printf("\nBucket        Entries   Allocated      In use   Fill\n")
+    for _, name := range []string{"metadata", "nodes", "keydata"} {
+        stats, ok := bucketStats[name]
+        if !ok {
+            continue
+        }
+        bucketAlloc := stats.BranchAlloc + stats.LeafAlloc
+        bucketInUse := stats.BranchInuse + stats.LeafInuse
+        allocated += bucketAlloc
+        inUse += bucketInUse
+        fmt.Printf("%-12s %8d %11d %11d %5.1f%%\n", name, stats.KeyN, bucketAlloc, bucketInUse, percent(bucketInUse, bucketAlloc))
+    }
+    if fileSize > 0 {
+        free := int(fileSize) - allocated
+        if free < 0 {
+            free = 0
+        }
+        fmt.Printf("\nPage size:          %d bytes\n", pageSize)
+        fmt.Printf("Free pages:         %d bytes (%.1f%%)\n", free, percent(free, int(fileSize)))
+        fmt.Printf("Fragmentation:      %.1f%% of the file is free or unused page space\n", percent(int(fileSize)-inUse, int(fileSize)))
+    }
+    return nil
+}
+
+// count returns the number of keys, or zero for a namespace that was not seen
+func (s *namespaceStats) count() int {
+    if s == nil {
+        return 0
+    }
+    return s.keys
+}
+
+// percent returns part as a percentage of total
+func percent(part, total int) float64 {
+    if total == 0 {
+        return 0
+    }
+    return float64(part) * 100 / float64(total)
+}
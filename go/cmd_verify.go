@@ -0,0 +1,42 @@
+package main
+
+import (
+    "fmt"
+
+    "pwr-stateful-vida/dbfile"
+)
+
+func init() {
+    registerCommand("verify", "check the integrity of the Merkle tree in a database file", runVerify)
+}
+
+// runVerify recomputes the tree from the stored nodes and reports any inconsistency
+func runVerify(args []string) error {
+    flags := newFlagSet("verify", "")
+    dbPath := dbFlag(flags)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    file, err := dbfile.Open(*dbPath, true)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    problems, err := file.Verify()
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Root hash: %x\n", file.RootHash())
+    for _, problem := range problems {
+        fmt.Printf("  %s\n", problem)
+    }
+    if len(problems) > 0 {
+        return fmt.Errorf("found %d problems", len(problems))
+    }
+
+    fmt.Println("Database is consistent")
+    return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+
+    "pwr-stateful-vida/verifier"
+)
+
+func init() {
+    registerCommand("verify-proof", "check a Merkle inclusion proof against a root hash", runVerifyProof)
+}
+
+// runVerifyProof reads a JSON proof and checks that it leads to the expected root hash
+func runVerifyProof(args []string) error {
+    flags := newFlagSet("verify-proof", "[proof file]")
+    root := flags.String("root", "", "expected root hash (default: the rootHash in the proof)")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    var data []byte
+    var err error
+    if flags.NArg() > 0 {
+        data, err = os.ReadFile(flags.Arg(0))
+    } else {
+        data, err = io.ReadAll(os.Stdin)
+    }
+    if err != nil {
+        return err
+    }
+
+    var proof verifier.Proof
+    if err := json.Unmarshal(data, &proof); err != nil {
+        return fmt.Errorf("invalid proof: %v", err)
+    }
+
+    rootHash := proof.RootHash
+    if *root != "" {
+        if rootHash, err = decodeHex(*root); err != nil {
+            return err
+        }
+    }
+
+    if !proof.Verify(rootHash) {
+        return errors.New("proof is invalid")
+    }
+    fmt.Printf("Proof is valid: key %x holds %x under root %x\n", proof.Key, proof.Value, rootHash)
+    return nil
+}
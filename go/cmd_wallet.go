@@ -0,0 +1,193 @@
+//go:build wallet
+
+// The wallet commands sign with pwrgo's Falcon bindings, which ship prebuilt objects that
+// only link on some platforms, so they are built only with -tags wallet.
+
+package main
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "math/big"
+    "os"
+    "strings"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/secrets"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+    "github.com/pwrlabs/pwrgo/wallet"
+)
+
+// walletPasswordEnv is read when -password is not given
+const walletPasswordEnv = "VIDA_WALLET_PASSWORD"
+
+func init() {
+    registerCommand("wallet", "generate a wallet file or print its address (new, address)", runWallet)
+    registerCommand("send", "sign and submit a transfer to the VIDA", runSend)
+}
+
+// walletFlags registers the flags locating and unlocking a wallet file
+func walletFlags(flags *flag.FlagSet) (path, password *string) {
+    path = flags.String("wallet", "wallet.dat", "wallet file")
+    password = flags.String("password", "", "wallet password or a secret reference such as keychain:vida/wallet (default $"+walletPasswordEnv+")")
+    return path, password
+}
+
+// walletPassword returns the password flag or the environment fallback, resolving
+// secret references so the password itself stays off the command line
+func walletPassword(password string) (string, error) {
+    if password == "" {
+        password = os.Getenv(walletPasswordEnv)
+    }
+    password, err := secrets.Resolve(password)
+    if err != nil {
+        return "", err
+    }
+    if password == "" {
+        return "", errors.New("a wallet password is required")
+    }
+    return password, nil
+}
+
+// loadWallet decrypts a wallet file connected to the configured RPC node
+func loadWallet(path, password string) (*wallet.PWRWallet, error) {
+    password, err := walletPassword(password)
+    if err != nil {
+        return nil, err
+    }
+    w, err := wallet.LoadWallet(path, password, rpc.SetRpcNodeUrl(config.Get().RPCURL))
+    if err != nil {
+        return nil, fmt.Errorf("failed to load wallet %s: %v", path, err)
+    }
+    return w, nil
+}
+
+// encodeTransfer encodes a transfer the way handleTransfer expects it
+func encodeTransfer(receiver []byte, amount *big.Int) ([]byte, error) {
+    return json.Marshal(map[string]string{
+        "action":   "transfer",
+        "receiver": hex.EncodeToString(receiver),
+        "amount":   amount.String(),
+    })
+}
+
+// parseAddress decodes a hex address with or without 0x prefix
+func parseAddress(value string) ([]byte, error) {
+    address, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+    if err != nil || len(address) != dbservice.AddressLength {
+        return nil, fmt.Errorf("invalid address %q", value)
+    }
+    return address, nil
+}
+
+// runWallet dispatches to the wallet actions
+func runWallet(args []string) error {
+    if len(args) == 0 {
+        return errors.New("expected an action: new or address")
+    }
+    switch args[0] {
+    case "new":
+        return runWalletNew(args[1:])
+    case "address":
+        return runWalletAddress(args[1:])
+    }
+    return fmt.Errorf("unknown wallet action %q", args[0])
+}
+
+// runWalletNew generates a random wallet and stores it encrypted
+func runWalletNew(args []string) error {
+    flags := newFlagSet("wallet new", "")
+    path, password := walletFlags(flags)
+    words := flags.Int("words", 12, "seed phrase length (12, 15, 18, 21 or 24)")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    pass, err := walletPassword(*password)
+    if err != nil {
+        return err
+    }
+    if _, err := os.Stat(*path); err == nil {
+        return fmt.Errorf("%s already exists", *path)
+    }
+
+    w, err := wallet.NewRandom(*words)
+    if err != nil {
+        return err
+    }
+    if err := w.StoreWallet(*path, pass); err != nil {
+        return err
+    }
+
+    fmt.Printf("Address:     %s\n", w.GetAddress())
+    fmt.Printf("Seed phrase: %s\n", w.GetSeedPhrase())
+    fmt.Printf("Wallet written to %s\n", *path)
+    return nil
+}
+
+// runWalletAddress prints the address of a wallet file and its PWR nonce and balance
+func runWalletAddress(args []string) error {
+    flags := newFlagSet("wallet address", "")
+    path, password := walletFlags(flags)
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    w, err := loadWallet(*path, *password)
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Address: %s\n", w.GetAddress())
+    fmt.Printf("Nonce:   %d\n", w.GetNonce())
+    fmt.Printf("PWR:     %d\n", w.GetBalance())
+    return nil
+}
+
+// runSend submits a transfer transaction to the configured VIDA
+func runSend(args []string) error {
+    flags := newFlagSet("send", "")
+    path, password := walletFlags(flags)
+    to := flags.String("to", "", "receiver address")
+    amount := flags.String("amount", "", "amount to transfer")
+    fee := flags.Int("fee-per-byte", 0, "fee per byte (default: current network fee)")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    receiver, err := parseAddress(*to)
+    if err != nil {
+        return err
+    }
+    value, ok := new(big.Int).SetString(*amount, 10)
+    if !ok || value.Sign() <= 0 {
+        return fmt.Errorf("invalid amount %q", *amount)
+    }
+
+    w, err := loadWallet(*path, *password)
+    if err != nil {
+        return err
+    }
+    payload, err := encodeTransfer(receiver, value)
+    if err != nil {
+        return err
+    }
+
+    feePerByte := *fee
+    if feePerByte == 0 {
+        feePerByte = w.GetRpc().GetFeePerByte()
+    }
+
+    response := w.SendVidaData(int(config.Get().VidaID), payload, feePerByte)
+    if !response.Success {
+        return fmt.Errorf("transaction rejected: %s", response.Error)
+    }
+
+    fmt.Printf("Transfer of %s from %s to %s submitted, hash %s\n", value, w.GetAddress(), *to, response.Hash)
+    return nil
+}
@@ -0,0 +1,695 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "os"
+    "sync"
+    "time"
+)
+
+// DefaultPath is the configuration file read when no path is given
+const DefaultPath = "config.json"
+
+// SupplyConfig lists the addresses excluded from circulating supply
+type SupplyConfig struct {
+    // BurnAddresses hold tokens that can never be spent again
+    BurnAddresses []string `json:"burnAddresses"`
+    // LockedAddresses hold treasury or vesting tokens that are not circulating
+    LockedAddresses []string `json:"lockedAddresses"`
+}
+
+// HTTPConfig controls the REST API server
+type HTTPConfig struct {
+    Port int `json:"port"`
+    // AccessLog enables structured access logging
+    AccessLog bool `json:"accessLog"`
+    // AccessLogSampleRate is the fraction of successful requests that are logged
+    AccessLogSampleRate float64 `json:"accessLogSampleRate"`
+    // Explorer serves the block explorer web UI at /explorer/
+    Explorer bool `json:"explorer"`
+
+    // MaxConnections caps the open connections, 0 for no cap
+    MaxConnections int `json:"maxConnections"`
+    // ReadHeaderTimeout and IdleTimeout close connections that are slow to send a
+    // request or idle between requests, such as "5s"
+    ReadHeaderTimeout string `json:"readHeaderTimeout"`
+    IdleTimeout       string `json:"idleTimeout"`
+    // PeerRequestsPerSecond and PeerBurst are the request budget of each client IP on
+    // the peer endpoints (/rootHash and /rootHashes); 0 disables the budget
+    PeerRequestsPerSecond float64 `json:"peerRequestsPerSecond"`
+    PeerBurst             int     `json:"peerBurst"`
+    // PeerWriteTimeout bounds the time to write a peer endpoint response, such as "10s"
+    PeerWriteTimeout string `json:"peerWriteTimeout"`
+    // TLS serves the API over HTTPS
+    TLS TLSConfig `json:"tls"`
+}
+
+// TLSConfig controls HTTPS on the API server
+type TLSConfig struct {
+    // CertFile and KeyFile are the PEM certificate and key of the server; empty serves plain HTTP
+    CertFile string `json:"certFile"`
+    KeyFile  string `json:"keyFile"`
+    // ClientCAFile is the PEM bundle of the CAs issuing validator certificates. When
+    // set, the peer endpoints require a client certificate signed by one of them.
+    ClientCAFile string `json:"clientCaFile"`
+}
+
+// PeerTLSConfig controls how root hashes are fetched from peers over HTTPS
+type PeerTLSConfig struct {
+    // CertFile and KeyFile are the client certificate presented to peers; empty fetches over plain HTTP
+    CertFile string `json:"certFile"`
+    KeyFile  string `json:"keyFile"`
+    // CAFile is the PEM bundle verifying peer server certificates, empty for the system roots
+    CAFile string `json:"caFile"`
+}
+
+// AuthConfig controls who can use the HTTP API. Roles are reader, operator and
+// admin, each including the ones before it.
+type AuthConfig struct {
+    // APIKeys maps bearer API keys to their role
+    APIKeys map[string]string `json:"apiKeys"`
+    // JWTSecret verifies HS256 bearer tokens carrying the role in a "role" claim;
+    // empty disables JWTs
+    JWTSecret string `json:"jwtSecret"`
+    // AnonymousRole is the role of requests without credentials, empty to reject them
+    AnonymousRole string `json:"anonymousRole"`
+    // PeerToken is sent as the bearer token when fetching root hashes from peers
+    PeerToken string `json:"peerToken"`
+}
+
+// GRPCConfig controls the gRPC API server. Calls are authenticated like the HTTP API
+// and GetRootHash shares the peer request budgets of http.
+type GRPCConfig struct {
+    Port int `json:"port"`
+    // MaxConnections caps the open connections, 0 for no cap
+    MaxConnections int `json:"maxConnections"`
+    // MaxConcurrentStreams caps the calls in progress on one connection
+    MaxConcurrentStreams int `json:"maxConcurrentStreams"`
+    // TLS serves the API over TLS, with ClientCAFile restricting GetRootHash to
+    // validators like the peer endpoints of http
+    TLS TLSConfig `json:"tls"`
+}
+
+// DiagnosticsConfig controls the pprof and runtime statistics server
+type DiagnosticsConfig struct {
+    // Port of the diagnostics server, 0 disables it
+    Port int `json:"port"`
+    // Address is the interface the server listens on
+    Address string `json:"address"`
+    // Username and Password require HTTP basic authentication when Password is set
+    Username string `json:"username"`
+    Password string `json:"password"`
+}
+
+// MemoryConfig caps the structures the node keeps in memory between checkpoints.
+// Zero disables a cap.
+type MemoryConfig struct {
+    // BalanceCacheSize is the number of balances kept in the LRU balance cache
+    BalanceCacheSize int `json:"balanceCacheSize"`
+    // MaxPendingWrites defers the remaining blocks of a batch to the next checkpoint
+    // once this many writes wait to be flushed
+    MaxPendingWrites int `json:"maxPendingWrites"`
+    // MaxCatchUpQueue defers the remaining blocks of a batch once this many
+    // transactions were applied since the last checkpoint
+    MaxCatchUpQueue int `json:"maxCatchUpQueue"`
+}
+
+// ParallelConfig sets how the plain transfers of a block are applied. The result is
+// the same either way, so nodes may differ.
+type ParallelConfig struct {
+    // Enabled reads and checks transfers touching disjoint accounts concurrently
+    Enabled bool `json:"enabled"`
+    // Workers is the number of goroutines reading balances, the number of CPUs when 0
+    Workers int `json:"workers"`
+}
+
+// DaemonConfig controls the background run mode
+type DaemonConfig struct {
+    PIDFile string `json:"pidFile"`
+    LogFile string `json:"logFile"`
+    // MaxLogSizeMB rotates the log file once it grows past this size, 0 disables it
+    MaxLogSizeMB int `json:"maxLogSizeMB"`
+    // MaxLogAge rotates the log file after this duration, such as "24h"; empty disables it
+    MaxLogAge string `json:"maxLogAge"`
+    // MaxLogBackups is the number of rotated log files kept, 0 keeps all
+    MaxLogBackups int `json:"maxLogBackups"`
+}
+
+// PruningConfig controls the background removal of old snapshots, archive
+// segments and transaction log records
+type PruningConfig struct {
+    // Schedule is either an interval such as "6h" or a daily local time such as
+    // "03:00"; empty disables scheduled pruning
+    Schedule string `json:"schedule"`
+    // KeepBlocks is the number of most recent blocks whose history is kept
+    KeepBlocks int64 `json:"keepBlocks"`
+    // KeepSnapshots is the minimum number of snapshots kept regardless of their age
+    KeepSnapshots int `json:"keepSnapshots"`
+}
+
+// NextRun returns the first scheduled run after now
+func (p PruningConfig) NextRun(now time.Time) (time.Time, error) {
+    if clock, err := time.Parse("15:04", p.Schedule); err == nil {
+        next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+        if !next.After(now) {
+            next = next.AddDate(0, 0, 1)
+        }
+        return next, nil
+    }
+    interval, err := time.ParseDuration(p.Schedule)
+    if err != nil || interval <= 0 {
+        return time.Time{}, fmt.Errorf("invalid schedule %q, expected an interval such as 6h or a time such as 03:00", p.Schedule)
+    }
+    return now.Add(interval), nil
+}
+
+// LoggingConfig controls the node log
+type LoggingConfig struct {
+    // Level is the minimum level logged: debug, info, warn or error
+    Level string `json:"level"`
+    // Format is text or json
+    Format string `json:"format"`
+    // Modules overrides the level per module, such as {"peers": "debug"}
+    Modules map[string]string `json:"modules"`
+    // Outputs are the destinations records are written to; empty writes to stdout only
+    Outputs []LogOutputConfig `json:"outputs"`
+}
+
+// LogOutputConfig is a destination the node log is written to
+type LogOutputConfig struct {
+    // Type is stdout, file or syslog
+    Type string `json:"type"`
+    // Level is the minimum level written to this destination, on top of the module
+    // levels; empty writes every record
+    Level string `json:"level"`
+    // Format is text or json, empty uses the logging format
+    Format string `json:"format"`
+
+    // Path, MaxSizeMB, MaxAge and MaxBackups configure a file destination, which is
+    // rotated like the daemon log
+    Path       string `json:"path"`
+    MaxSizeMB  int    `json:"maxSizeMB"`
+    MaxAge     string `json:"maxAge"`
+    MaxBackups int    `json:"maxBackups"`
+
+    // Network and Address select a remote syslog server, such as "udp" and
+    // "logs.example.com:514"; empty uses the local syslog daemon
+    Network string `json:"network"`
+    Address string `json:"address"`
+    // Tag is the syslog tag, "vida" by default
+    Tag string `json:"tag"`
+}
+
+// EmailConfig describes the SMTP server alerts are mailed through
+type EmailConfig struct {
+    // SMTPAddr is host:port of the server; empty disables email alerts
+    SMTPAddr string   `json:"smtpAddr"`
+    From     string   `json:"from"`
+    To       []string `json:"to"`
+    Username string   `json:"username"`
+    Password string   `json:"password"`
+}
+
+// AlertsConfig controls the notifications sent on consensus anomalies
+type AlertsConfig struct {
+    // MinSeverity is the lowest severity sent: info, warning or critical
+    MinSeverity string `json:"minSeverity"`
+    // DedupWindow suppresses repeats of the same alert for this duration, such as "15m"
+    DedupWindow string `json:"dedupWindow"`
+    // PeerDisagreements is the number of consecutive blocks a peer must disagree on before an alert
+    PeerDisagreements int `json:"peerDisagreements"`
+    // SyncStall raises an alert when no block is processed for this duration; empty disables it
+    SyncStall string `json:"syncStall"`
+    // Webhooks receive alerts as JSON POST requests
+    Webhooks []string `json:"webhooks"`
+    // Commands are run for every alert, each given as a program followed by its arguments
+    Commands [][]string  `json:"commands"`
+    Email    EmailConfig `json:"email"`
+}
+
+// IndexConfig controls the SQLite index of receipts, transfers and balances
+type IndexConfig struct {
+    // Path is the SQLite database file, empty to disable the index
+    Path string `json:"path"`
+}
+
+// PublishConfig sends the applied transfers, finalized blocks and root hash
+// validation results to a message broker
+type PublishConfig struct {
+    // Broker is "kafka" or "nats", empty to disable publishing
+    Broker string `json:"broker"`
+    // Addresses are the Kafka brokers or NATS server URLs
+    Addresses []string `json:"addresses"`
+    // TopicPrefix names the topics, or NATS subjects, <prefix>.transfers,
+    // <prefix>.blocks and <prefix>.validations
+    TopicPrefix string `json:"topicPrefix"`
+    // OutboxPath keeps the events until the broker acknowledges them, with the
+    // cursor of the last acknowledged event in <outboxPath>.cursor
+    OutboxPath string `json:"outboxPath"`
+    // Username and Password authenticate with SASL PLAIN to Kafka or as a NATS user
+    Username string `json:"username"`
+    Password string `json:"password"`
+    // TLS connects to the broker over TLS
+    TLS bool `json:"tls"`
+}
+
+// MirrorConfig keeps the accounts, transfers and finalized blocks in PostgreSQL
+type MirrorConfig struct {
+    // DSN is the PostgreSQL connection string, empty to disable the mirror
+    DSN string `json:"dsn"`
+    // Schema holds the accounts, transfers and blocks tables
+    Schema string `json:"schema"`
+    // QueueSize is the number of committed batches kept while the database falls
+    // behind; later batches are dropped and the gap they leave repaired
+    QueueSize int `json:"queueSize"`
+}
+
+// CloudSnapshotsConfig uploads verified snapshots to S3 or Google Cloud Storage,
+// where new nodes bootstrap from
+type CloudSnapshotsConfig struct {
+    // URL is the bucket and prefix, as s3://bucket/prefix or gs://bucket/prefix;
+    // empty disables uploads
+    URL string `json:"url"`
+    // Endpoint replaces the endpoint of the URL scheme, for other S3 compatible stores
+    Endpoint string `json:"endpoint"`
+    // Region is the S3 region, us-east-1 by default
+    Region string `json:"region"`
+    // AccessKeyID and SecretAccessKey sign the requests; the AWS_ACCESS_KEY_ID and
+    // AWS_SECRET_ACCESS_KEY environment variables are used when they are empty
+    AccessKeyID     string `json:"accessKeyId"`
+    SecretAccessKey string `json:"secretAccessKey"`
+    // IntervalBlocks is how many blocks apart snapshots are uploaded
+    IntervalBlocks int64 `json:"intervalBlocks"`
+    // Keep is the number of uploaded snapshots kept in the store, 0 to keep all
+    Keep int `json:"keep"`
+}
+
+// AuditConfig controls the log of committed balance changes
+type AuditConfig struct {
+    // Path is the audit log file, empty to disable it
+    Path string `json:"path"`
+    // MaxSizeMB rotates the file once it grows past this size, 0 disables rotation
+    MaxSizeMB int `json:"maxSizeMB"`
+    // MaxBackups is the number of rotated files kept, 0 keeps all
+    MaxBackups int `json:"maxBackups"`
+}
+
+// ErrorReportingConfig controls where unexpected errors are reported
+type ErrorReportingConfig struct {
+    // SentryDSN is the DSN of a Sentry compatible server, empty to disable reporting
+    SentryDSN   string `json:"sentryDsn"`
+    Environment string `json:"environment"`
+}
+
+// HealthConfig sets the thresholds of the health score
+type HealthConfig struct {
+    // MinReadyScore is the score, from 0 to 1, below which /readyz reports the node unavailable
+    MinReadyScore float64 `json:"minReadyScore"`
+    // MaxSyncLag is the time since the last checkpoint after which the sync score drops
+    MaxSyncLag string `json:"maxSyncLag"`
+    // MinFreeDiskMB is the free space below which the disk score is 0; the score drops from twice this value
+    MinFreeDiskMB int `json:"minFreeDiskMB"`
+}
+
+// DiskConfig controls the protection against a full database volume
+type DiskConfig struct {
+    // ReadOnlyBelowMB stops advancing the checkpoint while the database volume has less
+    // free space than this; syncing resumes above twice this value. 0 disables the check.
+    ReadOnlyBelowMB int `json:"readOnlyBelowMB"`
+    // CheckInterval is how often free space is checked, such as "30s"
+    CheckInterval string `json:"checkInterval"`
+}
+
+// AuditorConfig runs the node as a third-party auditor, which computes roots and
+// reports whether the network agrees with them but never serves as a peer
+type AuditorConfig struct {
+    Enabled bool `json:"enabled"`
+    // ReportPath is the JSON lines file of the signed per-block reports
+    ReportPath string `json:"reportPath"`
+    // MaxSizeMB rotates the report file past this size, 0 disables rotation
+    MaxSizeMB int `json:"maxSizeMB"`
+    // MaxBackups is the number of rotated files kept, 0 keeps all
+    MaxBackups int `json:"maxBackups"`
+}
+
+// ReplicaConfig runs the node as a read replica, which applies the archived state
+// changes of a primary node instead of syncing and validating roots itself
+type ReplicaConfig struct {
+    // Primary is the host:port of the primary, which needs an archiveDir; empty
+    // disables replica mode
+    Primary string `json:"primary"`
+    // PollInterval is how often the primary is asked for new batches once caught up
+    PollInterval string `json:"pollInterval"`
+    // BatchRecords is the number of archive records fetched per request
+    BatchRecords int `json:"batchRecords"`
+}
+
+// FailoverConfig pairs the node with a hot standby. The node holding the lease syncs
+// and serves peers; the other follows it as a replica and takes the lease over when
+// the active fails its health checks and stops renewing it.
+type FailoverConfig struct {
+    // Peer is the host:port of the other node of the pair, which needs an
+    // archiveDir like this one; empty disables failover
+    Peer string `json:"peer"`
+    // LeasePath is the lease file, on storage both nodes reach
+    LeasePath string `json:"leasePath"`
+    // Name identifies the node in the lease, the host name when empty
+    Name string `json:"name"`
+    // LeaseDuration is how long the lease lasts without being renewed
+    LeaseDuration string `json:"leaseDuration"`
+    // CheckInterval is how often the standby checks the readiness of the active
+    CheckInterval string `json:"checkInterval"`
+    // FailedChecks is the number of consecutive failed checks before the standby
+    // tries to take the lease
+    FailedChecks int `json:"failedChecks"`
+}
+
+// SigningConfig controls the signing of API responses
+type SigningConfig struct {
+    // KeyFile is the node key created with the signing-key command, empty to disable signing
+    KeyFile string `json:"keyFile"`
+    // Key is the hex encoded key itself, normally a secret reference, used instead of KeyFile
+    Key string `json:"key"`
+    // PreviousKeyFile and PreviousKey hold the key being rotated out, which also signs
+    // every response until it is removed after the overlap period
+    PreviousKeyFile string `json:"previousKeyFile"`
+    PreviousKey     string `json:"previousKey"`
+    // NodeID names the node in the governance key registry
+    NodeID string `json:"nodeId"`
+}
+
+// SecretsConfig locates the Vault server that "vault:" secret references are read
+// from. The VAULT_ADDR and VAULT_TOKEN environment variables are used when unset.
+type SecretsConfig struct {
+    VaultAddress string `json:"vaultAddress"`
+    // VaultToken may itself be an env, file or keychain reference
+    VaultToken string `json:"vaultToken"`
+}
+
+// CrossVidaConfig controls the delivery of messages from the VIDAs of the genesis
+// parameters crossVida.sources
+type CrossVidaConfig struct {
+    // WaitTimeout is how long delivery waits for a source to reach a block
+    WaitTimeout string `json:"waitTimeout"`
+}
+
+// FaucetConfig controls the testnet faucet, which sends transfers signed by its own
+// wallet so that every node applies them like any other transaction
+type FaucetConfig struct {
+    // Enabled serves POST /faucet; it also needs a build with -tags wallet
+    Enabled bool `json:"enabled"`
+    // WalletFile is the wallet the faucet sends from, funded in the VIDA state
+    WalletFile string `json:"walletFile"`
+    // Password unlocks WalletFile, normally a secret reference
+    Password string `json:"password"`
+    // Amount is the native amount, as a decimal string, sent per request
+    Amount string `json:"amount"`
+    // Cooldown is how long an address waits between requests
+    Cooldown string `json:"cooldown"`
+    // FeePerByte of the faucet transactions, 0 for the current network fee
+    FeePerByte int `json:"feePerByte"`
+}
+
+// ChaosConfig injects faults to exercise the recovery paths of the node. It only
+// takes effect in binaries built with -tags chaos.
+type ChaosConfig struct {
+    // PeerTimeout, RPCError and FlushFailure are the probabilities, from 0 to 1, of
+    // failing a peer root hash request, an RPC request and a flush
+    PeerTimeout  float64 `json:"peerTimeout"`
+    RPCError     float64 `json:"rpcError"`
+    FlushFailure float64 `json:"flushFailure"`
+    // Seed makes the injected faults reproducible, 0 seeds from the clock
+    Seed int64 `json:"seed"`
+}
+
+// Config is the node configuration
+type Config struct {
+    VidaID      int      `json:"vidaId"`
+    StartBlock  int      `json:"startBlock"`
+    RPCURL      string   `json:"rpcUrl"`
+    Peers       []string `json:"peers"`
+    SnapshotDir string   `json:"snapshotDir"`
+    // TxLog is the path of the transaction log, empty to disable it
+    TxLog string `json:"txLog"`
+    // ArchiveDir is the directory of the per-block state archive, empty to disable it
+    ArchiveDir string `json:"archiveDir"`
+    // SlowTreeOperation logs tree operations taking longer than this duration, such as "250ms"; empty disables it
+    SlowTreeOperation string `json:"slowTreeOperation"`
+    // Shards splits the state across this many tree files by address prefix. It is
+    // part of the root hash, so every node must use the same count, and a database
+    // keeps the count it was created with.
+    Shards int `json:"shards"`
+    // Canonical makes the state follow the specification shared with the other
+    // implementations of this VIDA, so they can be peers. Like Shards it is part of
+    // the genesis: it changes the root hash and a database keeps its setting.
+    Canonical bool `json:"canonical"`
+    // GenesisFile replaces the built-in initial balances and parameters of a new
+    // database with those of a JSON file, see LoadGenesis. Like Canonical it is part
+    // of the genesis.
+    GenesisFile string `json:"genesisFile"`
+
+    HTTP HTTPConfig `json:"http"`
+    // PeerTLS presents a client certificate when fetching root hashes from peers
+    PeerTLS PeerTLSConfig `json:"peerTls"`
+    // Auth assigns roles to HTTP API clients
+    Auth AuthConfig `json:"auth"`
+    GRPC GRPCConfig `json:"grpc"`
+    // Diagnostics serves pprof profiles on a separate port
+    Diagnostics DiagnosticsConfig `json:"diagnostics"`
+    // Parallel applies the independent transfers of a block concurrently
+    Parallel ParallelConfig `json:"parallel"`
+    // Memory caps the caches and buffers of the node
+    Memory  MemoryConfig  `json:"memory"`
+    Supply  SupplyConfig  `json:"supply"`
+    Daemon  DaemonConfig  `json:"daemon"`
+    Pruning PruningConfig `json:"pruning"`
+    Logging LoggingConfig `json:"logging"`
+    Alerts  AlertsConfig  `json:"alerts"`
+    Audit   AuditConfig   `json:"audit"`
+    // Index mirrors committed receipts, transfers and balances into SQLite for queries
+    Index IndexConfig `json:"index"`
+    // Publish emits committed events to Kafka or NATS
+    Publish PublishConfig `json:"publish"`
+    // Mirror keeps a PostgreSQL copy of the finalized state for reporting
+    Mirror MirrorConfig `json:"mirror"`
+    // CloudSnapshots uploads periodic snapshots for new nodes to bootstrap from
+    CloudSnapshots CloudSnapshotsConfig `json:"cloudSnapshots"`
+    // ErrorReporting sends unexpected errors to an error tracker
+    ErrorReporting ErrorReportingConfig `json:"errorReporting"`
+    // Health sets when the node reports itself ready
+    Health HealthConfig `json:"health"`
+    // Disk switches the node to read-only serving when the disk fills up
+    Disk DiskConfig `json:"disk"`
+    // Auditor switches the node to verification-only mode
+    Auditor AuditorConfig `json:"auditor"`
+    // Replica makes the node follow a primary instead of syncing itself
+    Replica ReplicaConfig `json:"replica"`
+    // Failover runs the node as half of an active/standby pair
+    Failover FailoverConfig `json:"failover"`
+    // Secrets configures where secret references are resolved
+    Secrets SecretsConfig `json:"secrets"`
+    // Signing signs balance and root hash responses with the node key
+    Signing SigningConfig `json:"signing"`
+    // CrossVida sets how long the delivery of cross-VIDA messages waits for a source
+    CrossVida CrossVidaConfig `json:"crossVida"`
+    // Faucet sends test tokens to requested addresses
+    Faucet FaucetConfig `json:"faucet"`
+    // Chaos injects faults in test builds
+    Chaos ChaosConfig `json:"chaos"`
+}
+
+var (
+    current  = defaults()
+    loadOnce sync.Once
+    // logger is replaced by package logging with its module logger, since logging
+    // depends on this package
+    logger = slog.Default()
+)
+
+// SetLogger replaces the logger the package reports through
+func SetLogger(l *slog.Logger) {
+    logger = l
+}
+
+// defaults returns the configuration used when no file is present
+func defaults() *Config {
+    return &Config{
+        VidaID:      73746238,
+        StartBlock:  1,
+        RPCURL:      "https://pwrrpc.pwrlabs.io",
+        Peers:       []string{"localhost:8080"},
+        SnapshotDir: "snapshots",
+        TxLog:       "txlog/transactions.log",
+
+        SlowTreeOperation: "250ms",
+        Shards:            1,
+        HTTP: HTTPConfig{
+            Port:                  8080,
+            AccessLog:             true,
+            AccessLogSampleRate:   1,
+            Explorer:              true,
+            MaxConnections:        1024,
+            ReadHeaderTimeout:     "5s",
+            IdleTimeout:           "60s",
+            PeerRequestsPerSecond: 20,
+            PeerBurst:             40,
+            PeerWriteTimeout:      "10s",
+        },
+        Auth: AuthConfig{
+            APIKeys:       map[string]string{},
+            AnonymousRole: "reader",
+        },
+        GRPC: GRPCConfig{
+            Port:                 9090,
+            MaxConnections:       1024,
+            MaxConcurrentStreams: 100,
+        },
+        Diagnostics: DiagnosticsConfig{
+            Address:  "127.0.0.1",
+            Username: "admin",
+        },
+        Memory: MemoryConfig{
+            BalanceCacheSize: 10000,
+        },
+        Supply: SupplyConfig{
+            BurnAddresses:   []string{},
+            LockedAddresses: []string{},
+        },
+        Daemon: DaemonConfig{
+            PIDFile:       "vida.pid",
+            LogFile:       "logs/vida.log",
+            MaxLogSizeMB:  100,
+            MaxLogAge:     "24h",
+            MaxLogBackups: 7,
+        },
+        Logging: LoggingConfig{
+            Level:   "info",
+            Format:  "text",
+            Modules: map[string]string{},
+        },
+        Alerts: AlertsConfig{
+            MinSeverity:       "warning",
+            DedupWindow:       "15m",
+            PeerDisagreements: 3,
+            SyncStall:         "10m",
+            Webhooks:          []string{},
+            Commands:          [][]string{},
+        },
+        Audit: AuditConfig{
+            MaxSizeMB: 100,
+        },
+        Publish: PublishConfig{
+            Addresses:   []string{},
+            TopicPrefix: "vida",
+            OutboxPath:  "publish-outbox.jsonl",
+        },
+        Mirror: MirrorConfig{
+            Schema:    "vida",
+            QueueSize: 1024,
+        },
+        CloudSnapshots: CloudSnapshotsConfig{
+            IntervalBlocks: 10000,
+            Keep:           3,
+        },
+        Pruning: PruningConfig{
+            KeepBlocks:    100000,
+            KeepSnapshots: 2,
+        },
+        Health: HealthConfig{
+            MinReadyScore: 0.7,
+            MaxSyncLag:    "5m",
+            MinFreeDiskMB: 1024,
+        },
+        Disk: DiskConfig{
+            ReadOnlyBelowMB: 256,
+            CheckInterval:   "30s",
+        },
+        Auditor: AuditorConfig{
+            ReportPath: "verification.jsonl",
+            MaxSizeMB:  100,
+        },
+        Replica: ReplicaConfig{
+            PollInterval: "2s",
+            BatchRecords: 100,
+        },
+        Failover: FailoverConfig{
+            LeaseDuration: "15s",
+            CheckInterval: "5s",
+            FailedChecks:  3,
+        },
+        CrossVida: CrossVidaConfig{
+            WaitTimeout: "5m",
+        },
+        Faucet: FaucetConfig{
+            Amount:   "1000",
+            Cooldown: "24h",
+        },
+    }
+}
+
+// Load reads the configuration file at path, keeping defaults for missing fields.
+// A missing file is not an error.
+func Load(path string) (*Config, error) {
+    cfg := defaults()
+
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return cfg, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read config %s: %v", path, err)
+    }
+
+    if err := json.Unmarshal(data, cfg); err != nil {
+        return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+    }
+    if err := checkGenesisFields(data); err != nil {
+        return nil, fmt.Errorf("config %s: %v", path, err)
+    }
+    return cfg, nil
+}
+
+// genesisFields are the sections of Params, which a configuration file held before
+// they became part of the genesis
+var genesisFields = []string{"payload", "policy", "staking", "fees", "swap", "accountRules", "names", "bridge", "settlement", "governance", "monetary", "referral"}
+
+// checkGenesisFields rejects a configuration holding params, which would otherwise
+// be ignored while the node applies those recorded at genesis
+func checkGenesisFields(data []byte) error {
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(data, &fields); err != nil {
+        return err
+    }
+    for _, name := range genesisFields {
+        if _, ok := fields[name]; ok {
+            return fmt.Errorf("%s is part of the genesis, set it in the params of genesisFile", name)
+        }
+    }
+    var crossVida struct {
+        Sources json.RawMessage `json:"sources"`
+    }
+    if raw, ok := fields["crossVida"]; ok && json.Unmarshal(raw, &crossVida) == nil && crossVida.Sources != nil {
+        return fmt.Errorf("crossVida.sources is part of the genesis, set it in the params of genesisFile")
+    }
+    return nil
+}
+
+// Set replaces the active configuration
+func Set(cfg *Config) {
+    loadOnce.Do(func() {})
+    current = cfg
+}
+
+// Get returns the active configuration, loading DefaultPath on first use
+func Get() *Config {
+    loadOnce.Do(func() {
+        if cfg, err := Load(DefaultPath); err == nil {
+            current = cfg
+        } else {
+            logger.Warn("using the default configuration", "path", DefaultPath, "error", err)
+        }
+    })
+    return current
+}
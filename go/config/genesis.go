@@ -0,0 +1,424 @@
+package config
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "os"
+    "strings"
+)
+
+// PayloadParams limit the transaction payloads the node decodes. Zero disables a
+// limit.
+type PayloadParams struct {
+    // MaxBytes is the largest decoded payload, checked on the hex data before decoding
+    MaxBytes int `json:"maxBytes"`
+    // MaxDepth is the deepest nesting of JSON objects and arrays
+    MaxDepth int `json:"maxDepth"`
+    // MaxFieldLength is the longest key or string value
+    MaxFieldLength int `json:"maxFieldLength"`
+    // DecimalAmounts accepts amounts in whole tokens such as "12.5", converted to base
+    // units with the decimals of the token
+    DecimalAmounts bool `json:"decimalAmounts"`
+    // NativeDecimals are the decimals of the native token, which is not registered
+    NativeDecimals int `json:"nativeDecimals"`
+}
+
+// PolicyParams control who changes the transfer policy
+type PolicyParams struct {
+    // Governors are the hex addresses allowed to send policy updates
+    Governors []string `json:"governors"`
+}
+
+// CrossVidaSource is another VIDA whose messages to this one are delivered
+type CrossVidaSource struct {
+    // VidaID of the source
+    VidaID int64 `json:"vidaId"`
+    // Publishers limits the accepted senders to these hex addresses, empty for anyone
+    Publishers []string `json:"publishers"`
+}
+
+// CrossVidaParams list the VIDAs this one consumes messages from
+type CrossVidaParams struct {
+    Sources []CrossVidaSource `json:"sources"`
+}
+
+// BridgeParams list who may mint and release bridged assets
+type BridgeParams struct {
+    // Operators are the hex addresses allowed to mint wrapped tokens and record releases
+    Operators []string `json:"operators"`
+}
+
+// SettlementParams list who may post settlement batches
+type SettlementParams struct {
+    // Operators are the hex addresses allowed to settle batches
+    Operators []string `json:"operators"`
+}
+
+// AccountRulesParams set how account rules are enforced
+type AccountRulesParams struct {
+    // BlocksPerDay is the length in blocks of the days of daily spend limits
+    BlocksPerDay int64 `json:"blocksPerDay"`
+}
+
+// NamesParams set the name registry fees
+type NamesParams struct {
+    // PeriodBlocks is the length in blocks of a registration period
+    PeriodBlocks int64 `json:"periodBlocks"`
+    // FeePerPeriod is the native amount, as a decimal string, charged per period
+    FeePerPeriod string `json:"feePerPeriod"`
+}
+
+// GovernanceParams set the voting rules in force until a proposal changes them
+type GovernanceParams struct {
+    // Quorum is the share of the snapshot total, in basis points, that must vote
+    Quorum int64 `json:"quorum"`
+    // Threshold is the share of the yes and no votes, in basis points, that yes must exceed
+    Threshold int64 `json:"threshold"`
+    // VotingPeriod is the number of blocks a proposal takes votes for
+    VotingPeriod int64 `json:"votingPeriod"`
+    // Deposit is the native amount a proposer pays to the governance account
+    Deposit string `json:"deposit"`
+}
+
+// MintPeriod allows issuing PerBlock native tokens for every block from From until
+// the next period starts
+type MintPeriod struct {
+    From     int64  `json:"from"`
+    PerBlock string `json:"perBlock"`
+}
+
+// MonetaryParams are the genesis monetary policy, in force until governance sets
+// another. Empty fields do not restrict issuance.
+type MonetaryParams struct {
+    // SupplyCap is the most native tokens that can exist
+    SupplyCap string `json:"supplyCap"`
+    // Schedule bounds the total issued by the allowance accrued up to each block
+    Schedule []MintPeriod `json:"schedule"`
+    // Minters are the hex addresses allowed to mint besides the governors
+    Minters []string `json:"minters"`
+    // BurnEnabled lets holders burn native tokens of at least BurnMinAmount
+    BurnEnabled   bool   `json:"burnEnabled"`
+    BurnMinAmount string `json:"burnMinAmount"`
+}
+
+// ReferralParams set the referral payout rules in force until governance changes them
+type ReferralParams struct {
+    // Rate is the share of a qualifying transfer, in basis points, paid to the referrer
+    Rate int64 `json:"rate"`
+    // MaxPayout is the most paid for a single transfer
+    MaxPayout string `json:"maxPayout"`
+    // MinTransfer is the smallest native transfer that qualifies
+    MinTransfer string `json:"minTransfer"`
+}
+
+// FeesParams set the transfer fee schedule. Fees are off while initialBaseFee and
+// minBaseFee are both 0.
+type FeesParams struct {
+    // TargetTransfers is the number of transfers per block the base fee steers towards
+    TargetTransfers int64 `json:"targetTransfers"`
+    // ChangeDenominator bounds the change of the base fee per block to 1/ChangeDenominator of it
+    ChangeDenominator int64 `json:"changeDenominator"`
+    // InitialBaseFee is the decimal fee of the first block charged a fee
+    InitialBaseFee string `json:"initialBaseFee"`
+    // MinBaseFee is the decimal fee the base fee never goes below
+    MinBaseFee string `json:"minBaseFee"`
+}
+
+// SwapParams set the swap pool parameters
+type SwapParams struct {
+    // FeeBasisPoints is the share of each swap input left in the pool, in 1/10000
+    FeeBasisPoints int64 `json:"feeBasisPoints"`
+}
+
+// StakingParams set the unbonding period and epoch rewards
+type StakingParams struct {
+    // UnbondingBlocks is how many blocks unstaked tokens stay locked before they are released
+    UnbondingBlocks int64 `json:"unbondingBlocks"`
+    // EpochBlocks is the length of a reward epoch in blocks
+    EpochBlocks int64 `json:"epochBlocks"`
+    // RewardPerEpoch is the decimal amount minted to delegators at each epoch boundary
+    RewardPerEpoch string `json:"rewardPerEpoch"`
+}
+
+// Params decide which transactions change the state and how. Nodes with different
+// params compute different roots, so they are part of the genesis rather than of the
+// node configuration: a new database records those of its genesis file and every
+// node applies the ones recorded.
+type Params struct {
+    // Payload bounds the transaction data that is decoded
+    Payload PayloadParams `json:"payload"`
+    // Policy lists who may change the transfer policy
+    Policy PolicyParams `json:"policy"`
+    // Staking sets the unbonding period and epoch rewards
+    Staking StakingParams `json:"staking"`
+    // Fees sets the transfer fee schedule
+    Fees FeesParams `json:"fees"`
+    // Swap sets the fee of the swap pools
+    Swap SwapParams `json:"swap"`
+    // AccountRules sets the day length of account spend limits
+    AccountRules AccountRulesParams `json:"accountRules"`
+    // Names sets the registration period and fee of the name registry
+    Names NamesParams `json:"names"`
+    // Bridge lists the operators of the wrapped-asset bridge
+    Bridge BridgeParams `json:"bridge"`
+    // Settlement lists the operators that post netted settlement batches
+    Settlement SettlementParams `json:"settlement"`
+    // CrossVida lists the VIDAs whose messages are delivered to the inbox
+    CrossVida CrossVidaParams `json:"crossVida"`
+    // Governance sets the initial quorum, threshold, voting period and deposit of
+    // proposals
+    Governance GovernanceParams `json:"governance"`
+    // Monetary sets the genesis supply cap, mint schedule and burn rules
+    Monetary MonetaryParams `json:"monetary"`
+    // Referral sets the initial rate, cap and qualifying amount of referral payouts
+    Referral ReferralParams `json:"referral"`
+}
+
+// DefaultParams returns the params of a database whose genesis recorded none
+func DefaultParams() Params {
+    return Params{
+        Payload: PayloadParams{
+            MaxBytes:       16384,
+            MaxDepth:       16,
+            MaxFieldLength: 4096,
+            NativeDecimals: 9,
+        },
+        Staking: StakingParams{
+            UnbondingBlocks: 1000,
+            EpochBlocks:     100,
+            RewardPerEpoch:  "0",
+        },
+        Swap: SwapParams{
+            FeeBasisPoints: 30,
+        },
+        AccountRules: AccountRulesParams{
+            BlocksPerDay: 86400,
+        },
+        Names: NamesParams{
+            PeriodBlocks: 2592000,
+            FeePerPeriod: "0",
+        },
+        Governance: GovernanceParams{
+            Quorum:       2000,
+            Threshold:    5000,
+            VotingPeriod: 50400,
+            Deposit:      "1000000000000",
+        },
+        Fees: FeesParams{
+            TargetTransfers:   100,
+            ChangeDenominator: 8,
+            InitialBaseFee:    "0",
+            MinBaseFee:        "0",
+        },
+        Referral: ReferralParams{
+            MaxPayout:   "0",
+            MinTransfer: "0",
+        },
+    }
+}
+
+// Validate checks the params for values the state transition cannot run with
+func (p Params) Validate() []error {
+    var problems []error
+    fail := func(format string, args ...interface{}) {
+        problems = append(problems, fmt.Errorf(format, args...))
+    }
+    nonNegative := func(value string) bool {
+        amount, ok := new(big.Int).SetString(value, 10)
+        return ok && amount.Sign() >= 0
+    }
+
+    if p.Payload.MaxBytes < 0 || p.Payload.MaxDepth < 0 || p.Payload.MaxFieldLength < 0 {
+        fail("payload.maxBytes, payload.maxDepth and payload.maxFieldLength must not be negative")
+    }
+    if p.Payload.NativeDecimals < 0 || p.Payload.NativeDecimals > 36 {
+        fail("payload.nativeDecimals must be between 0 and 36")
+    }
+
+    for _, governor := range p.Policy.Governors {
+        if !validAddress(governor) {
+            fail("policy.governors: %q is not a 20 byte hex address", governor)
+        }
+    }
+    for _, operator := range p.Bridge.Operators {
+        if !validAddress(operator) {
+            fail("bridge.operators: %q is not a 20 byte hex address", operator)
+        }
+    }
+    for _, operator := range p.Settlement.Operators {
+        if !validAddress(operator) {
+            fail("settlement.operators: %q is not a 20 byte hex address", operator)
+        }
+    }
+
+    sources := make(map[int64]bool)
+    for _, source := range p.CrossVida.Sources {
+        if source.VidaID <= 0 || sources[source.VidaID] {
+            fail("crossVida.sources: %d must be a VIDA ID, listed once", source.VidaID)
+        }
+        sources[source.VidaID] = true
+        for _, publisher := range source.Publishers {
+            if !validAddress(publisher) {
+                fail("crossVida.sources: publisher %q is not a 20 byte hex address", publisher)
+            }
+        }
+    }
+
+    if p.Staking.UnbondingBlocks < 0 {
+        fail("staking.unbondingBlocks must not be negative")
+    }
+    if p.Staking.EpochBlocks <= 0 {
+        fail("staking.epochBlocks must be positive")
+    }
+    if !nonNegative(p.Staking.RewardPerEpoch) {
+        fail("staking.rewardPerEpoch must be a non-negative integer")
+    }
+    if p.Fees.TargetTransfers < 0 {
+        fail("fees.targetTransfers must not be negative")
+    }
+    if p.Fees.ChangeDenominator <= 0 {
+        fail("fees.changeDenominator must be positive")
+    }
+    if !nonNegative(p.Fees.InitialBaseFee) {
+        fail("fees.initialBaseFee must be a non-negative integer")
+    }
+    if !nonNegative(p.Fees.MinBaseFee) {
+        fail("fees.minBaseFee must be a non-negative integer")
+    }
+    if p.Swap.FeeBasisPoints < 0 || p.Swap.FeeBasisPoints >= 10000 {
+        fail("swap.feeBasisPoints must be between 0 and 9999")
+    }
+    if p.AccountRules.BlocksPerDay <= 0 {
+        fail("accountRules.blocksPerDay must be positive")
+    }
+    if p.Names.PeriodBlocks <= 0 {
+        fail("names.periodBlocks must be positive")
+    }
+    if !nonNegative(p.Names.FeePerPeriod) {
+        fail("names.feePerPeriod must be a non-negative integer")
+    }
+    if p.Governance.Quorum < 0 || p.Governance.Quorum > 10000 {
+        fail("governance.quorum must be between 0 and 10000")
+    }
+    if p.Governance.Threshold < 0 || p.Governance.Threshold >= 10000 {
+        fail("governance.threshold must be between 0 and 9999")
+    }
+    if p.Governance.VotingPeriod <= 0 {
+        fail("governance.votingPeriod must be positive")
+    }
+    if !nonNegative(p.Governance.Deposit) {
+        fail("governance.deposit must be a non-negative integer")
+    }
+    if p.Monetary.SupplyCap != "" && !nonNegative(p.Monetary.SupplyCap) {
+        fail("monetary.supplyCap %q is not a non-negative integer", p.Monetary.SupplyCap)
+    }
+    for i, period := range p.Monetary.Schedule {
+        if !nonNegative(period.PerBlock) {
+            fail("monetary.schedule[%d].perBlock %q is not a non-negative integer", i, period.PerBlock)
+        }
+        if period.From < 0 || (i > 0 && period.From <= p.Monetary.Schedule[i-1].From) {
+            fail("monetary.schedule[%d].from must be after the previous period", i)
+        }
+    }
+    for _, minter := range p.Monetary.Minters {
+        if !validAddress(minter) {
+            fail("monetary.minters: %q is not a 20 byte hex address", minter)
+        }
+    }
+    if p.Monetary.BurnMinAmount != "" && !nonNegative(p.Monetary.BurnMinAmount) {
+        fail("monetary.burnMinAmount %q is not a non-negative integer", p.Monetary.BurnMinAmount)
+    }
+    if p.Referral.Rate < 0 || p.Referral.Rate > 10000 {
+        fail("referral.rate must be between 0 and 10000")
+    }
+    if !nonNegative(p.Referral.MaxPayout) {
+        fail("referral.maxPayout %q is not a non-negative integer", p.Referral.MaxPayout)
+    }
+    if !nonNegative(p.Referral.MinTransfer) {
+        fail("referral.minTransfer %q is not a non-negative integer", p.Referral.MinTransfer)
+    }
+    return problems
+}
+
+// Genesis is the initial state of a new database
+type Genesis struct {
+    // Balances are the initial balances by lower case hex address without a 0x prefix
+    Balances map[string]*big.Int
+    // Params are the params of the genesis, nil when it sets none and the defaults apply
+    Params *Params
+}
+
+// LoadGenesis reads a genesis file: a JSON object with the initial "balances", an
+// object of hex addresses to balances in base units as numbers or decimal strings,
+// and optionally the "params", whose missing fields keep their defaults. A file
+// holding the balances object alone is read as a genesis without params. The
+// addresses are returned lower case without a 0x prefix, the form the initial
+// balances are ordered by.
+func LoadGenesis(path string) (*Genesis, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(data, &fields); err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+
+    genesis := &Genesis{}
+    entries := data
+    if balances, ok := fields["balances"]; ok {
+        for name := range fields {
+            if name != "balances" && name != "params" {
+                return nil, fmt.Errorf("%s: unknown field %q", path, name)
+            }
+        }
+        entries = balances
+        if raw, ok := fields["params"]; ok {
+            params := DefaultParams()
+            decoder := json.NewDecoder(bytes.NewReader(raw))
+            decoder.DisallowUnknownFields()
+            if err := decoder.Decode(&params); err != nil {
+                return nil, fmt.Errorf("%s: params: %v", path, err)
+            }
+            if problems := params.Validate(); len(problems) > 0 {
+                return nil, fmt.Errorf("%s: params: %v", path, errors.Join(problems...))
+            }
+            genesis.Params = &params
+        }
+    }
+    if genesis.Balances, err = parseBalances(entries); err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    return genesis, nil
+}
+
+// parseBalances reads the balances object of a genesis file
+func parseBalances(data []byte) (map[string]*big.Int, error) {
+    var entries map[string]json.Number
+    if err := json.Unmarshal(data, &entries); err != nil {
+        return nil, err
+    }
+    if len(entries) == 0 {
+        return nil, errors.New("no balances")
+    }
+
+    balances := make(map[string]*big.Int, len(entries))
+    for address, amount := range entries {
+        if !validAddress(address) {
+            return nil, fmt.Errorf("%q is not a 20 byte hex address", address)
+        }
+        balance, ok := new(big.Int).SetString(amount.String(), 10)
+        if !ok || balance.Sign() < 0 {
+            return nil, fmt.Errorf("balance of %s is not a non-negative integer", address)
+        }
+        key := strings.ToLower(strings.TrimPrefix(address, "0x"))
+        if _, ok := balances[key]; ok {
+            return nil, fmt.Errorf("%s is listed twice", address)
+        }
+        balances[key] = balance
+    }
+    return balances, nil
+}
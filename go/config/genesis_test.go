@@ -0,0 +1,75 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestLoadGenesis(t *testing.T) {
+    tests := []struct {
+        name string
+        file string
+        // operators are the settlement operators of the params, nil for a genesis without params
+        operators []string
+        wantErr   bool
+    }{
+        {name: "balances only", file: `{"c767ea1d613eefe0ce1610b18cb047881bafb829": "10"}`},
+        {name: "balances and params", file: `{"balances": {"c767ea1d613eefe0ce1610b18cb047881bafb829": 10}, "params": {"settlement": {"operators": ["3b4412f57828d1ceb0dbf0d460f7eb1f21fed8b4"]}}}`,
+            operators: []string{"3b4412f57828d1ceb0dbf0d460f7eb1f21fed8b4"}},
+        {name: "invalid params", file: `{"balances": {"c767ea1d613eefe0ce1610b18cb047881bafb829": 10}, "params": {"settlement": {"operators": ["nobody"]}}}`, wantErr: true},
+        {name: "unknown params", file: `{"balances": {"c767ea1d613eefe0ce1610b18cb047881bafb829": 10}, "params": {"settlements": {}}}`, wantErr: true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "genesis.json")
+            if err := os.WriteFile(path, []byte(test.file), 0644); err != nil {
+                t.Fatal(err)
+            }
+            genesis, err := LoadGenesis(path)
+            if test.wantErr {
+                if err == nil {
+                    t.Fatal("LoadGenesis accepted the file")
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if genesis.Balances["c767ea1d613eefe0ce1610b18cb047881bafb829"].Int64() != 10 {
+                t.Errorf("balances = %v", genesis.Balances)
+            }
+            switch {
+            case test.operators == nil && genesis.Params != nil:
+                t.Errorf("params = %+v, want none", genesis.Params)
+            case test.operators != nil && (genesis.Params == nil || len(genesis.Params.Settlement.Operators) != 1 || genesis.Params.Settlement.Operators[0] != test.operators[0]):
+                t.Errorf("params = %+v, want settlement operators %v", genesis.Params, test.operators)
+            case genesis.Params != nil && genesis.Params.Payload != DefaultParams().Payload:
+                t.Errorf("payload params = %+v, want the defaults", genesis.Params.Payload)
+            }
+        })
+    }
+}
+
+func TestLoadRejectsGenesisParams(t *testing.T) {
+    tests := []struct {
+        name    string
+        file    string
+        wantErr bool
+    }{
+        {name: "node settings", file: `{"vidaId": 5, "crossVida": {"waitTimeout": "1m"}}`},
+        {name: "settlement operators", file: `{"settlement": {"operators": []}}`, wantErr: true},
+        {name: "cross-VIDA sources", file: `{"crossVida": {"sources": []}}`, wantErr: true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "config.json")
+            if err := os.WriteFile(path, []byte(test.file), 0644); err != nil {
+                t.Fatal(err)
+            }
+            if _, err := Load(path); (err != nil) != test.wantErr {
+                t.Errorf("Load error = %v, want error %v", err, test.wantErr)
+            }
+        })
+    }
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+    "fmt"
+    "net/url"
+    "os"
+
+    "pwr-stateful-vida/secrets"
+)
+
+// ResolveSecrets replaces the secret references in credential fields with the
+// secrets they name, connecting to Vault first when it is configured
+func (c *Config) ResolveSecrets() error {
+    vault := c.Secrets
+    if vault.VaultAddress == "" {
+        vault.VaultAddress = os.Getenv("VAULT_ADDR")
+    }
+    if vault.VaultToken == "" {
+        vault.VaultToken = os.Getenv("VAULT_TOKEN")
+    }
+    if vault.VaultAddress != "" {
+        token, err := secrets.Resolve(vault.VaultToken)
+        if err != nil {
+            return fmt.Errorf("secrets.vaultToken: %v", err)
+        }
+        secrets.Register("vault", secrets.NewVault(vault.VaultAddress, token))
+    }
+
+    fields := []struct {
+        name  string
+        value *string
+    }{
+        {"rpcUrl", &c.RPCURL},
+        {"auth.jwtSecret", &c.Auth.JWTSecret},
+        {"auth.peerToken", &c.Auth.PeerToken},
+        {"diagnostics.password", &c.Diagnostics.Password},
+        {"alerts.email.password", &c.Alerts.Email.Password},
+        {"errorReporting.sentryDsn", &c.ErrorReporting.SentryDSN},
+        {"signing.key", &c.Signing.Key},
+        {"signing.previousKey", &c.Signing.PreviousKey},
+        {"faucet.password", &c.Faucet.Password},
+        {"publish.password", &c.Publish.Password},
+        {"mirror.dsn", &c.Mirror.DSN},
+        {"cloudSnapshots.secretAccessKey", &c.CloudSnapshots.SecretAccessKey},
+    }
+    for _, field := range fields {
+        value, err := secrets.Resolve(*field.value)
+        if err != nil {
+            return fmt.Errorf("%s: %v", field.name, err)
+        }
+        *field.value = value
+    }
+
+    // API keys are the map keys, so the map is rebuilt
+    apiKeys := make(map[string]string, len(c.Auth.APIKeys))
+    for reference, role := range c.Auth.APIKeys {
+        key, err := secrets.Resolve(reference)
+        if err != nil {
+            return fmt.Errorf("auth.apiKeys: %v", err)
+        }
+        apiKeys[key] = role
+    }
+    c.Auth.APIKeys = apiKeys
+    return nil
+}
+
+// redacted replaces a set credential in printed configurations
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the configuration with credentials replaced, for printing
+func (c *Config) Redacted() *Config {
+    copy := *c
+    for _, value := range []*string{
+        &copy.Auth.JWTSecret,
+        &copy.Auth.PeerToken,
+        &copy.Diagnostics.Password,
+        &copy.Alerts.Email.Password,
+        &copy.ErrorReporting.SentryDSN,
+        &copy.Signing.Key,
+        &copy.Signing.PreviousKey,
+        &copy.Faucet.Password,
+        &copy.Publish.Password,
+        &copy.Mirror.DSN,
+        &copy.CloudSnapshots.SecretAccessKey,
+        &copy.Secrets.VaultToken,
+    } {
+        if *value != "" {
+            *value = redacted
+        }
+    }
+    if rpcURL, err := url.Parse(c.RPCURL); err == nil && rpcURL.User != nil {
+        copy.RPCURL = rpcURL.Redacted()
+    }
+
+    copy.Auth.APIKeys = make(map[string]string, len(c.Auth.APIKeys))
+    i := 0
+    for _, role := range c.Auth.APIKeys {
+        i++
+        copy.Auth.APIKeys[fmt.Sprintf("%s-%d", redacted, i)] = role
+    }
+    return &copy
+}
@@ -0,0 +1,480 @@
+package config
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "net"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// validPort reports whether port is a usable TCP port
+func validPort(port int) bool {
+    return port > 0 && port <= 65535
+}
+
+// validHostPort reports whether address has the host:port form used for peers
+func validHostPort(address string) error {
+    host, port, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+    if host == "" {
+        return fmt.Errorf("missing host")
+    }
+    if n, err := strconv.Atoi(port); err != nil || !validPort(n) {
+        return fmt.Errorf("invalid port %q", port)
+    }
+    return nil
+}
+
+// validAddress reports whether value is a 20 byte hex address
+func validAddress(value string) bool {
+    address, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+    return err == nil && len(address) == 20
+}
+
+// validLevel reports whether name is a log level
+func validLevel(name string) bool {
+    switch strings.ToLower(name) {
+    case "debug", "info", "warn", "error":
+        return true
+    }
+    return false
+}
+
+// validRole reports whether name is an API role
+func validRole(name string) bool {
+    switch strings.ToLower(name) {
+    case "reader", "operator", "admin":
+        return true
+    }
+    return false
+}
+
+// Validate checks the configuration for values the node cannot run with
+func (c *Config) Validate() []error {
+    var problems []error
+    fail := func(format string, args ...interface{}) {
+        problems = append(problems, fmt.Errorf(format, args...))
+    }
+
+    if c.VidaID <= 0 {
+        fail("vidaId must be positive")
+    }
+    if c.StartBlock < 1 {
+        fail("startBlock must be at least 1")
+    }
+
+    if rpcURL, err := url.Parse(c.RPCURL); err != nil {
+        fail("rpcUrl: %v", err)
+    } else if (rpcURL.Scheme != "http" && rpcURL.Scheme != "https") || rpcURL.Host == "" {
+        fail("rpcUrl %q must be an http or https URL", c.RPCURL)
+    }
+
+    if len(c.Peers) == 0 {
+        fail("peers is empty; root hashes can never reach a quorum")
+    }
+    seen := make(map[string]bool)
+    for _, peer := range c.Peers {
+        if err := validHostPort(peer); err != nil {
+            fail("peer %q: %v", peer, err)
+        }
+        if seen[peer] {
+            fail("peer %q is listed twice", peer)
+        }
+        seen[peer] = true
+    }
+
+    if !validPort(c.HTTP.Port) {
+        fail("http.port %d is not a valid port", c.HTTP.Port)
+    }
+    if !validPort(c.GRPC.Port) {
+        fail("grpc.port %d is not a valid port", c.GRPC.Port)
+    }
+    if c.HTTP.Port == c.GRPC.Port {
+        fail("http.port and grpc.port are both %d", c.HTTP.Port)
+    }
+    if c.Diagnostics.Port != 0 {
+        if !validPort(c.Diagnostics.Port) {
+            fail("diagnostics.port %d is not a valid port", c.Diagnostics.Port)
+        }
+        if c.Diagnostics.Port == c.HTTP.Port || c.Diagnostics.Port == c.GRPC.Port {
+            fail("diagnostics.port %d is already used by another server", c.Diagnostics.Port)
+        }
+    }
+    for _, role := range c.Auth.APIKeys {
+        if !validRole(role) {
+            fail("auth.apiKeys: role %q is not reader, operator or admin", role)
+        }
+    }
+    if c.Auth.AnonymousRole != "" && !validRole(c.Auth.AnonymousRole) {
+        fail("auth.anonymousRole %q is not reader, operator or admin", c.Auth.AnonymousRole)
+    }
+    if c.HTTP.AccessLogSampleRate < 0 || c.HTTP.AccessLogSampleRate > 1 {
+        fail("http.accessLogSampleRate must be between 0 and 1")
+    }
+    if c.HTTP.MaxConnections < 0 || c.HTTP.PeerRequestsPerSecond < 0 || c.HTTP.PeerBurst < 0 {
+        fail("http.maxConnections, http.peerRequestsPerSecond and http.peerBurst must not be negative")
+    }
+    if c.HTTP.PeerRequestsPerSecond > 0 && c.HTTP.PeerBurst < 1 {
+        fail("http.peerBurst must be at least 1 when http.peerRequestsPerSecond is set")
+    }
+    if (c.HTTP.TLS.CertFile == "") != (c.HTTP.TLS.KeyFile == "") {
+        fail("http.tls.certFile and http.tls.keyFile must be set together")
+    }
+    if c.HTTP.TLS.ClientCAFile != "" && c.HTTP.TLS.CertFile == "" {
+        fail("http.tls.clientCaFile requires http.tls.certFile and http.tls.keyFile")
+    }
+    if c.GRPC.MaxConnections < 0 || c.GRPC.MaxConcurrentStreams < 0 {
+        fail("grpc.maxConnections and grpc.maxConcurrentStreams must not be negative")
+    }
+    if (c.GRPC.TLS.CertFile == "") != (c.GRPC.TLS.KeyFile == "") {
+        fail("grpc.tls.certFile and grpc.tls.keyFile must be set together")
+    }
+    if c.GRPC.TLS.ClientCAFile != "" && c.GRPC.TLS.CertFile == "" {
+        fail("grpc.tls.clientCaFile requires grpc.tls.certFile and grpc.tls.keyFile")
+    }
+    if (c.PeerTLS.CertFile == "") != (c.PeerTLS.KeyFile == "") {
+        fail("peerTls.certFile and peerTls.keyFile must be set together")
+    }
+    for _, field := range []struct {
+        name  string
+        value string
+    }{{"readHeaderTimeout", c.HTTP.ReadHeaderTimeout}, {"idleTimeout", c.HTTP.IdleTimeout}, {"peerWriteTimeout", c.HTTP.PeerWriteTimeout}} {
+        if field.value == "" {
+            continue
+        }
+        if timeout, err := time.ParseDuration(field.value); err != nil || timeout <= 0 {
+            fail("http.%s %q is not a positive duration", field.name, field.value)
+        }
+    }
+
+    for _, address := range c.Supply.BurnAddresses {
+        if !validAddress(address) {
+            fail("supply.burnAddresses: %q is not a 20 byte hex address", address)
+        }
+    }
+    for _, address := range c.Supply.LockedAddresses {
+        if !validAddress(address) {
+            fail("supply.lockedAddresses: %q is not a 20 byte hex address", address)
+        }
+    }
+
+    if c.Health.MinReadyScore < 0 || c.Health.MinReadyScore > 1 {
+        fail("health.minReadyScore must be between 0 and 1")
+    }
+    if c.Health.MaxSyncLag != "" {
+        if _, err := time.ParseDuration(c.Health.MaxSyncLag); err != nil {
+            fail("health.maxSyncLag: %v", err)
+        }
+    }
+    if c.Health.MinFreeDiskMB < 0 {
+        fail("health.minFreeDiskMB must not be negative")
+    }
+    if c.Disk.ReadOnlyBelowMB < 0 {
+        fail("disk.readOnlyBelowMB must not be negative")
+    }
+    if c.Disk.ReadOnlyBelowMB > 0 {
+        if interval, err := time.ParseDuration(c.Disk.CheckInterval); err != nil || interval <= 0 {
+            fail("disk.checkInterval %q is not a positive duration", c.Disk.CheckInterval)
+        }
+    }
+
+    if c.Signing.Key != "" && c.Signing.KeyFile != "" {
+        fail("signing.key and signing.keyFile are mutually exclusive")
+    }
+    if c.Signing.PreviousKey != "" && c.Signing.PreviousKeyFile != "" {
+        fail("signing.previousKey and signing.previousKeyFile are mutually exclusive")
+    }
+    if (c.Signing.PreviousKey != "" || c.Signing.PreviousKeyFile != "") && c.Signing.Key == "" && c.Signing.KeyFile == "" {
+        fail("signing.previousKey needs a current signing.key or signing.keyFile")
+    }
+    if c.Auditor.Enabled {
+        if c.Auditor.ReportPath == "" {
+            fail("auditor.reportPath is required in auditor mode")
+        }
+        if c.Signing.Key == "" && c.Signing.KeyFile == "" {
+            fail("auditor mode signs its reports and needs signing.key or signing.keyFile")
+        }
+    }
+    if c.Auditor.MaxSizeMB < 0 || c.Auditor.MaxBackups < 0 {
+        fail("auditor.maxSizeMB and auditor.maxBackups must not be negative")
+    }
+    if c.GenesisFile != "" {
+        if c.Canonical {
+            fail("genesisFile and canonical are mutually exclusive, the canonical genesis is fixed")
+        }
+        genesis, err := LoadGenesis(c.GenesisFile)
+        if err != nil {
+            fail("genesisFile: %v", err)
+        } else if genesis.Params != nil {
+            for _, source := range genesis.Params.CrossVida.Sources {
+                if source.VidaID == int64(c.VidaID) {
+                    fail("genesisFile: crossVida.sources lists this VIDA, %d", c.VidaID)
+                }
+            }
+        }
+    }
+    if c.Replica.Primary != "" {
+        if err := validHostPort(c.Replica.Primary); err != nil {
+            fail("replica.primary: %v", err)
+        }
+        if c.Auditor.Enabled {
+            fail("replica.primary and auditor mode are mutually exclusive")
+        }
+    }
+    // A standby follows the active with the replica settings
+    if c.Replica.Primary != "" || c.Failover.Peer != "" {
+        if d, err := time.ParseDuration(c.Replica.PollInterval); err != nil || d <= 0 {
+            fail("replica.pollInterval %q is not a positive duration", c.Replica.PollInterval)
+        }
+        if c.Replica.BatchRecords < 1 || c.Replica.BatchRecords > 1000 {
+            fail("replica.batchRecords must be between 1 and 1000")
+        }
+        // These follow the applied transactions, which a replica never sees
+        if c.Index.Path != "" || c.Publish.Broker != "" || c.Mirror.DSN != "" {
+            fail("a replica applies state changes only, so index.path, publish.broker and mirror.dsn must be empty")
+        }
+    }
+    if c.Failover.Peer != "" {
+        if err := validHostPort(c.Failover.Peer); err != nil {
+            fail("failover.peer: %v", err)
+        }
+        if c.Failover.LeasePath == "" {
+            fail("failover.leasePath is required with failover.peer")
+        }
+        if d, err := time.ParseDuration(c.Failover.LeaseDuration); err != nil || d <= 0 {
+            fail("failover.leaseDuration %q is not a positive duration", c.Failover.LeaseDuration)
+        }
+        if d, err := time.ParseDuration(c.Failover.CheckInterval); err != nil || d <= 0 {
+            fail("failover.checkInterval %q is not a positive duration", c.Failover.CheckInterval)
+        }
+        if c.Failover.FailedChecks < 1 {
+            fail("failover.failedChecks must be at least 1")
+        }
+        if c.ArchiveDir == "" {
+            fail("failover needs archiveDir, which the standby follows the active with")
+        }
+        if c.Replica.Primary != "" || c.Auditor.Enabled {
+            fail("failover.peer cannot be combined with replica.primary or auditor mode")
+        }
+    }
+
+    if timeout, err := time.ParseDuration(c.CrossVida.WaitTimeout); err != nil || timeout <= 0 {
+        fail("crossVida.waitTimeout must be a positive duration")
+    }
+
+    if c.Faucet.Enabled {
+        if c.Faucet.WalletFile == "" {
+            fail("faucet.walletFile is required when the faucet is enabled")
+        }
+        if amount, ok := new(big.Int).SetString(c.Faucet.Amount, 10); !ok || amount.Sign() <= 0 {
+            fail("faucet.amount must be a positive integer")
+        }
+        if cooldown, err := time.ParseDuration(c.Faucet.Cooldown); err != nil || cooldown < 0 {
+            fail("faucet.cooldown must be a non-negative duration")
+        }
+        if c.Faucet.FeePerByte < 0 {
+            fail("faucet.feePerByte must not be negative")
+        }
+    }
+
+    for _, field := range []struct {
+        name  string
+        value float64
+    }{{"peerTimeout", c.Chaos.PeerTimeout}, {"rpcError", c.Chaos.RPCError}, {"flushFailure", c.Chaos.FlushFailure}} {
+        if field.value < 0 || field.value > 1 {
+            fail("chaos.%s must be between 0 and 1", field.name)
+        }
+    }
+
+    if c.Memory.BalanceCacheSize < 0 || c.Memory.MaxPendingWrites < 0 || c.Memory.MaxCatchUpQueue < 0 {
+        fail("memory.balanceCacheSize, memory.maxPendingWrites and memory.maxCatchUpQueue must not be negative")
+    }
+
+    if c.Shards < 1 || c.Shards > 256 {
+        fail("shards must be between 1 and 256")
+    }
+    if c.Canonical && c.Shards != 1 {
+        fail("the canonical state is not sharded, set shards to 1")
+    }
+    if c.Parallel.Workers < 0 {
+        fail("parallel.workers must not be negative")
+    }
+
+    if c.SlowTreeOperation != "" {
+        if _, err := time.ParseDuration(c.SlowTreeOperation); err != nil {
+            fail("slowTreeOperation: %v", err)
+        }
+    }
+
+    if c.Daemon.MaxLogAge != "" {
+        if _, err := time.ParseDuration(c.Daemon.MaxLogAge); err != nil {
+            fail("daemon.maxLogAge: %v", err)
+        }
+    }
+    if c.Daemon.MaxLogSizeMB < 0 || c.Daemon.MaxLogBackups < 0 {
+        fail("daemon.maxLogSizeMB and daemon.maxLogBackups must not be negative")
+    }
+
+    if c.Pruning.Schedule != "" {
+        if _, err := c.Pruning.NextRun(time.Now()); err != nil {
+            fail("pruning.schedule: %v", err)
+        }
+    }
+    if c.Pruning.KeepBlocks < 0 || c.Pruning.KeepSnapshots < 0 {
+        fail("pruning.keepBlocks and pruning.keepSnapshots must not be negative")
+    }
+
+    if c.Logging.Format != "text" && c.Logging.Format != "json" {
+        fail("logging.format must be text or json")
+    }
+    if !validLevel(c.Logging.Level) {
+        fail("logging.level %q is not debug, info, warn or error", c.Logging.Level)
+    }
+    for module, level := range c.Logging.Modules {
+        if !validLevel(level) {
+            fail("logging.modules.%s: %q is not debug, info, warn or error", module, level)
+        }
+    }
+    for i, output := range c.Logging.Outputs {
+        switch output.Type {
+        case "stdout", "syslog":
+        case "file":
+            if output.Path == "" {
+                fail("logging.outputs[%d]: a file output needs a path", i)
+            }
+        default:
+            fail("logging.outputs[%d]: type %q is not stdout, file or syslog", i, output.Type)
+        }
+        if output.Level != "" && !validLevel(output.Level) {
+            fail("logging.outputs[%d]: level %q is not debug, info, warn or error", i, output.Level)
+        }
+        if output.Format != "" && output.Format != "text" && output.Format != "json" {
+            fail("logging.outputs[%d]: format must be text or json", i)
+        }
+        if output.MaxAge != "" {
+            if _, err := time.ParseDuration(output.MaxAge); err != nil {
+                fail("logging.outputs[%d].maxAge: %v", i, err)
+            }
+        }
+        if output.MaxSizeMB < 0 || output.MaxBackups < 0 {
+            fail("logging.outputs[%d]: maxSizeMB and maxBackups must not be negative", i)
+        }
+        if (output.Network == "") != (output.Address == "") {
+            fail("logging.outputs[%d]: syslog network and address must be set together", i)
+        }
+    }
+
+    switch strings.ToLower(c.Alerts.MinSeverity) {
+    case "info", "warning", "critical":
+    default:
+        fail("alerts.minSeverity %q is not info, warning or critical", c.Alerts.MinSeverity)
+    }
+    for _, field := range []struct{ name, value string }{{"dedupWindow", c.Alerts.DedupWindow}, {"syncStall", c.Alerts.SyncStall}} {
+        if field.value == "" {
+            continue
+        }
+        if _, err := time.ParseDuration(field.value); err != nil {
+            fail("alerts.%s: %v", field.name, err)
+        }
+    }
+    if c.Alerts.PeerDisagreements < 1 {
+        fail("alerts.peerDisagreements must be at least 1")
+    }
+    for _, webhook := range c.Alerts.Webhooks {
+        if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+            fail("alerts.webhooks: %q is not an http or https URL", webhook)
+        }
+    }
+    for _, command := range c.Alerts.Commands {
+        if len(command) == 0 || command[0] == "" {
+            fail("alerts.commands: empty command")
+        }
+    }
+    if c.Alerts.Email.SMTPAddr != "" {
+        if err := validHostPort(c.Alerts.Email.SMTPAddr); err != nil {
+            fail("alerts.email.smtpAddr: %v", err)
+        }
+        if c.Alerts.Email.From == "" || len(c.Alerts.Email.To) == 0 {
+            fail("alerts.email needs from and to addresses")
+        }
+    }
+
+    if c.Audit.MaxSizeMB < 0 || c.Audit.MaxBackups < 0 {
+        fail("audit.maxSizeMB and audit.maxBackups must not be negative")
+    }
+
+    if broker := c.Publish.Broker; broker != "" {
+        if broker != "kafka" && broker != "nats" {
+            fail("publish.broker %q is not kafka or nats", broker)
+        }
+        if len(c.Publish.Addresses) == 0 {
+            fail("publish.addresses is empty")
+        }
+        if c.Publish.TopicPrefix == "" || c.Publish.OutboxPath == "" {
+            fail("publish needs a topicPrefix and an outboxPath")
+        }
+    }
+
+    if c.Mirror.DSN != "" {
+        if !validIdentifier(c.Mirror.Schema) {
+            fail("mirror.schema %q is not a lowercase SQL identifier", c.Mirror.Schema)
+        }
+        if c.Mirror.QueueSize < 1 {
+            fail("mirror.queueSize must be at least 1")
+        }
+    }
+
+    if c.CloudSnapshots.URL != "" {
+        if u, err := url.Parse(c.CloudSnapshots.URL); err != nil || (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+            fail("cloudSnapshots.url %q is not an s3:// or gs:// bucket URL", c.CloudSnapshots.URL)
+        }
+        if c.CloudSnapshots.Endpoint != "" {
+            if u, err := url.Parse(c.CloudSnapshots.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+                fail("cloudSnapshots.endpoint %q is not an http or https URL", c.CloudSnapshots.Endpoint)
+            }
+        }
+        if c.CloudSnapshots.IntervalBlocks < 1 {
+            fail("cloudSnapshots.intervalBlocks must be at least 1")
+        }
+        if c.CloudSnapshots.Keep < 0 {
+            fail("cloudSnapshots.keep must not be negative")
+        }
+        if c.Shards > 1 {
+            fail("cloudSnapshots needs a single shard")
+        }
+    }
+
+    if c.SnapshotDir == "" {
+        fail("snapshotDir is empty")
+    }
+    return problems
+}
+
+// CheckFields reports fields of the file at path that are not part of the
+// configuration, which Load silently ignores
+func CheckFields(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.DisallowUnknownFields()
+    return decoder.Decode(defaults())
+}
+
+// validIdentifier reports whether name is a lowercase SQL identifier that needs no quoting
+func validIdentifier(name string) bool {
+    if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789_") != "" {
+        return false
+    }
+    return name[0] < '0' || name[0] > '9'
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "strconv"
+    "time"
+
+    "pwr-stateful-vida/crossvida"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// startCrossVidaFeeds subscribes to each source VIDA from the block after the last
+// one delivered to the inbox
+func (a *App) startCrossVidaFeeds() {
+    cfg := a.config()
+    for _, source := range a.chainParams().CrossVida.Sources {
+        delivered, err := crossvida.Delivered(a.db, source.VidaID)
+        if err != nil {
+            syncLogger.Error("failed to read cross-VIDA delivery", "source", source.VidaID, "error", err)
+            continue
+        }
+        feed := crossvida.NewFeed(source.VidaID, int64(cfg.VidaID), source.Publishers, delivered)
+        a.crossVidaFeeds = append(a.crossVidaFeeds, feed)
+        a.feedSubscriptions = append(a.feedSubscriptions, a.RPC.Subscribe(int(source.VidaID), int(delivered)+1, feed.Handle, feed.Progress))
+        syncLogger.Info("subscribed to cross-VIDA messages", "source", source.VidaID, "fromBlock", delivered+1)
+    }
+}
+
+// deliverCrossVidaMessages moves the messages the sources published before block
+// into the inbox
+func (a *App) deliverCrossVidaMessages(block int64) error {
+    timeout, _ := time.ParseDuration(a.config().CrossVida.WaitTimeout)
+    for _, feed := range a.crossVidaFeeds {
+        count, err := crossvida.Deliver(a.db, feed, block, timeout)
+        if err != nil {
+            return err
+        }
+        if count > 0 {
+            syncLogger.Info("cross-VIDA messages delivered", "source", feed.Source, "count", count, "block", block)
+        }
+    }
+    return nil
+}
+
+// crossVidaPayload is the payload of a crossVidaMessage
+type crossVidaPayload struct {
+    envelope
+    TargetVida number `json:"targetVida"`
+    // Payload is the message, any JSON value but null
+    Payload json.RawMessage `json:"payload"`
+
+    target  int64
+    message []byte
+}
+
+func (p *crossVidaPayload) validate(a *App) error {
+    target := parsePositiveAmount(p.TargetVida)
+    if target == nil || !target.IsInt64() {
+        return invalidField("targetVida", "must be a VIDA ID")
+    }
+    p.target = target.Int64()
+    message, ok := reencode(p.Payload)
+    if !ok {
+        return invalidField("payload", "required")
+    }
+    p.message = message
+    return nil
+}
+
+// handleCrossVidaMessage publishes a message of the sender for another VIDA in the
+// outbox. It returns the reason the message was rejected, or an empty string on
+// success.
+func (a *App) handleCrossVidaMessage(ctx context.Context, p *crossVidaPayload, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+
+    sequence, err := crossvida.Publish(a.db, int64(a.config().VidaID), p.target, sender, p.message, transaction.Hash, int64(transaction.BlockNumber))
+    switch {
+    case errors.Is(err, crossvida.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid cross-VIDA message", "payload", p, "error", err)
+        return failureInvalidPayload
+    case err != nil:
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "crossVidaMessage",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": transaction.Sender, "target": strconv.FormatInt(p.target, 10)},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "cross-VIDA message published", "target", p.target, "sequence", sequence, "sender", transaction.Sender)
+    return ""
+}
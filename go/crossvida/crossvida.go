@@ -0,0 +1,265 @@
+// Package crossvida passes messages between VIDAs. A crossVidaMessage action sent to
+// this VIDA is published in its outbox for the target VIDA to consume, and the
+// messages other VIDAs publish for this one are delivered to its inbox. Both are
+// part of the Merkle state.
+//
+// A source VIDA is followed by a subscription of its own, which progresses
+// independently of the one of this VIDA, so its messages are buffered in a Feed and
+// only delivered before the first transaction of this VIDA in a later block, once
+// the feed has checked every block before it. Every node then delivers the same
+// messages at the same point of the transaction stream. Messages are authenticated
+// by the PWR signature of their sender, and a source can be limited to a set of
+// publishing addresses.
+package crossvida
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/dbservice"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Action is the action of a message payload
+const Action = "crossvidamessage"
+
+// Errors of rejected messages and deliveries
+var (
+    ErrInvalid = errors.New("invalid cross-VIDA message")
+    ErrTimeout = errors.New("source VIDA has not reached the block")
+)
+
+// Message is a message between two VIDAs. Sequence numbers the messages of an
+// outbox per target, and of an inbox per source, from 1.
+type Message struct {
+    Sequence uint64          `json:"sequence"`
+    Source   int64           `json:"source"`
+    Target   int64           `json:"target"`
+    Sender   string          `json:"sender"`
+    Hash     string          `json:"hash"`
+    Block    int64           `json:"block"`
+    Payload  json.RawMessage `json:"payload"`
+}
+
+// envelope is the JSON of a crossVidaMessage action
+type envelope struct {
+    Action     string          `json:"action"`
+    TargetVida json.Number     `json:"targetVida"`
+    Payload    json.RawMessage `json:"payload"`
+}
+
+// key returns the tree key of a record of a box
+func key(parts ...string) []byte {
+    return []byte(dbservice.CrossVidaPrefix + strings.Join(parts, "/"))
+}
+
+// sequenceKey returns the tree key of a message, zero padded so keys sort in order
+func sequenceKey(box string, vida int64, sequence uint64) []byte {
+    return key(box, strconv.FormatInt(vida, 10), fmt.Sprintf("%020d", sequence))
+}
+
+// readUint reads a counter stored under key
+func readUint(db *dbservice.DatabaseService, key []byte) (uint64, error) {
+    data, err := db.GetData(key)
+    if err != nil || len(data) == 0 {
+        return 0, err
+    }
+    return strconv.ParseUint(string(data), 10, 64)
+}
+
+// appendMessage stores a message as the next of a box, returning its sequence
+func appendMessage(db *dbservice.DatabaseService, box string, vida int64, message Message) (uint64, error) {
+    counter := key(box+"Sequence", strconv.FormatInt(vida, 10))
+    sequence, err := readUint(db, counter)
+    if err != nil {
+        return 0, err
+    }
+    sequence++
+    message.Sequence = sequence
+    if err := db.SaveJSON(sequenceKey(box, vida, sequence), message); err != nil {
+        return 0, err
+    }
+    return sequence, db.SetData(counter, []byte(strconv.FormatUint(sequence, 10)))
+}
+
+// Publish puts a message of sender for the target VIDA in the outbox of this one
+func Publish(db *dbservice.DatabaseService, source, target int64, sender []byte, payload json.RawMessage, hash string, block int64) (uint64, error) {
+    if target <= 0 || target == source {
+        return 0, fmt.Errorf("%w: the target must be another VIDA ID", ErrInvalid)
+    }
+    if len(payload) == 0 {
+        return 0, fmt.Errorf("%w: the message has no payload", ErrInvalid)
+    }
+    return appendMessage(db, "outbox", target, Message{
+        Source:  source,
+        Target:  target,
+        Sender:  hex.EncodeToString(sender),
+        Hash:    strings.TrimPrefix(strings.ToLower(hash), "0x"),
+        Block:   block,
+        Payload: payload,
+    })
+}
+
+// List returns up to limit messages of the outbox for target, or of the inbox from
+// source, after the given sequence
+func List(db *dbservice.DatabaseService, box string, vida int64, after uint64, limit int) ([]Message, error) {
+    last, err := readUint(db, key(box+"Sequence", strconv.FormatInt(vida, 10)))
+    if err != nil {
+        return nil, err
+    }
+    messages := []Message{}
+    for sequence := after + 1; sequence <= last && len(messages) < limit; sequence++ {
+        data, err := db.GetData(sequenceKey(box, vida, sequence))
+        if err != nil {
+            return nil, err
+        }
+        var message Message
+        if err := json.Unmarshal(data, &message); err != nil {
+            return nil, err
+        }
+        messages = append(messages, message)
+    }
+    return messages, nil
+}
+
+// Delivered returns the last block of a source VIDA whose messages are in the inbox
+func Delivered(db *dbservice.DatabaseService, source int64) (int64, error) {
+    block, err := readUint(db, key("delivered", strconv.FormatInt(source, 10)))
+    return int64(block), err
+}
+
+// Feed buffers the messages a source VIDA publishes for this one until they are
+// delivered
+type Feed struct {
+    Source     int64
+    target     int64
+    publishers map[string]bool
+
+    mutex   sync.Mutex
+    checked int64
+    pending []Message
+    changed chan struct{}
+}
+
+// NewFeed returns a feed of the messages from source to target, sent by one of the
+// publishers or by anyone when there are none, whose blocks up to checked are known
+func NewFeed(source, target int64, publishers []string, checked int64) *Feed {
+    feed := &Feed{Source: source, target: target, checked: checked, changed: make(chan struct{})}
+    if len(publishers) > 0 {
+        feed.publishers = make(map[string]bool, len(publishers))
+        for _, publisher := range publishers {
+            feed.publishers[strings.TrimPrefix(strings.ToLower(publisher), "0x")] = true
+        }
+    }
+    return feed
+}
+
+// Handle buffers a transaction of the source VIDA when it is a message for the target
+func (f *Feed) Handle(transaction rpc.VidaDataTransaction) {
+    data, err := hex.DecodeString(strings.TrimPrefix(transaction.Data, "0x"))
+    if err != nil {
+        return
+    }
+    var message envelope
+    if json.Unmarshal(data, &message) != nil || strings.ToLower(message.Action) != Action || len(message.Payload) == 0 {
+        return
+    }
+    if target, err := message.TargetVida.Int64(); err != nil || target != f.target {
+        return
+    }
+    sender := strings.TrimPrefix(strings.ToLower(transaction.Sender), "0x")
+    if f.publishers != nil && !f.publishers[sender] {
+        return
+    }
+
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if int64(transaction.BlockNumber) <= f.checked {
+        return
+    }
+    f.pending = append(f.pending, Message{
+        Source:  f.Source,
+        Target:  f.target,
+        Sender:  sender,
+        Hash:    strings.TrimPrefix(strings.ToLower(transaction.Hash), "0x"),
+        Block:   int64(transaction.BlockNumber),
+        Payload: message.Payload,
+    })
+}
+
+// Progress records that every block of the source up to block was handled
+func (f *Feed) Progress(block int) error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if int64(block) > f.checked {
+        f.checked = int64(block)
+        close(f.changed)
+        f.changed = make(chan struct{})
+    }
+    return nil
+}
+
+// take waits up to timeout for the source to be checked through a block, then
+// returns the buffered messages after delivered up to it. Messages are kept until
+// a later delivery passes them, so a node reverting to a checkpoint finds them again.
+func (f *Feed) take(delivered, through int64, timeout time.Duration) ([]Message, error) {
+    deadline := time.After(timeout)
+    for {
+        f.mutex.Lock()
+        if f.checked >= through {
+            var taken, kept []Message
+            for _, message := range f.pending {
+                if message.Block <= delivered {
+                    continue
+                }
+                kept = append(kept, message)
+                if message.Block <= through {
+                    taken = append(taken, message)
+                }
+            }
+            f.pending = kept
+            f.mutex.Unlock()
+            return taken, nil
+        }
+        changed := f.changed
+        f.mutex.Unlock()
+
+        select {
+        case <-changed:
+        case <-deadline:
+            return nil, ErrTimeout
+        }
+    }
+}
+
+// Deliver moves the messages of a source published before block into the inbox,
+// waiting up to timeout for its feed to get there. It returns the number delivered.
+func Deliver(db *dbservice.DatabaseService, feed *Feed, block int64, timeout time.Duration) (int, error) {
+    delivered, err := Delivered(db, feed.Source)
+    if err != nil {
+        return 0, err
+    }
+    through := block - 1
+    if through <= delivered {
+        return 0, nil
+    }
+    messages, err := feed.take(delivered, through, timeout)
+    if err != nil {
+        return 0, err
+    }
+    count := 0
+    for _, message := range messages {
+        if _, err := appendMessage(db, "inbox", feed.Source, message); err != nil {
+            return count, err
+        }
+        count++
+    }
+    return count, db.SetData(key("delivered", strconv.FormatInt(feed.Source, 10)), []byte(strconv.FormatInt(through, 10)))
+}
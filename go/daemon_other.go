@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// detachedProcess returns no attributes where sessions are not supported
+func detachedProcess() *syscall.SysProcAttr {
+    return nil
+}
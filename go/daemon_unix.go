@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// detachedProcess starts the daemon in its own session so it outlives the terminal
+func detachedProcess() *syscall.SysProcAttr {
+    return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,149 @@
+package dbfile
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+    "go.etcd.io/bbolt"
+)
+
+// ErrInUse is returned when the database file is locked by a running node
+var ErrInUse = errors.New("database file is in use by another process")
+
+// File gives direct access to a Merkle tree database file while no node has it open
+type File struct {
+    db   *bbolt.DB
+    path string
+}
+
+// Open opens the database file at path. Read-only files can be opened by several
+// processes at once; a writable open requires exclusive access.
+func Open(path string, readOnly bool) (*File, error) {
+    db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+    if errors.Is(err, bbolt.ErrTimeout) {
+        return nil, ErrInUse
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to open %s: %v", path, err)
+    }
+    return &File{db: db, path: path}, nil
+}
+
+// Close closes the file
+func (f *File) Close() error {
+    return f.db.Close()
+}
+
+// Path returns the path the file was opened from
+func (f *File) Path() string {
+    return f.path
+}
+
+// metadata returns the value stored under key in the metadata bucket
+func (f *File) metadata(key string) []byte {
+    var value []byte
+    f.db.View(func(tx *bbolt.Tx) error {
+        if b := tx.Bucket([]byte(merkletree.METADATA_BUCKET)); b != nil {
+            value = bytes.Clone(b.Get([]byte(key)))
+        }
+        return nil
+    })
+    return value
+}
+
+// RootHash returns the flushed root hash, or nil for an empty tree
+func (f *File) RootHash() []byte {
+    return f.metadata(merkletree.KEY_ROOT_HASH)
+}
+
+// NumLeaves returns the number of leaves recorded in the metadata. The tree does not
+// count every insertion path, so this can be lower than the number of stored keys.
+func (f *File) NumLeaves() int {
+    if v := f.metadata(merkletree.KEY_NUM_LEAVES); len(v) >= 4 {
+        return int(binary.BigEndian.Uint32(v))
+    }
+    return 0
+}
+
+// Depth returns the tree depth recorded in the metadata
+func (f *File) Depth() int {
+    if v := f.metadata(merkletree.KEY_DEPTH); len(v) >= 4 {
+        return int(binary.BigEndian.Uint32(v))
+    }
+    return 0
+}
+
+// Get returns the value stored for key, or nil if the key does not exist
+func (f *File) Get(key []byte) ([]byte, error) {
+    var value []byte
+    err := f.db.View(func(tx *bbolt.Tx) error {
+        if b := tx.Bucket([]byte(merkletree.KEY_DATA_BUCKET)); b != nil {
+            value = bytes.Clone(b.Get(key))
+        }
+        return nil
+    })
+    return value, err
+}
+
+// ForEach calls fn for every key and value in ascending key order
+func (f *File) ForEach(fn func(key, value []byte) error) error {
+    return f.db.View(func(tx *bbolt.Tx) error {
+        b := tx.Bucket([]byte(merkletree.KEY_DATA_BUCKET))
+        if b == nil {
+            return nil
+        }
+        return b.ForEach(fn)
+    })
+}
+
+// Node loads the node with the given hash, returning nil if it does not exist
+func (f *File) Node(hash []byte) (*merkletree.Node, error) {
+    var node *merkletree.Node
+    err := f.db.View(func(tx *bbolt.Tx) error {
+        b := tx.Bucket([]byte(merkletree.NODES_BUCKET))
+        if b == nil {
+            return nil
+        }
+        v := b.Get(hash)
+        if v == nil {
+            return nil
+        }
+        node = &merkletree.Node{}
+        return json.Unmarshal(v, node)
+    })
+    return node, err
+}
+
+// CopyTo writes a consistent copy of the file to path
+func (f *File) CopyTo(path string) error {
+    return f.db.View(func(tx *bbolt.Tx) error {
+        return tx.CopyFile(path, 0600)
+    })
+}
+
+// BucketStats returns the page statistics of every top-level bucket
+func (f *File) BucketStats() (map[string]bbolt.BucketStats, error) {
+    stats := make(map[string]bbolt.BucketStats)
+    err := f.db.View(func(tx *bbolt.Tx) error {
+        return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+            stats[string(name)] = b.Stats()
+            return nil
+        })
+    })
+    return stats, err
+}
+
+// PageSize returns the page size of the file
+func (f *File) PageSize() int {
+    return f.db.Info().PageSize
+}
+
+// Stats returns the bbolt statistics of the file
+func (f *File) Stats() bbolt.Stats {
+    return f.db.Stats()
+}
@@ -0,0 +1,253 @@
+package dbfile
+
+import (
+    "bytes"
+    "encoding/hex"
+    "fmt"
+
+    "pwr-stateful-vida/verifier"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+    "golang.org/x/crypto/sha3"
+)
+
+// Entry is a key and value stored in the tree
+type Entry struct {
+    Key   []byte
+    Value []byte
+}
+
+// hashPair computes the hash of an internal node, duplicating a missing child
+// the same way the Merkle tree does
+func hashPair(left, right []byte) []byte {
+    if left == nil {
+        left = right
+    }
+    if right == nil {
+        right = left
+    }
+    hasher := sha3.NewLegacyKeccak256()
+    hasher.Write(left)
+    hasher.Write(right)
+    return hasher.Sum(nil)
+}
+
+// leafIndex maps the leaf hash of every stored key to its entry
+func (f *File) leafIndex() (map[string]Entry, error) {
+    index := make(map[string]Entry)
+    err := f.ForEach(func(key, value []byte) error {
+        leaf := merkletree.CalculateLeafHash(key, value)
+        index[string(leaf)] = Entry{Key: bytes.Clone(key), Value: bytes.Clone(value)}
+        return nil
+    })
+    return index, err
+}
+
+// WalkLeaves visits the leaf hashes of the tree from left to right, which is the
+// order in which their keys were first inserted
+func (f *File) WalkLeaves(fn func(leafHash []byte) error) error {
+    root := f.RootHash()
+    if root == nil {
+        return nil
+    }
+    return f.walk(root, fn)
+}
+
+func (f *File) walk(hash []byte, fn func(leafHash []byte) error) error {
+    node, err := f.Node(hash)
+    if err != nil {
+        return err
+    }
+    if node == nil {
+        return fmt.Errorf("node %s not found", hex.EncodeToString(hash))
+    }
+
+    if node.Left == nil && node.Right == nil {
+        return fn(node.Hash)
+    }
+    if node.Left != nil {
+        if err := f.walk(node.Left, fn); err != nil {
+            return err
+        }
+    }
+    if node.Right != nil {
+        if err := f.walk(node.Right, fn); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Entries returns all entries in insertion order. Inserting them in this order
+// into an empty tree reproduces the same root hash.
+func (f *File) Entries() ([]Entry, error) {
+    index, err := f.leafIndex()
+    if err != nil {
+        return nil, err
+    }
+
+    entries := make([]Entry, 0, len(index))
+    err = f.WalkLeaves(func(leafHash []byte) error {
+        entry, ok := index[string(leafHash)]
+        if !ok {
+            return fmt.Errorf("leaf %s has no matching key", hex.EncodeToString(leafHash))
+        }
+        entries = append(entries, entry)
+        return nil
+    })
+    return entries, err
+}
+
+// Problem describes an inconsistency found by Verify
+type Problem struct {
+    Hash    []byte
+    Message string
+}
+
+func (p Problem) String() string {
+    if p.Hash == nil {
+        return p.Message
+    }
+    return fmt.Sprintf("%s: %s", hex.EncodeToString(p.Hash), p.Message)
+}
+
+// Verify recomputes every internal node hash from its children, checks that
+// every leaf belongs to a stored key and that every stored key is a leaf
+func (f *File) Verify() ([]Problem, error) {
+    index, err := f.leafIndex()
+    if err != nil {
+        return nil, err
+    }
+
+    var problems []Problem
+    seen := make(map[string]bool)
+
+    var check func(hash []byte, parent []byte)
+    check = func(hash []byte, parent []byte) {
+        node, err := f.Node(hash)
+        if err != nil || node == nil {
+            problems = append(problems, Problem{Hash: hash, Message: "node missing or unreadable"})
+            return
+        }
+        if parent != nil && !bytes.Equal(node.Parent, parent) {
+            problems = append(problems, Problem{Hash: hash, Message: "parent pointer does not match"})
+        }
+
+        if node.Left == nil && node.Right == nil {
+            if _, ok := index[string(hash)]; !ok {
+                problems = append(problems, Problem{Hash: hash, Message: "leaf has no matching key"})
+            }
+            seen[string(hash)] = true
+            return
+        }
+
+        if !bytes.Equal(hashPair(node.Left, node.Right), hash) {
+            problems = append(problems, Problem{Hash: hash, Message: "hash does not match children"})
+        }
+        if node.Left != nil {
+            check(node.Left, hash)
+        }
+        if node.Right != nil {
+            check(node.Right, hash)
+        }
+    }
+
+    if root := f.RootHash(); root != nil {
+        check(root, nil)
+    }
+
+    for leaf, entry := range index {
+        if !seen[leaf] {
+            problems = append(problems, Problem{Hash: []byte(leaf), Message: "key " + hex.EncodeToString(entry.Key) + " is not in the tree"})
+        }
+    }
+    return problems, nil
+}
+
+// Proof builds an inclusion proof for key by following parent pointers from its leaf to the root
+func (f *File) Proof(key []byte) (*verifier.Proof, error) {
+    value, err := f.Get(key)
+    if err != nil {
+        return nil, err
+    }
+    if value == nil {
+        return nil, fmt.Errorf("key %s not found", hex.EncodeToString(key))
+    }
+
+    proof := &verifier.Proof{Key: bytes.Clone(key), Value: value, RootHash: f.RootHash()}
+    hash := merkletree.CalculateLeafHash(key, value)
+    for !bytes.Equal(hash, proof.RootHash) {
+        node, err := f.Node(hash)
+        if err != nil {
+            return nil, err
+        }
+        if node == nil || node.Parent == nil {
+            return nil, fmt.Errorf("node %s is not connected to the root", hex.EncodeToString(hash))
+        }
+
+        parent, err := f.Node(node.Parent)
+        if err != nil {
+            return nil, err
+        }
+        if parent == nil {
+            return nil, fmt.Errorf("parent node %s not found", hex.EncodeToString(node.Parent))
+        }
+
+        switch {
+        case bytes.Equal(parent.Left, hash):
+            proof.Steps = append(proof.Steps, verifier.Step{Sibling: parent.Right})
+        case bytes.Equal(parent.Right, hash):
+            proof.Steps = append(proof.Steps, verifier.Step{Sibling: parent.Left, Left: parent.Left != nil})
+        default:
+            return nil, fmt.Errorf("node %s is not a child of its parent", hex.EncodeToString(hash))
+        }
+        hash = parent.Hash
+    }
+    return proof, nil
+}
+
+// Salvage collects as much of the tree as can still be trusted. Ordered holds the
+// entries reachable from the root in insertion order, orphans holds stored keys
+// that are not reachable, and dropped holds leaf hashes that match no stored key.
+func (f *File) Salvage() (ordered []Entry, orphans []Entry, dropped [][]byte, err error) {
+    index, err := f.leafIndex()
+    if err != nil {
+        return nil, nil, nil, err
+    }
+
+    reached := make(map[string]bool)
+    var visit func(hash []byte)
+    visit = func(hash []byte) {
+        node, err := f.Node(hash)
+        if err != nil || node == nil {
+            return
+        }
+        if node.Left == nil && node.Right == nil {
+            if entry, ok := index[string(hash)]; ok && !reached[string(hash)] {
+                reached[string(hash)] = true
+                ordered = append(ordered, entry)
+            } else if !ok {
+                dropped = append(dropped, bytes.Clone(hash))
+            }
+            return
+        }
+        if node.Left != nil {
+            visit(node.Left)
+        }
+        if node.Right != nil {
+            visit(node.Right)
+        }
+    }
+    if root := f.RootHash(); root != nil {
+        visit(root)
+    }
+
+    // Unreachable keys keep their relative key order
+    err = f.ForEach(func(key, value []byte) error {
+        if !reached[string(merkletree.CalculateLeafHash(key, value))] {
+            orphans = append(orphans, Entry{Key: bytes.Clone(key), Value: bytes.Clone(value)})
+        }
+        return nil
+    })
+    return ordered, orphans, dropped, err
+}
@@ -0,0 +1,236 @@
+package dbservice
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "math/big"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+    "go.etcd.io/bbolt"
+)
+
+// AddressLength is the length in bytes of an account address key
+const AddressLength = 20
+
+// ModuleAddress returns the account that holds the tokens of a state module, such as
+// staked tokens. It is derived from the module name, so no key controls it and it
+// can only be debited by the module itself.
+func ModuleAddress(name string) []byte {
+    sum := sha256.Sum256([]byte("module/" + name))
+    return sum[:AddressLength]
+}
+
+// accountsBucket is the bucket of the account index holding the addresses
+var accountsBucket = []byte("accounts")
+
+// accountSet is the account index of a service and the addresses given a balance
+// since the last flush
+type accountSet struct {
+    mutex   sync.RWMutex
+    index   *bbolt.DB
+    pending map[string]struct{}
+}
+
+// openAccountIndex opens the account index stored next to the Merkle tree file.
+// The Merkle tree cannot enumerate its keys, so every address that receives a
+// balance is recorded here. A fresh index is backfilled from the tree files
+// before the tree itself is opened.
+func (s *DatabaseService) openAccountIndex() {
+    indexPath := s.AccountIndexPath()
+    os.MkdirAll(filepath.Dir(indexPath), 0755)
+
+    db, err := bbolt.Open(indexPath, 0600, &bbolt.Options{Timeout: time.Second})
+    if err != nil {
+        return
+    }
+
+    db.Update(func(tx *bbolt.Tx) error {
+        bucket, err := tx.CreateBucket(accountsBucket)
+        if err != nil {
+            // Bucket already exists, nothing to backfill
+            return nil
+        }
+        for _, treePath := range s.TreePaths() {
+            if err := backfillAccounts(treePath, bucket); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+
+    s.accounts.index = db
+}
+
+// backfillAccounts copies every address key found in the tree file into the index
+func backfillAccounts(treePath string, bucket *bbolt.Bucket) error {
+    if _, err := os.Stat(treePath); err != nil {
+        return nil
+    }
+
+    treeDB, err := bbolt.Open(treePath, 0600, &bbolt.Options{Timeout: time.Second, ReadOnly: true})
+    if err != nil {
+        return err
+    }
+    defer treeDB.Close()
+
+    return treeDB.View(func(tx *bbolt.Tx) error {
+        keyData := tx.Bucket([]byte(merkletree.KEY_DATA_BUCKET))
+        if keyData == nil {
+            return nil
+        }
+        return keyData.ForEach(func(k, v []byte) error {
+            if KeyNamespace(k) != NamespaceAccount {
+                return nil
+            }
+            return bucket.Put(k, []byte{})
+        })
+    })
+}
+
+// TreePath returns the path of the Merkle tree database file
+func (s *DatabaseService) TreePath() string {
+    return s.file(s.name + ".db")
+}
+
+// AccountIndexPath returns the path of the account index file
+func (s *DatabaseService) AccountIndexPath() string {
+    return s.file(s.name + "_accounts.db")
+}
+
+// ResetAccountIndex deletes the account index so that it is rebuilt from the
+// tree file on next start. Call it after replacing the tree file offline.
+func (s *DatabaseService) ResetAccountIndex() error {
+    err := os.Remove(s.AccountIndexPath())
+    if os.IsNotExist(err) {
+        return nil
+    }
+    return err
+}
+
+// trackAccount records an address as pending until the next flush
+func (s *DatabaseService) trackAccount(address []byte) {
+    if KeyNamespace(address) != NamespaceAccount {
+        return
+    }
+    s.accounts.mutex.Lock()
+    // Looking the address up first avoids allocating a key for a known account
+    if _, ok := s.accounts.pending[string(address)]; !ok {
+        s.accounts.pending[string(address)] = struct{}{}
+    }
+    s.accounts.mutex.Unlock()
+}
+
+// flushAccounts persists the pending addresses to the account index
+func (s *DatabaseService) flushAccounts() error {
+    s.accounts.mutex.Lock()
+    defer s.accounts.mutex.Unlock()
+
+    if s.accounts.index == nil || len(s.accounts.pending) == 0 {
+        return nil
+    }
+
+    // Put in order, as bbolt shifts the keys after each one it inserts
+    addresses := make([]string, 0, len(s.accounts.pending))
+    for address := range s.accounts.pending {
+        addresses = append(addresses, address)
+    }
+    sort.Strings(addresses)
+    err := s.accounts.index.Update(func(tx *bbolt.Tx) error {
+        bucket := tx.Bucket(accountsBucket)
+        for _, address := range addresses {
+            if err := bucket.Put([]byte(address), []byte{}); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    s.accounts.pending = make(map[string]struct{})
+    return nil
+}
+
+// revertAccounts drops the addresses recorded since the last flush
+func (s *DatabaseService) revertAccounts() {
+    s.accounts.mutex.Lock()
+    s.accounts.pending = make(map[string]struct{})
+    s.accounts.mutex.Unlock()
+}
+
+// accountAddresses returns all known addresses greater than after, in ascending order
+func (s *DatabaseService) accountAddresses(after []byte) ([][]byte, error) {
+    s.accounts.mutex.RLock()
+    defer s.accounts.mutex.RUnlock()
+
+    var addresses [][]byte
+    if s.accounts.index != nil {
+        err := s.accounts.index.View(func(tx *bbolt.Tx) error {
+            cursor := tx.Bucket(accountsBucket).Cursor()
+            k, _ := cursor.Seek(after)
+            for ; k != nil; k, _ = cursor.Next() {
+                if bytes.Compare(k, after) <= 0 {
+                    continue
+                }
+                addresses = append(addresses, bytes.Clone(k))
+            }
+            return nil
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    for address := range s.accounts.pending {
+        if bytes.Compare([]byte(address), after) > 0 {
+            addresses = append(addresses, []byte(address))
+        }
+    }
+
+    sort.Slice(addresses, func(i, j int) bool {
+        return bytes.Compare(addresses[i], addresses[j]) < 0
+    })
+
+    // Pending addresses may already be in the index
+    unique := addresses[:0]
+    for i, address := range addresses {
+        if i == 0 || !bytes.Equal(address, addresses[i-1]) {
+            unique = append(unique, address)
+        }
+    }
+    return unique, nil
+}
+
+// ForEachAccount calls fn for every account with an address greater than after,
+// in ascending address order, until fn returns false
+func (s *DatabaseService) ForEachAccount(after []byte, fn func(address []byte, balance *big.Int) bool) error {
+    return s.ForEachAccountContext(context.Background(), after, fn)
+}
+
+// ForEachAccountContext is ForEachAccount, stopping with the error of ctx once it is
+// done, which is checked before every account
+func (s *DatabaseService) ForEachAccountContext(ctx context.Context, after []byte, fn func(address []byte, balance *big.Int) bool) error {
+    s.initialize()
+    addresses, err := s.accountAddresses(after)
+    if err != nil {
+        return err
+    }
+
+    for _, address := range addresses {
+        balance, err := s.GetBalanceContext(ctx, address)
+        if err != nil {
+            return err
+        }
+        if !fn(address, balance) {
+            return nil
+        }
+    }
+    return nil
+}
@@ -0,0 +1,219 @@
+package dbservice
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "sync"
+
+    "go.etcd.io/bbolt"
+)
+
+// appliedRetention is how many blocks before the checkpoint applied transactions are
+// remembered. A restarted subscription only re-delivers blocks from the checkpoint on.
+const appliedRetention = 1000
+
+// appliedBucket is the bucket of the account index holding the applied transactions
+var appliedBucket = []byte("appliedTransactions")
+
+// appliedLog is the applied transactions of a service not yet persisted, with the
+// checkpoint of its last flush, the newest checkpoint written since and the current
+// checkpoint together with the root hash of the tree at it
+type appliedLog struct {
+    mutex          sync.Mutex
+    pending        [][]byte
+    committedBlock int64
+    checkedBlock   int64
+    checkpoint     int64
+    checkpointRoot []byte
+}
+
+// appliedKey returns the index key of a transaction: the block number followed by the hash
+func appliedKey(blockNumber int64, hash string) []byte {
+    key := make([]byte, 8, 8+len(hash))
+    binary.BigEndian.PutUint64(key, uint64(blockNumber))
+    return append(key, hash...)
+}
+
+// loadCommittedBlock reads the checkpoint of the flushed tree, which has no pending writes yet
+func (s *DatabaseService) loadCommittedBlock() {
+    data, err := s.tree.GetData(LastCheckedBlockKey)
+    if err != nil {
+        return
+    }
+    s.applied.mutex.Lock()
+    s.applied.committedBlock = DecodeBlockNumber(data)
+    s.applied.checkedBlock = s.applied.committedBlock
+    s.applied.mutex.Unlock()
+    s.loadCheckpoint()
+}
+
+// loadCheckpoint reads the checkpoint and root hash of a tree without pending writes,
+// after it was opened or reverted
+func (s *DatabaseService) loadCheckpoint() {
+    data, err := s.tree.GetData(LastCheckedBlockKey)
+    if err != nil {
+        return
+    }
+    rootHash, err := s.tree.GetRootHash()
+    if err != nil {
+        return
+    }
+    s.buffer.setBlock(DecodeBlockNumber(data))
+    s.setCheckpoint(DecodeBlockNumber(data), rootHash)
+}
+
+// setCheckpoint records the current checkpoint and the root hash of the tree at it
+func (s *DatabaseService) setCheckpoint(blockNumber int64, rootHash []byte) {
+    s.applied.mutex.Lock()
+    s.applied.checkpoint = blockNumber
+    s.applied.checkpointRoot = rootHash
+    s.applied.mutex.Unlock()
+}
+
+// RecordApplied marks a transaction of the current batch as applied. It is persisted
+// with the next flush and forgotten on revert.
+func (s *DatabaseService) RecordApplied(blockNumber int64, hash string) {
+    s.applied.mutex.Lock()
+    s.applied.pending = append(s.applied.pending, appliedKey(blockNumber, hash))
+    s.applied.mutex.Unlock()
+}
+
+// WasApplied reports whether a transaction was applied in a block that has been
+// flushed, so that a subscription re-delivering it after a restart does not apply it
+// twice. Always false without the account index.
+func (s *DatabaseService) WasApplied(blockNumber int64, hash string) (bool, error) {
+    return s.WasAppliedContext(context.Background(), blockNumber, hash)
+}
+
+// WasAppliedContext is WasApplied, unless ctx is done
+func (s *DatabaseService) WasAppliedContext(ctx context.Context, blockNumber int64, hash string) (bool, error) {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return false, err
+    }
+    s.applied.mutex.Lock()
+    committed := s.applied.committedBlock
+    s.applied.mutex.Unlock()
+    if s.accounts.index == nil || blockNumber > committed {
+        return false, nil
+    }
+
+    found := false
+    err := s.accounts.index.View(func(tx *bbolt.Tx) error {
+        if bucket := tx.Bucket(appliedBucket); bucket != nil {
+            found = bucket.Get(appliedKey(blockNumber, hash)) != nil
+        }
+        return nil
+    })
+    return found, err
+}
+
+// writeApplied persists the pending applied transactions and forgets those older than
+// the retention window. It runs before the tree is flushed; entries after the
+// committed checkpoint are ignored, so a failed tree flush does not skip them later.
+func (s *DatabaseService) writeApplied(checkpoint int64) error {
+    s.applied.mutex.Lock()
+    defer s.applied.mutex.Unlock()
+
+    if s.accounts.index == nil {
+        s.applied.pending = nil
+        return nil
+    }
+
+    err := s.accounts.index.Update(func(tx *bbolt.Tx) error {
+        bucket, err := tx.CreateBucketIfNotExists(appliedBucket)
+        if err != nil {
+            return err
+        }
+        for _, key := range s.applied.pending {
+            if err := bucket.Put(key, []byte{}); err != nil {
+                return err
+            }
+        }
+
+        var expired [][]byte
+        cursor := bucket.Cursor()
+        for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+            if int64(binary.BigEndian.Uint64(k)) >= checkpoint-appliedRetention {
+                break
+            }
+            expired = append(expired, bytes.Clone(k))
+        }
+        for _, key := range expired {
+            if err := bucket.Delete(key); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    s.applied.pending = nil
+    return nil
+}
+
+// commitApplied records the checkpoint of a successful flush
+func (s *DatabaseService) commitApplied(checkpoint int64) {
+    s.applied.mutex.Lock()
+    s.applied.committedBlock = checkpoint
+    s.applied.checkedBlock = checkpoint
+    s.applied.mutex.Unlock()
+}
+
+// revertApplied drops the transactions recorded since the last flush
+func (s *DatabaseService) revertApplied() {
+    s.applied.mutex.Lock()
+    s.applied.pending = nil
+    s.applied.checkedBlock = s.applied.committedBlock
+    s.applied.mutex.Unlock()
+}
+
+// noteChecked records a checkpoint written since the last flush
+func (s *DatabaseService) noteChecked(blockNumber int64) {
+    s.applied.mutex.Lock()
+    if blockNumber > s.applied.checkedBlock {
+        s.applied.checkedBlock = blockNumber
+    }
+    s.applied.mutex.Unlock()
+}
+
+// FlushedBlock returns the checkpoint of the last successful flush. Only validated
+// batches are kept, so it is the last validated block.
+func (s *DatabaseService) FlushedBlock() int64 {
+    s.initialize()
+    s.applied.mutex.Lock()
+    defer s.applied.mutex.Unlock()
+    return s.applied.committedBlock
+}
+
+// CheckpointRootHash returns the last checkpoint and the root hash of the tree at it.
+// Both are recorded together when the checkpoint is written, so a block number is
+// never paired with the root of an earlier checkpoint or of blocks applied after it.
+// Peers ask for it while validating the same checkpoint, so it is reported before
+// the checkpoint is flushed.
+func (s *DatabaseService) CheckpointRootHash() (int64, []byte, error) {
+    return s.CheckpointRootHashContext(context.Background())
+}
+
+// CheckpointRootHashContext is CheckpointRootHash, unless ctx is done
+func (s *DatabaseService) CheckpointRootHashContext(ctx context.Context) (int64, []byte, error) {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return 0, nil, err
+    }
+    s.applied.mutex.Lock()
+    defer s.applied.mutex.Unlock()
+    return s.applied.checkpoint, s.applied.checkpointRoot, nil
+}
+
+// UnflushedBlocks returns the range of blocks whose changes a revert discards: the
+// blocks after the flushed checkpoint up to the newest checkpoint written since.
+// The range is empty, with to before from, when nothing was checkpointed.
+func (s *DatabaseService) UnflushedBlocks() (from, to int64) {
+    s.initialize()
+    s.applied.mutex.Lock()
+    defer s.applied.mutex.Unlock()
+    return s.applied.committedBlock + 1, s.applied.checkedBlock
+}
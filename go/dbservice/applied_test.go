@@ -0,0 +1,82 @@
+package dbservice_test
+
+import (
+    "bytes"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/testkit"
+)
+
+func TestCheckpointRootHash(t *testing.T) {
+    service := dbservice.New("checkpoint")
+    service.UseTree(testkit.NewMemoryTree())
+    sender := bytes.Repeat([]byte{1}, dbservice.AddressLength)
+    receiver := bytes.Repeat([]byte{2}, dbservice.AddressLength)
+
+    if err := service.SetBalance(sender, big.NewInt(100)); err != nil {
+        t.Fatal(err)
+    }
+    if err := service.SetLastCheckedBlock(5); err != nil {
+        t.Fatal(err)
+    }
+    flushedRoot, err := service.GetRootHash()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := service.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name      string
+        step      func() error
+        wantBlock int64
+        wantRoot  func() []byte
+    }{
+        {
+            name: "block applied after the checkpoint",
+            step: func() error {
+                if _, err := service.Transfer(sender, receiver, big.NewInt(10)); err != nil {
+                    return err
+                }
+                return service.EndBlock(6)
+            },
+            wantBlock: 5,
+            wantRoot:  func() []byte { return flushedRoot },
+        },
+        {
+            name:      "checkpoint written but not flushed",
+            step:      func() error { return service.SetLastCheckedBlock(7) },
+            wantBlock: 7,
+            wantRoot: func() []byte {
+                rootHash, _ := service.GetRootHash()
+                return rootHash
+            },
+        },
+        {
+            name:      "checkpoint reverted",
+            step:      service.RevertUnsavedChanges,
+            wantBlock: 5,
+            wantRoot:  func() []byte { return flushedRoot },
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if err := test.step(); err != nil {
+                t.Fatal(err)
+            }
+            block, rootHash, err := service.CheckpointRootHash()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if block != test.wantBlock {
+                t.Errorf("checkpoint = %d, want %d", block, test.wantBlock)
+            }
+            if want := test.wantRoot(); !bytes.Equal(rootHash, want) {
+                t.Errorf("root hash = %x, want %x", rootHash, want)
+            }
+        })
+    }
+}
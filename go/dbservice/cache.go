@@ -0,0 +1,99 @@
+package dbservice
+
+import (
+    "container/list"
+    "math/big"
+    "sync"
+
+    "pwr-stateful-vida/metrics"
+)
+
+// balanceCache keeps recently read balances in memory, evicting the least recently
+// used entry once it holds capacity entries. A capacity of 0 disables it.
+type balanceCache struct {
+    mutex    sync.Mutex
+    capacity int
+    entries  map[string]*list.Element
+    order    *list.List
+}
+
+// cachedBalance is an element of balanceCache.order
+type cachedBalance struct {
+    address string
+    balance *big.Int
+}
+
+// newBalanceCache returns a disabled balance cache
+func newBalanceCache() *balanceCache {
+    return &balanceCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// SetBalanceCacheSize sets the number of balances kept in memory between reads.
+// Zero disables the cache.
+func (s *DatabaseService) SetBalanceCacheSize(size int) {
+    s.balances.mutex.Lock()
+    defer s.balances.mutex.Unlock()
+    s.balances.capacity = size
+    s.balances.evict()
+}
+
+// load sets into the cached balance of address, reusing the memory of into
+func (c *balanceCache) load(address []byte, into *big.Int) bool {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if c.capacity <= 0 {
+        return false
+    }
+    element, ok := c.entries[string(address)]
+    if !ok {
+        metrics.BalanceCacheLookups.Inc("miss")
+        return false
+    }
+    metrics.BalanceCacheLookups.Inc("hit")
+    c.order.MoveToFront(element)
+    into.Set(element.Value.(*cachedBalance).balance)
+    return true
+}
+
+// put caches a copy of the balance of address
+func (c *balanceCache) put(address []byte, balance *big.Int) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if c.capacity <= 0 {
+        return
+    }
+    if element, ok := c.entries[string(address)]; ok {
+        element.Value.(*cachedBalance).balance.Set(balance)
+        c.order.MoveToFront(element)
+        return
+    }
+    c.entries[string(address)] = c.order.PushFront(&cachedBalance{address: string(address), balance: new(big.Int).Set(balance)})
+    c.evict()
+}
+
+// remove drops the cached balance of address
+func (c *balanceCache) remove(key []byte) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if element, ok := c.entries[string(key)]; ok {
+        c.order.Remove(element)
+        delete(c.entries, string(key))
+    }
+}
+
+// clear drops every cached balance
+func (c *balanceCache) clear() {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    c.entries = make(map[string]*list.Element)
+    c.order.Init()
+}
+
+// evict removes the least recently used entries beyond the capacity
+func (c *balanceCache) evict() {
+    for c.order.Len() > c.capacity && c.order.Len() > 0 {
+        oldest := c.order.Back()
+        c.order.Remove(oldest)
+        delete(c.entries, oldest.Value.(*cachedBalance).address)
+    }
+}
@@ -0,0 +1,96 @@
+package dbservice
+
+import (
+    "bytes"
+    "sync"
+)
+
+// Change is a key written since the last flush with its previous and new value
+type Change struct {
+    Key []byte
+    Old []byte
+    New []byte
+}
+
+// changeLog records the writes to a service since the last flush or revert
+type changeLog struct {
+    mutex    sync.Mutex
+    tracking bool
+    pending  map[string]*Change
+    writes   int
+    // order holds the pending changes in the order their keys were first written
+    order []*Change
+}
+
+// TrackChanges enables recording the previous value of every write, which costs
+// an extra read per write
+func (s *DatabaseService) TrackChanges(enabled bool) {
+    s.changes.mutex.Lock()
+    s.changes.tracking = enabled
+    s.changes.pending = make(map[string]*Change)
+    s.changes.order = nil
+    s.changes.mutex.Unlock()
+}
+
+// writeData stores value under key, recording the change when tracking is enabled.
+// Raw writes can replace a balance, so the cached one is dropped.
+func (s *DatabaseService) writeData(key, value []byte) error {
+    s.balances.remove(key)
+    return s.writeTree(key, value)
+}
+
+// writeTree stores value under key, recording the change when tracking is enabled
+func (s *DatabaseService) writeTree(key, value []byte) error {
+    s.changes.mutex.Lock()
+    defer s.changes.mutex.Unlock()
+
+    s.changes.writes++
+    if s.changes.tracking {
+        change, ok := s.changes.pending[string(key)]
+        if !ok {
+            old, err := s.tree.GetData(key)
+            if err != nil {
+                return err
+            }
+            change = &Change{Key: bytes.Clone(key), Old: old}
+            s.changes.pending[string(key)] = change
+            s.changes.order = append(s.changes.order, change)
+        }
+        change.New = bytes.Clone(value)
+    }
+    return s.tree.AddOrUpdateData(key, value)
+}
+
+// PendingWrites returns the number of writes since the last flush or revert, which
+// the tree holds in memory until it is flushed
+func (s *DatabaseService) PendingWrites() int {
+    s.changes.mutex.Lock()
+    defer s.changes.mutex.Unlock()
+    return s.changes.writes
+}
+
+// PendingChanges returns the changes since the last flush in the order their keys
+// were first written, which is the order new keys were added to the tree, leaving
+// out existing keys that were written back to their previous value. A new key is
+// kept even with an empty value, since it is still a leaf of the tree.
+func (s *DatabaseService) PendingChanges() []Change {
+    s.changes.mutex.Lock()
+    defer s.changes.mutex.Unlock()
+
+    changes := make([]Change, 0, len(s.changes.order))
+    for _, change := range s.changes.order {
+        if change.Old == nil || !bytes.Equal(change.Old, change.New) {
+            changes = append(changes, *change)
+        }
+    }
+    return changes
+}
+
+// clearChanges forgets the recorded changes after a flush or revert
+func (s *DatabaseService) clearChanges() {
+    s.changes.mutex.Lock()
+    s.changes.pending = make(map[string]*Change)
+    s.changes.order = nil
+    s.changes.writes = 0
+    s.changes.mutex.Unlock()
+}
@@ -0,0 +1,290 @@
+package dbservice
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "math/big"
+    "sync"
+
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/verifier"
+)
+
+// writeBuffer holds the writes to a tree until the end of the block, or until its
+// root hash is needed or it is flushed, keeping only the last value of each key, so
+// an account updated many times in a block rewrites its leaf once. Keys reach the
+// tree in the order of their first write, which inserts new leaves in the same
+// order as writing every update and so yields the same root hash. Until then the
+// tree behind the buffer holds the state before the block, which committed reads
+// are served from.
+type writeBuffer struct {
+    Tree
+    mutex  sync.RWMutex
+    values map[string][]byte
+    order  [][]byte
+    // block is the last block whose writes reached the tree
+    block int64
+}
+
+// newWriteBuffer returns an empty write buffer in front of t
+func newWriteBuffer(t Tree) *writeBuffer {
+    return &writeBuffer{Tree: t, values: make(map[string][]byte)}
+}
+
+func (b *writeBuffer) GetData(key []byte) ([]byte, error) {
+    b.mutex.RLock()
+    defer b.mutex.RUnlock()
+    if value, ok := b.values[string(key)]; ok {
+        return value, nil
+    }
+    return b.Tree.GetData(key)
+}
+
+func (b *writeBuffer) AddOrUpdateData(key, data []byte) error {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if _, ok := b.values[string(key)]; ok {
+        metrics.CoalescedWrites.Inc()
+    } else {
+        b.order = append(b.order, bytes.Clone(key))
+    }
+    b.values[string(key)] = data
+    return nil
+}
+
+// drain writes the buffered values to the tree. The writes that failed stay
+// buffered. The caller holds the lock.
+func (b *writeBuffer) drain() error {
+    for i, key := range b.order {
+        if err := b.Tree.AddOrUpdateData(key, b.values[string(key)]); err != nil {
+            for _, written := range b.order[:i] {
+                delete(b.values, string(written))
+            }
+            b.order = b.order[i:]
+            return err
+        }
+    }
+    b.values = make(map[string][]byte)
+    b.order = nil
+    return nil
+}
+
+// rlockContext read-locks the buffer, giving up when ctx is done first, for example
+// while the tree is copied. A lock taken after giving up is released at once.
+func (b *writeBuffer) rlockContext(ctx context.Context) error {
+    if ctx.Done() == nil {
+        b.mutex.RLock()
+        return nil
+    }
+    if b.mutex.TryRLock() {
+        return nil
+    }
+    locked := make(chan struct{})
+    abandoned := make(chan struct{})
+    go func() {
+        b.mutex.RLock()
+        select {
+        case locked <- struct{}{}:
+        case <-abandoned:
+            b.mutex.RUnlock()
+        }
+    }()
+    select {
+    case <-locked:
+        return nil
+    case <-ctx.Done():
+        close(abandoned)
+        return ctx.Err()
+    }
+}
+
+// getData is GetData, waiting for the buffer no longer than ctx allows
+func (b *writeBuffer) getData(ctx context.Context, key []byte) ([]byte, error) {
+    if err := b.rlockContext(ctx); err != nil {
+        return nil, err
+    }
+    defer b.mutex.RUnlock()
+    if value, ok := b.values[string(key)]; ok {
+        return value, nil
+    }
+    return b.Tree.GetData(key)
+}
+
+// committed runs fn on the tree behind the buffer while no buffered write reaches it
+func (b *writeBuffer) committed(ctx context.Context, fn func(t Tree) error) error {
+    if err := b.rlockContext(ctx); err != nil {
+        return err
+    }
+    defer b.mutex.RUnlock()
+    return fn(b.Tree)
+}
+
+// reopen closes the tree behind the buffer, runs fn while nothing reaches it and
+// replaces it with the tree open returns. The buffer must be empty.
+func (b *writeBuffer) reopen(fn func() error, open func() (Tree, error)) error {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if len(b.order) > 0 {
+        return errors.New("the tree has buffered writes")
+    }
+    if err := b.Tree.Close(); err != nil {
+        return err
+    }
+    err := fn()
+    t, openErr := open()
+    if openErr != nil {
+        return fmt.Errorf("failed to reopen the tree: %v", openErr)
+    }
+    b.Tree = timedTree{t}
+    return err
+}
+
+// endBlock passes the buffered writes of block to the tree
+func (b *writeBuffer) endBlock(block int64) error {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if err := b.drain(); err != nil {
+        return err
+    }
+    b.block = block
+    return nil
+}
+
+// setBlock records the block the state of the tree is at, after a checkpoint drained
+// the buffer or the tree was opened or reverted
+func (b *writeBuffer) setBlock(block int64) {
+    b.mutex.Lock()
+    b.block = block
+    b.mutex.Unlock()
+}
+
+func (b *writeBuffer) GetRootHash() ([]byte, error) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if err := b.drain(); err != nil {
+        return nil, err
+    }
+    return b.Tree.GetRootHash()
+}
+
+func (b *writeBuffer) FlushToDisk() error {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if err := b.drain(); err != nil {
+        return err
+    }
+    return b.Tree.FlushToDisk()
+}
+
+func (b *writeBuffer) RevertUnsavedChanges() error {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    b.values = make(map[string][]byte)
+    b.order = nil
+    return b.Tree.RevertUnsavedChanges()
+}
+
+// EndBlock passes the buffered writes of a completed block to the tree, making them
+// visible to committed reads
+func (s *DatabaseService) EndBlock(block int64) error {
+    return s.EndBlockContext(context.Background(), block)
+}
+
+// EndBlockContext is EndBlock, unless ctx is done
+func (s *DatabaseService) EndBlockContext(ctx context.Context, block int64) error {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    return s.buffer.endBlock(block)
+}
+
+// CommittedBalance returns the balance of address after the last completed block.
+// API reads use it so that they never observe a block being applied.
+func (s *DatabaseService) CommittedBalance(address []byte) (*big.Int, error) {
+    return s.CommittedBalanceContext(context.Background(), address)
+}
+
+// CommittedBalanceContext is CommittedBalance, waiting for the tree no longer than
+// ctx allows
+func (s *DatabaseService) CommittedBalanceContext(ctx context.Context, address []byte) (*big.Int, error) {
+    s.initialize()
+    var data []byte
+    err := s.buffer.committed(ctx, func(t Tree) error {
+        var err error
+        data, err = t.GetData(address)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    return new(big.Int).SetBytes(data), nil
+}
+
+// CommittedBalanceBlockContext is CommittedBalanceContext, also returning the last
+// completed block, which the balance is the one after
+func (s *DatabaseService) CommittedBalanceBlockContext(ctx context.Context, address []byte) (*big.Int, int64, error) {
+    s.initialize()
+    var data []byte
+    var block int64
+    err := s.buffer.committed(ctx, func(t Tree) error {
+        var err error
+        data, err = t.GetData(address)
+        block = s.buffer.block
+        return err
+    })
+    if err != nil {
+        return nil, 0, err
+    }
+    return new(big.Int).SetBytes(data), block, nil
+}
+
+// ErrNoProofs is returned for proofs of a state whose tree does not keep its nodes,
+// such as a sharded state
+var ErrNoProofs = errors.New("the tree of the state does not serve proofs")
+
+// CommittedProof returns a proof of the value of key after the last completed block
+// against the root hash of that block, nil when the key is not stored
+func (s *DatabaseService) CommittedProof(key []byte) (*verifier.Proof, error) {
+    return s.CommittedProofContext(context.Background(), key)
+}
+
+// CommittedProofContext is CommittedProof, waiting for the tree no longer than ctx
+// allows
+func (s *DatabaseService) CommittedProofContext(ctx context.Context, key []byte) (*verifier.Proof, error) {
+    s.initialize()
+    var proof *verifier.Proof
+    err := s.buffer.committed(ctx, func(t Tree) error {
+        if timed, ok := t.(timedTree); ok {
+            t = timed.Tree
+        }
+        tree, ok := t.(*FileTree)
+        if !ok {
+            return ErrNoProofs
+        }
+        var err error
+        proof, err = tree.Proof(key)
+        return err
+    })
+    return proof, err
+}
+
+// CommittedRootHash returns the root hash after the last completed block
+func (s *DatabaseService) CommittedRootHash() ([]byte, error) {
+    return s.CommittedRootHashContext(context.Background())
+}
+
+// CommittedRootHashContext is CommittedRootHash, waiting for the tree no longer than
+// ctx allows
+func (s *DatabaseService) CommittedRootHashContext(ctx context.Context) ([]byte, error) {
+    s.initialize()
+    var rootHash []byte
+    err := s.buffer.committed(ctx, func(t Tree) error {
+        var err error
+        rootHash, err = t.GetRootHash()
+        return err
+    })
+    return rootHash, err
+}
@@ -0,0 +1,79 @@
+package dbservice_test
+
+import (
+    "bytes"
+    "context"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/testkit"
+)
+
+func TestCommittedBalanceBlock(t *testing.T) {
+    service := dbservice.New("committed")
+    service.UseTree(testkit.NewMemoryTree())
+    account := bytes.Repeat([]byte{3}, dbservice.AddressLength)
+    setBalance := func(balance int64) error {
+        return service.SetBalance(account, big.NewInt(balance))
+    }
+
+    if err := setBalance(10); err != nil {
+        t.Fatal(err)
+    }
+    if err := service.SetLastCheckedBlock(20); err != nil {
+        t.Fatal(err)
+    }
+    if err := service.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    // The steps run in order, each on the state the previous one left
+    tests := []struct {
+        name        string
+        step        func() error
+        wantBalance int64
+        wantBlock   int64
+    }{
+        {name: "flushed checkpoint", step: func() error { return nil }, wantBalance: 10, wantBlock: 20},
+        {name: "block in progress", step: func() error { return setBalance(11) }, wantBalance: 10, wantBlock: 20},
+        {name: "block completed after the checkpoint", step: func() error { return service.EndBlock(21) }, wantBalance: 11, wantBlock: 21},
+        {
+            name: "next block completed",
+            step: func() error {
+                if err := setBalance(12); err != nil {
+                    return err
+                }
+                return service.EndBlock(23)
+            },
+            wantBalance: 12,
+            wantBlock:   23,
+        },
+        {
+            name: "checkpoint of a block in progress",
+            step: func() error {
+                if err := setBalance(13); err != nil {
+                    return err
+                }
+                return service.SetLastCheckedBlock(24)
+            },
+            wantBalance: 13,
+            wantBlock:   24,
+        },
+        {name: "checkpoint reverted", step: service.RevertUnsavedChanges, wantBalance: 10, wantBlock: 20},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if err := test.step(); err != nil {
+                t.Fatal(err)
+            }
+            balance, block, err := service.CommittedBalanceBlockContext(context.Background(), account)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if balance.Int64() != test.wantBalance || block != test.wantBlock {
+                t.Errorf("committed balance %s at block %d, want %d at block %d", balance, block, test.wantBalance, test.wantBlock)
+            }
+        })
+    }
+}
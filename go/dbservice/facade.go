@@ -0,0 +1,330 @@
+package dbservice
+
+import (
+    "context"
+    "math/big"
+
+    "pwr-stateful-vida/verifier"
+)
+
+// The package functions act on the default service, which keeps the state of the
+// node in the tree files named database. A process holding several states creates
+// a service for each of the others with New.
+
+// defaultService is the service of the package functions
+var defaultService = New("database")
+
+// Default returns the service the package functions act on
+func Default() *DatabaseService {
+    return defaultService
+}
+
+// SetCanonical calls SetCanonical on the default service
+func SetCanonical(enabled bool) {
+    defaultService.SetCanonical(enabled)
+}
+
+// SetShards calls SetShards on the default service
+func SetShards(n int) {
+    defaultService.SetShards(n)
+}
+
+// SetBalanceCacheSize calls SetBalanceCacheSize on the default service
+func SetBalanceCacheSize(size int) {
+    defaultService.SetBalanceCacheSize(size)
+}
+
+// TrackChanges calls TrackChanges on the default service
+func TrackChanges(enabled bool) {
+    defaultService.TrackChanges(enabled)
+}
+
+// ObserveBalances calls ObserveBalances on the default service
+func ObserveBalances(fn func(address []byte, old, new *big.Int)) {
+    defaultService.ObserveBalances(fn)
+}
+
+// UseTree calls UseTree on the default service
+func UseTree(t Tree) {
+    defaultService.UseTree(t)
+}
+
+// BlockRootHashKey calls BlockRootHashKey on the default service
+func BlockRootHashKey(blockNumber int64) []byte {
+    return defaultService.BlockRootHashKey(blockNumber)
+}
+
+// TreePath calls TreePath on the default service
+func TreePath() string {
+    return defaultService.TreePath()
+}
+
+// TreePaths calls TreePaths on the default service
+func TreePaths() []string {
+    return defaultService.TreePaths()
+}
+
+// AccountIndexPath calls AccountIndexPath on the default service
+func AccountIndexPath() string {
+    return defaultService.AccountIndexPath()
+}
+
+// ResetAccountIndex calls ResetAccountIndex on the default service
+func ResetAccountIndex() error {
+    return defaultService.ResetAccountIndex()
+}
+
+// GetRootHash calls GetRootHash on the default service
+func GetRootHash() ([]byte, error) {
+    return defaultService.GetRootHash()
+}
+
+// GetRootHashContext calls GetRootHashContext on the default service
+func GetRootHashContext(ctx context.Context) ([]byte, error) {
+    return defaultService.GetRootHashContext(ctx)
+}
+
+// CommittedRootHash calls CommittedRootHash on the default service
+func CommittedRootHash() ([]byte, error) {
+    return defaultService.CommittedRootHash()
+}
+
+// CommittedRootHashContext calls CommittedRootHashContext on the default service
+func CommittedRootHashContext(ctx context.Context) ([]byte, error) {
+    return defaultService.CommittedRootHashContext(ctx)
+}
+
+// CheckpointRootHash calls CheckpointRootHash on the default service
+func CheckpointRootHash() (int64, []byte, error) {
+    return defaultService.CheckpointRootHash()
+}
+
+// CheckpointRootHashContext calls CheckpointRootHashContext on the default service
+func CheckpointRootHashContext(ctx context.Context) (int64, []byte, error) {
+    return defaultService.CheckpointRootHashContext(ctx)
+}
+
+// Flush calls Flush on the default service
+func Flush() error {
+    return defaultService.Flush()
+}
+
+// FlushContext calls FlushContext on the default service
+func FlushContext(ctx context.Context) error {
+    return defaultService.FlushContext(ctx)
+}
+
+// RevertUnsavedChanges calls RevertUnsavedChanges on the default service
+func RevertUnsavedChanges() error {
+    return defaultService.RevertUnsavedChanges()
+}
+
+// RevertUnsavedChangesContext calls RevertUnsavedChangesContext on the default service
+func RevertUnsavedChangesContext(ctx context.Context) error {
+    return defaultService.RevertUnsavedChangesContext(ctx)
+}
+
+// EndBlock calls EndBlock on the default service
+func EndBlock(block int64) error {
+    return defaultService.EndBlock(block)
+}
+
+// EndBlockContext calls EndBlockContext on the default service
+func EndBlockContext(ctx context.Context, block int64) error {
+    return defaultService.EndBlockContext(ctx, block)
+}
+
+// GetBalance calls GetBalance on the default service
+func GetBalance(address []byte) (*big.Int, error) {
+    return defaultService.GetBalance(address)
+}
+
+// GetBalanceContext calls GetBalanceContext on the default service
+func GetBalanceContext(ctx context.Context, address []byte) (*big.Int, error) {
+    return defaultService.GetBalanceContext(ctx, address)
+}
+
+// CommittedBalance calls CommittedBalance on the default service
+func CommittedBalance(address []byte) (*big.Int, error) {
+    return defaultService.CommittedBalance(address)
+}
+
+// CommittedBalanceContext calls CommittedBalanceContext on the default service
+func CommittedBalanceContext(ctx context.Context, address []byte) (*big.Int, error) {
+    return defaultService.CommittedBalanceContext(ctx, address)
+}
+
+// CommittedProof calls CommittedProof on the default service
+func CommittedProof(key []byte) (*verifier.Proof, error) {
+    return defaultService.CommittedProof(key)
+}
+
+// CommittedProofContext calls CommittedProofContext on the default service
+func CommittedProofContext(ctx context.Context, key []byte) (*verifier.Proof, error) {
+    return defaultService.CommittedProofContext(ctx, key)
+}
+
+// SetBalance calls SetBalance on the default service
+func SetBalance(address []byte, balance *big.Int) error {
+    return defaultService.SetBalance(address, balance)
+}
+
+// SetBalanceContext calls SetBalanceContext on the default service
+func SetBalanceContext(ctx context.Context, address []byte, balance *big.Int) error {
+    return defaultService.SetBalanceContext(ctx, address, balance)
+}
+
+// Transfer calls Transfer on the default service
+func Transfer(sender, receiver []byte, amount *big.Int) (bool, error) {
+    return defaultService.Transfer(sender, receiver, amount)
+}
+
+// TransferContext calls TransferContext on the default service
+func TransferContext(ctx context.Context, sender, receiver []byte, amount *big.Int) (bool, error) {
+    return defaultService.TransferContext(ctx, sender, receiver, amount)
+}
+
+// ApplyTransfers calls ApplyTransfers on the default service
+func ApplyTransfers(transfers []TransferRequest, workers int, before func(i int)) ([]bool, error) {
+    return defaultService.ApplyTransfers(transfers, workers, before)
+}
+
+// ApplyTransfersContext calls ApplyTransfersContext on the default service
+func ApplyTransfersContext(ctx context.Context, transfers []TransferRequest, workers int, before func(i int)) ([]bool, error) {
+    return defaultService.ApplyTransfersContext(ctx, transfers, workers, before)
+}
+
+// GetData calls GetData on the default service
+func GetData(key []byte) ([]byte, error) {
+    return defaultService.GetData(key)
+}
+
+// GetDataContext calls GetDataContext on the default service
+func GetDataContext(ctx context.Context, key []byte) ([]byte, error) {
+    return defaultService.GetDataContext(ctx, key)
+}
+
+// SetData calls SetData on the default service
+func SetData(key, value []byte) error {
+    return defaultService.SetData(key, value)
+}
+
+// SetDataContext calls SetDataContext on the default service
+func SetDataContext(ctx context.Context, key, value []byte) error {
+    return defaultService.SetDataContext(ctx, key, value)
+}
+
+// GetLastCheckedBlock calls GetLastCheckedBlock on the default service
+func GetLastCheckedBlock() (int64, error) {
+    return defaultService.GetLastCheckedBlock()
+}
+
+// GetLastCheckedBlockContext calls GetLastCheckedBlockContext on the default service
+func GetLastCheckedBlockContext(ctx context.Context) (int64, error) {
+    return defaultService.GetLastCheckedBlockContext(ctx)
+}
+
+// SetLastCheckedBlock calls SetLastCheckedBlock on the default service
+func SetLastCheckedBlock(blockNumber int) error {
+    return defaultService.SetLastCheckedBlock(blockNumber)
+}
+
+// SetLastCheckedBlockContext calls SetLastCheckedBlockContext on the default service
+func SetLastCheckedBlockContext(ctx context.Context, blockNumber int) error {
+    return defaultService.SetLastCheckedBlockContext(ctx, blockNumber)
+}
+
+// SetBlockRootHash calls SetBlockRootHash on the default service
+func SetBlockRootHash(blockNumber int, rootHash []byte) error {
+    return defaultService.SetBlockRootHash(blockNumber, rootHash)
+}
+
+// SetBlockRootHashContext calls SetBlockRootHashContext on the default service
+func SetBlockRootHashContext(ctx context.Context, blockNumber int, rootHash []byte) error {
+    return defaultService.SetBlockRootHashContext(ctx, blockNumber, rootHash)
+}
+
+// GetBlockRootHash calls GetBlockRootHash on the default service
+func GetBlockRootHash(blockNumber int64) ([]byte, error) {
+    return defaultService.GetBlockRootHash(blockNumber)
+}
+
+// GetBlockRootHashContext calls GetBlockRootHashContext on the default service
+func GetBlockRootHashContext(ctx context.Context, blockNumber int64) ([]byte, error) {
+    return defaultService.GetBlockRootHashContext(ctx, blockNumber)
+}
+
+// FlushedBlock calls FlushedBlock on the default service
+func FlushedBlock() int64 {
+    return defaultService.FlushedBlock()
+}
+
+// UnflushedBlocks calls UnflushedBlocks on the default service
+func UnflushedBlocks() (from, to int64) {
+    return defaultService.UnflushedBlocks()
+}
+
+// RecordApplied calls RecordApplied on the default service
+func RecordApplied(blockNumber int64, hash string) {
+    defaultService.RecordApplied(blockNumber, hash)
+}
+
+// WasApplied calls WasApplied on the default service
+func WasApplied(blockNumber int64, hash string) (bool, error) {
+    return defaultService.WasApplied(blockNumber, hash)
+}
+
+// WasAppliedContext calls WasAppliedContext on the default service
+func WasAppliedContext(ctx context.Context, blockNumber int64, hash string) (bool, error) {
+    return defaultService.WasAppliedContext(ctx, blockNumber, hash)
+}
+
+// PendingWrites calls PendingWrites on the default service
+func PendingWrites() int {
+    return defaultService.PendingWrites()
+}
+
+// PendingChanges calls PendingChanges on the default service
+func PendingChanges() []Change {
+    return defaultService.PendingChanges()
+}
+
+// ForEachAccount calls ForEachAccount on the default service
+func ForEachAccount(after []byte, fn func(address []byte, balance *big.Int) bool) error {
+    return defaultService.ForEachAccount(after, fn)
+}
+
+// ForEachAccountContext calls ForEachAccountContext on the default service
+func ForEachAccountContext(ctx context.Context, after []byte, fn func(address []byte, balance *big.Int) bool) error {
+    return defaultService.ForEachAccountContext(ctx, after, fn)
+}
+
+// TopAccounts calls TopAccounts on the default service
+func TopAccounts(limit int) ([]Account, *big.Int, error) {
+    return defaultService.TopAccounts(limit)
+}
+
+// TopAccountsContext calls TopAccountsContext on the default service
+func TopAccountsContext(ctx context.Context, limit int) ([]Account, *big.Int, error) {
+    return defaultService.TopAccountsContext(ctx, limit)
+}
+
+// TotalBalance calls TotalBalance on the default service
+func TotalBalance() (*big.Int, error) {
+    return defaultService.TotalBalance()
+}
+
+// TotalBalanceContext calls TotalBalanceContext on the default service
+func TotalBalanceContext(ctx context.Context) (*big.Int, error) {
+    return defaultService.TotalBalanceContext(ctx)
+}
+
+// CopyTree calls CopyTree on the default service
+func CopyTree(fn func(paths []string) error) error {
+    return defaultService.CopyTree(fn)
+}
+
+// Close calls Close on the default service
+func Close() error {
+    return defaultService.Close()
+}
@@ -0,0 +1,601 @@
+package dbservice
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/verifier"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+    "go.etcd.io/bbolt"
+    "golang.org/x/crypto/sha3"
+)
+
+// FileTree is the Merkle tree file of a database, in the format of the pwrgo tree so
+// existing files open unchanged: every node is stored as JSON under its hash with the
+// hashes of its children and parent, the data of every key under the key, and the
+// root hash, leaf count, depth and the last node of each level with an odd node
+// count in the metadata. Leaves are in insertion order and hashed pairwise,
+// duplicating an odd last node.
+//
+// Writes only mark their leaf. The root hash rehashes the paths of the leaves marked
+// since the previous one bottom up, each node once, so the nodes shared by the paths
+// of a block are hashed once instead of once per write as in the pwrgo tree.
+type FileTree struct {
+    mutex sync.Mutex
+    db    *bbolt.DB
+
+    // flushedLeaves and flushedRoot describe the tree stored in the file, and
+    // flushID the flush of a sharded tree it was stored by
+    flushedLeaves int
+    flushedRoot   []byte
+    flushID       uint64
+    // leaves and rootHash include the changes since the flush, rootHash as of the
+    // last root hash
+    leaves   int
+    rootHash []byte
+
+    // values holds the data written since the flush
+    values map[string][]byte
+    // positions holds the leaf index of every key written since the file was opened,
+    // and added the keys appended since the flush
+    positions map[string]int
+    added     []string
+    // nodes holds the nodes read or changed since the flush
+    nodes map[nodePosition]*treeNode
+    // dirty holds the key of every leaf written since the last root hash
+    dirty map[int][]byte
+}
+
+// flushIDKey is the metadata key of the flush id, which the pwrgo tree ignores
+var flushIDKey = []byte("shardFlush")
+
+// nodePosition is the level of a node, leaves being level 0, and its index in it
+type nodePosition struct {
+    level, index int
+}
+
+// treeNode is a node as stored in the tree file
+type treeNode struct {
+    Hash   []byte `json:"hash"`
+    Left   []byte `json:"left,omitempty"`
+    Right  []byte `json:"right,omitempty"`
+    Parent []byte `json:"parent,omitempty"`
+
+    // stored is the hash the node is stored under, nil for a node not in the file.
+    // children are its children as stored.
+    stored   []byte
+    children [2][]byte
+    // changed marks a node to write at the next flush
+    changed bool
+}
+
+// setParent points a node at its parent
+func (n *treeNode) setParent(parent []byte) {
+    if !bytes.Equal(n.Parent, parent) {
+        n.Parent = bytes.Clone(parent)
+        n.changed = true
+    }
+}
+
+// OpenFileTree opens the tree file of name in the merkleTree directory, creating it
+// if it does not exist
+func OpenFileTree(name string) (*FileTree, error) {
+    return openFileTree(filepath.Join("merkleTree", name+".db"))
+}
+
+// openFileTree opens the tree file at path, creating it if it does not exist
+func openFileTree(path string) (*FileTree, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, err
+    }
+    db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+    if err != nil {
+        return nil, fmt.Errorf("open tree file %s: %w", path, err)
+    }
+    err = db.Update(func(tx *bbolt.Tx) error {
+        for _, bucket := range []string{merkletree.METADATA_BUCKET, merkletree.NODES_BUCKET, merkletree.KEY_DATA_BUCKET} {
+            if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    t := &FileTree{db: db, positions: make(map[string]int)}
+    if err := t.load(); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return t, nil
+}
+
+// load reads the metadata of the file and drops the changes since the flush
+func (t *FileTree) load() error {
+    t.flushedLeaves, t.flushedRoot = 0, nil
+    err := t.db.View(func(tx *bbolt.Tx) error {
+        metadata := tx.Bucket([]byte(merkletree.METADATA_BUCKET))
+        t.flushedRoot = bytes.Clone(metadata.Get([]byte(merkletree.KEY_ROOT_HASH)))
+        if v := metadata.Get([]byte(merkletree.KEY_NUM_LEAVES)); len(v) >= 4 {
+            t.flushedLeaves = int(binary.BigEndian.Uint32(v))
+        }
+        t.flushID = 0
+        if v := metadata.Get(flushIDKey); len(v) == 8 {
+            t.flushID = binary.BigEndian.Uint64(v)
+        }
+        return nil
+    })
+    t.leaves, t.rootHash = t.flushedLeaves, t.flushedRoot
+    t.values = make(map[string][]byte)
+    t.nodes = make(map[nodePosition]*treeNode)
+    t.dirty = make(map[int][]byte)
+    for _, key := range t.added {
+        delete(t.positions, key)
+    }
+    t.added = nil
+    return err
+}
+
+// levelSize returns the node count of a level of a tree of n leaves
+func levelSize(n, level int) int {
+    for ; level > 0; level-- {
+        n = (n + 1) / 2
+    }
+    return n
+}
+
+// treeDepth returns the level of the root of a tree of n leaves
+func treeDepth(n int) int {
+    depth := 0
+    for ; n > 1; n = (n + 1) / 2 {
+        depth++
+    }
+    return depth
+}
+
+// hashNode hashes the children of an internal node, duplicating a missing right child
+func hashNode(left, right []byte) []byte {
+    if right == nil {
+        right = left
+    }
+    hasher := sha3.NewLegacyKeccak256()
+    hasher.Write(left)
+    hasher.Write(right)
+    return hasher.Sum(nil)
+}
+
+// readNode reads the node stored under hash, nil if there is none
+func readNode(tx *bbolt.Tx, hash []byte) (*treeNode, error) {
+    v := tx.Bucket([]byte(merkletree.NODES_BUCKET)).Get(hash)
+    if v == nil {
+        return nil, nil
+    }
+    node := &treeNode{}
+    if err := json.Unmarshal(v, node); err != nil {
+        return nil, fmt.Errorf("node %x: %w", hash, err)
+    }
+    node.stored = bytes.Clone(hash)
+    node.children = [2][]byte{node.Left, node.Right}
+    return node, nil
+}
+
+// node returns the node at position, reading it from the file through its parent
+// the first time, or a new node past the end of the stored tree
+func (t *FileTree) node(tx *bbolt.Tx, position nodePosition) (*treeNode, error) {
+    if node, ok := t.nodes[position]; ok {
+        return node, nil
+    }
+    depth := treeDepth(t.flushedLeaves)
+    node := &treeNode{}
+    if t.flushedLeaves > 0 && position.level <= depth && position.index < levelSize(t.flushedLeaves, position.level) {
+        hash := t.flushedRoot
+        if position.level < depth {
+            parent, err := t.node(tx, nodePosition{position.level + 1, position.index / 2})
+            if err != nil {
+                return nil, err
+            }
+            hash = parent.children[position.index%2]
+        }
+        stored, err := readNode(tx, hash)
+        if err != nil {
+            return nil, err
+        }
+        if stored == nil {
+            return nil, fmt.Errorf("node %x at level %d is missing from the tree file", hash, position.level)
+        }
+        node = stored
+    }
+    t.nodes[position] = node
+    return node, nil
+}
+
+// locate returns the leaf index of a stored key by following the parent pointers of
+// its leaf to the root, and keeps the nodes it passes for the next root hash
+func (t *FileTree) locate(key, value []byte) (int, error) {
+    var path []*treeNode
+    index := 0
+    err := t.db.View(func(tx *bbolt.Tx) error {
+        hash := merkletree.CalculateLeafHash(key, value)
+        for level := 0; ; level++ {
+            node, err := readNode(tx, hash)
+            if err != nil {
+                return err
+            }
+            if node == nil {
+                return fmt.Errorf("node %x of key %x is missing from the tree file", hash, key)
+            }
+            path = append(path, node)
+            if node.Parent == nil {
+                return nil
+            }
+            parent, err := readNode(tx, node.Parent)
+            if err != nil {
+                return err
+            }
+            switch {
+            case parent == nil:
+                return fmt.Errorf("parent %x of node %x is missing from the tree file", node.Parent, hash)
+            case bytes.Equal(parent.Left, hash):
+            case bytes.Equal(parent.Right, hash):
+                index |= 1 << level
+            default:
+                return fmt.Errorf("node %x is not a child of its parent %x", hash, node.Parent)
+            }
+            hash = node.Parent
+        }
+    })
+    if err != nil {
+        return 0, err
+    }
+    for level, node := range path {
+        position := nodePosition{level, index >> level}
+        if _, ok := t.nodes[position]; !ok {
+            t.nodes[position] = node
+        }
+    }
+    return index, nil
+}
+
+// GetRootHash rehashes the paths of the leaves written since the last call and
+// returns the root hash, nil for an empty tree
+func (t *FileTree) GetRootHash() ([]byte, error) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    if err := t.db.View(t.rehash); err != nil {
+        return nil, err
+    }
+    return bytes.Clone(t.rootHash), nil
+}
+
+// rehash updates the nodes on the paths of the dirty leaves, level by level
+func (t *FileTree) rehash(tx *bbolt.Tx) error {
+    if len(t.dirty) == 0 {
+        return nil
+    }
+    changed := make([]int, 0, len(t.dirty))
+    for index, key := range t.dirty {
+        leaf, err := t.node(tx, nodePosition{0, index})
+        if err != nil {
+            return err
+        }
+        leaf.Hash = merkletree.CalculateLeafHash(key, t.values[string(key)])
+        leaf.changed = true
+        changed = append(changed, index)
+    }
+    t.dirty = make(map[int][]byte)
+    sort.Ints(changed)
+
+    level := 0
+    for ; levelSize(t.leaves, level) > 1; level++ {
+        size := levelSize(t.leaves, level)
+        parents := changed[:0]
+        for _, index := range changed {
+            p := index / 2
+            if len(parents) > 0 && parents[len(parents)-1] == p {
+                continue
+            }
+            parents = append(parents, p)
+
+            left, err := t.node(tx, nodePosition{level, 2 * p})
+            if err != nil {
+                return err
+            }
+            var right *treeNode
+            if 2*p+1 < size {
+                if right, err = t.node(tx, nodePosition{level, 2*p + 1}); err != nil {
+                    return err
+                }
+            }
+            parent, err := t.node(tx, nodePosition{level + 1, p})
+            if err != nil {
+                return err
+            }
+            parent.Left, parent.Right = left.Hash, nil
+            if right != nil {
+                parent.Right = right.Hash
+            }
+            parent.Hash = hashNode(parent.Left, parent.Right)
+            parent.changed = true
+            left.setParent(parent.Hash)
+            if right != nil {
+                right.setParent(parent.Hash)
+            }
+        }
+        changed = parents
+    }
+    root, err := t.node(tx, nodePosition{level, 0})
+    if err != nil {
+        return err
+    }
+    t.rootHash = bytes.Clone(root.Hash)
+    return nil
+}
+
+// GetData returns the data stored under key, nil if there is none
+func (t *FileTree) GetData(key []byte) ([]byte, error) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    return t.getData(key)
+}
+
+func (t *FileTree) getData(key []byte) ([]byte, error) {
+    if value, ok := t.values[string(key)]; ok {
+        return bytes.Clone(value), nil
+    }
+    var value []byte
+    err := t.db.View(func(tx *bbolt.Tx) error {
+        value = bytes.Clone(tx.Bucket([]byte(merkletree.KEY_DATA_BUCKET)).Get(key))
+        return nil
+    })
+    return value, err
+}
+
+// AddOrUpdateData stores data under key, appending a leaf for a new key, and marks
+// the leaf for the next root hash
+func (t *FileTree) AddOrUpdateData(key, data []byte) error {
+    if key == nil {
+        return errors.New("key cannot be nil")
+    }
+    if data == nil {
+        return errors.New("data cannot be nil")
+    }
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    old, err := t.getData(key)
+    if err != nil {
+        return err
+    }
+    if old != nil && bytes.Equal(old, data) {
+        return nil
+    }
+    index, ok := t.positions[string(key)]
+    if !ok {
+        if old == nil {
+            index = t.leaves
+            t.leaves++
+            t.added = append(t.added, string(key))
+        } else if index, err = t.locate(key, old); err != nil {
+            return err
+        }
+        t.positions[string(key)] = index
+    }
+    t.values[string(key)] = bytes.Clone(data)
+    t.dirty[index] = bytes.Clone(key)
+    return nil
+}
+
+// pending returns the writes since the flush, the appended keys first in the order
+// they were appended so writing them again to the flushed tree yields the same one
+func (t *FileTree) pending() []journalWrite {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    writes := make([]journalWrite, 0, len(t.values))
+    appended := make(map[string]bool, len(t.added))
+    for _, key := range t.added {
+        appended[key] = true
+        writes = append(writes, journalWrite{Key: []byte(key), Value: t.values[key]})
+    }
+    updated := make([]string, 0, len(t.values)-len(t.added))
+    for key := range t.values {
+        if !appended[key] {
+            updated = append(updated, key)
+        }
+    }
+    sort.Strings(updated)
+    for _, key := range updated {
+        writes = append(writes, journalWrite{Key: []byte(key), Value: t.values[key]})
+    }
+    return writes
+}
+
+// Proof returns the path of the leaf of key to the current root hash, nil when the
+// key is not stored
+func (t *FileTree) Proof(key []byte) (*verifier.Proof, error) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    value, err := t.getData(key)
+    if err != nil || value == nil {
+        return nil, err
+    }
+    index, ok := t.positions[string(key)]
+    if !ok {
+        if index, err = t.locate(key, value); err != nil {
+            return nil, err
+        }
+        t.positions[string(key)] = index
+    }
+
+    proof := &verifier.Proof{Key: bytes.Clone(key), Value: value}
+    err = t.db.View(func(tx *bbolt.Tx) error {
+        if err := t.rehash(tx); err != nil {
+            return err
+        }
+        for level := 0; levelSize(t.leaves, level) > 1; level++ {
+            sibling := index ^ 1
+            if sibling >= levelSize(t.leaves, level) {
+                proof.Steps = append(proof.Steps, verifier.Step{})
+            } else {
+                node, err := t.node(tx, nodePosition{level, sibling})
+                if err != nil {
+                    return err
+                }
+                proof.Steps = append(proof.Steps, verifier.Step{Sibling: bytes.Clone(node.Hash), Left: sibling < index})
+            }
+            index /= 2
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    proof.RootHash = bytes.Clone(t.rootHash)
+    return proof, nil
+}
+
+// FlushToDisk writes the changes since the last flush to the file in one transaction
+func (t *FileTree) FlushToDisk() error {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    if len(t.values) == 0 {
+        return nil
+    }
+    return t.flush(t.flushID)
+}
+
+// flushAs is FlushToDisk recording the flush as flush id of a sharded tree, even
+// when nothing was written since the last one
+func (t *FileTree) flushAs(id uint64) error {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    if len(t.values) == 0 && id == t.flushID {
+        return nil
+    }
+    return t.flush(id)
+}
+
+// flush writes the changes since the last flush and the flush id. The caller holds
+// the lock.
+func (t *FileTree) flush(id uint64) error {
+    err := t.db.Update(func(tx *bbolt.Tx) error {
+        if err := t.rehash(tx); err != nil {
+            return err
+        }
+        // The last node of each level with an odd count, read before nodes are replaced
+        depth := treeDepth(t.leaves)
+        hanging := make(map[int][]byte)
+        for level := 0; level <= depth; level++ {
+            if size := levelSize(t.leaves, level); size%2 == 1 {
+                node, err := t.node(tx, nodePosition{level, size - 1})
+                if err != nil {
+                    return err
+                }
+                hanging[level] = node.Hash
+            }
+        }
+
+        metadata := tx.Bucket([]byte(merkletree.METADATA_BUCKET))
+        cursor := metadata.Cursor()
+        for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+            if err := cursor.Delete(); err != nil {
+                return err
+            }
+        }
+        if t.rootHash != nil {
+            if err := metadata.Put([]byte(merkletree.KEY_ROOT_HASH), t.rootHash); err != nil {
+                return err
+            }
+        }
+        if err := metadata.Put([]byte(merkletree.KEY_NUM_LEAVES), binary.BigEndian.AppendUint32(nil, uint32(t.leaves))); err != nil {
+            return err
+        }
+        if err := metadata.Put([]byte(merkletree.KEY_DEPTH), binary.BigEndian.AppendUint32(nil, uint32(depth))); err != nil {
+            return err
+        }
+        if id > 0 {
+            if err := metadata.Put(flushIDKey, binary.BigEndian.AppendUint64(nil, id)); err != nil {
+                return err
+            }
+        }
+        for level, hash := range hanging {
+            if err := metadata.Put([]byte(fmt.Sprintf("%s%d", merkletree.KEY_HANGING_NODE_PREFIX, level)), hash); err != nil {
+                return err
+            }
+        }
+
+        // bbolt inserts into a page by shifting the keys after the new one, so keys
+        // are put in order to keep a large flush from moving them once per key
+        var changed []*treeNode
+        for _, node := range t.nodes {
+            if node.changed {
+                changed = append(changed, node)
+            }
+        }
+        sort.Slice(changed, func(i, j int) bool { return bytes.Compare(changed[i].Hash, changed[j].Hash) < 0 })
+        nodes := tx.Bucket([]byte(merkletree.NODES_BUCKET))
+        for _, node := range changed {
+            if node.stored != nil && !bytes.Equal(node.stored, node.Hash) {
+                if err := nodes.Delete(node.stored); err != nil {
+                    return err
+                }
+            }
+        }
+        for _, node := range changed {
+            encoded, err := json.Marshal(node)
+            if err != nil {
+                return err
+            }
+            if err := nodes.Put(node.Hash, encoded); err != nil {
+                return err
+            }
+        }
+
+        keys := make([]string, 0, len(t.values))
+        for key := range t.values {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        keyData := tx.Bucket([]byte(merkletree.KEY_DATA_BUCKET))
+        for _, key := range keys {
+            if err := keyData.Put([]byte(key), t.values[key]); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    t.flushedLeaves, t.flushedRoot, t.flushID = t.leaves, t.rootHash, id
+    t.values = make(map[string][]byte)
+    t.nodes = make(map[nodePosition]*treeNode)
+    t.added = nil
+    return nil
+}
+
+// RevertUnsavedChanges drops the changes since the last flush
+func (t *FileTree) RevertUnsavedChanges() error {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    return t.load()
+}
+
+// Close flushes the changes since the last flush, as the pwrgo tree does, and
+// closes the file
+func (t *FileTree) Close() error {
+    flushErr := t.FlushToDisk()
+    return errors.Join(flushErr, t.db.Close())
+}
@@ -0,0 +1,255 @@
+package dbservice_test
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "math/big"
+    "math/rand"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/testkit"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+)
+
+// inTempDir runs the test in a fresh directory, since tree files are opened relative
+// to the working directory
+func inTempDir(t *testing.T) {
+    t.Helper()
+    wd, err := os.Getwd()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Chdir(t.TempDir()); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// treeStep is a step applied to the file tree and the pwrgo tree alike
+type treeStep struct {
+    // writes are the keys written with a value derived from the step
+    writes []int
+    flush  bool
+    revert bool
+    reopen bool
+}
+
+// randomSteps returns n blocks of writes to keys below accounts, most of them flushed
+func randomSteps(seed int64, n, accounts, writes int) []treeStep {
+    random := rand.New(rand.NewSource(seed))
+    steps := make([]treeStep, n)
+    for i := range steps {
+        for j := 0; j < writes; j++ {
+            steps[i].writes = append(steps[i].writes, random.Intn(accounts))
+        }
+        switch random.Intn(8) {
+        case 0:
+            steps[i].revert = true
+        case 1:
+            steps[i].flush, steps[i].reopen = true, true
+        default:
+            steps[i].flush = true
+        }
+    }
+    return steps
+}
+
+func TestFileTreeMatchesPwrgoTree(t *testing.T) {
+    tests := []struct {
+        name  string
+        steps []treeStep
+    }{
+        {name: "one leaf", steps: []treeStep{{writes: []int{0}, flush: true}, {writes: []int{0}, flush: true}}},
+        {name: "growing tree", steps: []treeStep{
+            {writes: []int{0, 1, 2}, flush: true},
+            {writes: []int{3}, flush: true},
+            {writes: []int{4, 1}, flush: true, reopen: true},
+            {writes: []int{5, 6, 7, 8, 0}, revert: true},
+            {writes: []int{8, 2}, flush: true},
+        }},
+        {name: "revert appended leaves", steps: []treeStep{
+            {writes: []int{0, 1, 2, 3, 4}, flush: true},
+            {writes: []int{5, 6, 2}, revert: true},
+            {writes: []int{7, 3}, flush: true},
+        }},
+        {name: "random blocks", steps: randomSteps(1, 40, 300, 25)},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            inTempDir(t)
+            fileTree, err := dbservice.OpenFileTree("file")
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer func() { fileTree.Close() }()
+            pwrgoTree, err := merkletree.NewMerkleTree("pwrgo")
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer pwrgoTree.Close()
+
+            for i, step := range test.steps {
+                for _, k := range step.writes {
+                    key, value := []byte(fmt.Sprintf("key%d", k)), []byte(fmt.Sprintf("value%d-%d", k, i))
+                    if err := fileTree.AddOrUpdateData(key, value); err != nil {
+                        t.Fatal(err)
+                    }
+                    if err := pwrgoTree.AddOrUpdateData(key, value); err != nil {
+                        t.Fatal(err)
+                    }
+                }
+                got, err := fileTree.GetRootHash()
+                if err != nil {
+                    t.Fatal(err)
+                }
+                want, _ := pwrgoTree.GetRootHash()
+                if !bytes.Equal(got, want) {
+                    t.Fatalf("step %d: root %x, pwrgo tree %x", i, got, want)
+                }
+                switch {
+                case step.revert:
+                    fileTree.RevertUnsavedChanges()
+                    pwrgoTree.RevertUnsavedChanges()
+                case step.flush:
+                    if err := fileTree.FlushToDisk(); err != nil {
+                        t.Fatal(err)
+                    }
+                    pwrgoTree.FlushToDisk()
+                }
+                if step.reopen {
+                    fileTree.Close()
+                    if fileTree, err = dbservice.OpenFileTree("file"); err != nil {
+                        t.Fatal(err)
+                    }
+                }
+                got, _ = fileTree.GetRootHash()
+                want, _ = pwrgoTree.GetRootHash()
+                if !bytes.Equal(got, want) {
+                    t.Fatalf("step %d after flush or revert: root %x, pwrgo tree %x", i, got, want)
+                }
+            }
+        })
+    }
+}
+
+// The file keeps the format of the pwrgo tree, so its nodes prove keys and the pwrgo
+// tree continues it
+func TestFileTreeFormat(t *testing.T) {
+    inTempDir(t)
+    tree, err := dbservice.OpenFileTree("format")
+    if err != nil {
+        t.Fatal(err)
+    }
+    reference := testkit.NewMemoryTree()
+    write := func(tree dbservice.Tree, key, value string) {
+        if err := tree.AddOrUpdateData([]byte(key), []byte(value)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for i := 0; i < 50; i++ {
+        write(tree, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+        write(reference, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+        if i%7 == 0 {
+            tree.FlushToDisk()
+        }
+    }
+    write(tree, "key3", "changed")
+    write(reference, "key3", "changed")
+    root, _ := tree.GetRootHash()
+    if err := tree.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    file, err := dbfile.Open(filepath.Join("merkleTree", "format.db"), true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if problems, err := file.Verify(); err != nil || len(problems) > 0 {
+        t.Fatalf("verify: %v %v", problems, err)
+    }
+    for _, key := range []string{"key0", "key3", "key49"} {
+        proof, err := file.Proof([]byte(key))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !proof.Verify(root) {
+            t.Errorf("proof of %s does not verify", key)
+        }
+    }
+    file.Close()
+
+    pwrgoTree, err := merkletree.NewMerkleTree("format")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer pwrgoTree.Close()
+    if got, _ := pwrgoTree.GetRootHash(); !bytes.Equal(got, root) {
+        t.Fatalf("pwrgo tree root %x, want %x", got, root)
+    }
+    for _, tree := range []dbservice.Tree{pwrgoTree, reference} {
+        write(tree, "key10", "changed")
+        write(tree, "key50", "value50")
+    }
+    got, _ := pwrgoTree.GetRootHash()
+    want, _ := reference.GetRootHash()
+    if !bytes.Equal(got, want) {
+        t.Errorf("pwrgo tree continuing the file: root %x, want %x", got, want)
+    }
+}
+
+func TestCommittedProof(t *testing.T) {
+    inTempDir(t)
+    service := dbservice.New("proof")
+    defer service.Close()
+    address := func(i int) []byte { return bytes.Repeat([]byte{byte(i + 1)}, dbservice.AddressLength) }
+    for i := 0; i < 13; i++ {
+        if err := service.SetBalance(address(i), big.NewInt(int64(100+i))); err != nil {
+            t.Fatal(err)
+        }
+        if i == 6 {
+            if err := service.Flush(); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+    if err := service.EndBlock(1); err != nil {
+        t.Fatal(err)
+    }
+    // A block in progress does not reach the proofs
+    if err := service.SetBalance(address(0), big.NewInt(1)); err != nil {
+        t.Fatal(err)
+    }
+    root, err := service.CommittedRootHash()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, i := range []int{0, 5, 12} {
+        proof, err := service.CommittedProof(address(i))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if proof == nil || !proof.Verify(root) {
+            t.Fatalf("proof of account %d does not verify against %x: %+v", i, root, proof)
+        }
+        if got := new(big.Int).SetBytes(proof.Value); got.Int64() != int64(100+i) {
+            t.Errorf("proof of account %d holds %v, want %d", i, got, 100+i)
+        }
+    }
+    if proof, err := service.CommittedProof(address(20)); err != nil || proof != nil {
+        t.Errorf("proof of a missing account = %+v, %v, want none", proof, err)
+    }
+
+    sharded := dbservice.New("sharded")
+    sharded.SetShards(2)
+    defer sharded.Close()
+    if _, err := sharded.CommittedProof(address(0)); !errors.Is(err, dbservice.ErrNoProofs) {
+        t.Errorf("proof from a sharded state: %v, want ErrNoProofs", err)
+    }
+}
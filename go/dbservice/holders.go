@@ -0,0 +1,122 @@
+package dbservice
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "math/big"
+    "sort"
+    "sync"
+)
+
+// The account index is a side file that a node can lack or have stale, so the
+// state rules that need every holder, such as distribution snapshots, enumerate
+// them from a list kept in the tree instead. An address joins the list the first
+// time it is given a positive balance and never leaves it. The list is kept
+// outside canonical mode only, as the canonical specification defines every key.
+var (
+    holderCountKey     = []byte(HoldersPrefix + "count")
+    holderIndexPrefix  = HoldersPrefix + "index/"
+    holderMarkerPrefix = HoldersPrefix + "address/"
+)
+
+// holderList serializes the additions to the holder list
+type holderList struct {
+    mutex sync.Mutex
+}
+
+// holderIndexKey returns the key of the address at index of the holder list
+func holderIndexKey(index uint64) []byte {
+    return binary.BigEndian.AppendUint64([]byte(holderIndexPrefix), index)
+}
+
+// addHolder appends address to the holder list unless it is already on it
+func (s *DatabaseService) addHolder(ctx context.Context, address []byte) error {
+    if s.canonical || KeyNamespace(address) != NamespaceAccount {
+        return nil
+    }
+    s.holders.mutex.Lock()
+    defer s.holders.mutex.Unlock()
+
+    marker := append([]byte(holderMarkerPrefix), address...)
+    known, err := s.buffer.getData(ctx, marker)
+    if err != nil || len(known) > 0 {
+        return err
+    }
+    count, err := s.holderCount(ctx)
+    if err != nil {
+        return err
+    }
+    if err := s.writeTree(holderIndexKey(count), bytes.Clone(address)); err != nil {
+        return err
+    }
+    if err := s.writeTree(marker, []byte{1}); err != nil {
+        return err
+    }
+    return s.writeTree(holderCountKey, binary.BigEndian.AppendUint64(nil, count+1))
+}
+
+// holderCount returns the number of addresses on the holder list
+func (s *DatabaseService) holderCount(ctx context.Context) (uint64, error) {
+    data, err := s.buffer.getData(ctx, holderCountKey)
+    if err != nil || len(data) < 8 {
+        return 0, err
+    }
+    return binary.BigEndian.Uint64(data), nil
+}
+
+// ForEachHolder calls fn for every address ever given a positive balance, in
+// ascending address order, until fn returns false. Unlike ForEachAccount it reads
+// only the tree, so every node enumerates the same holders.
+func (s *DatabaseService) ForEachHolder(fn func(address []byte, balance *big.Int) bool) error {
+    return s.ForEachHolderContext(context.Background(), fn)
+}
+
+// ForEachHolderContext is ForEachHolder, stopping with the error of ctx once it is
+// done, which is checked before every holder
+func (s *DatabaseService) ForEachHolderContext(ctx context.Context, fn func(address []byte, balance *big.Int) bool) error {
+    s.initialize()
+    count, err := s.holderCount(ctx)
+    if err != nil {
+        return err
+    }
+    addresses := make([][]byte, 0, count)
+    for i := uint64(0); i < count; i++ {
+        address, err := s.buffer.getData(ctx, holderIndexKey(i))
+        if err != nil {
+            return err
+        }
+        addresses = append(addresses, address)
+    }
+    sort.Slice(addresses, func(i, j int) bool {
+        return bytes.Compare(addresses[i], addresses[j]) < 0
+    })
+
+    for _, address := range addresses {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        balance, err := s.GetBalanceContext(ctx, address)
+        if err != nil {
+            return err
+        }
+        if !fn(address, balance) {
+            return nil
+        }
+    }
+    return nil
+}
+
+// HolderBalance returns the sum of the balances of every holder, read from the
+// tree like ForEachHolder
+func (s *DatabaseService) HolderBalance() (*big.Int, error) {
+    total := big.NewInt(0)
+    err := s.ForEachHolder(func(address []byte, balance *big.Int) bool {
+        total.Add(total, balance)
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    return total, nil
+}
@@ -0,0 +1,118 @@
+package dbservice_test
+
+import (
+    "bytes"
+    "fmt"
+    "math/big"
+    "os"
+    "reflect"
+    "testing"
+    "time"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/testkit"
+
+    "go.etcd.io/bbolt"
+)
+
+func TestForEachHolderReadsOnlyTheTree(t *testing.T) {
+    address := func(b byte) []byte { return bytes.Repeat([]byte{b}, dbservice.AddressLength) }
+
+    tests := []struct {
+        name string
+        // open returns a service whose balances were set, and may damage its account index
+        open func(t *testing.T) *dbservice.DatabaseService
+    }{
+        {
+            name: "memory tree without an account index",
+            open: func(t *testing.T) *dbservice.DatabaseService {
+                service := dbservice.New("holders")
+                service.UseTree(testkit.NewMemoryTree())
+                setBalances(t, service)
+                return service
+            },
+        },
+        {
+            name: "account index emptied after a restart",
+            open: func(t *testing.T) *dbservice.DatabaseService {
+                dir := t.TempDir()
+                service := dbservice.New("holders")
+                service.SetDir(dir)
+                setBalances(t, service)
+                if err := service.Flush(); err != nil {
+                    t.Fatal(err)
+                }
+                service.Close()
+
+                // An index whose bucket exists is never backfilled, so it stays empty
+                os.Remove(service.AccountIndexPath())
+                index, err := bbolt.Open(service.AccountIndexPath(), 0600, &bbolt.Options{Timeout: time.Second})
+                if err != nil {
+                    t.Fatal(err)
+                }
+                index.Update(func(tx *bbolt.Tx) error {
+                    _, err := tx.CreateBucket([]byte("accounts"))
+                    return err
+                })
+                index.Close()
+
+                reopened := dbservice.New("holders")
+                reopened.SetDir(dir)
+                return reopened
+            },
+        },
+        {
+            name: "holder added after the flush reverted",
+            open: func(t *testing.T) *dbservice.DatabaseService {
+                service := dbservice.New("holders")
+                service.SetDir(t.TempDir())
+                setBalances(t, service)
+                if err := service.Flush(); err != nil {
+                    t.Fatal(err)
+                }
+                if err := service.SetBalance(address(1), big.NewInt(4)); err != nil {
+                    t.Fatal(err)
+                }
+                if err := service.RevertUnsavedChanges(); err != nil {
+                    t.Fatal(err)
+                }
+                return service
+            },
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            service := test.open(t)
+            defer service.Close()
+
+            var got []string
+            err := service.ForEachHolder(func(address []byte, balance *big.Int) bool {
+                got = append(got, fmt.Sprintf("%02x:%s", address[0], balance))
+                return true
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+            // 04 never held funds, so it is left out
+            if want := []string{"02:0", "05:30", "09:7"}; !reflect.DeepEqual(got, want) {
+                t.Errorf("holders = %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+// setBalances funds accounts 09 and 05, empties 02 after funding it and gives 04
+// a zero balance, so 04 is the only account that never held funds
+func setBalances(t *testing.T, service *dbservice.DatabaseService) {
+    t.Helper()
+    writes := []struct {
+        account byte
+        balance int64
+    }{{9, 7}, {2, 3}, {5, 30}, {2, 0}, {4, 0}}
+    for _, write := range writes {
+        address := bytes.Repeat([]byte{write.account}, dbservice.AddressLength)
+        if err := service.SetBalance(address, big.NewInt(write.balance)); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
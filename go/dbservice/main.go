@@ -1,151 +1,633 @@
 package dbservice
 
 import (
+    "bytes"
+    "context"
     "encoding/binary"
+    "errors"
     "math/big"
+    "path/filepath"
     "sync"
 
-    "github.com/pwrlabs/pwrgo/config/merkletree"
+    "pwr-stateful-vida/canonical"
+    "pwr-stateful-vida/chaos"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
 )
 
-var (
-    tree                *merkletree.MerkleTree
-    initOnce            sync.Once
-    lastCheckedBlockKey = []byte("lastCheckedBlock")
-    blockRootPrefix     = "blockRootHash_"
+// Tree is the storage the service keeps its state in. The Merkle tree file is used
+// unless another implementation is installed with UseTree.
+type Tree interface {
+    GetRootHash() ([]byte, error)
+    GetData(key []byte) ([]byte, error)
+    AddOrUpdateData(key, data []byte) error
+    FlushToDisk() error
+    RevertUnsavedChanges() error
+    Close() error
+}
+
+// logger is the log of the database module
+var logger = logging.For("db")
+
+// blockRootPrefix prefixes the keys of block root hashes outside canonical mode
+var blockRootPrefix = "blockRootHash_"
+
+// LastCheckedBlockKey is the key under which the checkpoint block number is stored
+var LastCheckedBlockKey = []byte("lastCheckedBlock")
+
+// DatabaseService keeps a state in a tree of its own: the Merkle tree files named
+// after the service, or the tree installed with UseTree. Services do not share
+// anything but metrics, each having its own account index, balance cache and
+// writes since the last flush, so several states can be open in one process. The
+// tree is opened on first use.
+type DatabaseService struct {
+    name     string
+    dir      string
+    shards   int
+    tree     Tree
+    buffer   *writeBuffer
+    initOnce sync.Once
+    accounts accountSet
+    balances *balanceCache
+    changes  changeLog
+    applied  appliedLog
+    holders  holderList
+    // canonical stores balances and block root hashes as package canonical specifies
+    canonical bool
+    // custom is set once UseTree replaced the tree files
+    custom bool
+    // observer is called after every balance write
+    observer func(address []byte, old, new *big.Int)
+}
+
+// New returns a service keeping its state in the tree files named name in the
+// merkleTree directory. Two open services must not use the same name.
+func New(name string) *DatabaseService {
+    return &DatabaseService{
+        name:     name,
+        shards:   1,
+        accounts: accountSet{pending: make(map[string]struct{})},
+        balances: newBalanceCache(),
+        changes:  changeLog{pending: make(map[string]*Change)},
+    }
+}
+
+// SetDir keeps the tree files in the merkleTree directory under dir rather than the
+// working directory, so the services of nodes in different directories may share a
+// name. Call it before any other method.
+func (s *DatabaseService) SetDir(dir string) {
+    s.dir = dir
+}
+
+// file returns the path of a file of the service in its merkleTree directory
+func (s *DatabaseService) file(name string) string {
+    return filepath.Join(s.dir, "merkleTree", name)
+}
+
+// SetCanonical makes the state follow the canonical specification shared with the
+// other implementations. It changes the root hash, so a database keeps the setting
+// it was created with. Call it before any other method.
+func (s *DatabaseService) SetCanonical(enabled bool) {
+    s.canonical = enabled
+}
+
+// BlockRootHashKey returns the key under which the root hash of a block is stored
+func (s *DatabaseService) BlockRootHashKey(blockNumber int64) []byte {
+    return blockRootHashKey(blockNumber, s.canonical)
+}
+
+// blockRootHashKey returns the key of the root hash of a block in either mode
+func blockRootHashKey(blockNumber int64, canonicalMode bool) []byte {
+    if canonicalMode {
+        return canonical.BlockRootHashKey(blockNumber)
+    }
+    return []byte(blockRootPrefix + string(rune(blockNumber)))
+}
+
+// checkCanonical refuses a database written in the other mode, which the root hash
+// of its last checkpoint is keyed by
+func (s *DatabaseService) checkCanonical(t Tree) error {
+    data, err := t.GetData(LastCheckedBlockKey)
+    if err != nil {
+        return err
+    }
+    blockNumber := DecodeBlockNumber(data)
+    if blockNumber == 0 {
+        return nil
+    }
+    own, err := t.GetData(blockRootHashKey(blockNumber, s.canonical))
+    if err != nil || own != nil {
+        return err
+    }
+    other, err := t.GetData(blockRootHashKey(blockNumber, !s.canonical))
+    if err != nil || other == nil {
+        return err
+    }
+    if s.canonical {
+        return errors.New("the database is not canonical, unset canonical")
+    }
+    return errors.New("the database is canonical, set canonical")
+}
+
+// encodeBalance returns the stored form of a balance
+func (s *DatabaseService) encodeBalance(balance *big.Int) []byte {
+    if s.canonical {
+        return canonical.EncodeBalance(balance)
+    }
+    return balance.Bytes()
+}
+
+// Key prefixes of the transfer policy, the node key registry, the staking module,
+// the token registry, the non-fungible items, the swap pools, the distribution
+// snapshots, the account rules, the name registry, the asset bridge, the fee
+// sponsorships, the cross-VIDA message boxes, the savings pool, governance, the
+// airdrops, account recovery, the monetary policy, the OTC offers, referrals,
+// payment streams, settlement batches, the transfer fee, the params recorded at
+// genesis and the holder list
+const (
+    PolicyPrefix       = "policy/"
+    NodeKeysPrefix     = "nodeKeys/"
+    StakingPrefix      = "staking/"
+    TokensPrefix       = "tokens/"
+    NFTPrefix          = "nfts/"
+    SwapPrefix         = "swap/"
+    DistributionPrefix = "distribution/"
+    AccountRulesPrefix = "accountRules/"
+    NamesPrefix        = "names/"
+    BridgePrefix       = "bridge/"
+    PaymasterPrefix    = "paymaster/"
+    CrossVidaPrefix    = "crossVida/"
+    SavingsPrefix      = "savings/"
+    GovernancePrefix   = "governance/"
+    AirdropPrefix      = "airdrop/"
+    RecoveryPrefix     = "recovery/"
+    MonetaryPrefix     = "monetary/"
+    OTCPrefix          = "otc/"
+    ReferralPrefix     = "referral/"
+    StreamPrefix       = "stream/"
+    SettlementPrefix   = "settlement/"
+    FeesPrefix         = "fees/"
+    GenesisPrefix      = "genesis/"
+    HoldersPrefix      = "holders/"
+)
+
+// Key namespaces reported by KeyNamespace
+const (
+    NamespaceAccount      = "account"
+    NamespaceBlockRoot    = "blockRoot"
+    NamespaceCheckpoint   = "checkpoint"
+    NamespacePolicy       = "policy"
+    NamespaceNodeKeys     = "nodeKeys"
+    NamespaceStaking      = "staking"
+    NamespaceTokens       = "tokens"
+    NamespaceNFT          = "nft"
+    NamespaceSwap         = "swap"
+    NamespaceDistribution = "distribution"
+    NamespaceAccountRules = "accountRules"
+    NamespaceNames        = "names"
+    NamespaceBridge       = "bridge"
+    NamespacePaymaster    = "paymaster"
+    NamespaceCrossVida    = "crossVida"
+    NamespaceSavings      = "savings"
+    NamespaceGovernance   = "governance"
+    NamespaceAirdrop      = "airdrop"
+    NamespaceRecovery     = "recovery"
+    NamespaceMonetary     = "monetary"
+    NamespaceOTC          = "otc"
+    NamespaceReferral     = "referral"
+    NamespaceStream       = "stream"
+    NamespaceSettlement   = "settlement"
+    NamespaceFees         = "fees"
+    NamespaceGenesis      = "genesis"
+    NamespaceHolders      = "holders"
+    NamespaceOther        = "other"
 )
 
-// initialize sets up the singleton MerkleTree instance
-func initialize() {
-    initOnce.Do(func() {
-        tree, _ = merkletree.NewMerkleTree("database")
+// KeyNamespace classifies a tree key by the kind of state it holds. Prefixed keys
+// can be as long as an address, so the prefixes are checked first.
+func KeyNamespace(key []byte) string {
+    switch {
+    case bytes.Equal(key, LastCheckedBlockKey):
+        return NamespaceCheckpoint
+    case bytes.HasPrefix(key, []byte(blockRootPrefix)):
+        return NamespaceBlockRoot
+    case bytes.HasPrefix(key, []byte(PolicyPrefix)):
+        return NamespacePolicy
+    case bytes.HasPrefix(key, []byte(NodeKeysPrefix)):
+        return NamespaceNodeKeys
+    case bytes.HasPrefix(key, []byte(StakingPrefix)):
+        return NamespaceStaking
+    case bytes.HasPrefix(key, []byte(TokensPrefix)):
+        return NamespaceTokens
+    case bytes.HasPrefix(key, []byte(NFTPrefix)):
+        return NamespaceNFT
+    case bytes.HasPrefix(key, []byte(SwapPrefix)):
+        return NamespaceSwap
+    case bytes.HasPrefix(key, []byte(DistributionPrefix)):
+        return NamespaceDistribution
+    case bytes.HasPrefix(key, []byte(AccountRulesPrefix)):
+        return NamespaceAccountRules
+    case bytes.HasPrefix(key, []byte(NamesPrefix)):
+        return NamespaceNames
+    case bytes.HasPrefix(key, []byte(BridgePrefix)):
+        return NamespaceBridge
+    case bytes.HasPrefix(key, []byte(PaymasterPrefix)):
+        return NamespacePaymaster
+    case bytes.HasPrefix(key, []byte(CrossVidaPrefix)):
+        return NamespaceCrossVida
+    case bytes.HasPrefix(key, []byte(SavingsPrefix)):
+        return NamespaceSavings
+    case bytes.HasPrefix(key, []byte(GovernancePrefix)):
+        return NamespaceGovernance
+    case bytes.HasPrefix(key, []byte(AirdropPrefix)):
+        return NamespaceAirdrop
+    case bytes.HasPrefix(key, []byte(RecoveryPrefix)):
+        return NamespaceRecovery
+    case bytes.HasPrefix(key, []byte(MonetaryPrefix)):
+        return NamespaceMonetary
+    case bytes.HasPrefix(key, []byte(OTCPrefix)):
+        return NamespaceOTC
+    case bytes.HasPrefix(key, []byte(ReferralPrefix)):
+        return NamespaceReferral
+    case bytes.HasPrefix(key, []byte(StreamPrefix)):
+        return NamespaceStream
+    case bytes.HasPrefix(key, []byte(SettlementPrefix)):
+        return NamespaceSettlement
+    case bytes.HasPrefix(key, []byte(FeesPrefix)):
+        return NamespaceFees
+    case bytes.HasPrefix(key, []byte(GenesisPrefix)):
+        return NamespaceGenesis
+    case bytes.HasPrefix(key, []byte(HoldersPrefix)):
+        return NamespaceHolders
+    case len(key) == AddressLength:
+        return NamespaceAccount
+    }
+    return NamespaceOther
+}
+
+// DecodeBlockNumber decodes a block number stored by SetLastCheckedBlock
+func DecodeBlockNumber(data []byte) int64 {
+    if len(data) < 8 {
+        return 0
+    }
+    return int64(binary.BigEndian.Uint64(data))
+}
+
+// initialize opens the Merkle tree of the service on first use
+func (s *DatabaseService) initialize() {
+    s.initOnce.Do(func() {
+        s.openAccountIndex()
+        merkleTree, err := s.openTree()
+        if err == nil {
+            if err = s.checkCanonical(merkleTree); err != nil {
+                merkleTree.Close()
+            }
+        }
+        if err != nil {
+            logger.Error("failed to open Merkle tree", "name", s.name, "shards", s.shards, "error", err)
+            reporting.Report(err, reporting.Context{Module: "db", Extra: map[string]string{"tree": s.name}})
+            return
+        }
+        s.buffer = newWriteBuffer(timedTree{merkleTree})
+        s.tree = s.buffer
+        s.loadCommittedBlock()
     })
 }
 
+// UseTree replaces the backing tree, for example with an in-memory tree in tests.
+// Call it before any other method; the account index is not opened.
+func (s *DatabaseService) UseTree(t Tree) {
+    s.initOnce.Do(func() {})
+    s.custom = true
+    s.buffer = newWriteBuffer(timedTree{t})
+    s.tree = s.buffer
+    s.balances.clear()
+    s.revertApplied()
+    s.loadCommittedBlock()
+}
+
 // GetRootHash returns the current Merkle root hash
-func GetRootHash() ([]byte, error) {
-    initialize()
-    return tree.GetRootHash()
+func (s *DatabaseService) GetRootHash() ([]byte, error) {
+    return s.GetRootHashContext(context.Background())
+}
+
+// GetRootHashContext is GetRootHash, unless ctx is done
+func (s *DatabaseService) GetRootHashContext(ctx context.Context) ([]byte, error) {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    return s.tree.GetRootHash()
 }
 
 // Flush pending writes to disk
-func Flush() error {
-    initialize()
-    return tree.FlushToDisk()
+func (s *DatabaseService) Flush() error {
+    return s.FlushContext(context.Background())
+}
+
+// FlushContext is Flush, unless ctx is done. A flush that started runs to the end,
+// so the files are never left half written.
+func (s *DatabaseService) FlushContext(ctx context.Context) error {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    if chaos.Inject(chaos.FlushFailure) {
+        err := chaos.Error(chaos.FlushFailure)
+        logger.Error("failed to flush tree", "error", err)
+        return err
+    }
+    checkpoint, err := s.GetLastCheckedBlock()
+    if err != nil {
+        return err
+    }
+    if err := s.writeApplied(checkpoint); err != nil {
+        // Replay protection of this batch is lost, the state is still flushed
+        logger.Error("failed to record applied transactions", "block", checkpoint, "error", err)
+        reporting.Report(err, reporting.Context{Module: "db", Block: checkpoint})
+    }
+    if err := s.tree.FlushToDisk(); err != nil {
+        logger.Error("failed to flush tree", "error", err)
+        reporting.Report(err, reporting.Context{Module: "db"})
+        return err
+    }
+    s.commitApplied(checkpoint)
+    logger.Debug("flushed tree")
+    s.clearChanges()
+    return s.flushAccounts()
 }
 
 // RevertUnsavedChanges reverts all unsaved changes
-func RevertUnsavedChanges() error {
-    initialize()
-    return tree.RevertUnsavedChanges()
+func (s *DatabaseService) RevertUnsavedChanges() error {
+    return s.RevertUnsavedChangesContext(context.Background())
 }
 
-// GetBalance retrieves the balance stored at the given address
-func GetBalance(address []byte) (*big.Int, error) {
-    initialize()
-    if address == nil {
-        return big.NewInt(0), nil
+// RevertUnsavedChangesContext is RevertUnsavedChanges, unless ctx is done
+func (s *DatabaseService) RevertUnsavedChangesContext(ctx context.Context) error {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return err
     }
+    logger.Debug("reverting unsaved changes")
+    s.revertAccounts()
+    s.revertApplied()
+    s.clearChanges()
+    s.balances.clear()
+    if err := s.tree.RevertUnsavedChanges(); err != nil {
+        return err
+    }
+    s.loadCheckpoint()
+    return nil
+}
 
-    data, err := tree.GetData(address)
-    if err != nil {
+// scratchInts holds big.Ints reused for the arithmetic of transfers, which runs for
+// almost every transaction
+var scratchInts = sync.Pool{New: func() any { return new(big.Int) }}
+
+// GetBalance retrieves the balance stored at the given address
+func (s *DatabaseService) GetBalance(address []byte) (*big.Int, error) {
+    return s.GetBalanceContext(context.Background(), address)
+}
+
+// GetBalanceContext is GetBalance, waiting for the tree no longer than ctx allows
+func (s *DatabaseService) GetBalanceContext(ctx context.Context, address []byte) (*big.Int, error) {
+    s.initialize()
+    balance := new(big.Int)
+    if err := s.readBalance(ctx, address, balance); err != nil {
         return nil, err
     }
+    return balance, nil
+}
 
-    if data == nil || len(data) == 0 {
-        return big.NewInt(0), nil
+// readBalance sets into the balance stored at address, reusing the memory of into
+func (s *DatabaseService) readBalance(ctx context.Context, address []byte, into *big.Int) error {
+    if address == nil {
+        into.SetInt64(0)
+        return nil
+    }
+    if s.balances.load(address, into) {
+        return nil
     }
 
-    balance := new(big.Int)
-    balance.SetBytes(data)
-    return balance, nil
+    data, err := s.buffer.getData(ctx, address)
+    if err != nil {
+        return err
+    }
+    into.SetBytes(data)
+    s.balances.put(address, into)
+    return nil
 }
 
-// SetBalance sets the balance for the given address
-func SetBalance(address []byte, balance *big.Int) error {
-    initialize()
+// SetBalance sets the balance for the given address. balance is not retained, so
+// the caller may reuse it.
+func (s *DatabaseService) SetBalance(address []byte, balance *big.Int) error {
+    return s.SetBalanceContext(context.Background(), address, balance)
+}
+
+// SetBalanceContext is SetBalance, unless ctx is done
+func (s *DatabaseService) SetBalanceContext(ctx context.Context, address []byte, balance *big.Int) error {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return err
+    }
     if address == nil || balance == nil {
         return nil
     }
 
-    return tree.AddOrUpdateData(address, balance.Bytes())
+    s.trackAccount(address)
+    if balance.Sign() > 0 {
+        if err := s.addHolder(ctx, address); err != nil {
+            return err
+        }
+    }
+    if s.observer != nil {
+        old, err := s.GetBalance(address)
+        if err != nil {
+            return err
+        }
+        if err := s.writeTree(address, s.encodeBalance(balance)); err != nil {
+            return err
+        }
+        s.balances.put(address, balance)
+        s.observer(address, old, balance)
+        return nil
+    }
+    // The cached balance is replaced in place rather than dropped by writeData
+    if err := s.writeTree(address, s.encodeBalance(balance)); err != nil {
+        return err
+    }
+    s.balances.put(address, balance)
+    return nil
+}
+
+// ObserveBalances registers fn to be called with the previous and new balance after
+// every balance write, or removes the observer when fn is nil. Call it before processing starts.
+func (s *DatabaseService) ObserveBalances(fn func(address []byte, old, new *big.Int)) {
+    s.observer = fn
 }
 
 // Transfer transfers amount from sender to receiver
-func Transfer(sender, receiver []byte, amount *big.Int) (bool, error) {
-    initialize()
+func (s *DatabaseService) Transfer(sender, receiver []byte, amount *big.Int) (bool, error) {
+    return s.TransferContext(context.Background(), sender, receiver, amount)
+}
+
+// TransferContext is Transfer, unless ctx is done before it starts. A transfer that
+// started is not interrupted, so funds never leave the sender without reaching the
+// receiver.
+func (s *DatabaseService) TransferContext(ctx context.Context, sender, receiver []byte, amount *big.Int) (bool, error) {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return false, err
+    }
     if sender == nil || receiver == nil || amount == nil {
         return false, nil
     }
 
-    senderBalance, err := GetBalance(sender)
-    if err != nil {
+    // Both balances are computed in one scratch value, which SetBalance copies
+    balance := scratchInts.Get().(*big.Int)
+    defer scratchInts.Put(balance)
+
+    if err := s.readBalance(context.Background(), sender, balance); err != nil {
         return false, err
     }
 
-    if senderBalance.Cmp(amount) < 0 {
+    if balance.Cmp(amount) < 0 {
         return false, nil // Insufficient funds
     }
 
-    newSenderBalance := new(big.Int).Sub(senderBalance, amount)
-    if err := SetBalance(sender, newSenderBalance); err != nil {
+    if err := s.SetBalance(sender, balance.Sub(balance, amount)); err != nil {
         return false, err
     }
 
-    receiverBalance, _ := GetBalance(receiver)
-    newReceiverBalance := new(big.Int).Add(receiverBalance, amount)
-    if err := SetBalance(receiver, newReceiverBalance); err != nil {
+    if err := s.readBalance(context.Background(), receiver, balance); err != nil {
+        return false, err
+    }
+    if err := s.SetBalance(receiver, balance.Add(balance, amount)); err != nil {
         return false, err
     }
 
     return true, nil
 }
 
+// GetData returns the raw value stored under key
+func (s *DatabaseService) GetData(key []byte) ([]byte, error) {
+    return s.GetDataContext(context.Background(), key)
+}
+
+// GetDataContext is GetData, waiting for the tree no longer than ctx allows
+func (s *DatabaseService) GetDataContext(ctx context.Context, key []byte) ([]byte, error) {
+    s.initialize()
+    return s.buffer.getData(ctx, key)
+}
+
+// SetData stores a raw value under key
+func (s *DatabaseService) SetData(key, value []byte) error {
+    return s.SetDataContext(context.Background(), key, value)
+}
+
+// SetDataContext is SetData, unless ctx is done
+func (s *DatabaseService) SetDataContext(ctx context.Context, key, value []byte) error {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    s.trackAccount(key)
+    return s.writeData(key, value)
+}
+
 // GetLastCheckedBlock returns the last checked block number
-func GetLastCheckedBlock() (int64, error) {
-    initialize()
-    data, err := tree.GetData(lastCheckedBlockKey)
+func (s *DatabaseService) GetLastCheckedBlock() (int64, error) {
+    return s.GetLastCheckedBlockContext(context.Background())
+}
+
+// GetLastCheckedBlockContext is GetLastCheckedBlock, waiting for the tree no longer
+// than ctx allows
+func (s *DatabaseService) GetLastCheckedBlockContext(ctx context.Context) (int64, error) {
+    s.initialize()
+    data, err := s.buffer.getData(ctx, LastCheckedBlockKey)
     if err != nil {
         return 0, err
     }
 
-    if data == nil || len(data) < 8 {
-        return 0, nil
-    }
-
-    return int64(binary.BigEndian.Uint64(data)), nil
+    return DecodeBlockNumber(data), nil
 }
 
 // SetLastCheckedBlock updates the last checked block number
-func SetLastCheckedBlock(blockNumber int) error {
-    initialize()
+func (s *DatabaseService) SetLastCheckedBlock(blockNumber int) error {
+    return s.SetLastCheckedBlockContext(context.Background(), blockNumber)
+}
+
+// SetLastCheckedBlockContext is SetLastCheckedBlock, unless ctx is done
+func (s *DatabaseService) SetLastCheckedBlockContext(ctx context.Context, blockNumber int) error {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return err
+    }
     blockBytes := make([]byte, 8)
     binary.BigEndian.PutUint64(blockBytes, uint64(blockNumber))
-    return tree.AddOrUpdateData(lastCheckedBlockKey, blockBytes)
+    if err := s.writeData(LastCheckedBlockKey, blockBytes); err != nil {
+        return err
+    }
+    s.noteChecked(int64(blockNumber))
+    // The root hash drains the buffer, which the checkpoint ends anyway
+    rootHash, err := s.tree.GetRootHash()
+    if err != nil {
+        return err
+    }
+    s.buffer.setBlock(int64(blockNumber))
+    s.setCheckpoint(int64(blockNumber), rootHash)
+    return nil
 }
 
 // SetBlockRootHash records the Merkle root hash for a specific block
-func SetBlockRootHash(blockNumber int, rootHash []byte) error {
-    initialize()
+func (s *DatabaseService) SetBlockRootHash(blockNumber int, rootHash []byte) error {
+    return s.SetBlockRootHashContext(context.Background(), blockNumber, rootHash)
+}
+
+// SetBlockRootHashContext is SetBlockRootHash, unless ctx is done
+func (s *DatabaseService) SetBlockRootHashContext(ctx context.Context, blockNumber int, rootHash []byte) error {
+    s.initialize()
+    if err := ctx.Err(); err != nil {
+        return err
+    }
     if rootHash == nil {
         return nil
     }
 
-    key := []byte(blockRootPrefix + string(rune(blockNumber)))
-    return tree.AddOrUpdateData(key, rootHash)
+    return s.writeData(s.BlockRootHashKey(int64(blockNumber)), rootHash)
 }
 
 // GetBlockRootHash retrieves the Merkle root hash for a specific block
-func GetBlockRootHash(blockNumber int64) ([]byte, error) {
-    initialize()
-    key := []byte(blockRootPrefix + string(rune(blockNumber)))
-    return tree.GetData(key)
+func (s *DatabaseService) GetBlockRootHash(blockNumber int64) ([]byte, error) {
+    return s.GetBlockRootHashContext(context.Background(), blockNumber)
+}
+
+// GetBlockRootHashContext is GetBlockRootHash, waiting for the tree no longer than
+// ctx allows
+func (s *DatabaseService) GetBlockRootHashContext(ctx context.Context, blockNumber int64) ([]byte, error) {
+    s.initialize()
+    return s.buffer.getData(ctx, s.BlockRootHashKey(blockNumber))
+}
+
+// CopyTree closes the tree files, passes their paths to fn so it can copy them
+// while the node runs, and opens them again. Reads and writes wait meanwhile. It
+// is called right after a flush, since buffered writes are refused and unsaved
+// changes of the tree are flushed on closing.
+func (s *DatabaseService) CopyTree(fn func(paths []string) error) error {
+    s.initialize()
+    if s.custom {
+        return errors.New("the state is not kept in tree files")
+    }
+    return s.buffer.reopen(func() error { return fn(s.TreePaths()) }, s.openTree)
 }
 
 // Close explicitly closes the DatabaseService
-func Close() error {
-    if tree != nil {
-        return tree.Close()
+func (s *DatabaseService) Close() error {
+    if s.accounts.index != nil {
+        s.accounts.index.Close()
+    }
+    if s.tree != nil {
+        return s.tree.Close()
     }
     return nil
 }
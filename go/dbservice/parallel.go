@@ -0,0 +1,128 @@
+package dbservice
+
+import (
+    "context"
+    "math/big"
+    "sync"
+)
+
+// TransferRequest is a native transfer applied by ApplyTransfers
+type TransferRequest struct {
+    Sender   []byte
+    Receiver []byte
+    Amount   *big.Int
+}
+
+// ApplyTransfers applies native transfers with the same result as calling Transfer
+// on each in order, and reports which of them moved funds. Consecutive transfers
+// touching disjoint accounts form a wave whose balances are read and checked by up
+// to workers goroutines at once; a transfer sharing an account with an earlier one
+// of its wave starts the next wave, so the outcome never depends on scheduling.
+// The writes are made in the order of the transfers, since insertion order
+// determines the root hash. before, if set, is called before the writes of each
+// transfer. On an error no transfer after the last one reported is applied.
+func (s *DatabaseService) ApplyTransfers(transfers []TransferRequest, workers int, before func(i int)) ([]bool, error) {
+    return s.ApplyTransfersContext(context.Background(), transfers, workers, before)
+}
+
+// ApplyTransfersContext is ApplyTransfers, stopping with the error of ctx once it is
+// done, which is checked before every wave
+func (s *DatabaseService) ApplyTransfersContext(ctx context.Context, transfers []TransferRequest, workers int, before func(i int)) ([]bool, error) {
+    s.initialize()
+    applied := make([]bool, len(transfers))
+    for start := 0; start < len(transfers); {
+        if err := ctx.Err(); err != nil {
+            return applied, err
+        }
+        end := waveEnd(transfers, start)
+        senders, receivers, err := s.readWave(transfers[start:end], workers)
+        if err != nil {
+            return applied, err
+        }
+        for i := start; i < end; i++ {
+            transfer := transfers[i]
+            senderBalance, receiverBalance := senders[i-start], receivers[i-start]
+            if senderBalance == nil || senderBalance.Cmp(transfer.Amount) < 0 {
+                continue
+            }
+            if before != nil {
+                before(i)
+            }
+            newSenderBalance := new(big.Int).Sub(senderBalance, transfer.Amount)
+            if err := s.SetBalance(transfer.Sender, newSenderBalance); err != nil {
+                return applied, err
+            }
+            if string(transfer.Sender) == string(transfer.Receiver) {
+                receiverBalance = newSenderBalance
+            }
+            if err := s.SetBalance(transfer.Receiver, new(big.Int).Add(receiverBalance, transfer.Amount)); err != nil {
+                return applied, err
+            }
+            applied[i] = true
+        }
+        start = end
+    }
+    return applied, nil
+}
+
+// waveEnd returns the end of the wave of transfers starting at start: the first
+// transfer touching an account of an earlier one of the wave
+func waveEnd(transfers []TransferRequest, start int) int {
+    touched := make(map[string]bool)
+    for i := start; i < len(transfers); i++ {
+        sender, receiver := string(transfers[i].Sender), string(transfers[i].Receiver)
+        if touched[sender] || touched[receiver] {
+            return i
+        }
+        touched[sender], touched[receiver] = true, true
+    }
+    return len(transfers)
+}
+
+// readWave reads the balances of the senders and receivers of a wave with up to
+// workers goroutines. The balance of a sender is nil when the transfer is invalid.
+func (s *DatabaseService) readWave(wave []TransferRequest, workers int) ([]*big.Int, []*big.Int, error) {
+    senders := make([]*big.Int, len(wave))
+    receivers := make([]*big.Int, len(wave))
+    errs := make([]error, len(wave))
+    read := func(i int) {
+        transfer := wave[i]
+        if transfer.Sender == nil || transfer.Receiver == nil || transfer.Amount == nil {
+            return
+        }
+        if senders[i], errs[i] = s.GetBalance(transfer.Sender); errs[i] != nil {
+            return
+        }
+        receivers[i], errs[i] = s.GetBalance(transfer.Receiver)
+    }
+
+    if workers <= 1 || len(wave) == 1 {
+        for i := range wave {
+            read(i)
+        }
+    } else {
+        next := make(chan int)
+        var wg sync.WaitGroup
+        for w := 0; w < workers && w < len(wave); w++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for i := range next {
+                    read(i)
+                }
+            }()
+        }
+        for i := range wave {
+            next <- i
+        }
+        close(next)
+        wg.Wait()
+    }
+
+    for _, err := range errs {
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+    return senders, receivers, nil
+}
@@ -0,0 +1,87 @@
+package dbservice
+
+import (
+    "bytes"
+    "math/big"
+    "reflect"
+    "testing"
+)
+
+func TestApplyTransfersMatchesTransfer(t *testing.T) {
+    address := func(b byte) []byte { return bytes.Repeat([]byte{b}, AddressLength) }
+    a, b, c, d := address(1), address(2), address(3), address(4)
+    transfer := func(sender, receiver []byte, amount int64) TransferRequest {
+        return TransferRequest{Sender: sender, Receiver: receiver, Amount: big.NewInt(amount)}
+    }
+
+    tests := []struct {
+        name      string
+        transfers []TransferRequest
+    }{
+        {name: "disjoint accounts", transfers: []TransferRequest{transfer(a, b, 10), transfer(c, d, 20)}},
+        {name: "funds received earlier in the batch", transfers: []TransferRequest{transfer(a, b, 100), transfer(b, c, 150), transfer(c, d, 150)}},
+        {name: "insufficient funds", transfers: []TransferRequest{transfer(b, a, 51), transfer(a, b, 101), transfer(b, c, 50)}},
+        {name: "sender spends twice", transfers: []TransferRequest{transfer(a, b, 60), transfer(a, c, 60), transfer(a, d, 40)}},
+        {name: "transfer to itself", transfers: []TransferRequest{transfer(a, a, 30), transfer(a, b, 100)}},
+        {name: "invalid transfers", transfers: []TransferRequest{{Sender: a, Receiver: b}, {Sender: a, Amount: big.NewInt(1)}, transfer(a, b, 1)}},
+    }
+    for _, test := range tests {
+        for _, workers := range []int{1, 4} {
+            // sequential applies the transfers one Transfer at a time
+            sequential := New("database")
+            sequential.SetDir(t.TempDir())
+            batched := New("database")
+            batched.SetDir(t.TempDir())
+            for _, db := range []*DatabaseService{sequential, batched} {
+                for i, account := range [][]byte{a, b, c} {
+                    if err := db.SetBalance(account, big.NewInt(int64(100-i*50))); err != nil {
+                        t.Fatal(err)
+                    }
+                }
+            }
+
+            want := make([]bool, len(test.transfers))
+            for i, request := range test.transfers {
+                ok, err := sequential.Transfer(request.Sender, request.Receiver, request.Amount)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                want[i] = ok
+            }
+            got, err := batched.ApplyTransfers(test.transfers, workers, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(got, want) {
+                t.Errorf("%s with %d workers: ApplyTransfers = %v, sequential Transfer = %v", test.name, workers, got, want)
+            }
+
+            for _, account := range [][]byte{a, b, c, d} {
+                wantBalance, err := sequential.GetBalance(account)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                gotBalance, err := batched.GetBalance(account)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if gotBalance.Cmp(wantBalance) != 0 {
+                    t.Errorf("%s with %d workers: balance of %x = %s, want %s", test.name, workers, account[:1], gotBalance, wantBalance)
+                }
+            }
+            wantRoot, err := sequential.GetRootHash()
+            if err != nil {
+                t.Fatal(err)
+            }
+            gotRoot, err := batched.GetRootHash()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !bytes.Equal(gotRoot, wantRoot) {
+                t.Errorf("%s with %d workers: root %x, want %x", test.name, workers, gotRoot, wantRoot)
+            }
+            sequential.Close()
+            batched.Close()
+        }
+    }
+}
@@ -0,0 +1,42 @@
+package dbservice
+
+import (
+    "encoding/json"
+    "math/big"
+)
+
+// The state modules keep their records as JSON under their key prefixes, with the
+// amounts in them as decimal strings. These helpers are the one encoding of both.
+
+// LoadJSON reads the JSON record under key into value, returning false when there
+// is none
+func (s *DatabaseService) LoadJSON(key []byte, value interface{}) (bool, error) {
+    data, err := s.GetData(key)
+    if err != nil || len(data) == 0 {
+        return false, err
+    }
+    return true, json.Unmarshal(data, value)
+}
+
+// SaveJSON writes value as JSON under key, or an empty value, which removes the
+// record, for nil
+func (s *DatabaseService) SaveJSON(key []byte, value interface{}) error {
+    if value == nil {
+        return s.SetData(key, []byte{})
+    }
+    data, err := json.Marshal(value)
+    if err != nil {
+        return err
+    }
+    return s.SetData(key, data)
+}
+
+// ParseAmount parses a decimal amount of a record, reading a malformed or missing
+// one as zero
+func ParseAmount(value string) *big.Int {
+    parsed, ok := new(big.Int).SetString(value, 10)
+    if !ok {
+        return new(big.Int)
+    }
+    return parsed
+}
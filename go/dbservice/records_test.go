@@ -0,0 +1,58 @@
+package dbservice
+
+import "testing"
+
+func TestJSONRecords(t *testing.T) {
+    type record struct {
+        Amount string `json:"amount"`
+    }
+    tests := []struct {
+        name      string
+        saved     interface{}
+        wantFound bool
+        want      record
+    }{
+        {name: "record", saved: record{Amount: "12"}, wantFound: true, want: record{Amount: "12"}},
+        {name: "removed record", saved: nil},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := New("database")
+            db.SetDir(t.TempDir())
+            defer db.Close()
+
+            key := []byte(GenesisPrefix + "record")
+            if err := db.SaveJSON(key, record{Amount: "1"}); err != nil {
+                t.Fatal(err)
+            }
+            if err := db.SaveJSON(key, test.saved); err != nil {
+                t.Fatal(err)
+            }
+            var got record
+            found, err := db.LoadJSON(key, &got)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if found != test.wantFound || (found && got != test.want) {
+                t.Errorf("LoadJSON = %+v, %v, want %+v, %v", got, found, test.want, test.wantFound)
+            }
+        })
+    }
+}
+
+func TestParseAmount(t *testing.T) {
+    tests := []struct {
+        value string
+        want  string
+    }{
+        {value: "1500", want: "1500"},
+        {value: "-7", want: "-7"},
+        {value: "", want: "0"},
+        {value: "1.5", want: "0"},
+    }
+    for _, test := range tests {
+        if got := ParseAmount(test.value); got.String() != test.want {
+            t.Errorf("ParseAmount(%q) = %s, want %s", test.value, got, test.want)
+        }
+    }
+}
@@ -0,0 +1,63 @@
+package dbservice
+
+import (
+    "container/heap"
+    "context"
+    "math/big"
+    "sort"
+)
+
+// Account is an address together with its balance
+type Account struct {
+    Address []byte
+    Balance *big.Int
+}
+
+// accountHeap is a min-heap of accounts ordered by balance
+type accountHeap []Account
+
+func (h accountHeap) Len() int           { return len(h) }
+func (h accountHeap) Less(i, j int) bool { return h[i].Balance.Cmp(h[j].Balance) < 0 }
+func (h accountHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *accountHeap) Push(x any)        { *h = append(*h, x.(Account)) }
+func (h *accountHeap) Pop() any {
+    old := *h
+    account := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return account
+}
+
+// TopAccounts returns up to limit accounts with the highest balances, in
+// descending balance order, together with the total supply over all accounts
+func (s *DatabaseService) TopAccounts(limit int) ([]Account, *big.Int, error) {
+    return s.TopAccountsContext(context.Background(), limit)
+}
+
+// TopAccountsContext is TopAccounts, stopping with the error of ctx once it is done
+func (s *DatabaseService) TopAccountsContext(ctx context.Context, limit int) ([]Account, *big.Int, error) {
+    total := big.NewInt(0)
+    top := &accountHeap{}
+
+    err := s.ForEachAccountContext(ctx, nil, func(address []byte, balance *big.Int) bool {
+        total.Add(total, balance)
+        if balance.Sign() == 0 {
+            return true
+        }
+        if top.Len() < limit {
+            heap.Push(top, Account{Address: address, Balance: balance})
+        } else if limit > 0 && balance.Cmp((*top)[0].Balance) > 0 {
+            (*top)[0] = Account{Address: address, Balance: balance}
+            heap.Fix(top, 0)
+        }
+        return true
+    })
+    if err != nil {
+        return nil, nil, err
+    }
+
+    accounts := []Account(*top)
+    sort.SliceStable(accounts, func(i, j int) bool {
+        return accounts[i].Balance.Cmp(accounts[j].Balance) > 0
+    })
+    return accounts, total, nil
+}
@@ -0,0 +1,356 @@
+package dbservice
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "sync"
+
+    "golang.org/x/crypto/sha3"
+)
+
+// SetShards splits the state across n tree files by the first byte of the key, so
+// root hashes and flushes are computed shard by shard in parallel. The count is part
+// of the root hash, so every node must use the same one and a database keeps the
+// count it was created with. Call it before any other method.
+func (s *DatabaseService) SetShards(n int) {
+    if n < 1 {
+        n = 1
+    }
+    s.shards = n
+}
+
+// shardName returns the tree name of shard i
+func (s *DatabaseService) shardName(i int) string {
+    return fmt.Sprintf("%s-shard%d", s.name, i)
+}
+
+// TreePaths returns the paths of the tree files holding the state, which is
+// TreePath alone unless the state is sharded
+func (s *DatabaseService) TreePaths() []string {
+    if s.shards == 1 {
+        return []string{s.TreePath()}
+    }
+    paths := make([]string, s.shards)
+    for i := range paths {
+        paths[i] = s.file(s.shardName(i) + ".db")
+    }
+    return paths
+}
+
+// openTree opens the tree file, or the shard files of a sharded state. A database
+// created with a different shard count is refused rather than opened empty.
+func (s *DatabaseService) openTree() (Tree, error) {
+    if s.shards == 1 {
+        if _, err := os.Stat(s.file(s.shardName(0) + ".db")); err == nil {
+            return nil, errors.New("the database is sharded, set shards to its shard count")
+        }
+        tree, err := openFileTree(s.TreePath())
+        if err != nil {
+            return nil, err
+        }
+        return tree, nil
+    }
+    if _, err := os.Stat(s.TreePath()); err == nil {
+        return nil, errors.New("the database is not sharded, set shards to 1")
+    }
+    tree, err := openShardedTree(s.file(s.name+"-flush.json"), s.TreePaths())
+    if err != nil {
+        return nil, err
+    }
+    return tree, nil
+}
+
+// openShardedTree opens the tree files at paths as the shards of a tree with the
+// flush journal at journal, completing an interrupted flush
+func openShardedTree(journal string, paths []string) (*ShardedTree, error) {
+    shards := make([]Tree, len(paths))
+    for i, path := range paths {
+        shard, err := openFileTree(path)
+        if err != nil {
+            for _, opened := range shards[:i] {
+                opened.Close()
+            }
+            return nil, err
+        }
+        shards[i] = shard
+    }
+    tree := NewShardedTree(shards)
+    tree.journal = journal
+    if err := tree.recoverFlush(); err != nil {
+        tree.RevertUnsavedChanges()
+        tree.Close()
+        return nil, err
+    }
+    return tree, nil
+}
+
+// ShardedTree spreads keys over sub-trees by their first byte: shard i of n holds
+// the keys whose first byte is in [256i/n, 256(i+1)/n), so addresses are split by
+// prefix. Its root hash is the Keccak-256 hash of the shard roots in order, an
+// empty shard counting as 32 zero bytes. The roots of the shards are kept between
+// root hashes and only the shards written since are rehashed.
+//
+// The shards are separate files, so a flush first writes the changes of every shard
+// to a journal, then flushes the shards, each recording the flush id, and removes
+// the journal. A crash in between leaves some shards a flush behind; opening the
+// tree writes the journaled changes to them, and refuses shards at different
+// flushes without a journal to complete them.
+type ShardedTree struct {
+    shards []Tree
+    // journal is the path of the flush journal, empty for shards that are not
+    // tree files
+    journal string
+    flushed uint64
+
+    mutex sync.Mutex
+    roots [][]byte
+    dirty []bool
+}
+
+// shardJournal holds the changes of a flush in progress. Shards still at flush From
+// are completed by writing their changes and flushing them as From+1.
+type shardJournal struct {
+    From   uint64           `json:"from"`
+    Writes [][]journalWrite `json:"writes"`
+}
+
+// journalWrite is a key written since the last flush and its value
+type journalWrite struct {
+    Key   []byte `json:"key"`
+    Value []byte `json:"value"`
+}
+
+// NewShardedTree returns a tree over shards
+func NewShardedTree(shards []Tree) *ShardedTree {
+    t := &ShardedTree{shards: shards, roots: make([][]byte, len(shards)), dirty: make([]bool, len(shards))}
+    t.markDirty()
+    return t
+}
+
+// markDirty makes the next root hash rehash every shard
+func (t *ShardedTree) markDirty() {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    for i := range t.dirty {
+        t.dirty[i] = true
+    }
+}
+
+// index returns the shard holding key
+func (t *ShardedTree) index(key []byte) int {
+    if len(key) == 0 {
+        return 0
+    }
+    return int(key[0]) * len(t.shards) / 256
+}
+
+// shard returns the sub-tree holding key
+func (t *ShardedTree) shard(key []byte) Tree {
+    return t.shards[t.index(key)]
+}
+
+// each runs fn on every shard concurrently and returns their errors joined
+func (t *ShardedTree) each(fn func(i int, shard Tree) error) error {
+    errs := make([]error, len(t.shards))
+    var wg sync.WaitGroup
+    for i, shard := range t.shards {
+        wg.Add(1)
+        go func(i int, shard Tree) {
+            defer wg.Done()
+            errs[i] = fn(i, shard)
+        }(i, shard)
+    }
+    wg.Wait()
+    return errors.Join(errs...)
+}
+
+// GetRootHash combines the root hashes of the shards, computing those of the shards
+// written since the last call in parallel. It is nil while every shard is empty.
+func (t *ShardedTree) GetRootHash() ([]byte, error) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+    err := t.each(func(i int, shard Tree) error {
+        if !t.dirty[i] {
+            return nil
+        }
+        root, err := shard.GetRootHash()
+        if err != nil {
+            return err
+        }
+        t.roots[i], t.dirty[i] = root, false
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    hasher := sha3.NewLegacyKeccak256()
+    empty := true
+    for _, root := range t.roots {
+        if root == nil {
+            root = make([]byte, 32)
+        } else {
+            empty = false
+        }
+        hasher.Write(root)
+    }
+    if empty {
+        return nil, nil
+    }
+    return hasher.Sum(nil), nil
+}
+
+func (t *ShardedTree) GetData(key []byte) ([]byte, error) {
+    return t.shard(key).GetData(key)
+}
+
+func (t *ShardedTree) AddOrUpdateData(key, data []byte) error {
+    i := t.index(key)
+    t.mutex.Lock()
+    t.dirty[i] = true
+    t.mutex.Unlock()
+    return t.shards[i].AddOrUpdateData(key, data)
+}
+
+// files returns the shards as tree files, false when some are not
+func (t *ShardedTree) files() ([]*FileTree, bool) {
+    files := make([]*FileTree, len(t.shards))
+    for i, shard := range t.shards {
+        file, ok := shard.(*FileTree)
+        if !ok {
+            return nil, false
+        }
+        files[i] = file
+    }
+    return files, true
+}
+
+// FlushToDisk writes the changes since the last flush to every shard. The changes
+// are journaled first, so a crash while the shards are flushed is completed when
+// the tree is opened again; a failed flush leaves the journal for the same reason.
+func (t *ShardedTree) FlushToDisk() error {
+    files, ok := t.files()
+    if !ok || t.journal == "" {
+        return t.each(func(_ int, shard Tree) error { return shard.FlushToDisk() })
+    }
+
+    journal := shardJournal{From: t.flushed, Writes: make([][]journalWrite, len(files))}
+    changed := false
+    for i, file := range files {
+        journal.Writes[i] = file.pending()
+        changed = changed || len(journal.Writes[i]) > 0
+    }
+    if !changed {
+        return nil
+    }
+    if err := writeJournal(t.journal, journal); err != nil {
+        return fmt.Errorf("failed to journal the flush: %v", err)
+    }
+    next := t.flushed + 1
+    if err := t.each(func(i int, _ Tree) error { return files[i].flushAs(next) }); err != nil {
+        return err
+    }
+    t.flushed = next
+    return os.Remove(t.journal)
+}
+
+// writeJournal replaces the journal file and syncs it
+func writeJournal(path string, journal shardJournal) error {
+    data, err := json.Marshal(journal)
+    if err != nil {
+        return err
+    }
+    tmp := path + ".tmp"
+    file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err := file.Write(data); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Sync(); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// recoverFlush completes the flush of a journal left by a crash and checks that
+// every shard was stored by the same flush. A journal the shards do not continue,
+// such as one left before a snapshot restore, is dropped.
+func (t *ShardedTree) recoverFlush() error {
+    files, ok := t.files()
+    if !ok {
+        return nil
+    }
+    data, err := os.ReadFile(t.journal)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    if err == nil {
+        var journal shardJournal
+        if err := json.Unmarshal(data, &journal); err != nil {
+            return fmt.Errorf("%s: %v", t.journal, err)
+        }
+        if t.continues(files, journal) {
+            for i, file := range files {
+                if file.flushID != journal.From {
+                    continue
+                }
+                for _, write := range journal.Writes[i] {
+                    if err := file.AddOrUpdateData(write.Key, write.Value); err != nil {
+                        return err
+                    }
+                }
+                if err := file.flushAs(journal.From + 1); err != nil {
+                    return fmt.Errorf("failed to complete the flush of shard %d: %v", i, err)
+                }
+            }
+            logger.Warn("completed an interrupted flush of the shards", "flush", journal.From+1)
+        }
+        if err := os.Remove(t.journal); err != nil {
+            return err
+        }
+    }
+
+    for i, file := range files {
+        if file.flushID != files[0].flushID {
+            return fmt.Errorf("shard %d was stored by flush %d and shard 0 by flush %d, restore a snapshot", i, file.flushID, files[0].flushID)
+        }
+    }
+    t.flushed = files[0].flushID
+    return nil
+}
+
+// continues reports whether every shard is at the flush a journal starts from or
+// the one it completes
+func (t *ShardedTree) continues(files []*FileTree, journal shardJournal) bool {
+    if len(journal.Writes) != len(files) {
+        return false
+    }
+    for _, file := range files {
+        if file.flushID != journal.From && file.flushID != journal.From+1 {
+            return false
+        }
+    }
+    return true
+}
+
+// RevertUnsavedChanges drops the changes since the last flush from every shard.
+// Nothing is written, so it leaves the files as they are.
+func (t *ShardedTree) RevertUnsavedChanges() error {
+    t.markDirty()
+    return t.each(func(_ int, shard Tree) error { return shard.RevertUnsavedChanges() })
+}
+
+// Close flushes the changes since the last flush, journaled as FlushToDisk does,
+// and closes the shards
+func (t *ShardedTree) Close() error {
+    flushErr := t.FlushToDisk()
+    return errors.Join(flushErr, t.each(func(_ int, shard Tree) error { return shard.Close() }))
+}
@@ -0,0 +1,137 @@
+package dbservice
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+var (
+    testJournal    = filepath.Join("merkleTree", "test-flush.json")
+    testShardPaths = []string{
+        filepath.Join("merkleTree", "test-shard0.db"),
+        filepath.Join("merkleTree", "test-shard1.db"),
+        filepath.Join("merkleTree", "test-shard2.db"),
+    }
+)
+
+// openTestShards opens a tree of three shards in a fresh directory, the first write
+// of keys 0 to 29 flushed and keys 20 to 39 written after it. It returns the root
+// hashes of the flushed writes and of all of them.
+func openTestShards(t *testing.T) (tree *ShardedTree, flushedRoot, root []byte) {
+    t.Helper()
+    wd, err := os.Getwd()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Chdir(t.TempDir()); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { os.Chdir(wd) })
+
+    tree, err = openShardedTree(testJournal, testShardPaths)
+    if err != nil {
+        t.Fatal(err)
+    }
+    write := func(from, to int, value string) {
+        for i := from; i < to; i++ {
+            key := []byte{byte(i * 9), byte(i)}
+            if err := tree.AddOrUpdateData(key, []byte(fmt.Sprintf("%s%d", value, i))); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+    write(0, 30, "first")
+    if flushedRoot, err = tree.GetRootHash(); err != nil {
+        t.Fatal(err)
+    }
+    if err := tree.FlushToDisk(); err != nil {
+        t.Fatal(err)
+    }
+    write(20, 40, "second")
+    if root, err = tree.GetRootHash(); err != nil {
+        t.Fatal(err)
+    }
+    return tree, flushedRoot, root
+}
+
+// crash closes the shards without flushing what they hold
+func crash(t *testing.T, files []*FileTree) {
+    t.Helper()
+    for _, file := range files {
+        file.RevertUnsavedChanges()
+        if err := file.Close(); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestShardedTreeInterruptedFlush(t *testing.T) {
+    tests := []struct {
+        name string
+        // flushed are the shards flushed before the crash
+        flushed []int
+        // from is the flush the journal starts from, relative to the current one
+        from uint64
+        // dropped is set when the journal is not for the shards, which stay at the
+        // last flush
+        dropped bool
+    }{
+        {name: "before any shard"},
+        {name: "after one shard", flushed: []int{0}},
+        {name: "after two shards", flushed: []int{2, 1}},
+        {name: "after every shard", flushed: []int{0, 1, 2}},
+        {name: "journal left before a restore", from: 5, dropped: true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            tree, flushedRoot, root := openTestShards(t)
+            files, _ := tree.files()
+            journal := shardJournal{From: tree.flushed + test.from, Writes: make([][]journalWrite, len(files))}
+            for i, file := range files {
+                journal.Writes[i] = file.pending()
+            }
+            if err := writeJournal(testJournal, journal); err != nil {
+                t.Fatal(err)
+            }
+            for _, i := range test.flushed {
+                if err := files[i].flushAs(tree.flushed + 1); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            crash(t, files)
+
+            reopened, err := openShardedTree(testJournal, testShardPaths)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer reopened.Close()
+            want := root
+            if test.dropped {
+                want = flushedRoot
+            }
+            if got, _ := reopened.GetRootHash(); !bytes.Equal(got, want) {
+                t.Errorf("root hash after reopening = %x, want %x", got, want)
+            }
+            if _, err := os.Stat(testJournal); !os.IsNotExist(err) {
+                t.Errorf("journal still present: %v", err)
+            }
+        })
+    }
+}
+
+func TestShardedTreeRefusesShardsAtDifferentFlushes(t *testing.T) {
+    tree, _, _ := openTestShards(t)
+    files, _ := tree.files()
+    if err := files[1].flushAs(tree.flushed + 1); err != nil {
+        t.Fatal(err)
+    }
+    crash(t, files)
+
+    if reopened, err := openShardedTree(testJournal, testShardPaths); err == nil {
+        reopened.Close()
+        t.Fatal("opened shards stored by different flushes")
+    }
+}
@@ -0,0 +1,24 @@
+package dbservice
+
+import (
+    "context"
+    "math/big"
+)
+
+// TotalBalance returns the sum of the balances of all accounts
+func (s *DatabaseService) TotalBalance() (*big.Int, error) {
+    return s.TotalBalanceContext(context.Background())
+}
+
+// TotalBalanceContext is TotalBalance, stopping with the error of ctx once it is done
+func (s *DatabaseService) TotalBalanceContext(ctx context.Context) (*big.Int, error) {
+    total := big.NewInt(0)
+    err := s.ForEachAccountContext(ctx, nil, func(address []byte, balance *big.Int) bool {
+        total.Add(total, balance)
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    return total, nil
+}
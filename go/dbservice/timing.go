@@ -0,0 +1,65 @@
+package dbservice
+
+import (
+    "sync/atomic"
+    "time"
+
+    "pwr-stateful-vida/metrics"
+)
+
+// slowThreshold is the duration in nanoseconds above which a tree operation is logged, 0 disables it
+var slowThreshold atomic.Int64
+
+// SetSlowOperationThreshold logs and counts every tree operation that takes longer
+// than threshold. Zero disables slow operation logging.
+func SetSlowOperationThreshold(threshold time.Duration) {
+    slowThreshold.Store(int64(threshold))
+}
+
+// timedTree measures the operations of the tree it wraps
+type timedTree struct {
+    Tree
+}
+
+// observe records the duration of an operation that started at start. Key is nil
+// for operations on the whole tree.
+func observe(operation string, key []byte, start time.Time) {
+    elapsed := time.Since(start)
+    metrics.TreeOperationDuration.Observe(elapsed.Seconds(), operation)
+
+    threshold := time.Duration(slowThreshold.Load())
+    if threshold <= 0 || elapsed < threshold {
+        return
+    }
+    namespace := "tree"
+    if key != nil {
+        namespace = KeyNamespace(key)
+    }
+    metrics.SlowTreeOperations.Inc(operation, namespace)
+    logger.Warn("slow tree operation", "operation", operation, "namespace", namespace, "duration", elapsed, "threshold", threshold)
+}
+
+func (t timedTree) GetRootHash() ([]byte, error) {
+    defer observe("root", nil, time.Now())
+    return t.Tree.GetRootHash()
+}
+
+func (t timedTree) GetData(key []byte) ([]byte, error) {
+    defer observe("read", key, time.Now())
+    return t.Tree.GetData(key)
+}
+
+func (t timedTree) AddOrUpdateData(key, data []byte) error {
+    defer observe("write", key, time.Now())
+    return t.Tree.AddOrUpdateData(key, data)
+}
+
+func (t timedTree) FlushToDisk() error {
+    defer observe("flush", nil, time.Now())
+    return t.Tree.FlushToDisk()
+}
+
+func (t timedTree) RevertUnsavedChanges() error {
+    defer observe("revert", nil, time.Now())
+    return t.Tree.RevertUnsavedChanges()
+}
@@ -0,0 +1,103 @@
+// Package diagnostics serves pprof profiles and runtime statistics for live
+// performance investigation. It is meant for a separate port that is not exposed
+// publicly.
+package diagnostics
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "net/http"
+    "net/http/pprof"
+    "runtime"
+    "time"
+)
+
+// started is used to report the process uptime
+var started = time.Now()
+
+// RuntimeStats is the response body of /debug/runtime
+type RuntimeStats struct {
+    Uptime       string `json:"uptime"`
+    GoVersion    string `json:"goVersion"`
+    NumCPU       int    `json:"numCpu"`
+    GOMAXPROCS   int    `json:"gomaxprocs"`
+    Goroutines   int    `json:"goroutines"`
+    HeapAlloc    uint64 `json:"heapAlloc"`
+    HeapInuse    uint64 `json:"heapInuse"`
+    HeapIdle     uint64 `json:"heapIdle"`
+    HeapObjects  uint64 `json:"heapObjects"`
+    Sys          uint64 `json:"sys"`
+    TotalAlloc   uint64 `json:"totalAlloc"`
+    NumGC        uint32 `json:"numGc"`
+    NextGC       uint64 `json:"nextGc"`
+    LastGC       string `json:"lastGc,omitempty"`
+    LastGCPause  string `json:"lastGcPause"`
+    TotalGCPause string `json:"totalGcPause"`
+}
+
+// ReadRuntimeStats collects the current runtime statistics. It stops the world
+// briefly to read memory statistics.
+func ReadRuntimeStats() RuntimeStats {
+    var memory runtime.MemStats
+    runtime.ReadMemStats(&memory)
+
+    stats := RuntimeStats{
+        Uptime:       time.Since(started).Round(time.Second).String(),
+        GoVersion:    runtime.Version(),
+        NumCPU:       runtime.NumCPU(),
+        GOMAXPROCS:   runtime.GOMAXPROCS(0),
+        Goroutines:   runtime.NumGoroutine(),
+        HeapAlloc:    memory.HeapAlloc,
+        HeapInuse:    memory.HeapInuse,
+        HeapIdle:     memory.HeapIdle,
+        HeapObjects:  memory.HeapObjects,
+        Sys:          memory.Sys,
+        TotalAlloc:   memory.TotalAlloc,
+        NumGC:        memory.NumGC,
+        NextGC:       memory.NextGC,
+        LastGCPause:  time.Duration(memory.PauseNs[(memory.NumGC+255)%256]).String(),
+        TotalGCPause: time.Duration(memory.PauseTotalNs).String(),
+    }
+    if memory.LastGC > 0 {
+        stats.LastGC = time.Unix(0, int64(memory.LastGC)).UTC().Format(time.RFC3339)
+    }
+    return stats
+}
+
+// Handler returns the diagnostics endpoints. When password is not empty every
+// request must carry matching HTTP basic credentials.
+func Handler(username, password string) http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json; charset=utf-8")
+        json.NewEncoder(w).Encode(ReadRuntimeStats())
+    })
+    mux.HandleFunc("/debug/gc", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "use POST to force a garbage collection", http.StatusMethodNotAllowed)
+            return
+        }
+        runtime.GC()
+        w.WriteHeader(http.StatusNoContent)
+    })
+
+    if password == "" {
+        return mux
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        user, pass, ok := r.BasicAuth()
+        userMatches := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+        passMatches := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+        if !ok || !userMatches || !passMatches {
+            w.Header().Set("WWW-Authenticate", `Basic realm="diagnostics"`)
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        mux.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "fmt"
+    "sync/atomic"
+    "time"
+
+    "pwr-stateful-vida/alert"
+    "pwr-stateful-vida/health"
+)
+
+// readOnly is set while the database volume is low on space. Transactions are not
+// applied and checkpoints do not advance, so the database file is not written.
+var readOnly atomic.Bool
+
+// watchDiskSpace switches the node to read-only serving while the database volume
+// has less than threshold bytes free, and back once twice that is free
+func (a *App) watchDiskSpace(threshold int64, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for ; ; <-ticker.C {
+        free, err := health.FreeSpace(health.DataDir())
+        if err != nil {
+            logger.Warn("failed to check free disk space, disabling the read-only fallback", "error", err)
+            return
+        }
+        switch {
+        case free < threshold && !readOnly.Load():
+            a.enterReadOnly(free, threshold)
+        case free >= threshold*2 && readOnly.Load():
+            a.leaveReadOnly(free)
+        }
+    }
+}
+
+// enterReadOnly stops syncing and raises an alert
+func (a *App) enterReadOnly(free, threshold int64) {
+    readOnly.Store(true)
+    health.SetReadOnly(true)
+    lastBlock, _ := a.db.GetLastCheckedBlock()
+    logger.Error("database volume is almost full, serving read-only", "freeMB", free>>20, "thresholdMB", threshold>>20, "block", lastBlock)
+    alerts.Raise(alert.Alert{
+        Kind:     alert.KindDiskSpace,
+        Severity: alert.Critical,
+        Message:  fmt.Sprintf("only %d MB free on the database volume, sync stopped at block %d and the node is read-only", free>>20, lastBlock),
+        Block:    lastBlock,
+    })
+    if a.subscription != nil {
+        a.subscription.Pause()
+    }
+}
+
+// leaveReadOnly resumes syncing from the last checkpoint
+func (a *App) leaveReadOnly(free int64) {
+    logger.Info("free disk space recovered, resuming sync", "freeMB", free>>20)
+    readOnly.Store(false)
+    health.SetReadOnly(a.syncHalted())
+    if a.subscription != nil && !a.adminPaused.Load() && !a.syncHalted() {
+        a.subscription.Resume()
+    }
+}
+
+// discardReadOnlyBatch drops a batch that was in flight when the node became
+// read-only, so it is processed again after syncing resumes
+func (a *App) discardReadOnlyBatch(blockNumber int) {
+    syncLogger.Warn("node is read-only, discarding batch", "block", blockNumber)
+    a.syncNode().Discard()
+}
+
+// startDiskGuard starts the free space watchdog when a threshold is configured
+func (a *App) startDiskGuard() {
+    cfg := a.config().Disk
+    if cfg.ReadOnlyBelowMB <= 0 {
+        return
+    }
+    interval, err := time.ParseDuration(cfg.CheckInterval)
+    if err != nil || interval <= 0 {
+        interval = 30 * time.Second
+    }
+    go a.watchDiskSpace(int64(cfg.ReadOnlyBelowMB)<<20, interval)
+}
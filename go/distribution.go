@@ -0,0 +1,186 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strings"
+
+    "pwr-stateful-vida/distribution"
+    "pwr-stateful-vida/governance"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons a snapshot or distribution is rejected
+const (
+    failureSnapshotExists   = "snapshot_exists"
+    failureSnapshotNotFound = "snapshot_not_found"
+    failureSnapshotPending  = "snapshot_pending"
+    failureDividendNotFound = "dividend_not_found"
+    failureNothingToClaim   = "nothing_to_claim"
+)
+
+// distributionFailure maps an error of the distribution package to the reason a
+// transaction is rejected
+func distributionFailure(ctx context.Context, err error, action string, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, distribution.ErrInvalid), errors.Is(err, distribution.ErrNoHolders):
+        syncLogger.WarnContext(ctx, "skipping invalid "+action, "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, distribution.ErrExists):
+        return failureSnapshotExists
+    case errors.Is(err, distribution.ErrNotFound):
+        return failureSnapshotNotFound
+    case errors.Is(err, distribution.ErrPending):
+        return failureSnapshotPending
+    case errors.Is(err, distribution.ErrDividendNotFound):
+        return failureDividendNotFound
+    case errors.Is(err, distribution.ErrClaimed):
+        return failureAlreadyClaimed
+    case errors.Is(err, distribution.ErrNothingToClaim):
+        return failureNothingToClaim
+    case errors.Is(err, distribution.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, action+" failed: insufficient funds", "payload", payload, "sender", senderHex)
+        return failureInsufficientFunds
+    }
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": senderHex},
+    })
+    return failureInvalidPayload
+}
+
+// snapshotPayload is the payload of a snapshot
+type snapshotPayload struct {
+    envelope
+    Name  string `json:"name"`
+    Block number `json:"block"`
+    // Exclude is the addresses left out of the snapshot
+    Exclude []string `json:"exclude"`
+
+    block   int64
+    exclude [][]byte
+}
+
+func (p *snapshotPayload) validate(a *App) error {
+    if strings.HasPrefix(p.Name, governance.SnapshotPrefix) {
+        return invalidField("name", "reserved for governance")
+    }
+    if p.Block.set() {
+        block, ok := p.Block.integer()
+        if !ok || !block.IsInt64() {
+            return invalidField("block", "must be a block number")
+        }
+        p.block = block.Int64()
+    }
+    for _, raw := range p.Exclude {
+        address := payloadAddress(raw)
+        if address == nil {
+            return invalidField("exclude", "must be addresses")
+        }
+        p.exclude = append(p.exclude, address)
+    }
+    return nil
+}
+
+// handleSnapshot schedules a named snapshot of the balances before "block", leaving
+// out the "exclude" addresses. It returns the reason the snapshot was rejected, or
+// an empty string on success.
+func (a *App) handleSnapshot(ctx context.Context, p *snapshotPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if err := distribution.Schedule(a.db, p.Name, p.block, int64(transaction.BlockNumber), sender, p.exclude); err != nil {
+        return distributionFailure(ctx, err, "snapshot", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "snapshot scheduled", "name", p.Name, "block", p.block, "sender", senderHex)
+    return ""
+}
+
+// distributionPayload is the payload of a distribute or a dividend
+type distributionPayload struct {
+    envelope
+    Snapshot string `json:"snapshot"`
+    Token    string `json:"token"`
+    Amount   number `json:"amount"`
+}
+
+// handleDistribute splits "amount" of "token" (the native token by default) from
+// the sender across the holders of the "snapshot". It returns the reason the
+// distribution was rejected, or an empty string on success.
+func (a *App) handleDistribute(ctx context.Context, p *distributionPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    amount := a.parseTokenAmount(p.Amount, p.Token)
+    if sender == nil || amount == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid distribute", "payload", p)
+        return failureInvalidAmount
+    }
+
+    if err := distribution.Distribute(a.db, sender, p.Snapshot, p.Token, amount); err != nil {
+        return distributionFailure(ctx, err, "distribute", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "distribution applied", "snapshot", p.Snapshot, "token", p.Token, "amount", amount, "sender", senderHex)
+    return ""
+}
+
+// handleDividend locks "amount" of "token" (the native token by default) from the
+// sender for the holders of the "snapshot" to claim. It returns the reason the
+// dividend was rejected, or an empty string on success.
+func (a *App) handleDividend(ctx context.Context, p *distributionPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    amount := a.parseTokenAmount(p.Amount, p.Token)
+    if amount == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid dividend", "payload", p)
+        return failureInvalidAmount
+    }
+    id, err := distribution.DeclareDividend(a.db, sender, p.Snapshot, p.Token, amount, int64(transaction.BlockNumber))
+    if err != nil {
+        return distributionFailure(ctx, err, "dividend", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "dividend declared", "dividend", id, "snapshot", p.Snapshot, "token", p.Token, "amount", amount, "sender", senderHex)
+    return ""
+}
+
+// claimDividendPayload is the payload of a claimDividend
+type claimDividendPayload struct {
+    envelope
+    Dividend number `json:"dividend"`
+
+    dividend uint64
+}
+
+func (p *claimDividendPayload) validate(a *App) error {
+    id := parsePositiveAmount(p.Dividend)
+    if id == nil || !id.IsUint64() {
+        return invalidField("dividend", "must be a dividend ID")
+    }
+    p.dividend = id.Uint64()
+    return nil
+}
+
+// handleClaimDividend pays the sender its share of the "dividend". It returns the
+// reason the claim was rejected, or an empty string on success.
+func (a *App) handleClaimDividend(ctx context.Context, p *claimDividendPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    paid, err := distribution.ClaimDividend(a.db, sender, p.dividend)
+    if err != nil {
+        return distributionFailure(ctx, err, "claimDividend", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "dividend claimed", "dividend", p.dividend, "amount", paid, "sender", senderHex)
+    return ""
+}
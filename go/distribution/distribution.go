@@ -0,0 +1,269 @@
+// Package distribution splits amounts across the holders of a balance snapshot.
+// The tree only keeps current balances, so a snapshot is scheduled for a future
+// block and recorded in the Merkle state before the first transaction applied at
+// or after it, which every node does at the same point of the transaction stream.
+// Amounts are either split at once or declared as dividends that each holder claims
+// on its own, so paying many holders never has to fit in one transaction.
+package distribution
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "regexp"
+    "sort"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// Errors of rejected snapshots and distributions
+var (
+    ErrInvalid           = errors.New("invalid distribution")
+    ErrExists            = errors.New("snapshot already exists")
+    ErrNotFound          = errors.New("snapshot not found")
+    ErrPending           = errors.New("snapshot not taken yet")
+    ErrNoHolders         = errors.New("snapshot has no holders")
+    ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// MaxExcluded is the most addresses a snapshot can leave out
+const MaxExcluded = 100
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+var pendingKey = []byte(dbservice.DistributionPrefix + "pending")
+
+// Holder is an address and its balance in a snapshot
+type Holder struct {
+    Address string `json:"address"`
+    Balance string `json:"balance"`
+}
+
+// Snapshot is a named record of the native balances before a block. Excluded
+// addresses, such as module accounts, are left out.
+type Snapshot struct {
+    Name    string   `json:"name"`
+    Block   int64    `json:"block"`
+    Creator string   `json:"creator"`
+    Exclude []string `json:"exclude,omitempty"`
+    Taken   bool     `json:"taken"`
+    Total   string   `json:"total,omitempty"`
+    Holders []Holder `json:"holders,omitempty"`
+}
+
+// scheduled is an entry of the queue of snapshots not taken yet
+type scheduled struct {
+    Block int64  `json:"block"`
+    Name  string `json:"name"`
+}
+
+// snapshotKey returns the tree key of a snapshot
+func snapshotKey(name string) []byte {
+    return []byte(dbservice.DistributionPrefix + "snapshot/" + name)
+}
+
+// ValidName reports whether name can name a snapshot
+func ValidName(name string) bool {
+    return namePattern.MatchString(name)
+}
+
+// Lookup returns a snapshot, and false when there is none of that name
+func Lookup(db *dbservice.DatabaseService, name string) (Snapshot, bool, error) {
+    var snapshot Snapshot
+    if !ValidName(name) {
+        return snapshot, false, nil
+    }
+    found, err := db.LoadJSON(snapshotKey(name), &snapshot)
+    return snapshot, found, err
+}
+
+// store writes a snapshot
+func store(db *dbservice.DatabaseService, snapshot Snapshot) error {
+    return db.SaveJSON(snapshotKey(snapshot.Name), snapshot)
+}
+
+// pending returns the snapshots not taken yet, ordered by block and name
+func pending(db *dbservice.DatabaseService) ([]scheduled, error) {
+    var queue []scheduled
+    _, err := db.LoadJSON(pendingKey, &queue)
+    return queue, err
+}
+
+// storePending writes the queue of snapshots not taken yet
+func storePending(db *dbservice.DatabaseService, queue []scheduled) error {
+    return db.SaveJSON(pendingKey, queue)
+}
+
+// Schedule registers a snapshot of the balances before block, which must be after
+// the current block
+func Schedule(db *dbservice.DatabaseService, name string, block, current int64, creator []byte, exclude [][]byte) error {
+    if !ValidName(name) {
+        return fmt.Errorf("%w: the name must be 1 to 64 letters, digits, '.', '_' or '-'", ErrInvalid)
+    }
+    if block <= current {
+        return fmt.Errorf("%w: the snapshot block must be after block %d", ErrInvalid, current)
+    }
+    if len(exclude) > MaxExcluded {
+        return fmt.Errorf("%w: at most %d addresses can be excluded", ErrInvalid, MaxExcluded)
+    }
+    if _, found, err := Lookup(db, name); err != nil || found {
+        if found {
+            return ErrExists
+        }
+        return err
+    }
+
+    snapshot := Snapshot{Name: name, Block: block, Creator: hex.EncodeToString(creator)}
+    for _, address := range exclude {
+        snapshot.Exclude = append(snapshot.Exclude, hex.EncodeToString(address))
+    }
+    sort.Strings(snapshot.Exclude)
+    if err := store(db, snapshot); err != nil {
+        return err
+    }
+
+    queue, err := pending(db)
+    if err != nil {
+        return err
+    }
+    entry := scheduled{Block: block, Name: name}
+    i := sort.Search(len(queue), func(i int) bool {
+        return queue[i].Block > block || (queue[i].Block == block && queue[i].Name > name)
+    })
+    queue = append(queue, scheduled{})
+    copy(queue[i+1:], queue[i:])
+    queue[i] = entry
+    return storePending(db, queue)
+}
+
+// BeginBlock takes the snapshots scheduled at or before block. It runs before the
+// first transaction applied in block, so they hold the balances after the last
+// transaction before their block.
+func BeginBlock(db *dbservice.DatabaseService, block int64) error {
+    queue, err := pending(db)
+    if err != nil {
+        return err
+    }
+    taken := 0
+    for taken < len(queue) && queue[taken].Block <= block {
+        if err := take(db, queue[taken].Name); err != nil {
+            return err
+        }
+        taken++
+    }
+    if taken == 0 {
+        return nil
+    }
+    return storePending(db, queue[taken:])
+}
+
+// take records the current native balances of every account into a snapshot
+func take(db *dbservice.DatabaseService, name string) error {
+    snapshot, found, err := Lookup(db, name)
+    if err != nil || !found {
+        return err
+    }
+    excluded := make(map[string]bool)
+    for _, address := range snapshot.Exclude {
+        excluded[address] = true
+    }
+
+    total := new(big.Int)
+    snapshot.Holders = []Holder{}
+    err = db.ForEachHolder(func(address []byte, balance *big.Int) bool {
+        addressHex := hex.EncodeToString(address)
+        if balance.Sign() > 0 && !excluded[addressHex] {
+            snapshot.Holders = append(snapshot.Holders, Holder{Address: addressHex, Balance: balance.String()})
+            total.Add(total, balance)
+        }
+        return true
+    })
+    if err != nil {
+        return err
+    }
+    snapshot.Taken, snapshot.Total = true, total.String()
+    return store(db, snapshot)
+}
+
+// Share is the amount a holder receives from a distribution
+type Share struct {
+    Address []byte
+    Amount  *big.Int
+}
+
+// Shares splits amount across the holders of a snapshot in proportion to their
+// balances. Each holder gets its share rounded down, and the units left over go one
+// each to the holders with the largest remainders, ties going to the lower address,
+// so exactly amount is split.
+func Shares(snapshot Snapshot, amount *big.Int) []Share {
+    total := dbservice.ParseAmount(snapshot.Total)
+    if total.Sign() <= 0 {
+        return nil
+    }
+
+    shares := make([]Share, len(snapshot.Holders))
+    remainders := make([]*big.Int, len(snapshot.Holders))
+    left := new(big.Int).Set(amount)
+    for i, holder := range snapshot.Holders {
+        balance := dbservice.ParseAmount(holder.Balance)
+        address, _ := hex.DecodeString(holder.Address)
+        quotient, remainder := new(big.Int).QuoRem(new(big.Int).Mul(amount, balance), total, new(big.Int))
+        shares[i] = Share{Address: address, Amount: quotient}
+        remainders[i] = remainder
+        left.Sub(left, quotient)
+    }
+
+    // Holders are sorted by address, so a stable sort breaks ties by address
+    order := make([]int, len(shares))
+    for i := range order {
+        order[i] = i
+    }
+    sort.SliceStable(order, func(x, y int) bool {
+        return remainders[order[x]].Cmp(remainders[order[y]]) > 0
+    })
+    for _, i := range order {
+        if left.Sign() == 0 {
+            break
+        }
+        shares[i].Amount.Add(shares[i].Amount, big.NewInt(1))
+        left.Sub(left, big.NewInt(1))
+    }
+    return shares
+}
+
+// Distribute splits amount of token from sender across the holders of a snapshot
+func Distribute(db *dbservice.DatabaseService, sender []byte, name, token string, amount *big.Int) error {
+    snapshot, found, err := Lookup(db, name)
+    if err != nil {
+        return err
+    }
+    if !found {
+        return ErrNotFound
+    }
+    if !snapshot.Taken {
+        return ErrPending
+    }
+    shares := Shares(snapshot, amount)
+    if len(shares) == 0 {
+        return ErrNoHolders
+    }
+
+    balance, err := tokens.Balance(db, token, sender)
+    if err != nil {
+        return err
+    }
+    if balance.Cmp(amount) < 0 {
+        return ErrInsufficientFunds
+    }
+    for _, share := range shares {
+        if share.Amount.Sign() == 0 {
+            continue
+        }
+        if _, err := tokens.Transfer(db, token, sender, share.Address, share.Amount); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,195 @@
+package distribution
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "sort"
+    "strconv"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// Errors of rejected dividend actions
+var (
+    ErrDividendNotFound = errors.New("dividend not found")
+    ErrClaimed          = errors.New("dividend already claimed")
+    ErrNothingToClaim   = errors.New("address held nothing at the snapshot")
+)
+
+// DividendAddress is the account holding the unclaimed part of dividends
+var DividendAddress = dbservice.ModuleAddress("dividend")
+
+// Precision scales the payout per unit of a dividend
+var Precision = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+var dividendCountKey = []byte(dbservice.DistributionPrefix + "dividends")
+
+// Dividend is a payout to the holders of a snapshot. Each holder claims its
+// balance times PerUnit divided by Precision, so the claims never add up to more
+// than Amount.
+type Dividend struct {
+    ID       uint64 `json:"id"`
+    Snapshot string `json:"snapshot"`
+    Creator  string `json:"creator"`
+    Token    string `json:"token"`
+    Amount   string `json:"amount"`
+    PerUnit  string `json:"perUnit"`
+    Claimed  string `json:"claimed"`
+    Block    int64  `json:"block"`
+}
+
+// dividendKey returns the tree key of a dividend
+func dividendKey(id uint64) []byte {
+    return []byte(fmt.Sprintf("%sdividend/%020d", dbservice.DistributionPrefix, id))
+}
+
+// claimKey returns the tree key recording that holder claimed a dividend
+func claimKey(id uint64, holder []byte) []byte {
+    return []byte(fmt.Sprintf("%sclaimed/%020d/%s", dbservice.DistributionPrefix, id, hex.EncodeToString(holder)))
+}
+
+// storeDividend writes a dividend
+func storeDividend(db *dbservice.DatabaseService, dividend Dividend) error {
+    return db.SaveJSON(dividendKey(dividend.ID), dividend)
+}
+
+// LookupDividend returns a dividend, and false when there is none with that ID
+func LookupDividend(db *dbservice.DatabaseService, id uint64) (Dividend, bool, error) {
+    var dividend Dividend
+    found, err := db.LoadJSON(dividendKey(id), &dividend)
+    return dividend, found, err
+}
+
+// DividendCount returns the number of dividends declared
+func DividendCount(db *dbservice.DatabaseService) (uint64, error) {
+    data, err := db.GetData(dividendCountKey)
+    if err != nil || len(data) == 0 {
+        return 0, err
+    }
+    return strconv.ParseUint(string(data), 10, 64)
+}
+
+// HolderBalance returns the balance of address in a taken snapshot, zero when it
+// held nothing
+func HolderBalance(snapshot Snapshot, address []byte) *big.Int {
+    addressHex := hex.EncodeToString(address)
+    i := sort.Search(len(snapshot.Holders), func(i int) bool {
+        return snapshot.Holders[i].Address >= addressHex
+    })
+    if i == len(snapshot.Holders) || snapshot.Holders[i].Address != addressHex {
+        return new(big.Int)
+    }
+    balance := dbservice.ParseAmount(snapshot.Holders[i].Balance)
+    return balance
+}
+
+// Payout returns what a holder of balance at the snapshot is paid by a dividend
+func (d Dividend) Payout(balance *big.Int) *big.Int {
+    perUnit := dbservice.ParseAmount(d.PerUnit)
+    payout := new(big.Int).Mul(balance, perUnit)
+    return payout.Quo(payout, Precision)
+}
+
+// Claimed reports whether holder claimed a dividend
+func Claimed(db *dbservice.DatabaseService, id uint64, holder []byte) (bool, error) {
+    data, err := db.GetData(claimKey(id, holder))
+    return len(data) > 0, err
+}
+
+// DeclareDividend locks amount of token from sender as a dividend to the holders
+// of a taken snapshot at block, and returns its ID. Nothing is paid until holders
+// claim, so declaring costs the same however many holders there are.
+func DeclareDividend(db *dbservice.DatabaseService, sender []byte, name, token string, amount *big.Int, block int64) (uint64, error) {
+    snapshot, found, err := Lookup(db, name)
+    if err != nil {
+        return 0, err
+    }
+    if !found {
+        return 0, ErrNotFound
+    }
+    if !snapshot.Taken {
+        return 0, ErrPending
+    }
+    total := dbservice.ParseAmount(snapshot.Total)
+    if total.Sign() <= 0 {
+        return 0, ErrNoHolders
+    }
+    perUnit := new(big.Int).Mul(amount, Precision)
+    perUnit.Quo(perUnit, total)
+    if perUnit.Sign() == 0 {
+        return 0, fmt.Errorf("%w: the amount is too small to pay anything per unit", ErrInvalid)
+    }
+    if tokens.IsNative(token) {
+        token = tokens.Native
+    } else if _, found, err := tokens.Lookup(db, token); err != nil || !found {
+        if err != nil {
+            return 0, err
+        }
+        return 0, fmt.Errorf("%w: token %q is not registered", ErrInvalid, token)
+    }
+    count, err := DividendCount(db)
+    if err != nil {
+        return 0, err
+    }
+    ok, err := tokens.Transfer(db, token, sender, DividendAddress, amount)
+    if err != nil {
+        return 0, err
+    }
+    if !ok {
+        return 0, ErrInsufficientFunds
+    }
+
+    dividend := Dividend{
+        ID:       count + 1,
+        Snapshot: name,
+        Creator:  hex.EncodeToString(sender),
+        Token:    token,
+        Amount:   amount.String(),
+        PerUnit:  perUnit.String(),
+        Claimed:  "0",
+        Block:    block,
+    }
+    if err := storeDividend(db, dividend); err != nil {
+        return 0, err
+    }
+    return dividend.ID, db.SetData(dividendCountKey, []byte(strconv.FormatUint(dividend.ID, 10)))
+}
+
+// ClaimDividend pays holder its share of a dividend once, and returns the amount
+func ClaimDividend(db *dbservice.DatabaseService, holder []byte, id uint64) (*big.Int, error) {
+    dividend, found, err := LookupDividend(db, id)
+    if err != nil {
+        return nil, err
+    }
+    if !found {
+        return nil, ErrDividendNotFound
+    }
+    claimed, err := Claimed(db, id, holder)
+    if err != nil {
+        return nil, err
+    }
+    if claimed {
+        return nil, ErrClaimed
+    }
+    snapshot, _, err := Lookup(db, dividend.Snapshot)
+    if err != nil {
+        return nil, err
+    }
+    payout := dividend.Payout(HolderBalance(snapshot, holder))
+    if payout.Sign() == 0 {
+        return nil, ErrNothingToClaim
+    }
+
+    if _, err := tokens.Transfer(db, dividend.Token, DividendAddress, holder, payout); err != nil {
+        return nil, err
+    }
+    if err := db.SetData(claimKey(id, holder), []byte{1}); err != nil {
+        return nil, err
+    }
+    total := dbservice.ParseAmount(dividend.Claimed)
+    dividend.Claimed = total.Add(total, payout).String()
+    return payout, storeDividend(db, dividend)
+}
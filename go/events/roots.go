@@ -0,0 +1,54 @@
+package events
+
+import (
+    "sync"
+)
+
+// subscriberBuffer is the number of events buffered per subscriber before
+// further events are dropped for that subscriber
+const subscriberBuffer = 64
+
+// RootEvent describes the outcome of a checkpoint
+type RootEvent struct {
+    BlockNumber int64
+    RootHash    []byte
+    Validated   bool
+}
+
+var (
+    subscribers = make(map[chan RootEvent]struct{})
+    mutex       sync.Mutex
+)
+
+// SubscribeRoots returns a channel receiving every published RootEvent and a
+// function that cancels the subscription
+func SubscribeRoots() (<-chan RootEvent, func()) {
+    ch := make(chan RootEvent, subscriberBuffer)
+
+    mutex.Lock()
+    subscribers[ch] = struct{}{}
+    mutex.Unlock()
+
+    cancel := func() {
+        mutex.Lock()
+        if _, ok := subscribers[ch]; ok {
+            delete(subscribers, ch)
+            close(ch)
+        }
+        mutex.Unlock()
+    }
+    return ch, cancel
+}
+
+// PublishRoot delivers the event to all subscribers without blocking
+func PublishRoot(event RootEvent) {
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    for ch := range subscribers {
+        select {
+        case ch <- event:
+        default:
+        }
+    }
+}
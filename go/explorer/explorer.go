@@ -0,0 +1,22 @@
+// Package explorer serves a small web UI for inspecting a node: its sync status,
+// recent checkpoints and their root hashes, balances and the balance history of an
+// account. The assets are compiled into the binary and read everything from the
+// API of the node, with the credentials the operator enters.
+package explorer
+
+import (
+    "embed"
+    "io/fs"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+//go:embed static
+var static embed.FS
+
+// Register serves the explorer at /explorer/
+func Register(router *gin.Engine) {
+    assets, _ := fs.Sub(static, "static")
+    router.StaticFS("/explorer", http.FS(assets))
+}
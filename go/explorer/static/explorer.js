@@ -0,0 +1,149 @@
+"use strict";
+
+// The credentials entered by the operator, sent as a bearer token with every request
+let token = localStorage.getItem("vida-explorer-token") || "";
+
+// api fetches a path of the node API, returning its JSON body or null for a 404
+async function api(path) {
+    const headers = token ? { Authorization: "Bearer " + token } : {};
+    const response = await fetch(path, { headers });
+    if (response.status === 404) {
+        return null;
+    }
+    if (!response.ok) {
+        throw new Error(path + ": " + response.status + " " + (await response.text()));
+    }
+    return response.json();
+}
+
+function showError(error) {
+    const element = document.getElementById("error");
+    element.textContent = error.message;
+    element.hidden = false;
+    setTimeout(() => { element.hidden = true; }, 5000);
+}
+
+// cell returns a table cell with text, optionally in monospace
+function cell(text, className) {
+    const td = document.createElement("td");
+    td.textContent = text;
+    if (className) {
+        td.className = className;
+    }
+    return td;
+}
+
+// shortHash abbreviates a hash for tables, keeping the full one in the title
+function shortHash(hash) {
+    return hash.length > 20 ? hash.slice(0, 10) + "…" + hash.slice(-8) : hash;
+}
+
+async function loadStatus() {
+    const status = await api("/status");
+    const list = document.getElementById("status");
+    list.replaceChildren();
+    const rows = [
+        ["Last checked block", status.lastCheckedBlock],
+        ["Root hash", status.rootHash || "none", "hash"],
+        ["Health score", status.healthScore.toFixed(2)],
+        ["Ready", status.ready ? "yes" : "no", status.ready ? "good" : "bad"],
+        ["Read-only", status.readOnly ? "yes" : "no", status.readOnly ? "bad" : ""],
+        ["Sync paused", status.syncPaused ? "yes" : "no", status.syncPaused ? "bad" : ""],
+    ];
+    for (const [name, value, className] of rows) {
+        const dt = document.createElement("dt");
+        dt.textContent = name;
+        const dd = document.createElement("dd");
+        dd.textContent = value;
+        dd.className = className || "";
+        list.append(dt, dd);
+    }
+}
+
+async function loadBlocks() {
+    const blocks = await api("/blocks?limit=20");
+    const body = document.querySelector("#blocks tbody");
+    body.replaceChildren();
+    for (const block of blocks) {
+        const row = document.createElement("tr");
+        row.className = "link";
+        row.append(cell(block.blockNumber), cell(block.rootHash, "hash"));
+        row.addEventListener("click", () => loadBlock(block.blockNumber).catch(showError));
+        body.append(row);
+    }
+}
+
+// loadBlock shows the transactions and state changes of an archived block
+async function loadBlock(blockNumber) {
+    const record = await api("/archive?blockNumber=" + blockNumber);
+    document.getElementById("block").hidden = false;
+    document.getElementById("block-number").textContent = blockNumber;
+    const detail = document.getElementById("block-detail");
+    detail.replaceChildren();
+    if (!record) {
+        detail.textContent = "This block is not archived; set archiveDir to keep the transactions and changes of each block.";
+        return;
+    }
+
+    const transactions = document.createElement("p");
+    transactions.textContent = record.transactions.length + " transactions, " + record.changes.length + " changed keys";
+    const table = document.createElement("table");
+    table.innerHTML = "<thead><tr><th>Transaction</th></tr></thead>";
+    const body = document.createElement("tbody");
+    for (const hash of record.transactions) {
+        const row = document.createElement("tr");
+        row.append(cell(hash, "hash"));
+        body.append(row);
+    }
+    table.append(body);
+    detail.append(transactions, table);
+}
+
+// loadAccount shows the balance of an address and the changes of it in the audit log
+async function loadAccount(address) {
+    const balance = await api("/balance?address=" + encodeURIComponent(address));
+    document.getElementById("balance").textContent = "Balance " + balance.balance + " at block " + balance.blockNumber;
+
+    const history = await api("/history?address=" + encodeURIComponent(address) + "&limit=50");
+    const table = document.getElementById("history");
+    const body = table.querySelector("tbody");
+    body.replaceChildren();
+    table.hidden = !history;
+    if (!history) {
+        document.getElementById("balance").textContent += " (set audit.path to keep the balance history)";
+        return;
+    }
+    for (const entry of history) {
+        const change = BigInt(entry.new) - BigInt(entry.old);
+        const row = document.createElement("tr");
+        const tx = cell(entry.tx ? shortHash(entry.tx) : "genesis", "hash");
+        tx.title = entry.tx || "";
+        row.append(
+            cell(entry.block),
+            tx,
+            cell((change > 0n ? "+" : "") + change, change > 0n ? "gain" : "loss"),
+            cell(entry.new),
+            cell(new Date(entry.time).toLocaleString()),
+        );
+        body.append(row);
+    }
+}
+
+function refresh() {
+    Promise.all([loadStatus(), loadBlocks()]).catch(showError);
+}
+
+document.getElementById("token").value = token;
+document.getElementById("credentials").addEventListener("submit", (event) => {
+    event.preventDefault();
+    token = document.getElementById("token").value.trim();
+    localStorage.setItem("vida-explorer-token", token);
+    refresh();
+});
+document.getElementById("account").addEventListener("submit", (event) => {
+    event.preventDefault();
+    loadAccount(document.getElementById("address").value.trim()).catch(showError);
+});
+
+refresh();
+setInterval(refresh, 5000);
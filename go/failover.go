@@ -0,0 +1,192 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "time"
+
+    "pwr-stateful-vida/alert"
+    "pwr-stateful-vida/health"
+    "pwr-stateful-vida/lease"
+    "pwr-stateful-vida/metrics"
+)
+
+// startFailover opens the lease of the pair and starts the node as the active when
+// it takes the lease, or as the standby following the other node otherwise
+func (a *App) startFailover() error {
+    cfg := a.config().Failover
+    name := cfg.Name
+    if name == "" {
+        host, err := os.Hostname()
+        if err != nil {
+            return fmt.Errorf("failover.name is not set and the host name is unknown: %v", err)
+        }
+        name = host
+    }
+    duration, _ := time.ParseDuration(cfg.LeaseDuration)
+    interval, _ := time.ParseDuration(cfg.CheckInterval)
+    client, err := newHTTPPeers(a.config().PeerTLS, a.config().Auth.PeerToken, interval)
+    if err != nil {
+        return err
+    }
+
+    a.lease = lease.Open(cfg.LeasePath, name, duration)
+    acquired, current, err := a.lease.Acquire()
+    if err != nil {
+        return fmt.Errorf("failed to read the failover lease: %v", err)
+    }
+    if acquired {
+        logger.Info("holding the failover lease, starting as the active node", "name", name, "epoch", current.Epoch)
+        a.startSync()
+    } else {
+        logger.Info("failover lease held by the other node, starting as the standby", "name", name, "holder", current.Holder, "epoch", current.Epoch)
+        health.SetStandby(true)
+        if err := a.startReplica(cfg.Peer); err != nil {
+            return err
+        }
+    }
+
+    a.failoverStop = make(chan struct{})
+    a.failoverDone = make(chan struct{})
+    go a.runFailover(client, cfg.Peer, duration/3, interval, cfg.FailedChecks)
+    return nil
+}
+
+// runFailover renews the lease while the node is active and, while it is the
+// standby, checks that the active is ready, taking the lease over once it failed
+// failedChecks checks in a row and stopped renewing the lease
+func (a *App) runFailover(client httpPeers, peer string, renewal, interval time.Duration, failedChecks int) {
+    defer close(a.failoverDone)
+    failures := 0
+
+    for {
+        wait := renewal
+        if health.Standby() {
+            wait = interval
+        }
+        select {
+        case <-a.failoverStop:
+            return
+        case <-time.After(wait):
+        }
+
+        if !health.Standby() {
+            err := a.lease.Renew()
+            if errors.Is(err, lease.ErrLost) {
+                a.demote(peer)
+            } else if err != nil {
+                logger.Warn("failed to renew the failover lease", "error", err)
+            }
+            continue
+        }
+
+        _, err := client.get(peer, "/readyz")
+        if err == nil {
+            failures = 0
+            continue
+        }
+        failures++
+        logger.Warn("active node failed its health check", "peer", peer, "failures", failures, "error", err)
+        if failures < failedChecks {
+            continue
+        }
+        acquired, current, err := a.lease.Acquire()
+        switch {
+        case err != nil:
+            logger.Warn("failed to read the failover lease", "error", err)
+        case !acquired:
+            logger.Warn("active node is unhealthy but still holds the failover lease", "holder", current.Holder, "expires", current.Expires)
+        default:
+            a.promote(peer, current.Epoch, failures)
+            failures = 0
+        }
+    }
+}
+
+// promote stops following the other node and syncs from the last replicated
+// checkpoint, serving the API and peers as the active node
+func (a *App) promote(peer string, epoch int64, failures int) {
+    a.adminMutex.Lock()
+    defer a.adminMutex.Unlock()
+
+    a.stopReplica()
+    health.SetStandby(false)
+    metrics.FailoverTransitions.Inc("active")
+    lastBlock, _ := a.db.GetLastCheckedBlock()
+    logger.Warn("took over as the active node", "epoch", epoch, "block", lastBlock)
+    alerts.Raise(alert.Alert{
+        Kind:     alert.KindFailover,
+        Severity: alert.Warning,
+        Message:  fmt.Sprintf("standby took over from %s at block %d after %d failed health checks", peer, lastBlock, failures),
+        Block:    lastBlock,
+    })
+    a.startSync()
+}
+
+// demote stops syncing once the other node took the lease, discarding the batch in
+// progress, and follows that node as the standby
+func (a *App) demote(peer string) {
+    a.adminMutex.Lock()
+    defer a.adminMutex.Unlock()
+
+    if a.subscription != nil {
+        a.subscription.Stop()
+        a.syncNode().Discard()
+        a.subscription = nil
+    }
+    a.stopSync()
+    health.SetStandby(true)
+    metrics.FailoverTransitions.Inc("standby")
+    lastBlock, _ := a.db.GetLastCheckedBlock()
+    logger.Error("failover lease taken by the other node, continuing as the standby", "peer", peer, "block", lastBlock)
+    alerts.Raise(alert.Alert{
+        Kind:     alert.KindFailover,
+        Severity: alert.Critical,
+        Message:  fmt.Sprintf("lost the failover lease at block %d, now the standby of %s", lastBlock, peer),
+        Block:    lastBlock,
+    })
+    if err := a.startReplica(peer); err != nil {
+        logger.Error("failed to follow the active node", "peer", peer, "error", err)
+    }
+}
+
+// fenced reports whether the checkpoint of blockNumber must not be written because
+// the node does not hold the failover lease, discarding the batch if so. The other
+// node may already be writing checkpoints of its own.
+func (a *App) fenced(blockNumber int) bool {
+    if a.lease == nil {
+        return false
+    }
+    err := a.lease.Check()
+    if err == nil {
+        return false
+    }
+    syncLogger.Error("failover lease not held, discarding batch", "block", blockNumber, "error", err)
+    metrics.FencedBatches.Inc()
+    a.syncNode().Discard()
+    return true
+}
+
+// stopFailover ends the failover loop, so the role of the node no longer changes
+func (a *App) stopFailover() {
+    if a.failoverStop == nil {
+        return
+    }
+    close(a.failoverStop)
+    <-a.failoverDone
+    a.failoverStop, a.failoverDone = nil, nil
+}
+
+// releaseLease gives up the lease of an active node that stops, so the standby can
+// take over without waiting for the lease to expire
+func (a *App) releaseLease() {
+    if a.lease == nil {
+        return
+    }
+    if err := a.lease.Release(); err != nil {
+        logger.Warn("failed to release the failover lease", "error", err)
+    }
+    a.lease = nil
+    health.SetStandby(false)
+}
@@ -0,0 +1,15 @@
+//go:build !wallet
+
+package main
+
+import (
+    "errors"
+
+    "pwr-stateful-vida/api"
+)
+
+// newFaucet fails: signing faucet transfers needs the wallet, which is only built
+// with -tags wallet
+func newFaucet() (api.FaucetSender, error) {
+    return nil, errors.New("the faucet needs a build with -tags wallet")
+}
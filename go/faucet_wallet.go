@@ -0,0 +1,61 @@
+//go:build wallet
+
+package main
+
+import (
+    "errors"
+    "math/big"
+    "sync"
+
+    "pwr-stateful-vida/api"
+    "pwr-stateful-vida/config"
+
+    "github.com/pwrlabs/pwrgo/wallet"
+)
+
+// walletFaucet sends faucet transfers signed by the faucet wallet, one at a time so
+// that their nonces follow each other
+type walletFaucet struct {
+    mutex   sync.Mutex
+    wallet  *wallet.PWRWallet
+    address []byte
+}
+
+// newFaucet unlocks the configured faucet wallet
+func newFaucet() (api.FaucetSender, error) {
+    cfg := config.Get().Faucet
+    w, err := loadWallet(cfg.WalletFile, cfg.Password)
+    if err != nil {
+        return nil, err
+    }
+    address, err := parseAddress(w.GetAddress())
+    if err != nil {
+        return nil, err
+    }
+    return &walletFaucet{wallet: w, address: address}, nil
+}
+
+// Address returns the address of the faucet wallet
+func (f *walletFaucet) Address() []byte {
+    return f.address
+}
+
+// Send submits a transfer to the VIDA and returns its hash
+func (f *walletFaucet) Send(receiver []byte, amount *big.Int) (string, error) {
+    payload, err := encodeTransfer(receiver, amount)
+    if err != nil {
+        return "", err
+    }
+
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    feePerByte := config.Get().Faucet.FeePerByte
+    if feePerByte == 0 {
+        feePerByte = f.wallet.GetRpc().GetFeePerByte()
+    }
+    response := f.wallet.SendVidaData(int(config.Get().VidaID), payload, feePerByte)
+    if !response.Success {
+        return "", errors.New("transaction rejected: " + response.Error)
+    }
+    return response.Hash, nil
+}
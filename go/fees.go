@@ -0,0 +1,73 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "math/big"
+
+    "pwr-stateful-vida/fees"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+)
+
+// feeParams returns the transfer fee schedule of the genesis params
+func (a *App) feeParams() fees.Params {
+    cfg := a.chainParams().Fees
+    initial, ok := new(big.Int).SetString(cfg.InitialBaseFee, 10)
+    if !ok {
+        initial = new(big.Int)
+    }
+    minimum, ok := new(big.Int).SetString(cfg.MinBaseFee, 10)
+    if !ok {
+        minimum = new(big.Int)
+    }
+    return fees.Params{Target: cfg.TargetTransfers, Denominator: cfg.ChangeDenominator, InitialBaseFee: initial, MinBaseFee: minimum}
+}
+
+// transferFee returns the native fee of a transfer in the current block, and
+// whether the sender can pay it on top of the transfer
+func (a *App) transferFee(ctx context.Context, sender []byte, token string, amount *big.Int) (*big.Int, bool) {
+    fee, err := fees.Current(a.db, a.feeParams())
+    if err != nil {
+        reportFeeError(ctx, err, sender)
+        return nil, false
+    }
+    if fee.Sign() == 0 {
+        return fee, true
+    }
+    required := new(big.Int).Set(fee)
+    if tokens.IsNative(token) {
+        required.Add(required, amount)
+    }
+    balance, err := tokens.Balance(a.db, tokens.Native, sender)
+    if err != nil {
+        reportFeeError(ctx, err, sender)
+        return nil, false
+    }
+    return fee, balance.Cmp(required) >= 0
+}
+
+// chargeTransferFee pays the fee of an applied transfer to the fee collector and
+// counts the transfer towards the volume of the block
+func (a *App) chargeTransferFee(ctx context.Context, sender []byte, fee *big.Int) {
+    params := a.feeParams()
+    if fee.Sign() > 0 {
+        if _, err := tokens.Transfer(a.db, tokens.Native, sender, fees.CollectorAddress, fee); err != nil {
+            reportFeeError(ctx, err, sender)
+        }
+    }
+    if err := fees.RecordTransfer(a.db, params); err != nil {
+        reportFeeError(ctx, err, sender)
+    }
+}
+
+// reportFeeError reports an unexpected error of the fees module
+func reportFeeError(ctx context.Context, err error, sender []byte) {
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        "transfer",
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": hex.EncodeToString(sender)},
+    })
+}
@@ -0,0 +1,134 @@
+// Package fees computes the fee charged on each transfer from recent transfer
+// volume, in the manner of EIP-1559: after every block the base fee moves towards
+// keeping the number of transfers per block at a target, up when a block had more
+// and down when it had fewer, by at most 1/Denominator of itself. Blocks without
+// transactions count as empty. The base fee and the transfers of the current block
+// are part of the Merkle state, so every node charges the same fee.
+package fees
+
+import (
+    "math/big"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// maxEmptyBlocks bounds the adjustments made for a run of empty blocks; the base fee
+// has long reached its minimum by then
+const maxEmptyBlocks = 1024
+
+var (
+    // CollectorAddress is the account transfer fees are paid to
+    CollectorAddress = dbservice.ModuleAddress("fees")
+
+    stateKey = []byte(dbservice.FeesPrefix + "state")
+)
+
+// Params set the fee schedule. Fees are off when Target is 0 or both the initial
+// and minimum base fees are 0.
+type Params struct {
+    // Target is the number of transfers per block the base fee steers towards
+    Target int64
+    // Denominator bounds the change of the base fee per block to 1/Denominator of it
+    Denominator    int64
+    InitialBaseFee *big.Int
+    MinBaseFee     *big.Int
+}
+
+// State is the base fee of a block and the transfers applied in it so far
+type State struct {
+    Block     int64  `json:"block"`
+    BaseFee   string `json:"baseFee"`
+    Transfers int64  `json:"transfers"`
+}
+
+// Enabled reports whether transfers are charged a fee
+func (p Params) Enabled() bool {
+    return p.Target > 0 && p.Denominator > 0 && (p.InitialBaseFee.Sign() > 0 || p.MinBaseFee.Sign() > 0)
+}
+
+// Lookup returns the fee state, and false before the first block charged a fee
+func Lookup(db *dbservice.DatabaseService) (State, bool, error) {
+    var state State
+    found, err := db.LoadJSON(stateKey, &state)
+    return state, found, err
+}
+
+// save writes the fee state
+func save(db *dbservice.DatabaseService, state State) error {
+    return db.SaveJSON(stateKey, state)
+}
+
+// Next returns the base fee of the block after one with transfers under params
+func Next(baseFee *big.Int, transfers int64, params Params) *big.Int {
+    next := new(big.Int).Set(baseFee)
+    if transfers != params.Target {
+        // A block of more than twice the target raises the fee by 1/Denominator, like one of twice the target
+        excess := transfers - params.Target
+        if excess > params.Target {
+            excess = params.Target
+        }
+        delta := new(big.Int).Mul(baseFee, big.NewInt(excess))
+        delta.Quo(delta, big.NewInt(params.Target*params.Denominator))
+        if delta.Sign() == 0 && transfers > params.Target {
+            delta.SetInt64(1)
+        }
+        next.Add(next, delta)
+    }
+    if next.Cmp(params.MinBaseFee) < 0 {
+        next.Set(params.MinBaseFee)
+    }
+    return next
+}
+
+// BeginBlock moves the base fee to block, adjusting it for the transfers of the
+// last block with transactions and for the empty blocks since
+func BeginBlock(db *dbservice.DatabaseService, block int64, params Params) error {
+    if !params.Enabled() {
+        return nil
+    }
+    state, found, err := Lookup(db)
+    if err != nil {
+        return err
+    }
+    if !found {
+        baseFee := params.InitialBaseFee
+        if baseFee.Cmp(params.MinBaseFee) < 0 {
+            baseFee = params.MinBaseFee
+        }
+        return save(db, State{Block: block, BaseFee: baseFee.String()})
+    }
+    if block <= state.Block {
+        return nil
+    }
+    baseFee := dbservice.ParseAmount(state.BaseFee)
+    baseFee = Next(baseFee, state.Transfers, params)
+    for empty := int64(1); empty < block-state.Block && empty <= maxEmptyBlocks; empty++ {
+        baseFee = Next(baseFee, 0, params)
+    }
+    return save(db, State{Block: block, BaseFee: baseFee.String()})
+}
+
+// Current returns the fee of a transfer in the block BeginBlock last moved to
+func Current(db *dbservice.DatabaseService, params Params) (*big.Int, error) {
+    if !params.Enabled() {
+        return new(big.Int), nil
+    }
+    state, found, err := Lookup(db)
+    if err != nil || !found {
+        return new(big.Int), err
+    }
+    return dbservice.ParseAmount(state.BaseFee), nil
+}
+
+// RecordTransfer counts a transfer applied in the current block
+func RecordTransfer(db *dbservice.DatabaseService, params Params) error {
+    if !params.Enabled() {
+        return nil
+    }
+    state, found, err := Lookup(db)
+    if err != nil || !found {
+        return err
+    }
+    state.Transfers++
+    return save(db, state)
+}
@@ -0,0 +1,126 @@
+package fees
+
+import (
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+func TestNextMovesByAtMostOneDenominator(t *testing.T) {
+    tests := []struct {
+        name      string
+        baseFee   int64
+        minFee    int64
+        transfers int64
+        want      int64
+    }{
+        {name: "at target", baseFee: 800, minFee: 1, transfers: 10, want: 800},
+        {name: "twice the target", baseFee: 800, minFee: 1, transfers: 20, want: 900},
+        {name: "far over target", baseFee: 800, minFee: 1, transfers: 10_000, want: 900},
+        {name: "half the target", baseFee: 800, minFee: 1, transfers: 5, want: 750},
+        {name: "empty", baseFee: 800, minFee: 1, transfers: 0, want: 700},
+        {name: "empty at the minimum", baseFee: 100, minFee: 100, transfers: 0, want: 100},
+        {name: "rounded up over target", baseFee: 7, minFee: 1, transfers: 11, want: 8},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            params := Params{Target: 10, Denominator: 8, InitialBaseFee: big.NewInt(800), MinBaseFee: big.NewInt(test.minFee)}
+            baseFee := big.NewInt(test.baseFee)
+            got := Next(baseFee, test.transfers, params)
+            if got.Int64() != test.want {
+                t.Errorf("Next(%d, %d) = %s, want %d", test.baseFee, test.transfers, got, test.want)
+            }
+            // The fee moves by at most base/Denominator, or by 1 when that rounds to 0
+            step := new(big.Int).Quo(baseFee, big.NewInt(params.Denominator))
+            if step.Sign() == 0 {
+                step.SetInt64(1)
+            }
+            if change := new(big.Int).Sub(got, baseFee); change.CmpAbs(step) > 0 {
+                t.Errorf("Next(%d, %d) moved the fee by %s, more than %s", test.baseFee, test.transfers, change, step)
+            }
+        })
+    }
+}
+
+func TestBeginBlockChargesForRecordedTransfers(t *testing.T) {
+    // block is a block BeginBlock moves to and the transfers recorded in it
+    type block struct {
+        number    int64
+        transfers int
+    }
+    tests := []struct {
+        name    string
+        params  Params
+        blocks  []block
+        wantFee int64
+    }{
+        {
+            name:    "disabled",
+            params:  Params{Target: 0, Denominator: 8, InitialBaseFee: big.NewInt(800), MinBaseFee: big.NewInt(1)},
+            blocks:  []block{{number: 1, transfers: 30}, {number: 2}},
+            wantFee: 0,
+        },
+        {
+            name:    "first block",
+            params:  Params{Target: 4, Denominator: 8, InitialBaseFee: big.NewInt(800), MinBaseFee: big.NewInt(1)},
+            blocks:  []block{{number: 12}},
+            wantFee: 800,
+        },
+        {
+            name:    "initial fee below the minimum",
+            params:  Params{Target: 4, Denominator: 8, InitialBaseFee: big.NewInt(10), MinBaseFee: big.NewInt(50)},
+            blocks:  []block{{number: 3}},
+            wantFee: 50,
+        },
+        {
+            name:    "busy block",
+            params:  Params{Target: 4, Denominator: 8, InitialBaseFee: big.NewInt(800), MinBaseFee: big.NewInt(1)},
+            blocks:  []block{{number: 5, transfers: 8}, {number: 6}},
+            wantFee: 900,
+        },
+        {
+            name:    "transfers of a block counted once",
+            params:  Params{Target: 4, Denominator: 8, InitialBaseFee: big.NewInt(800), MinBaseFee: big.NewInt(1)},
+            blocks:  []block{{number: 5, transfers: 4}, {number: 5, transfers: 4}, {number: 6}},
+            wantFee: 900,
+        },
+        {
+            name:    "empty blocks in between",
+            params:  Params{Target: 2, Denominator: 4, InitialBaseFee: big.NewInt(1600), MinBaseFee: big.NewInt(1)},
+            blocks:  []block{{number: 1, transfers: 2}, {number: 4}},
+            wantFee: 900,
+        },
+        {
+            name:    "long run of empty blocks",
+            params:  Params{Target: 2, Denominator: 4, InitialBaseFee: big.NewInt(1600), MinBaseFee: big.NewInt(25)},
+            blocks:  []block{{number: 1}, {number: 5000}},
+            wantFee: 25,
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := dbservice.New("fees")
+            db.SetDir(t.TempDir())
+            defer db.Close()
+
+            for _, block := range test.blocks {
+                if err := BeginBlock(db, block.number, test.params); err != nil {
+                    t.Fatal(err)
+                }
+                for i := 0; i < block.transfers; i++ {
+                    if err := RecordTransfer(db, test.params); err != nil {
+                        t.Fatal(err)
+                    }
+                }
+            }
+            fee, err := Current(db, test.params)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if fee.Int64() != test.wantFee {
+                t.Errorf("Current() = %s, want %d", fee, test.wantFee)
+            }
+        })
+    }
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "strings"
+
+    "pwr-stateful-vida/airdrop"
+    "pwr-stateful-vida/distribution"
+    "pwr-stateful-vida/fees"
+    "pwr-stateful-vida/governance"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/names"
+    "pwr-stateful-vida/otc"
+    "pwr-stateful-vida/referral"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/savings"
+    "pwr-stateful-vida/sdk"
+    "pwr-stateful-vida/staking"
+    "pwr-stateful-vida/stream"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons a governance action is rejected
+const (
+    failureProposalNotFound = "proposal_not_found"
+    failureVotingClosed     = "voting_closed"
+    failureAlreadyVoted     = "already_voted"
+    failureNoVotingPower    = "no_voting_power"
+    failureNotPassed        = "proposal_not_passed"
+)
+
+// governanceParams returns the voting rules in force, those of the genesis until a
+// proposal changes them
+func (a *App) governanceParams() (governance.Params, error) {
+    cfg := a.chainParams().Governance
+    return governance.CurrentParams(a.db, governance.Params{Quorum: cfg.Quorum, Threshold: cfg.Threshold, VotingPeriod: cfg.VotingPeriod, Deposit: cfg.Deposit})
+}
+
+// governanceExcluded returns the module accounts left out of the snapshots of
+// proposals, since no one can vote with their balances
+func governanceExcluded() [][]byte {
+    return [][]byte{staking.EscrowAddress, names.TreasuryAddress, savings.PoolAddress, airdrop.Address, governance.Address, otc.Address, referral.PoolAddress, stream.Address, fees.CollectorAddress, distribution.DividendAddress}
+}
+
+// payloadInt reads a non-negative integer given as a decimal string or a JSON number
+func payloadInt(raw number) (int64, bool) {
+    value, ok := raw.integer()
+    if !ok || !value.IsInt64() || value.Sign() < 0 {
+        return 0, false
+    }
+    return value.Int64(), true
+}
+
+// proposalAction reads the payload a proposal executes, which must be an action
+// other than a governance one whose payload is valid
+func (a *App) proposalAction(raw json.RawMessage) (json.RawMessage, error) {
+    if len(raw) == 0 || string(raw) == "null" {
+        return nil, nil
+    }
+    payload, err := sdk.DecodePayload(raw)
+    if err != nil {
+        return nil, invalidField("payload", "must be an object")
+    }
+    action, _ := payload["action"].(string)
+    switch strings.ToLower(action) {
+    case "", "propose", "vote", "execute":
+        return nil, invalidField("payload", "must be an action other than a governance one")
+    }
+    data, err := json.Marshal(payload)
+    if err != nil {
+        return nil, invalidField("payload", err.Error())
+    }
+    if handler := actionHandlers[strings.ToLower(action)]; handler != nil {
+        if _, err := handler.decode(a, data); err != nil {
+            invalid := &payloadError{reason: err.Error()}
+            errors.As(err, &invalid)
+            return nil, invalidField(strings.TrimSuffix("payload."+invalid.field, "."), invalid.reason)
+        }
+    }
+    return data, nil
+}
+
+// proposePayload is the payload of a propose
+type proposePayload struct {
+    envelope
+    Title       string `json:"title"`
+    Description string `json:"description"`
+    // Payload is the action the proposal executes when it passes, if any
+    Payload json.RawMessage `json:"payload"`
+
+    action json.RawMessage
+}
+
+func (p *proposePayload) validate(a *App) error {
+    action, err := a.proposalAction(p.Payload)
+    p.action = action
+    return err
+}
+
+// proposalPayload is the payload of an execute
+type proposalPayload struct {
+    envelope
+    Proposal number `json:"proposal"`
+
+    proposal uint64
+}
+
+func (p *proposalPayload) validate(a *App) error {
+    id := parsePositiveAmount(p.Proposal)
+    if id == nil || !id.IsUint64() {
+        return invalidField("proposal", "must be a proposal ID")
+    }
+    p.proposal = id.Uint64()
+    return nil
+}
+
+// votePayload is the payload of a vote
+type votePayload struct {
+    proposalPayload
+    Choice string `json:"choice"`
+}
+
+// governanceParamsPayload is the payload of a governanceParams
+type governanceParamsPayload struct {
+    envelope
+    Quorum       number `json:"quorum"`
+    Threshold    number `json:"threshold"`
+    VotingPeriod number `json:"votingPeriod"`
+    // Deposit, when given, replaces the proposal deposit, which is kept otherwise
+    Deposit number `json:"deposit"`
+
+    params governance.Params
+}
+
+func (p *governanceParamsPayload) validate(a *App) error {
+    var ok bool
+    if p.params.Quorum, ok = payloadInt(p.Quorum); !ok {
+        return invalidField("quorum", "must be a non-negative integer")
+    }
+    if p.params.Threshold, ok = payloadInt(p.Threshold); !ok {
+        return invalidField("threshold", "must be a non-negative integer")
+    }
+    if p.params.VotingPeriod, ok = payloadInt(p.VotingPeriod); !ok {
+        return invalidField("votingPeriod", "must be a non-negative integer")
+    }
+    if p.Deposit.raw != nil {
+        deposit, ok := p.Deposit.integer()
+        if !ok || deposit.Sign() < 0 {
+            return invalidField("deposit", "must be a non-negative integer")
+        }
+        p.params.Deposit = deposit.String()
+    }
+    return nil
+}
+
+// handlePropose creates a proposal voted on by the balances of the snapshot taken
+// at the block. It returns the reason the proposal was rejected, or an empty string
+// on success.
+func (a *App) handlePropose(ctx context.Context, p *proposePayload, transaction rpc.VidaDataTransaction) string {
+    senderHex, block := transaction.Sender, int64(transaction.BlockNumber)
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    params, err := a.governanceParams()
+    if err != nil {
+        return governanceFailure(ctx, err, "propose", p, senderHex)
+    }
+    proposal, err := governance.Propose(a.db, sender, p.Title, p.Description, p.action, block, params, governanceExcluded())
+    if err != nil {
+        return governanceFailure(ctx, err, "propose", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "proposal created", "proposal", proposal, "votingEnds", block+params.VotingPeriod, "sender", senderHex)
+    return ""
+}
+
+// handleVote casts the vote of the sender on a proposal. It returns the reason the
+// vote was rejected, or an empty string on success.
+func (a *App) handleVote(ctx context.Context, p *votePayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    power, err := governance.CastVote(a.db, sender, p.proposal, strings.ToLower(p.Choice), int64(transaction.BlockNumber))
+    if err != nil {
+        return governanceFailure(ctx, err, "vote", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "vote cast", "proposal", p.proposal, "choice", p.Choice, "power", power, "sender", senderHex)
+    return ""
+}
+
+// handleGovernanceParams changes the voting rules, sent by a governor. It returns
+// the reason the change was rejected, or an empty string on success.
+func (a *App) handleGovernanceParams(ctx context.Context, p *governanceParamsPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    if payloadAddress(senderHex) == nil {
+        return failureInvalidPayload
+    }
+    if !a.isGovernor(senderHex) {
+        syncLogger.WarnContext(ctx, "governance params change from a non-governor", "sender", senderHex)
+        return failureUnauthorized
+    }
+    params := p.params
+    if p.Deposit.raw == nil {
+        current, err := a.governanceParams()
+        if err != nil {
+            return governanceFailure(ctx, err, "governanceParams", p, senderHex)
+        }
+        params.Deposit = current.Deposit
+    }
+    if err := governance.SetParams(a.db, params); err != nil {
+        return governanceFailure(ctx, err, "governanceParams", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "governance params changed", "quorum", params.Quorum, "threshold", params.Threshold, "votingPeriod", params.VotingPeriod, "deposit", params.Deposit, "sender", senderHex)
+    return ""
+}
+
+// handleExecute applies the action of a passed proposal on behalf of the
+// governance account, then marks it executed. A proposal whose action is rejected
+// stays executable.
+func (a *App) handleExecute(ctx context.Context, p *proposalPayload, transaction rpc.VidaDataTransaction) string {
+    if payloadAddress(transaction.Sender) == nil {
+        return failureInvalidPayload
+    }
+    block := int64(transaction.BlockNumber)
+    proposal, err := governance.Executable(a.db, p.proposal, block)
+    if err != nil {
+        return governanceFailure(ctx, err, "execute", p, transaction.Sender)
+    }
+    if len(proposal.Action) > 0 {
+        executed := transaction
+        executed.Sender = "0x" + hex.EncodeToString(governance.Address)
+        executed.Data = hex.EncodeToString(proposal.Action)
+        payload, _, failure := a.parsePayload(executed)
+        if failure == "" {
+            var decoded actionPayload
+            if decoded, failure = a.decodeTransaction(ctx, executed, payload); failure == "" {
+                failure = payload.handler.apply(a, ctx, decoded, executed)
+            }
+        }
+        if failure != "" {
+            syncLogger.InfoContext(ctx, "proposal action rejected", "proposal", p.proposal, "reason", failure)
+            return failure
+        }
+    }
+    if err := governance.MarkExecuted(a.db, proposal, block); err != nil {
+        return governanceFailure(ctx, err, "execute", p, transaction.Sender)
+    }
+    syncLogger.InfoContext(ctx, "proposal executed", "proposal", p.proposal, "sender", transaction.Sender)
+    return ""
+}
+
+// governanceFailure maps an error of the governance package to the reason a
+// transaction is rejected
+func governanceFailure(ctx context.Context, err error, action string, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, governance.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid "+action, "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, governance.ErrNotFound):
+        return failureProposalNotFound
+    case errors.Is(err, governance.ErrNotStarted), errors.Is(err, governance.ErrClosed):
+        return failureVotingClosed
+    case errors.Is(err, governance.ErrAlreadyVoted):
+        return failureAlreadyVoted
+    case errors.Is(err, governance.ErrNoPower):
+        return failureNoVotingPower
+    case errors.Is(err, governance.ErrNoDeposit):
+        return failureInsufficientFunds
+    case errors.Is(err, governance.ErrNotPassed), errors.Is(err, governance.ErrExecuted):
+        return failureNotPassed
+    }
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": senderHex},
+    })
+    return failureInvalidPayload
+}
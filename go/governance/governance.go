@@ -0,0 +1,367 @@
+// Package governance lets token holders decide on proposals. Voting power is the
+// native balance of an account in a distribution snapshot taken at the end of the
+// proposal block, so tokens moved after a proposal cannot vote on it twice. A
+// proposal passes when the votes reach the quorum share of the snapshot total and
+// the yes votes exceed the threshold share of the yes and no votes. The quorum,
+// threshold, voting period and proposal deposit are kept in the Merkle state, and a
+// passed proposal can carry an action that executing it applies on behalf of
+// Address.
+package governance
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "sort"
+    "strconv"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/distribution"
+)
+
+// Errors of rejected governance actions
+var (
+    ErrInvalid      = errors.New("invalid governance action")
+    ErrNotFound     = errors.New("proposal not found")
+    ErrNotStarted   = errors.New("voting has not started")
+    ErrClosed       = errors.New("voting is closed")
+    ErrAlreadyVoted = errors.New("already voted")
+    ErrNoPower      = errors.New("no voting power")
+    ErrNotPassed    = errors.New("proposal has not passed")
+    ErrExecuted     = errors.New("proposal already executed")
+    ErrNoDeposit    = errors.New("insufficient funds for the proposal deposit")
+)
+
+const (
+    // BasisPoints is the denominator of the quorum and threshold
+    BasisPoints = 10000
+    // SnapshotPrefix starts the names of the snapshots of proposals
+    SnapshotPrefix = "governance."
+    // MaxTitleLength is the longest title a proposal can have
+    MaxTitleLength = 256
+)
+
+// Choices a vote can make
+const (
+    Yes     = "yes"
+    No      = "no"
+    Abstain = "abstain"
+)
+
+// Statuses of a proposal
+const (
+    StatusPending  = "pending"
+    StatusActive   = "active"
+    StatusPassed   = "passed"
+    StatusRejected = "rejected"
+    StatusExecuted = "executed"
+)
+
+var (
+    // Address is the account executed proposals act as
+    Address = dbservice.ModuleAddress("governance")
+
+    paramsKey = []byte(dbservice.GovernancePrefix + "params")
+    countKey  = []byte(dbservice.GovernancePrefix + "count")
+)
+
+// Params are the voting rules, applied to proposals made while they are in force
+type Params struct {
+    // Quorum is the share of the snapshot total, in basis points, that must vote
+    Quorum int64 `json:"quorum"`
+    // Threshold is the share of the yes and no votes, in basis points, that yes
+    // must exceed
+    Threshold int64 `json:"threshold"`
+    // VotingPeriod is the number of blocks after the proposal block votes are taken in
+    VotingPeriod int64 `json:"votingPeriod"`
+    // Deposit is the native amount a proposer pays to the governance account, since
+    // every proposal makes each node snapshot all holders
+    Deposit string `json:"deposit,omitempty"`
+}
+
+// Validate reports whether the params can be put in force
+func (p Params) Validate() error {
+    if p.Quorum < 0 || p.Quorum > BasisPoints || p.Threshold < 0 || p.Threshold >= BasisPoints {
+        return fmt.Errorf("%w: the quorum must be 0 to %d and the threshold 0 to %d basis points", ErrInvalid, BasisPoints, BasisPoints-1)
+    }
+    if p.VotingPeriod <= 0 {
+        return fmt.Errorf("%w: the voting period must be positive", ErrInvalid)
+    }
+    if deposit, ok := new(big.Int).SetString(p.Deposit, 10); p.Deposit != "" && (!ok || deposit.Sign() < 0) {
+        return fmt.Errorf("%w: the deposit must be a non-negative integer", ErrInvalid)
+    }
+    return nil
+}
+
+// Proposal is a proposal and its tally. The voting rules are copied from the params
+// in force when it was made, and Action is the payload applied when it is executed.
+type Proposal struct {
+    ID          uint64          `json:"id"`
+    Proposer    string          `json:"proposer"`
+    Title       string          `json:"title"`
+    Description string          `json:"description,omitempty"`
+    Action      json.RawMessage `json:"action,omitempty"`
+    Deposit     string          `json:"deposit,omitempty"`
+    Block       int64           `json:"block"`
+    Snapshot    string          `json:"snapshot"`
+    VotingEnds  int64           `json:"votingEnds"`
+    Quorum      int64           `json:"quorum"`
+    Threshold   int64           `json:"threshold"`
+    Yes         string          `json:"yes"`
+    No          string          `json:"no"`
+    Abstain     string          `json:"abstain"`
+    Executed    bool            `json:"executed"`
+    ExecutedAt  int64           `json:"executedAt,omitempty"`
+}
+
+// Vote is the vote of an account on a proposal
+type Vote struct {
+    Choice string `json:"choice"`
+    Power  string `json:"power"`
+    Block  int64  `json:"block"`
+}
+
+// proposalKey returns the tree key of a proposal, zero padded so keys sort in order
+func proposalKey(id uint64) []byte {
+    return []byte(fmt.Sprintf("%sproposal/%020d", dbservice.GovernancePrefix, id))
+}
+
+// voteKey returns the tree key of the vote of an account on a proposal
+func voteKey(id uint64, voter []byte) []byte {
+    return []byte(fmt.Sprintf("%svote/%d/%s", dbservice.GovernancePrefix, id, hex.EncodeToString(voter)))
+}
+
+// CurrentParams returns the params in force, which are defaults until params are
+// first set
+func CurrentParams(db *dbservice.DatabaseService, defaults Params) (Params, error) {
+    var params Params
+    found, err := db.LoadJSON(paramsKey, &params)
+    if err != nil || !found {
+        return defaults, err
+    }
+    return params, nil
+}
+
+// SetParams puts new params in force for later proposals
+func SetParams(db *dbservice.DatabaseService, params Params) error {
+    if err := params.Validate(); err != nil {
+        return err
+    }
+    return db.SaveJSON(paramsKey, params)
+}
+
+// Lookup returns a proposal, and false when there is none with that ID
+func Lookup(db *dbservice.DatabaseService, id uint64) (Proposal, bool, error) {
+    var proposal Proposal
+    found, err := db.LoadJSON(proposalKey(id), &proposal)
+    return proposal, found, err
+}
+
+// Count returns the number of proposals made
+func Count(db *dbservice.DatabaseService) (uint64, error) {
+    data, err := db.GetData(countKey)
+    if err != nil || len(data) == 0 {
+        return 0, err
+    }
+    return strconv.ParseUint(string(data), 10, 64)
+}
+
+// List returns up to limit proposals after the given ID, in order
+func List(db *dbservice.DatabaseService, after uint64, limit int) ([]Proposal, error) {
+    count, err := Count(db)
+    if err != nil {
+        return nil, err
+    }
+    proposals := []Proposal{}
+    for id := after + 1; id <= count && len(proposals) < limit; id++ {
+        proposal, found, err := Lookup(db, id)
+        if err != nil {
+            return nil, err
+        }
+        if found {
+            proposals = append(proposals, proposal)
+        }
+    }
+    return proposals, nil
+}
+
+// Propose takes the deposit from proposer, records its proposal at block and
+// schedules the snapshot of its voting power, which leaves out the excluded
+// addresses. The deposit is not refunded. It returns the proposal ID.
+func Propose(db *dbservice.DatabaseService, proposer []byte, title, description string, action json.RawMessage, block int64, params Params, exclude [][]byte) (uint64, error) {
+    if title == "" || len(title) > MaxTitleLength {
+        return 0, fmt.Errorf("%w: the title must be 1 to %d characters", ErrInvalid, MaxTitleLength)
+    }
+    deposit := dbservice.ParseAmount(params.Deposit)
+    if deposit.Sign() > 0 {
+        ok, err := db.Transfer(proposer, Address, deposit)
+        if err != nil {
+            return 0, err
+        }
+        if !ok {
+            return 0, ErrNoDeposit
+        }
+    }
+    count, err := Count(db)
+    if err != nil {
+        return 0, err
+    }
+    id := count + 1
+    proposal := Proposal{
+        ID:          id,
+        Proposer:    hex.EncodeToString(proposer),
+        Title:       title,
+        Description: description,
+        Action:      action,
+        Deposit:     params.Deposit,
+        Block:       block,
+        Snapshot:    SnapshotPrefix + strconv.FormatUint(id, 10),
+        VotingEnds:  block + params.VotingPeriod,
+        Quorum:      params.Quorum,
+        Threshold:   params.Threshold,
+        Yes:         "0",
+        No:          "0",
+        Abstain:     "0",
+    }
+    if err := distribution.Schedule(db, proposal.Snapshot, block+1, block, proposer, exclude); err != nil {
+        return 0, err
+    }
+    if err := db.SaveJSON(proposalKey(id), proposal); err != nil {
+        return 0, err
+    }
+    return id, db.SetData(countKey, []byte(strconv.FormatUint(id, 10)))
+}
+
+// Power returns the voting power of voter on a proposal whose snapshot is taken
+func Power(db *dbservice.DatabaseService, proposal Proposal, voter []byte) (*big.Int, error) {
+    snapshot, found, err := distribution.Lookup(db, proposal.Snapshot)
+    if err != nil {
+        return nil, err
+    }
+    if !found || !snapshot.Taken {
+        return nil, ErrNotStarted
+    }
+    // Holders are sorted by address
+    address := hex.EncodeToString(voter)
+    i := sort.Search(len(snapshot.Holders), func(i int) bool {
+        return snapshot.Holders[i].Address >= address
+    })
+    if i < len(snapshot.Holders) && snapshot.Holders[i].Address == address {
+        return dbservice.ParseAmount(snapshot.Holders[i].Balance), nil
+    }
+    return new(big.Int), nil
+}
+
+// LookupVote returns the vote of voter on a proposal, and false when it has not voted
+func LookupVote(db *dbservice.DatabaseService, id uint64, voter []byte) (Vote, bool, error) {
+    var vote Vote
+    found, err := db.LoadJSON(voteKey(id, voter), &vote)
+    return vote, found, err
+}
+
+// CastVote records the vote of voter on a proposal at block with its snapshot
+// balance. Each account votes once.
+func CastVote(db *dbservice.DatabaseService, voter []byte, id uint64, choice string, block int64) (*big.Int, error) {
+    if choice != Yes && choice != No && choice != Abstain {
+        return nil, fmt.Errorf("%w: the choice must be yes, no or abstain", ErrInvalid)
+    }
+    proposal, found, err := Lookup(db, id)
+    if err != nil {
+        return nil, err
+    }
+    if !found {
+        return nil, ErrNotFound
+    }
+    if block <= proposal.Block {
+        return nil, ErrNotStarted
+    }
+    if block > proposal.VotingEnds {
+        return nil, ErrClosed
+    }
+    if _, voted, err := LookupVote(db, id, voter); err != nil || voted {
+        if voted {
+            return nil, ErrAlreadyVoted
+        }
+        return nil, err
+    }
+    power, err := Power(db, proposal, voter)
+    if err != nil {
+        return nil, err
+    }
+    if power.Sign() == 0 {
+        return nil, ErrNoPower
+    }
+
+    switch choice {
+    case Yes:
+        proposal.Yes = dbservice.ParseAmount(proposal.Yes).Add(dbservice.ParseAmount(proposal.Yes), power).String()
+    case No:
+        proposal.No = dbservice.ParseAmount(proposal.No).Add(dbservice.ParseAmount(proposal.No), power).String()
+    default:
+        proposal.Abstain = dbservice.ParseAmount(proposal.Abstain).Add(dbservice.ParseAmount(proposal.Abstain), power).String()
+    }
+    if err := db.SaveJSON(voteKey(id, voter), Vote{Choice: choice, Power: power.String(), Block: block}); err != nil {
+        return nil, err
+    }
+    return power, db.SaveJSON(proposalKey(id), proposal)
+}
+
+// Status returns the status of a proposal at block
+func Status(db *dbservice.DatabaseService, proposal Proposal, block int64) (string, error) {
+    switch {
+    case proposal.Executed:
+        return StatusExecuted, nil
+    case block <= proposal.Block:
+        return StatusPending, nil
+    case block <= proposal.VotingEnds:
+        return StatusActive, nil
+    }
+    snapshot, found, err := distribution.Lookup(db, proposal.Snapshot)
+    if err != nil || !found {
+        return StatusRejected, err
+    }
+    yes, no := dbservice.ParseAmount(proposal.Yes), dbservice.ParseAmount(proposal.No)
+    voted := new(big.Int).Add(yes, no)
+    voted.Add(voted, dbservice.ParseAmount(proposal.Abstain))
+
+    // voted / total >= quorum / BasisPoints and yes / (yes + no) > threshold / BasisPoints
+    quorum := new(big.Int).Mul(dbservice.ParseAmount(snapshot.Total), big.NewInt(proposal.Quorum))
+    if voted.Mul(voted, big.NewInt(BasisPoints)).Cmp(quorum) < 0 {
+        return StatusRejected, nil
+    }
+    threshold := new(big.Int).Mul(new(big.Int).Add(yes, no), big.NewInt(proposal.Threshold))
+    if new(big.Int).Mul(yes, big.NewInt(BasisPoints)).Cmp(threshold) <= 0 {
+        return StatusRejected, nil
+    }
+    return StatusPassed, nil
+}
+
+// Executable returns a proposal that passed and can be executed at block
+func Executable(db *dbservice.DatabaseService, id uint64, block int64) (Proposal, error) {
+    proposal, found, err := Lookup(db, id)
+    if err != nil {
+        return proposal, err
+    }
+    if !found {
+        return proposal, ErrNotFound
+    }
+    status, err := Status(db, proposal, block)
+    if err != nil {
+        return proposal, err
+    }
+    switch status {
+    case StatusExecuted:
+        return proposal, ErrExecuted
+    case StatusPending, StatusActive, StatusRejected:
+        return proposal, ErrNotPassed
+    }
+    return proposal, nil
+}
+
+// MarkExecuted records that the action of a proposal was applied at block
+func MarkExecuted(db *dbservice.DatabaseService, proposal Proposal, block int64) error {
+    proposal.Executed, proposal.ExecutedAt = true, block
+    return db.SaveJSON(proposalKey(proposal.ID), proposal)
+}
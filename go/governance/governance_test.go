@@ -0,0 +1,258 @@
+package governance
+
+import (
+    "bytes"
+    "errors"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/distribution"
+)
+
+func TestProposeTakesDeposit(t *testing.T) {
+    proposer := bytes.Repeat([]byte{7}, dbservice.AddressLength)
+
+    tests := []struct {
+        name        string
+        balance     int64
+        deposit     string
+        wantErr     error
+        wantBalance int64
+    }{
+        {name: "no deposit", balance: 0, deposit: "", wantBalance: 0},
+        {name: "zero deposit", balance: 5, deposit: "0", wantBalance: 5},
+        {name: "deposit paid", balance: 250, deposit: "100", wantBalance: 150},
+        {name: "whole balance", balance: 100, deposit: "100", wantBalance: 0},
+        {name: "balance below the deposit", balance: 99, deposit: "100", wantErr: ErrNoDeposit, wantBalance: 99},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := dbservice.New("governance")
+            db.SetDir(t.TempDir())
+            defer db.Close()
+            if err := db.SetBalance(proposer, big.NewInt(test.balance)); err != nil {
+                t.Fatal(err)
+            }
+
+            params := Params{Quorum: 2000, Threshold: 5000, VotingPeriod: 10, Deposit: test.deposit}
+            id, err := Propose(db, proposer, "raise the quorum", "", nil, 40, params, nil)
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("Propose error = %v, want %v", err, test.wantErr)
+            }
+
+            balance, err := db.GetBalance(proposer)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if balance.Int64() != test.wantBalance {
+                t.Errorf("proposer balance = %s, want %d", balance, test.wantBalance)
+            }
+            collected, err := db.GetBalance(Address)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if want := test.balance - test.wantBalance; collected.Int64() != want {
+                t.Errorf("governance account = %s, want %d", collected, want)
+            }
+
+            count, err := Count(db)
+            if err != nil {
+                t.Fatal(err)
+            }
+            _, scheduled, err := distribution.Lookup(db, SnapshotPrefix+"1")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if test.wantErr != nil {
+                if count != 0 || scheduled {
+                    t.Errorf("rejected proposal left %d proposals and snapshot scheduled %v", count, scheduled)
+                }
+                return
+            }
+            if id != 1 || count != 1 || !scheduled {
+                t.Errorf("proposal %d of %d, snapshot scheduled %v, want proposal 1 of 1 with a snapshot", id, count, scheduled)
+            }
+        })
+    }
+}
+
+func TestParamsValidate(t *testing.T) {
+    tests := []struct {
+        name   string
+        params Params
+        valid  bool
+    }{
+        {name: "defaults", params: Params{Quorum: 2000, Threshold: 5000, VotingPeriod: 50400}, valid: true},
+        {name: "deposit", params: Params{Quorum: 0, Threshold: 0, VotingPeriod: 1, Deposit: "1000000000000"}, valid: true},
+        {name: "full quorum", params: Params{Quorum: BasisPoints, Threshold: BasisPoints - 1, VotingPeriod: 1}, valid: true},
+        {name: "negative deposit", params: Params{Quorum: 2000, Threshold: 5000, VotingPeriod: 10, Deposit: "-1"}},
+        {name: "fractional deposit", params: Params{Quorum: 2000, Threshold: 5000, VotingPeriod: 10, Deposit: "0.5"}},
+        {name: "threshold of every vote", params: Params{Quorum: 2000, Threshold: BasisPoints, VotingPeriod: 10}},
+        {name: "quorum above every vote", params: Params{Quorum: BasisPoints + 1, Threshold: 5000, VotingPeriod: 10}},
+        {name: "no voting period", params: Params{Quorum: 2000, Threshold: 5000}},
+    }
+    for _, test := range tests {
+        err := test.params.Validate()
+        if (err == nil) != test.valid {
+            t.Errorf("%s: Validate() = %v, want valid %v", test.name, err, test.valid)
+        }
+        if err != nil && !errors.Is(err, ErrInvalid) {
+            t.Errorf("%s: Validate() = %v, want ErrInvalid", test.name, err)
+        }
+    }
+}
+
+// holder returns the address of the nth test account
+func holder(n byte) []byte {
+    return bytes.Repeat([]byte{n}, dbservice.AddressLength)
+}
+
+// openProposal makes proposal 1 at block 10 with a voting period of 5 blocks over
+// accounts holding balances, and takes its snapshot
+func openProposal(t *testing.T, balances map[byte]int64, params Params) *dbservice.DatabaseService {
+    t.Helper()
+    db := dbservice.New("governance")
+    db.SetDir(t.TempDir())
+    t.Cleanup(func() { db.Close() })
+    for n, balance := range balances {
+        if err := db.SetBalance(holder(n), big.NewInt(balance)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    params.VotingPeriod = 5
+    if _, err := Propose(db, holder(1), "fund the bridge", "", nil, 10, params, nil); err != nil {
+        t.Fatal(err)
+    }
+    if err := distribution.BeginBlock(db, 11); err != nil {
+        t.Fatal(err)
+    }
+    return db
+}
+
+func TestCastVote(t *testing.T) {
+    balances := map[byte]int64{1: 60, 2: 30, 3: 10}
+
+    tests := []struct {
+        name string
+        // voted are the accounts that voted yes before
+        voted     []byte
+        voter     byte
+        id        uint64
+        choice    string
+        block     int64
+        wantPower int64
+        wantErr   error
+    }{
+        {name: "yes", voter: 1, id: 1, choice: Yes, block: 11, wantPower: 60},
+        {name: "abstain on the last block", voter: 3, id: 1, choice: Abstain, block: 15, wantPower: 10},
+        {name: "second vote", voted: []byte{2}, voter: 2, id: 1, choice: No, block: 12, wantErr: ErrAlreadyVoted},
+        {name: "in the proposal block", voter: 2, id: 1, choice: No, block: 10, wantErr: ErrNotStarted},
+        {name: "after voting ends", voter: 2, id: 1, choice: Yes, block: 16, wantErr: ErrClosed},
+        {name: "not a holder", voter: 9, id: 1, choice: Yes, block: 12, wantErr: ErrNoPower},
+        {name: "unknown proposal", voter: 1, id: 2, choice: Yes, block: 12, wantErr: ErrNotFound},
+        {name: "unknown choice", voter: 1, id: 1, choice: "maybe", block: 12, wantErr: ErrInvalid},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := openProposal(t, balances, Params{Quorum: 2000, Threshold: 5000})
+            for _, n := range test.voted {
+                if _, err := CastVote(db, holder(n), 1, Yes, 11); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            power, err := CastVote(db, holder(test.voter), test.id, test.choice, test.block)
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("CastVote error = %v, want %v", err, test.wantErr)
+            }
+            if test.wantErr != nil {
+                return
+            }
+            if power.Int64() != test.wantPower {
+                t.Errorf("power = %s, want %d", power, test.wantPower)
+            }
+            vote, found, err := LookupVote(db, test.id, holder(test.voter))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !found || vote.Choice != test.choice || vote.Power != power.String() {
+                t.Errorf("recorded vote = %+v (found %v), want %s with %s", vote, found, test.choice, power)
+            }
+        })
+    }
+}
+
+func TestPowerIgnoresLaterTransfers(t *testing.T) {
+    db := openProposal(t, map[byte]int64{1: 50, 2: 40}, Params{Quorum: 2000, Threshold: 5000})
+    // Tokens moved after the snapshot vote only with their old holder
+    if _, err := db.Transfer(holder(2), holder(4), big.NewInt(40)); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := CastVote(db, holder(4), 1, Yes, 12); !errors.Is(err, ErrNoPower) {
+        t.Errorf("vote of the new holder: error = %v, want %v", err, ErrNoPower)
+    }
+    power, err := CastVote(db, holder(2), 1, Yes, 12)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if power.Int64() != 40 {
+        t.Errorf("power of the old holder = %s, want 40", power)
+    }
+}
+
+func TestStatus(t *testing.T) {
+    balances := map[byte]int64{1: 50, 2: 30, 3: 20}
+
+    tests := []struct {
+        name      string
+        quorum    int64
+        threshold int64
+        votes     map[byte]string
+        block     int64
+        executed  bool
+        want      string
+    }{
+        {name: "proposal block", quorum: 2000, threshold: 5000, block: 10, want: StatusPending},
+        {name: "voting", quorum: 2000, threshold: 5000, votes: map[byte]string{1: Yes}, block: 15, want: StatusActive},
+        {name: "majority", quorum: 2000, threshold: 5000, votes: map[byte]string{1: Yes, 2: No}, block: 16, want: StatusPassed},
+        {name: "quorum exactly reached", quorum: 2000, threshold: 5000, votes: map[byte]string{3: Yes}, block: 16, want: StatusPassed},
+        {name: "below the quorum", quorum: 2500, threshold: 5000, votes: map[byte]string{3: Yes}, block: 16, want: StatusRejected},
+        {name: "abstentions count to the quorum", quorum: 4000, threshold: 5000, votes: map[byte]string{2: Abstain, 3: Yes}, block: 16, want: StatusPassed},
+        {name: "tie", quorum: 2000, threshold: 5000, votes: map[byte]string{1: No, 2: Yes, 3: Yes}, block: 16, want: StatusRejected},
+        {name: "supermajority missed", quorum: 2000, threshold: 6667, votes: map[byte]string{2: Yes, 3: No}, block: 20, want: StatusRejected},
+        {name: "no votes", quorum: 0, threshold: 0, block: 16, want: StatusRejected},
+        {name: "executed", quorum: 2000, threshold: 5000, votes: map[byte]string{1: Yes}, block: 16, executed: true, want: StatusExecuted},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := openProposal(t, balances, Params{Quorum: test.quorum, Threshold: test.threshold})
+            for n, choice := range test.votes {
+                if _, err := CastVote(db, holder(n), 1, choice, 11); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if test.executed {
+                proposal, err := Executable(db, 1, test.block)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if err := MarkExecuted(db, proposal, test.block); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            proposal, _, err := Lookup(db, 1)
+            if err != nil {
+                t.Fatal(err)
+            }
+            status, err := Status(db, proposal, test.block)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if status != test.want {
+                t.Errorf("Status at block %d = %s, want %s", test.block, status, test.want)
+            }
+        })
+    }
+}
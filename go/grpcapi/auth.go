@@ -0,0 +1,160 @@
+package grpcapi
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "net"
+    "os"
+    "time"
+
+    "pwr-stateful-vida/api"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/grpcapi/vidapb"
+    "pwr-stateful-vida/metrics"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+)
+
+// maxRequestBytes bounds a request message; every request is a few short fields
+const maxRequestBytes = 16 << 10
+
+// peerMethods are the calls validators compare root hashes with, guarded like the
+// peer endpoints of the HTTP API
+var peerMethods = map[string]bool{
+    vidapb.VidaState_GetRootHash_FullMethodName: true,
+}
+
+// ServerOptions returns the options of the gRPC server: TLS when configured, the
+// limits of the configuration and interceptors applying the access rules of the
+// HTTP API to every call
+func ServerOptions(cfg config.GRPCConfig) ([]grpc.ServerOption, error) {
+    options := []grpc.ServerOption{
+        grpc.UnaryInterceptor(authorizeUnary),
+        grpc.StreamInterceptor(authorizeStream),
+        grpc.MaxRecvMsgSize(maxRequestBytes),
+        grpc.ConnectionTimeout(10 * time.Second),
+    }
+    if cfg.MaxConcurrentStreams > 0 {
+        options = append(options, grpc.MaxConcurrentStreams(uint32(cfg.MaxConcurrentStreams)))
+    }
+    tlsConfig, err := api.ServerTLS(cfg.TLS)
+    if err != nil {
+        return nil, err
+    }
+    if tlsConfig != nil {
+        options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+    }
+    return options, nil
+}
+
+func authorizeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+    if err := authorize(ctx, info.FullMethod); err != nil {
+        return nil, err
+    }
+    return handler(ctx, req)
+}
+
+func authorizeStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+    if err := authorize(stream.Context(), info.FullMethod); err != nil {
+        return err
+    }
+    return handler(srv, stream)
+}
+
+// authorize requires the reader role from the authorization metadata of a call, like
+// the HTTP API. Peer calls are also refused by auditors, replicas and standbys,
+// require a validator certificate when a client CA is configured and take from the
+// request budget of the client IP.
+func authorize(ctx context.Context, method string) error {
+    var authorization string
+    if md, ok := metadata.FromIncomingContext(ctx); ok {
+        if values := md.Get("authorization"); len(values) > 0 {
+            authorization = values[0]
+        }
+    }
+    role, err := api.Authorize(authorization)
+    if err != nil {
+        return status.Error(codes.Unauthenticated, err.Error())
+    }
+    if role < api.RoleReader {
+        return status.Error(codes.Unauthenticated, "credentials required")
+    }
+    if !peerMethods[method] {
+        return nil
+    }
+
+    if refusal := api.PeerRefusal(); refusal != "" {
+        return status.Error(codes.Unimplemented, refusal)
+    }
+    client, _ := peer.FromContext(ctx)
+    if config.Get().GRPC.TLS.ClientCAFile != "" && !verifiedClient(client) {
+        return status.Error(codes.PermissionDenied, "a validator client certificate is required")
+    }
+    if allowed, wait := api.AllowPeer(clientIP(client)); !allowed {
+        metrics.ThrottledRequests.Inc(method)
+        return status.Errorf(codes.ResourceExhausted, "request budget exceeded, retry in %s", wait.Round(time.Millisecond))
+    }
+    return nil
+}
+
+// verifiedClient reports whether the client presented a certificate the client CA signed
+func verifiedClient(client *peer.Peer) bool {
+    if client == nil {
+        return false
+    }
+    info, ok := client.AuthInfo.(credentials.TLSInfo)
+    return ok && len(info.State.VerifiedChains) > 0
+}
+
+// clientIP returns the IP of the connection of a call
+func clientIP(client *peer.Peer) string {
+    if client == nil || client.Addr == nil {
+        return ""
+    }
+    host, _, err := net.SplitHostPort(client.Addr.String())
+    if err != nil {
+        return client.Addr.String()
+    }
+    return host
+}
+
+// bearerToken sends an API key or JWT with every call
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+    return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+    return false
+}
+
+// Dial connects to the gRPC API of a node, over TLS verified with the PEM bundle
+// caFile when it is set, sending token as the bearer credentials when it is set
+func Dial(address, token, caFile string) (*grpc.ClientConn, error) {
+    transport := insecure.NewCredentials()
+    if caFile != "" {
+        pem, err := os.ReadFile(caFile)
+        if err != nil {
+            return nil, err
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("%s holds no PEM certificates", caFile)
+        }
+        transport = credentials.NewTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+    }
+    options := []grpc.DialOption{grpc.WithTransportCredentials(transport)}
+    if token != "" {
+        options = append(options, grpc.WithPerRPCCredentials(bearerToken(token)))
+    }
+    return grpc.NewClient(address, options...)
+}
@@ -0,0 +1,149 @@
+package grpcapi
+
+import (
+    "context"
+    "net"
+    "testing"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/grpcapi/vidapb"
+    "pwr-stateful-vida/testkit"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/grpc/status"
+)
+
+// useConfig installs the default configuration changed by edit for one test
+func useConfig(t *testing.T, edit func(cfg *config.Config)) {
+    previous := config.Get()
+    cfg, err := config.Load("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    cfg.Auth.APIKeys = map[string]string{"reader-key": "reader"}
+    edit(cfg)
+    config.Set(cfg)
+    t.Cleanup(func() { config.Set(previous) })
+}
+
+func TestAuthorize(t *testing.T) {
+    tests := []struct {
+        name   string
+        config func(cfg *config.Config)
+        method string
+        token  string
+        calls  int
+        want   codes.Code
+    }{
+        {
+            name:   "anonymous reader",
+            config: func(cfg *config.Config) {},
+            method: vidapb.VidaState_GetStatus_FullMethodName,
+            want:   codes.OK,
+        },
+        {
+            name:   "credentials required",
+            config: func(cfg *config.Config) { cfg.Auth.AnonymousRole = "" },
+            method: vidapb.VidaState_GetStatus_FullMethodName,
+            want:   codes.Unauthenticated,
+        },
+        {
+            name:   "API key",
+            config: func(cfg *config.Config) { cfg.Auth.AnonymousRole = "" },
+            method: vidapb.VidaState_WatchRootHashes_FullMethodName,
+            token:  "reader-key",
+            want:   codes.OK,
+        },
+        {
+            name:   "invalid API key",
+            config: func(cfg *config.Config) {},
+            method: vidapb.VidaState_GetStatus_FullMethodName,
+            token:  "wrong-key",
+            want:   codes.Unauthenticated,
+        },
+        {
+            name:   "replica refuses peers",
+            config: func(cfg *config.Config) { cfg.Replica.Primary = "http://primary:8080" },
+            method: vidapb.VidaState_GetRootHash_FullMethodName,
+            want:   codes.Unimplemented,
+        },
+        {
+            name:   "replica serves clients",
+            config: func(cfg *config.Config) { cfg.Replica.Primary = "http://primary:8080" },
+            method: vidapb.VidaState_GetStatus_FullMethodName,
+            want:   codes.OK,
+        },
+        {
+            name:   "validator certificate required",
+            config: func(cfg *config.Config) { cfg.GRPC.TLS.ClientCAFile = "validators.pem" },
+            method: vidapb.VidaState_GetRootHash_FullMethodName,
+            want:   codes.PermissionDenied,
+        },
+        {
+            name: "peer budget exceeded",
+            config: func(cfg *config.Config) {
+                cfg.HTTP.PeerRequestsPerSecond = 0.001
+                cfg.HTTP.PeerBurst = 1
+            },
+            method: vidapb.VidaState_GetRootHash_FullMethodName,
+            calls:  2,
+            want:   codes.ResourceExhausted,
+        },
+    }
+    for i, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            useConfig(t, test.config)
+            ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, byte(i+1)), Port: 40000}})
+            if test.token != "" {
+                ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+test.token))
+            }
+            var err error
+            for call := 0; call < max(test.calls, 1); call++ {
+                err = authorize(ctx, test.method)
+            }
+            if got := status.Code(err); got != test.want {
+                t.Errorf("code = %s, want %s (%v)", got, test.want, err)
+            }
+        })
+    }
+}
+
+func TestServerOptions(t *testing.T) {
+    useConfig(t, func(cfg *config.Config) { cfg.Auth.AnonymousRole = "" })
+    testkit.UseMemoryTree()
+
+    options, err := ServerOptions(config.Get().GRPC)
+    if err != nil {
+        t.Fatal(err)
+    }
+    server := grpc.NewServer(options...)
+    RegisterServices(server, dbservice.Default(), nil)
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    go server.Serve(listener)
+    defer server.Stop()
+
+    for _, test := range []struct {
+        token string
+        want  codes.Code
+    }{
+        {token: "", want: codes.Unauthenticated},
+        {token: "reader-key", want: codes.OK},
+    } {
+        conn, err := Dial(listener.Addr().String(), test.token, "")
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = vidapb.NewVidaStateClient(conn).GetStatus(context.Background(), &vidapb.GetStatusRequest{})
+        conn.Close()
+        if got := status.Code(err); got != test.want {
+            t.Errorf("token %q: code = %s, want %s (%v)", test.token, got, test.want, err)
+        }
+    }
+}
@@ -0,0 +1,156 @@
+package grpcapi
+
+//go:generate protoc -I proto --go_out=vidapb --go_opt=paths=source_relative --go-grpc_out=vidapb --go-grpc_opt=paths=source_relative proto/vida.proto
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "strings"
+    "time"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/events"
+    "pwr-stateful-vida/grpcapi/vidapb"
+    "pwr-stateful-vida/signing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+)
+
+type server struct {
+    vidapb.UnimplementedVidaStateServer
+    db    *dbservice.DatabaseService
+    peers []string
+}
+
+// RegisterServices registers the VIDA state service of db on the given gRPC server
+func RegisterServices(s *grpc.Server, db *dbservice.DatabaseService, peers []string) {
+    vidapb.RegisterVidaStateServer(s, &server{db: db, peers: peers})
+}
+
+// GetBalance returns the balance stored for the requested address, following the
+// same rules as GET /balance
+func (s *server) GetBalance(ctx context.Context, req *vidapb.GetBalanceRequest) (*vidapb.GetBalanceResponse, error) {
+    address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(req.GetAddress()), "0x"))
+    if err != nil || len(address) != dbservice.AddressLength {
+        return nil, status.Error(codes.InvalidArgument, "invalid address")
+    }
+
+    balance, err := s.db.CommittedBalanceContext(ctx, address)
+    if err != nil {
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+
+    return signed(ctx, &vidapb.GetBalanceResponse{Address: req.GetAddress(), Balance: balance.String()})
+}
+
+// GetRootHash returns the root hash for a block, following the same rules as GET /rootHash
+func (s *server) GetRootHash(ctx context.Context, req *vidapb.GetRootHashRequest) (*vidapb.GetRootHashResponse, error) {
+    blockNumber := req.GetBlockNumber()
+    lastCheckedBlock, checkpointRoot, _ := s.db.CheckpointRootHashContext(ctx)
+
+    if blockNumber == lastCheckedBlock {
+        if checkpointRoot != nil {
+            return signed(ctx, &vidapb.GetRootHashResponse{BlockNumber: blockNumber, RootHash: checkpointRoot})
+        }
+    } else if blockNumber < lastCheckedBlock && blockNumber > 1 {
+        if blockRootHash, _ := s.db.GetBlockRootHash(blockNumber); blockRootHash != nil {
+            return signed(ctx, &vidapb.GetRootHashResponse{BlockNumber: blockNumber, RootHash: blockRootHash})
+        }
+        return nil, status.Errorf(codes.NotFound, "block root hash not found for block number: %d", blockNumber)
+    }
+
+    return nil, status.Error(codes.InvalidArgument, "invalid block number")
+}
+
+// signed sends the signature of a response in the header metadata when a node key is
+// configured. The signature covers the full method name and the deterministic
+// protobuf encoding of the response.
+func signed[T proto.Message](ctx context.Context, response T) (T, error) {
+    if !signing.Enabled() {
+        return response, nil
+    }
+    method, _ := grpc.Method(ctx)
+    body, err := proto.MarshalOptions{Deterministic: true}.Marshal(response)
+    if err != nil {
+        return response, status.Error(codes.Internal, err.Error())
+    }
+    if signature, ok := signing.Sign(method, body, time.Now()); ok {
+        header := metadata.Pairs(
+            strings.ToLower(signing.SignatureHeader), signature.Signature,
+            strings.ToLower(signing.TimestampHeader), signature.Timestamp,
+            strings.ToLower(signing.KeyHeader), signature.Key,
+        )
+        if signature.PreviousSignature != "" {
+            header.Set(strings.ToLower(signing.PreviousSignatureHeader), signature.PreviousSignature)
+            header.Set(strings.ToLower(signing.PreviousKeyHeader), signature.PreviousKey)
+        }
+        grpc.SetHeader(ctx, header)
+    }
+    return response, nil
+}
+
+// GetProof returns the proof of a key after the last completed block, which
+// verifier.Proof checks against the root hash it carries
+func (s *server) GetProof(ctx context.Context, req *vidapb.GetProofRequest) (*vidapb.GetProofResponse, error) {
+    if len(req.GetKey()) == 0 {
+        return nil, status.Error(codes.InvalidArgument, "invalid key")
+    }
+
+    proof, err := s.db.CommittedProofContext(ctx, req.GetKey())
+    switch {
+    case errors.Is(err, dbservice.ErrNoProofs):
+        return nil, status.Error(codes.Unimplemented, err.Error())
+    case err != nil:
+        return nil, status.Error(codes.Internal, err.Error())
+    case proof == nil:
+        return nil, status.Error(codes.NotFound, "key not found")
+    }
+
+    response := &vidapb.GetProofResponse{Key: proof.Key, Value: proof.Value, RootHash: proof.RootHash}
+    for _, step := range proof.Steps {
+        response.Steps = append(response.Steps, &vidapb.ProofStep{Sibling: step.Sibling, Left: step.Left})
+    }
+    return signed(ctx, response)
+}
+
+// GetStatus returns the current checkpoint and root hash
+func (s *server) GetStatus(ctx context.Context, req *vidapb.GetStatusRequest) (*vidapb.GetStatusResponse, error) {
+    lastCheckedBlock, rootHash, err := s.db.CheckpointRootHashContext(ctx)
+    if err != nil {
+        return nil, status.Error(codes.Internal, err.Error())
+    }
+
+    return &vidapb.GetStatusResponse{
+        LastCheckedBlock: lastCheckedBlock,
+        RootHash:         rootHash,
+        Peers:            int32(len(s.peers)),
+    }, nil
+}
+
+// WatchRootHashes streams the root hash of each validated checkpoint
+func (s *server) WatchRootHashes(req *vidapb.WatchRootHashesRequest, stream vidapb.VidaState_WatchRootHashesServer) error {
+    ch, cancel := events.SubscribeRoots()
+    defer cancel()
+
+    for {
+        select {
+        case <-stream.Context().Done():
+            return nil
+        case event, ok := <-ch:
+            if !ok {
+                return nil
+            }
+            if !event.Validated {
+                continue
+            }
+            if err := stream.Send(&vidapb.RootHashEvent{BlockNumber: event.BlockNumber, RootHash: event.RootHash}); err != nil {
+                return err
+            }
+        }
+    }
+}
@@ -0,0 +1,57 @@
+package grpcapi
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "math/big"
+    "strings"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/grpcapi/vidapb"
+    "pwr-stateful-vida/testkit"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestGetBalanceAddresses(t *testing.T) {
+    db := dbservice.New("grpcapi")
+    db.UseTree(testkit.NewMemoryTree())
+    funded := bytes.Repeat([]byte{0xab}, dbservice.AddressLength)
+    if err := db.SetBalance(funded, big.NewInt(42)); err != nil {
+        t.Fatal(err)
+    }
+    if err := db.Flush(); err != nil {
+        t.Fatal(err)
+    }
+    s := &server{db: db}
+    address := hex.EncodeToString(funded)
+
+    tests := []struct {
+        name     string
+        address  string
+        wantCode codes.Code
+        want     string
+    }{
+        {name: "address", address: address, want: "42"},
+        {name: "prefixed upper case", address: "0X" + strings.ToUpper(address), want: "42"},
+        {name: "unfunded", address: strings.Repeat("01", dbservice.AddressLength), want: "0"},
+        {name: "empty", address: "", wantCode: codes.InvalidArgument},
+        {name: "state key", address: hex.EncodeToString([]byte("holders/count")), wantCode: codes.InvalidArgument},
+        {name: "long", address: address + "00", wantCode: codes.InvalidArgument},
+        {name: "not hex", address: "carol", wantCode: codes.InvalidArgument},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            response, err := s.GetBalance(context.Background(), &vidapb.GetBalanceRequest{Address: test.address})
+            if code := status.Code(err); code != test.wantCode {
+                t.Fatalf("GetBalance error = %v, want code %v", err, test.wantCode)
+            }
+            if err == nil && response.GetBalance() != test.want {
+                t.Errorf("balance = %s, want %s", response.GetBalance(), test.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,291 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: vida.proto
+
+package vidapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	VidaState_GetBalance_FullMethodName      = "/vida.v1.VidaState/GetBalance"
+	VidaState_GetRootHash_FullMethodName     = "/vida.v1.VidaState/GetRootHash"
+	VidaState_GetProof_FullMethodName        = "/vida.v1.VidaState/GetProof"
+	VidaState_GetStatus_FullMethodName       = "/vida.v1.VidaState/GetStatus"
+	VidaState_WatchRootHashes_FullMethodName = "/vida.v1.VidaState/WatchRootHashes"
+)
+
+// VidaStateClient is the client API for VidaState service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// VidaState exposes the read side of the stateful VIDA node.
+type VidaStateClient interface {
+	// GetBalance returns the balance stored for an address.
+	GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error)
+	// GetRootHash returns the Merkle root hash for a block.
+	GetRootHash(ctx context.Context, in *GetRootHashRequest, opts ...grpc.CallOption) (*GetRootHashResponse, error)
+	// GetProof returns a Merkle inclusion proof for a key.
+	GetProof(ctx context.Context, in *GetProofRequest, opts ...grpc.CallOption) (*GetProofResponse, error)
+	// GetStatus returns the synchronization status of the node.
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error)
+	// WatchRootHashes streams the root hash of every validated checkpoint.
+	WatchRootHashes(ctx context.Context, in *WatchRootHashesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RootHashEvent], error)
+}
+
+type vidaStateClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVidaStateClient(cc grpc.ClientConnInterface) VidaStateClient {
+	return &vidaStateClient{cc}
+}
+
+func (c *vidaStateClient) GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetBalanceResponse)
+	err := c.cc.Invoke(ctx, VidaState_GetBalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vidaStateClient) GetRootHash(ctx context.Context, in *GetRootHashRequest, opts ...grpc.CallOption) (*GetRootHashResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetRootHashResponse)
+	err := c.cc.Invoke(ctx, VidaState_GetRootHash_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vidaStateClient) GetProof(ctx context.Context, in *GetProofRequest, opts ...grpc.CallOption) (*GetProofResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetProofResponse)
+	err := c.cc.Invoke(ctx, VidaState_GetProof_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vidaStateClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStatusResponse)
+	err := c.cc.Invoke(ctx, VidaState_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vidaStateClient) WatchRootHashes(ctx context.Context, in *WatchRootHashesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RootHashEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &VidaState_ServiceDesc.Streams[0], VidaState_WatchRootHashes_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRootHashesRequest, RootHashEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VidaState_WatchRootHashesClient = grpc.ServerStreamingClient[RootHashEvent]
+
+// VidaStateServer is the server API for VidaState service.
+// All implementations must embed UnimplementedVidaStateServer
+// for forward compatibility.
+//
+// VidaState exposes the read side of the stateful VIDA node.
+type VidaStateServer interface {
+	// GetBalance returns the balance stored for an address.
+	GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error)
+	// GetRootHash returns the Merkle root hash for a block.
+	GetRootHash(context.Context, *GetRootHashRequest) (*GetRootHashResponse, error)
+	// GetProof returns a Merkle inclusion proof for a key.
+	GetProof(context.Context, *GetProofRequest) (*GetProofResponse, error)
+	// GetStatus returns the synchronization status of the node.
+	GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error)
+	// WatchRootHashes streams the root hash of every validated checkpoint.
+	WatchRootHashes(*WatchRootHashesRequest, grpc.ServerStreamingServer[RootHashEvent]) error
+	mustEmbedUnimplementedVidaStateServer()
+}
+
+// UnimplementedVidaStateServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedVidaStateServer struct{}
+
+func (UnimplementedVidaStateServer) GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBalance not implemented")
+}
+func (UnimplementedVidaStateServer) GetRootHash(context.Context, *GetRootHashRequest) (*GetRootHashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRootHash not implemented")
+}
+func (UnimplementedVidaStateServer) GetProof(context.Context, *GetProofRequest) (*GetProofResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetProof not implemented")
+}
+func (UnimplementedVidaStateServer) GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedVidaStateServer) WatchRootHashes(*WatchRootHashesRequest, grpc.ServerStreamingServer[RootHashEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchRootHashes not implemented")
+}
+func (UnimplementedVidaStateServer) mustEmbedUnimplementedVidaStateServer() {}
+func (UnimplementedVidaStateServer) testEmbeddedByValue()                   {}
+
+// UnsafeVidaStateServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VidaStateServer will
+// result in compilation errors.
+type UnsafeVidaStateServer interface {
+	mustEmbedUnimplementedVidaStateServer()
+}
+
+func RegisterVidaStateServer(s grpc.ServiceRegistrar, srv VidaStateServer) {
+	// If the following call pancis, it indicates UnimplementedVidaStateServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&VidaState_ServiceDesc, srv)
+}
+
+func _VidaState_GetBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VidaStateServer).GetBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VidaState_GetBalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VidaStateServer).GetBalance(ctx, req.(*GetBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VidaState_GetRootHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRootHashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VidaStateServer).GetRootHash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VidaState_GetRootHash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VidaStateServer).GetRootHash(ctx, req.(*GetRootHashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VidaState_GetProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetProofRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VidaStateServer).GetProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VidaState_GetProof_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VidaStateServer).GetProof(ctx, req.(*GetProofRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VidaState_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VidaStateServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VidaState_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VidaStateServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VidaState_WatchRootHashes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRootHashesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(VidaStateServer).WatchRootHashes(m, &grpc.GenericServerStream[WatchRootHashesRequest, RootHashEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type VidaState_WatchRootHashesServer = grpc.ServerStreamingServer[RootHashEvent]
+
+// VidaState_ServiceDesc is the grpc.ServiceDesc for VidaState service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VidaState_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "vida.v1.VidaState",
+	HandlerType: (*VidaStateServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetBalance",
+			Handler:    _VidaState_GetBalance_Handler,
+		},
+		{
+			MethodName: "GetRootHash",
+			Handler:    _VidaState_GetRootHash_Handler,
+		},
+		{
+			MethodName: "GetProof",
+			Handler:    _VidaState_GetProof_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _VidaState_GetStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRootHashes",
+			Handler:       _VidaState_WatchRootHashes_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "vida.proto",
+}
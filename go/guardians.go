@@ -0,0 +1,201 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/hex"
+    "errors"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/recovery"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons a recovery action is rejected
+const (
+    failureRecoveryNotFound = "recovery_not_found"
+    failureRecoveryPending  = "recovery_pending"
+)
+
+// reportRecoveryError reports an unexpected error reading or writing recovery state
+func reportRecoveryError(ctx context.Context, err error, action string, account []byte) {
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"account": hex.EncodeToString(account)},
+    })
+}
+
+// actingAccount returns the transaction as sent by the account it acts for. The
+// controller of an account acts for it by naming it in "onBehalfOf", and an account
+// whose control a recovery moved can no longer act itself.
+func (a *App) actingAccount(ctx context.Context, transaction rpc.VidaDataTransaction, onBehalfOf []byte) (rpc.VidaDataTransaction, string) {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return transaction, ""
+    }
+    account := sender
+    if onBehalfOf != nil {
+        account = onBehalfOf
+    }
+    controller, err := recovery.Controller(a.db, account)
+    if err != nil {
+        reportRecoveryError(ctx, err, "onBehalfOf", account)
+        return transaction, failureInvalidPayload
+    }
+    if !bytes.Equal(controller, sender) {
+        syncLogger.WarnContext(ctx, "transaction from an address that does not control the account",
+            "sender", transaction.Sender, "account", hex.EncodeToString(account))
+        return transaction, failureUnauthorized
+    }
+    transaction.Sender = "0x" + hex.EncodeToString(account)
+    return transaction, ""
+}
+
+// guardiansPayload is the payload of a guardians action
+type guardiansPayload struct {
+    envelope
+    Guardians []string `json:"guardians"`
+    Threshold number   `json:"threshold"`
+    Delay     number   `json:"delay"`
+
+    guardians [][]byte
+    threshold int64
+    delay     int64
+}
+
+func (p *guardiansPayload) validate(a *App) error {
+    for _, raw := range p.Guardians {
+        guardian := payloadAddress(raw)
+        if guardian == nil {
+            return invalidField("guardians", "must be addresses")
+        }
+        p.guardians = append(p.guardians, guardian)
+    }
+    var ok bool
+    if p.Threshold.set() {
+        if p.threshold, ok = payloadInt(p.Threshold); !ok {
+            return invalidField("threshold", "must be a non-negative integer")
+        }
+    }
+    if p.Delay.set() {
+        if p.delay, ok = payloadInt(p.Delay); !ok {
+            return invalidField("delay", "must be a non-negative integer")
+        }
+    }
+    return nil
+}
+
+// recoverPayload is the payload of a recover approval
+type recoverPayload struct {
+    envelope
+    Account    string `json:"account"`
+    Controller string `json:"controller"`
+
+    account    []byte
+    controller []byte
+}
+
+func (p *recoverPayload) validate(a *App) error {
+    if p.account = payloadAddress(p.Account); p.account == nil {
+        return invalidField("account", "must be an address")
+    }
+    if p.controller = payloadAddress(p.Controller); p.controller == nil {
+        return invalidField("controller", "must be an address")
+    }
+    return nil
+}
+
+// completeRecoveryPayload is the payload of a completeRecovery
+type completeRecoveryPayload struct {
+    envelope
+    Account string `json:"account"`
+
+    account []byte
+}
+
+func (p *completeRecoveryPayload) validate(a *App) error {
+    if p.account = payloadAddress(p.Account); p.account == nil {
+        return invalidField("account", "must be an address")
+    }
+    return nil
+}
+
+// handleGuardians sets the guardians of the sender. It returns the reason the
+// action was rejected, or an empty string on success.
+func (a *App) handleGuardians(ctx context.Context, p *guardiansPayload, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    err := recovery.SetGuardians(a.db, sender, p.guardians, int(p.threshold), p.delay)
+    if err != nil {
+        return recoveryFailure(ctx, err, "guardians", p, transaction.Sender, sender)
+    }
+    syncLogger.InfoContext(ctx, "guardians set", "guardians", len(p.guardians), "threshold", p.threshold, "delay", p.delay, "sender", transaction.Sender)
+    return ""
+}
+
+// handleRecover applies a recover approval from a guardian. It returns the reason
+// the approval was rejected, or an empty string on success.
+func (a *App) handleRecover(ctx context.Context, p *recoverPayload, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    request, err := recovery.Approve(a.db, sender, p.account, p.controller, int64(transaction.BlockNumber))
+    if err != nil {
+        return recoveryFailure(ctx, err, "recover", p, transaction.Sender, p.account)
+    }
+    syncLogger.InfoContext(ctx, "recovery approved", "account", request.Account, "controller", request.Controller,
+        "approvals", len(request.Approvals), "readyAt", request.ReadyAt, "sender", transaction.Sender)
+    return ""
+}
+
+// handleCancelRecovery cancels a recovery of the sender's account. It returns the
+// reason the cancellation was rejected, or an empty string on success.
+func (a *App) handleCancelRecovery(ctx context.Context, p *envelope, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if err := recovery.Cancel(a.db, sender); err != nil {
+        return recoveryFailure(ctx, err, "cancelRecovery", p, transaction.Sender, sender)
+    }
+    syncLogger.InfoContext(ctx, "recovery cancelled", "sender", transaction.Sender)
+    return ""
+}
+
+// handleCompleteRecovery moves control of an account to its new controller once the
+// delay of the recovery has passed. Anyone may send it. It returns the reason the
+// action was rejected, or an empty string on success.
+func (a *App) handleCompleteRecovery(ctx context.Context, p *completeRecoveryPayload, transaction rpc.VidaDataTransaction) string {
+    controller, err := recovery.Complete(a.db, p.account, int64(transaction.BlockNumber))
+    if err != nil {
+        return recoveryFailure(ctx, err, "completeRecovery", p, transaction.Sender, p.account)
+    }
+    syncLogger.InfoContext(ctx, "account recovered", "account", hex.EncodeToString(p.account), "controller", hex.EncodeToString(controller), "sender", transaction.Sender)
+    return ""
+}
+
+// recoveryFailure maps an error of the recovery package to the reason a transaction
+// is rejected
+func recoveryFailure(ctx context.Context, err error, action string, payload actionPayload, senderHex string, account []byte) string {
+    switch {
+    case errors.Is(err, recovery.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid "+action, "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, recovery.ErrNotGuardian):
+        syncLogger.WarnContext(ctx, "recover from an address that is not a guardian", "sender", senderHex)
+        return failureUnauthorized
+    case errors.Is(err, recovery.ErrNotFound):
+        return failureRecoveryNotFound
+    case errors.Is(err, recovery.ErrConflict), errors.Is(err, recovery.ErrNotReady):
+        return failureRecoveryPending
+    }
+    reportRecoveryError(ctx, err, action, account)
+    return failureInvalidPayload
+}
@@ -1,176 +1,441 @@
 package main
 
 import (
+    "context"
     "encoding/hex"
-    "encoding/json"
     "fmt"
-    "io"
     "math/big"
-    "net/http"
     "strings"
     "time"
 
+    "pwr-stateful-vida/alert"
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/crossvida"
     "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/events"
+    "pwr-stateful-vida/health"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/sdk"
+    "pwr-stateful-vida/tokens"
+    "pwr-stateful-vida/txlog"
     "github.com/pwrlabs/pwrgo/rpc"
 )
 
-var subscription *rpc.VidaTransactionSubscription
-var peersToCheckRootHashWith []string
+// syncControl is the part of the VIDA subscription the handlers use, so tests can
+// substitute testkit.Subscription
+type syncControl interface {
+    SetLatestCheckedBlock(blockNumber int)
+    Pause()
+    Resume()
+}
 
-// fetchPeerRootHash fetches the root hash from a peer node for the specified block number
-func fetchPeerRootHash(peer string, blockNumber int) (bool, []byte) {
-    url := fmt.Sprintf("http://%s/rootHash?blockNumber=%d", peer, blockNumber)
+// syncLogger logs transaction processing and checkpoints, peerLogger root hash validation
+var (
+    syncLogger = logging.For("sync")
+    peerLogger = logging.For("peers")
+)
 
-    client := &http.Client{Timeout: 10 * time.Second}
-    resp, err := client.Get(url)
-    if err != nil {
-        fmt.Printf("Failed to fetch root hash from peer %s for block %d\n", peer, blockNumber)
-        return false, nil
+// checkRootHashValidityAndSave validates the local Merkle root against peers and persists it if a quorum of peers agree.
+// It returns false when the changes of the block are to be reverted.
+func (a *App) checkRootHashValidityAndSave(blockNumber int, localRoot []byte) bool {
+    if verificationLog != nil {
+        return a.auditRootHash(blockNumber, localRoot)
+    }
+    matches, agreed := sdk.Quorum(a.Peers, a.PeerAddresses, blockNumber, localRoot, func(peer string, agreed bool) {
+        if !agreed {
+            metrics.PeerMismatches.Inc(peer)
+        }
+        a.recordPeerAgreement(peer, agreed, blockNumber)
+    })
+    if agreed {
+        a.db.SetBlockRootHash(blockNumber, localRoot)
+        peerLogger.Info("root hash validated and saved", "block", blockNumber, "matches", matches)
+        a.publishRoot(events.RootEvent{BlockNumber: int64(blockNumber), RootHash: localRoot, Validated: true})
+        return true
     }
-    defer resp.Body.Close()
 
-    if resp.StatusCode == 200 {
-        body, _ := io.ReadAll(resp.Body)
-        hexString := strings.TrimSpace(string(body))
+    peerLogger.Warn("root hash mismatch, reverting", "block", blockNumber, "matches", matches, "peers", len(a.PeerAddresses))
+    a.publishRoot(events.RootEvent{BlockNumber: int64(blockNumber), RootHash: localRoot, Validated: false})
+    alerts.Raise(rootMismatchAlert(blockNumber, matches, a.PeerAddresses))
+    return false
+}
 
-        if hexString == "" {
-            fmt.Printf("Peer %s returned empty root hash for block %d\n", peer, blockNumber)
-            return false, nil
-        }
+// Reasons a transaction is rejected, used as metric labels
+const (
+    failureInvalidPayload    = "invalid_payload"
+    failureInvalidAmount     = "invalid_amount"
+    failureInsufficientFunds = "insufficient_funds"
+    failureUnsupportedAction = "unsupported_action"
+    failurePanic             = sdk.FailurePanic
+)
 
-        rootHash, err := hex.DecodeString(hexString)
-        if err != nil {
-            fmt.Printf("Invalid hex response from peer %s for block %d\n", peer, blockNumber)
-            return false, nil
-        }
+// transferPayload is the payload of a transfer
+type transferPayload struct {
+    envelope
+    Amount   number `json:"amount"`
+    Receiver string `json:"receiver"`
+    Token    string `json:"token"`
+    // Sponsor is the account paying the fee, if any
+    Sponsor *string `json:"sponsor"`
 
-        fmt.Printf("Successfully fetched root hash from peer %s for block %d\n", peer, blockNumber)
-        return true, rootHash
-    } else {
-        fmt.Printf("Peer %s returned HTTP %d for block %d\n", peer, resp.StatusCode, blockNumber)
-        return true, nil
-    }
+    sponsor []byte
 }
 
-// checkRootHashValidityAndSave validates the local Merkle root against peers and persists it if a quorum of peers agree
-func checkRootHashValidityAndSave(blockNumber int) {
-    localRoot, _ := dbservice.GetRootHash()
-    if localRoot == nil {
-        fmt.Printf("No local root hash available for block %d\n", blockNumber)
-        return
+func (p *transferPayload) validate(a *App) error {
+    if !p.Amount.set() {
+        return invalidField("amount", "required")
+    }
+    if p.Receiver == "" {
+        return invalidField("receiver", "required")
     }
+    if p.Sponsor != nil {
+        if p.sponsor = payloadAddress(*p.Sponsor); p.sponsor == nil {
+            return invalidField("sponsor", "must be an address")
+        }
+    }
+    return nil
+}
 
-    peersCount := len(peersToCheckRootHashWith)
-    quorum := (peersCount*2)/3 + 1
-    matches := 0
+// handleTransfer executes a token transfer. Transfers of accounts with a co-signer
+// are held until it approves them, and a named sponsor reimburses the fee of an
+// applied or held transfer. It returns the reason the transfer was rejected, or an
+// empty string on success.
+func (a *App) handleTransfer(ctx context.Context, p *transferPayload, transaction rpc.VidaDataTransaction) string {
+    // Convert amount to big.Int in base units of the token. Like every other
+    // amount it must be positive: a negative one would move funds from the receiver.
+    amount := a.parseTokenAmount(p.Amount, p.Token)
+    if amount == nil {
+        syncLogger.WarnContext(ctx, "invalid amount", "payload", p)
+        return failureInvalidAmount
+    }
 
-    for _, peer := range peersToCheckRootHashWith {
-        success, peerRoot := fetchPeerRootHash(peer, blockNumber)
+    // Decode hex addresses
+    senderAddress := strings.TrimPrefix(transaction.Sender, "0x")
+    receiverAddress := strings.TrimPrefix(p.Receiver, "0x")
 
-        if success && peerRoot != nil {
-            if string(peerRoot) == string(localRoot) {
-                matches++
-            }
-        } else {
-            peersCount--
-            quorum = (peersCount*2)/3 + 1
+    sender, _ := hex.DecodeString(senderAddress)
+    receiver, err := hex.DecodeString(receiverAddress)
+    if err != nil || len(receiver) != dbservice.AddressLength {
+        // Not an address, so the receiver must be a registered name
+        receiver = a.resolveReceiver(ctx, p.Receiver, int64(transaction.BlockNumber))
+        if receiver == nil {
+            syncLogger.WarnContext(ctx, "invalid receiver", "payload", p)
+            return failureInvalidPayload
         }
+    }
 
-        if matches >= quorum {
-            dbservice.SetBlockRootHash(blockNumber, localRoot)
-            fmt.Printf("Root hash validated and saved for block %d\n", blockNumber)
-            return
-        }
+    sponsor, failure := a.checkSponsor(ctx, p.sponsor, sender, transaction)
+    if failure != "" {
+        return failure
+    }
+    held, failure := a.holdForCoSigner(ctx, sender, receiver, p.Token, amount, transaction)
+    if failure == "" && !held {
+        failure = a.executeTransfer(ctx, sender, receiver, p.Token, amount, int64(transaction.BlockNumber))
+    }
+    if failure == "" && sponsor != nil {
+        a.paySponsoredFee(ctx, sponsor, sender, transaction)
+    }
+    return failure
+}
+
+// executeTransfer moves amount of a token, the native token unless another is
+// named, after checking the transfer policy and the rules of the sender, and pays
+// the referrer of the sender its share of a native transfer
+func (a *App) executeTransfer(ctx context.Context, sender, receiver []byte, token string, amount *big.Int, block int64) string {
+    senderHex, receiverHex := hex.EncodeToString(sender), hex.EncodeToString(receiver)
+    if failure := a.checkTransferPolicy(ctx, sender, receiver, amount); failure != "" {
+        return failure
+    }
+    if failure := a.checkAccountRules(ctx, sender, receiver, token, amount, block); failure != "" {
+        return failure
+    }
+    fee, canPay := a.transferFee(ctx, sender, token, amount)
+    if !canPay {
+        syncLogger.InfoContext(ctx, "transfer failed: insufficient funds for the fee", "amount", amount, "fee", fee, "sender", senderHex, "receiver", receiverHex)
+        return failureInsufficientFunds
     }
 
-    fmt.Printf("Root hash mismatch: only %d/%d peers agreed\n", matches, len(peersToCheckRootHashWith))
+    success, err := tokens.Transfer(a.db, token, sender, receiver, amount)
+    if err != nil {
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "transfer",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "receiver": receiverHex},
+        })
+    }
 
-    // Revert changes and reset block to reprocess the data
-    dbservice.RevertUnsavedChanges()
-    lastCheckedBlock, _ := dbservice.GetLastCheckedBlock()
-    subscription.SetLatestCheckedBlock(int(lastCheckedBlock))
+    if !success {
+        syncLogger.InfoContext(ctx, "transfer failed: insufficient funds", "amount", amount, "sender", senderHex, "receiver", receiverHex)
+        return failureInsufficientFunds
+    }
+    a.recordAccountSpend(ctx, sender, token, amount, block)
+    a.observeTransfer(ctx, block, sender, receiver, token, amount)
+    a.chargeTransferFee(ctx, sender, fee)
+    syncLogger.InfoContext(ctx, "transfer succeeded", "amount", amount, "fee", fee, "sender", senderHex, "receiver", receiverHex)
+    a.payReferral(ctx, sender, receiver, token, amount)
+    return ""
 }
 
-// handleTransfer executes a token transfer described by the given JSON payload
-func handleTransfer(jsonData map[string]interface{}, senderHex string) {
-    // Extract amount and receiver from JSON
-    amountRaw := jsonData["amount"]
-    receiverHex, _ := jsonData["receiver"].(string)
+// skipTransaction reports whether a transaction is left out of the batch, while the
+// node is read-only or once a memory budget leaves it for the next batch
+func (a *App) skipTransaction(transaction rpc.VidaDataTransaction) bool {
+    return readOnly.Load() || a.deferTransaction(transaction)
+}
 
-    if amountRaw == nil || receiverHex == "" {
-        fmt.Printf("Skipping invalid transfer: %v\n", jsonData)
-        return
+// receiveTransaction logs a transaction before it is applied
+func (a *App) receiveTransaction(ctx context.Context, transaction rpc.VidaDataTransaction) {
+    if a.transactionLog != nil {
+        if err := a.transactionLog.Append(txlog.FromTransaction(transaction)); err != nil {
+            syncLogger.ErrorContext(ctx, "failed to log transaction", "hash", transaction.Hash, "error", err)
+            reporting.Report(err, reporting.Context{Module: "sync", Block: int64(transaction.BlockNumber), TxHash: transaction.Hash, CorrelationID: transaction.Hash})
+        }
     }
 
-    // Convert amount to big.Int
-    var amount *big.Int
-    switch v := amountRaw.(type) {
-    case string:
-        amount, _ = new(big.Int).SetString(v, 10)
-    case float64:
-        amount = big.NewInt(int64(v))
-    default:
-        fmt.Printf("Invalid amount type: %v\n", jsonData)
-        return
+    if a.blockArchive != nil {
+        a.archivedTransactions = append(a.archivedTransactions, transaction.Hash)
     }
+}
 
-    // Decode hex addresses
-    senderAddress := strings.TrimPrefix(senderHex, "0x")
-    receiverAddress := strings.TrimPrefix(receiverHex, "0x")
+// recordTransaction records the outcome of a transaction, unless the machine
+// records it once it applies the transaction later in its block
+func (a *App) recordTransaction(ctx context.Context, transaction rpc.VidaDataTransaction, outcome sdk.Outcome, start time.Time) {
+    if !outcome.Deferred {
+        recordOutcome(outcome.Label, outcome.Failure, start)
+        a.indexReceipt(transaction, outcome.Label, outcome.Failure)
+    }
+}
 
-    sender, _ := hex.DecodeString(senderAddress)
-    receiver, _ := hex.DecodeString(receiverAddress)
+// recordOutcome counts an applied or failed transaction and how long it took
+func recordOutcome(label, failure string, start time.Time) {
+    if failure == "" {
+        metrics.TransactionsApplied.Inc(label)
+    } else {
+        metrics.TransactionsFailed.Inc(label, failure)
+    }
+    metrics.TransactionDuration.Observe(time.Since(start).Seconds(), label)
+}
 
-    // Execute transfer
-    success, _ := dbservice.Transfer(sender, receiver, amount)
+// parsedPayload is the data of a transaction with its action and the handler of
+// the action, which is nil for an action this VIDA does not know
+type parsedPayload struct {
+    data    []byte
+    action  string
+    handler *actionHandler
+}
 
-    if success {
-        fmt.Printf("Transfer succeeded: %s from %s to %s\n", amount, senderHex, receiverHex)
-    } else {
-        fmt.Printf("Transfer failed (insufficient funds): %s from %s to %s\n", amount, senderHex, receiverHex)
+// actionHandlers maps each action, in lower case, to its handler. It is filled in
+// init since executing a proposal looks up the handler of its action.
+var actionHandlers map[string]*actionHandler
+
+func init() {
+    actionHandlers = map[string]*actionHandler{
+        "transfer":            typedAction("transfer", (*App).handleTransfer),
+        "policy":              typedAction("policy", (*App).handlePolicyUpdate),
+        "nodekey":             typedAction("nodeKey", (*App).handleNodeKeyUpdate),
+        "stake":               typedAction("staking", (*App).handleStaking),
+        "unstake":             typedAction("staking", (*App).handleStaking),
+        "delegate":            typedAction("staking", (*App).handleStaking),
+        "registertoken":       typedAction("registerToken", (*App).handleTokenRegistration),
+        "nft":                 typedAction("nft", (*App).handleNFTOperation),
+        "name":                typedAction("name", (*App).handleNameOperation),
+        "swap":                typedAction("swap", (*App).handleSwap),
+        "addliquidity":        typedAction("liquidity", (*App).handleAddLiquidity),
+        "removeliquidity":     typedAction("liquidity", (*App).handleRemoveLiquidity),
+        "snapshot":            typedAction("snapshot", (*App).handleSnapshot),
+        "distribute":          typedAction("distribute", (*App).handleDistribute),
+        "dividend":            typedAction("dividend", (*App).handleDividend),
+        "claimdividend":       typedAction("dividend", (*App).handleClaimDividend),
+        "accountrules":        typedAction("accountRules", (*App).handleAccountRules),
+        "cosign":              typedAction("cosign", (*App).handleCosign),
+        "spendlimit":          typedAction("spendLimit", (*App).handleSpendLimit),
+        "guardians":           typedAction("recovery", (*App).handleGuardians),
+        "recover":             typedAction("recovery", (*App).handleRecover),
+        "cancelrecovery":      typedAction("recovery", (*App).handleCancelRecovery),
+        "completerecovery":    typedAction("recovery", (*App).handleCompleteRecovery),
+        "bridgemint":          typedAction("bridge", (*App).handleBridgeMint),
+        "bridgeburn":          typedAction("bridge", (*App).handleBridgeBurn),
+        "bridgerelease":       typedAction("bridge", (*App).handleBridgeRelease),
+        "sponsor":             typedAction("sponsor", (*App).handleSponsorAllowance),
+        crossvida.Action:      typedAction("crossVidaMessage", (*App).handleCrossVidaMessage),
+        "savingsdeposit":      typedAction("savings", (*App).handleSavingsDeposit),
+        "savingswithdraw":     typedAction("savings", (*App).handleSavingsWithdraw),
+        "savingsrate":         typedAction("savings", (*App).handleSavingsRate),
+        "propose":             typedAction("governance", (*App).handlePropose),
+        "vote":                typedAction("governance", (*App).handleVote),
+        "execute":             typedAction("governance", (*App).handleExecute),
+        "governanceparams":    typedAction("governance", (*App).handleGovernanceParams),
+        "airdrop":             typedAction("airdrop", (*App).handleAirdrop),
+        "claim":               typedAction("airdrop", (*App).handleClaim),
+        "mint":                typedAction("monetary", (*App).handleMint),
+        "burn":                typedAction("monetary", (*App).handleBurn),
+        "monetarypolicy":      typedAction("monetary", (*App).handleMonetaryPolicy),
+        "offer":               typedAction("otc", (*App).handleOffer),
+        "takeoffer":           typedAction("otc", (*App).handleTakeOffer),
+        "canceloffer":         typedAction("otc", (*App).handleCancelOffer),
+        "referral":            typedAction("referral", (*App).handleReferral),
+        "referralparams":      typedAction("referral", (*App).handleReferralParams),
+        "openstream":          typedAction("stream", (*App).handleOpenStream),
+        "withdrawstream":      typedAction("stream", (*App).handleWithdrawStream),
+        "closestream":         typedAction("stream", (*App).handleCloseStream),
+        "settle":              typedAction("settlement", (*App).handleSettle),
+        "authorizesettlement": typedAction("settlement", (*App).handleAuthorizeSettlement),
     }
 }
 
-// processTransaction processes a single VIDA transaction
-func processTransaction(transaction rpc.VidaDataTransaction) {
+// parsePayload reads the action of the JSON payload of a transaction and returns
+// the payload with the metric label of its action. Unknown actions share a label so
+// payloads cannot create unbounded metric series. Payloads over the configured
+// limits are rejected with the reason as the failure.
+func (a *App) parsePayload(transaction rpc.VidaDataTransaction) (parsedPayload, string, string) {
+    if failure := a.checkEncodedSize(transaction.Data); failure != "" {
+        return parsedPayload{}, "other", failure
+    }
     // Get transaction data and convert from hex to bytes
     dataBytes, _ := hex.DecodeString(transaction.Data)
+    if failure := a.checkNesting(dataBytes); failure != "" {
+        return parsedPayload{}, "other", failure
+    }
 
-    // Parse JSON data
-    var jsonData map[string]interface{}
-    json.Unmarshal(dataBytes, &jsonData)
+    // Parse JSON data, keeping numbers exact
+    jsonData, _ := sdk.DecodePayload(dataBytes)
+    if failure := a.checkFieldLengths(jsonData); failure != "" {
+        return parsedPayload{}, "other", failure
+    }
 
     // Get action from JSON
     action, _ := jsonData["action"].(string)
-
-    if strings.ToLower(action) == "transfer" {
-        handleTransfer(jsonData, transaction.Sender)
+    payload := parsedPayload{data: dataBytes, action: action, handler: actionHandlers[strings.ToLower(action)]}
+    if payload.handler == nil {
+        return payload, "other", ""
     }
+    return payload, payload.handler.label, ""
 }
 
-// onChainProgress callback invoked as blocks are processed
-func onChainProgress(blockNumber int) error {
-    dbservice.SetLastCheckedBlock(blockNumber)
-    checkRootHashValidityAndSave(blockNumber)
-    fmt.Printf("Checkpoint updated to block %d\n", blockNumber)
-    dbservice.Flush()
+// applyTransaction applies the state changes of a parsed transaction and returns the
+// reason it was rejected, or an empty string on success
+func (a *App) applyTransaction(ctx context.Context, transaction rpc.VidaDataTransaction, payload parsedPayload) string {
+    if a.auditLog != nil {
+        a.auditLog.SetTransaction(transaction.Hash, int64(transaction.BlockNumber))
+    }
+    a.beginBlock(ctx, int64(transaction.BlockNumber))
+    decoded, failure := a.decodeTransaction(ctx, transaction, payload)
+    if failure != "" {
+        return failure
+    }
+    // A stale submission is not applied long after it was sent
+    envelope := decoded.common()
+    if envelope.ValidUntilBlock.set() && int64(transaction.BlockNumber) > envelope.validUntil {
+        syncLogger.InfoContext(ctx, "rejecting transaction past its validUntilBlock", "hash", transaction.Hash, "validUntilBlock", envelope.validUntil)
+        return failureExpired
+    }
+    transaction, failure = a.actingAccount(ctx, transaction, envelope.account)
+    if failure != "" {
+        return failure
+    }
+    return payload.handler.apply(a, ctx, decoded, transaction)
+}
 
-    return nil
+// decodeTransaction decodes and validates the payload of a transaction into the
+// struct of its action, returning the reason it was rejected if it is invalid
+func (a *App) decodeTransaction(ctx context.Context, transaction rpc.VidaDataTransaction, payload parsedPayload) (actionPayload, string) {
+    if payload.handler == nil {
+        return nil, failureUnsupportedAction
+    }
+    decoded, err := payload.handler.decode(a, payload.data)
+    if err != nil {
+        return nil, payloadFailure(ctx, transaction, payload.action, err)
+    }
+    return decoded, ""
 }
 
-// subscribeAndSync subscribes to VIDA transactions starting from the given block
-func subscribeAndSync(fromBlock int) {
-    fmt.Printf("Starting VIDA transaction subscription from block %d\n", fromBlock)
+// prepareCheckpoint returns the block a checkpoint reported for blockNumber commits,
+// discarding the batch instead while the node is read-only or fenced
+func (a *App) prepareCheckpoint(blockNumber int) (int, bool, bool) {
+    blockNumber, deferred := a.checkpointBlock(blockNumber)
+    if readOnly.Load() {
+        a.discardReadOnlyBatch(blockNumber)
+        return blockNumber, deferred, false
+    }
+    if a.fenced(blockNumber) {
+        return blockNumber, deferred, false
+    }
+    return blockNumber, deferred, true
+}
 
-    // Initialize RPC client
-    rpcClient := rpc.SetRpcNodeUrl(RPC_URL)
+// logCheckpoint records a validated checkpoint in the transaction log and the block
+// archive, and drops the records of the batch if it was reverted
+func (a *App) logCheckpoint(blockNumber int, localRoot []byte, kept bool) {
+    if a.transactionLog != nil {
+        record := txlog.Record{Type: txlog.TypeBlock, Block: int64(blockNumber), RootHash: hex.EncodeToString(localRoot), Reverted: !kept}
+        if err := a.transactionLog.Append(record); err != nil {
+            syncLogger.Error("failed to log block", "block", blockNumber, "error", err)
+            reporting.Report(err, reporting.Context{Module: "sync", Block: int64(blockNumber)})
+        }
+    }
+    if a.blockArchive != nil {
+        if kept {
+            a.archiveBlock(blockNumber, localRoot)
+        }
+        a.archivedTransactions = nil
+    }
+    if a.auditLog != nil && !kept {
+        a.auditLog.Discard()
+    }
+    if !kept {
+        a.discardRecords()
+    }
+}
 
-    subscription = rpcClient.SubscribeToVidaTransactions(
-        VIDA_ID,
-        fromBlock,
-        processTransaction,
-        onChainProgress,
-    )
+// commitCheckpoint writes a flushed checkpoint to the audit log, the index, the
+// event stream, the mirror and the cloud snapshots, and raises an alert when the
+// flush failed
+func (a *App) commitCheckpoint(blockNumber int, localRoot []byte, kept bool, flushErr error) {
+    health.RecordFlush(flushErr)
+    if flushErr != nil {
+        alerts.Raise(alert.Alert{
+            Kind:     alert.KindFlushFailure,
+            Severity: alert.Critical,
+            Message:  fmt.Sprintf("failed to flush state at block %d: %v", blockNumber, flushErr),
+            Block:    int64(blockNumber),
+        })
+    } else if a.auditLog != nil {
+        if err := a.auditLog.Commit(); err != nil {
+            syncLogger.Error("failed to write audit log", "block", blockNumber, "error", err)
+            reporting.Report(err, reporting.Context{Module: "sync", Block: int64(blockNumber)})
+        }
+    }
+    if flushErr == nil && kept {
+        a.commitIndex(int64(blockNumber))
+        a.commitEvents(int64(blockNumber), localRoot)
+        a.commitMirror(int64(blockNumber), localRoot)
+        a.commitCloudSnapshot(int64(blockNumber), localRoot)
+    }
+    health.RecordProgress()
+}
 
-    fmt.Printf("Successfully subscribed to VIDA %d transactions\n", VIDA_ID)
+// archiveBlock writes the archive record of a committed block from the pending changes
+func (a *App) archiveBlock(blockNumber int, rootHash []byte) {
+    record := archive.Record{
+        BlockNumber:  int64(blockNumber),
+        RootHash:     hex.EncodeToString(rootHash),
+        Transactions: a.archivedTransactions,
+        Changes:      []archive.Change{},
+    }
+    if record.Transactions == nil {
+        record.Transactions = []string{}
+    }
+    for _, change := range a.db.PendingChanges() {
+        record.Changes = append(record.Changes, archive.Change{
+            Key: hex.EncodeToString(change.Key),
+            Old: hex.EncodeToString(change.Old),
+            New: hex.EncodeToString(change.New),
+        })
+    }
+    if err := a.blockArchive.Append(record); err != nil {
+        syncLogger.Error("failed to archive block", "block", blockNumber, "error", err)
+        reporting.Report(err, reporting.Context{Module: "sync", Block: int64(blockNumber)})
+    }
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "encoding/json"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/names"
+    "pwr-stateful-vida/testkit"
+)
+
+func TestTransferAmounts(t *testing.T) {
+    sender, receiver := account(1), account(2)
+
+    tests := []struct {
+        name        string
+        amount      string
+        wantFailure string
+    }{
+        {name: "decimal string", amount: `"40"`},
+        {name: "integer", amount: `40`},
+        {name: "negative string", amount: `"-100"`, wantFailure: failureInvalidAmount},
+        {name: "negative integer", amount: `-100`, wantFailure: failureInvalidAmount},
+        {name: "zero", amount: `"0"`, wantFailure: failureInvalidAmount},
+        {name: "fraction", amount: `"1.5"`, wantFailure: failureInvalidAmount},
+        {name: "number with a fraction", amount: `40.0`, wantFailure: failureInvalidAmount},
+        {name: "exponent", amount: `4e1`, wantFailure: failureInvalidAmount},
+        {name: "not a number", amount: `"forty"`, wantFailure: failureInvalidAmount},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            testkit.UseMemoryTree()
+            dbservice.SetBalance(sender, big.NewInt(100))
+            dbservice.SetBalance(receiver, big.NewInt(50))
+
+            payload := `{"action":"transfer","amount":` + test.amount + `,"receiver":"` + hex.EncodeToString(receiver) + `"}`
+            transaction := testkit.Transaction(sender, json.RawMessage(payload))
+            parsed, _, failure := app.parsePayload(transaction)
+            if failure == "" {
+                failure = app.applyTransaction(context.Background(), transaction, parsed)
+            }
+            if failure != test.wantFailure {
+                t.Fatalf("failure = %q, want %q", failure, test.wantFailure)
+            }
+
+            wantSender, wantReceiver := int64(100), int64(50)
+            if test.wantFailure == "" {
+                wantSender, wantReceiver = 60, 90
+            }
+            if got := balanceOf(t, sender); got != wantSender {
+                t.Errorf("sender balance = %d, want %d", got, wantSender)
+            }
+            if got := balanceOf(t, receiver); got != wantReceiver {
+                t.Errorf("receiver balance = %d, want %d", got, wantReceiver)
+            }
+        })
+    }
+}
+
+func TestTransferReceivers(t *testing.T) {
+    sender, named := account(1), account(3)
+    address := hex.EncodeToString(account(2))
+
+    tests := []struct {
+        name        string
+        receiver    string
+        wantFailure string
+        // credited is the account the transfer pays, if it is applied
+        credited []byte
+    }{
+        {name: "address", receiver: address, credited: account(2)},
+        {name: "prefixed address", receiver: "0x" + address, credited: account(2)},
+        {name: "registered name", receiver: "carol", credited: named},
+        {name: "unregistered name", receiver: "dave", wantFailure: failureInvalidPayload},
+        {name: "short address", receiver: address[:38], wantFailure: failureInvalidPayload},
+        {name: "long address", receiver: address + "02", wantFailure: failureInvalidPayload},
+        {name: "odd length", receiver: address[:39], wantFailure: failureInvalidPayload},
+        {name: "not hex", receiver: "0x" + address[:38] + "zz", wantFailure: failureInvalidPayload},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            testkit.UseMemoryTree()
+            dbservice.SetBalance(sender, big.NewInt(100))
+            operation := names.Operation{Op: names.OpRegister, Name: "carol", Sender: named, Address: named}
+            if err := names.Apply(app.db, operation, 0, names.Params{PeriodBlocks: 100}); err != nil {
+                t.Fatal(err)
+            }
+
+            payload := `{"action":"transfer","amount":"25","receiver":"` + test.receiver + `"}`
+            transaction := testkit.Transaction(sender, json.RawMessage(payload))
+            parsed, _, failure := app.parsePayload(transaction)
+            if failure == "" {
+                failure = app.applyTransaction(context.Background(), transaction, parsed)
+            }
+            if failure != test.wantFailure {
+                t.Fatalf("failure = %q, want %q", failure, test.wantFailure)
+            }
+
+            wantSender := int64(100)
+            if test.credited != nil {
+                wantSender = 75
+                if got := balanceOf(t, test.credited); got != 25 {
+                    t.Errorf("receiver balance = %d, want 25", got)
+                }
+            }
+            if got := balanceOf(t, sender); got != wantSender {
+                t.Errorf("sender balance = %d, want %d", got, wantSender)
+            }
+        })
+    }
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package health
+
+import "errors"
+
+// FreeSpace is not implemented on this platform
+func FreeSpace(path string) (int64, error) {
+    return 0, errors.New("free space is not available on this platform")
+}
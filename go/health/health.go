@@ -0,0 +1,204 @@
+// Package health combines sync lag, peer agreement, flush errors and free disk space
+// into a single score that decides whether the node is ready to serve.
+package health
+
+import (
+    "fmt"
+    "math"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+)
+
+// Weights of the components in the composite score
+const (
+    syncWeight  = 0.4
+    peerWeight  = 0.3
+    flushWeight = 0.2
+    diskWeight  = 0.1
+)
+
+// Component is one input of the health score
+type Component struct {
+    Name   string  `json:"name"`
+    Score  float64 `json:"score"`
+    Weight float64 `json:"weight"`
+    Detail string  `json:"detail"`
+}
+
+// Report is the health of the node
+type Report struct {
+    // Score is the weighted average of the component scores, from 0 to 1
+    Score float64 `json:"score"`
+    // Ready is true when Score reaches the configured minimum
+    Ready bool `json:"ready"`
+    // ReadOnly is true while the node serves its last checkpoint without syncing
+    ReadOnly   bool        `json:"readOnly"`
+    Components []Component `json:"components"`
+    // Standby is true while the node is the standby of a failover pair, which is
+    // never ready so traffic goes to the active
+    Standby bool `json:"standby"`
+}
+
+var (
+    mutex         sync.Mutex
+    lastProgress  = time.Now()
+    peerAgreement = make(map[string]bool)
+    flushFailures int
+    readOnly      bool
+    standby       bool
+)
+
+// SetReadOnly records whether the node stopped syncing to protect its database
+func SetReadOnly(enabled bool) {
+    mutex.Lock()
+    readOnly = enabled
+    mutex.Unlock()
+}
+
+// SetStandby records whether the node is the standby of a failover pair
+func SetStandby(enabled bool) {
+    mutex.Lock()
+    standby = enabled
+    mutex.Unlock()
+}
+
+// Standby reports whether the node is the standby of a failover pair
+func Standby() bool {
+    mutex.Lock()
+    defer mutex.Unlock()
+    return standby
+}
+
+// RecordProgress records that a checkpoint was processed
+func RecordProgress() {
+    mutex.Lock()
+    lastProgress = time.Now()
+    mutex.Unlock()
+}
+
+// LastProgress returns the time of the last checkpoint, or the process start time
+// before the first one
+func LastProgress() time.Time {
+    mutex.Lock()
+    defer mutex.Unlock()
+    return lastProgress
+}
+
+// RecordPeer records whether a peer agreed with the local root hash
+func RecordPeer(peer string, agreed bool) {
+    mutex.Lock()
+    peerAgreement[peer] = agreed
+    mutex.Unlock()
+}
+
+// RecordFlush records the result of a flush. Failures count until a flush succeeds.
+func RecordFlush(err error) {
+    mutex.Lock()
+    if err != nil {
+        flushFailures++
+    } else {
+        flushFailures = 0
+    }
+    mutex.Unlock()
+}
+
+// Evaluate computes the current health report
+func Evaluate() Report {
+    cfg := config.Get().Health
+    maxSyncLag, _ := time.ParseDuration(cfg.MaxSyncLag)
+
+    mutex.Lock()
+    lag := time.Since(lastProgress)
+    agreed, answered := 0, len(peerAgreement)
+    for _, ok := range peerAgreement {
+        if ok {
+            agreed++
+        }
+    }
+    failures := flushFailures
+    stopped := readOnly
+    passive := standby
+    mutex.Unlock()
+
+    components := []Component{
+        syncComponent(lag, maxSyncLag),
+        peerComponent(agreed, answered),
+        flushComponent(failures),
+        diskComponent(int64(cfg.MinFreeDiskMB) << 20),
+    }
+
+    report := Report{Components: components, ReadOnly: stopped, Standby: passive}
+    var total float64
+    for _, component := range components {
+        report.Score += component.Score * component.Weight
+        total += component.Weight
+    }
+    report.Score = math.Round(report.Score/total*1000) / 1000
+    report.Ready = report.Score >= cfg.MinReadyScore && !passive
+    return report
+}
+
+// syncComponent scores the time since the last checkpoint: 1 up to maxLag, falling
+// to 0 at twice maxLag
+func syncComponent(lag, maxLag time.Duration) Component {
+    component := Component{Name: "sync", Weight: syncWeight, Detail: fmt.Sprintf("last checkpoint %s ago", lag.Round(time.Second))}
+    component.Score = 1
+    if maxLag > 0 {
+        component.Score = falloff(float64(maxLag*2-lag) / float64(maxLag))
+    }
+    return component
+}
+
+// peerComponent scores the share of peers that agreed with the last root hash they returned
+func peerComponent(agreed, answered int) Component {
+    component := Component{Name: "peers", Weight: peerWeight, Score: 1, Detail: "no peer answered yet"}
+    if answered > 0 {
+        component.Score = float64(agreed) / float64(answered)
+        component.Detail = fmt.Sprintf("%d of %d peers agree", agreed, answered)
+    }
+    return component
+}
+
+// flushComponent scores 0 while the last flush failed
+func flushComponent(failures int) Component {
+    if failures > 0 {
+        return Component{Name: "flush", Weight: flushWeight, Score: 0, Detail: fmt.Sprintf("%d consecutive flush failures", failures)}
+    }
+    return Component{Name: "flush", Weight: flushWeight, Score: 1, Detail: "last flush succeeded"}
+}
+
+// diskComponent scores the free space of the database volume: 1 from twice minFree,
+// falling to 0 at minFree
+func diskComponent(minFree int64) Component {
+    component := Component{Name: "disk", Weight: diskWeight, Score: 1}
+    free, err := FreeSpace(DataDir())
+    if err != nil {
+        component.Detail = "free space unknown: " + err.Error()
+        return component
+    }
+    component.Detail = fmt.Sprintf("%d MB free", free>>20)
+    if minFree > 0 {
+        component.Score = falloff(float64(free-minFree) / float64(minFree))
+    }
+    return component
+}
+
+// DataDir returns the directory holding the database files, or the working
+// directory before it exists
+func DataDir() string {
+    dir := filepath.Dir(dbservice.TreePath())
+    if _, err := os.Stat(dir); err != nil {
+        return "."
+    }
+    return dir
+}
+
+// falloff clamps a linear score to [0, 1]
+func falloff(score float64) float64 {
+    return math.Max(0, math.Min(1, score))
+}
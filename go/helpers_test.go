@@ -0,0 +1,73 @@
+package main
+
+import (
+    "math/big"
+    "testing"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/testkit"
+)
+
+// testNode is an app syncing from a fake subscription on an in-memory tree
+type testNode struct {
+    tree         *testkit.MemoryTree
+    subscription *testkit.Subscription
+}
+
+// newTestNode installs an app over a fresh in-memory tree, checking root hashes
+// with peers, and funds the given accounts before the first checkpoint
+func newTestNode(t *testing.T, peers []*testkit.Peer, funded map[string]int64) *testNode {
+    t.Helper()
+    tree := testkit.UseMemoryTree()
+    for address, balance := range funded {
+        dbservice.SetBalance([]byte(address), big.NewInt(balance))
+    }
+    dbservice.SetLastCheckedBlock(0)
+    if err := dbservice.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    client, err := newHTTPPeers(config.PeerTLSConfig{}, "", 5*time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rpcClient := &testkit.RPC{}
+    app = &App{db: dbservice.Default(), Peers: client, RPC: rpcClient, PeerAddresses: testkit.Addrs(peers...)}
+    app.startSync()
+    t.Cleanup(func() {
+        app.stopSync()
+        app = &App{db: dbservice.Default()}
+    })
+    return &testNode{tree: tree, subscription: rpcClient.Subscription}
+}
+
+// account returns a test address whose bytes are all b
+func account(b byte) []byte {
+    address := make([]byte, dbservice.AddressLength)
+    for i := range address {
+        address[i] = b
+    }
+    return address
+}
+
+// balanceOf returns the balance of address, failing the test on an error
+func balanceOf(t *testing.T, address []byte) int64 {
+    t.Helper()
+    balance, err := dbservice.GetBalance(address)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return balance.Int64()
+}
+
+// checkpoint returns the last checked block, failing the test on an error
+func checkpoint(t *testing.T) int64 {
+    t.Helper()
+    block, err := dbservice.GetLastCheckedBlock()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return block
+}
@@ -0,0 +1,141 @@
+package hosting
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/logging"
+)
+
+// logger is the log of the host, which names the tenant of every line
+var logger = logging.For("hosting")
+
+// Node is the application a tenant runs
+type Node interface {
+    // Start opens the logs of the node and subscribes to its VIDA
+    Start() error
+    // Stop ends the subscriptions after the batches in progress
+    Stop()
+    // Handler serves the API of the node once it started
+    Handler() http.Handler
+    // Halted reports whether the node stopped applying blocks
+    Halted() bool
+}
+
+// NewNode returns the node of a tenant, keeping its state in db
+type NewNode func(tenant *Tenant, db *dbservice.DatabaseService) (Node, error)
+
+// Status is the state of a tenant's node
+type Status struct {
+    Name             string    `json:"name"`
+    RoutePrefix      string    `json:"routePrefix"`
+    VidaID           int       `json:"vidaId"`
+    Running          bool      `json:"running"`
+    Since            time.Time `json:"since"`
+    LastCheckedBlock int64     `json:"lastCheckedBlock"`
+    Halted           bool      `json:"halted"`
+}
+
+// tenantNode is the node of a tenant and the database it keeps its state in
+type tenantNode struct {
+    tenant Tenant
+    db     *dbservice.DatabaseService
+    node   Node
+
+    mutex   sync.Mutex
+    running bool
+    since   time.Time
+}
+
+// Host runs the nodes of the tenants
+type Host struct {
+    nodes []*tenantNode
+}
+
+// Start opens the database of each tenant in its directory and starts the node
+// newNode returns for it
+func Start(cfg *Config, newNode NewNode) (*Host, error) {
+    h := &Host{}
+    for i := range cfg.Tenants {
+        tenant := &cfg.Tenants[i]
+        n := &tenantNode{tenant: *tenant}
+        n.db = dbservice.New("database")
+        n.db.SetDir(tenant.Dir)
+        node, err := newNode(tenant, n.db)
+        if err != nil {
+            n.db.Close()
+            h.Stop()
+            return nil, fmt.Errorf("tenant %s: %v", tenant.Name, err)
+        }
+        n.node = node
+        h.nodes = append(h.nodes, n)
+
+        if err := n.node.Start(); err != nil {
+            h.Stop()
+            return nil, fmt.Errorf("tenant %s: %v", tenant.Name, err)
+        }
+        n.mutex.Lock()
+        n.running, n.since = true, time.Now()
+        n.mutex.Unlock()
+        logger.Info("tenant started", "tenant", tenant.Name, "vidaId", tenant.node.VidaID, "routePrefix", tenant.RoutePrefix, "dir", tenant.Dir)
+    }
+    return h, nil
+}
+
+// Handler serves the API of each tenant's node under its route prefix, and the
+// state of the nodes at /tenants
+func (h *Host) Handler() http.Handler {
+    mux := http.NewServeMux()
+    for _, n := range h.nodes {
+        prefix := n.tenant.RoutePrefix
+        handler := http.StripPrefix(prefix, n.node.Handler())
+        mux.Handle(prefix+"/", handler)
+        mux.Handle(prefix, handler)
+    }
+    mux.HandleFunc("/tenants", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(h.Status())
+    })
+    return mux
+}
+
+// Status returns the state of every tenant's node
+func (h *Host) Status() []Status {
+    statuses := make([]Status, 0, len(h.nodes))
+    for _, n := range h.nodes {
+        status := Status{Name: n.tenant.Name, RoutePrefix: n.tenant.RoutePrefix, VidaID: n.tenant.node.VidaID}
+        n.mutex.Lock()
+        status.Running, status.Since = n.running, n.since
+        n.mutex.Unlock()
+        if status.Running {
+            status.LastCheckedBlock, _ = n.db.GetLastCheckedBlock()
+            status.Halted = n.node.Halted()
+        }
+        statuses = append(statuses, status)
+    }
+    return statuses
+}
+
+// Stop ends the subscriptions of the nodes after the batches in progress and closes
+// their databases, dropping the blocks applied since their last checkpoints
+func (h *Host) Stop() error {
+    var errs []error
+    for _, n := range h.nodes {
+        n.node.Stop()
+        n.mutex.Lock()
+        n.running = false
+        n.mutex.Unlock()
+        if err := n.db.RevertUnsavedChanges(); err != nil {
+            errs = append(errs, fmt.Errorf("tenant %s: %v", n.tenant.Name, err))
+        }
+        if err := n.db.Close(); err != nil {
+            errs = append(errs, fmt.Errorf("tenant %s: %v", n.tenant.Name, err))
+        }
+    }
+    return errors.Join(errs...)
+}
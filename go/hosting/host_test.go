@@ -0,0 +1,134 @@
+package hosting
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "testing"
+
+    "pwr-stateful-vida/canonical"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/sdk"
+    "pwr-stateful-vida/testkit"
+
+    "github.com/gin-gonic/gin"
+)
+
+// tenantPeers answers for each tenant with the current root hash of its own node,
+// so every checkpoint reaches a quorum
+type tenantPeers struct {
+    host *Host
+}
+
+func (p tenantPeers) RootHash(peer string, blockNumber int) (bool, []byte) {
+    for _, n := range p.host.nodes {
+        if n.tenant.Name == peer {
+            root, err := n.db.GetRootHash()
+            return err == nil, root
+        }
+    }
+    return false, nil
+}
+
+// canonicalNode runs a tenant as an sdk.Node applying the canonical rules
+type canonicalNode struct {
+    *sdk.Node
+}
+
+func (n canonicalNode) Handler() http.Handler {
+    gin.SetMode(gin.ReleaseMode)
+    return n.Router()
+}
+
+// startTenants starts a host of tenants a and b in dir, each agreeing with itself
+func startTenants(t *testing.T, dir string) (*Host, map[string]*testkit.RPC) {
+    t.Helper()
+    cfg := &Config{}
+    rpcs := make(map[string]*testkit.RPC)
+    for i, name := range []string{"a", "b"} {
+        cfg.Tenants = append(cfg.Tenants, Tenant{
+            Name:        name,
+            Dir:         filepath.Join(dir, name),
+            RoutePrefix: "/" + name,
+            node:        &config.Config{VidaID: 100 + i, StartBlock: 1, Peers: []string{name}, Canonical: true},
+        })
+        rpcs[name] = &testkit.RPC{}
+    }
+    peers := &tenantPeers{}
+    host, err := Start(cfg, func(tenant *Tenant, db *dbservice.DatabaseService) (Node, error) {
+        db.SetCanonical(true)
+        return canonicalNode{sdk.New(sdk.Config{
+            VidaID:     tenant.node.VidaID,
+            StartBlock: tenant.node.StartBlock,
+            Peers:      tenant.node.Peers,
+            DB:         db,
+            Canonical:  true,
+        }, rpcs[tenant.Name], peers)}, nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    peers.host = host
+    return host, rpcs
+}
+
+// balance reads the balance of address from the API of a tenant
+func balance(t *testing.T, host *Host, prefix string, address []byte) string {
+    t.Helper()
+    recorder := httptest.NewRecorder()
+    host.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", prefix+"/balance?address="+hex.EncodeToString(address), nil))
+    var response struct {
+        Balance string `json:"balance"`
+    }
+    if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+        t.Fatalf("%s/balance: %v (%s)", prefix, err, recorder.Body)
+    }
+    return response.Balance
+}
+
+func TestTenantsKeepSeparateStates(t *testing.T) {
+    dir := t.TempDir()
+    host, rpcs := startTenants(t, dir)
+    sender, _ := hex.DecodeString(canonical.Genesis[0])
+    receiver := bytes.Repeat([]byte{7}, canonical.AddressLength)
+
+    rpcs["a"].Subscription.AddBlock(1, testkit.Transfer(sender, receiver, big.NewInt(100)))
+    rpcs["a"].Subscription.Sync(10)
+    rpcs["b"].Subscription.AddBlock(1, testkit.Transfer(sender, receiver, big.NewInt(7)))
+    rpcs["b"].Subscription.AddBlock(2, testkit.Transfer(sender, receiver, big.NewInt(8)))
+    rpcs["b"].Subscription.Sync(10)
+
+    tests := []struct {
+        prefix    string
+        want      string
+        wantBlock int64
+    }{
+        {prefix: "/a", want: "100", wantBlock: 1},
+        {prefix: "/b", want: "15", wantBlock: 2},
+    }
+    check := func(host *Host) {
+        t.Helper()
+        statuses := host.Status()
+        for i, test := range tests {
+            if got := balance(t, host, test.prefix, receiver); got != test.want {
+                t.Errorf("%s: balance = %s, want %s", test.prefix, got, test.want)
+            }
+            if statuses[i].LastCheckedBlock != test.wantBlock {
+                t.Errorf("%s: last checked block = %d, want %d", test.prefix, statuses[i].LastCheckedBlock, test.wantBlock)
+            }
+        }
+    }
+    check(host)
+
+    if err := host.Stop(); err != nil {
+        t.Fatal(err)
+    }
+    host, _ = startTenants(t, dir)
+    defer host.Stop()
+    check(host)
+}
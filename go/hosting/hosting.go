@@ -0,0 +1,186 @@
+// Package hosting runs several independent VIDA nodes in one process. Each tenant is
+// a node with its own configuration, genesis, peers and database service, kept in
+// its own directory, and the host serves the API of every tenant under its route
+// prefix on one port. The process configuration, with the servers, alerts and
+// health checks it sets up, belongs to the host.
+package hosting
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+
+    "pwr-stateful-vida/config"
+)
+
+// validName matches tenant names, which are used in paths and logs
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Tenant is one hosted node
+type Tenant struct {
+    // Name identifies the tenant in logs and in its default route prefix and directory
+    Name string `json:"name"`
+    // Dir is the directory of the node, holding its database; tenants/<name> next to
+    // the hosting file when empty
+    Dir string `json:"dir"`
+    // Config is the configuration file of the node, relative to Dir; config.json
+    // when empty. Its relative paths are relative to Dir too.
+    Config string `json:"config"`
+    // RoutePrefix serves the API of the node under this path; /<name> when empty
+    RoutePrefix string `json:"routePrefix"`
+
+    node *config.Config
+}
+
+// Node returns the configuration of the tenant's node
+func (t *Tenant) Node() *config.Config {
+    return t.node
+}
+
+// Config is a hosting file
+type Config struct {
+    // Port is the HTTP port the APIs of the tenants are served on
+    Port int `json:"port"`
+    // CertFile and KeyFile serve HTTPS when set
+    CertFile string   `json:"certFile"`
+    KeyFile  string   `json:"keyFile"`
+    Tenants  []Tenant `json:"tenants"`
+}
+
+// Load reads a hosting file and the configuration of each of its tenants
+func Load(path string) (*Config, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    cfg := &Config{Port: 8080}
+    if err := json.Unmarshal(data, cfg); err != nil {
+        return nil, fmt.Errorf("failed to parse hosting file %s: %v", path, err)
+    }
+
+    base := filepath.Dir(path)
+    for i := range cfg.Tenants {
+        tenant := &cfg.Tenants[i]
+        if tenant.Dir == "" {
+            tenant.Dir = filepath.Join("tenants", tenant.Name)
+        }
+        if !filepath.IsAbs(tenant.Dir) {
+            tenant.Dir = filepath.Join(base, tenant.Dir)
+        }
+        if tenant.Dir, err = filepath.Abs(tenant.Dir); err != nil {
+            return nil, err
+        }
+        if tenant.Config == "" {
+            tenant.Config = "config.json"
+        }
+        if !filepath.IsAbs(tenant.Config) {
+            tenant.Config = filepath.Join(tenant.Dir, tenant.Config)
+        }
+        if tenant.RoutePrefix == "" {
+            tenant.RoutePrefix = "/" + tenant.Name
+        }
+
+        // A missing file would silently give the node the default configuration
+        if _, err := os.Stat(tenant.Config); err != nil {
+            return nil, fmt.Errorf("tenant %s: %v", tenant.Name, err)
+        }
+        if tenant.node, err = config.Load(tenant.Config); err != nil {
+            return nil, fmt.Errorf("tenant %s: %v", tenant.Name, err)
+        }
+        node := tenant.node
+        for _, path := range []*string{&node.GenesisFile, &node.SnapshotDir, &node.TxLog, &node.Index.Path, &node.Publish.OutboxPath} {
+            if *path != "" && !filepath.IsAbs(*path) {
+                *path = filepath.Join(tenant.Dir, *path)
+            }
+        }
+    }
+    return cfg, nil
+}
+
+// Validate returns the problems of the hosting file, including resources two tenants
+// would share. Each node checks the rest of its configuration when it starts.
+func (c *Config) Validate() []error {
+    var problems []error
+    fail := func(format string, args ...interface{}) {
+        problems = append(problems, fmt.Errorf(format, args...))
+    }
+
+    if c.Port <= 0 || c.Port > 65535 {
+        fail("port %d is out of range", c.Port)
+    }
+    if (c.CertFile == "") != (c.KeyFile == "") {
+        fail("certFile and keyFile must be set together")
+    }
+    if len(c.Tenants) == 0 {
+        fail("no tenants")
+    }
+
+    names := make(map[string]bool)
+    dirs := make(map[string]string)
+    vidas := make(map[int]string)
+    for i, tenant := range c.Tenants {
+        if !validName.MatchString(tenant.Name) {
+            fail("tenants[%d]: name %q must be lower case letters, digits, - and _", i, tenant.Name)
+            continue
+        }
+        if names[tenant.Name] {
+            fail("tenant %s is listed twice", tenant.Name)
+        }
+        names[tenant.Name] = true
+
+        prefix := tenant.RoutePrefix
+        if !strings.HasPrefix(prefix, "/") || prefix == "/" || strings.HasSuffix(prefix, "/") || prefix == "/tenants" {
+            fail("tenant %s: routePrefix %q must be a path like /%s", tenant.Name, prefix, tenant.Name)
+        }
+        for _, other := range c.Tenants[:i] {
+            if prefix == other.RoutePrefix || strings.HasPrefix(prefix+"/", other.RoutePrefix+"/") || strings.HasPrefix(other.RoutePrefix+"/", prefix+"/") {
+                fail("tenant %s: routePrefix %q overlaps the one of tenant %s", tenant.Name, prefix, other.Name)
+            }
+        }
+        if other, ok := dirs[tenant.Dir]; ok {
+            fail("tenant %s: dir %s is already used by tenant %s", tenant.Name, tenant.Dir, other)
+        }
+        dirs[tenant.Dir] = tenant.Name
+
+        node := tenant.node
+        if node == nil {
+            continue
+        }
+        if other, ok := vidas[node.VidaID]; ok {
+            fail("tenant %s: VIDA %d is already hosted by tenant %s", tenant.Name, node.VidaID, other)
+        }
+        vidas[node.VidaID] = tenant.Name
+        for _, problem := range node.Validate() {
+            fail("tenant %s: %v", tenant.Name, problem)
+        }
+        for _, setting := range hostSettings {
+            if setting.set(node) {
+                fail("tenant %s: %s belongs to the host configuration and cannot be set for a tenant", tenant.Name, setting.name)
+            }
+        }
+    }
+    return problems
+}
+
+// hostSettings are the settings the node reads from the process configuration, or
+// which run once per process, so they belong to the host
+var hostSettings = []struct {
+    name string
+    set  func(node *config.Config) bool
+}{
+    {"archiveDir", func(node *config.Config) bool { return node.ArchiveDir != "" }},
+    {"audit.path", func(node *config.Config) bool { return node.Audit.Path != "" }},
+    {"auth.apiKeys", func(node *config.Config) bool { return len(node.Auth.APIKeys) > 0 }},
+    {"auth.jwtSecret", func(node *config.Config) bool { return node.Auth.JWTSecret != "" }},
+    {"auditor.enabled", func(node *config.Config) bool { return node.Auditor.Enabled }},
+    {"faucet.enabled", func(node *config.Config) bool { return node.Faucet.Enabled }},
+    {"failover.peer", func(node *config.Config) bool { return node.Failover.Peer != "" }},
+    {"replica.primary", func(node *config.Config) bool { return node.Replica.Primary != "" }},
+    {"shards", func(node *config.Config) bool { return node.Shards > 1 }},
+    {"supply", func(node *config.Config) bool {
+        return len(node.Supply.BurnAddresses)+len(node.Supply.LockedAddresses) > 0
+    }},
+}
@@ -0,0 +1,48 @@
+package hosting
+
+import (
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "pwr-stateful-vida/config"
+)
+
+func TestValidateTenantSettings(t *testing.T) {
+    tests := []struct {
+        name    string
+        change  func(node *config.Config)
+        wantErr string
+    }{
+        {name: "defaults", change: func(node *config.Config) {}},
+        {name: "ledger with its own genesis", change: func(node *config.Config) { node.Canonical = false }},
+        {name: "canonical", change: func(node *config.Config) { node.Canonical = true }},
+        {name: "index of its own", change: func(node *config.Config) { node.Index.Path = "/tenants/a/index" }},
+        {name: "no rpc node", change: func(node *config.Config) { node.RPCURL = "" }, wantErr: "must be an http or https URL"},
+        {name: "archive", change: func(node *config.Config) { node.ArchiveDir = "archive" }, wantErr: "archiveDir belongs to the host"},
+        {name: "api keys", change: func(node *config.Config) { node.Auth.APIKeys = map[string]string{"key": "admin"} }, wantErr: "auth.apiKeys belongs to the host"},
+        {name: "replica", change: func(node *config.Config) { node.Replica.Primary = "primary:8080" }, wantErr: "replica.primary belongs to the host"},
+        {name: "burn addresses", change: func(node *config.Config) { node.Supply.BurnAddresses = []string{strings.Repeat("00", 20)} }, wantErr: "supply belongs to the host"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            node, err := config.Load(filepath.Join(t.TempDir(), "config.json"))
+            if err != nil {
+                t.Fatal(err)
+            }
+            test.change(node)
+            cfg := &Config{Port: 8080, Tenants: []Tenant{{Name: "a", Dir: "/tenants/a", RoutePrefix: "/a", node: node}}}
+
+            problems := cfg.Validate()
+            if test.wantErr == "" {
+                if len(problems) > 0 {
+                    t.Errorf("Validate() = %v, want no problems", problems)
+                }
+                return
+            }
+            if len(problems) != 1 || !strings.Contains(problems[0].Error(), test.wantErr) {
+                t.Errorf("Validate() = %v, want %q", problems, test.wantErr)
+            }
+        })
+    }
+}
@@ -0,0 +1,385 @@
+// Package index mirrors the committed receipts, transfers and balances of the node
+// into an embedded SQLite database for history queries, filtering and aggregation
+// the Merkle tree cannot serve. It is not part of the state: nodes never compare it,
+// and it can be deleted and rebuilt from the transaction log with replay -index.
+package index
+
+import (
+    "database/sql"
+    "errors"
+    "math/big"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Receipt is the outcome of a transaction. Failure is empty when it was applied.
+type Receipt struct {
+    Hash    string `json:"hash"`
+    Block   int64  `json:"block"`
+    Sender  string `json:"sender"`
+    Action  string `json:"action"`
+    Failure string `json:"failure,omitempty"`
+}
+
+// Transfer is a movement of a token between two accounts by a transaction
+type Transfer struct {
+    Hash     string `json:"hash"`
+    Block    int64  `json:"block"`
+    Sender   string `json:"sender"`
+    Receiver string `json:"receiver"`
+    Token    string `json:"token"`
+    Amount   string `json:"amount"`
+}
+
+// Balance is the native balance of an account and the checkpoint its last change
+// was committed at
+type Balance struct {
+    Address string `json:"address"`
+    Balance string `json:"balance"`
+    Block   int64  `json:"block"`
+}
+
+// schema creates the tables. Amounts and balances are decimal text, since they
+// exceed the integers of SQLite.
+const schema = `
+CREATE TABLE IF NOT EXISTS receipts (
+    id INTEGER PRIMARY KEY,
+    hash TEXT NOT NULL,
+    block INTEGER NOT NULL,
+    sender TEXT NOT NULL,
+    action TEXT NOT NULL,
+    failure TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS receipts_hash ON receipts (hash);
+CREATE INDEX IF NOT EXISTS receipts_sender ON receipts (sender, block);
+CREATE TABLE IF NOT EXISTS transfers (
+    id INTEGER PRIMARY KEY,
+    hash TEXT NOT NULL,
+    block INTEGER NOT NULL,
+    sender TEXT NOT NULL,
+    receiver TEXT NOT NULL,
+    token TEXT NOT NULL,
+    amount TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS transfers_sender ON transfers (sender, block);
+CREATE INDEX IF NOT EXISTS transfers_receiver ON transfers (receiver, block);
+CREATE TABLE IF NOT EXISTS balances (
+    address TEXT PRIMARY KEY,
+    balance TEXT NOT NULL,
+    block INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS meta (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+`
+
+// Index buffers the receipts, transfers and balance changes of a batch and writes
+// them once the batch is committed, so reverted changes never reach the database
+type Index struct {
+    db *sql.DB
+
+    mutex     sync.Mutex
+    receipts  []Receipt
+    transfers []Transfer
+    balances  map[string]string
+}
+
+// Open opens or creates the index at path
+func Open(path string) (*Index, error) {
+    if driver == "" {
+        return nil, errors.New("the index needs a build with -tags sqlite")
+    }
+    db, err := sql.Open(driver, path)
+    if err != nil {
+        return nil, err
+    }
+    // SQLite writes through one connection at a time
+    db.SetMaxOpenConns(1)
+    if _, err := db.Exec(schema); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &Index{db: db, balances: make(map[string]string)}, nil
+}
+
+// LastBlock returns the last block committed to the index, 0 for an empty one
+func (x *Index) LastBlock() (int64, error) {
+    var value string
+    err := x.db.QueryRow(`SELECT value FROM meta WHERE key = 'lastBlock'`).Scan(&value)
+    if errors.Is(err, sql.ErrNoRows) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    return strconv.ParseInt(value, 10, 64)
+}
+
+// RecordReceipt buffers the outcome of a transaction
+func (x *Index) RecordReceipt(receipt Receipt) {
+    x.mutex.Lock()
+    x.receipts = append(x.receipts, receipt)
+    x.mutex.Unlock()
+}
+
+// RecordTransfer buffers a transfer
+func (x *Index) RecordTransfer(transfer Transfer) {
+    x.mutex.Lock()
+    x.transfers = append(x.transfers, transfer)
+    x.mutex.Unlock()
+}
+
+// RecordBalance buffers the new balance of an account, keeping the last of a batch
+func (x *Index) RecordBalance(address string, balance *big.Int) {
+    x.mutex.Lock()
+    x.balances[address] = balance.String()
+    x.mutex.Unlock()
+}
+
+// Commit writes the buffered records of a committed batch in one transaction and
+// records block as the last block of the index
+func (x *Index) Commit(block int64) error {
+    x.mutex.Lock()
+    defer x.mutex.Unlock()
+
+    tx, err := x.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    for _, r := range x.receipts {
+        if _, err := tx.Exec(`INSERT INTO receipts (hash, block, sender, action, failure) VALUES (?, ?, ?, ?, ?)`, r.Hash, r.Block, r.Sender, r.Action, r.Failure); err != nil {
+            return err
+        }
+    }
+    for _, t := range x.transfers {
+        if _, err := tx.Exec(`INSERT INTO transfers (hash, block, sender, receiver, token, amount) VALUES (?, ?, ?, ?, ?, ?)`, t.Hash, t.Block, t.Sender, t.Receiver, t.Token, t.Amount); err != nil {
+            return err
+        }
+    }
+    for address, balance := range x.balances {
+        if _, err := tx.Exec(`INSERT INTO balances (address, balance, block) VALUES (?, ?, ?) ON CONFLICT (address) DO UPDATE SET balance = excluded.balance, block = excluded.block`, address, balance, block); err != nil {
+            return err
+        }
+    }
+    if _, err := tx.Exec(`INSERT INTO meta (key, value) VALUES ('lastBlock', ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`, strconv.FormatInt(block, 10)); err != nil {
+        return err
+    }
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+    x.receipts, x.transfers = nil, nil
+    x.balances = make(map[string]string)
+    return nil
+}
+
+// Discard drops the buffered records of a reverted batch
+func (x *Index) Discard() {
+    x.mutex.Lock()
+    x.receipts, x.transfers = nil, nil
+    x.balances = make(map[string]string)
+    x.mutex.Unlock()
+}
+
+// Close closes the database
+func (x *Index) Close() error {
+    return x.db.Close()
+}
+
+// Filter selects the rows of a query. Zero fields do not filter.
+type Filter struct {
+    // Address matches the sender or the receiver of a transfer, or the sender of a receipt
+    Address   string
+    Token     string
+    Action    string
+    Failed    *bool
+    FromBlock int64
+    ToBlock   int64
+    Limit     int
+}
+
+// where returns the conditions of the filter on the block column and its arguments
+func (f Filter) where(conditions []string, args []interface{}) (string, []interface{}) {
+    if f.FromBlock > 0 {
+        conditions = append(conditions, "block >= ?")
+        args = append(args, f.FromBlock)
+    }
+    if f.ToBlock > 0 {
+        conditions = append(conditions, "block <= ?")
+        args = append(args, f.ToBlock)
+    }
+    if len(conditions) == 0 {
+        return "", args
+    }
+    return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Transfers returns the transfers matching the filter, newest first
+func (x *Index) Transfers(f Filter) ([]Transfer, error) {
+    var conditions []string
+    var args []interface{}
+    if f.Address != "" {
+        conditions = append(conditions, "(sender = ? OR receiver = ?)")
+        args = append(args, f.Address, f.Address)
+    }
+    if f.Token != "" {
+        conditions = append(conditions, "token = ?")
+        args = append(args, f.Token)
+    }
+    where, args := f.where(conditions, args)
+    rows, err := x.db.Query(`SELECT hash, block, sender, receiver, token, amount FROM transfers`+where+` ORDER BY id DESC LIMIT ?`, append(args, f.Limit)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    transfers := []Transfer{}
+    for rows.Next() {
+        var t Transfer
+        if err := rows.Scan(&t.Hash, &t.Block, &t.Sender, &t.Receiver, &t.Token, &t.Amount); err != nil {
+            return nil, err
+        }
+        transfers = append(transfers, t)
+    }
+    return transfers, rows.Err()
+}
+
+// Receipts returns the receipts matching the filter, newest first
+func (x *Index) Receipts(f Filter) ([]Receipt, error) {
+    var conditions []string
+    var args []interface{}
+    if f.Address != "" {
+        conditions = append(conditions, "sender = ?")
+        args = append(args, f.Address)
+    }
+    if f.Action != "" {
+        conditions = append(conditions, "action = ?")
+        args = append(args, f.Action)
+    }
+    if f.Failed != nil {
+        if *f.Failed {
+            conditions = append(conditions, "failure <> ''")
+        } else {
+            conditions = append(conditions, "failure = ''")
+        }
+    }
+    where, args := f.where(conditions, args)
+    rows, err := x.db.Query(`SELECT hash, block, sender, action, failure FROM receipts`+where+` ORDER BY id DESC LIMIT ?`, append(args, f.Limit)...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    receipts := []Receipt{}
+    for rows.Next() {
+        var r Receipt
+        if err := rows.Scan(&r.Hash, &r.Block, &r.Sender, &r.Action, &r.Failure); err != nil {
+            return nil, err
+        }
+        receipts = append(receipts, r)
+    }
+    return receipts, rows.Err()
+}
+
+// Balances returns the largest balances of at least min, largest first
+func (x *Index) Balances(min *big.Int, limit int) ([]Balance, error) {
+    // Decimal text without leading zeros orders by length, then lexically
+    rows, err := x.db.Query(`SELECT address, balance, block FROM balances ORDER BY length(balance) DESC, balance DESC`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    balances := []Balance{}
+    for rows.Next() && len(balances) < limit {
+        var b Balance
+        if err := rows.Scan(&b.Address, &b.Balance, &b.Block); err != nil {
+            return nil, err
+        }
+        if value, ok := new(big.Int).SetString(b.Balance, 10); !ok || value.Cmp(min) < 0 {
+            break
+        }
+        balances = append(balances, b)
+    }
+    return balances, rows.Err()
+}
+
+// ActionStats counts the applied and failed transactions of an action
+type ActionStats struct {
+    Action  string `json:"action"`
+    Applied int64  `json:"applied"`
+    Failed  int64  `json:"failed"`
+}
+
+// TokenVolume is the number and total amount of the transfers of a token
+type TokenVolume struct {
+    Token     string `json:"token"`
+    Transfers int64  `json:"transfers"`
+    Volume    string `json:"volume"`
+}
+
+// Stats aggregates the receipts and transfers in the block range of the filter
+type Stats struct {
+    Actions []ActionStats `json:"actions"`
+    Volumes []TokenVolume `json:"volumes"`
+}
+
+// Stats counts transactions per action and sums transfers per token, of the
+// account of the filter when it has one
+func (x *Index) Stats(f Filter) (Stats, error) {
+    stats := Stats{Actions: []ActionStats{}, Volumes: []TokenVolume{}}
+    var conditions []string
+    var args []interface{}
+    if f.Address != "" {
+        conditions = append(conditions, "sender = ?")
+        args = append(args, f.Address)
+    }
+    where, args := f.where(conditions, args)
+    rows, err := x.db.Query(`SELECT action, SUM(failure = ''), SUM(failure <> '') FROM receipts`+where+` GROUP BY action ORDER BY action`, args...)
+    if err != nil {
+        return stats, err
+    }
+    for rows.Next() {
+        var s ActionStats
+        if err := rows.Scan(&s.Action, &s.Applied, &s.Failed); err != nil {
+            rows.Close()
+            return stats, err
+        }
+        stats.Actions = append(stats.Actions, s)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return stats, err
+    }
+
+    conditions, args = nil, nil
+    if f.Address != "" {
+        conditions = append(conditions, "(sender = ? OR receiver = ?)")
+        args = append(args, f.Address, f.Address)
+    }
+    where, args = f.where(conditions, args)
+    // SQLite would sum the decimal amounts as floats, so they are added here
+    rows, err = x.db.Query(`SELECT token, amount FROM transfers`+where+` ORDER BY token`, args...)
+    if err != nil {
+        return stats, err
+    }
+    defer rows.Close()
+    var totals []*big.Int
+    for rows.Next() {
+        var token, amount string
+        if err := rows.Scan(&token, &amount); err != nil {
+            return stats, err
+        }
+        if n := len(stats.Volumes); n == 0 || stats.Volumes[n-1].Token != token {
+            stats.Volumes = append(stats.Volumes, TokenVolume{Token: token})
+            totals = append(totals, new(big.Int))
+        }
+        stats.Volumes[len(stats.Volumes)-1].Transfers++
+        if value, ok := new(big.Int).SetString(amount, 10); ok {
+            totals[len(totals)-1].Add(totals[len(totals)-1], value)
+        }
+    }
+    for i, total := range totals {
+        stats.Volumes[i].Volume = total.String()
+    }
+    return stats, rows.Err()
+}
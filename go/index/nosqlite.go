@@ -0,0 +1,6 @@
+//go:build !sqlite
+
+package index
+
+// driver is empty: SQLite is a C library, only linked in a build with -tags sqlite
+const driver = ""
@@ -0,0 +1,10 @@
+//go:build sqlite
+
+package index
+
+import (
+    _ "github.com/mattn/go-sqlite3"
+)
+
+// driver is the database/sql driver of SQLite, which links the C library
+const driver = "sqlite3"
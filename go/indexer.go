@@ -0,0 +1,148 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "math/big"
+    "strings"
+
+    "pwr-stateful-vida/index"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/mirror"
+    "pwr-stateful-vida/publish"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// openIndex opens the configured index and brings its balances up to the state. An
+// index that was not kept with the state, because it is new or the node ran
+// without it, has its balances copied from the state, while its history misses the
+// blocks in between until it is rebuilt with replay -index.
+func (a *App) openIndex(path string) (*index.Index, error) {
+    idx, err := index.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    indexed, err := idx.LastBlock()
+    if err != nil {
+        idx.Close()
+        return nil, err
+    }
+    checkpoint, _ := a.db.GetLastCheckedBlock()
+    if indexed == checkpoint {
+        return idx, nil
+    }
+    if indexed > 0 {
+        logger.Warn("index is not at the checkpoint, its history has a gap until it is rebuilt with replay -index", "indexBlock", indexed, "checkpoint", checkpoint)
+    }
+    err = a.db.ForEachAccount(nil, func(address []byte, balance *big.Int) bool {
+        idx.RecordBalance(hex.EncodeToString(address), balance)
+        return true
+    })
+    if err == nil {
+        err = idx.Commit(checkpoint)
+    }
+    if err != nil {
+        idx.Close()
+        return nil, err
+    }
+    return idx, nil
+}
+
+// observeBalance passes a balance write to the audit log, the index and the mirror
+func (a *App) observeBalance(address []byte, old, new *big.Int) {
+    if a.auditLog != nil {
+        a.auditLog.Record(address, old, new)
+    }
+    if a.index != nil {
+        a.index.RecordBalance(hex.EncodeToString(address), new)
+    }
+    if a.mirror != nil {
+        a.mirror.RecordBalance(hex.EncodeToString(address), new)
+    }
+}
+
+// indexReceipt records the outcome of a transaction in the index
+func (a *App) indexReceipt(transaction rpc.VidaDataTransaction, label, failure string) {
+    if a.index == nil {
+        return
+    }
+    a.index.RecordReceipt(index.Receipt{
+        Hash:    transaction.Hash,
+        Block:   int64(transaction.BlockNumber),
+        Sender:  strings.TrimPrefix(strings.ToLower(transaction.Sender), "0x"),
+        Action:  label,
+        Failure: failure,
+    })
+}
+
+// observeTransfer records a transfer of the transaction ctx belongs to in the
+// index, the events to publish and the mirror
+func (a *App) observeTransfer(ctx context.Context, block int64, sender, receiver []byte, token string, amount *big.Int) {
+    if a.index == nil && a.publisher == nil && a.mirror == nil {
+        return
+    }
+    if tokens.IsNative(token) {
+        token = tokens.Native
+    }
+    hash := logging.CorrelationID(ctx)
+    if a.index != nil {
+        a.index.RecordTransfer(index.Transfer{
+            Hash:     hash,
+            Block:    block,
+            Sender:   hex.EncodeToString(sender),
+            Receiver: hex.EncodeToString(receiver),
+            Token:    token,
+            Amount:   amount.String(),
+        })
+    }
+    if a.publisher != nil {
+        a.publisher.Add(publish.Event{
+            Type:     publish.TypeTransfer,
+            Block:    block,
+            Hash:     hash,
+            Sender:   hex.EncodeToString(sender),
+            Receiver: hex.EncodeToString(receiver),
+            Token:    token,
+            Amount:   amount.String(),
+        })
+    }
+    if a.mirror != nil {
+        a.mirror.RecordTransfer(mirror.Transfer{
+            Hash:     hash,
+            Block:    block,
+            Sender:   hex.EncodeToString(sender),
+            Receiver: hex.EncodeToString(receiver),
+            Token:    token,
+            Amount:   amount.String(),
+        })
+    }
+}
+
+// commitIndex writes the records of a committed batch to the index. The index is
+// not part of the state, so a failure is reported without stopping the node.
+func (a *App) commitIndex(block int64) {
+    if a.index == nil {
+        return
+    }
+    if err := a.index.Commit(block); err != nil {
+        syncLogger.Error("failed to write index", "block", block, "error", err)
+        reporting.Report(err, reporting.Context{Module: "index", Block: block})
+    }
+}
+
+// discardRecords drops what the index, the publisher and the mirror buffered for a
+// reverted batch
+func (a *App) discardRecords() {
+    if a.index != nil {
+        a.index.Discard()
+    }
+    if a.publisher != nil {
+        a.publisher.Discard()
+    }
+    if a.mirror != nil {
+        a.mirror.Discard()
+    }
+}
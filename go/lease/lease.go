@@ -0,0 +1,206 @@
+// Package lease implements the fencing lease of a failover pair: a file on storage
+// both nodes reach that names the node allowed to write checkpoints. Every takeover
+// increments the epoch of the lease, so a node that lost it notices on its next check.
+package lease
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// ErrLost is returned when another node took the lease
+var ErrLost = errors.New("lease is held by another node")
+
+// Lease is the content of the lease file
+type Lease struct {
+    Holder  string    `json:"holder"`
+    Epoch   int64     `json:"epoch"`
+    Expires time.Time `json:"expires"`
+}
+
+// File is the lease file as seen by one node
+type File struct {
+    mutex    sync.Mutex
+    path     string
+    holder   string
+    duration time.Duration
+    // epoch is the epoch of the lease this node holds, 0 when it holds none
+    epoch int64
+    // validUntil is when the held lease expires by the local clock, measured from
+    // before it was written so it never outlasts the expiry in the file
+    validUntil time.Time
+}
+
+// Open returns the lease file at path for the node named holder
+func Open(path, holder string, duration time.Duration) *File {
+    return &File{path: path, holder: holder, duration: duration}
+}
+
+// read returns the current lease, a zero one when the file does not exist
+func (f *File) read() (Lease, error) {
+    var current Lease
+    data, err := os.ReadFile(f.path)
+    if os.IsNotExist(err) {
+        return current, nil
+    }
+    if err != nil {
+        return current, err
+    }
+    if err := json.Unmarshal(data, &current); err != nil {
+        return current, fmt.Errorf("%s: %v", f.path, err)
+    }
+    return current, nil
+}
+
+// write replaces the lease file and syncs it
+func (f *File) write(next Lease) error {
+    data, err := json.Marshal(next)
+    if err != nil {
+        return err
+    }
+    tmp := f.path + ".tmp"
+    file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+    if err != nil {
+        return err
+    }
+    if _, err := file.Write(data); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Sync(); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp, f.path)
+}
+
+// update runs fn on the current lease while holding the lock file, writing the
+// lease it returns unless that is nil
+func (f *File) update(fn func(current Lease) (*Lease, error)) error {
+    if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+        return err
+    }
+    unlock, err := lockFile(f.path + ".lock")
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    current, err := f.read()
+    if err != nil {
+        return err
+    }
+    next, err := fn(current)
+    if err != nil || next == nil {
+        return err
+    }
+    return f.write(*next)
+}
+
+// Acquire takes the lease when it has expired or is already held by this node,
+// starting a new epoch. It returns the current lease whether or not it was taken.
+func (f *File) Acquire() (bool, Lease, error) {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+
+    start := time.Now()
+    acquired := false
+    var result Lease
+    err := f.update(func(current Lease) (*Lease, error) {
+        result = current
+        if current.Holder != f.holder && current.Expires.After(start) {
+            return nil, nil
+        }
+        result = Lease{Holder: f.holder, Epoch: current.Epoch + 1, Expires: start.Add(f.duration)}
+        acquired = true
+        return &result, nil
+    })
+    if err != nil || !acquired {
+        return false, result, err
+    }
+    f.epoch, f.validUntil = result.Epoch, start.Add(f.duration)
+    return true, result, nil
+}
+
+// Renew extends the held lease, returning ErrLost once another node took it
+func (f *File) Renew() error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if f.epoch == 0 {
+        return ErrLost
+    }
+
+    start := time.Now()
+    err := f.update(func(current Lease) (*Lease, error) {
+        if current.Holder != f.holder || current.Epoch != f.epoch {
+            return nil, ErrLost
+        }
+        current.Expires = start.Add(f.duration)
+        return &current, nil
+    })
+    if errors.Is(err, ErrLost) {
+        f.epoch = 0
+    }
+    if err != nil {
+        return err
+    }
+    f.validUntil = start.Add(f.duration)
+    return nil
+}
+
+// Check reports whether this node still holds the lease with at least a third of
+// its duration left, so that a checkpoint started now completes before the other
+// node could take over. It reads the file to notice a takeover right away.
+func (f *File) Check() error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if f.epoch == 0 {
+        return ErrLost
+    }
+    if time.Until(f.validUntil) < f.duration/3 {
+        return fmt.Errorf("lease of epoch %d was not renewed in time", f.epoch)
+    }
+    current, err := f.read()
+    if err != nil {
+        return err
+    }
+    if current.Holder != f.holder || current.Epoch != f.epoch {
+        f.epoch = 0
+        return ErrLost
+    }
+    return nil
+}
+
+// Release lets the lease expire now if this node still holds it, so the other node
+// can take over without waiting
+func (f *File) Release() error {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    if f.epoch == 0 {
+        return nil
+    }
+    epoch := f.epoch
+    f.epoch = 0
+    return f.update(func(current Lease) (*Lease, error) {
+        if current.Holder != f.holder || current.Epoch != epoch {
+            return nil, nil
+        }
+        current.Expires = time.Now()
+        return &current, nil
+    })
+}
+
+// Epoch returns the epoch of the held lease, 0 when this node holds none
+func (f *File) Epoch() int64 {
+    f.mutex.Lock()
+    defer f.mutex.Unlock()
+    return f.epoch
+}
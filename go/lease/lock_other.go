@@ -0,0 +1,10 @@
+//go:build !unix
+
+package lease
+
+import "errors"
+
+// lockFile is not implemented on this platform
+func lockFile(path string) (func(), error) {
+    return nil, errors.New("lease files are not available on this platform")
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package lease
+
+import (
+    "os"
+    "syscall"
+)
+
+// lockFile takes an exclusive lock on path, waiting for the other node to release it
+func lockFile(path string) (func(), error) {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+    if err != nil {
+        return nil, err
+    }
+    if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+        file.Close()
+        return nil, err
+    }
+    return func() {
+        syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+        file.Close()
+    }, nil
+}
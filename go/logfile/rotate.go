@@ -0,0 +1,151 @@
+// Package logfile writes logs to a file that is rotated by size and age.
+package logfile
+
+import (
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// backupTimeFormat is appended to the file name of rotated logs
+const backupTimeFormat = "20060102-150405.000"
+
+// Writer is an io.Writer appending to a log file. The file is renamed with a
+// timestamp suffix when it grows past MaxSize bytes or is older than MaxAge, and
+// only the newest MaxBackups rotated files are kept. Zero values disable a limit.
+type Writer struct {
+    Path       string
+    MaxSize    int64
+    MaxAge     time.Duration
+    MaxBackups int
+
+    mutex  sync.Mutex
+    file   *os.File
+    size   int64
+    opened time.Time
+}
+
+// open opens the log file for appending
+func (w *Writer) open() error {
+    if err := os.MkdirAll(filepath.Dir(w.Path), 0755); err != nil {
+        return err
+    }
+    file, err := os.OpenFile(w.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return err
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return err
+    }
+    w.file = file
+    w.size = info.Size()
+    w.opened = time.Now()
+    return nil
+}
+
+// Write appends p to the log, rotating first if a limit was reached
+func (w *Writer) Write(p []byte) (int, error) {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+
+    if w.file == nil {
+        if err := w.open(); err != nil {
+            return 0, err
+        }
+    }
+
+    full := w.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.MaxSize
+    expired := w.MaxAge > 0 && time.Since(w.opened) >= w.MaxAge
+    if full || expired {
+        if err := w.rotate(); err != nil {
+            return 0, err
+        }
+    }
+
+    n, err := w.file.Write(p)
+    w.size += int64(n)
+    return n, err
+}
+
+// Rotate closes the current file and starts a new one
+func (w *Writer) Rotate() error {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    return w.rotate()
+}
+
+func (w *Writer) rotate() error {
+    if w.file != nil {
+        w.file.Close()
+        w.file = nil
+    }
+
+    backup := w.Path + "." + time.Now().Format(backupTimeFormat)
+    if _, err := os.Stat(w.Path); err == nil {
+        if err := os.Rename(w.Path, backup); err != nil {
+            return err
+        }
+    }
+    w.prune()
+    return w.open()
+}
+
+// prune removes the oldest rotated files beyond MaxBackups
+func (w *Writer) prune() {
+    if w.MaxBackups <= 0 {
+        return
+    }
+    backups, err := filepath.Glob(w.Path + ".*")
+    if err != nil {
+        return
+    }
+    // The timestamp suffix sorts chronologically
+    sort.Strings(backups)
+    for len(backups) > w.MaxBackups {
+        if strings.HasPrefix(filepath.Base(backups[0]), filepath.Base(w.Path)+".") {
+            os.Remove(backups[0])
+        }
+        backups = backups[1:]
+    }
+}
+
+// Files returns the rotated files of the log at path, oldest first, followed by the
+// current file if it exists
+func Files(path string) ([]string, error) {
+    files, err := filepath.Glob(path + ".*")
+    if err != nil {
+        return nil, err
+    }
+    sort.Strings(files)
+    if _, err := os.Stat(path); err == nil {
+        files = append(files, path)
+    }
+    return files, nil
+}
+
+// Sync commits the current file to disk
+func (w *Writer) Sync() error {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    if w.file == nil {
+        return nil
+    }
+    return w.file.Sync()
+}
+
+// Close closes the current file
+func (w *Writer) Close() error {
+    w.mutex.Lock()
+    defer w.mutex.Unlock()
+    if w.file == nil {
+        return nil
+    }
+    err := w.file.Close()
+    w.file = nil
+    return err
+}
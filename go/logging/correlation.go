@@ -0,0 +1,21 @@
+package logging
+
+import "context"
+
+// correlationKey is the context key of the correlation ID
+type correlationKey struct{}
+
+// WithCorrelationID returns a context carrying id. Records logged with the context
+// include it as the correlationId attribute.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+    return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID carried by ctx, or an empty string
+func CorrelationID(ctx context.Context) string {
+    if ctx == nil {
+        return ""
+    }
+    id, _ := ctx.Value(correlationKey{}).(string)
+    return id
+}
@@ -0,0 +1,151 @@
+// Package logging provides leveled, structured loggers for the node's modules.
+// Loggers can be created before the configuration is loaded; Setup changes the
+// output format and levels of every logger at once.
+package logging
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "strings"
+    "sync"
+
+    "pwr-stateful-vida/config"
+)
+
+var (
+    mutex   sync.RWMutex
+    output  = fanout{{handler: newHandler("text", stdout{}, false), minimum: slog.LevelDebug}}
+    closers []io.Closer
+    level   = slog.LevelInfo
+    modules = make(map[string]slog.Level)
+)
+
+func init() {
+    config.SetLogger(For("config"))
+}
+
+// stdout writes to the current os.Stdout, which is redirected by the daemon and
+// silenced by offline commands after loggers are created
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+    return os.Stdout.Write(p)
+}
+
+// newHandler returns the handler formatting records for w. Filtering by level
+// happens before records reach it.
+func newHandler(format string, w io.Writer, omitTime bool) slog.Handler {
+    options := &slog.HandlerOptions{Level: slog.LevelDebug}
+    if omitTime {
+        options.ReplaceAttr = func(groups []string, attr slog.Attr) slog.Attr {
+            if len(groups) == 0 && attr.Key == slog.TimeKey {
+                return slog.Attr{}
+            }
+            return attr
+        }
+    }
+    if format == "json" {
+        return slog.NewJSONHandler(w, options)
+    }
+    return slog.NewTextHandler(w, options)
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+    var parsed slog.Level
+    if err := parsed.UnmarshalText([]byte(name)); err != nil {
+        return 0, fmt.Errorf("unknown log level %q", name)
+    }
+    return parsed, nil
+}
+
+// Setup applies the logging configuration to all loggers and opens its outputs,
+// closing those of the previous configuration
+func Setup(cfg config.LoggingConfig) error {
+    if cfg.Format != "" && cfg.Format != "text" && cfg.Format != "json" {
+        return fmt.Errorf("unknown log format %q", cfg.Format)
+    }
+    defaultLevel, err := ParseLevel(cfg.Level)
+    if err != nil {
+        return err
+    }
+    moduleLevels := make(map[string]slog.Level)
+    for module, name := range cfg.Modules {
+        moduleLevel, err := ParseLevel(name)
+        if err != nil {
+            return fmt.Errorf("module %s: %v", module, err)
+        }
+        moduleLevels[strings.ToLower(module)] = moduleLevel
+    }
+    outputs, opened, err := openOutputs(cfg)
+    if err != nil {
+        return err
+    }
+
+    mutex.Lock()
+    previous := closers
+    output = outputs
+    closers = opened
+    level = defaultLevel
+    modules = moduleLevels
+    mutex.Unlock()
+
+    for _, closer := range previous {
+        closer.Close()
+    }
+    return nil
+}
+
+// For returns the logger of a module. Its records carry a module attribute and are
+// filtered by the module's level, or the default level if it has none.
+func For(module string) *slog.Logger {
+    return slog.New(&moduleHandler{module: strings.ToLower(module)})
+}
+
+// moduleHandler filters records by module level and forwards them to the current output
+type moduleHandler struct {
+    module string
+    // wrap applies the attributes and groups added with WithAttrs and WithGroup
+    wrap []func(slog.Handler) slog.Handler
+}
+
+func (h *moduleHandler) Enabled(ctx context.Context, l slog.Level) bool {
+    mutex.RLock()
+    defer mutex.RUnlock()
+    if !output.Enabled(ctx, l) {
+        return false
+    }
+    if moduleLevel, ok := modules[h.module]; ok {
+        return l >= moduleLevel
+    }
+    return l >= level
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, record slog.Record) error {
+    mutex.RLock()
+    handler := output.WithAttrs([]slog.Attr{slog.String("module", h.module)})
+    mutex.RUnlock()
+    for _, wrap := range h.wrap {
+        handler = wrap(handler)
+    }
+    if id := CorrelationID(ctx); id != "" {
+        record = record.Clone()
+        record.AddAttrs(slog.String("correlationId", id))
+    }
+    return handler.Handle(ctx, record)
+}
+
+func (h *moduleHandler) with(wrap func(slog.Handler) slog.Handler) *moduleHandler {
+    return &moduleHandler{module: h.module, wrap: append(h.wrap[:len(h.wrap):len(h.wrap)], wrap)}
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+    return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
@@ -0,0 +1,143 @@
+package logging
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log/slog"
+    "time"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/logfile"
+)
+
+// destination is an output handler with its own minimum level
+type destination struct {
+    handler slog.Handler
+    minimum slog.Level
+}
+
+// fanout writes every record to the destinations whose level it reaches
+type fanout []destination
+
+func (f fanout) Enabled(ctx context.Context, l slog.Level) bool {
+    return l >= f.minimum()
+}
+
+func (f fanout) Handle(ctx context.Context, record slog.Record) error {
+    var first error
+    for _, d := range f {
+        if record.Level < d.minimum {
+            continue
+        }
+        if err := d.handler.Handle(ctx, record.Clone()); err != nil && first == nil {
+            first = err
+        }
+    }
+    return first
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return f.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+    return f.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (f fanout) with(wrap func(slog.Handler) slog.Handler) fanout {
+    wrapped := make(fanout, len(f))
+    for i, d := range f {
+        wrapped[i] = destination{handler: wrap(d.handler), minimum: d.minimum}
+    }
+    return wrapped
+}
+
+// minimum returns the lowest level any destination writes
+func (f fanout) minimum() slog.Level {
+    if len(f) == 0 {
+        return slog.LevelError + 1
+    }
+    lowest := f[0].minimum
+    for _, d := range f[1:] {
+        lowest = min(lowest, d.minimum)
+    }
+    return lowest
+}
+
+// openOutputs builds the destinations of the configuration, writing to stdout when
+// none are configured. The returned closers release files and connections.
+func openOutputs(cfg config.LoggingConfig) (fanout, []io.Closer, error) {
+    outputs := cfg.Outputs
+    if len(outputs) == 0 {
+        outputs = []config.LogOutputConfig{{Type: "stdout"}}
+    }
+
+    var destinations fanout
+    var closers []io.Closer
+    for i, output := range outputs {
+        d, closer, err := openOutput(output, cfg.Format)
+        if err != nil {
+            for _, c := range closers {
+                c.Close()
+            }
+            return nil, nil, fmt.Errorf("output %d (%s): %v", i, output.Type, err)
+        }
+        destinations = append(destinations, d)
+        if closer != nil {
+            closers = append(closers, closer)
+        }
+    }
+    return destinations, closers, nil
+}
+
+// openOutput builds a single destination
+func openOutput(output config.LogOutputConfig, defaultFormat string) (destination, io.Closer, error) {
+    format := output.Format
+    if format == "" {
+        format = defaultFormat
+    }
+    if format != "" && format != "text" && format != "json" {
+        return destination{}, nil, fmt.Errorf("unknown log format %q", format)
+    }
+
+    minimum := slog.LevelDebug
+    if output.Level != "" {
+        parsed, err := ParseLevel(output.Level)
+        if err != nil {
+            return destination{}, nil, err
+        }
+        minimum = parsed
+    }
+
+    switch output.Type {
+    case "stdout":
+        return destination{handler: newHandler(format, stdout{}, false), minimum: minimum}, nil, nil
+    case "file":
+        if output.Path == "" {
+            return destination{}, nil, fmt.Errorf("no path given")
+        }
+        maxAge, err := time.ParseDuration(output.MaxAge)
+        if output.MaxAge != "" && err != nil {
+            return destination{}, nil, fmt.Errorf("maxAge: %v", err)
+        }
+        writer := &logfile.Writer{
+            Path:       output.Path,
+            MaxSize:    int64(output.MaxSizeMB) << 20,
+            MaxAge:     maxAge,
+            MaxBackups: output.MaxBackups,
+        }
+        return destination{handler: newHandler(format, writer, false), minimum: minimum}, writer, nil
+    case "syslog":
+        tag := output.Tag
+        if tag == "" {
+            tag = "vida"
+        }
+        handler, closer, err := newSyslogHandler(output.Network, output.Address, tag, format)
+        if err != nil {
+            return destination{}, nil, err
+        }
+        return destination{handler: handler, minimum: minimum}, closer, nil
+    }
+    return destination{}, nil, fmt.Errorf("unknown output type %q", output.Type)
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package logging
+
+import (
+    "errors"
+    "io"
+    "log/slog"
+)
+
+// newSyslogHandler fails because syslog is only available on Unix systems
+func newSyslogHandler(network, address, tag, format string) (slog.Handler, io.Closer, error) {
+    return nil, nil, errors.New("syslog is not supported on this platform")
+}
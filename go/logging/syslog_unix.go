@@ -0,0 +1,76 @@
+//go:build unix
+
+package logging
+
+import (
+    "context"
+    "io"
+    "log/slog"
+    "log/syslog"
+    "strings"
+)
+
+// syslogHandler sends records to syslog with the severity matching their level.
+// Syslog timestamps its messages, so the record time is left out.
+type syslogHandler struct {
+    // handlers format debug, info, warn and error records
+    handlers [4]slog.Handler
+}
+
+// syslogMessage writes each formatted record as one syslog message
+type syslogMessage func(message string) error
+
+func (w syslogMessage) Write(p []byte) (int, error) {
+    return len(p), w(strings.TrimSuffix(string(p), "\n"))
+}
+
+// newSyslogHandler connects to the syslog server at address, or to the local syslog
+// daemon when network and address are empty
+func newSyslogHandler(network, address, tag, format string) (slog.Handler, io.Closer, error) {
+    writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+    if err != nil {
+        return nil, nil, err
+    }
+    h := &syslogHandler{}
+    for i, write := range []syslogMessage{writer.Debug, writer.Info, writer.Warning, writer.Err} {
+        h.handlers[i] = newHandler(format, write, true)
+    }
+    return h, writer, nil
+}
+
+// severity returns the index in handlers of a level
+func severity(l slog.Level) int {
+    switch {
+    case l < slog.LevelInfo:
+        return 0
+    case l < slog.LevelWarn:
+        return 1
+    case l < slog.LevelError:
+        return 2
+    }
+    return 3
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+    return true
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, record slog.Record) error {
+    return h.handlers[severity(record.Level)].Handle(ctx, record)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    wrapped := &syslogHandler{}
+    for i, handler := range h.handlers {
+        wrapped.handlers[i] = handler.WithAttrs(attrs)
+    }
+    return wrapped
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+    wrapped := &syslogHandler{}
+    for i, handler := range h.handlers {
+        wrapped.handlers[i] = handler.WithGroup(name)
+    }
+    return wrapped
+}
@@ -2,42 +2,47 @@ package main
 
 import (
     "encoding/hex"
+    "errors"
     "fmt"
     "math/big"
+    "net"
+    "net/http"
     "os"
     "os/signal"
+    "runtime/debug"
+    "strconv"
     "syscall"
 
     "pwr-stateful-vida/api"
-    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/canonical"
+    "pwr-stateful-vida/chaos"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/diagnostics"
+    "pwr-stateful-vida/explorer"
+    "pwr-stateful-vida/grpcapi"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/params"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/sdk"
+    "pwr-stateful-vida/signing"
 
     "github.com/gin-gonic/gin"
+    "google.golang.org/grpc"
 )
 
-// Constants
-const (
-    VIDA_ID     = 73746238
-    START_BLOCK = 1
-    PORT        = 8080
-    RPC_URL     = "https://pwrrpc.pwrlabs.io"
-)
-
-// initializePeers initializes peer list from arguments or defaults
-func initializePeers() {
-    if len(os.Args) > 1 {
-        peersToCheckRootHashWith = os.Args[1:]
-        fmt.Printf("Using peers from args: %v\n", peersToCheckRootHashWith)
-    } else {
-        peersToCheckRootHashWith = []string{"localhost:8080"}
-        fmt.Printf("Using default peers: %v\n", peersToCheckRootHashWith)
-    }
-}
+// logger is the log of the node lifecycle
+var logger = logging.For("node")
 
 // initInitialBalances sets up the initial account balances when starting from a fresh database
-func initInitialBalances() {
-    lastBlock, _ := dbservice.GetLastCheckedBlock()
+func (a *App) initInitialBalances() {
+    lastBlock, _ := a.db.GetLastCheckedBlock()
     if lastBlock == 0 {
-        fmt.Println("Setting up initial balances for fresh database")
+        logger.Info("setting up initial balances for fresh database")
+
+        if a.config().Canonical {
+            a.initCanonicalBalances()
+            return
+        }
 
         initialBalances := map[string]*big.Int{
             "c767ea1d613eefe0ce1610b18cb047881bafb829": big.NewInt(1000000000000),
@@ -45,53 +50,256 @@ func initInitialBalances() {
             "9282d39ca205806473f4fde5bac48ca6dfb9d300": big.NewInt(1000000000000),
             "e68191b7913e72e6f1759531fbfaa089ff02308a": big.NewInt(1000000000000),
         }
+        if path := a.config().GenesisFile; path != "" {
+            genesis, err := config.LoadGenesis(path)
+            if err != nil {
+                logger.Error("failed to read the genesis file", "path", path, "error", err)
+                return
+            }
+            if err := params.Record(a.db, genesis.Params); err != nil {
+                logger.Error("failed to record the genesis params", "error", err)
+                return
+            }
+            initialBalances = genesis.Balances
+        }
 
-        for addressHex, balance := range initialBalances {
-            address, _ := hex.DecodeString(addressHex)
-            dbservice.SetBalance(address, balance)
+        if err := sdk.ApplyGenesis(a.db, initialBalances); err != nil {
+            logger.Error("failed to set up initial balances", "error", err)
+            return
         }
-        fmt.Println("Initial balances setup completed")
+        logger.Info("initial balances setup completed", "accounts", len(initialBalances))
     }
 }
 
-// startAPIServer initializes and starts the HTTP API server
-func startAPIServer() {
+// initCanonicalBalances writes the genesis accounts of package canonical in their order
+func (a *App) initCanonicalBalances() {
+    if err := sdk.ApplyCanonicalGenesis(a.db); err != nil {
+        logger.Error("failed to set up initial balances", "error", err)
+        return
+    }
+    logger.Info("initial balances setup completed", "accounts", len(canonical.Genesis))
+}
+
+// chainParams returns the params recorded at genesis, which every node applies alike
+func (a *App) chainParams() config.Params {
+    current, err := params.Current(a.db)
+    if err != nil {
+        logger.Error("failed to read the genesis params", "error", err)
+    }
+    return current
+}
+
+// newRouter returns the router serving the HTTP API of the app
+func (a *App) newRouter() *gin.Engine {
     gin.SetMode(gin.ReleaseMode)
     router := gin.New()
-    api.RegisterRoutes(router)
 
-    fmt.Printf("Starting HTTP server on port %d\n", PORT)
-    router.Run(fmt.Sprintf(":%d", PORT))
+    httpConfig := a.config().HTTP
+    router.Use(api.RequestID())
+    if httpConfig.AccessLog {
+        router.Use(api.AccessLog(httpConfig.AccessLogSampleRate))
+    }
+    api.RegisterRoutes(a.db, router)
+    api.RegisterAdminRoutes(a.db, router, a.adminActions())
+    api.RegisterQueryRoutes(router, a.machine())
+    if a.index != nil {
+        api.RegisterIndexRoutes(router, a.index)
+    }
+    if httpConfig.Explorer {
+        explorer.Register(router)
+    }
+    if a.config().Faucet.Enabled {
+        if faucet, err := newFaucet(); err != nil {
+            logger.Error("faucet disabled", "error", err)
+        } else {
+            api.RegisterFaucetRoutes(a.db, router, faucet)
+            logger.Info("faucet enabled", "address", hex.EncodeToString(faucet.Address()), "amount", a.config().Faucet.Amount)
+        }
+    }
+    return router
+}
+
+// startAPIServer initializes and starts the HTTP API server
+func (a *App) startAPIServer() {
+    router := a.newRouter()
+    httpConfig := a.config().HTTP
+
+    listener, err := net.Listen("tcp", fmt.Sprintf(":%d", httpConfig.Port))
+    if err != nil {
+        logger.Error("failed to listen on HTTP port", "port", httpConfig.Port, "error", err)
+        return
+    }
+
+    server := api.NewServer(router, httpConfig)
+    server.TLSConfig, err = api.ServerTLS(httpConfig.TLS)
+    if err != nil {
+        logger.Error("failed to load TLS configuration", "error", err)
+        listener.Close()
+        return
+    }
+    listener = api.LimitListener(listener, httpConfig.MaxConnections)
+
+    logger.Info("starting HTTP server", "port", httpConfig.Port, "maxConnections", httpConfig.MaxConnections, "tls", server.TLSConfig != nil)
+    a.httpServer = server
+    go func() {
+        var err error
+        if server.TLSConfig != nil {
+            err = server.ServeTLS(listener, "", "")
+        } else {
+            err = server.Serve(listener)
+        }
+        if !errors.Is(err, http.ErrServerClosed) {
+            logger.Error("HTTP server stopped", "port", httpConfig.Port, "error", err)
+        }
+    }()
 }
 
-// main is the application entry point for synchronizing VIDA transactions
-func main() {
-    fmt.Println("Starting PWR VIDA Transaction Synchronizer...")
+// startGRPCServer initializes and starts the gRPC API server
+func (a *App) startGRPCServer() {
+    cfg := a.config().GRPC
+    port := cfg.Port
+    options, err := grpcapi.ServerOptions(cfg)
+    if err != nil {
+        logger.Error("failed to configure gRPC server", "port", port, "error", err)
+        return
+    }
+    listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+    if err != nil {
+        logger.Error("failed to listen on gRPC port", "port", port, "error", err)
+        return
+    }
+    listener = api.LimitListener(listener, cfg.MaxConnections)
+
+    server := grpc.NewServer(options...)
+    grpcapi.RegisterServices(server, a.db, a.PeerAddresses)
+
+    if cfg.TLS.CertFile == "" {
+        logger.Warn("gRPC server is not encrypted", "port", port)
+    }
+    logger.Info("starting gRPC server", "port", port)
+    a.grpcServer = server
+    go server.Serve(listener)
+}
 
-    // Initialize peers from command line arguments
-    initializePeers()
+// startDiagnosticsServer serves pprof and runtime statistics when a diagnostics port is configured
+func startDiagnosticsServer() {
+    cfg := config.Get().Diagnostics
+    if cfg.Port == 0 {
+        return
+    }
+    address := net.JoinHostPort(cfg.Address, strconv.Itoa(cfg.Port))
+    if cfg.Password == "" && cfg.Address != "127.0.0.1" && cfg.Address != "localhost" {
+        logger.Warn("diagnostics server is not authenticated", "address", address)
+    }
+
+    logger.Info("starting diagnostics server", "address", address)
+    if err := http.ListenAndServe(address, diagnostics.Handler(cfg.Username, cfg.Password)); err != nil {
+        logger.Error("diagnostics server stopped", "address", address, "error", err)
+    }
+}
+
+// loadSigningKeys installs the node key and the key being rotated out, if any
+func loadSigningKeys(cfg config.SigningConfig) error {
+    if cfg.Key != "" {
+        if err := signing.UseKey(cfg.Key); err != nil {
+            return fmt.Errorf("signing.key: %v", err)
+        }
+    } else if err := signing.Load(cfg.KeyFile); err != nil {
+        return fmt.Errorf("signing.keyFile: %v", err)
+    }
+
+    if cfg.PreviousKey != "" {
+        if err := signing.UsePrevious(cfg.PreviousKey); err != nil {
+            return fmt.Errorf("signing.previousKey: %v", err)
+        }
+    } else if err := signing.LoadPrevious(cfg.PreviousKeyFile); err != nil {
+        return fmt.Errorf("signing.previousKeyFile: %v", err)
+    }
+    return nil
+}
+
+// buildRevision returns the version control revision the binary was built from, if recorded
+func buildRevision() string {
+    info, ok := debug.ReadBuildInfo()
+    if !ok {
+        return ""
+    }
+    for _, setting := range info.Settings {
+        if setting.Key == "vcs.revision" {
+            return setting.Value
+        }
+    }
+    return ""
+}
+
+// runServe synchronizes VIDA transactions and serves the APIs until interrupted
+func runServe(args []string) error {
+    flags := newFlagSet("serve", "[peer ...]")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+
+    if problems := config.Get().Validate(); len(problems) > 0 {
+        return fmt.Errorf("invalid configuration: %v (run config check for details)", problems[0])
+    }
+
+    if os.Getenv(daemonEnv) != "" {
+        cleanup, err := startDaemonChild()
+        if err != nil {
+            return err
+        }
+        defer cleanup()
+    }
+
+    if cfg := config.Get().ErrorReporting; cfg.SentryDSN != "" {
+        sentry, err := reporting.NewSentry(cfg.SentryDSN, cfg.Environment, buildRevision())
+        if err != nil {
+            return fmt.Errorf("errorReporting.sentryDsn: %v", err)
+        }
+        reporting.SetReporter(sentry)
+        defer reporting.Close()
+    }
 
-    // Set up HTTP API server
-    go startAPIServer()
+    if err := chaos.Setup(config.Get().Chaos, config.Get().RPCURL); err != nil {
+        return err
+    }
 
-    // Initialize database with initial balances if needed
-    initInitialBalances()
+    if err := loadSigningKeys(config.Get().Signing); err != nil {
+        return err
+    }
 
-    // Get starting block number
-    lastBlock, _ := dbservice.GetLastCheckedBlock()
-    fromBlock := START_BLOCK
-    if lastBlock > 0 {
-        fromBlock = int(lastBlock)
+    node, err := NewApp(config.Get(), flags.Args())
+    if err != nil {
+        return err
     }
 
-    fmt.Printf("Starting synchronization from block %d\n", fromBlock)
+    logger.Info("starting PWR VIDA transaction synchronizer")
+    go startDiagnosticsServer()
 
-    // Subscribe to VIDA transactions
-    subscribeAndSync(fromBlock)
+    if err := node.setupAlerts(); err != nil {
+        return err
+    }
+    node.setupAuditor()
+    if verificationLog != nil {
+        defer verificationLog.Close()
+    }
+    startPruneScheduler()
+
+    if err := node.Start(); err != nil {
+        return err
+    }
+    defer node.Stop()
+    node.startDiskGuard()
 
     // Keep the main thread alive
-    fmt.Println("Application started successfully. Press Ctrl+C to exit.")
+    logger.Info("application started, press Ctrl+C to exit")
     c := make(chan os.Signal, 1)
     signal.Notify(c, os.Interrupt, syscall.SIGTERM)
     <-c
+    return nil
+}
+
+// main dispatches to the subcommand given on the command line
+func main() {
+    os.Exit(runCLI(os.Args[1:]))
 }
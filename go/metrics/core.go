@@ -0,0 +1,54 @@
+package metrics
+
+// Core processing metrics of the node
+var (
+    // TransactionsApplied counts transactions that changed state, by action
+    TransactionsApplied = NewCounter("vida_transactions_applied_total", "Transactions applied to the state.", "action")
+    // TransactionsFailed counts transactions that were rejected, by action and reason
+    TransactionsFailed = NewCounter("vida_transactions_failed_total", "Transactions rejected without changing the state.", "action", "reason")
+    // TransactionDuration is the time spent applying a transaction, by action
+    TransactionDuration = NewHistogram("vida_transaction_duration_seconds", "Time spent processing a transaction.", DurationBuckets, "action")
+
+    // BlocksProcessed counts checkpoints reported by the subscription
+    BlocksProcessed = NewCounter("vida_blocks_processed_total", "Checkpoints processed.")
+    // CheckpointDuration is the time spent validating and flushing a checkpoint
+    CheckpointDuration = NewHistogram("vida_checkpoint_duration_seconds", "Time spent validating and flushing a checkpoint.", DurationBuckets)
+    // Reverts counts batches discarded after failing root hash validation
+    Reverts = NewCounter("vida_reverts_total", "Batches reverted after failing root hash validation.")
+    // ReprocessedBlocks counts blocks requested again from the RPC node after their changes were reverted
+    ReprocessedBlocks = NewCounter("vida_reprocessed_blocks_total", "Blocks requested again from the RPC node after their changes were reverted.")
+
+    // ReplayedTransactions counts re-delivered transactions skipped because a flushed block already applied them
+    ReplayedTransactions = NewCounter("vida_replayed_transactions_total", "Re-delivered transactions skipped because they were already applied.")
+
+    // PeerMismatches counts peer root hashes that differed from the local root, by peer
+    PeerMismatches = NewCounter("vida_peer_mismatches_total", "Peer root hashes that differed from the local root.", "peer")
+    // TreeOperationDuration is the time spent in Merkle tree operations, by operation
+    TreeOperationDuration = NewHistogram("vida_tree_operation_duration_seconds", "Time spent in Merkle tree operations.", DurationBuckets, "operation")
+    // SlowTreeOperations counts tree operations slower than the configured threshold, by operation and key namespace
+    SlowTreeOperations = NewCounter("vida_slow_tree_operations_total", "Tree operations slower than the configured threshold.", "operation", "namespace")
+
+    // BalanceCacheLookups counts balance cache lookups, by result
+    BalanceCacheLookups = NewCounter("vida_balance_cache_lookups_total", "Balance cache lookups.", "result")
+    // CoalescedWrites counts writes replacing a buffered write to the same key
+    CoalescedWrites = NewCounter("vida_coalesced_writes_total", "Writes replacing a buffered write to the same key before it reached the tree.")
+    // DeferredBatches counts batches cut short because a memory budget was reached, by budget
+    DeferredBatches = NewCounter("vida_deferred_batches_total", "Batches whose remaining blocks were deferred to the next checkpoint.", "budget")
+
+    // ThrottledRequests counts peer endpoint requests refused for exceeding the client budget, by route
+    ThrottledRequests = NewCounter("vida_http_throttled_requests_total", "Peer endpoint requests refused for exceeding the client request budget.", "route")
+
+    // PeerErrors counts root hash requests to peers that failed, by peer
+    PeerErrors = NewCounter("vida_peer_errors_total", "Root hash requests to peers that failed.", "peer")
+
+    // PublishErrors counts failed deliveries of events to the message broker
+    PublishErrors = NewCounter("vida_publish_errors_total", "Deliveries of events to the message broker that failed and were retried.")
+    // MirrorErrors counts failed writes to the PostgreSQL mirror
+    MirrorErrors = NewCounter("vida_mirror_errors_total", "Writes to the PostgreSQL mirror that failed and were retried.")
+    // CloudSnapshotErrors counts snapshots that were not uploaded to the cloud store
+    CloudSnapshotErrors = NewCounter("vida_cloud_snapshot_errors_total", "Snapshots that failed to be copied, verified or uploaded to the cloud store.")
+    // FencedBatches counts batches discarded because the node did not hold the failover lease
+    FencedBatches = NewCounter("vida_fenced_batches_total", "Batches discarded because the node did not hold the failover lease.")
+    // FailoverTransitions counts changes of the failover role, by the role taken
+    FailoverTransitions = NewCounter("vida_failover_transitions_total", "Changes of the failover role.", "role")
+)
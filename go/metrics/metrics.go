@@ -0,0 +1,228 @@
+// Package metrics keeps the processing counters and histograms of the node. They
+// are served in the Prometheus text format on /metrics and can be read directly by
+// programs embedding the node.
+package metrics
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// metric is a registered counter or histogram
+type metric interface {
+    write(w io.Writer)
+}
+
+var (
+    registry      []metric
+    registryMutex sync.Mutex
+)
+
+// register adds a metric to the /metrics output
+func register(m metric) {
+    registryMutex.Lock()
+    registry = append(registry, m)
+    registryMutex.Unlock()
+}
+
+// labelKey joins label values into a map key
+func labelKey(values []string) string {
+    return strings.Join(values, "\x00")
+}
+
+// formatLabels renders label names and values in the exposition format
+func formatLabels(names []string, key string, extra ...string) string {
+    var pairs []string
+    if len(names) > 0 {
+        for i, value := range strings.Split(key, "\x00") {
+            pairs = append(pairs, names[i]+"="+strconv.Quote(value))
+        }
+    }
+    for i := 0; i+1 < len(extra); i += 2 {
+        pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+    }
+    if len(pairs) == 0 {
+        return ""
+    }
+    return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat renders a sample value
+func formatFloat(value float64) string {
+    if math.IsInf(value, 1) {
+        return "+Inf"
+    }
+    return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys returns the label keys of a metric in a stable order
+func sortedKeys[V any](values map[string]V) []string {
+    keys := make([]string, 0, len(values))
+    for key := range values {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// Counter is a monotonically increasing value, optionally split by labels
+type Counter struct {
+    name   string
+    help   string
+    labels []string
+
+    mutex  sync.Mutex
+    values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names
+func NewCounter(name, help string, labels ...string) *Counter {
+    c := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+    register(c)
+    return c
+}
+
+// Inc adds one to the counter of the given label values
+func (c *Counter) Inc(labelValues ...string) {
+    c.Add(1, labelValues...)
+}
+
+// Add adds delta to the counter of the given label values
+func (c *Counter) Add(delta float64, labelValues ...string) {
+    if len(labelValues) != len(c.labels) {
+        panic(fmt.Sprintf("metrics: %s expects %d label values", c.name, len(c.labels)))
+    }
+    c.mutex.Lock()
+    c.values[labelKey(labelValues)] += delta
+    c.mutex.Unlock()
+}
+
+// Value returns the counter of the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return c.values[labelKey(labelValues)]
+}
+
+// Total returns the sum of the counter over all label values
+func (c *Counter) Total() float64 {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    total := 0.0
+    for _, value := range c.values {
+        total += value
+    }
+    return total
+}
+
+func (c *Counter) write(w io.Writer) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+    if len(c.labels) == 0 && len(c.values) == 0 {
+        fmt.Fprintf(w, "%s 0\n", c.name)
+    }
+    for _, key := range sortedKeys(c.values) {
+        fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key), formatFloat(c.values[key]))
+    }
+}
+
+// DurationBuckets are histogram bounds in seconds suited to transaction and block processing
+var DurationBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// HistogramData is the state of a histogram for one set of label values
+type HistogramData struct {
+    // Counts holds the number of observations per bucket, not cumulative, with a
+    // final entry for observations above the last bound
+    Counts []uint64
+    Count  uint64
+    Sum    float64
+}
+
+// Histogram counts observations in buckets, optionally split by labels
+type Histogram struct {
+    name    string
+    help    string
+    buckets []float64
+    labels  []string
+
+    mutex  sync.Mutex
+    values map[string]*HistogramData
+}
+
+// NewHistogram registers a histogram with ascending bucket bounds and the given label names
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+    h := &Histogram{name: name, help: help, buckets: buckets, labels: labels, values: make(map[string]*HistogramData)}
+    register(h)
+    return h
+}
+
+// Observe records a value for the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+    if len(labelValues) != len(h.labels) {
+        panic(fmt.Sprintf("metrics: %s expects %d label values", h.name, len(h.labels)))
+    }
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    key := labelKey(labelValues)
+    data, ok := h.values[key]
+    if !ok {
+        data = &HistogramData{Counts: make([]uint64, len(h.buckets)+1)}
+        h.values[key] = data
+    }
+    data.Counts[sort.SearchFloat64s(h.buckets, value)]++
+    data.Count++
+    data.Sum += value
+}
+
+// Data returns a copy of the histogram of the given label values
+func (h *Histogram) Data(labelValues ...string) HistogramData {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+    data, ok := h.values[labelKey(labelValues)]
+    if !ok {
+        return HistogramData{Counts: make([]uint64, len(h.buckets)+1)}
+    }
+    copy := *data
+    copy.Counts = append([]uint64(nil), data.Counts...)
+    return copy
+}
+
+func (h *Histogram) write(w io.Writer) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+    for _, key := range sortedKeys(h.values) {
+        data := h.values[key]
+        cumulative := uint64(0)
+        for i, count := range data.Counts {
+            bound := math.Inf(1)
+            if i < len(h.buckets) {
+                bound = h.buckets[i]
+            }
+            cumulative += count
+            fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", formatFloat(bound)), cumulative)
+        }
+        fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key), formatFloat(data.Sum))
+        fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key), data.Count)
+    }
+}
+
+// WritePrometheus writes every registered metric in the Prometheus text format
+func WritePrometheus(w io.Writer) {
+    registryMutex.Lock()
+    metrics := append([]metric(nil), registry...)
+    registryMutex.Unlock()
+
+    for _, m := range metrics {
+        m.write(w)
+    }
+}
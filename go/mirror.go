@@ -0,0 +1,43 @@
+package main
+
+import (
+    "encoding/hex"
+    "math/big"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/mirror"
+    "pwr-stateful-vida/reporting"
+)
+
+// openMirror starts the PostgreSQL mirror of the configuration from the checkpoint
+func (a *App) openMirror(cfg config.MirrorConfig) (*mirror.Mirror, error) {
+    checkpoint, _ := a.db.GetLastCheckedBlock()
+    m, err := mirror.Open(cfg.DSN, cfg.Schema, cfg.QueueSize, checkpoint)
+    if err != nil {
+        return nil, err
+    }
+    m.OnError = func(error) { metrics.MirrorErrors.Inc() }
+    return m, nil
+}
+
+// commitMirror queues the records of a committed batch for the mirror, with every
+// account when the mirror has a gap to repair
+func (a *App) commitMirror(block int64, rootHash []byte) {
+    if a.mirror == nil {
+        return
+    }
+    full := false
+    if a.mirror.NeedsResync() {
+        err := a.db.ForEachAccount(nil, func(address []byte, balance *big.Int) bool {
+            a.mirror.RecordBalance(hex.EncodeToString(address), balance)
+            return true
+        })
+        if err != nil {
+            syncLogger.Error("failed to read accounts for the mirror", "block", block, "error", err)
+            reporting.Report(err, reporting.Context{Module: "mirror", Block: block})
+        }
+        full = err == nil
+    }
+    a.mirror.Commit(block, hex.EncodeToString(rootHash), full)
+}
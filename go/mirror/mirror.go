@@ -0,0 +1,333 @@
+// Package mirror keeps the accounts, transfers and finalized blocks of the node in
+// a PostgreSQL schema for dashboards and reporting. Committed batches are written
+// by a background worker with upserts keyed by block, so writing a block again, as
+// replay -mirror does, leaves the same rows. A batch the worker missed, because the
+// database was unreachable for too long or the node ran without the mirror, leaves
+// a gap: the accounts are repaired from the full state at the next checkpoint, and
+// the transfers of the gap are filled in by replaying the transaction log.
+package mirror
+
+import (
+    "database/sql"
+    "fmt"
+    "math/big"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "pwr-stateful-vida/logging"
+
+    _ "github.com/lib/pq"
+)
+
+// Transfer is a movement of a token by a transaction of a block
+type Transfer struct {
+    Hash     string
+    Block    int64
+    Sender   string
+    Receiver string
+    Token    string
+    Amount   string
+}
+
+// schema creates the tables in the schema named by %[1]s. A block row is written
+// for every checkpoint; previous is the checkpoint before it, so a previous
+// missing from the table marks a gap.
+const schema = `
+CREATE SCHEMA IF NOT EXISTS %[1]s;
+CREATE TABLE IF NOT EXISTS %[1]s.blocks (
+    block BIGINT PRIMARY KEY,
+    previous BIGINT NOT NULL,
+    root_hash TEXT NOT NULL,
+    transfers INTEGER NOT NULL,
+    mirrored_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS %[1]s.transfers (
+    checkpoint BIGINT NOT NULL,
+    ordinal INTEGER NOT NULL,
+    block BIGINT NOT NULL,
+    hash TEXT NOT NULL,
+    sender TEXT NOT NULL,
+    receiver TEXT NOT NULL,
+    token TEXT NOT NULL,
+    amount NUMERIC NOT NULL,
+    PRIMARY KEY (checkpoint, ordinal)
+);
+CREATE INDEX IF NOT EXISTS transfers_sender ON %[1]s.transfers (sender, block);
+CREATE INDEX IF NOT EXISTS transfers_receiver ON %[1]s.transfers (receiver, block);
+CREATE TABLE IF NOT EXISTS %[1]s.accounts (
+    address TEXT PRIMARY KEY,
+    balance NUMERIC NOT NULL,
+    block BIGINT NOT NULL
+);
+`
+
+// maxBackoff bounds the wait between attempts to reach the database
+const maxBackoff = 30 * time.Second
+
+// closeTimeout bounds how long Close keeps writing the queued batches
+const closeTimeout = 30 * time.Second
+
+var logger = logging.For("mirror")
+
+// batch is a committed checkpoint waiting to be written
+type batch struct {
+    previous  int64
+    block     int64
+    rootHash  string
+    transfers []Transfer
+    balances  map[string]string
+    // full is set when balances hold every account, to repair them after a gap
+    full bool
+}
+
+// Mirror buffers the transfers and balance changes of a batch and queues them once
+// the batch is committed, so reverted changes never reach the database
+type Mirror struct {
+    db     *sql.DB
+    schema string
+
+    mutex     sync.Mutex
+    transfers []Transfer
+    balances  map[string]string
+    // checkpoint is the block of the last committed batch
+    checkpoint int64
+
+    queue chan batch
+    // resync is set once a gap was found, until a batch with every account is queued
+    resync atomic.Bool
+    // lastBlock is the last checkpoint written to the database
+    lastBlock atomic.Int64
+    stop      chan struct{}
+    done      chan struct{}
+
+    // Blocking makes Commit wait for room in the queue instead of dropping the
+    // batch, for replays that must not leave gaps
+    Blocking bool
+    // OnError is called with every failed write before it is retried
+    OnError func(error)
+}
+
+// Open returns a mirror writing to the PostgreSQL database at dsn, into the named
+// schema, with room for queueSize committed batches. checkpoint is the last
+// checkpoint of the node. The database is connected to in the background, so the
+// node starts while it is unreachable.
+func Open(dsn, schemaName string, queueSize int, checkpoint int64) (*Mirror, error) {
+    db, err := sql.Open("postgres", dsn)
+    if err != nil {
+        return nil, err
+    }
+    m := &Mirror{
+        db:         db,
+        schema:     schemaName,
+        balances:   make(map[string]string),
+        checkpoint: checkpoint,
+        queue:      make(chan batch, queueSize),
+        stop:       make(chan struct{}),
+        done:       make(chan struct{}),
+    }
+    go m.run()
+    return m, nil
+}
+
+// RecordTransfer buffers a transfer
+func (m *Mirror) RecordTransfer(transfer Transfer) {
+    m.mutex.Lock()
+    m.transfers = append(m.transfers, transfer)
+    m.mutex.Unlock()
+}
+
+// RecordBalance buffers the new balance of an account, keeping the last of a batch
+func (m *Mirror) RecordBalance(address string, balance *big.Int) {
+    m.mutex.Lock()
+    m.balances[address] = balance.String()
+    m.mutex.Unlock()
+}
+
+// NeedsResync reports whether the next batch should carry every account, with
+// RecordBalance, to repair the accounts after a gap
+func (m *Mirror) NeedsResync() bool {
+    return m.resync.Load()
+}
+
+// Commit queues the buffered records as the batch of the checkpoint block. full
+// tells that every account was recorded. Unless Blocking is set, a batch that does
+// not fit the queue is dropped and the gap it leaves is repaired later.
+func (m *Mirror) Commit(block int64, rootHash string, full bool) {
+    m.mutex.Lock()
+    b := batch{
+        previous:  m.checkpoint,
+        block:     block,
+        rootHash:  rootHash,
+        transfers: m.transfers,
+        balances:  m.balances,
+        full:      full,
+    }
+    m.transfers, m.balances = nil, make(map[string]string)
+    m.checkpoint = block
+    m.mutex.Unlock()
+
+    if m.Blocking {
+        m.queue <- b
+    } else {
+        select {
+        case m.queue <- b:
+        default:
+            logger.Warn("mirror queue is full, dropping batch", "block", block, "queued", len(m.queue))
+            m.resync.Store(true)
+            return
+        }
+    }
+    if full {
+        m.resync.Store(false)
+    }
+}
+
+// Discard drops the buffered records of a reverted batch
+func (m *Mirror) Discard() {
+    m.mutex.Lock()
+    m.transfers = nil
+    m.balances = make(map[string]string)
+    m.mutex.Unlock()
+}
+
+// LastBlock returns the last checkpoint written to the database
+func (m *Mirror) LastBlock() int64 {
+    return m.lastBlock.Load()
+}
+
+// Pending returns the number of committed batches waiting for the database
+func (m *Mirror) Pending() int {
+    return len(m.queue)
+}
+
+// run creates the schema and writes the queued batches in order until the queue is
+// closed, retrying every write until it succeeds
+func (m *Mirror) run() {
+    defer close(m.done)
+
+    if !m.retry(m.prepare) {
+        return
+    }
+    for b := range m.queue {
+        if !m.retry(func() error { return m.write(b) }) {
+            return
+        }
+    }
+}
+
+// retry calls f until it succeeds, waiting longer after each failure. It returns
+// false when the mirror is stopped first.
+func (m *Mirror) retry(f func() error) bool {
+    backoff := time.Second
+    for {
+        err := f()
+        if err == nil {
+            return true
+        }
+        logger.Warn("failed to write mirror, retrying", "retryIn", backoff, "error", err)
+        if m.OnError != nil {
+            m.OnError(err)
+        }
+        select {
+        case <-m.stop:
+            return false
+        case <-time.After(backoff):
+        }
+        backoff = min(backoff*2, maxBackoff)
+    }
+}
+
+// prepare creates the tables and reads the last mirrored checkpoint
+func (m *Mirror) prepare() error {
+    if _, err := m.db.Exec(fmt.Sprintf(schema, m.schema)); err != nil {
+        return err
+    }
+    var last int64
+    if err := m.db.QueryRow(`SELECT COALESCE(MAX(block), 0) FROM ` + m.schema + `.blocks`).Scan(&last); err != nil {
+        return err
+    }
+    m.lastBlock.Store(last)
+    logger.Info("mirror connected", "schema", m.schema, "lastBlock", last)
+    return nil
+}
+
+// write upserts a batch in one transaction. Accounts keep the balance of the
+// latest block written, so writing an older block again does not roll them back.
+func (m *Mirror) write(b batch) error {
+    last := m.lastBlock.Load()
+    if b.previous > last && !b.full {
+        logger.Warn("mirror has a gap, repairing accounts at the next checkpoint", "from", last+1, "to", b.previous)
+        m.resync.Store(true)
+    }
+
+    tx, err := m.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`INSERT INTO `+m.schema+`.blocks (block, previous, root_hash, transfers) VALUES ($1, $2, $3, $4)
+ON CONFLICT (block) DO UPDATE SET previous = excluded.previous, root_hash = excluded.root_hash, transfers = excluded.transfers, mirrored_at = now()`,
+        b.block, b.previous, b.rootHash, len(b.transfers))
+    if err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`DELETE FROM `+m.schema+`.transfers WHERE checkpoint = $1 AND ordinal >= $2`, b.block, len(b.transfers)); err != nil {
+        return err
+    }
+    if len(b.transfers) > 0 {
+        statement, err := tx.Prepare(`INSERT INTO ` + m.schema + `.transfers (checkpoint, ordinal, block, hash, sender, receiver, token, amount) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (checkpoint, ordinal) DO UPDATE SET block = excluded.block, hash = excluded.hash, sender = excluded.sender, receiver = excluded.receiver, token = excluded.token, amount = excluded.amount`)
+        if err != nil {
+            return err
+        }
+        defer statement.Close()
+        for i, t := range b.transfers {
+            if _, err := statement.Exec(b.block, i, t.Block, t.Hash, t.Sender, t.Receiver, t.Token, t.Amount); err != nil {
+                return err
+            }
+        }
+    }
+
+    if len(b.balances) > 0 {
+        statement, err := tx.Prepare(`INSERT INTO ` + m.schema + `.accounts AS account (address, balance, block) VALUES ($1, $2, $3)
+ON CONFLICT (address) DO UPDATE SET balance = excluded.balance, block = excluded.block WHERE account.block <= excluded.block`)
+        if err != nil {
+            return err
+        }
+        defer statement.Close()
+        for address, balance := range b.balances {
+            if _, err := statement.Exec(address, balance, b.block); err != nil {
+                return err
+            }
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+    m.lastBlock.Store(max(last, b.block))
+    return nil
+}
+
+// Close writes the queued batches and closes the database. Unless Blocking is set
+// it gives up after closeTimeout, leaving a gap. Commit must not be called afterwards.
+func (m *Mirror) Close() error {
+    close(m.queue)
+    var timeout <-chan time.Time
+    if !m.Blocking {
+        timeout = time.After(closeTimeout)
+    }
+    select {
+    case <-m.done:
+    case <-timeout:
+        close(m.stop)
+        <-m.done
+    }
+    if pending := len(m.queue); pending > 0 {
+        logger.Warn("mirror closed with batches not written", "pending", pending)
+    }
+    return m.db.Close()
+}
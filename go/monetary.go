@@ -0,0 +1,139 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/monetary"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// failureOutsidePolicy rejects a mint or burn the monetary policy does not allow
+const failureOutsidePolicy = "outside_monetary_policy"
+
+// mintPayload is the payload of a mint
+type mintPayload struct {
+    envelope
+    Receiver string `json:"receiver"`
+    Amount   number `json:"amount"`
+
+    receiver []byte
+    amount   *big.Int
+}
+
+func (p *mintPayload) validate(a *App) error {
+    if p.receiver = payloadAddress(p.Receiver); p.receiver == nil {
+        return invalidField("receiver", "must be an address")
+    }
+    if p.amount = a.parseTokenAmount(p.Amount, tokens.Native); p.amount == nil {
+        return invalidField("amount", "must be a positive amount")
+    }
+    return nil
+}
+
+// burnPayload is the payload of a burn
+type burnPayload struct {
+    envelope
+    Amount number `json:"amount"`
+
+    amount *big.Int
+}
+
+func (p *burnPayload) validate(a *App) error {
+    if p.amount = a.parseTokenAmount(p.Amount, tokens.Native); p.amount == nil {
+        return invalidAmount("amount", "must be a positive amount")
+    }
+    return nil
+}
+
+// monetaryPolicyPayload is the payload of a monetaryPolicy, the policy replacing
+// the one in force
+type monetaryPolicyPayload struct {
+    envelope
+    monetary.Policy
+}
+
+// handleMint mints "amount" to "receiver", sent by a minter or a governor. It
+// returns the reason the mint was rejected, or an empty string on success.
+func (a *App) handleMint(ctx context.Context, p *mintPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    policy, err := monetary.Current(a.db)
+    if err != nil {
+        return monetaryFailure(ctx, err, "mint", p, senderHex)
+    }
+    if !policy.IsMinter(sender) && !a.isGovernor(senderHex) {
+        syncLogger.WarnContext(ctx, "mint from a non-minter", "sender", senderHex)
+        return failureUnauthorized
+    }
+    if err := monetary.Mint(a.db, p.receiver, p.amount, int64(transaction.BlockNumber)); err != nil {
+        return monetaryFailure(ctx, err, "mint", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "tokens minted", "receiver", p.Receiver, "amount", p.amount, "sender", senderHex)
+    return ""
+}
+
+// handleBurn burns "amount" of the sender's balance. It returns the reason the burn
+// was rejected, or an empty string on success.
+func (a *App) handleBurn(ctx context.Context, p *burnPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if err := monetary.Burn(a.db, sender, p.amount); err != nil {
+        return monetaryFailure(ctx, err, "burn", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "tokens burned", "amount", p.amount, "sender", senderHex)
+    return ""
+}
+
+// handleMonetaryPolicy replaces the monetary policy, sent by a governor. It returns
+// the reason the change was rejected, or an empty string on success.
+func (a *App) handleMonetaryPolicy(ctx context.Context, p *monetaryPolicyPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    if payloadAddress(senderHex) == nil {
+        return failureInvalidPayload
+    }
+    if !a.isGovernor(senderHex) {
+        syncLogger.WarnContext(ctx, "monetary policy change from a non-governor", "sender", senderHex)
+        return failureUnauthorized
+    }
+    policy := p.Policy
+    if err := monetary.SetPolicy(a.db, policy); err != nil {
+        return monetaryFailure(ctx, err, "monetaryPolicy", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "monetary policy changed", "supplyCap", policy.SupplyCap, "periods", len(policy.Schedule), "minters", len(policy.Minters), "burn", policy.Burn.Enabled, "sender", senderHex)
+    return ""
+}
+
+// monetaryFailure maps an error of the monetary package to the reason a
+// transaction is rejected
+func monetaryFailure(ctx context.Context, err error, action string, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, monetary.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid monetary policy", "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, monetary.ErrOutsidePolicy):
+        syncLogger.InfoContext(ctx, action+" rejected by the monetary policy", "error", err, "sender", senderHex)
+        return failureOutsidePolicy
+    case errors.Is(err, monetary.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, "burn failed: insufficient funds", "sender", senderHex)
+        return failureInsufficientFunds
+    }
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": senderHex},
+    })
+    return failureInvalidPayload
+}
@@ -0,0 +1,261 @@
+// Package monetary enforces the monetary policy of the native token: a cap on the
+// supply, a schedule of how much can be issued per block and the rules of burning.
+// Every issuance, whether a mint action, staking rewards or savings interest, goes
+// through Issue, which rejects what the policy does not allow. The policy of the
+// params recorded at genesis is in force until governance puts another in the
+// Merkle state.
+package monetary
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "sort"
+    "strings"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/params"
+)
+
+// Errors of rejected issuance, burns and policies
+var (
+    ErrInvalid           = errors.New("invalid monetary policy")
+    ErrOutsidePolicy     = errors.New("outside the monetary policy")
+    ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// MaxPeriods is the most periods a mint schedule can have
+const MaxPeriods = 64
+
+var (
+    policyKey = []byte(dbservice.MonetaryPrefix + "policy")
+    stateKey  = []byte(dbservice.MonetaryPrefix + "state")
+)
+
+// Period allows issuing up to PerBlock for every block from From until the next
+// period starts. Allowance not used in a block carries over.
+type Period struct {
+    From     int64  `json:"from"`
+    PerBlock string `json:"perBlock"`
+}
+
+// BurnRules say whether holders can burn native tokens and the smallest burn
+type BurnRules struct {
+    Enabled   bool   `json:"enabled"`
+    MinAmount string `json:"minAmount,omitempty"`
+}
+
+// Policy is a monetary policy. Empty fields do not restrict issuance.
+type Policy struct {
+    // SupplyCap is the most native tokens that can exist
+    SupplyCap string `json:"supplyCap,omitempty"`
+    // Schedule bounds the total issued since genesis by the allowance accrued up to
+    // each block, ordered by From
+    Schedule []Period `json:"schedule,omitempty"`
+    // Minters may send mint actions besides the governors
+    Minters []string  `json:"minters,omitempty"`
+    Burn    BurnRules `json:"burn"`
+}
+
+// State is the supply of the native token and what was issued and burned since the
+// policy was first applied
+type State struct {
+    Supply string `json:"supply"`
+    Issued string `json:"issued"`
+    Burned string `json:"burned"`
+}
+
+// Normalize validates a policy and puts its amounts and addresses in canonical form
+func Normalize(policy Policy) (Policy, error) {
+    if policy.SupplyCap != "" {
+        cap, ok := new(big.Int).SetString(policy.SupplyCap, 10)
+        if !ok || cap.Sign() < 0 {
+            return policy, fmt.Errorf("%w: supplyCap must be a non-negative integer", ErrInvalid)
+        }
+        policy.SupplyCap = cap.String()
+    }
+    if len(policy.Schedule) > MaxPeriods {
+        return policy, fmt.Errorf("%w: at most %d schedule periods", ErrInvalid, MaxPeriods)
+    }
+    for i, period := range policy.Schedule {
+        perBlock, ok := new(big.Int).SetString(period.PerBlock, 10)
+        if !ok || perBlock.Sign() < 0 || period.From < 0 || (i > 0 && period.From <= policy.Schedule[i-1].From) {
+            return policy, fmt.Errorf("%w: schedule periods need increasing blocks and non-negative amounts", ErrInvalid)
+        }
+        policy.Schedule[i].PerBlock = perBlock.String()
+    }
+    for i, minter := range policy.Minters {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(minter), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            return policy, fmt.Errorf("%w: minter %q is not an address", ErrInvalid, minter)
+        }
+        policy.Minters[i] = hex.EncodeToString(address)
+    }
+    sort.Strings(policy.Minters)
+    if policy.Burn.MinAmount != "" {
+        minimum, ok := new(big.Int).SetString(policy.Burn.MinAmount, 10)
+        if !ok || minimum.Sign() < 0 {
+            return policy, fmt.Errorf("%w: burn.minAmount must be a non-negative integer", ErrInvalid)
+        }
+        policy.Burn.MinAmount = minimum.String()
+    }
+    return policy, nil
+}
+
+// Genesis returns the policy of the params recorded at genesis
+func Genesis(db *dbservice.DatabaseService) (Policy, error) {
+    current, err := params.Current(db)
+    if err != nil {
+        return Policy{}, err
+    }
+    policy := Policy{
+        SupplyCap: current.Monetary.SupplyCap,
+        Minters:   append([]string(nil), current.Monetary.Minters...),
+        Burn:      BurnRules{Enabled: current.Monetary.BurnEnabled, MinAmount: current.Monetary.BurnMinAmount},
+    }
+    for _, period := range current.Monetary.Schedule {
+        policy.Schedule = append(policy.Schedule, Period{From: period.From, PerBlock: period.PerBlock})
+    }
+    return Normalize(policy)
+}
+
+// Current returns the policy in force
+func Current(db *dbservice.DatabaseService) (Policy, error) {
+    var policy Policy
+    found, err := db.LoadJSON(policyKey, &policy)
+    if err != nil {
+        return Policy{}, err
+    }
+    if !found {
+        return Genesis(db)
+    }
+    return policy, nil
+}
+
+// SetPolicy replaces the policy in force. Issuance already made stays valid.
+func SetPolicy(db *dbservice.DatabaseService, policy Policy) error {
+    policy, err := Normalize(policy)
+    if err != nil {
+        return err
+    }
+    return db.SaveJSON(policyKey, policy)
+}
+
+// IsMinter reports whether the policy lets address send mint actions
+func (p Policy) IsMinter(address []byte) bool {
+    addressHex := hex.EncodeToString(address)
+    i := sort.SearchStrings(p.Minters, addressHex)
+    return i < len(p.Minters) && p.Minters[i] == addressHex
+}
+
+// Allowance returns the total the schedule allows issuing up to and including
+// block, or nil when there is no schedule
+func (p Policy) Allowance(block int64) *big.Int {
+    if len(p.Schedule) == 0 {
+        return nil
+    }
+    total := new(big.Int)
+    for i, period := range p.Schedule {
+        if block < period.From {
+            break
+        }
+        end := block + 1
+        if i+1 < len(p.Schedule) && p.Schedule[i+1].From < end {
+            end = p.Schedule[i+1].From
+        }
+        total.Add(total, new(big.Int).Mul(dbservice.ParseAmount(period.PerBlock), big.NewInt(end-period.From)))
+    }
+    return total
+}
+
+// Lookup returns the supply state, counting the balances of every holder the
+// first time
+func Lookup(db *dbservice.DatabaseService) (State, error) {
+    var state State
+    if found, err := db.LoadJSON(stateKey, &state); err != nil || found {
+        return state, err
+    }
+    supply, err := db.HolderBalance()
+    if err != nil {
+        return State{}, err
+    }
+    return State{Supply: supply.String(), Issued: "0", Burned: "0"}, nil
+}
+
+// Check returns why issuing amount at block is outside the policy, or nil
+func Check(db *dbservice.DatabaseService, issued *big.Int, block int64) error {
+    policy, err := Current(db)
+    if err != nil {
+        return err
+    }
+    state, err := Lookup(db)
+    if err != nil {
+        return err
+    }
+    if policy.SupplyCap != "" && new(big.Int).Add(dbservice.ParseAmount(state.Supply), issued).Cmp(dbservice.ParseAmount(policy.SupplyCap)) > 0 {
+        return fmt.Errorf("%w: the supply would exceed the cap of %s", ErrOutsidePolicy, policy.SupplyCap)
+    }
+    if allowance := policy.Allowance(block); allowance != nil && new(big.Int).Add(dbservice.ParseAmount(state.Issued), issued).Cmp(allowance) > 0 {
+        return fmt.Errorf("%w: the schedule allows %s issued by block %d", ErrOutsidePolicy, allowance, block)
+    }
+    return nil
+}
+
+// Issue records the issuance of amount at block, which the caller then credits,
+// unless the policy does not allow it
+func Issue(db *dbservice.DatabaseService, issued *big.Int, block int64) error {
+    if err := Check(db, issued, block); err != nil {
+        return err
+    }
+    state, err := Lookup(db)
+    if err != nil {
+        return err
+    }
+    state.Supply = dbservice.ParseAmount(state.Supply).Add(dbservice.ParseAmount(state.Supply), issued).String()
+    state.Issued = dbservice.ParseAmount(state.Issued).Add(dbservice.ParseAmount(state.Issued), issued).String()
+    return db.SaveJSON(stateKey, state)
+}
+
+// Mint issues amount at block and credits it to receiver
+func Mint(db *dbservice.DatabaseService, receiver []byte, minted *big.Int, block int64) error {
+    if err := Issue(db, minted, block); err != nil {
+        return err
+    }
+    balance, err := db.GetBalance(receiver)
+    if err != nil {
+        return err
+    }
+    return db.SetBalance(receiver, balance.Add(balance, minted))
+}
+
+// Burn destroys amount of the balance of account, within the burn rules
+func Burn(db *dbservice.DatabaseService, account []byte, burned *big.Int) error {
+    policy, err := Current(db)
+    if err != nil {
+        return err
+    }
+    if !policy.Burn.Enabled {
+        return fmt.Errorf("%w: burning is disabled", ErrOutsidePolicy)
+    }
+    if policy.Burn.MinAmount != "" && burned.Cmp(dbservice.ParseAmount(policy.Burn.MinAmount)) < 0 {
+        return fmt.Errorf("%w: the smallest burn is %s", ErrOutsidePolicy, policy.Burn.MinAmount)
+    }
+    state, err := Lookup(db)
+    if err != nil {
+        return err
+    }
+    balance, err := db.GetBalance(account)
+    if err != nil {
+        return err
+    }
+    if balance.Cmp(burned) < 0 {
+        return ErrInsufficientFunds
+    }
+    if err := db.SetBalance(account, balance.Sub(balance, burned)); err != nil {
+        return err
+    }
+    state.Supply = dbservice.ParseAmount(state.Supply).Sub(dbservice.ParseAmount(state.Supply), burned).String()
+    state.Burned = dbservice.ParseAmount(state.Burned).Add(dbservice.ParseAmount(state.Burned), burned).String()
+    return db.SaveJSON(stateKey, state)
+}
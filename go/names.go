@@ -0,0 +1,114 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/names"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons a name operation is rejected
+const (
+    failureNameTaken    = "name_taken"
+    failureNameNotFound = "name_not_found"
+)
+
+// namesParams returns the name registry parameters of the genesis params
+func (a *App) namesParams() names.Params {
+    cfg := a.chainParams().Names
+    fee, ok := new(big.Int).SetString(cfg.FeePerPeriod, 10)
+    if !ok {
+        fee = new(big.Int)
+    }
+    return names.Params{PeriodBlocks: cfg.PeriodBlocks, FeePerPeriod: fee}
+}
+
+// namePayload is the payload of a name operation
+type namePayload struct {
+    envelope
+    Op      string  `json:"op"`
+    Name    string  `json:"name"`
+    Periods number  `json:"periods"`
+    Address *string `json:"address"`
+
+    periods int64
+    address []byte
+}
+
+func (p *namePayload) validate(a *App) error {
+    if p.Periods.set() {
+        var ok bool
+        if p.periods, ok = payloadInt(p.Periods); !ok {
+            return invalidField("periods", "must be a non-negative integer")
+        }
+    }
+    if p.Address != nil {
+        if p.address = payloadAddress(*p.Address); p.address == nil {
+            return invalidField("address", "must be an address")
+        }
+    }
+    return nil
+}
+
+// handleNameOperation registers, transfers or points a name at an address. It
+// returns the reason the operation was rejected, or an empty string on success.
+func (a *App) handleNameOperation(ctx context.Context, p *namePayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    operation := names.Operation{Sender: payloadAddress(senderHex), Op: p.Op, Name: p.Name, Periods: p.periods, Address: p.address}
+    if operation.Sender == nil {
+        return failureInvalidPayload
+    }
+
+    err := names.Apply(a.db, operation, int64(transaction.BlockNumber), a.namesParams())
+    switch {
+    case errors.Is(err, names.ErrInvalidOperation):
+        syncLogger.WarnContext(ctx, "skipping invalid name operation", "payload", p, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, names.ErrTaken):
+        syncLogger.InfoContext(ctx, "name registration failed: name taken", "name", operation.Name, "sender", senderHex)
+        return failureNameTaken
+    case errors.Is(err, names.ErrNotFound):
+        syncLogger.InfoContext(ctx, "name operation failed: name not registered", "op", operation.Op, "name", operation.Name, "sender", senderHex)
+        return failureNameNotFound
+    case errors.Is(err, names.ErrNotOwner):
+        syncLogger.WarnContext(ctx, "name operation from an account other than the owner", "op", operation.Op, "name", operation.Name, "sender", senderHex)
+        return failureUnauthorized
+    case errors.Is(err, names.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, "name registration failed: insufficient funds", "name", operation.Name, "sender", senderHex)
+        return failureInsufficientFunds
+    case err != nil:
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "name",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "op": operation.Op, "name": operation.Name},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "name operation applied", "op", operation.Op, "name", operation.Name, "sender", senderHex)
+    return ""
+}
+
+// resolveReceiver returns the address of a transfer receiver given as a registered
+// name, or nil when it is not a name active at block
+func (a *App) resolveReceiver(ctx context.Context, receiver string, block int64) []byte {
+    if !names.ValidName(receiver) {
+        return nil
+    }
+    address, err := names.Resolve(a.db, receiver, block)
+    if err != nil {
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "transfer",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"receiver": receiver},
+        })
+        return nil
+    }
+    return address
+}
@@ -0,0 +1,193 @@
+// Package names maps human-readable names to addresses. A name is registered for
+// a number of periods of blocks against a fee paid to the registry account, and
+// can be taken by anyone once it expires. Its owner can renew it, point it at
+// another address or hand it to another owner.
+package names
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "regexp"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// Operations on names
+const (
+    OpRegister = "register"
+    OpTransfer = "transfer"
+    OpResolve  = "resolve"
+)
+
+// MaxPeriods is the most periods a name can be registered or renewed for at once
+const MaxPeriods = 100
+
+// Errors of rejected operations
+var (
+    ErrInvalidOperation  = errors.New("invalid name operation")
+    ErrTaken             = errors.New("name is registered to another account")
+    ErrNotFound          = errors.New("name not registered")
+    ErrNotOwner          = errors.New("name is owned by another account")
+    ErrInsufficientFunds = errors.New("insufficient funds for the fee")
+)
+
+// TreasuryAddress is the account registration fees are paid to
+var TreasuryAddress = dbservice.ModuleAddress("names")
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,30}[a-z0-9]$`)
+
+// Params are the registration parameters of the configuration
+type Params struct {
+    // PeriodBlocks is the length of a registration period
+    PeriodBlocks int64
+    // FeePerPeriod is the native amount charged per period
+    FeePerPeriod *big.Int
+}
+
+// Record is a registered name. It resolves to Address until the Expires block.
+type Record struct {
+    Name         string `json:"name"`
+    Owner        string `json:"owner"`
+    Address      string `json:"address"`
+    RegisteredAt int64  `json:"registeredAt"`
+    Expires      int64  `json:"expires"`
+}
+
+// Operation is a register, transfer or resolve of a name sent by Sender. Address is
+// what the name resolves to after a register or resolve, and the new owner of a
+// transfer.
+type Operation struct {
+    Op      string
+    Name    string
+    Periods int64
+    Sender  []byte
+    Address []byte
+}
+
+// recordKey returns the tree key of a name
+func recordKey(name string) []byte {
+    return []byte(dbservice.NamesPrefix + "record/" + name)
+}
+
+// ValidName reports whether name can be registered: 3 to 32 lower case letters,
+// digits or inner hyphens
+func ValidName(name string) bool {
+    return namePattern.MatchString(name)
+}
+
+// Lookup returns the record of a name, expired or not, and false when it was never
+// registered
+func Lookup(db *dbservice.DatabaseService, name string) (Record, bool, error) {
+    var record Record
+    if !ValidName(name) {
+        return record, false, nil
+    }
+    found, err := db.LoadJSON(recordKey(name), &record)
+    return record, found, err
+}
+
+// Active reports whether the record still holds its name at block
+func (r Record) Active(block int64) bool {
+    return block < r.Expires
+}
+
+// Resolve returns the address a name points to at block, or nil when it is not
+// registered or has expired
+func Resolve(db *dbservice.DatabaseService, name string, block int64) ([]byte, error) {
+    record, found, err := Lookup(db, name)
+    if err != nil || !found || !record.Active(block) {
+        return nil, err
+    }
+    address, _ := hex.DecodeString(record.Address)
+    return address, nil
+}
+
+// store writes a record
+func store(db *dbservice.DatabaseService, record Record) error {
+    return db.SaveJSON(recordKey(record.Name), record)
+}
+
+// Apply applies an operation made at a block
+func Apply(db *dbservice.DatabaseService, operation Operation, block int64, params Params) error {
+    if !ValidName(operation.Name) {
+        return fmt.Errorf("%w: a name must be 3 to 32 lower case letters, digits or inner '-'", ErrInvalidOperation)
+    }
+    record, found, err := Lookup(db, operation.Name)
+    if err != nil {
+        return err
+    }
+    sender := hex.EncodeToString(operation.Sender)
+    active := found && record.Active(block)
+
+    switch operation.Op {
+    case OpRegister:
+        return register(db, operation, record, active, block, params)
+    case OpTransfer, OpResolve:
+    default:
+        return fmt.Errorf("%w: unknown operation %q", ErrInvalidOperation, operation.Op)
+    }
+    if !active {
+        return ErrNotFound
+    }
+    if record.Owner != sender {
+        return ErrNotOwner
+    }
+    if len(operation.Address) != dbservice.AddressLength {
+        return fmt.Errorf("%w: %s needs a 20 byte address", ErrInvalidOperation, operation.Op)
+    }
+    // A transferred name points at its new owner until it changes it
+    if operation.Op == OpTransfer {
+        record.Owner = hex.EncodeToString(operation.Address)
+    }
+    record.Address = hex.EncodeToString(operation.Address)
+    return store(db, record)
+}
+
+// register registers a free or expired name, or renews an active one of the sender,
+// after charging the fee
+func register(db *dbservice.DatabaseService, operation Operation, record Record, active bool, block int64, params Params) error {
+    periods := operation.Periods
+    if periods == 0 {
+        periods = 1
+    }
+    if periods < 0 || periods > MaxPeriods {
+        return fmt.Errorf("%w: a name is registered for 1 to %d periods", ErrInvalidOperation, MaxPeriods)
+    }
+    sender := hex.EncodeToString(operation.Sender)
+    if active && record.Owner != sender {
+        return ErrTaken
+    }
+
+    if params.FeePerPeriod != nil && params.FeePerPeriod.Sign() > 0 {
+        fee := new(big.Int).Mul(params.FeePerPeriod, big.NewInt(periods))
+        ok, err := tokens.Transfer(db, tokens.Native, operation.Sender, TreasuryAddress, fee)
+        if err != nil {
+            return err
+        }
+        if !ok {
+            return ErrInsufficientFunds
+        }
+    }
+
+    if active {
+        record.Expires += periods * params.PeriodBlocks
+        if operation.Address != nil {
+            record.Address = hex.EncodeToString(operation.Address)
+        }
+        return store(db, record)
+    }
+    address := operation.Address
+    if address == nil {
+        address = operation.Sender
+    }
+    return store(db, Record{
+        Name:         operation.Name,
+        Owner:        sender,
+        Address:      hex.EncodeToString(address),
+        RegisteredAt: block,
+        Expires:      block + periods*params.PeriodBlocks,
+    })
+}
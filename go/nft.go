@@ -0,0 +1,63 @@
+package main
+
+import (
+    "context"
+    "errors"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/nft"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons an item operation is rejected
+const (
+    failureItemExists   = "item_exists"
+    failureItemNotFound = "item_not_found"
+)
+
+// nftPayload is the payload of an item operation
+type nftPayload struct {
+    envelope
+    Op       string `json:"op"`
+    Item     string `json:"item"`
+    Metadata string `json:"metadata"`
+    Receiver string `json:"receiver"`
+}
+
+// handleNFTOperation mints, transfers or burns an item. It returns the reason the
+// operation was rejected, or an empty string on success.
+func (a *App) handleNFTOperation(ctx context.Context, p *nftPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    operation := nft.Operation{Sender: payloadAddress(senderHex), Op: p.Op, ID: p.Item, Metadata: p.Metadata, Receiver: payloadAddress(p.Receiver)}
+    if operation.Sender == nil {
+        return failureInvalidPayload
+    }
+
+    err := nft.Apply(a.db, operation, int64(transaction.BlockNumber))
+    switch {
+    case errors.Is(err, nft.ErrInvalidOperation):
+        syncLogger.WarnContext(ctx, "skipping invalid nft operation", "payload", p, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, nft.ErrExists):
+        syncLogger.InfoContext(ctx, "nft mint failed: item exists", "item", operation.ID, "sender", senderHex)
+        return failureItemExists
+    case errors.Is(err, nft.ErrNotFound):
+        syncLogger.InfoContext(ctx, "nft operation failed: item not found", "op", operation.Op, "item", operation.ID, "sender", senderHex)
+        return failureItemNotFound
+    case errors.Is(err, nft.ErrNotOwner):
+        syncLogger.WarnContext(ctx, "nft operation from an account other than the owner", "op", operation.Op, "item", operation.ID, "sender", senderHex)
+        return failureUnauthorized
+    case err != nil:
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "nft",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "op": operation.Op, "item": operation.ID},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "nft operation applied", "op", operation.Op, "item", operation.ID, "sender", senderHex)
+    return ""
+}
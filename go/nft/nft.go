@@ -0,0 +1,202 @@
+// Package nft keeps non-fungible items in the Merkle state: each item ID has one
+// owner and the metadata it was minted with. The tree cannot enumerate its keys,
+// so sorted indexes of all items and of the items of each owner are kept next to
+// the items.
+package nft
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "regexp"
+    "sort"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// Operations on items
+const (
+    OpMint     = "mint"
+    OpTransfer = "transfer"
+    OpBurn     = "burn"
+)
+
+// Errors of rejected operations
+var (
+    ErrInvalidOperation = errors.New("invalid nft operation")
+    ErrExists           = errors.New("item already exists")
+    ErrNotFound         = errors.New("item not found")
+    ErrNotOwner         = errors.New("item is owned by another account")
+)
+
+// MaxMetadataLength is the longest metadata an item can carry
+const MaxMetadataLength = 2048
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+var itemsKey = []byte(dbservice.NFTPrefix + "items")
+
+// Item is a non-fungible item. Burned items keep their entry without an owner so
+// their ID cannot be minted again.
+type Item struct {
+    ID       string `json:"id"`
+    Owner    string `json:"owner,omitempty"`
+    Metadata string `json:"metadata,omitempty"`
+    Minter   string `json:"minter"`
+    MintedAt int64  `json:"mintedAt"`
+    Burned   bool   `json:"burned,omitempty"`
+}
+
+// Operation is a mint, transfer or burn of an item sent by Sender. Receiver is the
+// new owner of a transfer.
+type Operation struct {
+    Op       string
+    ID       string
+    Metadata string
+    Sender   []byte
+    Receiver []byte
+}
+
+// itemKey returns the tree key of an item
+func itemKey(id string) []byte {
+    return []byte(dbservice.NFTPrefix + "item/" + id)
+}
+
+// ownerKey returns the tree key of the index of an owner's items
+func ownerKey(owner string) []byte {
+    return []byte(dbservice.NFTPrefix + "owner/" + owner)
+}
+
+// ValidID reports whether id can name an item
+func ValidID(id string) bool {
+    return idPattern.MatchString(id)
+}
+
+// Lookup returns an item, and false when it was never minted
+func Lookup(db *dbservice.DatabaseService, id string) (Item, bool, error) {
+    var item Item
+    if !ValidID(id) {
+        return item, false, nil
+    }
+    found, err := db.LoadJSON(itemKey(id), &item)
+    return item, found, err
+}
+
+// loadIndex reads a sorted list of item IDs
+func loadIndex(db *dbservice.DatabaseService, key []byte) ([]string, error) {
+    var ids []string
+    _, err := db.LoadJSON(key, &ids)
+    return ids, err
+}
+
+// updateIndex adds id to or removes it from the sorted list stored under key
+func updateIndex(db *dbservice.DatabaseService, key []byte, id string, add bool) error {
+    ids, err := loadIndex(db, key)
+    if err != nil {
+        return err
+    }
+    i := sort.SearchStrings(ids, id)
+    found := i < len(ids) && ids[i] == id
+    switch {
+    case add && !found:
+        ids = append(ids, "")
+        copy(ids[i+1:], ids[i:])
+        ids[i] = id
+    case !add && found:
+        ids = append(ids[:i], ids[i+1:]...)
+    default:
+        return nil
+    }
+    return db.SaveJSON(key, ids)
+}
+
+// store writes an item
+func store(db *dbservice.DatabaseService, item Item) error {
+    return db.SaveJSON(itemKey(item.ID), item)
+}
+
+// Apply applies an operation made at a block
+func Apply(db *dbservice.DatabaseService, operation Operation, block int64) error {
+    if !ValidID(operation.ID) {
+        return fmt.Errorf("%w: the item id must be 1 to 128 letters, digits, '.', '_', ':' or '-'", ErrInvalidOperation)
+    }
+    item, found, err := Lookup(db, operation.ID)
+    if err != nil {
+        return err
+    }
+    sender := hex.EncodeToString(operation.Sender)
+
+    if operation.Op == OpMint {
+        if len(operation.Metadata) > MaxMetadataLength {
+            return fmt.Errorf("%w: metadata must be at most %d bytes", ErrInvalidOperation, MaxMetadataLength)
+        }
+        if found {
+            return ErrExists
+        }
+        item = Item{ID: operation.ID, Owner: sender, Metadata: operation.Metadata, Minter: sender, MintedAt: block}
+        if err := store(db, item); err != nil {
+            return err
+        }
+        if err := updateIndex(db, itemsKey, item.ID, true); err != nil {
+            return err
+        }
+        return updateIndex(db, ownerKey(sender), item.ID, true)
+    }
+
+    if operation.Op != OpTransfer && operation.Op != OpBurn {
+        return fmt.Errorf("%w: unknown operation %q", ErrInvalidOperation, operation.Op)
+    }
+    if !found || item.Burned {
+        return ErrNotFound
+    }
+    if item.Owner != sender {
+        return ErrNotOwner
+    }
+    if err := updateIndex(db, ownerKey(sender), item.ID, false); err != nil {
+        return err
+    }
+
+    if operation.Op == OpBurn {
+        item.Owner, item.Burned = "", true
+        if err := store(db, item); err != nil {
+            return err
+        }
+        return updateIndex(db, itemsKey, item.ID, false)
+    }
+    if len(operation.Receiver) != dbservice.AddressLength {
+        return fmt.Errorf("%w: transfer needs a 20 byte receiver", ErrInvalidOperation)
+    }
+    item.Owner = hex.EncodeToString(operation.Receiver)
+    if err := store(db, item); err != nil {
+        return err
+    }
+    return updateIndex(db, ownerKey(item.Owner), item.ID, true)
+}
+
+// List returns up to limit items in ID order after the given ID, all items or
+// those of owner when it is set, and the ID to continue from when more remain
+func List(db *dbservice.DatabaseService, owner []byte, after string, limit int) ([]Item, string, error) {
+    key := itemsKey
+    if owner != nil {
+        key = ownerKey(hex.EncodeToString(owner))
+    }
+    ids, err := loadIndex(db, key)
+    if err != nil {
+        return nil, "", err
+    }
+
+    items := []Item{}
+    for i := sort.Search(len(ids), func(i int) bool { return ids[i] > after }); i < len(ids); i++ {
+        if len(items) == limit {
+            return items, items[len(items)-1].ID, nil
+        }
+        item, found, err := Lookup(db, ids[i])
+        if err != nil {
+            return nil, "", err
+        }
+        if found {
+            items = append(items, item)
+        }
+    }
+    return items, "", nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "context"
+    "errors"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/nodekeys"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// nodeKeyPayload is the payload of a node key update
+type nodeKeyPayload struct {
+    envelope
+    Op            string `json:"op"`
+    Node          string `json:"node"`
+    Key           string `json:"key"`
+    OverlapBlocks number `json:"overlapBlocks"`
+
+    overlap int64
+}
+
+func (p *nodeKeyPayload) validate(a *App) error {
+    if p.OverlapBlocks.set() {
+        overlap, ok := p.OverlapBlocks.integer()
+        if !ok || !overlap.IsInt64() {
+            return invalidField("overlapBlocks", "must be an integer")
+        }
+        p.overlap = overlap.Int64()
+    }
+    return nil
+}
+
+// handleNodeKeyUpdate applies a rotation or revocation of a node key sent by a
+// governor. It returns the reason the update was rejected, or an empty string on success.
+func (a *App) handleNodeKeyUpdate(ctx context.Context, p *nodeKeyPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex, block := transaction.Sender, int64(transaction.BlockNumber)
+    if !a.isGovernor(senderHex) {
+        syncLogger.WarnContext(ctx, "node key update from a non-governor", "sender", senderHex)
+        return failureUnauthorized
+    }
+
+    update := nodekeys.Update{Op: p.Op, Node: p.Node, Key: p.Key, Overlap: p.overlap}
+
+    if err := nodekeys.Apply(a.db, update, block); err != nil {
+        if errors.Is(err, nodekeys.ErrInvalidUpdate) {
+            syncLogger.WarnContext(ctx, "skipping invalid node key update", "payload", p, "error", err)
+            return failureInvalidPayload
+        }
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "nodeKey",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "node": update.Node},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "node key updated", "op", update.Op, "node", update.Node, "block", block)
+    return ""
+}
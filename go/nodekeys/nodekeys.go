@@ -0,0 +1,118 @@
+// Package nodekeys is the registry of the keys nodes sign their responses and reports
+// with. It is part of the Merkle state and only changes through governance
+// transactions, so every node and consumer sees the same keys at the same block.
+package nodekeys
+
+import (
+    "crypto/ed25519"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strings"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// Operations of a registry update
+const (
+    OpRotate = "rotate"
+    OpRevoke = "revoke"
+)
+
+// ErrInvalidUpdate is wrapped by the errors of malformed updates
+var ErrInvalidUpdate = errors.New("invalid node key update")
+
+// Entry is the registered keys of a node. The previous key stays valid up to and
+// including block PreviousUntil, so consumers can move to the new key in the meantime.
+type Entry struct {
+    Current       string `json:"current"`
+    Since         int64  `json:"since"`
+    Previous      string `json:"previous,omitempty"`
+    PreviousUntil int64  `json:"previousUntil,omitempty"`
+}
+
+// Update is a governance action on the key of a node. Overlap is the number of
+// blocks the replaced key stays valid after a rotation.
+type Update struct {
+    Op      string
+    Node    string
+    Key     string
+    Overlap int64
+}
+
+// entryKey returns the tree key of a node's entry
+func entryKey(node string) []byte {
+    return []byte(dbservice.NodeKeysPrefix + node)
+}
+
+// Lookup returns the registered keys of a node, and false when it has none
+func Lookup(db *dbservice.DatabaseService, node string) (Entry, bool, error) {
+    var entry Entry
+    if found, err := db.LoadJSON(entryKey(node), &entry); err != nil || !found {
+        return entry, false, err
+    }
+    return entry, entry.Current != "", nil
+}
+
+// ValidKeys returns the keys of a node that verify its signatures at a block
+func (e Entry) ValidKeys(block int64) []string {
+    keys := []string{}
+    if e.Current != "" {
+        keys = append(keys, e.Current)
+    }
+    if e.Previous != "" && block <= e.PreviousUntil {
+        keys = append(keys, e.Previous)
+    }
+    return keys
+}
+
+// Valid reports whether key is a valid signing key of node at a block
+func Valid(db *dbservice.DatabaseService, node, key string, block int64) (bool, error) {
+    entry, ok, err := Lookup(db, node)
+    if err != nil || !ok {
+        return false, err
+    }
+    for _, valid := range entry.ValidKeys(block) {
+        if strings.EqualFold(valid, key) {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// Apply applies a registry update made at a block
+func Apply(db *dbservice.DatabaseService, update Update, block int64) error {
+    if update.Node == "" || len(update.Node) > 64 {
+        return fmt.Errorf("%w: a node name of at most 64 bytes is required", ErrInvalidUpdate)
+    }
+    entry, _, err := Lookup(db, update.Node)
+    if err != nil {
+        return err
+    }
+
+    switch update.Op {
+    case OpRotate:
+        key, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(update.Key), "0x"))
+        if err != nil || len(key) != ed25519.PublicKeySize {
+            return fmt.Errorf("%w: rotate needs a hex encoded %d byte public key", ErrInvalidUpdate, ed25519.PublicKeySize)
+        }
+        if update.Overlap < 0 {
+            return fmt.Errorf("%w: overlap must not be negative", ErrInvalidUpdate)
+        }
+        keyHex := hex.EncodeToString(key)
+        if keyHex == entry.Current {
+            return fmt.Errorf("%w: %s is already the current key", ErrInvalidUpdate, keyHex)
+        }
+        if entry.Current != "" {
+            entry.Previous, entry.PreviousUntil = entry.Current, block+update.Overlap
+        }
+        entry.Current, entry.Since = keyHex, block
+    case OpRevoke:
+        // A compromised key must stop validating at once, so nothing overlaps
+        entry = Entry{Since: block}
+    default:
+        return fmt.Errorf("%w: unknown operation %q", ErrInvalidUpdate, update.Op)
+    }
+
+    return db.SaveJSON(entryKey(update.Node), entry)
+}
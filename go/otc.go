@@ -0,0 +1,145 @@
+package main
+
+import (
+    "context"
+    "errors"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/otc"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons an OTC offer action is rejected
+const (
+    failureOfferNotFound = "offer_not_found"
+    failureOfferExpired  = "offer_expired"
+)
+
+// offerPayload is the payload of an offer
+type offerPayload struct {
+    envelope
+    Give      number  `json:"give"`
+    GiveToken string  `json:"giveToken"`
+    Want      number  `json:"want"`
+    WantToken string  `json:"wantToken"`
+    Taker     *string `json:"taker"`
+    Expires   number  `json:"expires"`
+
+    taker   []byte
+    expires int64
+}
+
+func (p *offerPayload) validate(a *App) error {
+    if p.Taker != nil {
+        if p.taker = payloadAddress(*p.Taker); p.taker == nil {
+            return invalidField("taker", "must be an address")
+        }
+    }
+    if p.Expires.set() {
+        var ok bool
+        if p.expires, ok = payloadInt(p.Expires); !ok {
+            return invalidField("expires", "must be a block number")
+        }
+    }
+    return nil
+}
+
+// offerIDPayload is the payload of a takeOffer or a cancelOffer
+type offerIDPayload struct {
+    envelope
+    Offer number `json:"offer"`
+
+    offer uint64
+}
+
+func (p *offerIDPayload) validate(a *App) error {
+    id := parsePositiveAmount(p.Offer)
+    if id == nil || !id.IsUint64() {
+        return invalidField("offer", "must be an offer ID")
+    }
+    p.offer = id.Uint64()
+    return nil
+}
+
+// handleOffer locks "give" of "giveToken" for "want" of "wantToken", optionally
+// reserved for a "taker" and valid until block "expires". It returns the reason the
+// offer was rejected, or an empty string on success.
+func (a *App) handleOffer(ctx context.Context, p *offerPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    give := a.parseTokenAmount(p.Give, p.GiveToken)
+    want := a.parseTokenAmount(p.Want, p.WantToken)
+    if give == nil || want == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid offer", "payload", p)
+        return failureInvalidPayload
+    }
+    id, err := otc.Make(a.db, sender, p.taker, p.GiveToken, give, p.WantToken, want, int64(transaction.BlockNumber), p.expires)
+    if err != nil {
+        return otcFailure(ctx, err, "offer", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "offer made", "offer", id, "give", give, "giveToken", p.GiveToken, "want", want, "wantToken", p.WantToken, "sender", senderHex)
+    return ""
+}
+
+// handleTakeOffer settles both legs of an "offer" at once. It returns the reason
+// the action was rejected, or an empty string on success.
+func (a *App) handleTakeOffer(ctx context.Context, p *offerIDPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    offer, err := otc.Take(a.db, sender, p.offer, int64(transaction.BlockNumber))
+    if err != nil {
+        return otcFailure(ctx, err, "takeOffer", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "offer taken", "offer", p.offer, "maker", offer.Maker, "sender", senderHex)
+    return ""
+}
+
+// handleCancelOffer returns the locked tokens of an "offer" to its maker, who sends
+// it. It returns the reason the action was rejected, or an empty string on success.
+func (a *App) handleCancelOffer(ctx context.Context, p *offerIDPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if _, err := otc.Cancel(a.db, sender, p.offer); err != nil {
+        return otcFailure(ctx, err, "cancelOffer", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "offer cancelled", "offer", p.offer, "sender", senderHex)
+    return ""
+}
+
+// otcFailure maps an error of the otc package to the reason a transaction is
+// rejected
+func otcFailure(ctx context.Context, err error, action string, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, otc.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid offer", "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, otc.ErrNotFound):
+        return failureOfferNotFound
+    case errors.Is(err, otc.ErrExpired):
+        return failureOfferExpired
+    case errors.Is(err, otc.ErrNotTaker), errors.Is(err, otc.ErrNotMaker):
+        syncLogger.WarnContext(ctx, action+" from an unauthorized sender", "payload", payload, "sender", senderHex)
+        return failureUnauthorized
+    case errors.Is(err, otc.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, action+" failed: insufficient funds", "payload", payload, "sender", senderHex)
+        return failureInsufficientFunds
+    }
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": senderHex},
+    })
+    return failureInvalidPayload
+}
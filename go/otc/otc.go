@@ -0,0 +1,181 @@
+// Package otc settles two-token trades between two parties in one transaction. The
+// maker authorizes its leg by locking the tokens it gives in an offer, and the taker
+// authorizes the other leg by taking the offer: both legs then move together, or
+// neither does when the taker cannot pay. Open offers are part of the Merkle state.
+package otc
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "strconv"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// Errors of rejected offers
+var (
+    ErrInvalid           = errors.New("invalid offer")
+    ErrNotFound          = errors.New("offer not found")
+    ErrNotTaker          = errors.New("offer is reserved for another taker")
+    ErrNotMaker          = errors.New("sender did not make the offer")
+    ErrExpired           = errors.New("offer expired")
+    ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// Address is the account holding the tokens locked by open offers
+var Address = dbservice.ModuleAddress("otc")
+
+var countKey = []byte(dbservice.OTCPrefix + "count")
+
+// Offer gives Give of GiveToken for Want of WantToken. Taker is empty when anyone
+// can take it and Expires is 0 when it does not expire.
+type Offer struct {
+    ID        uint64 `json:"id"`
+    Maker     string `json:"maker"`
+    Taker     string `json:"taker,omitempty"`
+    GiveToken string `json:"giveToken"`
+    Give      string `json:"give"`
+    WantToken string `json:"wantToken"`
+    Want      string `json:"want"`
+    Block     int64  `json:"block"`
+    Expires   int64  `json:"expires,omitempty"`
+}
+
+// offerKey returns the tree key of an offer
+func offerKey(id uint64) []byte {
+    return []byte(fmt.Sprintf("%soffer/%020d", dbservice.OTCPrefix, id))
+}
+
+// validToken reports whether token is the native token or a registered one
+func validToken(db *dbservice.DatabaseService, token string) (bool, error) {
+    if tokens.IsNative(token) {
+        return true, nil
+    }
+    _, found, err := tokens.Lookup(db, token)
+    return found, err
+}
+
+// Lookup returns an open offer, and false when there is none with that ID
+func Lookup(db *dbservice.DatabaseService, id uint64) (Offer, bool, error) {
+    var offer Offer
+    found, err := db.LoadJSON(offerKey(id), &offer)
+    return offer, found, err
+}
+
+// Count returns the number of offers made
+func Count(db *dbservice.DatabaseService) (uint64, error) {
+    data, err := db.GetData(countKey)
+    if err != nil || len(data) == 0 {
+        return 0, err
+    }
+    return strconv.ParseUint(string(data), 10, 64)
+}
+
+// Make locks give of giveToken from maker in a new offer for want of wantToken,
+// reserved for taker unless it is nil, and returns its ID
+func Make(db *dbservice.DatabaseService, maker, taker []byte, giveToken string, give *big.Int, wantToken string, want *big.Int, block, expires int64) (uint64, error) {
+    if tokens.IsNative(giveToken) {
+        giveToken = tokens.Native
+    }
+    if tokens.IsNative(wantToken) {
+        wantToken = tokens.Native
+    }
+    if giveToken == wantToken {
+        return 0, fmt.Errorf("%w: the offer must trade two different tokens", ErrInvalid)
+    }
+    if expires != 0 && expires < block {
+        return 0, fmt.Errorf("%w: the offer expires before block %d", ErrInvalid, block)
+    }
+    for _, token := range []string{giveToken, wantToken} {
+        if ok, err := validToken(db, token); err != nil || !ok {
+            if err != nil {
+                return 0, err
+            }
+            return 0, fmt.Errorf("%w: token %q is not registered", ErrInvalid, token)
+        }
+    }
+    count, err := Count(db)
+    if err != nil {
+        return 0, err
+    }
+    ok, err := tokens.Transfer(db, giveToken, maker, Address, give)
+    if err != nil {
+        return 0, err
+    }
+    if !ok {
+        return 0, ErrInsufficientFunds
+    }
+
+    offer := Offer{
+        ID:        count + 1,
+        Maker:     hex.EncodeToString(maker),
+        GiveToken: giveToken,
+        Give:      give.String(),
+        WantToken: wantToken,
+        Want:      want.String(),
+        Block:     block,
+        Expires:   expires,
+    }
+    if taker != nil {
+        offer.Taker = hex.EncodeToString(taker)
+    }
+    if err := db.SaveJSON(offerKey(offer.ID), offer); err != nil {
+        return 0, err
+    }
+    return offer.ID, db.SetData(countKey, []byte(strconv.FormatUint(offer.ID, 10)))
+}
+
+// Take settles an offer at block: the taker pays what the maker wants and receives
+// the locked tokens. Nothing moves when the taker cannot pay.
+func Take(db *dbservice.DatabaseService, taker []byte, id uint64, block int64) (Offer, error) {
+    offer, found, err := Lookup(db, id)
+    if err != nil {
+        return offer, err
+    }
+    if !found {
+        return offer, ErrNotFound
+    }
+    if offer.Taker != "" && offer.Taker != hex.EncodeToString(taker) {
+        return offer, ErrNotTaker
+    }
+    if offer.Expires != 0 && block > offer.Expires {
+        return offer, ErrExpired
+    }
+    maker, _ := hex.DecodeString(offer.Maker)
+    want := dbservice.ParseAmount(offer.Want)
+    give := dbservice.ParseAmount(offer.Give)
+
+    ok, err := tokens.Transfer(db, offer.WantToken, taker, maker, want)
+    if err != nil {
+        return offer, err
+    }
+    if !ok {
+        return offer, ErrInsufficientFunds
+    }
+    if _, err := tokens.Transfer(db, offer.GiveToken, Address, taker, give); err != nil {
+        return offer, err
+    }
+    return offer, db.SetData(offerKey(id), []byte{})
+}
+
+// Cancel closes an offer of maker, expired or not, and returns the locked tokens
+func Cancel(db *dbservice.DatabaseService, maker []byte, id uint64) (Offer, error) {
+    offer, found, err := Lookup(db, id)
+    if err != nil {
+        return offer, err
+    }
+    if !found {
+        return offer, ErrNotFound
+    }
+    if offer.Maker != hex.EncodeToString(maker) {
+        return offer, ErrNotMaker
+    }
+    give := dbservice.ParseAmount(offer.Give)
+    if _, err := tokens.Transfer(db, offer.GiveToken, Address, maker, give); err != nil {
+        return offer, err
+    }
+    return offer, db.SetData(offerKey(id), []byte{})
+}
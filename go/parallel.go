@@ -0,0 +1,145 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "runtime"
+    "strings"
+    "time"
+
+    "pwr-stateful-vida/accountrules"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/policy"
+    "pwr-stateful-vida/recovery"
+    "pwr-stateful-vida/referral"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// queuedTransfer is a plain transfer waiting to be applied with the transfers
+// around it in its block
+type queuedTransfer struct {
+    ctx         context.Context
+    transaction rpc.VidaDataTransaction
+    request     dbservice.TransferRequest
+}
+
+// queueTransfer queues a transaction when parallel transfers are enabled and it is
+// a plain transfer, and otherwise applies the queued transfers so that the
+// transaction sees their result. It returns whether the transaction was queued.
+func (a *App) queueTransfer(ctx context.Context, transaction rpc.VidaDataTransaction, payload parsedPayload, label string) bool {
+    if n := len(a.queuedTransfers); n > 0 && a.queuedTransfers[n-1].transaction.BlockNumber != transaction.BlockNumber {
+        a.flushTransfers()
+    }
+    if !a.config().Parallel.Enabled || label != "transfer" {
+        a.flushTransfers()
+        return false
+    }
+    if a.auditLog != nil {
+        a.auditLog.SetTransaction(transaction.Hash, int64(transaction.BlockNumber))
+    }
+    a.beginBlock(ctx, int64(transaction.BlockNumber))
+    request, ok := a.plainTransfer(transaction, payload)
+    if !ok {
+        a.flushTransfers()
+        return false
+    }
+    a.queuedTransfers = append(a.queuedTransfers, queuedTransfer{ctx: ctx, transaction: transaction, request: request})
+    return true
+}
+
+// plainTransfer returns the transfer a transaction makes when nothing but the
+// balances of its accounts decides it: a native transfer of a positive amount to an
+// address by an account acting for itself, with no sponsor, expiry, rules, policy
+// restriction, referral payout or fee. Anything unexpected leaves the transaction
+// to the sequential handler, which reports it.
+func (a *App) plainTransfer(transaction rpc.VidaDataTransaction, payload parsedPayload) (dbservice.TransferRequest, bool) {
+    var request dbservice.TransferRequest
+    decoded, err := payload.handler.decode(a, payload.data)
+    if err != nil {
+        return request, false
+    }
+    transfer := decoded.(*transferPayload)
+    if transfer.Sponsor != nil || transfer.OnBehalfOf != nil || transfer.ValidUntilBlock.set() || !tokens.IsNative(transfer.Token) {
+        return request, false
+    }
+    amount := a.parseTokenAmount(transfer.Amount, tokens.Native)
+    if amount == nil {
+        return request, false
+    }
+    receiver, err := hex.DecodeString(strings.TrimPrefix(transfer.Receiver, "0x"))
+    sender := payloadAddress(transaction.Sender)
+    if err != nil || len(receiver) != dbservice.AddressLength || sender == nil {
+        return request, false
+    }
+    block := int64(transaction.BlockNumber)
+
+    if controller, err := recovery.Controller(a.db, sender); err != nil || string(controller) != string(sender) {
+        return request, false
+    }
+    if unrestricted, err := accountrules.Unrestricted(a.db, sender, block); err != nil || !unrestricted {
+        return request, false
+    }
+    if reason, err := policy.Check(a.db, sender, receiver, amount); err != nil || reason != "" {
+        return request, false
+    }
+    if a.feeParams().Enabled() {
+        return request, false
+    }
+    params, err := a.referralParams()
+    if err != nil {
+        return request, false
+    }
+    if params.Payout(amount).Sign() > 0 {
+        if referrer, err := referral.Referrer(a.db, sender); err != nil || referrer != nil {
+            return request, false
+        }
+    }
+    return dbservice.TransferRequest{Sender: sender, Receiver: receiver, Amount: amount}, true
+}
+
+// flushTransfers applies the queued transfers
+func (a *App) flushTransfers() {
+    queued := a.queuedTransfers
+    if len(queued) == 0 {
+        return
+    }
+    a.queuedTransfers = nil
+    requests := make([]dbservice.TransferRequest, len(queued))
+    for i, transfer := range queued {
+        requests[i] = transfer.request
+    }
+    workers := a.config().Parallel.Workers
+    if workers == 0 {
+        workers = runtime.NumCPU()
+    }
+
+    start := time.Now()
+    applied, err := a.db.ApplyTransfers(requests, workers, func(i int) {
+        if a.auditLog != nil {
+            a.auditLog.SetTransaction(queued[i].transaction.Hash, int64(queued[i].transaction.BlockNumber))
+        }
+    })
+    if err != nil {
+        reporting.Report(err, reporting.Context{Module: "handler", Action: "transfer", Block: int64(queued[0].transaction.BlockNumber)})
+    }
+    // The transfers were applied together, so each is charged an equal share
+    duration := time.Since(start).Seconds() / float64(len(queued))
+    for i, transfer := range queued {
+        senderHex, receiverHex := hex.EncodeToString(transfer.request.Sender), hex.EncodeToString(transfer.request.Receiver)
+        if applied[i] {
+            syncLogger.InfoContext(transfer.ctx, "transfer succeeded", "amount", transfer.request.Amount, "sender", senderHex, "receiver", receiverHex)
+            metrics.TransactionsApplied.Inc("transfer")
+            a.observeTransfer(transfer.ctx, int64(transfer.transaction.BlockNumber), transfer.request.Sender, transfer.request.Receiver, tokens.Native, transfer.request.Amount)
+            a.indexReceipt(transfer.transaction, "transfer", "")
+        } else {
+            syncLogger.InfoContext(transfer.ctx, "transfer failed: insufficient funds", "amount", transfer.request.Amount, "sender", senderHex, "receiver", receiverHex)
+            metrics.TransactionsFailed.Inc("transfer", failureInsufficientFunds)
+            a.indexReceipt(transfer.transaction, "transfer", failureInsufficientFunds)
+        }
+        metrics.TransactionDuration.Observe(duration, "transfer")
+    }
+}
@@ -0,0 +1,58 @@
+// Package params keeps the params of the state transition in the Merkle state. A new
+// database records the params of its genesis file, so every node applies those of
+// the genesis it started from whatever its configuration says, and nodes started
+// from different genesis params disagree on the root of the first checkpoint.
+package params
+
+import (
+    "bytes"
+    "encoding/json"
+    "sync"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+)
+
+// key holds the recorded params
+var key = []byte(dbservice.GenesisPrefix + "params")
+
+// decoded keeps the last params read, since they are read for every transaction
+var decoded struct {
+    sync.Mutex
+    data   []byte
+    params config.Params
+}
+
+// Record writes the params of a genesis to a database without a checkpoint. A
+// genesis without params records nothing, which keeps the roots of databases
+// created before params were recorded.
+func Record(db *dbservice.DatabaseService, params *config.Params) error {
+    if params == nil {
+        return nil
+    }
+    data, err := json.Marshal(params)
+    if err != nil {
+        return err
+    }
+    return db.SetData(key, data)
+}
+
+// Current returns the params recorded in db, or the defaults when its genesis
+// recorded none
+func Current(db *dbservice.DatabaseService) (config.Params, error) {
+    data, err := db.GetData(key)
+    if err != nil || len(data) == 0 {
+        return config.DefaultParams(), err
+    }
+
+    decoded.Lock()
+    defer decoded.Unlock()
+    if !bytes.Equal(data, decoded.data) {
+        params := config.DefaultParams()
+        if err := json.Unmarshal(data, &params); err != nil {
+            return config.DefaultParams(), err
+        }
+        decoded.data, decoded.params = bytes.Clone(data), params
+    }
+    return decoded.params, nil
+}
@@ -0,0 +1,78 @@
+package params
+
+import (
+    "bytes"
+    "math/big"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbservice"
+)
+
+func TestCurrentReturnsTheParamsOfTheGenesis(t *testing.T) {
+    recorded := config.DefaultParams()
+    recorded.Settlement.Operators = []string{"c767ea1d613eefe0ce1610b18cb047881bafb829"}
+    recorded.Payload.MaxBytes = 512
+
+    tests := []struct {
+        name    string
+        genesis *config.Params
+        want    config.Params
+    }{
+        {name: "genesis with params", genesis: &recorded, want: recorded},
+        {name: "genesis without params", want: config.DefaultParams()},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            dir := t.TempDir()
+            db := dbservice.New("database")
+            db.SetDir(dir)
+            if err := Record(db, test.genesis); err != nil {
+                t.Fatal(err)
+            }
+            if err := db.Flush(); err != nil {
+                t.Fatal(err)
+            }
+            if err := db.Close(); err != nil {
+                t.Fatal(err)
+            }
+
+            // A reopened database keeps the params of its genesis
+            reopened := dbservice.New("database")
+            reopened.SetDir(dir)
+            defer reopened.Close()
+            got, err := Current(reopened)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(got, test.want) {
+                t.Errorf("Current = %+v, want %+v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestRecordedParamsChangeTheRoot(t *testing.T) {
+    roots := make([][]byte, 2)
+    for i, genesis := range []*config.Params{nil, {Swap: config.SwapParams{FeeBasisPoints: 1}}} {
+        db := dbservice.New("database")
+        db.SetDir(filepath.Join(t.TempDir(), "node"))
+        if err := db.SetBalance(bytes.Repeat([]byte{1}, dbservice.AddressLength), big.NewInt(100)); err != nil {
+            t.Fatal(err)
+        }
+        if err := Record(db, genesis); err != nil {
+            t.Fatal(err)
+        }
+        root, err := db.GetRootHash()
+        if err != nil {
+            t.Fatal(err)
+        }
+        roots[i] = root
+        db.Close()
+    }
+    if bytes.Equal(roots[0], roots[1]) {
+        t.Errorf("nodes with and without genesis params agree on root %x", roots[0])
+    }
+}
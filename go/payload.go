@@ -0,0 +1,94 @@
+package main
+
+import "encoding/json"
+
+// Reasons a payload is rejected before it is applied
+const (
+    failurePayloadTooLarge = "payload_too_large"
+    failurePayloadTooDeep  = "payload_too_deep"
+    failureFieldTooLong    = "field_too_long"
+    failureExpired         = "expired"
+)
+
+// checkEncodedSize rejects hex transaction data that decodes to more than the
+// payload limit, before anything is allocated for it
+func (a *App) checkEncodedSize(data string) string {
+    if limit := a.chainParams().Payload.MaxBytes; limit > 0 && len(data) > 2*limit {
+        return failurePayloadTooLarge
+    }
+    return ""
+}
+
+// checkNesting rejects JSON nested deeper than the limit. It scans the raw bytes so
+// the decoder never builds the deep structure.
+func (a *App) checkNesting(data []byte) string {
+    limit := a.chainParams().Payload.MaxDepth
+    if limit <= 0 {
+        return ""
+    }
+
+    depth := 0
+    inString, escaped := false, false
+    for _, b := range data {
+        switch {
+        case escaped:
+            escaped = false
+        case inString:
+            switch b {
+            case '\\':
+                escaped = true
+            case '"':
+                inString = false
+            }
+        case b == '"':
+            inString = true
+        case b == '{' || b == '[':
+            depth++
+            if depth > limit {
+                return failurePayloadTooDeep
+            }
+        case b == '}' || b == ']':
+            depth--
+        }
+    }
+    return ""
+}
+
+// checkFieldLengths rejects decoded payloads with a key or string value longer than the limit
+func (a *App) checkFieldLengths(value interface{}) string {
+    limit := a.chainParams().Payload.MaxFieldLength
+    if limit <= 0 {
+        return ""
+    }
+    return fieldLengths(value, limit)
+}
+
+// fieldLengths walks a decoded JSON value checking its keys and strings against limit
+func fieldLengths(value interface{}, limit int) string {
+    switch v := value.(type) {
+    case string:
+        if len(v) > limit {
+            return failureFieldTooLong
+        }
+    case json.Number:
+        if len(v) > limit {
+            return failureFieldTooLong
+        }
+    case map[string]interface{}:
+        for key, field := range v {
+            if len(key) > limit {
+                return failureFieldTooLong
+            }
+            if failure := fieldLengths(field, limit); failure != "" {
+                return failure
+            }
+        }
+    case []interface{}:
+        for _, item := range v {
+            if failure := fieldLengths(item, limit); failure != "" {
+                return failure
+            }
+        }
+    }
+    return ""
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/paymaster"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// failureSponsorDenied rejects a transfer whose sponsor would not pay its fee
+const failureSponsorDenied = "sponsor_denied"
+
+// reportPaymasterError reports an unexpected error reading or writing an allowance
+func reportPaymasterError(ctx context.Context, err error, sponsor, account []byte) {
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        "sponsor",
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sponsor": hex.EncodeToString(sponsor), "account": hex.EncodeToString(account)},
+    })
+}
+
+// checkSponsor returns the sponsor a transfer names, after checking that it will pay
+// the fee, so that a transfer is rejected rather than left unsponsored
+func (a *App) checkSponsor(ctx context.Context, sponsor, sender []byte, transaction rpc.VidaDataTransaction) ([]byte, string) {
+    if sponsor == nil {
+        return nil, ""
+    }
+    err := paymaster.Check(a.db, sponsor, sender, big.NewInt(int64(transaction.Fee)), int64(transaction.BlockNumber))
+    switch {
+    case errors.Is(err, paymaster.ErrNoAllowance), errors.Is(err, paymaster.ErrExpired),
+        errors.Is(err, paymaster.ErrLimitExceeded), errors.Is(err, paymaster.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, "transfer rejected: sponsor does not pay the fee", "reason", err,
+            "fee", transaction.Fee, "sponsor", hex.EncodeToString(sponsor), "sender", transaction.Sender)
+        return nil, failureSponsorDenied
+    case err != nil:
+        reportPaymasterError(ctx, err, sponsor, sender)
+        return nil, failureInvalidPayload
+    }
+    return sponsor, ""
+}
+
+// paySponsoredFee reimburses the sender of a sponsored transfer its fee
+func (a *App) paySponsoredFee(ctx context.Context, sponsor, sender []byte, transaction rpc.VidaDataTransaction) {
+    fee := big.NewInt(int64(transaction.Fee))
+    if err := paymaster.Pay(a.db, sponsor, sender, fee, int64(transaction.BlockNumber)); err != nil {
+        reportPaymasterError(ctx, err, sponsor, sender)
+        return
+    }
+    syncLogger.InfoContext(ctx, "sponsor paid the transfer fee", "fee", fee, "sponsor", hex.EncodeToString(sponsor), "sender", transaction.Sender)
+}
+
+// sponsorPayload is the payload of a sponsor action
+type sponsorPayload struct {
+    envelope
+    Account string `json:"account"`
+    // Allowance is the fees left to pay, none when it is not a positive amount
+    Allowance         number `json:"allowance"`
+    MaxPerTransaction number `json:"maxPerTransaction"`
+    ExpiresAt         number `json:"expiresAt"`
+
+    account           []byte
+    maxPerTransaction *big.Int
+    expiresAt         int64
+}
+
+func (p *sponsorPayload) validate(a *App) error {
+    if p.account = payloadAddress(p.Account); p.account == nil {
+        return invalidField("account", "must be an address")
+    }
+    if p.MaxPerTransaction.set() {
+        if p.maxPerTransaction = a.parseTokenAmount(p.MaxPerTransaction, tokens.Native); p.maxPerTransaction == nil {
+            return invalidAmount("maxPerTransaction", "must be a positive amount")
+        }
+    }
+    if p.ExpiresAt.set() {
+        var ok bool
+        if p.expiresAt, ok = payloadInt(p.ExpiresAt); !ok {
+            return invalidField("expiresAt", "must be a block number")
+        }
+    }
+    return nil
+}
+
+// handleSponsorAllowance sets how much of the fees of an account the sender pays.
+// It returns the reason the change was rejected, or an empty string on success.
+func (a *App) handleSponsorAllowance(ctx context.Context, p *sponsorPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sponsor := payloadAddress(senderHex)
+    if sponsor == nil {
+        return failureInvalidPayload
+    }
+    remaining := a.parseTokenAmount(p.Allowance, tokens.Native)
+    if remaining == nil {
+        remaining = new(big.Int)
+    }
+
+    if err := paymaster.Approve(a.db, sponsor, p.account, remaining, p.maxPerTransaction, p.expiresAt); err != nil {
+        reportPaymasterError(ctx, err, sponsor, p.account)
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "sponsorship allowance set", "allowance", remaining, "sponsor", senderHex, "account", hex.EncodeToString(p.account))
+    return ""
+}
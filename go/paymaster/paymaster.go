@@ -0,0 +1,137 @@
+// Package paymaster lets a sponsor account pay the transaction fees of other
+// accounts. The PWR fee of a VIDA transaction is always paid by its signer on the
+// base chain, so a sponsored transfer reimburses the sender the same amount in the
+// native token of the VIDA, out of an allowance the sponsor granted it. Allowances
+// are part of the Merkle state.
+package paymaster
+
+import (
+    "encoding/hex"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// Errors of refused sponsorships
+var (
+    ErrNoAllowance       = errors.New("no sponsorship allowance")
+    ErrExpired           = errors.New("sponsorship allowance expired")
+    ErrLimitExceeded     = errors.New("fee above the sponsorship limit")
+    ErrInsufficientFunds = errors.New("sponsor has insufficient funds")
+)
+
+// Allowance is what a sponsor pays of the fees of an account
+type Allowance struct {
+    Sponsor string `json:"sponsor"`
+    Account string `json:"account"`
+    // Remaining is the total the sponsor still pays
+    Remaining string `json:"remaining"`
+    // MaxPerTransaction caps the fee paid for one transaction, empty for no cap
+    MaxPerTransaction string `json:"maxPerTransaction,omitempty"`
+    // ExpiresAt is the first block the allowance no longer applies at, 0 for never
+    ExpiresAt int64 `json:"expiresAt,omitempty"`
+    // Paid is the total the sponsor paid under the allowance
+    Paid string `json:"paid"`
+}
+
+// allowanceKey returns the tree key of the allowance of sponsor for account
+func allowanceKey(sponsor, account []byte) []byte {
+    return []byte(dbservice.PaymasterPrefix + "allowance/" + hex.EncodeToString(sponsor) + "/" + hex.EncodeToString(account))
+}
+
+// Lookup returns the allowance of sponsor for account, and false when there is none
+func Lookup(db *dbservice.DatabaseService, sponsor, account []byte) (Allowance, bool, error) {
+    var allowance Allowance
+    found, err := db.LoadJSON(allowanceKey(sponsor, account), &allowance)
+    return allowance, found, err
+}
+
+// store writes an allowance
+func store(db *dbservice.DatabaseService, sponsor, account []byte, allowance Allowance) error {
+    return db.SaveJSON(allowanceKey(sponsor, account), allowance)
+}
+
+// Approve sets the allowance of sponsor for account, replacing any previous one. A
+// zero remaining amount revokes it.
+func Approve(db *dbservice.DatabaseService, sponsor, account []byte, remaining, maxPerTransaction *big.Int, expiresAt int64) error {
+    if remaining.Sign() == 0 {
+        return db.SetData(allowanceKey(sponsor, account), []byte{})
+    }
+    paid := "0"
+    if previous, found, err := Lookup(db, sponsor, account); err != nil {
+        return err
+    } else if found {
+        paid = previous.Paid
+    }
+    allowance := Allowance{
+        Sponsor:   hex.EncodeToString(sponsor),
+        Account:   hex.EncodeToString(account),
+        Remaining: remaining.String(),
+        ExpiresAt: expiresAt,
+        Paid:      paid,
+    }
+    if maxPerTransaction != nil {
+        allowance.MaxPerTransaction = maxPerTransaction.String()
+    }
+    return store(db, sponsor, account, allowance)
+}
+
+// Check returns why sponsor would not pay fee for account at block, or nil
+func Check(db *dbservice.DatabaseService, sponsor, account []byte, fee *big.Int, block int64) error {
+    allowance, found, err := Lookup(db, sponsor, account)
+    if err != nil {
+        return err
+    }
+    if !found {
+        return ErrNoAllowance
+    }
+    if allowance.ExpiresAt != 0 && block >= allowance.ExpiresAt {
+        return ErrExpired
+    }
+    remaining := dbservice.ParseAmount(allowance.Remaining)
+    if fee.Cmp(remaining) > 0 {
+        return ErrLimitExceeded
+    }
+    if allowance.MaxPerTransaction != "" {
+        limit := dbservice.ParseAmount(allowance.MaxPerTransaction)
+        if fee.Cmp(limit) > 0 {
+            return ErrLimitExceeded
+        }
+    }
+    balance, err := db.GetBalance(sponsor)
+    if err != nil {
+        return err
+    }
+    if balance.Cmp(fee) < 0 {
+        return ErrInsufficientFunds
+    }
+    return nil
+}
+
+// Pay reimburses account the fee of a transaction at block from sponsor, within the
+// allowance, after the same checks as Check
+func Pay(db *dbservice.DatabaseService, sponsor, account []byte, fee *big.Int, block int64) error {
+    if err := Check(db, sponsor, account, fee, block); err != nil {
+        return err
+    }
+    if fee.Sign() == 0 {
+        return nil
+    }
+    ok, err := db.Transfer(sponsor, account, fee)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return ErrInsufficientFunds
+    }
+    allowance, _, err := Lookup(db, sponsor, account)
+    if err != nil {
+        return err
+    }
+    remaining := dbservice.ParseAmount(allowance.Remaining)
+    paid := dbservice.ParseAmount(allowance.Paid)
+    allowance.Remaining = remaining.Sub(remaining, fee).String()
+    allowance.Paid = paid.Add(paid, fee).String()
+    return store(db, sponsor, account, allowance)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/chaos"
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/statesync"
+)
+
+// newPeerClient returns a client for peer endpoints and the URL scheme to use. With
+// peerTls configured it connects over HTTPS, presenting the validator certificate.
+func newPeerClient(cfg config.PeerTLSConfig, timeout time.Duration) (*http.Client, string, error) {
+    if cfg.CertFile == "" && cfg.CAFile == "" {
+        return &http.Client{Timeout: timeout}, "http", nil
+    }
+
+    tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+    if cfg.CertFile != "" {
+        certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+        if err != nil {
+            return nil, "", fmt.Errorf("peerTls: %v", err)
+        }
+        tlsConfig.Certificates = []tls.Certificate{certificate}
+    }
+    if cfg.CAFile != "" {
+        pem, err := os.ReadFile(cfg.CAFile)
+        if err != nil {
+            return nil, "", fmt.Errorf("peerTls.caFile: %v", err)
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, "", fmt.Errorf("peerTls.caFile: %s holds no PEM certificates", cfg.CAFile)
+        }
+        tlsConfig.RootCAs = pool
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = tlsConfig
+    return &http.Client{Timeout: timeout, Transport: transport}, "https", nil
+}
+
+// httpPeers fetches root hashes from the /rootHash endpoint of peers, presenting
+// the peer token when one is configured
+type httpPeers struct {
+    client *http.Client
+    scheme string
+    token  string
+}
+
+// newHTTPPeers returns the peer client of the peerTls configuration
+func newHTTPPeers(cfg config.PeerTLSConfig, token string, timeout time.Duration) (httpPeers, error) {
+    client, scheme, err := newPeerClient(cfg, timeout)
+    if err != nil {
+        return httpPeers{}, err
+    }
+    return httpPeers{client: client, scheme: scheme, token: token}, nil
+}
+
+// RootHash fetches the root hash from a peer node for the specified block number
+func (p httpPeers) RootHash(peer string, blockNumber int) (bool, []byte) {
+    url := fmt.Sprintf("%s://%s/rootHash?blockNumber=%d", p.scheme, peer, blockNumber)
+    if chaos.Inject(chaos.PeerTimeout) {
+        peerLogger.Warn("failed to fetch root hash", "peer", peer, "block", blockNumber, "error", chaos.Error(chaos.PeerTimeout))
+        metrics.PeerErrors.Inc(peer)
+        return false, nil
+    }
+
+    request, err := http.NewRequest(http.MethodGet, url, nil)
+    if err != nil {
+        return false, nil
+    }
+    if p.token != "" {
+        request.Header.Set("Authorization", "Bearer "+p.token)
+    }
+    resp, err := p.client.Do(request)
+    if err != nil {
+        peerLogger.Warn("failed to fetch root hash", "peer", peer, "block", blockNumber, "error", err)
+        metrics.PeerErrors.Inc(peer)
+        return false, nil
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == 200 {
+        body, _ := io.ReadAll(resp.Body)
+        hexString := strings.TrimSpace(string(body))
+
+        if hexString == "" {
+            peerLogger.Warn("peer returned empty root hash", "peer", peer, "block", blockNumber)
+            metrics.PeerErrors.Inc(peer)
+            return false, nil
+        }
+
+        rootHash, err := hex.DecodeString(hexString)
+        if err != nil {
+            peerLogger.Warn("invalid hex response from peer", "peer", peer, "block", blockNumber)
+            metrics.PeerErrors.Inc(peer)
+            return false, nil
+        }
+
+        peerLogger.Debug("fetched root hash", "peer", peer, "block", blockNumber, "rootHash", hexString)
+        return true, rootHash
+    } else {
+        peerLogger.Warn("peer returned an error status", "peer", peer, "block", blockNumber, "status", resp.StatusCode)
+        metrics.PeerErrors.Inc(peer)
+        return true, nil
+    }
+}
+
+// get fetches path from a peer and returns the body of a successful response
+func (p httpPeers) get(peer, path string) ([]byte, error) {
+    request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s%s", p.scheme, peer, path), nil)
+    if err != nil {
+        return nil, err
+    }
+    if p.token != "" {
+        request.Header.Set("Authorization", "Bearer "+p.token)
+    }
+    resp, err := p.client.Do(request)
+    if err != nil {
+        metrics.PeerErrors.Inc(peer)
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        metrics.PeerErrors.Inc(peer)
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        metrics.PeerErrors.Inc(peer)
+        return nil, fmt.Errorf("peer returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+    }
+    return body, nil
+}
+
+// stateQuery returns the query selecting a snapshot, the newest for a negative block number
+func stateQuery(blockNumber int64, chunkLeaves int) string {
+    query := fmt.Sprintf("chunkLeaves=%d", chunkLeaves)
+    if blockNumber >= 0 {
+        query += fmt.Sprintf("&blockNumber=%d", blockNumber)
+    }
+    return query
+}
+
+// StateManifest fetches and verifies the manifest of a snapshot from a peer
+func (p httpPeers) StateManifest(peer string, blockNumber int64, chunkLeaves int) (*statesync.Manifest, error) {
+    body, err := p.get(peer, "/state/manifest?"+stateQuery(blockNumber, chunkLeaves))
+    if err != nil {
+        return nil, err
+    }
+    return statesync.DecodeManifest(body)
+}
+
+// StateChunk fetches a chunk of a snapshot from a peer and checks it against the manifest
+func (p httpPeers) StateChunk(peer string, manifest *statesync.Manifest, index int) ([]dbfile.Entry, error) {
+    body, err := p.get(peer, fmt.Sprintf("/state/chunk?%s&index=%d", stateQuery(manifest.BlockNumber, manifest.ChunkLeaves), index))
+    if err != nil {
+        return nil, err
+    }
+    got, entries, err := statesync.DecodeChunk(body)
+    if err != nil {
+        return nil, err
+    }
+    if got != index {
+        return nil, fmt.Errorf("peer returned chunk %d instead of %d", got, index)
+    }
+    return entries, manifest.CheckChunk(index, entries)
+}
+
+// ArchiveRecords fetches up to limit archive records after a block from a peer
+func (p httpPeers) ArchiveRecords(peer string, blockNumber int64, limit int) ([]archive.Record, error) {
+    body, err := p.get(peer, fmt.Sprintf("/archive/after?blockNumber=%d&limit=%d", blockNumber, limit))
+    if err != nil {
+        return nil, err
+    }
+    var records []archive.Record
+    if err := json.Unmarshal(body, &records); err != nil {
+        return nil, fmt.Errorf("invalid archive records: %v", err)
+    }
+    return records, nil
+}
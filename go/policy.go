@@ -0,0 +1,95 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "strings"
+
+    "pwr-stateful-vida/governance"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/policy"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons a policy update or a transfer is rejected by the policy
+const (
+    failureUnauthorized = "unauthorized"
+    failurePolicyDenied = "policy_denied"
+)
+
+// isGovernor reports whether the sender of a transaction may update the policy.
+// Executed governance proposals act as a governor.
+func (a *App) isGovernor(senderHex string) bool {
+    sender := strings.TrimPrefix(strings.ToLower(senderHex), "0x")
+    if sender == hex.EncodeToString(governance.Address) {
+        return true
+    }
+    for _, governor := range a.chainParams().Policy.Governors {
+        if strings.TrimPrefix(strings.ToLower(governor), "0x") == sender {
+            return true
+        }
+    }
+    return false
+}
+
+// policyPayload is the payload of a policy update
+type policyPayload struct {
+    envelope
+    Op      string  `json:"op"`
+    Tag     string  `json:"tag"`
+    Address *string `json:"address"`
+    Amount  *string `json:"amount"`
+}
+
+// handlePolicyUpdate applies a policy update sent by a governor. It returns the
+// reason the update was rejected, or an empty string on success.
+func (a *App) handlePolicyUpdate(ctx context.Context, p *policyPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    if !a.isGovernor(senderHex) {
+        syncLogger.WarnContext(ctx, "policy update from a non-governor", "sender", senderHex)
+        return failureUnauthorized
+    }
+
+    update := policy.Update{Op: p.Op, Tag: p.Tag}
+    if p.Address != nil {
+        update.Address, _ = hex.DecodeString(strings.TrimPrefix(*p.Address, "0x"))
+    }
+    if p.Amount != nil {
+        update.Amount, _ = new(big.Int).SetString(*p.Amount, 10)
+    }
+
+    if err := policy.Apply(a.db, update); err != nil {
+        if errors.Is(err, policy.ErrInvalidUpdate) {
+            syncLogger.WarnContext(ctx, "skipping invalid policy update", "payload", p, "error", err)
+            return failureInvalidPayload
+        }
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "policy",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "op": update.Op},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "policy updated", "op", update.Op, "sender", senderHex)
+    return ""
+}
+
+// checkTransferPolicy returns failurePolicyDenied when the policy forbids a transfer
+func (a *App) checkTransferPolicy(ctx context.Context, sender, receiver []byte, amount *big.Int) string {
+    reason, err := policy.Check(a.db, sender, receiver, amount)
+    if err != nil {
+        reporting.Report(err, reporting.Context{Module: "handler", Action: "transfer", CorrelationID: logging.CorrelationID(ctx)})
+        return failurePolicyDenied
+    }
+    if reason != "" {
+        syncLogger.InfoContext(ctx, "transfer rejected by policy", "reason", reason, "amount", amount,
+            "sender", hex.EncodeToString(sender), "receiver", hex.EncodeToString(receiver))
+        return failurePolicyDenied
+    }
+    return ""
+}
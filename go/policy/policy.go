@@ -0,0 +1,190 @@
+// Package policy decides which transfers may be applied. The policy is part of the
+// Merkle state and only changes through governance transactions, so every node
+// applies the same policy at the same block.
+package policy
+
+import (
+    "errors"
+    "fmt"
+    "math/big"
+    "strings"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// Reasons a transfer is rejected
+const (
+    ReasonDenylisted          = "denylisted"
+    ReasonNotAllowlisted      = "not_allowlisted"
+    ReasonBlockedJurisdiction = "blocked_jurisdiction"
+    ReasonAmountTooLarge      = "amount_too_large"
+)
+
+// Operations of a policy update
+const (
+    OpDeny             = "deny"
+    OpUndeny           = "undeny"
+    OpAllow            = "allow"
+    OpDisallow         = "disallow"
+    OpTag              = "tag"
+    OpBlockTag         = "blockTag"
+    OpUnblockTag       = "unblockTag"
+    OpMaxAmount        = "maxAmount"
+    OpEnableAllowlist  = "enableAllowlist"
+    OpDisableAllowlist = "disableAllowlist"
+)
+
+// ErrInvalidUpdate is wrapped by the errors of malformed updates
+var ErrInvalidUpdate = errors.New("invalid policy update")
+
+var (
+    maxAmountKey = []byte(dbservice.PolicyPrefix + "maxAmount")
+    allowlistKey = []byte(dbservice.PolicyPrefix + "allowlist")
+)
+
+func denyKey(address []byte) []byte  { return append([]byte(dbservice.PolicyPrefix+"deny/"), address...) }
+func allowKey(address []byte) []byte { return append([]byte(dbservice.PolicyPrefix+"allow/"), address...) }
+func tagKey(address []byte) []byte   { return append([]byte(dbservice.PolicyPrefix+"tag/"), address...) }
+func blockedTagKey(tag string) []byte {
+    return []byte(dbservice.PolicyPrefix + "blockedTag/" + tag)
+}
+
+// Update is a governance action changing the policy. Address is used by the
+// address operations, Tag by tag and the tag blocking operations and Amount by
+// maxAmount, where 0 removes the limit.
+type Update struct {
+    Op      string
+    Address []byte
+    Tag     string
+    Amount  *big.Int
+}
+
+// Account is the policy applied to an address
+type Account struct {
+    Denylisted  bool   `json:"denylisted"`
+    Allowlisted bool   `json:"allowlisted"`
+    Tag         string `json:"tag,omitempty"`
+    TagBlocked  bool   `json:"tagBlocked"`
+}
+
+// Rules are the policy settings that apply to every transfer
+type Rules struct {
+    // MaxAmount is the largest transfer allowed, 0 for no limit
+    MaxAmount string `json:"maxAmount"`
+    // Allowlist requires both parties of a transfer to be allowlisted
+    Allowlist bool `json:"allowlist"`
+}
+
+// flag reads a boolean stored under key
+func flag(db *dbservice.DatabaseService, key []byte) (bool, error) {
+    data, err := db.GetData(key)
+    return len(data) > 0 && data[0] == 1, err
+}
+
+// setFlag stores a boolean under key. The tree cannot delete keys, so false is stored as 0.
+func setFlag(db *dbservice.DatabaseService, key []byte, value bool) error {
+    if value {
+        return db.SetData(key, []byte{1})
+    }
+    return db.SetData(key, []byte{0})
+}
+
+// AccountPolicy returns the policy applied to address
+func AccountPolicy(db *dbservice.DatabaseService, address []byte) (Account, error) {
+    var account Account
+    var err error
+    if account.Denylisted, err = flag(db, denyKey(address)); err != nil {
+        return account, err
+    }
+    if account.Allowlisted, err = flag(db, allowKey(address)); err != nil {
+        return account, err
+    }
+    tag, err := db.GetData(tagKey(address))
+    if err != nil {
+        return account, err
+    }
+    account.Tag = string(tag)
+    if account.Tag != "" {
+        if account.TagBlocked, err = flag(db, blockedTagKey(account.Tag)); err != nil {
+            return account, err
+        }
+    }
+    return account, nil
+}
+
+// CurrentRules returns the settings that apply to every transfer
+func CurrentRules(db *dbservice.DatabaseService) (Rules, error) {
+    data, err := db.GetData(maxAmountKey)
+    if err != nil {
+        return Rules{}, err
+    }
+    allowlist, err := flag(db, allowlistKey)
+    return Rules{MaxAmount: new(big.Int).SetBytes(data).String(), Allowlist: allowlist}, err
+}
+
+// Check returns the reason a transfer violates the policy, or an empty string if it is allowed
+func Check(db *dbservice.DatabaseService, sender, receiver []byte, amount *big.Int) (string, error) {
+    data, err := db.GetData(maxAmountKey)
+    if err != nil {
+        return "", err
+    }
+    if max := new(big.Int).SetBytes(data); max.Sign() > 0 && amount != nil && amount.Cmp(max) > 0 {
+        return ReasonAmountTooLarge, nil
+    }
+    allowlist, err := flag(db, allowlistKey)
+    if err != nil {
+        return "", err
+    }
+
+    for _, address := range [][]byte{sender, receiver} {
+        account, err := AccountPolicy(db, address)
+        if err != nil {
+            return "", err
+        }
+        switch {
+        case account.Denylisted:
+            return ReasonDenylisted, nil
+        case account.TagBlocked:
+            return ReasonBlockedJurisdiction, nil
+        case allowlist && !account.Allowlisted:
+            return ReasonNotAllowlisted, nil
+        }
+    }
+    return "", nil
+}
+
+// Apply applies a policy update to the state
+func Apply(db *dbservice.DatabaseService, update Update) error {
+    switch update.Op {
+    case OpDeny, OpUndeny, OpAllow, OpDisallow, OpTag:
+        if len(update.Address) != dbservice.AddressLength {
+            return fmt.Errorf("%w: %s needs a 20 byte address", ErrInvalidUpdate, update.Op)
+        }
+    case OpBlockTag, OpUnblockTag:
+        if update.Tag == "" || strings.Contains(update.Tag, "/") {
+            return fmt.Errorf("%w: %s needs a tag without slashes", ErrInvalidUpdate, update.Op)
+        }
+    case OpMaxAmount:
+        if update.Amount == nil || update.Amount.Sign() < 0 {
+            return fmt.Errorf("%w: maxAmount needs a non-negative amount", ErrInvalidUpdate)
+        }
+    case OpEnableAllowlist, OpDisableAllowlist:
+    default:
+        return fmt.Errorf("%w: unknown operation %q", ErrInvalidUpdate, update.Op)
+    }
+
+    switch update.Op {
+    case OpDeny, OpUndeny:
+        return setFlag(db, denyKey(update.Address), update.Op == OpDeny)
+    case OpAllow, OpDisallow:
+        return setFlag(db, allowKey(update.Address), update.Op == OpAllow)
+    case OpTag:
+        return db.SetData(tagKey(update.Address), []byte(update.Tag))
+    case OpBlockTag, OpUnblockTag:
+        return setFlag(db, blockedTagKey(update.Tag), update.Op == OpBlockTag)
+    case OpMaxAmount:
+        return db.SetData(maxAmountKey, update.Amount.Bytes())
+    default:
+        return setFlag(db, allowlistKey, update.Op == OpEnableAllowlist)
+    }
+}
@@ -0,0 +1,178 @@
+// Package prune removes history older than a retention window: snapshots, archive
+// segments and transaction log records. Block root hashes are part of the Merkle
+// state, so removing them would change the root and they are never pruned.
+package prune
+
+import (
+    "errors"
+    "os"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/snapshot"
+)
+
+// ErrRunning is returned when a pruning run is already in progress
+var ErrRunning = errors.New("pruning is already running")
+
+// Options describes what a run prunes
+type Options struct {
+    // Checkpoint is the last checked block of the database
+    Checkpoint int64
+    // KeepBlocks is the number of blocks before the checkpoint whose history is kept
+    KeepBlocks int64
+    // KeepSnapshots is the minimum number of snapshots kept regardless of their age
+    KeepSnapshots int
+    SnapshotDir   string
+    // ArchiveDir is skipped when empty
+    ArchiveDir string
+    // CompactLog removes the transaction log records up to a block; nil skips the log
+    CompactLog func(keepAfter int64) (int, error)
+}
+
+// Progress reports the current or last pruning run
+type Progress struct {
+    Running             bool      `json:"running"`
+    Phase               string    `json:"phase,omitempty"`
+    Runs                int       `json:"runs"`
+    StartedAt           time.Time `json:"startedAt"`
+    FinishedAt          time.Time `json:"finishedAt"`
+    NextRun             time.Time `json:"nextRun"`
+    Cutoff              int64     `json:"cutoff"`
+    SnapshotsRemoved    int       `json:"snapshotsRemoved"`
+    ArchiveFilesRemoved int       `json:"archiveFilesRemoved"`
+    LogRecordsRemoved   int       `json:"logRecordsRemoved"`
+    BytesFreed          int64     `json:"bytesFreed"`
+    LastError           string    `json:"lastError,omitempty"`
+}
+
+var (
+    progress      Progress
+    progressMutex sync.Mutex
+)
+
+// Status returns the progress of the current or last run
+func Status() Progress {
+    progressMutex.Lock()
+    defer progressMutex.Unlock()
+    return progress
+}
+
+// update changes the progress under the lock
+func update(fn func(p *Progress)) {
+    progressMutex.Lock()
+    fn(&progress)
+    progressMutex.Unlock()
+}
+
+// SetNextRun records when the scheduler will run next
+func SetNextRun(next time.Time) {
+    update(func(p *Progress) { p.NextRun = next })
+}
+
+// Run prunes everything older than the retention window and returns the progress of the run
+func Run(options Options) (Progress, error) {
+    progressMutex.Lock()
+    if progress.Running {
+        progressMutex.Unlock()
+        return Progress{}, ErrRunning
+    }
+    progress = Progress{
+        Running:   true,
+        Runs:      progress.Runs + 1,
+        StartedAt: time.Now(),
+        NextRun:   progress.NextRun,
+        Cutoff:    max(options.Checkpoint-options.KeepBlocks, 0),
+    }
+    progressMutex.Unlock()
+
+    err := run(options)
+
+    update(func(p *Progress) {
+        p.Running = false
+        p.Phase = ""
+        p.FinishedAt = time.Now()
+        if err != nil {
+            p.LastError = err.Error()
+        }
+    })
+    return Status(), err
+}
+
+func run(options Options) error {
+    cutoff := Status().Cutoff
+
+    update(func(p *Progress) { p.Phase = "snapshots" })
+    oldestKept, err := pruneSnapshots(options.SnapshotDir, cutoff, options.KeepSnapshots)
+    if err != nil {
+        return err
+    }
+
+    if options.ArchiveDir != "" {
+        update(func(p *Progress) { p.Phase = "archive" })
+        files, freed, err := archive.Prune(options.ArchiveDir, cutoff)
+        update(func(p *Progress) {
+            p.ArchiveFilesRemoved += files
+            p.BytesFreed += freed
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    // Replaying from the oldest kept snapshot needs the log records after its block
+    if options.CompactLog != nil {
+        update(func(p *Progress) { p.Phase = "transaction log" })
+        keepAfter := cutoff
+        if oldestKept >= 0 && oldestKept < keepAfter {
+            keepAfter = oldestKept
+        }
+        removed, err := options.CompactLog(keepAfter)
+        update(func(p *Progress) { p.LogRecordsRemoved += removed })
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// pruneSnapshots removes snapshots taken before cutoff. The newest of them is kept as
+// a base for rebuilding the blocks after cutoff, and so are the newest keep snapshots.
+// It returns the block of the oldest remaining snapshot, or -1 if there is none.
+func pruneSnapshots(dir string, cutoff int64, keep int) (int64, error) {
+    snapshots, err := snapshot.List(dir)
+    if err != nil {
+        return -1, err
+    }
+
+    base := -1
+    for i, info := range snapshots {
+        if info.BlockNumber <= cutoff {
+            base = i
+        }
+    }
+
+    oldestKept := int64(-1)
+    for i, info := range snapshots {
+        if i >= base || i >= len(snapshots)-keep {
+            if oldestKept < 0 {
+                oldestKept = info.BlockNumber
+            }
+            continue
+        }
+
+        stat, err := os.Stat(info.Path)
+        if err != nil {
+            return oldestKept, err
+        }
+        if err := os.Remove(info.Path); err != nil {
+            return oldestKept, err
+        }
+        update(func(p *Progress) {
+            p.SnapshotsRemoved++
+            p.BytesFreed += stat.Size()
+        })
+    }
+    return oldestKept, nil
+}
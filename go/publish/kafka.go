@@ -0,0 +1,55 @@
+package publish
+
+import (
+    "context"
+    "crypto/tls"
+    "time"
+
+    "github.com/segmentio/kafka-go"
+    "github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// Kafka sends messages to a Kafka cluster, waiting for all in-sync replicas to
+// acknowledge them
+type Kafka struct {
+    writer *kafka.Writer
+}
+
+// NewKafka returns a broker writing to the given bootstrap servers. The username
+// authenticates with SASL PLAIN when it is not empty.
+func NewKafka(addresses []string, username, password string, useTLS bool) *Kafka {
+    transport := &kafka.Transport{}
+    if username != "" {
+        transport.SASL = plain.Mechanism{Username: username, Password: password}
+    }
+    if useTLS {
+        transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+    }
+    return &Kafka{writer: &kafka.Writer{
+        Addr:         kafka.TCP(addresses...),
+        Balancer:     &kafka.Hash{},
+        RequiredAcks: kafka.RequireAll,
+        BatchSize:    batchSize,
+        BatchTimeout: 10 * time.Millisecond,
+        Transport:    transport,
+    }}
+}
+
+// Send writes the messages, each to its topic
+func (k *Kafka) Send(ctx context.Context, messages []Message) error {
+    records := make([]kafka.Message, len(messages))
+    for i, message := range messages {
+        records[i] = kafka.Message{
+            Topic:   message.Topic,
+            Key:     message.Key,
+            Value:   message.Value,
+            Headers: []kafka.Header{{Key: "sequence", Value: []byte(message.ID)}},
+        }
+    }
+    return k.writer.WriteMessages(ctx, records...)
+}
+
+// Close flushes and closes the writer
+func (k *Kafka) Close() error {
+    return k.writer.Close()
+}
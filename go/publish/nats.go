@@ -0,0 +1,57 @@
+package publish
+
+import (
+    "context"
+    "crypto/tls"
+    "strings"
+
+    "github.com/nats-io/nats.go"
+    "github.com/nats-io/nats.go/jetstream"
+)
+
+// NATS publishes messages to JetStream, which acknowledges them once they are
+// stored. A stream must capture the subjects of the topics.
+type NATS struct {
+    conn   *nats.Conn
+    stream jetstream.JetStream
+}
+
+// NewNATS connects to the given servers, as a user when username is not empty.
+// The connection keeps retrying in the background while no server is reachable.
+func NewNATS(addresses []string, username, password string, useTLS bool) (*NATS, error) {
+    options := []nats.Option{nats.Name("pwr-stateful-vida"), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true)}
+    if username != "" {
+        options = append(options, nats.UserInfo(username, password))
+    }
+    if useTLS {
+        options = append(options, nats.Secure(&tls.Config{MinVersion: tls.VersionTLS12}))
+    }
+    conn, err := nats.Connect(strings.Join(addresses, ","), options...)
+    if err != nil {
+        return nil, err
+    }
+    stream, err := jetstream.New(conn)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return &NATS{conn: conn, stream: stream}, nil
+}
+
+// Send publishes the messages in order, with their ID as the message ID so
+// JetStream drops the ones it already stored
+func (n *NATS) Send(ctx context.Context, messages []Message) error {
+    for _, message := range messages {
+        msg := &nats.Msg{Subject: message.Topic, Data: message.Value, Header: nats.Header{}}
+        msg.Header.Set("Key", string(message.Key))
+        if _, err := n.stream.PublishMsg(ctx, msg, jetstream.WithMsgID(message.ID)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Close drains the connection
+func (n *NATS) Close() error {
+    return n.conn.Drain()
+}
@@ -0,0 +1,392 @@
+// Package publish emits the committed events of the node to a message broker with
+// at-least-once delivery. Events are appended to an outbox file when their batch
+// is committed and sent from it in order; the cursor file records the last event
+// the broker acknowledged, so a restarted node resumes after it.
+package publish
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/logging"
+)
+
+// Event types, which also name the topic of the event
+const (
+    TypeTransfer   = "transfers"
+    TypeBlock      = "blocks"
+    TypeValidation = "validations"
+)
+
+// Event is a message published to the broker. Sequence increases by one with
+// every event, so consumers can skip the events delivered twice.
+type Event struct {
+    Sequence uint64    `json:"sequence"`
+    Type     string    `json:"type"`
+    Block    int64     `json:"block"`
+    Time     time.Time `json:"time"`
+    // Hash, Sender, Receiver, Token and Amount describe a transfer
+    Hash     string `json:"hash,omitempty"`
+    Sender   string `json:"sender,omitempty"`
+    Receiver string `json:"receiver,omitempty"`
+    Token    string `json:"token,omitempty"`
+    Amount   string `json:"amount,omitempty"`
+    // RootHash is the root of a finalized or validated block
+    RootHash string `json:"rootHash,omitempty"`
+    // Validated tells whether a quorum of peers agreed with the root of the block
+    Validated *bool `json:"validated,omitempty"`
+}
+
+// key partitions the events, keeping the transfers of a sender and the blocks in order
+func (e Event) key() []byte {
+    if e.Sender != "" {
+        return []byte(e.Sender)
+    }
+    return []byte(e.Type)
+}
+
+// Message is an event encoded for a broker
+type Message struct {
+    Topic string
+    // ID is unique per event, for brokers that drop duplicates
+    ID    string
+    Key   []byte
+    Value []byte
+}
+
+// Broker sends messages, returning once the broker acknowledged all of them
+type Broker interface {
+    Send(ctx context.Context, messages []Message) error
+    Close() error
+}
+
+// Cursor is the position of the last event the broker acknowledged
+type Cursor struct {
+    Sequence uint64    `json:"sequence"`
+    Block    int64     `json:"block"`
+    Time     time.Time `json:"time"`
+}
+
+// batchSize bounds the number of events sent to the broker at once
+const batchSize = 500
+
+// maxBackoff bounds the wait between attempts to reach the broker
+const maxBackoff = 30 * time.Second
+
+var logger = logging.For("publish")
+
+// Publisher buffers the events of a batch, appends them to the outbox once the
+// batch is committed and sends the outbox to the broker in the background
+type Publisher struct {
+    broker Broker
+    prefix string
+    path   string
+
+    mutex   sync.Mutex
+    outbox  *os.File
+    pending []Event
+    // next is the sequence of the next event appended to the outbox
+    next uint64
+    // offset is where the first event not acknowledged starts in the outbox
+    offset int64
+    cursor Cursor
+
+    wake chan struct{}
+    stop chan struct{}
+    done chan struct{}
+    // OnError is called with every failed delivery before it is retried
+    OnError func(error)
+}
+
+// Open returns a publisher sending the outbox at path to broker, on topics named
+// <prefix>.<event type>. Events left in the outbox after the cursor are sent first.
+func Open(path, prefix string, broker Broker) (*Publisher, error) {
+    p := &Publisher{
+        broker: broker,
+        prefix: prefix,
+        path:   path,
+        wake:   make(chan struct{}, 1),
+        stop:   make(chan struct{}),
+        done:   make(chan struct{}),
+    }
+    data, err := os.ReadFile(path + ".cursor")
+    if err == nil {
+        err = json.Unmarshal(data, &p.cursor)
+    }
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        return nil, err
+    }
+    p.next = p.cursor.Sequence + 1
+
+    p.outbox, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+    if err != nil {
+        return nil, err
+    }
+    if err := p.recover(); err != nil {
+        p.outbox.Close()
+        return nil, err
+    }
+    go p.run()
+    return p, nil
+}
+
+// recover finds the first event after the cursor and the next sequence in the
+// outbox, dropping a last line cut short by a crash
+func (p *Publisher) recover() error {
+    reader := bufio.NewReader(p.outbox)
+    var position int64
+    found := false
+    for {
+        line, err := reader.ReadBytes('\n')
+        if err == io.EOF {
+            if len(line) > 0 {
+                if err := p.outbox.Truncate(position); err != nil {
+                    return err
+                }
+            }
+            break
+        }
+        if err != nil {
+            return err
+        }
+        var event Event
+        if err := json.Unmarshal(line, &event); err != nil {
+            return err
+        }
+        if !found && event.Sequence > p.cursor.Sequence {
+            p.offset, found = position, true
+        }
+        p.next = max(p.next, event.Sequence+1)
+        position += int64(len(line))
+    }
+    if !found {
+        p.offset = position
+    }
+    _, err := p.outbox.Seek(position, io.SeekStart)
+    return err
+}
+
+// Add buffers an event of the current batch
+func (p *Publisher) Add(event Event) {
+    p.mutex.Lock()
+    p.pending = append(p.pending, event)
+    p.mutex.Unlock()
+}
+
+// Commit appends the buffered events to the outbox and syncs it. The events stay
+// buffered for the next commit when the outbox cannot be written.
+func (p *Publisher) Commit() error {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+
+    if err := p.append(p.pending); err != nil {
+        return err
+    }
+    p.pending = nil
+    return nil
+}
+
+// Send appends an event that does not belong to a batch straight to the outbox
+func (p *Publisher) Send(event Event) error {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+
+    return p.append([]Event{event})
+}
+
+// append numbers the events and writes them to the outbox
+func (p *Publisher) append(events []Event) error {
+    if len(events) == 0 {
+        return nil
+    }
+    var data []byte
+    now := time.Now().UTC()
+    for i := range events {
+        events[i].Sequence = p.next + uint64(i)
+        if events[i].Time.IsZero() {
+            events[i].Time = now
+        }
+        line, _ := json.Marshal(events[i])
+        data = append(append(data, line...), '\n')
+    }
+    if _, err := p.outbox.Write(data); err != nil {
+        return err
+    }
+    if err := p.outbox.Sync(); err != nil {
+        return err
+    }
+    p.next += uint64(len(events))
+    select {
+    case p.wake <- struct{}{}:
+    default:
+    }
+    return nil
+}
+
+// Discard drops the buffered events of a reverted batch
+func (p *Publisher) Discard() {
+    p.mutex.Lock()
+    p.pending = nil
+    p.mutex.Unlock()
+}
+
+// Cursor returns the position of the last acknowledged event and the number of
+// events in the outbox waiting for the broker
+func (p *Publisher) Cursor() (Cursor, uint64) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    return p.cursor, p.next - 1 - p.cursor.Sequence
+}
+
+// run sends the outbox until the publisher is closed, retrying with a growing
+// delay while the broker fails
+func (p *Publisher) run() {
+    defer close(p.done)
+
+    backoff := time.Second
+    for {
+        sent, err := p.deliver()
+        wait := time.Duration(0)
+        select {
+        case <-p.stop:
+            return
+        default:
+        }
+        switch {
+        case err != nil:
+            logger.Warn("failed to publish events, retrying", "retryIn", backoff, "error", err)
+            if p.OnError != nil {
+                p.OnError(err)
+            }
+            wait, backoff = backoff, min(backoff*2, maxBackoff)
+        case sent == 0:
+            backoff = time.Second
+            wait = -1
+        default:
+            backoff = time.Second
+        }
+        if wait == 0 {
+            continue
+        }
+        var timer <-chan time.Time
+        if wait > 0 {
+            timer = time.After(wait)
+        }
+        select {
+        case <-p.stop:
+            return
+        case <-p.wake:
+        case <-timer:
+        }
+    }
+}
+
+// deliver sends the next events of the outbox and moves the cursor past them,
+// returning how many were sent. The outbox is emptied once all its events are
+// acknowledged.
+func (p *Publisher) deliver() (int, error) {
+    p.mutex.Lock()
+    events, end, err := p.read()
+    p.mutex.Unlock()
+    if err != nil || len(events) == 0 {
+        return 0, err
+    }
+
+    messages := make([]Message, len(events))
+    for i, event := range events {
+        value, _ := json.Marshal(event)
+        messages[i] = Message{
+            Topic: p.prefix + "." + event.Type,
+            ID:    strconv.FormatUint(event.Sequence, 10),
+            Key:   event.key(),
+            Value: value,
+        }
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        select {
+        case <-p.stop:
+            cancel()
+        case <-ctx.Done():
+        }
+    }()
+    err = p.broker.Send(ctx, messages)
+    cancel()
+    if err != nil {
+        return 0, err
+    }
+
+    last := events[len(events)-1]
+    cursor := Cursor{Sequence: last.Sequence, Block: last.Block, Time: time.Now().UTC()}
+    if err := writeCursor(p.path+".cursor", cursor); err != nil {
+        return 0, err
+    }
+
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    p.cursor, p.offset = cursor, end
+    size, err := p.outbox.Seek(0, io.SeekEnd)
+    if err == nil && size == end {
+        if err = p.outbox.Truncate(0); err == nil {
+            p.offset = 0
+            _, err = p.outbox.Seek(0, io.SeekStart)
+        }
+    }
+    return len(events), err
+}
+
+// read returns up to batchSize events from the offset of the outbox and the
+// offset after them
+func (p *Publisher) read() ([]Event, int64, error) {
+    reader := bufio.NewReader(io.NewSectionReader(p.outbox, p.offset, 1<<62))
+    var events []Event
+    end := p.offset
+    for len(events) < batchSize {
+        line, err := reader.ReadBytes('\n')
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, 0, err
+        }
+        var event Event
+        if err := json.Unmarshal(line, &event); err != nil {
+            return nil, 0, err
+        }
+        events = append(events, event)
+        end += int64(len(line))
+    }
+    return events, end, nil
+}
+
+// writeCursor replaces the cursor file through a rename, so a crash leaves the old
+// or the new cursor
+func writeCursor(path string, cursor Cursor) error {
+    data, _ := json.Marshal(cursor)
+    temp := path + ".tmp"
+    if err := os.WriteFile(temp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(temp, path)
+}
+
+// Close stops sending after the delivery in progress and closes the outbox and
+// the broker. Events not acknowledged yet are sent after the next Open.
+func (p *Publisher) Close() error {
+    close(p.stop)
+    <-p.done
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    err := p.outbox.Close()
+    if brokerErr := p.broker.Close(); err == nil {
+        err = brokerErr
+    }
+    return err
+}
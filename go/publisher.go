@@ -0,0 +1,68 @@
+package main
+
+import (
+    "encoding/hex"
+
+    "pwr-stateful-vida/config"
+    "pwr-stateful-vida/events"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/publish"
+    "pwr-stateful-vida/reporting"
+)
+
+// openPublisher connects to the configured broker and opens the outbox
+func openPublisher(cfg config.PublishConfig) (*publish.Publisher, error) {
+    var broker publish.Broker
+    if cfg.Broker == "nats" {
+        nats, err := publish.NewNATS(cfg.Addresses, cfg.Username, cfg.Password, cfg.TLS)
+        if err != nil {
+            return nil, err
+        }
+        broker = nats
+    } else {
+        broker = publish.NewKafka(cfg.Addresses, cfg.Username, cfg.Password, cfg.TLS)
+    }
+    publisher, err := publish.Open(cfg.OutboxPath, cfg.TopicPrefix, broker)
+    if err != nil {
+        broker.Close()
+        return nil, err
+    }
+    publisher.OnError = func(error) { metrics.PublishErrors.Inc() }
+    cursor, pending := publisher.Cursor()
+    logger.Info("publishing events", "broker", cfg.Broker, "sequence", cursor.Sequence, "pending", pending)
+    return publisher, nil
+}
+
+// publishRoot announces a root hash validation result to the subscribers of the
+// node and the broker
+func (a *App) publishRoot(event events.RootEvent) {
+    events.PublishRoot(event)
+    if a.publisher == nil {
+        return
+    }
+    validated := event.Validated
+    err := a.publisher.Send(publish.Event{
+        Type:      publish.TypeValidation,
+        Block:     event.BlockNumber,
+        RootHash:  hex.EncodeToString(event.RootHash),
+        Validated: &validated,
+    })
+    if err != nil {
+        syncLogger.Error("failed to write publish outbox", "block", event.BlockNumber, "error", err)
+        reporting.Report(err, reporting.Context{Module: "publish", Block: event.BlockNumber})
+    }
+}
+
+// commitEvents appends the events of a committed batch and its finalized block to
+// the outbox. Events are not part of the state, so a failure is reported without
+// stopping the node.
+func (a *App) commitEvents(block int64, rootHash []byte) {
+    if a.publisher == nil {
+        return
+    }
+    a.publisher.Add(publish.Event{Type: publish.TypeBlock, Block: block, RootHash: hex.EncodeToString(rootHash)})
+    if err := a.publisher.Commit(); err != nil {
+        syncLogger.Error("failed to write publish outbox", "block", block, "error", err)
+        reporting.Report(err, reporting.Context{Module: "publish", Block: block})
+    }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "runtime/debug"
+
+    "pwr-stateful-vida/alert"
+    "pwr-stateful-vida/health"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// applyTransactionSafely applies a transaction, isolating a panic so that it fails
+// only that transaction
+func (a *App) applyTransactionSafely(ctx context.Context, transaction rpc.VidaDataTransaction, payload parsedPayload, label string) (failure string) {
+    defer func() {
+        if value := recover(); value != nil {
+            failure = failurePanic
+            a.isolateFailedTransaction(ctx, transaction, label, value)
+        }
+    }()
+    return a.applyTransaction(ctx, transaction, payload)
+}
+
+// isolateFailedTransaction records a transaction that panicked and removes whatever it
+// wrote before panicking. The tree cannot delete keys, so the batch is reverted and
+// the transactions before it are applied again.
+func (a *App) isolateFailedTransaction(ctx context.Context, transaction rpc.VidaDataTransaction, label string, value interface{}) {
+    stack := string(debug.Stack())
+    syncLogger.ErrorContext(ctx, "transaction panicked, marking it failed",
+        "hash", transaction.Hash,
+        "block", transaction.BlockNumber,
+        "sender", transaction.Sender,
+        "data", transaction.Data,
+        "panic", fmt.Sprint(value),
+        "stack", stack,
+    )
+    reporting.Report(fmt.Errorf("transaction panicked: %v", value), reporting.Context{
+        Module:        "handler",
+        Block:         int64(transaction.BlockNumber),
+        TxHash:        transaction.Hash,
+        Action:        label,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": transaction.Sender, "data": transaction.Data, "stack": stack},
+    })
+    alerts.Raise(alert.Alert{
+        Kind:     alert.KindTransactionPanic,
+        Severity: alert.Warning,
+        Subject:  transaction.Hash,
+        Message:  fmt.Sprintf("transaction %s in block %d panicked: %v", transaction.Hash, transaction.BlockNumber, value),
+        Block:    int64(transaction.BlockNumber),
+    })
+
+    if a.auditLog != nil {
+        a.auditLog.Discard()
+    }
+    a.discardRecords()
+    a.syncNode().RebuildBatch(ctx, func(ctx context.Context, previous rpc.VidaDataTransaction) interface{} {
+        payload, label, failure := a.parsePayload(previous)
+        if failure != "" {
+            a.indexReceipt(previous, label, failure)
+            return nil
+        }
+        failure, value := a.replayTransaction(ctx, previous, payload)
+        if value == nil {
+            a.indexReceipt(previous, label, failure)
+        }
+        return value
+    })
+}
+
+// replayTransaction applies a transaction of the batch again, returning the value it
+// panicked with instead of isolating it, which would rebuild the batch once more
+func (a *App) replayTransaction(ctx context.Context, transaction rpc.VidaDataTransaction, payload parsedPayload) (failure string, panicked interface{}) {
+    defer func() {
+        if value := recover(); value != nil {
+            failure, panicked = failurePanic, value
+        }
+    }()
+    return a.applyTransaction(ctx, transaction, payload), nil
+}
+
+// discardPending drops what the app collected for a batch that is discarded
+func (a *App) discardPending() {
+    a.queuedTransfers = nil
+    a.archivedTransactions = nil
+    if a.auditLog != nil {
+        a.auditLog.Discard()
+    }
+    a.discardRecords()
+}
+
+// syncHalted reports whether syncing halted because the unflushed blocks could not
+// be reverted, see sdk.Node.Halted
+func (a *App) syncHalted() bool {
+    return a.node != nil && a.node.Halted()
+}
+
+// onHalt marks the node read-only and raises an alert when a revert fails, and
+// lifts the mark once one succeeds again
+func (a *App) onHalt(err error) {
+    if err == nil {
+        health.SetReadOnly(readOnly.Load())
+        return
+    }
+    health.SetReadOnly(true)
+    from, to := a.db.UnflushedBlocks()
+    alerts.Raise(alert.Alert{
+        Kind:     alert.KindRevertFailure,
+        Severity: alert.Critical,
+        Message:  fmt.Sprintf("failed to revert blocks %d to %d, syncing halted: %v", from, to, err),
+        Block:    to,
+    })
+}
+
+// onCheckpointPanic raises an alert for a checkpoint that panicked
+func onCheckpointPanic(blockNumber int, value interface{}) {
+    alerts.Raise(alert.Alert{
+        Kind:     alert.KindCheckpointPanic,
+        Severity: alert.Critical,
+        Message:  fmt.Sprintf("checkpoint at block %d panicked: %v", blockNumber, value),
+        Block:    int64(blockNumber),
+    })
+}
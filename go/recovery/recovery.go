@@ -0,0 +1,178 @@
+// Package recovery lets an account name guardians that can move control of it to
+// a new key when its own is lost. Once enough guardians approve a new controller
+// and the account's delay has passed without the account cancelling, the new
+// controller acts for the account and its old key no longer can. Guardians,
+// pending recoveries and controllers are part of the Merkle state.
+package recovery
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "sort"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// MaxGuardians is the most guardians an account can name
+const MaxGuardians = 10
+
+// Errors of rejected recovery actions
+var (
+    ErrInvalid     = errors.New("invalid recovery")
+    ErrNotGuardian = errors.New("sender is not a guardian")
+    ErrNotFound    = errors.New("no pending recovery")
+    ErrConflict    = errors.New("another recovery is pending")
+    ErrNotReady    = errors.New("recovery delay has not passed")
+)
+
+// Guardians are the guardians of an account, Threshold of which must approve a
+// recovery that then waits Delay blocks
+type Guardians struct {
+    Guardians []string `json:"guardians"`
+    Threshold int      `json:"threshold"`
+    Delay     int64    `json:"delay"`
+}
+
+// Request is a pending recovery of an account to a new controller
+type Request struct {
+    Account    string   `json:"account"`
+    Controller string   `json:"controller"`
+    Approvals  []string `json:"approvals"`
+    Block      int64    `json:"block"`
+    // ReadyAt is the first block the recovery can complete at, 0 until the
+    // threshold is reached
+    ReadyAt int64 `json:"readyAt,omitempty"`
+}
+
+// key returns the tree key of a record of an account
+func key(kind string, account []byte) []byte {
+    return []byte(dbservice.RecoveryPrefix + kind + "/" + hex.EncodeToString(account))
+}
+
+// GuardiansOf returns the guardians of an account
+func GuardiansOf(db *dbservice.DatabaseService, account []byte) (Guardians, error) {
+    var guardians Guardians
+    _, err := db.LoadJSON(key("guardians", account), &guardians)
+    return guardians, err
+}
+
+// PendingOf returns the pending recovery of an account, and false when there is none
+func PendingOf(db *dbservice.DatabaseService, account []byte) (Request, bool, error) {
+    var request Request
+    found, err := db.LoadJSON(key("request", account), &request)
+    return request, found, err
+}
+
+// Controller returns the address that controls an account, the account itself
+// unless a recovery moved it
+func Controller(db *dbservice.DatabaseService, account []byte) ([]byte, error) {
+    data, err := db.GetData(key("controller", account))
+    if err != nil || len(data) == 0 {
+        return account, err
+    }
+    return data, nil
+}
+
+// SetGuardians replaces the guardians of an account, which no guardian list removes,
+// and cancels any pending recovery
+func SetGuardians(db *dbservice.DatabaseService, account []byte, guardians [][]byte, threshold int, delay int64) error {
+    if len(guardians) > MaxGuardians {
+        return fmt.Errorf("%w: at most %d guardians", ErrInvalid, MaxGuardians)
+    }
+    if err := db.SaveJSON(key("request", account), nil); err != nil {
+        return err
+    }
+    if len(guardians) == 0 {
+        return db.SaveJSON(key("guardians", account), nil)
+    }
+    if threshold <= 0 || threshold > len(guardians) || delay <= 0 {
+        return fmt.Errorf("%w: the threshold must be 1 to the number of guardians and the delay positive", ErrInvalid)
+    }
+    set := Guardians{Threshold: threshold, Delay: delay}
+    seen := make(map[string]bool)
+    for _, guardian := range guardians {
+        guardianHex := hex.EncodeToString(guardian)
+        if seen[guardianHex] || guardianHex == hex.EncodeToString(account) {
+            return fmt.Errorf("%w: guardians must be distinct and not the account", ErrInvalid)
+        }
+        seen[guardianHex] = true
+        set.Guardians = append(set.Guardians, guardianHex)
+    }
+    sort.Strings(set.Guardians)
+    return db.SaveJSON(key("guardians", account), set)
+}
+
+// Approve records the approval of guardian for moving an account to controller at
+// block. A recovery to another controller that has not reached the threshold is
+// replaced. It returns the pending request.
+func Approve(db *dbservice.DatabaseService, guardian, account, controller []byte, block int64) (Request, error) {
+    guardians, err := GuardiansOf(db, account)
+    if err != nil {
+        return Request{}, err
+    }
+    guardianHex := hex.EncodeToString(guardian)
+    i := sort.SearchStrings(guardians.Guardians, guardianHex)
+    if i == len(guardians.Guardians) || guardians.Guardians[i] != guardianHex {
+        return Request{}, ErrNotGuardian
+    }
+    request, found, err := PendingOf(db, account)
+    if err != nil {
+        return request, err
+    }
+    controllerHex := hex.EncodeToString(controller)
+    if found && request.Controller != controllerHex {
+        if request.ReadyAt != 0 {
+            return request, ErrConflict
+        }
+        found = false
+    }
+    if !found {
+        request = Request{Account: hex.EncodeToString(account), Controller: controllerHex, Block: block}
+    }
+
+    i = sort.SearchStrings(request.Approvals, guardianHex)
+    if i == len(request.Approvals) || request.Approvals[i] != guardianHex {
+        request.Approvals = append(request.Approvals, "")
+        copy(request.Approvals[i+1:], request.Approvals[i:])
+        request.Approvals[i] = guardianHex
+    }
+    if request.ReadyAt == 0 && len(request.Approvals) >= guardians.Threshold {
+        request.ReadyAt = block + guardians.Delay
+    }
+    return request, db.SaveJSON(key("request", account), request)
+}
+
+// Cancel drops the pending recovery of an account
+func Cancel(db *dbservice.DatabaseService, account []byte) error {
+    if _, found, err := PendingOf(db, account); err != nil || !found {
+        if err != nil {
+            return err
+        }
+        return ErrNotFound
+    }
+    return db.SaveJSON(key("request", account), nil)
+}
+
+// Complete moves control of an account to the controller of its recovery once the
+// delay has passed at block, and returns the new controller
+func Complete(db *dbservice.DatabaseService, account []byte, block int64) ([]byte, error) {
+    request, found, err := PendingOf(db, account)
+    if err != nil {
+        return nil, err
+    }
+    if !found {
+        return nil, ErrNotFound
+    }
+    if request.ReadyAt == 0 || block < request.ReadyAt {
+        return nil, ErrNotReady
+    }
+    controller, _ := hex.DecodeString(request.Controller)
+    if err := db.SaveJSON(key("request", account), nil); err != nil {
+        return nil, err
+    }
+    if request.Controller == request.Account {
+        return controller, db.SaveJSON(key("controller", account), nil)
+    }
+    return controller, db.SetData(key("controller", account), controller)
+}
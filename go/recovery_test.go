@@ -0,0 +1,215 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/testkit"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// panickingTransfers makes the transfer handler panic on the attempts of a
+// transaction panics selects, counting every application of it from 1
+func panickingTransfers(t *testing.T, panics func(hash string, attempt int) bool) {
+    original := actionHandlers["transfer"]
+    attempts := make(map[string]int)
+    wrapped := *original
+    wrapped.apply = func(a *App, ctx context.Context, payload actionPayload, transaction rpc.VidaDataTransaction) string {
+        attempts[transaction.Hash]++
+        if panics(transaction.Hash, attempts[transaction.Hash]) {
+            panic("transfer handler failed")
+        }
+        return original.apply(a, ctx, payload, transaction)
+    }
+    actionHandlers["transfer"] = &wrapped
+    t.Cleanup(func() { actionHandlers["transfer"] = original })
+}
+
+func TestIsolateFailedTransaction(t *testing.T) {
+    sender, receiver := account(1), account(2)
+    first := testkit.Transfer(sender, receiver, big.NewInt(10))
+    second := testkit.Transfer(sender, receiver, big.NewInt(20))
+    third := testkit.Transfer(sender, receiver, big.NewInt(5))
+
+    tests := []struct {
+        name    string
+        panics  func(hash string, attempt int) bool
+        want    int64
+        rewound bool
+    }{
+        {
+            name:   "panic after two good transactions",
+            panics: func(hash string, attempt int) bool { return hash == third.Hash },
+            want:   30,
+        },
+        {
+            name: "panic while rebuilding the batch",
+            panics: func(hash string, attempt int) bool {
+                return hash == third.Hash && attempt == 1 || hash == first.Hash && attempt == 2
+            },
+            want:    35,
+            rewound: true,
+        },
+        {
+            name: "rebuilding keeps panicking",
+            panics: func(hash string, attempt int) bool {
+                return hash == third.Hash && attempt == 1 || hash == first.Hash && attempt >= 2
+            },
+            want:    25,
+            rewound: true,
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            peer := testkit.AgreeingPeer()
+            defer peer.Close()
+            node := newTestNode(t, []*testkit.Peer{peer}, map[string]int64{string(sender): 1000})
+            panickingTransfers(t, test.panics)
+            node.subscription.AddBlock(1, first, second, third)
+
+            node.subscription.Sync(5)
+            if got := balanceOf(t, receiver); got != test.want {
+                t.Errorf("receiver balance = %d, want %d", got, test.want)
+            }
+            if got := balanceOf(t, sender); got != 1000-test.want {
+                t.Errorf("sender balance = %d, want %d", got, 1000-test.want)
+            }
+            if got := checkpoint(t); got != 1 {
+                t.Errorf("checkpoint = %d, want 1", got)
+            }
+            if rewound := len(node.subscription.Rewinds) > 0; rewound != test.rewound {
+                t.Errorf("rewinds = %v, want a rewind: %v", node.subscription.Rewinds, test.rewound)
+            }
+            // The batch is no longer broken, so the next block is applied
+            node.subscription.AddBlock(2, testkit.Transfer(sender, receiver, big.NewInt(1)))
+            node.subscription.Sync(5)
+            if got := balanceOf(t, receiver); got != test.want+1 {
+                t.Errorf("receiver balance after the next block = %d, want %d", got, test.want+1)
+            }
+        })
+    }
+}
+
+// revertFailingTree is a memory tree whose reverts fail while failing is set
+type revertFailingTree struct {
+    *testkit.MemoryTree
+    failing bool
+}
+
+func (t *revertFailingTree) RevertUnsavedChanges() error {
+    if t.failing {
+        return errors.New("revert failed")
+    }
+    return t.MemoryTree.RevertUnsavedChanges()
+}
+
+func TestReprocessUnflushed(t *testing.T) {
+    sender, receiver := account(1), account(2)
+
+    tests := []struct {
+        name string
+        // disagreements is how many root hash queries the peer answers wrongly
+        disagreements int
+        revertFails   bool
+        wantHalted    bool
+        wantRewinds   int
+        wantFlushed   int64
+        wantBalance   int64
+    }{
+        {
+            name:          "peer agrees",
+            disagreements: 0,
+            wantFlushed:   1,
+            wantBalance:   10,
+        },
+        {
+            name:          "peer disagrees once",
+            disagreements: 1,
+            wantRewinds:   1,
+            wantFlushed:   1,
+            wantBalance:   10,
+        },
+        {
+            name:          "revert fails",
+            disagreements: 1,
+            revertFails:   true,
+            wantHalted:    true,
+            wantFlushed:   0,
+            wantBalance:   10,
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            queries := 0
+            peer := testkit.NewPeer(func(int) []byte {
+                queries++
+                if queries <= test.disagreements {
+                    return []byte("wrong root")
+                }
+                root, _ := dbservice.GetRootHash()
+                return root
+            })
+            defer peer.Close()
+            node := newTestNode(t, []*testkit.Peer{peer}, map[string]int64{string(sender): 1000})
+            tree := &revertFailingTree{MemoryTree: node.tree, failing: test.revertFails}
+            dbservice.UseTree(tree)
+
+            node.subscription.AddBlock(1, testkit.Transfer(sender, receiver, big.NewInt(10)))
+            node.subscription.Sync(5)
+
+            if app.node.Halted() != test.wantHalted {
+                t.Fatalf("halted = %v, want %v", app.node.Halted(), test.wantHalted)
+            }
+            if got := len(node.subscription.Rewinds); got != test.wantRewinds {
+                t.Errorf("rewinds = %d, want %d", got, test.wantRewinds)
+            }
+            if got := dbservice.FlushedBlock(); got != test.wantFlushed {
+                t.Errorf("flushed checkpoint = %d, want %d", got, test.wantFlushed)
+            }
+            if got := balanceOf(t, receiver); got != test.wantBalance {
+                t.Errorf("receiver balance = %d, want %d", got, test.wantBalance)
+            }
+            if !test.wantHalted {
+                return
+            }
+            // The checkpoint is not rewound and nothing more is flushed while halted
+            if got := checkpoint(t); got != 1 {
+                t.Errorf("checkpoint = %d, want 1", got)
+            }
+            if err := app.resumeSync(); err == nil {
+                t.Error("resumed while halted")
+            }
+            // Halting from the block saver leaves the subscription running, and the
+            // blocks it delivers meanwhile are skipped
+            node.subscription.AddBlock(2, testkit.Transfer(sender, receiver, big.NewInt(5)))
+            node.subscription.Sync(5)
+            if got := balanceOf(t, receiver); got != 10 {
+                t.Errorf("receiver balance while halted = %d, want 10", got)
+            }
+            if got := checkpoint(t); got != 1 {
+                t.Errorf("checkpoint while halted = %d, want 1", got)
+            }
+
+            // A revert through the admin API pauses the subscription without waiting
+            // forever, recovers, and the blocks are processed again
+            tree.failing = false
+            if _, err := app.revertToCheckpoint(); err != nil {
+                t.Fatal(err)
+            }
+            node.subscription.Sync(5)
+            if app.node.Halted() {
+                t.Error("still halted after a successful revert")
+            }
+            if got := dbservice.FlushedBlock(); got != 2 {
+                t.Errorf("flushed checkpoint after recovery = %d, want 2", got)
+            }
+            if got := balanceOf(t, receiver); got != 15 {
+                t.Errorf("receiver balance after recovery = %d, want 15", got)
+            }
+        })
+    }
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/referral"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// failureReferrerExists rejects naming a referrer for an account that has one
+const failureReferrerExists = "referrer_exists"
+
+// referralParams returns the payout rules in force, those of the genesis until
+// governance changes them
+func (a *App) referralParams() (referral.Params, error) {
+    cfg := a.chainParams().Referral
+    return referral.CurrentParams(a.db, referral.Params{Rate: cfg.Rate, MaxPayout: cfg.MaxPayout, MinTransfer: cfg.MinTransfer})
+}
+
+// referralPayload is the payload of a referral
+type referralPayload struct {
+    envelope
+    Referrer string `json:"referrer"`
+
+    referrer []byte
+}
+
+func (p *referralPayload) validate(a *App) error {
+    if p.referrer = payloadAddress(p.Referrer); p.referrer == nil {
+        return invalidField("referrer", "must be an address")
+    }
+    return nil
+}
+
+// referralParamsPayload is the payload of a referralParams
+type referralParamsPayload struct {
+    envelope
+    Rate        number `json:"rate"`
+    MaxPayout   string `json:"maxPayout"`
+    MinTransfer string `json:"minTransfer"`
+
+    rate int64
+}
+
+func (p *referralParamsPayload) validate(a *App) error {
+    var ok bool
+    if p.rate, ok = payloadInt(p.Rate); !ok {
+        return invalidField("rate", "must be a non-negative integer")
+    }
+    return nil
+}
+
+// handleReferral names the "referrer" of the sender. It returns the reason the
+// action was rejected, or an empty string on success.
+func (a *App) handleReferral(ctx context.Context, p *referralPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if err := referral.SetReferrer(a.db, sender, p.referrer); err != nil {
+        return referralFailure(ctx, err, "referral", p, sender)
+    }
+    syncLogger.InfoContext(ctx, "referrer set", "referrer", hex.EncodeToString(p.referrer), "sender", senderHex)
+    return ""
+}
+
+// handleReferralParams changes the payout rules, sent by a governor. It returns the
+// reason the change was rejected, or an empty string on success.
+func (a *App) handleReferralParams(ctx context.Context, p *referralParamsPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if !a.isGovernor(senderHex) {
+        syncLogger.WarnContext(ctx, "referral params change from a non-governor", "sender", senderHex)
+        return failureUnauthorized
+    }
+    params := referral.Params{Rate: p.rate, MaxPayout: p.MaxPayout, MinTransfer: p.MinTransfer}
+    if err := referral.SetParams(a.db, params); err != nil {
+        return referralFailure(ctx, err, "referralParams", p, sender)
+    }
+    syncLogger.InfoContext(ctx, "referral params changed", "rate", p.rate, "maxPayout", p.MaxPayout, "minTransfer", p.MinTransfer, "sender", senderHex)
+    return ""
+}
+
+// referralFailure maps an error of the referral package to the reason a
+// transaction is rejected
+func referralFailure(ctx context.Context, err error, action string, payload actionPayload, sender []byte) string {
+    switch {
+    case errors.Is(err, referral.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid "+action, "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, referral.ErrExists):
+        return failureReferrerExists
+    }
+    reportReferralError(ctx, err, action, sender)
+    return failureInvalidPayload
+}
+
+// payReferral pays the referrer of the sender of an applied native transfer its
+// share. A failed payout is reported without failing the transfer.
+func (a *App) payReferral(ctx context.Context, sender, receiver []byte, token string, amount *big.Int) {
+    if !tokens.IsNative(token) {
+        return
+    }
+    params, err := a.referralParams()
+    if err != nil {
+        reportReferralError(ctx, err, "transfer", sender)
+        return
+    }
+    referrer, paid, err := referral.Pay(a.db, sender, receiver, amount, params)
+    if err != nil {
+        reportReferralError(ctx, err, "transfer", sender)
+        return
+    }
+    if paid.Sign() > 0 {
+        syncLogger.InfoContext(ctx, "referral paid", "referrer", hex.EncodeToString(referrer), "amount", paid, "referee", hex.EncodeToString(sender))
+    }
+}
+
+// reportReferralError reports an unexpected error of the referral module
+func reportReferralError(ctx context.Context, err error, action string, account []byte) {
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"account": hex.EncodeToString(account)},
+    })
+}
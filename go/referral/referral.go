@@ -0,0 +1,185 @@
+// Package referral records who referred each account and pays referrers a share
+// of the native transfers of the accounts they referred. Payouts come out of the
+// referral pool, which anyone funds by transferring to it, and stop when it runs
+// dry. The rate, the cap on each payout and the smallest qualifying transfer are
+// governance parameters. Referrers, their earnings and the params are part of the
+// Merkle state.
+package referral
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// BasisPoints is the denominator of the referral rate
+const BasisPoints = 10000
+
+// Errors of rejected referral actions
+var (
+    ErrInvalid = errors.New("invalid referral")
+    ErrExists  = errors.New("referrer already set")
+)
+
+var (
+    // PoolAddress is the account referral payouts are paid from
+    PoolAddress = dbservice.ModuleAddress("referral")
+
+    paramsKey = []byte(dbservice.ReferralPrefix + "params")
+)
+
+// Params decide the payout of a qualifying transfer: Rate basis points of the
+// amount, at most MaxPayout, for transfers of at least MinTransfer
+type Params struct {
+    Rate        int64  `json:"rate"`
+    MaxPayout   string `json:"maxPayout"`
+    MinTransfer string `json:"minTransfer"`
+}
+
+// Earnings are the referees of a referrer and what it was paid for them
+type Earnings struct {
+    Referees int64  `json:"referees"`
+    Earned   string `json:"earned"`
+}
+
+// referrerKey returns the tree key of the referrer of an account
+func referrerKey(referee []byte) []byte {
+    return []byte(dbservice.ReferralPrefix + "referrer/" + hex.EncodeToString(referee))
+}
+
+// earningsKey returns the tree key of the earnings of a referrer
+func earningsKey(referrer []byte) []byte {
+    return []byte(dbservice.ReferralPrefix + "earnings/" + hex.EncodeToString(referrer))
+}
+
+// Validate reports whether the params can be put in force
+func (p Params) Validate() error {
+    if p.Rate < 0 || p.Rate > BasisPoints {
+        return fmt.Errorf("%w: the rate must be 0 to %d basis points", ErrInvalid, BasisPoints)
+    }
+    for _, value := range []string{p.MaxPayout, p.MinTransfer} {
+        if parsed, ok := new(big.Int).SetString(value, 10); !ok || parsed.Sign() < 0 {
+            return fmt.Errorf("%w: maxPayout and minTransfer must be non-negative integers", ErrInvalid)
+        }
+    }
+    return nil
+}
+
+// CurrentParams returns the params in force, which are defaults until params are
+// first set
+func CurrentParams(db *dbservice.DatabaseService, defaults Params) (Params, error) {
+    var params Params
+    found, err := db.LoadJSON(paramsKey, &params)
+    if err != nil || !found {
+        return defaults, err
+    }
+    return params, nil
+}
+
+// SetParams puts new params in force for later transfers
+func SetParams(db *dbservice.DatabaseService, params Params) error {
+    if err := params.Validate(); err != nil {
+        return err
+    }
+    params.MaxPayout, params.MinTransfer = dbservice.ParseAmount(params.MaxPayout).String(), dbservice.ParseAmount(params.MinTransfer).String()
+    return db.SaveJSON(paramsKey, params)
+}
+
+// Referrer returns the referrer of an account, or nil when it has none
+func Referrer(db *dbservice.DatabaseService, referee []byte) ([]byte, error) {
+    data, err := db.GetData(referrerKey(referee))
+    if err != nil || len(data) == 0 {
+        return nil, err
+    }
+    return data, nil
+}
+
+// EarningsOf returns the earnings of a referrer
+func EarningsOf(db *dbservice.DatabaseService, referrer []byte) (Earnings, error) {
+    earnings := Earnings{Earned: "0"}
+    _, err := db.LoadJSON(earningsKey(referrer), &earnings)
+    return earnings, err
+}
+
+// SetReferrer records referrer as the referrer of an account, which is set once
+// and cannot be the account itself or one it referred
+func SetReferrer(db *dbservice.DatabaseService, referee, referrer []byte) error {
+    if bytes.Equal(referee, referrer) {
+        return fmt.Errorf("%w: an account cannot refer itself", ErrInvalid)
+    }
+    current, err := Referrer(db, referee)
+    if err != nil {
+        return err
+    }
+    if current != nil {
+        return ErrExists
+    }
+    upstream, err := Referrer(db, referrer)
+    if err != nil {
+        return err
+    }
+    if bytes.Equal(upstream, referee) {
+        return fmt.Errorf("%w: the referrer was referred by the account", ErrInvalid)
+    }
+    earnings, err := EarningsOf(db, referrer)
+    if err != nil {
+        return err
+    }
+    earnings.Referees++
+    if err := db.SaveJSON(earningsKey(referrer), earnings); err != nil {
+        return err
+    }
+    return db.SetData(referrerKey(referee), referrer)
+}
+
+// Payout returns what the referrer of an account earns for a native transfer of
+// transferred under params, before the pool balance is taken into account
+func (p Params) Payout(transferred *big.Int) *big.Int {
+    if p.Rate == 0 || transferred.Cmp(dbservice.ParseAmount(p.MinTransfer)) < 0 {
+        return new(big.Int)
+    }
+    payout := new(big.Int).Mul(transferred, big.NewInt(p.Rate))
+    payout.Quo(payout, big.NewInt(BasisPoints))
+    if limit := dbservice.ParseAmount(p.MaxPayout); payout.Cmp(limit) > 0 {
+        payout = limit
+    }
+    return payout
+}
+
+// Pay pays the referrer of referee its share of a native transfer of transferred to
+// receiver out of the pool, as much of it as the pool holds. Transfers to the
+// referrer itself do not qualify. It returns the referrer and the amount paid, nil
+// and zero when the account has no referrer or nothing is due.
+func Pay(db *dbservice.DatabaseService, referee, receiver []byte, transferred *big.Int, params Params) ([]byte, *big.Int, error) {
+    payout := params.Payout(transferred)
+    if payout.Sign() == 0 {
+        return nil, payout, nil
+    }
+    referrer, err := Referrer(db, referee)
+    if err != nil || referrer == nil || bytes.Equal(referrer, receiver) {
+        return nil, new(big.Int), err
+    }
+    pool, err := db.GetBalance(PoolAddress)
+    if err != nil {
+        return nil, new(big.Int), err
+    }
+    if payout.Cmp(pool) > 0 {
+        payout = pool
+    }
+    if payout.Sign() == 0 {
+        return referrer, payout, nil
+    }
+    if _, err := db.Transfer(PoolAddress, referrer, payout); err != nil {
+        return nil, new(big.Int), err
+    }
+    earnings, err := EarningsOf(db, referrer)
+    if err != nil {
+        return nil, new(big.Int), err
+    }
+    earnings.Earned = dbservice.ParseAmount(earnings.Earned).Add(dbservice.ParseAmount(earnings.Earned), payout).String()
+    return referrer, payout, db.SaveJSON(earningsKey(referrer), earnings)
+}
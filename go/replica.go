@@ -0,0 +1,161 @@
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "time"
+
+    "pwr-stateful-vida/alert"
+    "pwr-stateful-vida/archive"
+    "pwr-stateful-vida/health"
+    "pwr-stateful-vida/metrics"
+)
+
+// errDiverged stops a replica whose state no longer matches the batches of the primary
+var errDiverged = errors.New("replica diverged from the primary")
+
+// startReplica follows primary with the replica settings of the configuration until
+// stopReplica is called
+func (a *App) startReplica(primary string) error {
+    cfg := a.config().Replica
+    interval, _ := time.ParseDuration(cfg.PollInterval)
+    client, err := newHTTPPeers(a.config().PeerTLS, a.config().Auth.PeerToken, time.Minute)
+    if err != nil {
+        return err
+    }
+    a.replicaStop = make(chan struct{})
+    a.replicaDone = make(chan struct{})
+    go a.followPrimary(client, primary, cfg.BatchRecords, interval)
+    return nil
+}
+
+// followPrimary applies the batches of the primary as they are archived. A batch
+// that does not continue the local state or does not reproduce the root the primary
+// validated is not applied, and the replica stops there, serving its last state.
+func (a *App) followPrimary(source httpPeers, primary string, limit int, interval time.Duration) {
+    defer close(a.replicaDone)
+    syncLogger.Info("following primary", "primary", primary)
+
+    for {
+        caughtUp := true
+        if !readOnly.Load() && !a.adminPaused.Load() {
+            checkpoint, _ := a.db.GetLastCheckedBlock()
+            records, err := source.ArchiveRecords(primary, checkpoint, limit)
+            if err != nil {
+                syncLogger.Warn("failed to fetch batches from the primary", "primary", primary, "block", checkpoint, "error", err)
+            }
+            for _, record := range records {
+                if err := a.applyReplicatedBatch(record); err != nil {
+                    syncLogger.Error("stopped following the primary", "primary", primary, "block", record.BlockNumber, "error", err)
+                    alerts.Raise(alert.Alert{
+                        Kind:     alert.KindRootMismatch,
+                        Severity: alert.Critical,
+                        Message:  fmt.Sprintf("replica stopped at block %d: %v", checkpoint, err),
+                        Block:    record.BlockNumber,
+                    })
+                    return
+                }
+                checkpoint = record.BlockNumber
+            }
+            caughtUp = len(records) < limit
+        }
+
+        wait := time.Duration(0)
+        if caughtUp {
+            wait = interval
+        }
+        select {
+        case <-a.replicaStop:
+            return
+        case <-time.After(wait):
+        }
+    }
+}
+
+// applyReplicatedBatch writes the changes of an archived batch, checking that every
+// key still holds the value the primary changed, and flushes them once the root
+// matches the one the primary validated. The root hash of the block is stored last,
+// as the primary did after validating it.
+func (a *App) applyReplicatedBatch(record archive.Record) error {
+    start := time.Now()
+    rootKey := a.db.BlockRootHashKey(record.BlockNumber)
+    var rootChange *archive.Change
+    for i, change := range record.Changes {
+        key, err := hex.DecodeString(change.Key)
+        if err != nil {
+            return fmt.Errorf("invalid key %q: %v", change.Key, err)
+        }
+        if bytes.Equal(key, rootKey) {
+            rootChange = &record.Changes[i]
+            continue
+        }
+        if err := a.replicateChange(key, change); err != nil {
+            a.db.RevertUnsavedChanges()
+            return err
+        }
+    }
+
+    root, err := a.db.GetRootHash()
+    if err != nil {
+        a.db.RevertUnsavedChanges()
+        return err
+    }
+    if hex.EncodeToString(root) != record.RootHash {
+        a.db.RevertUnsavedChanges()
+        return fmt.Errorf("%w: root hash %x, the primary validated %s", errDiverged, root, record.RootHash)
+    }
+    if rootChange != nil {
+        if err := a.replicateChange(rootKey, *rootChange); err != nil {
+            a.db.RevertUnsavedChanges()
+            return err
+        }
+    }
+    if checkpoint, _ := a.db.GetLastCheckedBlock(); checkpoint != record.BlockNumber {
+        a.db.RevertUnsavedChanges()
+        return fmt.Errorf("%w: batch of block %d leaves the checkpoint at %d", errDiverged, record.BlockNumber, checkpoint)
+    }
+
+    err = a.db.Flush()
+    health.RecordFlush(err)
+    if err != nil {
+        return err
+    }
+    metrics.BlocksProcessed.Inc()
+    metrics.CheckpointDuration.Observe(time.Since(start).Seconds())
+    health.RecordProgress()
+    if a.blockArchive != nil {
+        if err := a.blockArchive.Append(record); err != nil {
+            syncLogger.Error("failed to archive block", "block", record.BlockNumber, "error", err)
+        }
+    }
+    syncLogger.Info("checkpoint replicated", "block", record.BlockNumber, "changes", len(record.Changes))
+    return nil
+}
+
+// replicateChange writes the new value of a change once the key holds its old value
+func (a *App) replicateChange(key []byte, change archive.Change) error {
+    current, err := a.db.GetData(key)
+    if err != nil {
+        return err
+    }
+    if hex.EncodeToString(current) != change.Old {
+        return fmt.Errorf("%w: key %s holds %x, the primary changed it from %q", errDiverged, change.Key, current, change.Old)
+    }
+    value, err := hex.DecodeString(change.New)
+    if err != nil {
+        return fmt.Errorf("invalid value of key %s: %v", change.Key, err)
+    }
+    return a.db.SetData(key, value)
+}
+
+// stopReplica stops following the primary after the batch in progress
+func (a *App) stopReplica() {
+    if a.replicaStop == nil {
+        return
+    }
+    close(a.replicaStop)
+    <-a.replicaDone
+    a.replicaStop, a.replicaDone = nil, nil
+}
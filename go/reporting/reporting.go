@@ -0,0 +1,62 @@
+// Package reporting forwards unexpected errors, with the block and transaction they
+// occurred in, to an error tracking service.
+package reporting
+
+import (
+    "sync"
+)
+
+// Context describes where an error occurred. Empty fields are left out of reports.
+type Context struct {
+    // Module is the layer that hit the error, such as handler, sync or db
+    Module string
+    Block  int64
+    TxHash string
+    Action string
+    // CorrelationID is the ID of the request or transaction the error occurred in
+    CorrelationID string
+    // Extra holds any other details
+    Extra map[string]string
+}
+
+// ErrorReporter sends errors to an error tracking service
+type ErrorReporter interface {
+    Report(err error, context Context)
+    // Close sends pending reports before the process exits
+    Close()
+}
+
+var (
+    reporter ErrorReporter
+    mutex    sync.RWMutex
+)
+
+// SetReporter installs the reporter used by Report; nil disables reporting
+func SetReporter(r ErrorReporter) {
+    mutex.Lock()
+    reporter = r
+    mutex.Unlock()
+}
+
+// Report sends err to the installed reporter, if any
+func Report(err error, context Context) {
+    if err == nil {
+        return
+    }
+    mutex.RLock()
+    r := reporter
+    mutex.RUnlock()
+    if r != nil {
+        r.Report(err, context)
+    }
+}
+
+// Close flushes the installed reporter
+func Close() {
+    mutex.RLock()
+    r := reporter
+    mutex.RUnlock()
+    if r != nil {
+        r.Close()
+    }
+}
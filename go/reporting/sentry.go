@@ -0,0 +1,177 @@
+package reporting
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/logging"
+)
+
+// sentryQueueSize is the number of reports buffered before new ones are dropped
+const sentryQueueSize = 100
+
+var logger = logging.For("reporting")
+
+// Sentry sends errors to a Sentry compatible server using the store endpoint
+type Sentry struct {
+    endpoint    string
+    auth        string
+    environment string
+    release     string
+    serverName  string
+    client      *http.Client
+
+    queue  chan map[string]interface{}
+    done   sync.WaitGroup
+    mutex  sync.RWMutex
+    closed bool
+}
+
+// NewSentry parses a DSN of the form https://key@host/project and starts sending in the background
+func NewSentry(dsn, environment, release string) (*Sentry, error) {
+    parsed, err := url.Parse(dsn)
+    if err != nil {
+        return nil, fmt.Errorf("invalid DSN: %v", err)
+    }
+    project := strings.Trim(parsed.Path, "/")
+    if parsed.User == nil || parsed.User.Username() == "" || project == "" || parsed.Host == "" {
+        return nil, fmt.Errorf("invalid DSN %q, expected scheme://key@host/project", dsn)
+    }
+
+    // A project behind a path prefix keeps the prefix before /api/
+    prefix := ""
+    if i := strings.LastIndex(project, "/"); i >= 0 {
+        prefix, project = "/"+project[:i], project[i+1:]
+    }
+
+    serverName, _ := os.Hostname()
+    s := &Sentry{
+        endpoint:    fmt.Sprintf("%s://%s%s/api/%s/store/", parsed.Scheme, parsed.Host, prefix, project),
+        auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=pwr-stateful-vida/1.0, sentry_key=%s", parsed.User.Username()),
+        environment: environment,
+        release:     release,
+        serverName:  serverName,
+        client:      &http.Client{Timeout: 10 * time.Second},
+        queue:       make(chan map[string]interface{}, sentryQueueSize),
+    }
+    if secret, ok := parsed.User.Password(); ok {
+        s.auth += ", sentry_secret=" + secret
+    }
+
+    s.done.Add(1)
+    go s.send()
+    return s, nil
+}
+
+// eventID returns a random 32 character hex event identifier
+func eventID() string {
+    id := make([]byte, 16)
+    rand.Read(id)
+    return hex.EncodeToString(id)
+}
+
+// Report queues an event for err, dropping it if the queue is full
+func (s *Sentry) Report(err error, context Context) {
+    tags := map[string]string{}
+    if context.Module != "" {
+        tags["module"] = context.Module
+    }
+    if context.Block > 0 {
+        tags["block"] = strconv.FormatInt(context.Block, 10)
+    }
+    if context.TxHash != "" {
+        tags["tx_hash"] = context.TxHash
+    }
+    if context.Action != "" {
+        tags["action"] = context.Action
+    }
+    if context.CorrelationID != "" {
+        tags["correlation_id"] = context.CorrelationID
+    }
+
+    event := map[string]interface{}{
+        "event_id":    eventID(),
+        "timestamp":   time.Now().UTC().Format("2006-01-02T15:04:05"),
+        "level":       "error",
+        "platform":    "go",
+        "logger":      context.Module,
+        "server_name": s.serverName,
+        "message":     err.Error(),
+        "exception": map[string]interface{}{
+            "values": []map[string]string{{"type": fmt.Sprintf("%T", err), "value": err.Error()}},
+        },
+        "tags":  tags,
+        "extra": context.Extra,
+    }
+    if s.environment != "" {
+        event["environment"] = s.environment
+    }
+    if s.release != "" {
+        event["release"] = s.release
+    }
+
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    if s.closed {
+        return
+    }
+    select {
+    case s.queue <- event:
+    default:
+        logger.Warn("error report dropped, queue is full", "error", err)
+    }
+}
+
+// send posts queued events until the queue is closed
+func (s *Sentry) send() {
+    defer s.done.Done()
+    for event := range s.queue {
+        body, _ := json.Marshal(event)
+        request, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+        if err != nil {
+            continue
+        }
+        request.Header.Set("Content-Type", "application/json")
+        request.Header.Set("X-Sentry-Auth", s.auth)
+
+        resp, err := s.client.Do(request)
+        if err != nil {
+            logger.Warn("failed to send error report", "error", err)
+            continue
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            logger.Warn("error report rejected", "status", resp.StatusCode)
+        }
+    }
+}
+
+// Close sends the queued events, waiting at most five seconds
+func (s *Sentry) Close() {
+    s.mutex.Lock()
+    if !s.closed {
+        s.closed = true
+        close(s.queue)
+    }
+    s.mutex.Unlock()
+
+    finished := make(chan struct{})
+    go func() {
+        s.done.Wait()
+        close(finished)
+    }()
+    select {
+    case <-finished:
+    case <-time.After(5 * time.Second):
+    }
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/savings"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// failureInsufficientSavings rejects a withdrawal of more shares than are held
+const failureInsufficientSavings = "insufficient_savings"
+
+// savingsDepositPayload is the payload of a savingsDeposit
+type savingsDepositPayload struct {
+    envelope
+    Amount number `json:"amount"`
+
+    amount *big.Int
+}
+
+func (p *savingsDepositPayload) validate(a *App) error {
+    if p.amount = a.parseTokenAmount(p.Amount, tokens.Native); p.amount == nil {
+        return invalidAmount("amount", "must be a positive amount")
+    }
+    return nil
+}
+
+// savingsWithdrawPayload is the payload of a savingsWithdraw, which redeems the
+// given shares, or all of them with all set
+type savingsWithdrawPayload struct {
+    envelope
+    Shares number `json:"shares"`
+    All    bool   `json:"all"`
+
+    shares *big.Int
+}
+
+func (p *savingsWithdrawPayload) validate(a *App) error {
+    if p.All {
+        return nil
+    }
+    if p.shares = parsePositiveAmount(p.Shares); p.shares == nil {
+        return invalidAmount("shares", "must be a positive integer")
+    }
+    return nil
+}
+
+// savingsRatePayload is the payload of a savingsRate
+type savingsRatePayload struct {
+    envelope
+    Rate number `json:"rate"`
+
+    rate *big.Int
+}
+
+func (p *savingsRatePayload) validate(a *App) error {
+    var ok bool
+    if p.rate, ok = p.Rate.integer(); !ok {
+        return invalidField("rate", "must be an integer")
+    }
+    return nil
+}
+
+// handleSavingsDeposit deposits "amount" of the sender in the savings pool for
+// shares. It returns the reason the deposit was rejected, or an empty string on
+// success.
+func (a *App) handleSavingsDeposit(ctx context.Context, p *savingsDepositPayload, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    shares, err := savings.Deposit(a.db, sender, p.amount, int64(transaction.BlockNumber))
+    return savingsResult(ctx, err, "savingsDeposit", shares, p, transaction.Sender)
+}
+
+// handleSavingsWithdraw redeems shares of the sender. It returns the reason the
+// withdrawal was rejected, or an empty string on success.
+func (a *App) handleSavingsWithdraw(ctx context.Context, p *savingsWithdrawPayload, transaction rpc.VidaDataTransaction) string {
+    sender := payloadAddress(transaction.Sender)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    amount, err := savings.Withdraw(a.db, sender, p.shares, int64(transaction.BlockNumber))
+    return savingsResult(ctx, err, "savingsWithdraw", amount, p, transaction.Sender)
+}
+
+// handleSavingsRate sets the savings rate, sent by a governor. It returns the
+// reason the change was rejected, or an empty string on success.
+func (a *App) handleSavingsRate(ctx context.Context, p *savingsRatePayload, transaction rpc.VidaDataTransaction) string {
+    if payloadAddress(transaction.Sender) == nil {
+        return failureInvalidPayload
+    }
+    if !a.isGovernor(transaction.Sender) {
+        syncLogger.WarnContext(ctx, "savings rate change from a non-governor", "sender", transaction.Sender)
+        return failureUnauthorized
+    }
+    err := savings.SetRate(a.db, p.rate, int64(transaction.BlockNumber))
+    return savingsResult(ctx, err, "savingsRate", p.rate, p, transaction.Sender)
+}
+
+// savingsResult maps the result of a savings action to the reason it was rejected,
+// or an empty string on success
+func savingsResult(ctx context.Context, err error, action string, result *big.Int, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, savings.ErrInvalidRate):
+        syncLogger.WarnContext(ctx, "skipping savings rate above the maximum", "payload", payload)
+        return failureInvalidPayload
+    case errors.Is(err, savings.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, "savings action failed: insufficient funds", "action", action, "sender", senderHex)
+        return failureInsufficientFunds
+    case errors.Is(err, savings.ErrInsufficientShares):
+        syncLogger.InfoContext(ctx, "savings withdrawal failed: insufficient savings", "sender", senderHex)
+        return failureInsufficientSavings
+    case err != nil:
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        action,
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "savings action applied", "action", action, "amount", result, "sender", senderHex)
+    return ""
+}
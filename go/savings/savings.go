@@ -0,0 +1,233 @@
+// Package savings implements a pool that pays interest on native token deposits.
+// Depositors hold shares of the pool, whose value grows with an index compounded
+// every block at the rate governance sets. All amounts are integers and the index
+// is a fixed-point number with 18 decimals, rounded down at every step, so every
+// node computes the same balances to the unit. Interest is minted into the pool as
+// it accrues, as far as the monetary policy allows.
+package savings
+
+import (
+    "encoding/hex"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/monetary"
+)
+
+// Errors of rejected savings actions
+var (
+    ErrInvalidRate        = errors.New("rate above the maximum")
+    ErrInsufficientFunds  = errors.New("insufficient funds")
+    ErrInsufficientShares = errors.New("insufficient savings")
+)
+
+var (
+    // One is the fixed-point 1 of the index and rate
+    One = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+    // MaxRate caps the interest per block at 1%
+    MaxRate = new(big.Int).Exp(big.NewInt(10), big.NewInt(16), nil)
+
+    // PoolAddress is the account holding the deposits and accrued interest
+    PoolAddress = dbservice.ModuleAddress("savings")
+
+    stateKey = []byte(dbservice.SavingsPrefix + "state")
+)
+
+// State is the pool state after accrual up to LastBlock
+type State struct {
+    // Rate is the interest per block with 18 decimals
+    Rate string `json:"rate"`
+    // Index is the value of a share with 18 decimals
+    Index       string `json:"index"`
+    TotalShares string `json:"totalShares"`
+    LastBlock   int64  `json:"lastBlock"`
+}
+
+// sharesKey returns the tree key of the shares of an account
+func sharesKey(account []byte) []byte {
+    return []byte(dbservice.SavingsPrefix + "shares/" + hex.EncodeToString(account))
+}
+
+// load returns the pool state, with an index of one before the first deposit
+func load(db *dbservice.DatabaseService) (State, error) {
+    state := State{Rate: "0", Index: One.String(), TotalShares: "0"}
+    _, err := db.LoadJSON(stateKey, &state)
+    return state, err
+}
+
+// store writes the pool state
+func store(db *dbservice.DatabaseService, state State) error {
+    return db.SaveJSON(stateKey, state)
+}
+
+// pow returns x to the power n for fixed-point x, rounding down after every
+// multiplication
+func pow(x *big.Int, n int64) *big.Int {
+    result := new(big.Int).Set(One)
+    base := new(big.Int).Set(x)
+    for ; n > 0; n >>= 1 {
+        if n&1 == 1 {
+            result.Quo(result.Mul(result, base), One)
+        }
+        base.Quo(base.Mul(base, base), One)
+    }
+    return result
+}
+
+// value returns what shares are worth at index, rounded down
+func value(shares, index *big.Int) *big.Int {
+    worth := new(big.Int).Mul(shares, index)
+    return worth.Quo(worth, One)
+}
+
+// accrued returns the state compounded up to block without storing it, and the
+// interest to mint into the pool for it
+func accrued(state State, block int64) (State, *big.Int) {
+    if block <= state.LastBlock {
+        return state, new(big.Int)
+    }
+    index, rate, total := dbservice.ParseAmount(state.Index), dbservice.ParseAmount(state.Rate), dbservice.ParseAmount(state.TotalShares)
+    next := index
+    if rate.Sign() > 0 && total.Sign() > 0 {
+        next = new(big.Int).Mul(index, pow(new(big.Int).Add(One, rate), block-state.LastBlock))
+        next.Quo(next, One)
+    }
+    interest := new(big.Int).Sub(value(total, next), value(total, index))
+    state.Index, state.LastBlock = next.String(), block
+    return state, interest
+}
+
+// accrue compounds the pool up to block, minting the interest into the pool. When
+// the monetary policy does not allow the interest, the index stays where it was.
+func accrue(db *dbservice.DatabaseService, block int64) (State, error) {
+    state, err := load(db)
+    if err != nil {
+        return state, err
+    }
+    next, interest := accrued(state, block)
+    if interest.Sign() > 0 {
+        if err := monetary.Issue(db, interest, block); err != nil {
+            if !errors.Is(err, monetary.ErrOutsidePolicy) {
+                return state, err
+            }
+            state.LastBlock = block
+            return state, nil
+        }
+        balance, err := db.GetBalance(PoolAddress)
+        if err != nil {
+            return state, err
+        }
+        if err := db.SetBalance(PoolAddress, balance.Add(balance, interest)); err != nil {
+            return state, err
+        }
+    }
+    return next, nil
+}
+
+// SharesOf returns the shares an account holds
+func SharesOf(db *dbservice.DatabaseService, account []byte) (*big.Int, error) {
+    data, err := db.GetData(sharesKey(account))
+    if err != nil {
+        return nil, err
+    }
+    return new(big.Int).SetBytes(data), nil
+}
+
+// addShares adds delta, which may be negative, to the shares of an account and the
+// total of the pool
+func addShares(db *dbservice.DatabaseService, state *State, account []byte, delta *big.Int) error {
+    shares, err := SharesOf(db, account)
+    if err != nil {
+        return err
+    }
+    if err := db.SetData(sharesKey(account), shares.Add(shares, delta).Bytes()); err != nil {
+        return err
+    }
+    state.TotalShares = dbservice.ParseAmount(state.TotalShares).Add(dbservice.ParseAmount(state.TotalShares), delta).String()
+    return nil
+}
+
+// Deposit moves amount from account into the pool at block and returns the shares
+// credited, rounded down
+func Deposit(db *dbservice.DatabaseService, account []byte, deposit *big.Int, block int64) (*big.Int, error) {
+    state, err := accrue(db, block)
+    if err != nil {
+        return nil, err
+    }
+    shares := new(big.Int).Mul(deposit, One)
+    shares.Quo(shares, dbservice.ParseAmount(state.Index))
+    if shares.Sign() <= 0 {
+        return nil, ErrInsufficientFunds
+    }
+    ok, err := db.Transfer(account, PoolAddress, deposit)
+    if err != nil {
+        return nil, err
+    }
+    if !ok {
+        return nil, ErrInsufficientFunds
+    }
+    if err := addShares(db, &state, account, shares); err != nil {
+        return nil, err
+    }
+    return shares, store(db, state)
+}
+
+// Withdraw pays account the value of shares at block, or of all its shares for nil,
+// and returns the amount paid
+func Withdraw(db *dbservice.DatabaseService, account []byte, shares *big.Int, block int64) (*big.Int, error) {
+    state, err := accrue(db, block)
+    if err != nil {
+        return nil, err
+    }
+    held, err := SharesOf(db, account)
+    if err != nil {
+        return nil, err
+    }
+    if shares == nil {
+        shares = held
+    }
+    if shares.Sign() <= 0 || held.Cmp(shares) < 0 {
+        return nil, ErrInsufficientShares
+    }
+    paid := value(shares, dbservice.ParseAmount(state.Index))
+    ok, err := db.Transfer(PoolAddress, account, paid)
+    if err != nil {
+        return nil, err
+    }
+    if !ok {
+        return nil, ErrInsufficientFunds
+    }
+    if err := addShares(db, &state, account, new(big.Int).Neg(shares)); err != nil {
+        return nil, err
+    }
+    return paid, store(db, state)
+}
+
+// SetRate accrues the pool at the current rate up to block, then changes the rate
+func SetRate(db *dbservice.DatabaseService, rate *big.Int, block int64) error {
+    if rate.Sign() < 0 || rate.Cmp(MaxRate) > 0 {
+        return ErrInvalidRate
+    }
+    state, err := accrue(db, block)
+    if err != nil {
+        return err
+    }
+    state.Rate = rate.String()
+    return store(db, state)
+}
+
+// Preview returns the pool state as it would be accrued at block, without changing it
+func Preview(db *dbservice.DatabaseService, block int64) (State, error) {
+    state, err := load(db)
+    if err != nil {
+        return state, err
+    }
+    state, _ = accrued(state, block)
+    return state, nil
+}
+
+// Value returns what shares are worth in a previewed state
+func Value(state State, shares *big.Int) *big.Int {
+    return value(shares, dbservice.ParseAmount(state.Index))
+}
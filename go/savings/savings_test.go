@@ -0,0 +1,162 @@
+package savings
+
+import (
+    "bytes"
+    "errors"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/monetary"
+)
+
+// account returns the address of the nth test account
+func account(n byte) []byte {
+    return bytes.Repeat([]byte{n}, dbservice.AddressLength)
+}
+
+// openPool returns a database where each account holds its balance and the pool
+// pays rate from block 1
+func openPool(t *testing.T, balances map[byte]int64, rate int64) *dbservice.DatabaseService {
+    t.Helper()
+    db := dbservice.New("savings")
+    db.SetDir(t.TempDir())
+    t.Cleanup(func() { db.Close() })
+    for n, balance := range balances {
+        if err := db.SetBalance(account(n), big.NewInt(balance)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := SetRate(db, big.NewInt(rate), 1); err != nil {
+        t.Fatal(err)
+    }
+    return db
+}
+
+func TestDepositAndWithdraw(t *testing.T) {
+    tests := []struct {
+        name    string
+        balance int64
+        // rate is the interest per block with 18 decimals
+        rate      int64
+        supplyCap string
+        deposit   int64
+        // withdraw is the shares withdrawn, all of them when 0
+        withdraw   int64
+        block      int64
+        wantErr    error
+        wantPaid   int64
+        wantShares int64
+    }{
+        {name: "no interest", balance: 1000, deposit: 1000, block: 50, wantPaid: 1000},
+        {name: "one percent for two blocks", balance: 1000, rate: 1e16, deposit: 1000, block: 3, wantPaid: 1020},
+        {name: "half a percent for ten blocks", balance: 2500, rate: 5e15, deposit: 2000, block: 11, wantPaid: 2102},
+        {name: "part of the shares", balance: 800, rate: 1e16, deposit: 800, withdraw: 300, block: 2, wantPaid: 303, wantShares: 500},
+        {name: "withdrawn in the deposit block", balance: 1000, rate: 1e16, deposit: 1000, block: 1, wantPaid: 1000},
+        {name: "interest outside the monetary policy", balance: 1000, rate: 1e16, supplyCap: "0", deposit: 1000, block: 3, wantPaid: 1000},
+        {name: "more shares than held", balance: 1000, deposit: 1000, withdraw: 1001, block: 2, wantErr: ErrInsufficientShares, wantShares: 1000},
+        {name: "deposit above the balance", balance: 1000, deposit: 5000, block: 2, wantErr: ErrInsufficientFunds},
+        {name: "empty deposit", balance: 1000, deposit: 0, block: 2, wantErr: ErrInsufficientFunds},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := openPool(t, map[byte]int64{1: test.balance}, test.rate)
+            if test.supplyCap != "" {
+                if err := monetary.SetPolicy(db, monetary.Policy{SupplyCap: test.supplyCap}); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            shares, err := Deposit(db, account(1), big.NewInt(test.deposit), 1)
+            if err == nil {
+                var withdrawn *big.Int
+                if test.withdraw > 0 {
+                    withdrawn = big.NewInt(test.withdraw)
+                }
+                var paid *big.Int
+                paid, err = Withdraw(db, account(1), withdrawn, test.block)
+                if err == nil && paid.Int64() != test.wantPaid {
+                    t.Errorf("paid %s, want %d", paid, test.wantPaid)
+                }
+            } else if shares != nil {
+                t.Errorf("rejected deposit credited %s shares", shares)
+            }
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("error = %v, want %v", err, test.wantErr)
+            }
+
+            held, err := SharesOf(db, account(1))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if held.Int64() != test.wantShares {
+                t.Errorf("shares held = %s, want %d", held, test.wantShares)
+            }
+            balance, err := db.GetBalance(account(1))
+            if err != nil {
+                t.Fatal(err)
+            }
+            want := test.balance
+            if test.wantErr == nil {
+                want += test.wantPaid - test.deposit
+            } else if errors.Is(test.wantErr, ErrInsufficientShares) {
+                want -= test.deposit
+            }
+            if balance.Int64() != want {
+                t.Errorf("balance = %s, want %d", balance, want)
+            }
+        })
+    }
+}
+
+func TestLaterDepositsBuyAtTheIndex(t *testing.T) {
+    db := openPool(t, map[byte]int64{1: 1000, 2: 1010}, 1e16)
+    if _, err := Deposit(db, account(1), big.NewInt(1000), 1); err != nil {
+        t.Fatal(err)
+    }
+    // A share is worth 1.01 after one block
+    shares, err := Deposit(db, account(2), big.NewInt(1010), 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if shares.Int64() != 1000 {
+        t.Errorf("shares of the later deposit = %s, want 1000", shares)
+    }
+    state, err := Preview(db, 3)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for n := byte(1); n <= 2; n++ {
+        paid, err := Withdraw(db, account(n), nil, 3)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if paid.Int64() != 1020 {
+            t.Errorf("account %d paid %s, want 1020", n, paid)
+        }
+        if previewed := Value(state, big.NewInt(1000)); previewed.Cmp(paid) != 0 {
+            t.Errorf("previewed value %s, paid %s", previewed, paid)
+        }
+    }
+}
+
+func TestSetRateBounds(t *testing.T) {
+    tests := []struct {
+        name    string
+        rate    *big.Int
+        wantErr error
+    }{
+        {name: "zero", rate: big.NewInt(0)},
+        {name: "maximum", rate: MaxRate},
+        {name: "above the maximum", rate: new(big.Int).Add(MaxRate, big.NewInt(1)), wantErr: ErrInvalidRate},
+        {name: "negative", rate: big.NewInt(-1), wantErr: ErrInvalidRate},
+    }
+    for _, test := range tests {
+        db := dbservice.New("savings")
+        db.SetDir(t.TempDir())
+        if err := SetRate(db, test.rate, 5); !errors.Is(err, test.wantErr) {
+            t.Errorf("%s: SetRate error = %v, want %v", test.name, err, test.wantErr)
+        }
+        db.Close()
+    }
+}
@@ -0,0 +1,73 @@
+package sdk
+
+import (
+    "context"
+    "encoding/hex"
+
+    "pwr-stateful-vida/canonical"
+    "pwr-stateful-vida/dbservice"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// failureInsufficientFunds rejects a transfer the sender cannot pay
+const failureInsufficientFunds = "insufficient_funds"
+
+// canonicalMachine applies plain transfers by the rules of package canonical, the
+// machine of a Node configured with Canonical
+type canonicalMachine struct {
+    node *Node
+}
+
+func (m *canonicalMachine) BeginBlock(ctx context.Context, block int64) {}
+
+func (m *canonicalMachine) ApplyTransaction(ctx context.Context, transaction rpc.VidaDataTransaction) Outcome {
+    data, err := hex.DecodeString(transaction.Data)
+    if err != nil {
+        return Outcome{Label: "other", Failure: failureInvalidPayload}
+    }
+    transfer, isTransfer, ok := canonical.ParseTransfer(data)
+    if !isTransfer {
+        return Outcome{Label: "other", Failure: failureUnsupportedAction}
+    }
+    sender, validSender := canonical.ParseSender(transaction.Sender)
+    if !ok || !validSender {
+        logger.WarnContext(ctx, "skipping invalid transfer", "hash", transaction.Hash)
+        return Outcome{Label: "transfer", Failure: failureInvalidPayload}
+    }
+
+    success, err := m.node.db.Transfer(sender, transfer.Receiver, transfer.Amount)
+    if err != nil {
+        logger.ErrorContext(ctx, "transfer failed", "hash", transaction.Hash, "error", err)
+    }
+    if !success {
+        return Outcome{Label: "transfer", Failure: failureInsufficientFunds}
+    }
+    return Outcome{Label: "transfer"}
+}
+
+// EndBlock completes the block, so API reads may observe it
+func (m *canonicalMachine) EndBlock(ctx context.Context, block int64) {
+    if err := m.node.db.EndBlockContext(ctx, block); err != nil {
+        logger.ErrorContext(ctx, "failed to complete block", "block", block, "error", err)
+    }
+}
+
+func (m *canonicalMachine) RootHash() ([]byte, error) {
+    return m.node.db.GetRootHash()
+}
+
+// ApplyCanonicalGenesis writes the genesis accounts of package canonical in their
+// listed order to a database without a checkpoint and flushes them
+func ApplyCanonicalGenesis(db *dbservice.DatabaseService) error {
+    if lastBlock, err := db.GetLastCheckedBlock(); err != nil || lastBlock > 0 {
+        return err
+    }
+    for _, addressHex := range canonical.Genesis {
+        address, _ := hex.DecodeString(addressHex)
+        if err := db.SetBalance(address, canonical.GenesisBalance); err != nil {
+            return err
+        }
+    }
+    return db.Flush()
+}
@@ -0,0 +1,145 @@
+package sdk
+
+import (
+    "context"
+    "encoding/hex"
+    "fmt"
+    "strings"
+
+    "pwr-stateful-vida/logging"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Machine is the application a Node syncs. The node hands it the transactions of the
+// VIDA in order, bracketed by the blocks they are in, and at each checkpoint compares
+// its root hash with peers, reverting the database when they disagree. Its state
+// lives in the database of the node, so reverts and flushes cover it.
+type Machine interface {
+    // BeginBlock is called with the first transaction of a block, before it is
+    // applied. It may be called again for a block after a checkpoint or a panic.
+    BeginBlock(ctx context.Context, block int64)
+    // ApplyTransaction applies a transaction. A transaction that panicked is
+    // reported with FailurePanic once its changes are undone, see RebuildBatch, and
+    // is left out of the batch.
+    ApplyTransaction(ctx context.Context, transaction rpc.VidaDataTransaction) Outcome
+    // EndBlock completes a block once the first transaction of the next one arrives
+    // or a checkpoint follows it
+    EndBlock(ctx context.Context, block int64)
+    // RootHash returns the root hash of the state the transactions led to
+    RootHash() ([]byte, error)
+}
+
+// Outcome is what applying a transaction came to
+type Outcome struct {
+    // Label is the metric label of the action of the transaction
+    Label string
+    // Failure is the reason the transaction was rejected, empty when it was applied
+    Failure string
+    // Deferred reports a transaction the machine applies later in its block, which
+    // records the outcome then
+    Deferred bool
+}
+
+// Reasons the handler machine rejects a transaction. FailurePanic is also the
+// failure of a transaction that panicked in any machine.
+const (
+    FailurePanic             = "panic"
+    failureInvalidPayload    = "invalid_payload"
+    failureUnsupportedAction = "unsupported_action"
+    failureRejected          = "rejected"
+)
+
+// handlerMachine passes each transaction to the handler of its action, the machine
+// of a Node configured with Handlers
+type handlerMachine struct {
+    node     *Node
+    handlers map[string]Handler
+}
+
+func (m *handlerMachine) BeginBlock(ctx context.Context, block int64) {}
+
+func (m *handlerMachine) ApplyTransaction(ctx context.Context, transaction rpc.VidaDataTransaction) Outcome {
+    decoded, failure := m.decode(ctx, transaction)
+    if failure != "" {
+        return Outcome{Label: "other", Failure: failure}
+    }
+    if value := m.apply(ctx, decoded); value != nil {
+        logger.ErrorContext(ctx, "handler panicked", "hash", transaction.Hash, "action", decoded.Action, "panic", fmt.Sprint(value))
+        m.node.RebuildBatch(ctx, func(ctx context.Context, previous rpc.VidaDataTransaction) interface{} {
+            previousDecoded, failure := m.decode(ctx, previous)
+            if failure != "" {
+                return nil
+            }
+            return m.apply(ctx, previousDecoded)
+        })
+        return Outcome{Label: decoded.Action, Failure: FailurePanic}
+    }
+    return Outcome{Label: decoded.Action, Failure: decoded.failure}
+}
+
+// EndBlock completes the block, so API reads may observe it
+func (m *handlerMachine) EndBlock(ctx context.Context, block int64) {
+    if err := m.node.db.EndBlockContext(ctx, block); err != nil {
+        logger.ErrorContext(ctx, "failed to complete block", "block", block, "error", err)
+    }
+}
+
+func (m *handlerMachine) RootHash() ([]byte, error) {
+    return m.node.db.GetRootHash()
+}
+
+// decodedTransaction is a transaction with a handler for its action, and the reason
+// the handler rejected it once applied
+type decodedTransaction struct {
+    Transaction
+    handler Handler
+    failure string
+}
+
+// decode reads the payload of a transaction and looks up the handler of its action
+func (m *handlerMachine) decode(ctx context.Context, transaction rpc.VidaDataTransaction) (*decodedTransaction, string) {
+    data, _ := hex.DecodeString(transaction.Data)
+    payload, err := DecodePayload(data)
+    if err != nil {
+        logger.WarnContext(ctx, "skipping transaction without a JSON payload", "hash", transaction.Hash)
+        return nil, failureInvalidPayload
+    }
+    action, _ := payload["action"].(string)
+    action = strings.ToLower(action)
+    handler, ok := m.handlers[action]
+    if !ok {
+        logger.WarnContext(ctx, "skipping unsupported action", "hash", transaction.Hash, "action", action)
+        return nil, failureUnsupportedAction
+    }
+    sender, _ := hex.DecodeString(strings.TrimPrefix(strings.ToLower(transaction.Sender), "0x"))
+    return &decodedTransaction{
+        Transaction: Transaction{
+            Hash:    transaction.Hash,
+            Sender:  sender,
+            Block:   int64(transaction.BlockNumber),
+            Action:  action,
+            Payload: payload,
+            State:   m.node.db,
+        },
+        handler: handler,
+    }, ""
+}
+
+// apply runs the handler of a transaction, returning the value it panicked with
+func (m *handlerMachine) apply(ctx context.Context, transaction *decodedTransaction) (panicked interface{}) {
+    defer func() {
+        panicked = recover()
+    }()
+    if err := transaction.handler(transaction.Transaction); err != nil {
+        logger.InfoContext(ctx, "transaction rejected", "hash", transaction.Hash, "action", transaction.Action, "error", err)
+        transaction.failure = failureRejected
+    }
+    return nil
+}
+
+// transactionContext returns the context a transaction is processed in, which
+// correlates its logs and error reports by the transaction hash
+func transactionContext(transaction rpc.VidaDataTransaction) context.Context {
+    return logging.WithCorrelationID(context.Background(), transaction.Hash)
+}
@@ -0,0 +1,65 @@
+package sdk
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io"
+    "math/big"
+    "strings"
+)
+
+// DecodePayload decodes the JSON object of transaction data. Numbers are kept as
+// json.Number rather than float64, which would round large values and read 1.5 and
+// 1e3 as numbers a handler might truncate; read them with Integer. Like
+// json.Unmarshal it rejects anything after the object.
+func DecodePayload(data []byte) (map[string]interface{}, error) {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    var payload map[string]interface{}
+    if err := decoder.Decode(&payload); err != nil {
+        return nil, err
+    }
+    if _, err := decoder.Token(); err != io.EOF {
+        return nil, errors.New("invalid character after top-level value")
+    }
+    return payload, nil
+}
+
+// Integer reads an integer of a payload given as a decimal string or a JSON number:
+// an optional sign followed by decimal digits. A number with a fraction or an
+// exponent, even 1.0 or 1e3, is not an integer, so every node reads every payload
+// to the same value or rejects it.
+func Integer(raw interface{}) (*big.Int, bool) {
+    var text string
+    switch v := raw.(type) {
+    case string:
+        text = v
+    case json.Number:
+        text = v.String()
+    default:
+        return nil, false
+    }
+    return new(big.Int).SetString(text, 10)
+}
+
+// DecimalAmount converts an amount in whole tokens, digits with a decimal point and
+// at least one digit on each side such as "12.5", to base units of a token with
+// decimals. More fraction digits than the token has are rejected rather than rounded.
+func DecimalAmount(text string, decimals int) (*big.Int, bool) {
+    whole, fraction, found := strings.Cut(text, ".")
+    if !found || whole == "" || fraction == "" || len(fraction) > decimals || !isDigits(whole) || !isDigits(fraction) {
+        return nil, false
+    }
+    return new(big.Int).SetString(whole+fraction+strings.Repeat("0", decimals-len(fraction)), 10)
+}
+
+// isDigits reports whether text is made of decimal digits only
+func isDigits(text string) bool {
+    for i := 0; i < len(text); i++ {
+        if text[i] < '0' || text[i] > '9' {
+            return false
+        }
+    }
+    return true
+}
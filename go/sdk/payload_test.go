@@ -0,0 +1,82 @@
+package sdk
+
+import (
+    "encoding/json"
+    "testing"
+)
+
+func TestInteger(t *testing.T) {
+    tests := []struct {
+        name string
+        raw  interface{}
+        want string
+        ok   bool
+    }{
+        {name: "decimal string", raw: "1500", want: "1500", ok: true},
+        {name: "JSON number", raw: json.Number("1500"), want: "1500", ok: true},
+        {name: "beyond 64 bits", raw: "123456789012345678901234567890", want: "123456789012345678901234567890", ok: true},
+        {name: "negative", raw: "-7", want: "-7", ok: true},
+        {name: "empty", raw: ""},
+        {name: "fraction", raw: json.Number("1.0")},
+        {name: "exponent", raw: json.Number("1e3")},
+        {name: "hex", raw: "0x10"},
+        {name: "spaces", raw: " 15"},
+        {name: "float64", raw: float64(15)},
+        {name: "missing", raw: nil},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, ok := Integer(test.raw)
+            if ok != test.ok || (ok && got.String() != test.want) {
+                t.Errorf("Integer(%#v) = %v, %v, want %s, %v", test.raw, got, ok, test.want, test.ok)
+            }
+        })
+    }
+}
+
+func TestDecimalAmount(t *testing.T) {
+    tests := []struct {
+        text     string
+        decimals int
+        want     string
+        ok       bool
+    }{
+        {text: "12.5", decimals: 9, want: "12500000000", ok: true},
+        {text: "0.000000001", decimals: 9, want: "1", ok: true},
+        {text: "0.0000000001", decimals: 9},
+        {text: "12", decimals: 9},
+        {text: ".5", decimals: 9},
+        {text: "5.", decimals: 9},
+        {text: "-1.5", decimals: 9},
+        {text: "1.5e3", decimals: 9},
+    }
+    for _, test := range tests {
+        got, ok := DecimalAmount(test.text, test.decimals)
+        if ok != test.ok || (ok && got.String() != test.want) {
+            t.Errorf("DecimalAmount(%q, %d) = %v, %v, want %s, %v", test.text, test.decimals, got, ok, test.want, test.ok)
+        }
+    }
+}
+
+func TestDecodePayloadKeepsNumbersExact(t *testing.T) {
+    tests := []struct {
+        data    string
+        want    json.Number
+        wantErr bool
+    }{
+        {data: `{"amount": 9007199254740993}`, want: "9007199254740993"},
+        {data: `{"amount": 1e3}`, want: "1e3"},
+        {data: `{"amount": 1} {}`, wantErr: true},
+        {data: `[1]`, wantErr: true},
+    }
+    for _, test := range tests {
+        payload, err := DecodePayload([]byte(test.data))
+        if (err != nil) != test.wantErr {
+            t.Errorf("DecodePayload(%s) error = %v, want error %v", test.data, err, test.wantErr)
+            continue
+        }
+        if err == nil && payload["amount"] != test.want {
+            t.Errorf("DecodePayload(%s) amount = %#v, want %s", test.data, payload["amount"], test.want)
+        }
+    }
+}
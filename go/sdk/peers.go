@@ -0,0 +1,88 @@
+package sdk
+
+import (
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// PeerClient fetches the root hash a peer computed for a block. It reports false
+// when the peer could not be asked, and a nil root hash when it has none.
+type PeerClient interface {
+    RootHash(peer string, blockNumber int) (bool, []byte)
+}
+
+// HTTPPeers fetches root hashes from the /rootHash endpoint of peers
+type HTTPPeers struct {
+    Client *http.Client
+    // Scheme is "http" or "https"
+    Scheme string
+    // Token is sent as a bearer token when set
+    Token string
+}
+
+// NewHTTPPeers returns a peer client over plain HTTP
+func NewHTTPPeers() HTTPPeers {
+    return HTTPPeers{Client: &http.Client{Timeout: 10 * time.Second}, Scheme: "http"}
+}
+
+// RootHash fetches the root hash of a peer for the specified block number
+func (p HTTPPeers) RootHash(peer string, blockNumber int) (bool, []byte) {
+    request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s/rootHash?blockNumber=%d", p.Scheme, peer, blockNumber), nil)
+    if err != nil {
+        return false, nil
+    }
+    if p.Token != "" {
+        request.Header.Set("Authorization", "Bearer "+p.Token)
+    }
+    resp, err := p.Client.Do(request)
+    if err != nil {
+        logger.Warn("failed to fetch root hash", "peer", peer, "block", blockNumber, "error", err)
+        return false, nil
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        logger.Warn("peer returned an error status", "peer", peer, "block", blockNumber, "status", resp.StatusCode)
+        return true, nil
+    }
+    body, _ := io.ReadAll(resp.Body)
+    rootHash, err := hex.DecodeString(strings.TrimSpace(string(body)))
+    if err != nil || len(rootHash) == 0 {
+        logger.Warn("invalid root hash from peer", "peer", peer, "block", blockNumber)
+        return false, nil
+    }
+    return true, rootHash
+}
+
+// Quorum asks peers in order for their root hash of a block until more than two
+// thirds of those that answered agree with local. Peers that cannot be asked leave
+// the count, so the quorum shrinks with them. observe, if set, is called with the
+// answer of each peer that returned a root hash. It returns the number of peers
+// that agreed and whether they reached the quorum.
+func Quorum(client PeerClient, peers []string, blockNumber int, local []byte, observe func(peer string, agreed bool)) (int, bool) {
+    peersCount := len(peers)
+    quorum := (peersCount*2)/3 + 1
+    matches := 0
+    for _, peer := range peers {
+        success, peerRoot := client.RootHash(peer, blockNumber)
+        if success && peerRoot != nil {
+            agreed := string(peerRoot) == string(local)
+            if agreed {
+                matches++
+            }
+            if observe != nil {
+                observe(peer, agreed)
+            }
+        } else {
+            peersCount--
+            quorum = (peersCount*2)/3 + 1
+        }
+        if matches >= quorum {
+            return matches, true
+        }
+    }
+    return matches, false
+}
@@ -0,0 +1,290 @@
+// Package sdk is a framework for building a stateful VIDA. The consumer supplies the
+// VIDA ID, the genesis balances and a handler per action; the node subscribes to
+// the transactions of the VIDA on an RPC node, passes each one to the handler of
+// its action, and at every checkpoint checks the resulting Merkle root with a
+// quorum of peers, committing the batch when they agree and reverting and
+// refetching it when they do not. It also serves the root hashes peers ask for,
+// and the consumer can add its own routes.
+//
+// Each Node keeps its state in its own database service, so several can run in one
+// process. The node binary of this repository runs on a Node too, with the token
+// ledger as its Machine and Hooks for its logs, indexes and alerts.
+package sdk
+
+import (
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "net"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync/atomic"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/logging"
+
+    "github.com/gin-gonic/gin"
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// logger is the log of the framework
+var logger = logging.For("sdk")
+
+// Subscription is a running subscription to the transactions of a VIDA
+type Subscription interface {
+    SetLatestCheckedBlock(blockNumber int)
+    Pause()
+    Resume()
+    Stop()
+}
+
+// RPCClient subscribes to the transactions of a VIDA from a block, passing them to
+// handler and each processed block to progress
+type RPCClient interface {
+    Subscribe(vidaID, fromBlock int, handler rpc.ProcessVidaTransactions, progress rpc.BlockSaver) Subscription
+}
+
+// RPCNode subscribes through a PWR Chain RPC node
+type RPCNode struct {
+    Client *rpc.RPC
+}
+
+// NewRPCNode returns the RPC client of the node at url
+func NewRPCNode(url string) RPCNode {
+    return RPCNode{Client: rpc.SetRpcNodeUrl(url)}
+}
+
+// Subscribe starts a subscription on the RPC node
+func (n RPCNode) Subscribe(vidaID, fromBlock int, handler rpc.ProcessVidaTransactions, progress rpc.BlockSaver) Subscription {
+    return n.Client.SubscribeToVidaTransactions(vidaID, fromBlock, handler, progress)
+}
+
+// Transaction is a VIDA transaction passed to a handler
+type Transaction struct {
+    Hash   string
+    Sender []byte
+    Block  int64
+    // Action is the lowercased "action" of the payload
+    Action string
+    // Payload is the decoded data, with numbers as json.Number (see Integer)
+    Payload map[string]interface{}
+    // State is the database of the node, which the handler reads and writes
+    State *dbservice.DatabaseService
+}
+
+// Handler applies a transaction to the state. A returned error rejects it, so a
+// handler checks everything before its first write.
+type Handler func(transaction Transaction) error
+
+// Config describes a stateful VIDA
+type Config struct {
+    VidaID int
+    // StartBlock is the first block synced by a fresh database
+    StartBlock int
+    // Genesis is the native balance of each hex address in a fresh database
+    Genesis map[string]*big.Int
+    // Handlers apply the transactions of each action, keyed by lowercased action
+    Handlers map[string]Handler
+    // Peers are the host:port of the nodes checkpoints are checked with
+    Peers []string
+    // HTTPAddress is the address the API listens on, none when empty
+    HTTPAddress string
+    // DB holds the state of the node, the process wide database service when nil
+    DB *dbservice.DatabaseService
+    // Canonical applies plain transfers by the rules of package canonical, from its
+    // genesis, instead of Handlers and Genesis. DB must be canonical too, see
+    // dbservice.SetCanonical.
+    Canonical bool
+    // Machine applies the transactions instead of Handlers when set
+    Machine Machine
+    // Hooks act on the steps of the sync loop
+    Hooks Hooks
+}
+
+// Node syncs a stateful VIDA. The subscription calls Process and Checkpoint from one
+// goroutine; other callers pause it first.
+type Node struct {
+    cfg     Config
+    db      *dbservice.DatabaseService
+    machine Machine
+    hooks   Hooks
+    rpc     RPCClient
+    peers   PeerClient
+    router  *gin.Engine
+
+    subscription Subscription
+    server       *http.Server
+    // batch holds the transactions applied since the last checkpoint, so the batch
+    // can be rebuilt without a transaction that panicked
+    batch []rpc.VidaDataTransaction
+    // broken is set when a panic left the batch in a state it cannot be rebuilt
+    // from, so the next checkpoint drops it
+    broken bool
+    // halted is set when the blocks after the flushed checkpoint could not be
+    // reverted, see Halted
+    halted atomic.Bool
+}
+
+// New returns a node syncing cfg from rpcClient and checking checkpoints with
+// peerClient
+func New(cfg Config, rpcClient RPCClient, peerClient PeerClient) *Node {
+    node := &Node{cfg: cfg, db: cfg.DB, machine: cfg.Machine, hooks: cfg.Hooks, rpc: rpcClient, peers: peerClient}
+    if node.db == nil {
+        node.db = dbservice.Default()
+    }
+    if node.machine == nil && cfg.Canonical {
+        node.machine = &canonicalMachine{node: node}
+    }
+    if node.machine == nil {
+        node.machine = &handlerMachine{node: node, handlers: cfg.Handlers}
+    }
+    return node
+}
+
+// DB returns the database the node keeps its state in
+func (n *Node) DB() *dbservice.DatabaseService {
+    return n.db
+}
+
+// Router returns the router of the API, to add the routes of the VIDA before Start
+func (n *Node) Router() *gin.Engine {
+    if n.router == nil {
+        n.router = gin.New()
+        RegisterRoutes(n.router, n.db)
+    }
+    return n.router
+}
+
+// Start applies the genesis to a fresh database, starts the API and subscribes to
+// the VIDA from the block after the last checkpoint
+func (n *Node) Start() error {
+    if n.cfg.Canonical {
+        if err := ApplyCanonicalGenesis(n.db); err != nil {
+            return err
+        }
+    } else if n.cfg.Genesis != nil {
+        if err := ApplyGenesis(n.db, n.cfg.Genesis); err != nil {
+            return err
+        }
+    }
+    if n.cfg.HTTPAddress != "" {
+        listener, err := net.Listen("tcp", n.cfg.HTTPAddress)
+        if err != nil {
+            return err
+        }
+        n.server = &http.Server{Handler: n.Router()}
+        go func() {
+            if err := n.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+                logger.Error("HTTP server stopped", "address", n.cfg.HTTPAddress, "error", err)
+            }
+        }()
+    }
+
+    fromBlock := n.cfg.StartBlock
+    if lastBlock, _ := n.db.GetLastCheckedBlock(); lastBlock > 0 {
+        fromBlock = int(lastBlock)
+    }
+    syncLogger.Info("starting synchronization", "vidaId", n.cfg.VidaID, "fromBlock", fromBlock)
+    n.subscription = n.rpc.Subscribe(n.cfg.VidaID, fromBlock, n.Process, n.Checkpoint)
+    return nil
+}
+
+// SetLatestCheckedBlock rewinds the subscription to fetch the blocks after blockNumber
+func (n *Node) SetLatestCheckedBlock(blockNumber int) {
+    if n.subscription != nil {
+        n.subscription.SetLatestCheckedBlock(blockNumber)
+    }
+}
+
+// Pause stops the subscription after the batch in progress
+func (n *Node) Pause() {
+    if n.subscription != nil {
+        n.subscription.Pause()
+    }
+}
+
+// Resume continues a paused subscription
+func (n *Node) Resume() {
+    if n.subscription != nil {
+        n.subscription.Resume()
+    }
+}
+
+// Stop ends the subscription after the batch in progress and shuts the API down
+func (n *Node) Stop() {
+    if n.subscription != nil {
+        n.subscription.Stop()
+        n.subscription = nil
+    }
+    if n.server != nil {
+        n.server.Close()
+        n.server = nil
+    }
+}
+
+// ApplyGenesis sets the balances of a database without a checkpoint, in address
+// order since insertion order determines the root hash, and flushes them so that
+// reverting the first batch keeps them
+func ApplyGenesis(db *dbservice.DatabaseService, balances map[string]*big.Int) error {
+    if lastBlock, err := db.GetLastCheckedBlock(); err != nil || lastBlock > 0 {
+        return err
+    }
+    addresses := make([]string, 0, len(balances))
+    for addressHex := range balances {
+        addresses = append(addresses, addressHex)
+    }
+    sort.Strings(addresses)
+    for _, addressHex := range addresses {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(addressHex), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            return errors.New("genesis: " + addressHex + " is not a 20 byte hex address")
+        }
+        if err := db.SetBalance(address, balances[addressHex]); err != nil {
+            return err
+        }
+    }
+    return db.Flush()
+}
+
+// RegisterRoutes adds the endpoints peers and clients of every stateful VIDA use:
+// the root hash of a block, the native balance of an address and the last
+// checkpoint, answered from db
+func RegisterRoutes(router gin.IRouter, db *dbservice.DatabaseService) {
+    router.GET("/rootHash", func(c *gin.Context) {
+        blockNumber, _ := strconv.ParseInt(c.Query("blockNumber"), 10, 64)
+        lastCheckedBlock, checkpointRoot, _ := db.CheckpointRootHash()
+        if blockNumber == lastCheckedBlock {
+            if checkpointRoot != nil {
+                c.String(http.StatusOK, hex.EncodeToString(checkpointRoot))
+                return
+            }
+        } else if blockNumber < lastCheckedBlock && blockNumber > 1 {
+            if rootHash, _ := db.GetBlockRootHash(blockNumber); rootHash != nil {
+                c.String(http.StatusOK, hex.EncodeToString(rootHash))
+                return
+            }
+        }
+        c.String(http.StatusBadRequest, "Invalid block number")
+    })
+
+    router.GET("/balance", func(c *gin.Context) {
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(c.Query("address")), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            c.String(http.StatusBadRequest, "Invalid address")
+            return
+        }
+        balance, blockNumber, err := db.CommittedBalanceBlockContext(c.Request.Context(), address)
+        if err != nil {
+            c.String(http.StatusInternalServerError, "Failed to read balance")
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"address": hex.EncodeToString(address), "balance": balance.String(), "blockNumber": blockNumber})
+    })
+
+    router.GET("/lastCheckedBlock", func(c *gin.Context) {
+        lastCheckedBlock, _ := db.GetLastCheckedBlock()
+        c.String(http.StatusOK, strconv.FormatInt(lastCheckedBlock, 10))
+    })
+}
@@ -0,0 +1,334 @@
+package sdk
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "runtime/debug"
+    "time"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/metrics"
+    "pwr-stateful-vida/reporting"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// syncLogger logs transaction processing and checkpoints, peerLogger root hash
+// validation, under the modules the node has always logged them as
+var (
+    syncLogger = logging.For("sync")
+    peerLogger = logging.For("peers")
+)
+
+// ErrHalted is returned by checkpoints while the node is halted
+var ErrHalted = errors.New("unflushed blocks could not be reverted, syncing is halted")
+
+// Hooks let the application of a Node act on the steps of the sync loop. Every hook
+// is optional and runs on the goroutine of the subscription.
+type Hooks struct {
+    // Skip reports whether a transaction not applied before is left out of the
+    // batch, for example because it belongs to the next one
+    Skip func(transaction rpc.VidaDataTransaction) bool
+    // Received is called before a transaction is applied
+    Received func(ctx context.Context, transaction rpc.VidaDataTransaction)
+    // Applied is called with the outcome of a transaction applied at start
+    Applied func(ctx context.Context, transaction rpc.VidaDataTransaction, outcome Outcome, start time.Time)
+    // Prepare returns the block a checkpoint reported for blockNumber commits,
+    // whether blocks after it were left for the next batch, so the subscription is
+    // rewound to it, and whether to checkpoint at all. It may Discard the batch
+    // instead.
+    Prepare func(blockNumber int) (block int, deferred, proceed bool)
+    // Validate reports whether a quorum agrees with the root hash of a checkpoint,
+    // saving it as the root hash of the block if so. A Node without one asks
+    // Config.Peers.
+    Validate func(blockNumber int, rootHash []byte) bool
+    // Checkpointed is called once a checkpoint was validated, before it is flushed,
+    // with whether its batch was kept or reverted
+    Checkpointed func(blockNumber int, rootHash []byte, kept bool)
+    // Flushed is called after the flush of a checkpoint, with its error
+    Flushed func(blockNumber int, rootHash []byte, kept bool, err error)
+    // Discarded is called when the batch is dropped before the changes after the
+    // flushed checkpoint are reverted
+    Discarded func()
+    // Halted is called with the error of a revert that halted the node, and with
+    // nil once a revert succeeds again
+    Halted func(err error)
+    // Panicked is called with the value a checkpoint panicked with
+    Panicked func(blockNumber int, value interface{})
+}
+
+// Process applies a transaction of the VIDA, the handler of the subscription.
+// Transactions applied in a flushed block are skipped, since a restarted
+// subscription begins at the checkpoint block.
+func (n *Node) Process(transaction rpc.VidaDataTransaction) {
+    if n.halted.Load() || n.broken || n.replayed(transaction) {
+        return
+    }
+    if n.hooks.Skip != nil && n.hooks.Skip(transaction) {
+        return
+    }
+    start := time.Now()
+    ctx := transactionContext(transaction)
+    if last := len(n.batch); last == 0 || n.batch[last-1].BlockNumber != transaction.BlockNumber {
+        if last > 0 {
+            n.machine.EndBlock(ctx, int64(n.batch[last-1].BlockNumber))
+        }
+        n.machine.BeginBlock(ctx, int64(transaction.BlockNumber))
+    }
+    if n.hooks.Received != nil {
+        n.hooks.Received(ctx, transaction)
+    }
+
+    outcome := n.machine.ApplyTransaction(ctx, transaction)
+    if outcome.Failure != FailurePanic {
+        n.batch = append(n.batch, transaction)
+    }
+    if n.hooks.Applied != nil {
+        n.hooks.Applied(ctx, transaction, outcome, start)
+    }
+}
+
+// replayed reports whether a transaction was already applied in a flushed block
+func (n *Node) replayed(transaction rpc.VidaDataTransaction) bool {
+    applied, err := n.db.WasApplied(int64(transaction.BlockNumber), transaction.Hash)
+    if err != nil {
+        syncLogger.Error("failed to check for a replayed transaction", "hash", transaction.Hash, "error", err)
+        reporting.Report(err, reporting.Context{Module: "sync", Block: int64(transaction.BlockNumber), TxHash: transaction.Hash, CorrelationID: transaction.Hash})
+        return false
+    }
+    if applied {
+        syncLogger.Info("skipping transaction applied before restart", "hash", transaction.Hash, "block", transaction.BlockNumber)
+        metrics.ReplayedTransactions.Inc()
+    }
+    return applied
+}
+
+// Batch returns the transactions applied since the last checkpoint
+func (n *Node) Batch() []rpc.VidaDataTransaction {
+    return n.batch
+}
+
+// Halted reports whether the node stopped syncing because the blocks after the
+// flushed checkpoint could not be reverted. The state then holds changes that were
+// never validated, so nothing is flushed until a revert succeeds, through Discard
+// or by restarting from the flushed files.
+func (n *Node) Halted() bool {
+    return n.halted.Load()
+}
+
+// RebuildBatch removes whatever a transaction that panicked wrote. The tree cannot
+// delete keys, so the batch is reverted and replay applies its transactions again,
+// returning the value one panicked with. When the revert fails or a transaction
+// panics again the batch is broken: the transactions of the poll in progress are
+// skipped and the next checkpoint drops it, so its blocks are fetched again.
+func (n *Node) RebuildBatch(ctx context.Context, replay func(ctx context.Context, transaction rpc.VidaDataTransaction) (panicked interface{})) {
+    if err := n.db.RevertUnsavedChangesContext(ctx); err != nil {
+        n.breakBatch(ctx, fmt.Errorf("failed to revert the batch: %v", err))
+        return
+    }
+    for _, previous := range n.batch {
+        previousCtx := transactionContext(previous)
+        if value := replay(previousCtx, previous); value != nil {
+            // These applied cleanly before, so a panic now means the state itself is broken
+            n.breakBatch(previousCtx, fmt.Errorf("transaction %s panicked while rebuilding the batch: %v", previous.Hash, value))
+            return
+        }
+    }
+}
+
+// breakBatch gives up on a batch that cannot be rebuilt
+func (n *Node) breakBatch(ctx context.Context, err error) {
+    syncLogger.ErrorContext(ctx, "batch cannot be rebuilt, dropping it", "error", err)
+    reporting.Report(err, reporting.Context{Module: "handler", CorrelationID: logging.CorrelationID(ctx)})
+    n.broken = true
+}
+
+// endBatchBlock ends the block of the last transaction of the batch, which a
+// checkpoint completes
+func (n *Node) endBatchBlock(ctx context.Context) {
+    if last := len(n.batch); last > 0 {
+        n.machine.EndBlock(ctx, int64(n.batch[last-1].BlockNumber))
+    }
+}
+
+// recordAppliedBatch marks the transactions of a kept batch as applied, to be
+// persisted with the checkpoint
+func (n *Node) recordAppliedBatch() {
+    for _, transaction := range n.batch {
+        n.db.RecordApplied(int64(transaction.BlockNumber), transaction.Hash)
+    }
+}
+
+// Checkpoint commits the batch up to blockNumber when a quorum of peers agrees with
+// its root hash, and otherwise reverts it and rewinds the subscription, the block
+// saver of the subscription
+func (n *Node) Checkpoint(blockNumber int) (err error) {
+    defer n.recoverCheckpoint(blockNumber, &err)
+    if n.halted.Load() {
+        return ErrHalted
+    }
+    if n.broken {
+        syncLogger.Error("dropping a batch that could not be rebuilt", "block", blockNumber)
+        n.broken = false
+        _, err := n.Discard()
+        return err
+    }
+    n.endBatchBlock(context.Background())
+    deferred := false
+    if n.hooks.Prepare != nil {
+        var proceed bool
+        if blockNumber, deferred, proceed = n.hooks.Prepare(blockNumber); !proceed {
+            return nil
+        }
+    }
+    start := time.Now()
+    metrics.BlocksProcessed.Inc()
+    n.db.SetLastCheckedBlock(blockNumber)
+    localRoot, _ := n.machine.RootHash()
+    kept := true
+    if localRoot == nil {
+        peerLogger.Info("no local root hash available", "block", blockNumber)
+    } else {
+        kept = n.validate(blockNumber, localRoot)
+    }
+    if !kept {
+        // The blocks after the flushed checkpoint are fetched again and reprocessed
+        metrics.Reverts.Inc()
+        if _, err := n.reprocess(); err != nil {
+            return ErrHalted
+        }
+    }
+    if deferred && kept {
+        // Rewind the subscription so the deferred blocks are fetched again
+        n.SetLatestCheckedBlock(blockNumber)
+    }
+    if kept {
+        n.recordAppliedBatch()
+    }
+    if n.hooks.Checkpointed != nil {
+        n.hooks.Checkpointed(blockNumber, localRoot, kept)
+    }
+    n.batch = nil
+    syncLogger.Info("checkpoint updated", "block", blockNumber)
+    flushErr := n.db.Flush()
+    if n.hooks.Flushed != nil {
+        n.hooks.Flushed(blockNumber, localRoot, kept, flushErr)
+    }
+    metrics.CheckpointDuration.Observe(time.Since(start).Seconds())
+    return nil
+}
+
+// validate reports whether the batch of a checkpoint is kept
+func (n *Node) validate(blockNumber int, localRoot []byte) bool {
+    if n.hooks.Validate != nil {
+        return n.hooks.Validate(blockNumber, localRoot)
+    }
+    matches, agreed := Quorum(n.peers, n.cfg.Peers, blockNumber, localRoot, nil)
+    if !agreed {
+        peerLogger.Warn("root hash mismatch, reverting", "block", blockNumber, "matches", matches, "peers", len(n.cfg.Peers))
+        return false
+    }
+    n.db.SetBlockRootHash(blockNumber, localRoot)
+    peerLogger.Info("root hash validated and saved", "block", blockNumber, "matches", matches)
+    return true
+}
+
+// Commit checkpoints blockNumber without asking peers, as when replaying blocks
+// that reached a quorum before, and returns its root hash
+func (n *Node) Commit(blockNumber int) ([]byte, error) {
+    n.endBatchBlock(context.Background())
+    n.db.SetLastCheckedBlock(blockNumber)
+    root, err := n.machine.RootHash()
+    if err != nil {
+        return nil, err
+    }
+    if root != nil {
+        n.db.SetBlockRootHash(blockNumber, root)
+    }
+    n.recordAppliedBatch()
+    n.batch = nil
+    if err := n.db.Flush(); err != nil {
+        return nil, err
+    }
+    return root, nil
+}
+
+// Discard reverts the changes after the last checkpoint and rewinds the
+// subscription to it, returning the checkpoint block. It fails, halting the node,
+// when the changes could not be reverted.
+func (n *Node) Discard() (int64, error) {
+    n.batch = nil
+    n.broken = false
+    if n.hooks.Discarded != nil {
+        n.hooks.Discarded()
+    }
+    return n.reprocess()
+}
+
+// reprocess reverts the changes of the blocks after the flushed checkpoint, the last
+// validated one, and rewinds the checkpoint and the subscription to it so those
+// blocks are requested from the RPC node again instead of being skipped. It returns
+// the flushed checkpoint. When the revert fails the checkpoint is left alone and the
+// node halts, since flushing would persist the blocks that were rejected.
+func (n *Node) reprocess() (int64, error) {
+    from, to := n.db.UnflushedBlocks()
+    flushed := from - 1
+    if err := n.db.RevertUnsavedChanges(); err != nil {
+        n.halt(from, to, err)
+        return flushed, fmt.Errorf("revert blocks %d to %d: %w", from, to, err)
+    }
+    if n.halted.Swap(false) {
+        syncLogger.Warn("unflushed blocks reverted, no longer halted", "block", flushed)
+        if n.hooks.Halted != nil {
+            n.hooks.Halted(nil)
+        }
+    }
+    // The checkpoint is written with the batch and reverted with it
+    if lastCheckedBlock, _ := n.db.GetLastCheckedBlock(); lastCheckedBlock != flushed {
+        n.db.SetLastCheckedBlock(int(flushed))
+    }
+    n.SetLatestCheckedBlock(int(flushed))
+    if to >= from {
+        metrics.ReprocessedBlocks.Add(float64(to - from + 1))
+        syncLogger.Warn("reprocessing unflushed blocks", "from", from, "to", to)
+    }
+    return flushed, nil
+}
+
+// halt stops syncing after the unflushed blocks could not be reverted. Process and
+// Checkpoint skip their work while halted; the subscription is not paused, since
+// halt runs in its block saver and its Pause waits for that very goroutine.
+func (n *Node) halt(from, to int64, err error) {
+    n.halted.Store(true)
+    syncLogger.Error("failed to revert unflushed blocks, halting", "from", from, "to", to, "error", err)
+    reporting.Report(err, reporting.Context{Module: "sync", Block: to})
+    if n.hooks.Halted != nil {
+        n.hooks.Halted(err)
+    }
+}
+
+// recoverCheckpoint turns a panic while committing a checkpoint into an error. The
+// batch is reverted and the subscription rewound so the blocks are processed again.
+func (n *Node) recoverCheckpoint(blockNumber int, err *error) {
+    value := recover()
+    if value == nil {
+        return
+    }
+
+    stack := string(debug.Stack())
+    syncLogger.Error("checkpoint panicked, reverting batch", "block", blockNumber, "panic", fmt.Sprint(value), "stack", stack)
+    reporting.Report(fmt.Errorf("checkpoint panicked: %v", value), reporting.Context{Module: "sync", Block: int64(blockNumber), Extra: map[string]string{"stack": stack}})
+    if n.hooks.Panicked != nil {
+        n.hooks.Panicked(blockNumber, value)
+    }
+    *err = fmt.Errorf("checkpoint at block %d panicked: %v", blockNumber, value)
+
+    // The state may be what panicked, so a second panic here must not escape either
+    defer func() {
+        if value := recover(); value != nil {
+            syncLogger.Error("failed to revert after checkpoint panic", "block", blockNumber, "panic", fmt.Sprint(value))
+        }
+    }()
+    n.Discard()
+}
@@ -0,0 +1,40 @@
+package secrets
+
+import (
+    "bytes"
+    "fmt"
+    "os/exec"
+    "runtime"
+    "strings"
+)
+
+// lookupKeychain reads the password of a service and account from the OS keychain,
+// through security on macOS and secret-tool (libsecret) on Linux
+func lookupKeychain(name string) (string, error) {
+    service, account, found := strings.Cut(name, "/")
+    if !found || service == "" || account == "" {
+        return "", fmt.Errorf("keychain references take the form service/account")
+    }
+
+    var cmd *exec.Cmd
+    switch runtime.GOOS {
+    case "darwin":
+        cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+    case "linux":
+        cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+    default:
+        return "", fmt.Errorf("no keychain support on %s", runtime.GOOS)
+    }
+
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        return "", fmt.Errorf("%s: %v %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+    }
+    secret := strings.TrimRight(string(out), "\r\n")
+    if secret == "" {
+        return "", fmt.Errorf("no keychain entry for %s", name)
+    }
+    return secret, nil
+}
@@ -0,0 +1,96 @@
+// Package secrets resolves references to secrets kept outside the configuration
+// file. A reference is a provider name, a colon and what the provider looks up:
+//
+//	env:VIDA_JWT_SECRET          an environment variable
+//	file:/run/secrets/jwt        the trimmed contents of a file
+//	keychain:vida/jwt            a service and account in the OS keychain
+//	vault:secret/data/vida#jwt   a field of a Vault KV secret, "value" by default
+//
+// Any other value is used as it is.
+package secrets
+
+import (
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+)
+
+// Provider looks up the secret a reference names
+type Provider interface {
+    Lookup(name string) (string, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface
+type ProviderFunc func(name string) (string, error)
+
+// Lookup calls f
+func (f ProviderFunc) Lookup(name string) (string, error) {
+    return f(name)
+}
+
+var (
+    providers = map[string]Provider{
+        "env":      ProviderFunc(lookupEnv),
+        "file":     ProviderFunc(lookupFile),
+        "keychain": ProviderFunc(lookupKeychain),
+        "vault":    ProviderFunc(func(string) (string, error) { return "", fmt.Errorf("vault is not configured") }),
+    }
+    providersMutex sync.RWMutex
+)
+
+// Register installs the provider resolving references with the given prefix
+func Register(prefix string, provider Provider) {
+    providersMutex.Lock()
+    providers[prefix] = provider
+    providersMutex.Unlock()
+}
+
+// split returns the provider and name of a reference, or false for a plain value
+func split(value string) (Provider, string, bool) {
+    prefix, name, found := strings.Cut(value, ":")
+    if !found {
+        return nil, "", false
+    }
+    providersMutex.RLock()
+    provider, ok := providers[prefix]
+    providersMutex.RUnlock()
+    return provider, name, ok
+}
+
+// IsReference reports whether value names a secret rather than holding one
+func IsReference(value string) bool {
+    _, _, ok := split(value)
+    return ok
+}
+
+// Resolve returns the secret value references, or value itself when it is not a reference
+func Resolve(value string) (string, error) {
+    provider, name, ok := split(value)
+    if !ok {
+        return value, nil
+    }
+    secret, err := provider.Lookup(name)
+    if err != nil {
+        return "", fmt.Errorf("%s: %v", value, err)
+    }
+    return secret, nil
+}
+
+// lookupEnv reads a secret from an environment variable, which must be set
+func lookupEnv(name string) (string, error) {
+    value, ok := os.LookupEnv(name)
+    if !ok {
+        return "", fmt.Errorf("environment variable %s is not set", name)
+    }
+    return value, nil
+}
+
+// lookupFile reads a secret from a file, dropping surrounding whitespace
+func lookupFile(name string) (string, error) {
+    data, err := os.ReadFile(name)
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,67 @@
+package secrets
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// vaultResponse is the body of a Vault secret read. KV version 2 nests the fields
+// in a second data object.
+type vaultResponse struct {
+    Data map[string]interface{} `json:"data"`
+}
+
+// Vault reads secrets from the KV engine of a HashiCorp Vault server
+type Vault struct {
+    Address string
+    Token   string
+    client  *http.Client
+}
+
+// NewVault returns a provider reading from the Vault server at address with token
+func NewVault(address, token string) *Vault {
+    return &Vault{
+        Address: strings.TrimSuffix(address, "/"),
+        Token:   token,
+        client:  &http.Client{Timeout: 10 * time.Second},
+    }
+}
+
+// Lookup reads a field of a secret, named as path#field with field defaulting to "value"
+func (v *Vault) Lookup(name string) (string, error) {
+    path, field, found := strings.Cut(name, "#")
+    if !found {
+        field = "value"
+    }
+
+    request, err := http.NewRequest(http.MethodGet, v.Address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+    if err != nil {
+        return "", err
+    }
+    request.Header.Set("X-Vault-Token", v.Token)
+    resp, err := v.client.Do(request)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("vault returned status %d", resp.StatusCode)
+    }
+
+    var body vaultResponse
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        return "", fmt.Errorf("invalid vault response: %v", err)
+    }
+    data := body.Data
+    if nested, ok := data["data"].(map[string]interface{}); ok {
+        data = nested
+    }
+    value, ok := data[field].(string)
+    if !ok {
+        return "", fmt.Errorf("secret has no string field %q", field)
+    }
+    return value, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "strings"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/settlement"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons a settlement action is rejected
+const (
+    failureBatchSettled  = "batch_already_settled"
+    failureNotAuthorized = "settlement_not_authorized"
+)
+
+// isSettlementOperator reports whether the sender of a transaction may settle batches
+func (a *App) isSettlementOperator(senderHex string) bool {
+    sender := strings.TrimPrefix(strings.ToLower(senderHex), "0x")
+    for _, operator := range a.chainParams().Settlement.Operators {
+        if strings.TrimPrefix(strings.ToLower(operator), "0x") == sender {
+            return true
+        }
+    }
+    return false
+}
+
+// parseDeltas reads the "deltas" of a batch, an object of signed decimal changes
+// keyed by address, rejecting an address given twice
+func parseDeltas(entries map[string]string) (map[string]*big.Int, bool) {
+    if entries == nil {
+        return nil, false
+    }
+    deltas := make(map[string]*big.Int, len(entries))
+    for address, text := range entries {
+        delta, ok := new(big.Int).SetString(text, 10)
+        address = strings.TrimPrefix(strings.ToLower(address), "0x")
+        if !ok || deltas[address] != nil {
+            return nil, false
+        }
+        deltas[address] = delta
+    }
+    return deltas, true
+}
+
+// settlePayload is the payload of a settle
+type settlePayload struct {
+    envelope
+    ID     string            `json:"id"`
+    Token  string            `json:"token"`
+    Deltas map[string]string `json:"deltas"`
+
+    deltas map[string]*big.Int
+}
+
+func (p *settlePayload) validate(a *App) error {
+    deltas, ok := parseDeltas(p.Deltas)
+    if !ok {
+        return invalidField("deltas", "must be decimal changes keyed by distinct addresses")
+    }
+    p.deltas = deltas
+    return nil
+}
+
+// authorizeSettlementPayload is the payload of an authorizeSettlement
+type authorizeSettlementPayload struct {
+    envelope
+    Operator string `json:"operator"`
+    Revoke   bool   `json:"revoke"`
+
+    operator []byte
+}
+
+func (p *authorizeSettlementPayload) validate(a *App) error {
+    if p.operator = payloadAddress(p.Operator); p.operator == nil {
+        return invalidField("operator", "must be an address")
+    }
+    return nil
+}
+
+// handleAuthorizeSettlement lets an "operator" debit the sender in settlements, or
+// no longer with revoke set. It returns the reason the action was rejected, or an
+// empty string on success.
+func (a *App) handleAuthorizeSettlement(ctx context.Context, p *authorizeSettlementPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if err := settlement.Authorize(a.db, sender, p.operator, !p.Revoke); err != nil {
+        reportSettlementError(ctx, err, "authorizeSettlement", senderHex)
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "settlement authorization changed", "operator", hex.EncodeToString(p.operator), "revoked", p.Revoke, "sender", senderHex)
+    return ""
+}
+
+// handleSettle applies the batch "id" from an operator, changing the balance of
+// "token" of each account by its "deltas". It returns the reason the batch was
+// rejected, or an empty string on success.
+func (a *App) handleSettle(ctx context.Context, p *settlePayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    if !a.isSettlementOperator(senderHex) {
+        syncLogger.WarnContext(ctx, "settlement from a non-operator", "sender", senderHex)
+        return failureUnauthorized
+    }
+    id := p.ID
+    batch, err := settlement.Settle(a.db, sender, id, p.Token, p.deltas, int64(transaction.BlockNumber))
+    switch {
+    case err == nil:
+        syncLogger.InfoContext(ctx, "batch settled", "id", id, "token", batch.Token, "entries", batch.Entries, "volume", batch.Volume, "sender", senderHex)
+        return ""
+    case errors.Is(err, settlement.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid settlement", "id", id, "error", err, "sender", senderHex)
+        return failureInvalidPayload
+    case errors.Is(err, settlement.ErrSettled):
+        return failureBatchSettled
+    case errors.Is(err, settlement.ErrNotAuthorized):
+        syncLogger.InfoContext(ctx, "settlement failed", "id", id, "error", err, "sender", senderHex)
+        return failureNotAuthorized
+    case errors.Is(err, settlement.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, "settlement failed", "id", id, "error", err, "sender", senderHex)
+        return failureInsufficientFunds
+    }
+    reportSettlementError(ctx, err, "settle", senderHex)
+    return failureInvalidPayload
+}
+
+// reportSettlementError reports an unexpected error of the settlement module
+func reportSettlementError(ctx context.Context, err error, action, senderHex string) {
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": senderHex},
+    })
+}
@@ -0,0 +1,177 @@
+// Package settlement applies netted batches posted by settlement operators, such as
+// exchanges settling the activity of their users. A batch lists the net change of
+// the balance of each account in one token; the changes must sum to zero and apply
+// together or not at all. Only accounts that authorized the operator can be debited,
+// and each batch ID settles once. Authorizations and settled batches are part of the
+// Merkle state.
+package settlement
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "regexp"
+    "sort"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// MaxEntries is the most accounts a batch can change
+const MaxEntries = 1024
+
+// Errors of rejected settlement actions
+var (
+    ErrInvalid           = errors.New("invalid settlement batch")
+    ErrSettled           = errors.New("batch already settled")
+    ErrNotAuthorized     = errors.New("account has not authorized the operator")
+    ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Batch is a settled batch
+type Batch struct {
+    ID       string `json:"id"`
+    Operator string `json:"operator"`
+    Token    string `json:"token"`
+    Entries  int    `json:"entries"`
+    // Volume is the sum of the credits, equal to the sum of the debits
+    Volume string `json:"volume"`
+    Block  int64  `json:"block"`
+}
+
+// authorizationKey returns the tree key of the authorization of operator by account
+func authorizationKey(account, operator []byte) []byte {
+    return []byte(dbservice.SettlementPrefix + "authorized/" + hex.EncodeToString(account) + "/" + hex.EncodeToString(operator))
+}
+
+// batchKey returns the tree key of a batch of an operator
+func batchKey(operator []byte, id string) []byte {
+    return []byte(dbservice.SettlementPrefix + "batch/" + hex.EncodeToString(operator) + "/" + id)
+}
+
+// ValidID reports whether id can name a batch
+func ValidID(id string) bool {
+    return idPattern.MatchString(id)
+}
+
+// Authorize lets operator debit account in its batches, or stops it when allowed
+// is false
+func Authorize(db *dbservice.DatabaseService, account, operator []byte, allowed bool) error {
+    if !allowed {
+        return db.SetData(authorizationKey(account, operator), []byte{})
+    }
+    return db.SetData(authorizationKey(account, operator), []byte{1})
+}
+
+// Authorized reports whether account lets operator debit it
+func Authorized(db *dbservice.DatabaseService, account, operator []byte) (bool, error) {
+    data, err := db.GetData(authorizationKey(account, operator))
+    return len(data) > 0, err
+}
+
+// Lookup returns a settled batch of an operator, and false when it has not settled
+func Lookup(db *dbservice.DatabaseService, operator []byte, id string) (Batch, bool, error) {
+    var batch Batch
+    if !ValidID(id) {
+        return batch, false, nil
+    }
+    found, err := db.LoadJSON(batchKey(operator, id), &batch)
+    return batch, found, err
+}
+
+// Settle applies the batch id of operator at block, changing the balance of token
+// of each account by its delta. Nothing changes unless every debited account
+// authorized the operator and holds its debit.
+func Settle(db *dbservice.DatabaseService, operator []byte, id, token string, deltas map[string]*big.Int, block int64) (Batch, error) {
+    if !ValidID(id) {
+        return Batch{}, fmt.Errorf("%w: the id must be 1 to 64 letters, digits, '.', '_' or '-'", ErrInvalid)
+    }
+    if len(deltas) == 0 || len(deltas) > MaxEntries {
+        return Batch{}, fmt.Errorf("%w: a batch changes 1 to %d accounts", ErrInvalid, MaxEntries)
+    }
+    if tokens.IsNative(token) {
+        token = tokens.Native
+    } else if _, found, err := tokens.Lookup(db, token); err != nil || !found {
+        if err != nil {
+            return Batch{}, err
+        }
+        return Batch{}, fmt.Errorf("%w: token %q is not registered", ErrInvalid, token)
+    }
+    if _, settled, err := Lookup(db, operator, id); err != nil || settled {
+        if settled {
+            return Batch{}, ErrSettled
+        }
+        return Batch{}, err
+    }
+
+    // Check everything before the first write, in address order so the error of an
+    // invalid batch does not depend on map order
+    addresses := make([]string, 0, len(deltas))
+    for address := range deltas {
+        addresses = append(addresses, address)
+    }
+    sort.Strings(addresses)
+    accounts := make(map[string][]byte, len(deltas))
+    sum, volume := new(big.Int), new(big.Int)
+    for _, address := range addresses {
+        account, err := hex.DecodeString(address)
+        if err != nil || len(account) != dbservice.AddressLength {
+            return Batch{}, fmt.Errorf("%w: %q is not an address", ErrInvalid, address)
+        }
+        accounts[address] = account
+        delta := deltas[address]
+        if delta.Sign() == 0 {
+            return Batch{}, fmt.Errorf("%w: the change of %s is zero", ErrInvalid, address)
+        }
+        sum.Add(sum, delta)
+        if delta.Sign() > 0 {
+            volume.Add(volume, delta)
+            continue
+        }
+        authorized, err := Authorized(db, account, operator)
+        if err != nil {
+            return Batch{}, err
+        }
+        if !authorized {
+            return Batch{}, fmt.Errorf("%w: %s", ErrNotAuthorized, address)
+        }
+        balance, err := tokens.Balance(db, token, account)
+        if err != nil {
+            return Batch{}, err
+        }
+        if balance.Cmp(new(big.Int).Neg(delta)) < 0 {
+            return Batch{}, fmt.Errorf("%w: %s", ErrInsufficientFunds, address)
+        }
+    }
+    if sum.Sign() != 0 {
+        return Batch{}, fmt.Errorf("%w: the debits and credits differ by %s", ErrInvalid, sum)
+    }
+
+    for _, address := range addresses {
+        delta := deltas[address]
+        if delta.Sign() < 0 {
+            if _, err := tokens.Debit(db, token, accounts[address], new(big.Int).Neg(delta)); err != nil {
+                return Batch{}, err
+            }
+        }
+    }
+    for _, address := range addresses {
+        if delta := deltas[address]; delta.Sign() > 0 {
+            if err := tokens.Credit(db, token, accounts[address], delta); err != nil {
+                return Batch{}, err
+            }
+        }
+    }
+    batch := Batch{
+        ID:       id,
+        Operator: hex.EncodeToString(operator),
+        Token:    token,
+        Entries:  len(deltas),
+        Volume:   volume.String(),
+        Block:    block,
+    }
+    return batch, db.SaveJSON(batchKey(operator, id), batch)
+}
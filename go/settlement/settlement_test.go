@@ -0,0 +1,75 @@
+package settlement
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+func TestSettleOnlyChangesItsDatabase(t *testing.T) {
+    operator := bytes.Repeat([]byte{9}, dbservice.AddressLength)
+    payer := bytes.Repeat([]byte{1}, dbservice.AddressLength)
+    payee := bytes.Repeat([]byte{2}, dbservice.AddressLength)
+    deltas := map[string]*big.Int{
+        hex.EncodeToString(payer): big.NewInt(-40),
+        hex.EncodeToString(payee): big.NewInt(40),
+    }
+
+    tests := []struct {
+        name string
+        // authorize is whether the payer authorizes the operator in the database
+        authorize bool
+        wantErr   error
+        // payer and payee are the balances after the batch
+        payer, payee int64
+    }{
+        {name: "authorized", authorize: true, payer: 60, payee: 40},
+        {name: "authorized in the other database", wantErr: ErrNotAuthorized, payer: 100},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db, other := dbservice.New("database"), dbservice.New("database")
+            db.SetDir(t.TempDir())
+            other.SetDir(t.TempDir())
+            defer db.Close()
+            defer other.Close()
+
+            for _, state := range []*dbservice.DatabaseService{db, other} {
+                if err := state.SetBalance(payer, big.NewInt(100)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if err := Authorize(other, payer, operator, true); err != nil {
+                t.Fatal(err)
+            }
+            if test.authorize {
+                if err := Authorize(db, payer, operator, true); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            if _, err := Settle(db, operator, "batch-1", tokens.Native, deltas, 10); !errors.Is(err, test.wantErr) {
+                t.Fatalf("Settle error = %v, want %v", err, test.wantErr)
+            }
+            if _, settled, err := Lookup(other, operator, "batch-1"); err != nil || settled {
+                t.Errorf("the other database holds the batch: %v, %v", settled, err)
+            }
+            for state, balances := range map[*dbservice.DatabaseService][2]int64{db: {test.payer, test.payee}, other: {100, 0}} {
+                for i, account := range [][]byte{payer, payee} {
+                    balance, err := tokens.Balance(state, tokens.Native, account)
+                    if err != nil {
+                        t.Fatal(err)
+                    }
+                    if balance.Int64() != balances[i] {
+                        t.Errorf("balance of %x = %s, want %d", account, balance, balances[i])
+                    }
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,212 @@
+// Package signing signs API responses with the node key, so that consumers can
+// prove what a node reported and when. The key is an Ed25519 key stored as the hex
+// encoded 32 byte seed.
+package signing
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Response headers carrying the signature, the Unix time it was made at and the
+// hex public key of the node, and the signature and key of the previous key while
+// a rotation overlaps
+const (
+    SignatureHeader         = "X-Vida-Signature"
+    TimestampHeader         = "X-Vida-Signed-At"
+    KeyHeader               = "X-Vida-Key"
+    PreviousSignatureHeader = "X-Vida-Previous-Signature"
+    PreviousKeyHeader       = "X-Vida-Previous-Key"
+)
+
+var (
+    privateKey  ed25519.PrivateKey
+    previousKey ed25519.PrivateKey
+    keyMutex    sync.RWMutex
+)
+
+// GenerateKey writes a new key to path, failing if the file exists, and returns its public key
+func GenerateKey(path string) (ed25519.PublicKey, error) {
+    publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, err
+    }
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    if _, err := fmt.Fprintln(file, hex.EncodeToString(key.Seed())); err != nil {
+        return nil, err
+    }
+    return publicKey, file.Close()
+}
+
+// readKey reads a hex encoded key from path, returning nil for an empty path
+func readKey(path string) (ed25519.PrivateKey, error) {
+    if path == "" {
+        return nil, nil
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    key, err := parseKey(string(data))
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    return key, nil
+}
+
+// parseKey decodes a hex encoded key, returning nil for an empty string
+func parseKey(keyHex string) (ed25519.PrivateKey, error) {
+    if keyHex = strings.TrimSpace(keyHex); keyHex == "" {
+        return nil, nil
+    }
+    seed, err := hex.DecodeString(keyHex)
+    if err != nil || len(seed) != ed25519.SeedSize {
+        return nil, fmt.Errorf("not a hex encoded %d byte key", ed25519.SeedSize)
+    }
+    return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Load reads the node key from path; an empty path disables signing
+func Load(path string) error {
+    key, err := readKey(path)
+    if err != nil {
+        return err
+    }
+    keyMutex.Lock()
+    privateKey = key
+    keyMutex.Unlock()
+    return nil
+}
+
+// UseKey installs the hex encoded key; an empty key disables signing
+func UseKey(keyHex string) error {
+    key, err := parseKey(keyHex)
+    if err != nil {
+        return err
+    }
+    keyMutex.Lock()
+    privateKey = key
+    keyMutex.Unlock()
+    return nil
+}
+
+// LoadPrevious reads the key being rotated out from path. Until it is removed,
+// responses carry a second signature with it, so consumers that only know the old
+// key keep verifying during the overlap. An empty path stops the second signature.
+func LoadPrevious(path string) error {
+    key, err := readKey(path)
+    if err != nil {
+        return err
+    }
+    keyMutex.Lock()
+    previousKey = key
+    keyMutex.Unlock()
+    return nil
+}
+
+// UsePrevious installs the hex encoded key being rotated out, see LoadPrevious
+func UsePrevious(keyHex string) error {
+    key, err := parseKey(keyHex)
+    if err != nil {
+        return err
+    }
+    keyMutex.Lock()
+    previousKey = key
+    keyMutex.Unlock()
+    return nil
+}
+
+// Enabled reports whether a key is loaded
+func Enabled() bool {
+    keyMutex.RLock()
+    defer keyMutex.RUnlock()
+    return privateKey != nil
+}
+
+// PublicKey returns the public key of the node, nil when signing is disabled
+func PublicKey() ed25519.PublicKey {
+    keyMutex.RLock()
+    defer keyMutex.RUnlock()
+    if privateKey == nil {
+        return nil
+    }
+    return privateKey.Public().(ed25519.PublicKey)
+}
+
+// message returns the signed bytes: the timestamp, the request target (path and
+// query, or the gRPC method) and the response body, separated by newlines
+func message(timestamp int64, target string, body []byte) []byte {
+    prefix := strconv.FormatInt(timestamp, 10) + "\n" + target + "\n"
+    return append([]byte(prefix), body...)
+}
+
+// Signature is a signed response, rendered into the response headers. The previous
+// fields are set while a rotated out key is loaded.
+type Signature struct {
+    Signature         string
+    Timestamp         string
+    Key               string
+    PreviousSignature string
+    PreviousKey       string
+}
+
+// Sign signs the response body to a request for target at the given time. It
+// returns false when signing is disabled.
+func Sign(target string, body []byte, at time.Time) (Signature, bool) {
+    keyMutex.RLock()
+    key, previous := privateKey, previousKey
+    keyMutex.RUnlock()
+    if key == nil {
+        return Signature{}, false
+    }
+
+    timestamp := at.Unix()
+    signed := message(timestamp, target, body)
+    signature := Signature{
+        Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, signed)),
+        Timestamp: strconv.FormatInt(timestamp, 10),
+        Key:       hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+    }
+    if previous != nil {
+        signature.PreviousSignature = base64.StdEncoding.EncodeToString(ed25519.Sign(previous, signed))
+        signature.PreviousKey = hex.EncodeToString(previous.Public().(ed25519.PublicKey))
+    }
+    return signature, true
+}
+
+// Verify checks a signature made by Sign against the public key of a node. The
+// previous signature is checked when publicKey is the rotated out key.
+func Verify(publicKey ed25519.PublicKey, target string, body []byte, signature Signature) error {
+    if len(publicKey) != ed25519.PublicKeySize {
+        return errors.New("invalid public key")
+    }
+    timestamp, err := strconv.ParseInt(signature.Timestamp, 10, 64)
+    if err != nil {
+        return errors.New("invalid timestamp")
+    }
+    encoded := signature.Signature
+    if signature.PreviousSignature != "" && signature.PreviousKey == hex.EncodeToString(publicKey) {
+        encoded = signature.PreviousSignature
+    }
+    sig, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil {
+        return errors.New("invalid signature encoding")
+    }
+    if !ed25519.Verify(publicKey, message(timestamp, target, body), sig) {
+        return errors.New("signature does not match")
+    }
+    return nil
+}
@@ -0,0 +1,195 @@
+package snapshot
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+)
+
+// fileSuffix is the extension of snapshot files
+const fileSuffix = ".db"
+
+// Info describes a snapshot file
+type Info struct {
+    Path        string
+    BlockNumber int64
+    RootHash    []byte
+}
+
+// lastCheckedBlock reads the checkpoint stored in an open database file
+func lastCheckedBlock(file *dbfile.File) (int64, error) {
+    data, err := file.Get(dbservice.LastCheckedBlockKey)
+    if err != nil {
+        return 0, err
+    }
+    return dbservice.DecodeBlockNumber(data), nil
+}
+
+// Create copies the database at dbPath into dir, naming the file after its checkpoint block
+func Create(dbPath, dir string) (*Info, error) {
+    file, err := dbfile.Open(dbPath, true)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    blockNumber, err := lastCheckedBlock(file)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+    out := filepath.Join(dir, strconv.FormatInt(blockNumber, 10)+fileSuffix)
+    return CreateAt(file, out, blockNumber)
+}
+
+// CreateFile copies the database at dbPath to out. A non-negative blockNumber must
+// match the checkpoint of the database, since only the current state can be copied.
+func CreateFile(dbPath, out string, blockNumber int64) (*Info, error) {
+    file, err := dbfile.Open(dbPath, true)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    checkpoint, err := lastCheckedBlock(file)
+    if err != nil {
+        return nil, err
+    }
+    if blockNumber >= 0 && blockNumber != checkpoint {
+        return nil, fmt.Errorf("database is at block %d, not %d", checkpoint, blockNumber)
+    }
+
+    if dir := filepath.Dir(out); dir != "" {
+        if err := os.MkdirAll(dir, 0755); err != nil {
+            return nil, err
+        }
+    }
+    return CreateAt(file, out, checkpoint)
+}
+
+// CreateAt writes a copy of an open database file to out
+func CreateAt(file *dbfile.File, out string, blockNumber int64) (*Info, error) {
+    if err := file.CopyTo(out); err != nil {
+        return nil, fmt.Errorf("failed to write snapshot %s: %v", out, err)
+    }
+    return &Info{Path: out, BlockNumber: blockNumber, RootHash: file.RootHash()}, nil
+}
+
+// Inspect reads the checkpoint and root hash of a snapshot file
+func Inspect(path string) (*Info, error) {
+    file, err := dbfile.Open(path, true)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    blockNumber, err := lastCheckedBlock(file)
+    if err != nil {
+        return nil, err
+    }
+    return &Info{Path: path, BlockNumber: blockNumber, RootHash: file.RootHash()}, nil
+}
+
+// List returns the snapshots in dir ordered by block number
+func List(dir string) ([]Info, error) {
+    entries, err := os.ReadDir(dir)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var snapshots []Info
+    for _, entry := range entries {
+        name := entry.Name()
+        if entry.IsDir() || !strings.HasSuffix(name, fileSuffix) {
+            continue
+        }
+        blockNumber, err := strconv.ParseInt(strings.TrimSuffix(name, fileSuffix), 10, 64)
+        if err != nil {
+            continue
+        }
+        snapshots = append(snapshots, Info{Path: filepath.Join(dir, name), BlockNumber: blockNumber})
+    }
+
+    sort.Slice(snapshots, func(i, j int) bool {
+        return snapshots[i].BlockNumber < snapshots[j].BlockNumber
+    })
+    return snapshots, nil
+}
+
+// Latest returns the newest snapshot in dir taken at or before maxBlock
+func Latest(dir string, maxBlock int64) (*Info, error) {
+    snapshots, err := List(dir)
+    if err != nil {
+        return nil, err
+    }
+    for i := len(snapshots) - 1; i >= 0; i-- {
+        if snapshots[i].BlockNumber <= maxBlock {
+            return Inspect(snapshots[i].Path)
+        }
+    }
+    return nil, fmt.Errorf("no snapshot at or before block %d in %s", maxBlock, dir)
+}
+
+// Restore verifies the snapshot at path and copies it over the database at dbPath.
+// The database must not be open by a running node.
+func Restore(path, dbPath string) (*Info, error) {
+    source, err := dbfile.Open(path, true)
+    if err != nil {
+        return nil, err
+    }
+    defer source.Close()
+
+    problems, err := source.Verify()
+    if err != nil {
+        return nil, err
+    }
+    if len(problems) > 0 {
+        return nil, fmt.Errorf("snapshot %s failed verification: %s", path, problems[0])
+    }
+
+    blockNumber, err := lastCheckedBlock(source)
+    if err != nil {
+        return nil, err
+    }
+
+    // Make sure no node holds the database before replacing it
+    if _, err := os.Stat(dbPath); err == nil {
+        target, err := dbfile.Open(dbPath, false)
+        if err != nil {
+            return nil, err
+        }
+        target.Close()
+    }
+
+    tmp := dbPath + ".restore"
+    if err := source.CopyTo(tmp); err != nil {
+        return nil, err
+    }
+    if err := os.Rename(tmp, dbPath); err != nil {
+        os.Remove(tmp)
+        return nil, err
+    }
+
+    restored, err := Inspect(dbPath)
+    if err != nil {
+        return nil, err
+    }
+    if !bytes.Equal(restored.RootHash, source.RootHash()) {
+        return nil, fmt.Errorf("restored root hash does not match snapshot")
+    }
+    restored.BlockNumber = blockNumber
+    return restored, nil
+}
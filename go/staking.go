@@ -0,0 +1,117 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "strings"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/staking"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// failureInsufficientStake rejects an unstake of more than is bonded
+const failureInsufficientStake = "insufficient_stake"
+
+// stakingParams returns the staking parameters of the genesis params
+func (a *App) stakingParams() staking.Params {
+    cfg := a.chainParams().Staking
+    reward, ok := new(big.Int).SetString(cfg.RewardPerEpoch, 10)
+    if !ok {
+        reward = new(big.Int)
+    }
+    return staking.Params{UnbondingBlocks: cfg.UnbondingBlocks, EpochBlocks: cfg.EpochBlocks, RewardPerEpoch: reward}
+}
+
+// payloadAddress decodes a hex address from a payload, returning nil when it is not
+// a 20 byte address
+func payloadAddress(addressHex string) []byte {
+    address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(addressHex), "0x"))
+    if err != nil || len(address) != dbservice.AddressLength {
+        return nil
+    }
+    return address
+}
+
+// parsePositiveAmount reads an amount given as a decimal string or a JSON number,
+// returning nil unless it is a positive integer
+func parsePositiveAmount(raw number) *big.Int {
+    amount, ok := raw.integer()
+    if !ok || amount.Sign() <= 0 {
+        return nil
+    }
+    return amount
+}
+
+// stakingPayload is the payload of a stake, delegate or unstake action
+type stakingPayload struct {
+    envelope
+    Amount    number  `json:"amount"`
+    Validator *string `json:"validator"`
+
+    amount    *big.Int
+    validator []byte
+}
+
+func (p *stakingPayload) validate(a *App) error {
+    if p.amount = a.parseTokenAmount(p.Amount, tokens.Native); p.amount == nil {
+        return invalidAmount("amount", "must be a positive amount")
+    }
+    if p.Validator != nil || strings.EqualFold(p.Action, "delegate") {
+        if p.Validator == nil {
+            return invalidField("validator", "required")
+        }
+        if p.validator = payloadAddress(*p.Validator); p.validator == nil {
+            return invalidField("validator", "must be an address")
+        }
+    }
+    return nil
+}
+
+// handleStaking applies a stake, delegate or unstake action. Staking bonds to the
+// sender itself, delegating to the "validator" of the payload; unstaking releases
+// from the given validator, or the sender, after the unbonding period. It returns
+// the reason the action was rejected, or an empty string on success.
+func (a *App) handleStaking(ctx context.Context, p *stakingPayload, transaction rpc.VidaDataTransaction) string {
+    action := strings.ToLower(p.Action)
+    senderHex, block := transaction.Sender, int64(transaction.BlockNumber)
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    amount, validator := p.amount, p.validator
+    if validator == nil {
+        validator = sender
+    }
+
+    var err error
+    if action == "unstake" {
+        err = staking.Unbond(a.db, sender, validator, amount, block, a.stakingParams())
+    } else {
+        err = staking.Bond(a.db, sender, validator, amount, block)
+    }
+    switch {
+    case errors.Is(err, staking.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, "staking failed: insufficient funds", "action", action, "amount", amount, "sender", senderHex)
+        return failureInsufficientFunds
+    case errors.Is(err, staking.ErrInsufficientStake):
+        syncLogger.InfoContext(ctx, "unstake failed: insufficient stake", "amount", amount, "sender", senderHex)
+        return failureInsufficientStake
+    case err != nil:
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        action,
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "validator": hex.EncodeToString(validator)},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "staking action applied", "action", action, "amount", amount, "sender", senderHex, "validator", hex.EncodeToString(validator))
+    return ""
+}
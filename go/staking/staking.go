@@ -0,0 +1,329 @@
+// Package staking tracks bonded balances: tokens staked to a validator by the
+// validator itself or delegated by other accounts. Bonded and unbonding tokens are
+// held by the staking module account, unbonding tokens are released after a fixed
+// number of blocks, and a fixed reward is minted to delegators at every epoch
+// boundary, unless the monetary policy does not allow it. All of it is part of the
+// Merkle state.
+package staking
+
+import (
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "math/big"
+    "sort"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/monetary"
+)
+
+// Errors of rejected staking actions
+var (
+    ErrInsufficientFunds = errors.New("insufficient funds")
+    ErrInsufficientStake = errors.New("insufficient stake")
+)
+
+// EscrowAddress is the module account holding bonded and unbonding tokens
+var EscrowAddress = dbservice.ModuleAddress("staking")
+
+var (
+    delegationsKey = []byte(dbservice.StakingPrefix + "delegations")
+    unbondingKey   = []byte(dbservice.StakingPrefix + "unbonding")
+    lastBlockKey   = []byte(dbservice.StakingPrefix + "lastBlock")
+)
+
+// Params are the staking parameters, which must be the same on every node
+type Params struct {
+    UnbondingBlocks int64
+    EpochBlocks     int64
+    RewardPerEpoch  *big.Int
+}
+
+// Delegation is the amount a delegator has bonded to a validator. Staking is a
+// delegation to oneself.
+type Delegation struct {
+    Delegator string `json:"delegator"`
+    Validator string `json:"validator"`
+    Amount    string `json:"amount"`
+}
+
+// Unbonding is an unstaked amount released to its owner at ReleaseBlock
+type Unbonding struct {
+    Address      string `json:"address"`
+    Amount       string `json:"amount"`
+    ReleaseBlock int64  `json:"releaseBlock"`
+}
+
+// loadList reads a JSON list stored under key
+func loadList(db *dbservice.DatabaseService, key []byte, list interface{}) error {
+    _, err := db.LoadJSON(key, list)
+    return err
+}
+
+// delegations returns all delegations, ordered by delegator and validator
+func delegations(db *dbservice.DatabaseService) ([]Delegation, error) {
+    var list []Delegation
+    return list, loadList(db, delegationsKey, &list)
+}
+
+// unbondings returns the unbonding queue, ordered by release block
+func unbondings(db *dbservice.DatabaseService) ([]Unbonding, error) {
+    var list []Unbonding
+    return list, loadList(db, unbondingKey, &list)
+}
+
+// lastBlock returns the block the staking state was last advanced to, and false
+// when it never was
+func lastBlock(db *dbservice.DatabaseService) (int64, bool, error) {
+    data, err := db.GetData(lastBlockKey)
+    if err != nil || len(data) != 8 {
+        return 0, false, err
+    }
+    return int64(binary.BigEndian.Uint64(data)), true, nil
+}
+
+// setLastBlock records the block the staking state was advanced to
+func setLastBlock(db *dbservice.DatabaseService, block int64) error {
+    data := make([]byte, 8)
+    binary.BigEndian.PutUint64(data, uint64(block))
+    return db.SetData(lastBlockKey, data)
+}
+
+// amountOf parses a stored decimal amount
+func amountOf(amount string) *big.Int {
+    value, ok := new(big.Int).SetString(amount, 10)
+    if !ok {
+        return new(big.Int)
+    }
+    return value
+}
+
+// credit adds amount to the balance of address
+func credit(db *dbservice.DatabaseService, address []byte, amount *big.Int) error {
+    balance, err := db.GetBalance(address)
+    if err != nil {
+        return err
+    }
+    return db.SetBalance(address, new(big.Int).Add(balance, amount))
+}
+
+// BeginBlock advances the staking state to block, before the first transaction of
+// the block is applied: it releases the unbondings that matured and mints the
+// rewards of the epoch boundaries crossed since the last advance. It only depends
+// on the state and the block number, so every node makes the same changes.
+func BeginBlock(db *dbservice.DatabaseService, block int64, params Params) error {
+    last, ok, err := lastBlock(db)
+    if err != nil || !ok || block <= last {
+        return err
+    }
+    if err := setLastBlock(db, block); err != nil {
+        return err
+    }
+
+    queue, err := unbondings(db)
+    if err != nil {
+        return err
+    }
+    released := 0
+    for released < len(queue) && queue[released].ReleaseBlock <= block {
+        entry := queue[released]
+        address, _ := hex.DecodeString(entry.Address)
+        amount := amountOf(entry.Amount)
+        if _, err := db.Transfer(EscrowAddress, address, amount); err != nil {
+            return err
+        }
+        released++
+    }
+    if released > 0 {
+        if err := db.SaveJSON(unbondingKey, queue[released:]); err != nil {
+            return err
+        }
+    }
+
+    if params.EpochBlocks <= 0 || params.RewardPerEpoch == nil || params.RewardPerEpoch.Sign() <= 0 {
+        return nil
+    }
+    epochs := block/params.EpochBlocks - last/params.EpochBlocks
+    if epochs <= 0 {
+        return nil
+    }
+    return distributeRewards(db, new(big.Int).Mul(params.RewardPerEpoch, big.NewInt(epochs)), block)
+}
+
+// distributeRewards mints reward to the delegators in proportion to their bonded
+// amounts at block. Each share is rounded down and the remainder is not minted. A
+// reward the monetary policy does not allow is not minted at all.
+func distributeRewards(db *dbservice.DatabaseService, reward *big.Int, block int64) error {
+    list, err := delegations(db)
+    if err != nil {
+        return err
+    }
+    total := new(big.Int)
+    for _, delegation := range list {
+        total.Add(total, amountOf(delegation.Amount))
+    }
+    if total.Sign() == 0 {
+        return nil
+    }
+    shares := make([]*big.Int, len(list))
+    minted := new(big.Int)
+    for i, delegation := range list {
+        shares[i] = new(big.Int).Mul(reward, amountOf(delegation.Amount))
+        shares[i].Quo(shares[i], total)
+        minted.Add(minted, shares[i])
+    }
+    if err := monetary.Issue(db, minted, block); err != nil {
+        if errors.Is(err, monetary.ErrOutsidePolicy) {
+            return nil
+        }
+        return err
+    }
+    for i, delegation := range list {
+        if shares[i].Sign() == 0 {
+            continue
+        }
+        delegator, _ := hex.DecodeString(delegation.Delegator)
+        if err := credit(db, delegator, shares[i]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// find returns the position of the delegation of delegator to validator, or where
+// it would be inserted, and whether it exists
+func find(list []Delegation, delegator, validator string) (int, bool) {
+    i := sort.Search(len(list), func(i int) bool {
+        if list[i].Delegator != delegator {
+            return list[i].Delegator > delegator
+        }
+        return list[i].Validator >= validator
+    })
+    return i, i < len(list) && list[i].Delegator == delegator && list[i].Validator == validator
+}
+
+// Bond moves amount from the balance of delegator to its delegation to validator.
+// block is the block of the transaction, from which epochs are counted when it is
+// the first bond.
+func Bond(db *dbservice.DatabaseService, delegator, validator []byte, amount *big.Int, block int64) error {
+    list, err := delegations(db)
+    if err != nil {
+        return err
+    }
+    ok, err := db.Transfer(delegator, EscrowAddress, amount)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return ErrInsufficientFunds
+    }
+    if len(list) == 0 {
+        // Nothing was bonded, so no rewards accrued since the last advance
+        if err := setLastBlock(db, block); err != nil {
+            return err
+        }
+    }
+
+    delegatorHex, validatorHex := hex.EncodeToString(delegator), hex.EncodeToString(validator)
+    i, found := find(list, delegatorHex, validatorHex)
+    if found {
+        list[i].Amount = new(big.Int).Add(amountOf(list[i].Amount), amount).String()
+    } else {
+        list = append(list, Delegation{})
+        copy(list[i+1:], list[i:])
+        list[i] = Delegation{Delegator: delegatorHex, Validator: validatorHex, Amount: amount.String()}
+    }
+    return db.SaveJSON(delegationsKey, list)
+}
+
+// Unbond removes amount from the delegation of delegator to validator and queues it
+// for release to delegator after the unbonding period
+func Unbond(db *dbservice.DatabaseService, delegator, validator []byte, amount *big.Int, block int64, params Params) error {
+    list, err := delegations(db)
+    if err != nil {
+        return err
+    }
+    delegatorHex, validatorHex := hex.EncodeToString(delegator), hex.EncodeToString(validator)
+    i, found := find(list, delegatorHex, validatorHex)
+    if !found {
+        return ErrInsufficientStake
+    }
+    remaining := new(big.Int).Sub(amountOf(list[i].Amount), amount)
+    if remaining.Sign() < 0 {
+        return ErrInsufficientStake
+    }
+    if remaining.Sign() == 0 {
+        list = append(list[:i], list[i+1:]...)
+    } else {
+        list[i].Amount = remaining.String()
+    }
+    if err := db.SaveJSON(delegationsKey, list); err != nil {
+        return err
+    }
+
+    queue, err := unbondings(db)
+    if err != nil {
+        return err
+    }
+    entry := Unbonding{Address: delegatorHex, Amount: amount.String(), ReleaseBlock: block + params.UnbondingBlocks}
+    // Keep the queue ordered by release block, after entries releasing at the same block
+    j := sort.Search(len(queue), func(j int) bool { return queue[j].ReleaseBlock > entry.ReleaseBlock })
+    queue = append(queue, Unbonding{})
+    copy(queue[j+1:], queue[j:])
+    queue[j] = entry
+    return db.SaveJSON(unbondingKey, queue)
+}
+
+// Account is the staking position of an address: what it delegated, what was
+// delegated to it as a validator and what it has unbonding
+type Account struct {
+    Delegations    []Delegation `json:"delegations"`
+    Delegated      string       `json:"delegated"`
+    ValidatorPower string       `json:"validatorPower"`
+    Unbonding      []Unbonding  `json:"unbonding"`
+}
+
+// AccountState returns the staking position of address
+func AccountState(db *dbservice.DatabaseService, address []byte) (Account, error) {
+    account := Account{Delegations: []Delegation{}, Unbonding: []Unbonding{}}
+    list, err := delegations(db)
+    if err != nil {
+        return account, err
+    }
+    queue, err := unbondings(db)
+    if err != nil {
+        return account, err
+    }
+
+    addressHex := hex.EncodeToString(address)
+    delegated, power := new(big.Int), new(big.Int)
+    for _, delegation := range list {
+        if delegation.Delegator == addressHex {
+            account.Delegations = append(account.Delegations, delegation)
+            delegated.Add(delegated, amountOf(delegation.Amount))
+        }
+        if delegation.Validator == addressHex {
+            power.Add(power, amountOf(delegation.Amount))
+        }
+    }
+    for _, entry := range queue {
+        if entry.Address == addressHex {
+            account.Unbonding = append(account.Unbonding, entry)
+        }
+    }
+    account.Delegated, account.ValidatorPower = delegated.String(), power.String()
+    return account, nil
+}
+
+// TotalBonded returns the sum of all delegations
+func TotalBonded(db *dbservice.DatabaseService) (*big.Int, error) {
+    list, err := delegations(db)
+    if err != nil {
+        return nil, err
+    }
+    total := new(big.Int)
+    for _, delegation := range list {
+        total.Add(total, amountOf(delegation.Amount))
+    }
+    return total, nil
+}
@@ -0,0 +1,180 @@
+package staking
+
+import (
+    "bytes"
+    "errors"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/monetary"
+)
+
+// account returns the address of the nth test account
+func account(n byte) []byte {
+    return bytes.Repeat([]byte{n}, dbservice.AddressLength)
+}
+
+// newDatabase returns a database where each account holds its balance
+func newDatabase(t *testing.T, balances map[byte]int64) *dbservice.DatabaseService {
+    t.Helper()
+    db := dbservice.New("staking")
+    db.SetDir(t.TempDir())
+    t.Cleanup(func() { db.Close() })
+    for n, balance := range balances {
+        if err := db.SetBalance(account(n), big.NewInt(balance)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return db
+}
+
+// balanceOf returns the balance of the nth test account
+func balanceOf(t *testing.T, db *dbservice.DatabaseService, n byte) int64 {
+    t.Helper()
+    balance, err := db.GetBalance(account(n))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return balance.Int64()
+}
+
+func TestBondAndUnbond(t *testing.T) {
+    delegator, validator, other := byte(1), byte(2), byte(3)
+    params := Params{UnbondingBlocks: 5}
+
+    tests := []struct {
+        name    string
+        balance int64
+        bond    int64
+        unbond  int64
+        // from is the validator the unbond is taken from
+        from          byte
+        wantErr       error
+        wantBalance   int64
+        wantDelegated int64
+        wantUnbonding int
+    }{
+        {name: "bond", balance: 100, bond: 60, wantBalance: 40, wantDelegated: 60},
+        {name: "whole balance", balance: 100, bond: 100, wantBalance: 0, wantDelegated: 100},
+        {name: "more than the balance", balance: 50, bond: 60, wantErr: ErrInsufficientFunds, wantBalance: 50},
+        {name: "unbond part", balance: 100, bond: 60, unbond: 20, from: validator, wantBalance: 40, wantDelegated: 40, wantUnbonding: 1},
+        {name: "unbond everything", balance: 75, bond: 75, unbond: 75, from: validator, wantBalance: 0, wantDelegated: 0, wantUnbonding: 1},
+        {name: "unbond more than bonded", balance: 100, bond: 60, unbond: 61, from: validator, wantErr: ErrInsufficientStake, wantBalance: 40, wantDelegated: 60},
+        {name: "unbond from another validator", balance: 100, bond: 60, unbond: 10, from: other, wantErr: ErrInsufficientStake, wantBalance: 40, wantDelegated: 60},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newDatabase(t, map[byte]int64{delegator: test.balance})
+
+            err := Bond(db, account(delegator), account(validator), big.NewInt(test.bond), 1)
+            if err == nil && test.unbond > 0 {
+                err = Unbond(db, account(delegator), account(test.from), big.NewInt(test.unbond), 3, params)
+            }
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("error = %v, want %v", err, test.wantErr)
+            }
+
+            if got := balanceOf(t, db, delegator); got != test.wantBalance {
+                t.Errorf("delegator balance = %d, want %d", got, test.wantBalance)
+            }
+            state, err := AccountState(db, account(delegator))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if want := big.NewInt(test.wantDelegated).String(); state.Delegated != want {
+                t.Errorf("delegated = %s, want %s", state.Delegated, want)
+            }
+            if len(state.Unbonding) != test.wantUnbonding {
+                t.Errorf("unbonding = %v, want %d entries", state.Unbonding, test.wantUnbonding)
+            }
+            for _, entry := range state.Unbonding {
+                if entry.ReleaseBlock != 3+params.UnbondingBlocks {
+                    t.Errorf("unbonding released at block %d, want %d", entry.ReleaseBlock, 3+params.UnbondingBlocks)
+                }
+            }
+            power, err := AccountState(db, account(validator))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if power.ValidatorPower != state.Delegated {
+                t.Errorf("validator power = %s, want %s", power.ValidatorPower, state.Delegated)
+            }
+            // The escrow holds what is bonded and unbonding
+            escrow, err := db.GetBalance(EscrowAddress)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if want := test.balance - test.wantBalance; escrow.Int64() != want {
+                t.Errorf("escrow balance = %s, want %d", escrow, want)
+            }
+        })
+    }
+}
+
+func TestBeginBlockReleasesAndRewards(t *testing.T) {
+    first, second, validator := byte(1), byte(2), byte(3)
+
+    tests := []struct {
+        name   string
+        reward int64
+        // supplyCap is the supply cap of the monetary policy, none when empty
+        supplyCap string
+        // unbond is what the first delegator unbonds at block 2
+        unbond     int64
+        block      int64
+        wantFirst  int64
+        wantSecond int64
+    }{
+        {name: "before the first epoch", reward: 40, block: 9, wantFirst: 700, wantSecond: 400},
+        {name: "epoch boundary", reward: 40, block: 10, wantFirst: 730, wantSecond: 410},
+        {name: "three epochs at once", reward: 40, block: 35, wantFirst: 790, wantSecond: 430},
+        {name: "shares rounded down", reward: 7, block: 10, wantFirst: 705, wantSecond: 401},
+        {name: "outside the monetary policy", reward: 40, supplyCap: "0", block: 10, wantFirst: 700, wantSecond: 400},
+        {name: "unbonding not yet released", unbond: 100, block: 6, wantFirst: 700, wantSecond: 400},
+        {name: "unbonding released", unbond: 100, block: 7, wantFirst: 800, wantSecond: 400},
+        {name: "released and rewarded", reward: 40, unbond: 100, block: 10, wantFirst: 826, wantSecond: 413},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := newDatabase(t, map[byte]int64{first: 1000, second: 500})
+            if test.supplyCap != "" {
+                if err := monetary.SetPolicy(db, monetary.Policy{SupplyCap: test.supplyCap}); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            params := Params{UnbondingBlocks: 5, EpochBlocks: 10, RewardPerEpoch: big.NewInt(test.reward)}
+            if err := Bond(db, account(first), account(validator), big.NewInt(300), 1); err != nil {
+                t.Fatal(err)
+            }
+            if err := Bond(db, account(second), account(validator), big.NewInt(100), 1); err != nil {
+                t.Fatal(err)
+            }
+            if test.unbond > 0 {
+                if err := BeginBlock(db, 2, params); err != nil {
+                    t.Fatal(err)
+                }
+                if err := Unbond(db, account(first), account(validator), big.NewInt(test.unbond), 2, params); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            if err := BeginBlock(db, test.block, params); err != nil {
+                t.Fatal(err)
+            }
+            if got := balanceOf(t, db, first); got != test.wantFirst {
+                t.Errorf("first delegator balance = %d, want %d", got, test.wantFirst)
+            }
+            if got := balanceOf(t, db, second); got != test.wantSecond {
+                t.Errorf("second delegator balance = %d, want %d", got, test.wantSecond)
+            }
+            // Advancing to the same block again changes nothing
+            if err := BeginBlock(db, test.block, params); err != nil {
+                t.Fatal(err)
+            }
+            if got := balanceOf(t, db, first); got != test.wantFirst {
+                t.Errorf("first delegator balance after a second advance = %d, want %d", got, test.wantFirst)
+            }
+        })
+    }
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "context"
+    "encoding/hex"
+    "fmt"
+    "strings"
+
+    "pwr-stateful-vida/api"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/sdk"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// StateMachine is the application logic the node syncs, an sdk.Machine whose state
+// can also be queried
+type StateMachine interface {
+    sdk.Machine
+    // Query answers the queries of GET /query/<path>
+    Query(path string, params map[string]string) (interface{}, error)
+}
+
+// TransactionOutcome is what applying a transaction came to
+type TransactionOutcome = sdk.Outcome
+
+// balanceMachine is the token ledger of this VIDA with its actions, the state
+// machine of an App without one
+type balanceMachine struct {
+    app *App
+}
+
+// BeginBlock leaves the block driven state to the first transaction applied in the
+// block, so a block whose payloads are all over the limits leaves it as it was
+func (balanceMachine) BeginBlock(ctx context.Context, block int64) {}
+
+func (m balanceMachine) ApplyTransaction(ctx context.Context, transaction rpc.VidaDataTransaction) TransactionOutcome {
+    if m.app.config().Canonical {
+        label, failure := m.app.applyCanonical(ctx, transaction)
+        return TransactionOutcome{Label: label, Failure: failure}
+    }
+
+    payload, label, failure := m.app.parsePayload(transaction)
+    if failure != "" {
+        syncLogger.WarnContext(ctx, "rejecting payload over the limits", "hash", transaction.Hash, "reason", failure, "size", len(transaction.Data)/2)
+    } else if m.app.queueTransfer(ctx, transaction, payload, label) {
+        // Its outcome is recorded when the queue is flushed
+        return TransactionOutcome{Label: label, Deferred: true}
+    } else {
+        failure = m.app.applyTransactionSafely(ctx, transaction, payload, label)
+    }
+    return TransactionOutcome{Label: label, Failure: failure}
+}
+
+func (m balanceMachine) EndBlock(ctx context.Context, block int64) {
+    m.app.endBlock(ctx, block)
+}
+
+// RootHash applies the queued transfers before computing the root
+func (m balanceMachine) RootHash() ([]byte, error) {
+    m.app.flushTransfers()
+    return m.app.db.GetRootHash()
+}
+
+// balanceQuery is the answer to the balance query
+type balanceQuery struct {
+    Address     string `json:"address"`
+    Balance     string `json:"balance"`
+    BlockNumber int64  `json:"blockNumber"`
+}
+
+// rootHashQuery is the answer to the rootHash query
+type rootHashQuery struct {
+    RootHash    string `json:"rootHash"`
+    BlockNumber int64  `json:"blockNumber"`
+}
+
+// Query answers balance?address=<address> from the state after the last completed
+// block and rootHash from the last checkpoint
+func (m balanceMachine) Query(path string, params map[string]string) (interface{}, error) {
+    switch path {
+    case "balance":
+        address, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(params["address"]), "0x"))
+        if err != nil || len(address) != dbservice.AddressLength {
+            return nil, fmt.Errorf("%w: address must be an address", api.ErrInvalidQuery)
+        }
+        balance, blockNumber, err := m.app.db.CommittedBalanceBlockContext(context.Background(), address)
+        if err != nil {
+            return nil, err
+        }
+        return balanceQuery{Address: hex.EncodeToString(address), Balance: balance.String(), BlockNumber: blockNumber}, nil
+    case "rootHash":
+        checkpoint, rootHash, err := m.app.db.CheckpointRootHash()
+        if err != nil {
+            return nil, err
+        }
+        return rootHashQuery{RootHash: hex.EncodeToString(rootHash), BlockNumber: checkpoint}, nil
+    }
+    return nil, api.ErrUnknownQuery
+}
+
+// machine returns the state machine of the app
+func (a *App) machine() StateMachine {
+    if a.Machine == nil {
+        return balanceMachine{app: a}
+    }
+    return a.Machine
+}
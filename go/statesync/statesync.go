@@ -0,0 +1,343 @@
+// Package statesync splits a state into chunks that nodes fetch from peers and
+// verify one at a time. The leaves are taken in insertion order, the order the tree
+// hashes them in, and cut into chunks of a power of two leaves, so the hash of a
+// chunk is the hash of a node of the tree. A manifest lists the chunk hashes of a
+// state, which combine into its root hash like any other level of the tree: a chunk
+// is checked against the manifest and the manifest against a trusted root hash.
+package statesync
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+
+    "pwr-stateful-vida/dbfile"
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/snapshot"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+    "golang.org/x/crypto/sha3"
+)
+
+// Version is the version of the binary encodings
+const Version = 1
+
+// DefaultChunkLeaves is the number of leaves in a chunk unless asked otherwise
+const DefaultChunkLeaves = 1 << 12
+
+// MaxChunkLeaves is the largest allowed chunk
+const MaxChunkLeaves = 1 << 16
+
+// hashLength is the length of a node hash
+const hashLength = 32
+
+// ErrMalformed is returned for data that is not a valid manifest or chunk
+var ErrMalformed = errors.New("malformed state sync data")
+
+// Manifest describes the chunks of a state
+type Manifest struct {
+    BlockNumber int64
+    // Leaves is the number of leaves of the state
+    Leaves int64
+    // ChunkLeaves is the number of leaves in every chunk but the last
+    ChunkLeaves int
+    // RootHash is the root hash of the state, nil when it is empty
+    RootHash []byte
+    // Chunks are the hashes of the chunks in order
+    Chunks [][]byte
+}
+
+// hashPair hashes two sibling nodes
+func hashPair(left, right []byte) []byte {
+    hasher := sha3.NewLegacyKeccak256()
+    hasher.Write(left)
+    hasher.Write(right)
+    return hasher.Sum(nil)
+}
+
+// reduce hashes nodes pairwise into the level above, duplicating an odd last node
+func reduce(nodes [][]byte) [][]byte {
+    parents := make([][]byte, 0, (len(nodes)+1)/2)
+    for i := 0; i < len(nodes); i += 2 {
+        right := nodes[i]
+        if i+1 < len(nodes) {
+            right = nodes[i+1]
+        }
+        parents = append(parents, hashPair(nodes[i], right))
+    }
+    return parents
+}
+
+// root returns the hash above nodes, nil when there are none
+func root(nodes [][]byte) []byte {
+    if len(nodes) == 0 {
+        return nil
+    }
+    for len(nodes) > 1 {
+        nodes = reduce(nodes)
+    }
+    return nodes[0]
+}
+
+// chunkHash returns the hash of the node above the leaves of a chunk, chunkLeaves
+// leaves up. A state of a single chunk has no such node and its root hash is used.
+func chunkHash(entries []dbfile.Entry, chunkLeaves int, single bool) []byte {
+    nodes := make([][]byte, len(entries))
+    for i, entry := range entries {
+        nodes[i] = merkletree.CalculateLeafHash(entry.Key, entry.Value)
+    }
+    if single {
+        return root(nodes)
+    }
+    for width := 1; width < chunkLeaves; width *= 2 {
+        nodes = reduce(nodes)
+    }
+    return nodes[0]
+}
+
+// validChunkLeaves reports whether n is a power of two no larger than MaxChunkLeaves
+func validChunkLeaves(n int) bool {
+    return n > 0 && n <= MaxChunkLeaves && n&(n-1) == 0
+}
+
+// NewManifest returns the manifest of the state made of entries in insertion order
+func NewManifest(entries []dbfile.Entry, chunkLeaves int, blockNumber int64) (*Manifest, error) {
+    if !validChunkLeaves(chunkLeaves) {
+        return nil, fmt.Errorf("chunk size must be a power of two up to %d", MaxChunkLeaves)
+    }
+    m := &Manifest{BlockNumber: blockNumber, Leaves: int64(len(entries)), ChunkLeaves: chunkLeaves}
+    for i := 0; i < m.Count(); i++ {
+        start, end := m.Range(i)
+        m.Chunks = append(m.Chunks, chunkHash(entries[start:end], chunkLeaves, m.Count() == 1))
+    }
+    m.RootHash = m.computeRoot()
+    return m, nil
+}
+
+// Count returns the number of chunks
+func (m *Manifest) Count() int {
+    return int((m.Leaves + int64(m.ChunkLeaves) - 1) / int64(m.ChunkLeaves))
+}
+
+// Range returns the leaves of chunk i as a half-open interval
+func (m *Manifest) Range(i int) (int, int) {
+    start := int64(i) * int64(m.ChunkLeaves)
+    return int(start), int(min(start+int64(m.ChunkLeaves), m.Leaves))
+}
+
+// computeRoot combines the chunk hashes into the root hash
+func (m *Manifest) computeRoot() []byte {
+    if len(m.Chunks) == 1 {
+        return m.Chunks[0]
+    }
+    return root(m.Chunks)
+}
+
+// Verify checks that the chunk hashes combine into the root hash
+func (m *Manifest) Verify() error {
+    if !validChunkLeaves(m.ChunkLeaves) || m.Leaves < 0 || len(m.Chunks) != m.Count() {
+        return fmt.Errorf("%w: chunk count does not match the leaves", ErrMalformed)
+    }
+    if !bytes.Equal(m.computeRoot(), m.RootHash) {
+        return errors.New("chunk hashes do not match the root hash")
+    }
+    return nil
+}
+
+// CheckChunk verifies that entries are the leaves of chunk i
+func (m *Manifest) CheckChunk(i int, entries []dbfile.Entry) error {
+    if i < 0 || i >= m.Count() {
+        return fmt.Errorf("chunk %d out of range", i)
+    }
+    start, end := m.Range(i)
+    if len(entries) != end-start {
+        return fmt.Errorf("chunk %d has %d leaves, expected %d", i, len(entries), end-start)
+    }
+    if !bytes.Equal(chunkHash(entries, m.ChunkLeaves, m.Count() == 1), m.Chunks[i]) {
+        return fmt.Errorf("chunk %d does not match its hash", i)
+    }
+    return nil
+}
+
+// Encode returns the binary encoding of the manifest: the version byte, the block
+// number, leaf count, chunk size and chunk count, then the root hash (zeros for an
+// empty state) and the chunk hashes, with integers in big-endian order
+func (m *Manifest) Encode() []byte {
+    data := make([]byte, 0, 25+hashLength*(len(m.Chunks)+1))
+    data = append(data, Version)
+    data = binary.BigEndian.AppendUint64(data, uint64(m.BlockNumber))
+    data = binary.BigEndian.AppendUint64(data, uint64(m.Leaves))
+    data = binary.BigEndian.AppendUint32(data, uint32(m.ChunkLeaves))
+    data = binary.BigEndian.AppendUint32(data, uint32(len(m.Chunks)))
+    if m.RootHash == nil {
+        data = append(data, make([]byte, hashLength)...)
+    } else {
+        data = append(data, m.RootHash...)
+    }
+    for _, hash := range m.Chunks {
+        data = append(data, hash...)
+    }
+    return data
+}
+
+// DecodeManifest parses and verifies an encoded manifest
+func DecodeManifest(data []byte) (*Manifest, error) {
+    if len(data) < 25+hashLength || data[0] != Version {
+        return nil, fmt.Errorf("%w: bad manifest header", ErrMalformed)
+    }
+    m := &Manifest{
+        BlockNumber: int64(binary.BigEndian.Uint64(data[1:])),
+        Leaves:      int64(binary.BigEndian.Uint64(data[9:])),
+        ChunkLeaves: int(binary.BigEndian.Uint32(data[17:])),
+    }
+    count := int(binary.BigEndian.Uint32(data[21:]))
+    data = data[25:]
+    if len(data) != hashLength*(count+1) {
+        return nil, fmt.Errorf("%w: manifest length does not match its chunk count", ErrMalformed)
+    }
+    if m.Leaves > 0 {
+        m.RootHash = bytes.Clone(data[:hashLength])
+    }
+    for i := 1; i <= count; i++ {
+        m.Chunks = append(m.Chunks, bytes.Clone(data[i*hashLength:(i+1)*hashLength]))
+    }
+    return m, m.Verify()
+}
+
+// EncodeChunk returns the binary encoding of chunk i: the version byte, the chunk
+// index and leaf count, then every key and value prefixed with its length, with
+// integers in big-endian order
+func EncodeChunk(i int, entries []dbfile.Entry) []byte {
+    size := 9
+    for _, entry := range entries {
+        size += 8 + len(entry.Key) + len(entry.Value)
+    }
+    data := make([]byte, 0, size)
+    data = append(data, Version)
+    data = binary.BigEndian.AppendUint32(data, uint32(i))
+    data = binary.BigEndian.AppendUint32(data, uint32(len(entries)))
+    for _, entry := range entries {
+        data = binary.BigEndian.AppendUint32(data, uint32(len(entry.Key)))
+        data = append(data, entry.Key...)
+        data = binary.BigEndian.AppendUint32(data, uint32(len(entry.Value)))
+        data = append(data, entry.Value...)
+    }
+    return data
+}
+
+// DecodeChunk parses an encoded chunk, returning its index and leaves
+func DecodeChunk(data []byte) (int, []dbfile.Entry, error) {
+    if len(data) < 9 || data[0] != Version {
+        return 0, nil, fmt.Errorf("%w: bad chunk header", ErrMalformed)
+    }
+    i := int(binary.BigEndian.Uint32(data[1:]))
+    count := int(binary.BigEndian.Uint32(data[5:]))
+    if count > MaxChunkLeaves {
+        return 0, nil, fmt.Errorf("%w: chunk has too many leaves", ErrMalformed)
+    }
+    data = data[9:]
+
+    // next returns the next length-prefixed field
+    next := func() ([]byte, bool) {
+        if len(data) < 4 {
+            return nil, false
+        }
+        n := binary.BigEndian.Uint32(data)
+        if uint64(len(data)-4) < uint64(n) {
+            return nil, false
+        }
+        field := bytes.Clone(data[4 : 4+n])
+        data = data[4+n:]
+        return field, true
+    }
+    entries := make([]dbfile.Entry, 0, count)
+    for len(entries) < count {
+        key, ok := next()
+        if !ok {
+            return 0, nil, fmt.Errorf("%w: chunk is truncated", ErrMalformed)
+        }
+        value, ok := next()
+        if !ok {
+            return 0, nil, fmt.Errorf("%w: chunk is truncated", ErrMalformed)
+        }
+        entries = append(entries, dbfile.Entry{Key: key, Value: value})
+    }
+    if len(data) != 0 {
+        return 0, nil, fmt.Errorf("%w: trailing bytes after the chunk", ErrMalformed)
+    }
+    return i, entries, nil
+}
+
+// Source serves the chunks of a snapshot file
+type Source struct {
+    Manifest *Manifest
+    entries  []dbfile.Entry
+}
+
+// Open reads the snapshot file at path into a source, checking that its chunks
+// combine into the root hash stored in the file
+func Open(path string, chunkLeaves int) (*Source, error) {
+    file, err := dbfile.Open(path, true)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    entries, err := file.Entries()
+    if err != nil {
+        return nil, err
+    }
+    data, err := file.Get(dbservice.LastCheckedBlockKey)
+    if err != nil {
+        return nil, err
+    }
+    manifest, err := NewManifest(entries, chunkLeaves, dbservice.DecodeBlockNumber(data))
+    if err != nil {
+        return nil, err
+    }
+    if !bytes.Equal(manifest.RootHash, file.RootHash()) {
+        return nil, fmt.Errorf("snapshot %s does not reproduce its root hash", path)
+    }
+    return &Source{Manifest: manifest, entries: entries}, nil
+}
+
+// OpenSnapshot opens the snapshot of blockNumber in dir, or the newest one for a
+// negative block number
+func OpenSnapshot(dir string, blockNumber int64, chunkLeaves int) (*Source, error) {
+    snapshots, err := snapshot.List(dir)
+    if err != nil {
+        return nil, err
+    }
+    for i := len(snapshots) - 1; i >= 0; i-- {
+        if blockNumber < 0 || snapshots[i].BlockNumber == blockNumber {
+            return Open(snapshots[i].Path, chunkLeaves)
+        }
+    }
+    return nil, ErrNoSnapshot
+}
+
+// ErrNoSnapshot is returned when no snapshot of the requested block exists
+var ErrNoSnapshot = errors.New("no snapshot of the requested block")
+
+// Chunk returns the leaves of chunk i
+func (s *Source) Chunk(i int) ([]dbfile.Entry, error) {
+    if i < 0 || i >= s.Manifest.Count() {
+        return nil, fmt.Errorf("chunk %d out of range", i)
+    }
+    start, end := s.Manifest.Range(i)
+    return s.entries[start:end], nil
+}
+
+// Diff returns the chunks of manifest that the local entries, in insertion order,
+// do not hold. A node with an older or damaged copy of the state only fetches these.
+func Diff(manifest *Manifest, local []dbfile.Entry) []int {
+    var missing []int
+    for i := 0; i < manifest.Count(); i++ {
+        start, end := manifest.Range(i)
+        if end > len(local) || manifest.CheckChunk(i, local[start:end]) != nil {
+            missing = append(missing, i)
+        }
+    }
+    return missing
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+    "context"
+    "errors"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/stream"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// failureStreamNotFound rejects an action on a stream that is not open
+const failureStreamNotFound = "stream_not_found"
+
+// openStreamPayload is the payload of an openStream
+type openStreamPayload struct {
+    envelope
+    Receiver string `json:"receiver"`
+    Token    string `json:"token"`
+    // Rate is the amount vesting each block
+    Rate    number `json:"rate"`
+    Deposit number `json:"deposit"`
+
+    receiver []byte
+}
+
+func (p *openStreamPayload) validate(a *App) error {
+    if p.receiver = payloadAddress(p.Receiver); p.receiver == nil {
+        return invalidField("receiver", "must be an address")
+    }
+    return nil
+}
+
+// streamPayload is the payload of a withdrawStream or a closeStream
+type streamPayload struct {
+    envelope
+    Stream number `json:"stream"`
+
+    stream uint64
+}
+
+func (p *streamPayload) validate(a *App) error {
+    id := parsePositiveAmount(p.Stream)
+    if id == nil || !id.IsUint64() {
+        return invalidField("stream", "must be a stream ID")
+    }
+    p.stream = id.Uint64()
+    return nil
+}
+
+// handleOpenStream locks "deposit" of "token" to vest "rate" per block to
+// "receiver". It returns the reason the stream was rejected, or an empty string on
+// success.
+func (a *App) handleOpenStream(ctx context.Context, p *openStreamPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    rate := a.parseTokenAmount(p.Rate, p.Token)
+    deposit := a.parseTokenAmount(p.Deposit, p.Token)
+    if rate == nil || deposit == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid stream", "payload", p)
+        return failureInvalidPayload
+    }
+    id, err := stream.Open(a.db, sender, p.receiver, p.Token, rate, deposit, int64(transaction.BlockNumber))
+    if err != nil {
+        return streamFailure(ctx, err, "openStream", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "stream opened", "stream", id, "rate", rate, "deposit", deposit, "token", p.Token, "sender", senderHex)
+    return ""
+}
+
+// handleWithdrawStream pays the receiver of a "stream" what has vested. It returns
+// the reason the withdrawal was rejected, or an empty string on success.
+func (a *App) handleWithdrawStream(ctx context.Context, p *streamPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    paid, err := stream.Withdraw(a.db, sender, p.stream, int64(transaction.BlockNumber))
+    if err != nil {
+        return streamFailure(ctx, err, "withdrawStream", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "stream withdrawn", "stream", p.stream, "amount", paid, "sender", senderHex)
+    return ""
+}
+
+// handleCloseStream settles a "stream", sent by either party. It returns the reason
+// the action was rejected, or an empty string on success.
+func (a *App) handleCloseStream(ctx context.Context, p *streamPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    paid, refund, err := stream.Close(a.db, sender, p.stream, int64(transaction.BlockNumber))
+    if err != nil {
+        return streamFailure(ctx, err, "closeStream", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "stream closed", "stream", p.stream, "paid", paid, "refund", refund, "sender", senderHex)
+    return ""
+}
+
+// streamFailure maps an error of the stream package to the reason a transaction is
+// rejected
+func streamFailure(ctx context.Context, err error, action string, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, stream.ErrInvalid):
+        syncLogger.WarnContext(ctx, "skipping invalid stream", "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, stream.ErrNotFound):
+        return failureStreamNotFound
+    case errors.Is(err, stream.ErrNotParty):
+        syncLogger.WarnContext(ctx, action+" from an address that is not a party", "payload", payload, "sender", senderHex)
+        return failureUnauthorized
+    case errors.Is(err, stream.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, "stream failed: insufficient funds", "payload", payload, "sender", senderHex)
+        return failureInsufficientFunds
+    }
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": senderHex},
+    })
+    return failureInvalidPayload
+}
@@ -0,0 +1,207 @@
+// Package stream pays tokens from a sender to a receiver continuously. Opening a
+// stream locks its deposit in the stream account, which then vests to the receiver
+// at a fixed amount per block until the deposit is used up. The receiver withdraws
+// what has vested at any time, and closing the stream settles it at the closing
+// block: the receiver gets what vested and the sender gets the rest back. Open
+// streams are part of the Merkle state.
+package stream
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "strconv"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// Errors of rejected stream actions
+var (
+    ErrInvalid           = errors.New("invalid stream")
+    ErrNotFound          = errors.New("stream not found")
+    ErrNotParty          = errors.New("sender is not a party of the stream")
+    ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// Address is the account holding the deposits of open streams
+var Address = dbservice.ModuleAddress("stream")
+
+var countKey = []byte(dbservice.StreamPrefix + "count")
+
+// Stream vests Rate of Token per block from Start until Deposit is used up, so it
+// ends at block Start + Deposit / Rate, rounded up
+type Stream struct {
+    ID        uint64 `json:"id"`
+    Sender    string `json:"sender"`
+    Receiver  string `json:"receiver"`
+    Token     string `json:"token"`
+    Rate      string `json:"rate"`
+    Deposit   string `json:"deposit"`
+    Withdrawn string `json:"withdrawn"`
+    Start     int64  `json:"start"`
+}
+
+// streamKey returns the tree key of a stream
+func streamKey(id uint64) []byte {
+    return []byte(fmt.Sprintf("%sstream/%020d", dbservice.StreamPrefix, id))
+}
+
+// save writes a stream as JSON, or removes it when closed is set
+func save(db *dbservice.DatabaseService, stream Stream, closed bool) error {
+    if closed {
+        return db.SaveJSON(streamKey(stream.ID), nil)
+    }
+    return db.SaveJSON(streamKey(stream.ID), stream)
+}
+
+// pay moves paid of token out of the stream account to account, if there is any
+func pay(db *dbservice.DatabaseService, token string, account []byte, paid *big.Int) error {
+    if paid.Sign() == 0 {
+        return nil
+    }
+    _, err := tokens.Transfer(db, token, Address, account, paid)
+    return err
+}
+
+// Vested returns what has vested to the receiver by block
+func (s Stream) Vested(block int64) *big.Int {
+    if block <= s.Start {
+        return new(big.Int)
+    }
+    vested := new(big.Int).Mul(dbservice.ParseAmount(s.Rate), big.NewInt(block-s.Start))
+    if deposit := dbservice.ParseAmount(s.Deposit); vested.Cmp(deposit) > 0 {
+        return deposit
+    }
+    return vested
+}
+
+// Withdrawable returns what the receiver can withdraw at block
+func (s Stream) Withdrawable(block int64) *big.Int {
+    vested := s.Vested(block)
+    return vested.Sub(vested, dbservice.ParseAmount(s.Withdrawn))
+}
+
+// End returns the block the whole deposit has vested at
+func (s Stream) End() int64 {
+    rate := dbservice.ParseAmount(s.Rate)
+    blocks := new(big.Int).Add(dbservice.ParseAmount(s.Deposit), new(big.Int).Sub(rate, big.NewInt(1)))
+    blocks.Quo(blocks, rate)
+    if !blocks.IsInt64() {
+        return 0
+    }
+    return s.Start + blocks.Int64()
+}
+
+// Lookup returns an open stream, and false when there is none with that ID
+func Lookup(db *dbservice.DatabaseService, id uint64) (Stream, bool, error) {
+    var stream Stream
+    found, err := db.LoadJSON(streamKey(id), &stream)
+    return stream, found, err
+}
+
+// Count returns the number of streams opened
+func Count(db *dbservice.DatabaseService) (uint64, error) {
+    data, err := db.GetData(countKey)
+    if err != nil || len(data) == 0 {
+        return 0, err
+    }
+    return strconv.ParseUint(string(data), 10, 64)
+}
+
+// Open locks deposit of token from sender in a stream to receiver vesting rate per
+// block after block, and returns its ID
+func Open(db *dbservice.DatabaseService, sender, receiver []byte, token string, rate, deposit *big.Int, block int64) (uint64, error) {
+    if bytes.Equal(sender, receiver) {
+        return 0, fmt.Errorf("%w: the sender cannot stream to itself", ErrInvalid)
+    }
+    if rate.Cmp(deposit) > 0 {
+        return 0, fmt.Errorf("%w: the rate is above the deposit", ErrInvalid)
+    }
+    if tokens.IsNative(token) {
+        token = tokens.Native
+    } else if _, found, err := tokens.Lookup(db, token); err != nil || !found {
+        if err != nil {
+            return 0, err
+        }
+        return 0, fmt.Errorf("%w: token %q is not registered", ErrInvalid, token)
+    }
+    count, err := Count(db)
+    if err != nil {
+        return 0, err
+    }
+    ok, err := tokens.Transfer(db, token, sender, Address, deposit)
+    if err != nil {
+        return 0, err
+    }
+    if !ok {
+        return 0, ErrInsufficientFunds
+    }
+
+    stream := Stream{
+        ID:        count + 1,
+        Sender:    hex.EncodeToString(sender),
+        Receiver:  hex.EncodeToString(receiver),
+        Token:     token,
+        Rate:      rate.String(),
+        Deposit:   deposit.String(),
+        Withdrawn: "0",
+        Start:     block,
+    }
+    if err := save(db, stream, false); err != nil {
+        return 0, err
+    }
+    return stream.ID, db.SetData(countKey, []byte(strconv.FormatUint(stream.ID, 10)))
+}
+
+// Withdraw pays the receiver of a stream what has vested by block and not been
+// withdrawn, and returns the amount. A stream whose deposit is all paid out closes.
+func Withdraw(db *dbservice.DatabaseService, receiver []byte, id uint64, block int64) (*big.Int, error) {
+    stream, found, err := Lookup(db, id)
+    if err != nil {
+        return nil, err
+    }
+    if !found {
+        return nil, ErrNotFound
+    }
+    if stream.Receiver != hex.EncodeToString(receiver) {
+        return nil, ErrNotParty
+    }
+    paid := stream.Withdrawable(block)
+    if err := pay(db, stream.Token, receiver, paid); err != nil {
+        return nil, err
+    }
+    withdrawn := dbservice.ParseAmount(stream.Withdrawn).Add(dbservice.ParseAmount(stream.Withdrawn), paid)
+    stream.Withdrawn = withdrawn.String()
+    return paid, save(db, stream, withdrawn.Cmp(dbservice.ParseAmount(stream.Deposit)) >= 0)
+}
+
+// Close settles a stream at block on behalf of its sender or receiver: the receiver
+// gets what vested and has not been withdrawn and the sender gets the rest of the
+// deposit back. It returns the two amounts.
+func Close(db *dbservice.DatabaseService, party []byte, id uint64, block int64) (*big.Int, *big.Int, error) {
+    stream, found, err := Lookup(db, id)
+    if err != nil {
+        return nil, nil, err
+    }
+    if !found {
+        return nil, nil, ErrNotFound
+    }
+    partyHex := hex.EncodeToString(party)
+    if partyHex != stream.Sender && partyHex != stream.Receiver {
+        return nil, nil, ErrNotParty
+    }
+    sender, _ := hex.DecodeString(stream.Sender)
+    receiver, _ := hex.DecodeString(stream.Receiver)
+    paid := stream.Withdrawable(block)
+    refund := new(big.Int).Sub(dbservice.ParseAmount(stream.Deposit), stream.Vested(block))
+    if err := pay(db, stream.Token, receiver, paid); err != nil {
+        return nil, nil, err
+    }
+    if err := pay(db, stream.Token, sender, refund); err != nil {
+        return nil, nil, err
+    }
+    return paid, refund, save(db, stream, true)
+}
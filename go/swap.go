@@ -0,0 +1,146 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/swap"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Reasons a swap or liquidity change is rejected
+const (
+    failureNoLiquidity        = "no_liquidity"
+    failureSlippage           = "slippage_exceeded"
+    failureInsufficientShares = "insufficient_shares"
+)
+
+// swapFailure maps an error of the swap package to the reason a transaction is rejected
+func swapFailure(ctx context.Context, err error, action string, payload actionPayload, senderHex string) string {
+    switch {
+    case errors.Is(err, swap.ErrInvalidPair):
+        syncLogger.WarnContext(ctx, "skipping invalid "+action, "payload", payload, "error", err)
+        return failureInvalidPayload
+    case errors.Is(err, swap.ErrNoLiquidity):
+        syncLogger.InfoContext(ctx, action+" failed: pool has no liquidity", "payload", payload, "sender", senderHex)
+        return failureNoLiquidity
+    case errors.Is(err, swap.ErrSlippage):
+        syncLogger.InfoContext(ctx, action+" failed: output below the minimum", "payload", payload, "sender", senderHex)
+        return failureSlippage
+    case errors.Is(err, swap.ErrInsufficientFunds):
+        syncLogger.InfoContext(ctx, action+" failed: insufficient funds", "payload", payload, "sender", senderHex)
+        return failureInsufficientFunds
+    case errors.Is(err, swap.ErrInsufficientShares):
+        syncLogger.InfoContext(ctx, action+" failed: insufficient shares", "payload", payload, "sender", senderHex)
+        return failureInsufficientShares
+    }
+    reporting.Report(err, reporting.Context{
+        Module:        "handler",
+        Action:        action,
+        CorrelationID: logging.CorrelationID(ctx),
+        Extra:         map[string]string{"sender": senderHex},
+    })
+    return failureInvalidPayload
+}
+
+// swapPayload is the payload of a swap
+type swapPayload struct {
+    envelope
+    TokenIn      string `json:"tokenIn"`
+    TokenOut     string `json:"tokenOut"`
+    Amount       number `json:"amount"`
+    MinAmountOut number `json:"minAmountOut"`
+}
+
+// handleSwap sells "amount" of "tokenIn" to the pool of the pair for "tokenOut",
+// rejecting the swap when it would pay out less than "minAmountOut". It returns the
+// reason the swap was rejected, or an empty string on success.
+func (a *App) handleSwap(ctx context.Context, p *swapPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    amountIn := a.parseTokenAmount(p.Amount, p.TokenIn)
+    if sender == nil || amountIn == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid swap", "payload", p)
+        return failureInvalidAmount
+    }
+    minOut := a.parseTokenAmount(p.MinAmountOut, p.TokenOut)
+    if p.MinAmountOut.set() && minOut == nil {
+        syncLogger.WarnContext(ctx, "skipping swap with an invalid minAmountOut", "payload", p)
+        return failureInvalidAmount
+    }
+
+    amountOut, err := swap.Swap(a.db, sender, p.TokenIn, p.TokenOut, amountIn, minOut, a.chainParams().Swap.FeeBasisPoints)
+    if err != nil {
+        return swapFailure(ctx, err, "swap", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "swap applied", "tokenIn", p.TokenIn, "tokenOut", p.TokenOut, "amountIn", amountIn, "amountOut", amountOut, "sender", senderHex)
+    return ""
+}
+
+// addLiquidityPayload is the payload of an addLiquidity
+type addLiquidityPayload struct {
+    envelope
+    TokenA  string `json:"tokenA"`
+    TokenB  string `json:"tokenB"`
+    AmountA number `json:"amountA"`
+    AmountB number `json:"amountB"`
+}
+
+// removeLiquidityPayload is the payload of a removeLiquidity
+type removeLiquidityPayload struct {
+    envelope
+    TokenA string `json:"tokenA"`
+    TokenB string `json:"tokenB"`
+    Shares number `json:"shares"`
+
+    shares *big.Int
+}
+
+func (p *removeLiquidityPayload) validate(a *App) error {
+    if p.shares = parsePositiveAmount(p.Shares); p.shares == nil {
+        return invalidAmount("shares", "must be a positive integer")
+    }
+    return nil
+}
+
+// handleAddLiquidity adds liquidity to the pool of "tokenA" and "tokenB". It
+// returns the reason the change was rejected, or an empty string on success.
+func (a *App) handleAddLiquidity(ctx context.Context, p *addLiquidityPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    amountA := a.parseTokenAmount(p.AmountA, p.TokenA)
+    amountB := a.parseTokenAmount(p.AmountB, p.TokenB)
+    if amountA == nil || amountB == nil {
+        syncLogger.WarnContext(ctx, "skipping invalid addLiquidity", "payload", p)
+        return failureInvalidAmount
+    }
+    shares, err := swap.AddLiquidity(a.db, sender, p.TokenA, p.TokenB, amountA, amountB)
+    if err != nil {
+        return swapFailure(ctx, err, "addLiquidity", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "liquidity added", "tokenA", p.TokenA, "tokenB", p.TokenB, "shares", shares, "sender", senderHex)
+    return ""
+}
+
+// handleRemoveLiquidity redeems "shares" of the pool of "tokenA" and "tokenB". It
+// returns the reason the change was rejected, or an empty string on success.
+func (a *App) handleRemoveLiquidity(ctx context.Context, p *removeLiquidityPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+    amountA, amountB, err := swap.RemoveLiquidity(a.db, sender, p.TokenA, p.TokenB, p.shares)
+    if err != nil {
+        return swapFailure(ctx, err, "removeLiquidity", p, senderHex)
+    }
+    syncLogger.InfoContext(ctx, "liquidity removed", "tokenA", p.TokenA, "tokenB", p.TokenB, "shares", p.shares, "amountA", amountA, "amountB", amountB, "sender", senderHex)
+    return ""
+}
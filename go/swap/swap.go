@@ -0,0 +1,313 @@
+// Package swap implements constant-product pools between two tokens. The tokens of
+// a pool are held by its own module account, whose balances are the reserves, and
+// liquidity providers hold shares of the pool. A swap keeps the product of the
+// reserves from decreasing, after a fee that stays in the pool.
+package swap
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// BasisPoints is the denominator of the swap fee
+const BasisPoints = 10000
+
+// Errors of rejected pool operations
+var (
+    ErrInvalidPair        = errors.New("invalid token pair")
+    ErrNoLiquidity        = errors.New("pool has no liquidity")
+    ErrSlippage           = errors.New("output below the minimum")
+    ErrInsufficientFunds  = errors.New("insufficient funds")
+    ErrInsufficientShares = errors.New("insufficient shares")
+)
+
+// Pool is the state of a pool. TokenA sorts before TokenB.
+type Pool struct {
+    TokenA   string `json:"tokenA"`
+    TokenB   string `json:"tokenB"`
+    Address  string `json:"address"`
+    ReserveA string `json:"reserveA"`
+    ReserveB string `json:"reserveB"`
+    Shares   string `json:"shares"`
+}
+
+// normalize returns the ID of a token, naming the native token by tokens.Native
+func normalize(id string) string {
+    if tokens.IsNative(id) {
+        return tokens.Native
+    }
+    return id
+}
+
+// pair sorts two token IDs into the pair of a pool
+func pair(x, y string) (string, string, error) {
+    x, y = normalize(x), normalize(y)
+    for _, id := range []string{x, y} {
+        if id != tokens.Native && !tokens.ValidID(id) {
+            return "", "", fmt.Errorf("%w: %q is not a token ID", ErrInvalidPair, id)
+        }
+    }
+    if x == y {
+        return "", "", fmt.Errorf("%w: a pool needs two different tokens", ErrInvalidPair)
+    }
+    if x > y {
+        x, y = y, x
+    }
+    return x, y, nil
+}
+
+// Address returns the account holding the reserves of the pool of two tokens
+func Address(a, b string) []byte {
+    return dbservice.ModuleAddress("swap/" + a + "/" + b)
+}
+
+// sharesKey returns the tree key of the shares a provider holds in a pool, or of
+// all shares of the pool for a nil provider
+func sharesKey(a, b string, provider []byte) []byte {
+    key := dbservice.SwapPrefix + "shares/" + a + "/" + b
+    if provider != nil {
+        key += "/" + hex.EncodeToString(provider)
+    }
+    return []byte(key)
+}
+
+// readAmount reads an amount stored under key
+func readAmount(db *dbservice.DatabaseService, key []byte) (*big.Int, error) {
+    data, err := db.GetData(key)
+    if err != nil {
+        return nil, err
+    }
+    return new(big.Int).SetBytes(data), nil
+}
+
+// reserves returns the reserves and total shares of the pool of a sorted pair
+func reserves(db *dbservice.DatabaseService, a, b string) (*big.Int, *big.Int, *big.Int, error) {
+    address := Address(a, b)
+    reserveA, err := tokens.Balance(db, a, address)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    reserveB, err := tokens.Balance(db, b, address)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    total, err := readAmount(db, sharesKey(a, b, nil))
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    return reserveA, reserveB, total, nil
+}
+
+// Lookup returns the state of the pool of two tokens
+func Lookup(db *dbservice.DatabaseService, x, y string) (Pool, error) {
+    a, b, err := pair(x, y)
+    if err != nil {
+        return Pool{}, err
+    }
+    reserveA, reserveB, total, err := reserves(db, a, b)
+    if err != nil {
+        return Pool{}, err
+    }
+    return Pool{
+        TokenA:   a,
+        TokenB:   b,
+        Address:  hex.EncodeToString(Address(a, b)),
+        ReserveA: reserveA.String(),
+        ReserveB: reserveB.String(),
+        Shares:   total.String(),
+    }, nil
+}
+
+// Shares returns the shares provider holds in the pool of two tokens
+func Shares(db *dbservice.DatabaseService, x, y string, provider []byte) (*big.Int, error) {
+    a, b, err := pair(x, y)
+    if err != nil {
+        return nil, err
+    }
+    return readAmount(db, sharesKey(a, b, provider))
+}
+
+// hasBalance reports whether address holds at least amount of token id
+func hasBalance(db *dbservice.DatabaseService, id string, address []byte, amount *big.Int) (bool, error) {
+    balance, err := tokens.Balance(db, id, address)
+    if err != nil {
+        return false, err
+    }
+    return balance.Cmp(amount) >= 0, nil
+}
+
+// ceilDiv returns x / y rounded up
+func ceilDiv(x, y *big.Int) *big.Int {
+    quotient, remainder := new(big.Int).QuoRem(x, y, new(big.Int))
+    if remainder.Sign() > 0 {
+        quotient.Add(quotient, big.NewInt(1))
+    }
+    return quotient
+}
+
+// AddLiquidity deposits up to amountX of token x and amountY of token y into their
+// pool and returns the shares credited to provider. The first deposit sets the
+// price; later ones are taken in the ratio of the reserves, leaving the excess of
+// one token with the provider.
+func AddLiquidity(db *dbservice.DatabaseService, provider []byte, x, y string, amountX, amountY *big.Int) (*big.Int, error) {
+    a, b, err := pair(x, y)
+    if err != nil {
+        return nil, err
+    }
+    amountA, amountB := amountX, amountY
+    if a != normalize(x) {
+        amountA, amountB = amountY, amountX
+    }
+    reserveA, reserveB, total, err := reserves(db, a, b)
+    if err != nil {
+        return nil, err
+    }
+
+    var shares *big.Int
+    if total.Sign() == 0 {
+        shares = new(big.Int).Sqrt(new(big.Int).Mul(amountA, amountB))
+    } else {
+        if needB := ceilDiv(new(big.Int).Mul(amountA, reserveB), reserveA); needB.Cmp(amountB) <= 0 {
+            amountB = needB
+        } else {
+            amountA = ceilDiv(new(big.Int).Mul(amountB, reserveA), reserveB)
+        }
+        shares = new(big.Int).Mul(amountA, total)
+        shares.Quo(shares, reserveA)
+        if fromB := new(big.Int).Quo(new(big.Int).Mul(amountB, total), reserveB); fromB.Cmp(shares) < 0 {
+            shares = fromB
+        }
+    }
+    if shares.Sign() <= 0 {
+        return nil, fmt.Errorf("%w: the deposit is too small for a share", ErrInvalidPair)
+    }
+
+    // Check both balances first, so a rejected deposit changes nothing
+    for _, deposit := range []struct {
+        id     string
+        amount *big.Int
+    }{{a, amountA}, {b, amountB}} {
+        ok, err := hasBalance(db, deposit.id, provider, deposit.amount)
+        if err != nil {
+            return nil, err
+        }
+        if !ok {
+            return nil, ErrInsufficientFunds
+        }
+    }
+    address := Address(a, b)
+    if _, err := tokens.Transfer(db, a, provider, address, amountA); err != nil {
+        return nil, err
+    }
+    if _, err := tokens.Transfer(db, b, provider, address, amountB); err != nil {
+        return nil, err
+    }
+    return shares, addShares(db, a, b, provider, shares, total)
+}
+
+// addShares adds delta, which may be negative, to the shares of provider and to
+// the total of the pool
+func addShares(db *dbservice.DatabaseService, a, b string, provider []byte, delta, total *big.Int) error {
+    held, err := readAmount(db, sharesKey(a, b, provider))
+    if err != nil {
+        return err
+    }
+    if err := db.SetData(sharesKey(a, b, provider), new(big.Int).Add(held, delta).Bytes()); err != nil {
+        return err
+    }
+    return db.SetData(sharesKey(a, b, nil), new(big.Int).Add(total, delta).Bytes())
+}
+
+// RemoveLiquidity redeems shares of provider in the pool of two tokens for their
+// part of both reserves, rounded down, and returns the amounts of x and y paid out
+func RemoveLiquidity(db *dbservice.DatabaseService, provider []byte, x, y string, shares *big.Int) (*big.Int, *big.Int, error) {
+    a, b, err := pair(x, y)
+    if err != nil {
+        return nil, nil, err
+    }
+    held, err := readAmount(db, sharesKey(a, b, provider))
+    if err != nil {
+        return nil, nil, err
+    }
+    if held.Cmp(shares) < 0 {
+        return nil, nil, ErrInsufficientShares
+    }
+    reserveA, reserveB, total, err := reserves(db, a, b)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    amountA := new(big.Int).Quo(new(big.Int).Mul(reserveA, shares), total)
+    amountB := new(big.Int).Quo(new(big.Int).Mul(reserveB, shares), total)
+    address := Address(a, b)
+    if _, err := tokens.Transfer(db, a, address, provider, amountA); err != nil {
+        return nil, nil, err
+    }
+    if _, err := tokens.Transfer(db, b, address, provider, amountB); err != nil {
+        return nil, nil, err
+    }
+    if err := addShares(db, a, b, provider, new(big.Int).Neg(shares), total); err != nil {
+        return nil, nil, err
+    }
+    if a != normalize(x) {
+        return amountB, amountA, nil
+    }
+    return amountA, amountB, nil
+}
+
+// Quote returns the amount of tokenOut a swap of amountIn of tokenIn pays out with
+// the given fee in basis points
+func Quote(db *dbservice.DatabaseService, tokenIn, tokenOut string, amountIn *big.Int, feeBasisPoints int64) (*big.Int, error) {
+    a, b, err := pair(tokenIn, tokenOut)
+    if err != nil {
+        return nil, err
+    }
+    reserveA, reserveB, total, err := reserves(db, a, b)
+    if err != nil {
+        return nil, err
+    }
+    if total.Sign() == 0 {
+        return nil, ErrNoLiquidity
+    }
+    reserveIn, reserveOut := reserveA, reserveB
+    if b == normalize(tokenIn) {
+        reserveIn, reserveOut = reserveB, reserveA
+    }
+
+    // out = reserveOut * in * (1 - fee) / (reserveIn + in * (1 - fee))
+    inAfterFee := new(big.Int).Mul(amountIn, big.NewInt(BasisPoints-feeBasisPoints))
+    numerator := new(big.Int).Mul(reserveOut, inAfterFee)
+    denominator := new(big.Int).Mul(reserveIn, big.NewInt(BasisPoints))
+    denominator.Add(denominator, inAfterFee)
+    return numerator.Quo(numerator, denominator), nil
+}
+
+// Swap sells amountIn of tokenIn from trader to the pool for tokenOut, failing with
+// ErrSlippage when the output would be zero or below minOut. It returns the output.
+func Swap(db *dbservice.DatabaseService, trader []byte, tokenIn, tokenOut string, amountIn, minOut *big.Int, feeBasisPoints int64) (*big.Int, error) {
+    amountOut, err := Quote(db, tokenIn, tokenOut, amountIn, feeBasisPoints)
+    if err != nil {
+        return nil, err
+    }
+    if amountOut.Sign() <= 0 || (minOut != nil && amountOut.Cmp(minOut) < 0) {
+        return nil, ErrSlippage
+    }
+    a, b, _ := pair(tokenIn, tokenOut)
+    address := Address(a, b)
+    ok, err := tokens.Transfer(db, tokenIn, trader, address, amountIn)
+    if err != nil {
+        return nil, err
+    }
+    if !ok {
+        return nil, ErrInsufficientFunds
+    }
+    if _, err := tokens.Transfer(db, tokenOut, address, trader, amountOut); err != nil {
+        return nil, err
+    }
+    return amountOut, nil
+}
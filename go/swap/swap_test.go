@@ -0,0 +1,216 @@
+package swap
+
+import (
+    "bytes"
+    "errors"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+    "pwr-stateful-vida/tokens"
+)
+
+// account returns the address of the nth test account
+func account(n byte) []byte {
+    return bytes.Repeat([]byte{n}, dbservice.AddressLength)
+}
+
+// fund opens a database where each account holds the given amounts of gold and of
+// the native token
+func fund(t *testing.T, holdings map[byte][2]int64) *dbservice.DatabaseService {
+    t.Helper()
+    db := dbservice.New("swap")
+    db.SetDir(t.TempDir())
+    t.Cleanup(func() { db.Close() })
+    for n, amounts := range holdings {
+        for i, id := range []string{"gold", tokens.Native} {
+            if err := tokens.Credit(db, id, account(n), big.NewInt(amounts[i])); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+    return db
+}
+
+// holdings returns the gold and native balances of address
+func holdings(t *testing.T, db *dbservice.DatabaseService, address []byte) [2]int64 {
+    t.Helper()
+    var amounts [2]int64
+    for i, id := range []string{"gold", tokens.Native} {
+        balance, err := tokens.Balance(db, id, address)
+        if err != nil {
+            t.Fatal(err)
+        }
+        amounts[i] = balance.Int64()
+    }
+    return amounts
+}
+
+func TestAddLiquidity(t *testing.T) {
+    seeder, provider := byte(9), byte(1)
+    pool := Address("gold", tokens.Native)
+
+    tests := []struct {
+        name string
+        // seed is the gold and native the seeder deposited first, if any
+        seed    [2]int64
+        x, y    string
+        amountX int64
+        amountY int64
+        // balance is the gold and native the provider holds
+        balance     [2]int64
+        wantErr     error
+        wantShares  int64
+        wantReserve [2]int64
+    }{
+        {name: "first deposit sets the price", x: "gold", y: tokens.Native, amountX: 400, amountY: 100, balance: [2]int64{400, 100}, wantShares: 200, wantReserve: [2]int64{400, 100}},
+        {name: "tokens in either order", x: tokens.Native, y: "gold", amountX: 100, amountY: 400, balance: [2]int64{500, 500}, wantShares: 200, wantReserve: [2]int64{400, 100}},
+        {name: "empty ID is the native token", x: "", y: "gold", amountX: 9, amountY: 16, balance: [2]int64{16, 9}, wantShares: 12, wantReserve: [2]int64{16, 9}},
+        {name: "excess left with the provider", seed: [2]int64{400, 100}, x: "gold", y: tokens.Native, amountX: 200, amountY: 80, balance: [2]int64{200, 80}, wantShares: 100, wantReserve: [2]int64{600, 150}},
+        {name: "rounded for the pool", seed: [2]int64{400, 100}, x: "gold", y: tokens.Native, amountX: 3, amountY: 10, balance: [2]int64{3, 10}, wantShares: 1, wantReserve: [2]int64{403, 101}},
+        {name: "too small for a share", x: "gold", y: tokens.Native, amountX: 1, amountY: 0, balance: [2]int64{1, 0}, wantErr: ErrInvalidPair},
+        {name: "insufficient funds", x: "gold", y: tokens.Native, amountX: 100, amountY: 100, balance: [2]int64{50, 100}, wantErr: ErrInsufficientFunds},
+        {name: "same token twice", x: "gold", y: "gold", amountX: 10, amountY: 10, balance: [2]int64{20, 0}, wantErr: ErrInvalidPair},
+        {name: "invalid token ID", x: "gold!", y: tokens.Native, amountX: 10, amountY: 10, balance: [2]int64{10, 10}, wantErr: ErrInvalidPair},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := fund(t, map[byte][2]int64{seeder: test.seed, provider: test.balance})
+            if test.seed != [2]int64{} {
+                if _, err := AddLiquidity(db, account(seeder), "gold", tokens.Native, big.NewInt(test.seed[0]), big.NewInt(test.seed[1])); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            shares, err := AddLiquidity(db, account(provider), test.x, test.y, big.NewInt(test.amountX), big.NewInt(test.amountY))
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("AddLiquidity error = %v, want %v", err, test.wantErr)
+            }
+            if test.wantErr != nil {
+                // A rejected deposit changes nothing
+                if got := holdings(t, db, account(provider)); got != test.balance {
+                    t.Errorf("provider holds %v after a rejected deposit, want %v", got, test.balance)
+                }
+                return
+            }
+            if shares.Int64() != test.wantShares {
+                t.Errorf("shares = %s, want %d", shares, test.wantShares)
+            }
+            held, err := Shares(db, test.x, test.y, account(provider))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if held.Cmp(shares) != 0 {
+                t.Errorf("provider holds %s shares, want %s", held, shares)
+            }
+            reserve := holdings(t, db, pool)
+            if reserve != test.wantReserve {
+                t.Errorf("reserves = %v, want %v", reserve, test.wantReserve)
+            }
+            deposited := [2]int64{reserve[0] - test.seed[0], reserve[1] - test.seed[1]}
+            if got, want := holdings(t, db, account(provider)), [2]int64{test.balance[0] - deposited[0], test.balance[1] - deposited[1]}; got != want {
+                t.Errorf("provider holds %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+func TestRemoveLiquidity(t *testing.T) {
+    provider := byte(1)
+
+    tests := []struct {
+        name    string
+        shares  int64
+        wantErr error
+        // want are the gold and native paid out
+        want [2]int64
+    }{
+        {name: "quarter", shares: 50, want: [2]int64{100, 25}},
+        {name: "everything", shares: 200, want: [2]int64{400, 100}},
+        {name: "rounded down", shares: 3, want: [2]int64{6, 1}},
+        {name: "more than held", shares: 201, wantErr: ErrInsufficientShares},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := fund(t, map[byte][2]int64{provider: {400, 100}})
+            if _, err := AddLiquidity(db, account(provider), "gold", tokens.Native, big.NewInt(400), big.NewInt(100)); err != nil {
+                t.Fatal(err)
+            }
+
+            gold, native, err := RemoveLiquidity(db, account(provider), "gold", tokens.Native, big.NewInt(test.shares))
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("RemoveLiquidity error = %v, want %v", err, test.wantErr)
+            }
+            if test.wantErr != nil {
+                return
+            }
+            if got := [2]int64{gold.Int64(), native.Int64()}; got != test.want {
+                t.Errorf("paid out %v, want %v", got, test.want)
+            }
+            if got := holdings(t, db, account(provider)); got != test.want {
+                t.Errorf("provider holds %v, want %v", got, test.want)
+            }
+            pool, err := Lookup(db, "gold", tokens.Native)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if want := big.NewInt(200 - test.shares).String(); pool.Shares != want {
+                t.Errorf("pool shares = %s, want %s", pool.Shares, want)
+            }
+        })
+    }
+}
+
+func TestSwap(t *testing.T) {
+    trader, seeder := byte(1), byte(9)
+
+    tests := []struct {
+        name     string
+        empty    bool
+        tokenIn  string
+        tokenOut string
+        amountIn int64
+        minOut   int64
+        fee      int64
+        wantErr  error
+        wantOut  int64
+    }{
+        {name: "no fee", tokenIn: "gold", tokenOut: tokens.Native, amountIn: 100, wantOut: 90},
+        {name: "fee stays in the pool", tokenIn: "gold", tokenOut: tokens.Native, amountIn: 100, fee: 1000, wantOut: 82},
+        {name: "other direction", tokenIn: tokens.Native, tokenOut: "gold", amountIn: 250, fee: 30, wantOut: 199},
+        {name: "minimum met", tokenIn: "gold", tokenOut: tokens.Native, amountIn: 100, minOut: 90, wantOut: 90},
+        {name: "below the minimum", tokenIn: "gold", tokenOut: tokens.Native, amountIn: 100, minOut: 91, wantErr: ErrSlippage},
+        {name: "output rounds to zero", tokenIn: "gold", tokenOut: tokens.Native, amountIn: 1, wantErr: ErrSlippage},
+        {name: "more than the balance", tokenIn: "gold", tokenOut: tokens.Native, amountIn: 600, wantErr: ErrInsufficientFunds},
+        {name: "empty pool", empty: true, tokenIn: "gold", tokenOut: tokens.Native, amountIn: 100, wantErr: ErrNoLiquidity},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            db := fund(t, map[byte][2]int64{trader: {500, 500}, seeder: {1000, 1000}})
+            if !test.empty {
+                if _, err := AddLiquidity(db, account(seeder), "gold", tokens.Native, big.NewInt(1000), big.NewInt(1000)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            out, err := Swap(db, account(trader), test.tokenIn, test.tokenOut, big.NewInt(test.amountIn), big.NewInt(test.minOut), test.fee)
+            if !errors.Is(err, test.wantErr) {
+                t.Fatalf("Swap error = %v, want %v", err, test.wantErr)
+            }
+            if test.wantErr != nil {
+                if got := holdings(t, db, account(trader)); got != [2]int64{500, 500} {
+                    t.Errorf("trader holds %v after a rejected swap, want [500 500]", got)
+                }
+                return
+            }
+            if out.Int64() != test.wantOut {
+                t.Errorf("output = %s, want %d", out, test.wantOut)
+            }
+            // The product of the reserves never decreases
+            reserve := holdings(t, db, Address("gold", tokens.Native))
+            if reserve[0]*reserve[1] < 1000*1000 {
+                t.Errorf("reserves %v have a smaller product than before the swap", reserve)
+            }
+        })
+    }
+}
@@ -0,0 +1,193 @@
+// Package testkit provides fakes for exercising the transaction processing path
+// without a live RPC node or a tree file: an in-memory tree, a synchronous VIDA
+// subscription and peers that answer root hash queries.
+package testkit
+
+import (
+    "bytes"
+    "sort"
+    "sync"
+
+    "pwr-stateful-vida/dbservice"
+
+    "github.com/pwrlabs/pwrgo/config/merkletree"
+    "golang.org/x/crypto/sha3"
+)
+
+// MemoryTree is an in-memory replacement for the Merkle tree file. Leaves are kept in
+// insertion order and hashed pairwise, duplicating an odd last node, which yields the
+// same root hash as the file-backed tree for the same sequence of writes. The hashes
+// of every level are kept between root hash computations, which rehash only the
+// paths of the leaves written since, and a flush or revert only touches the keys
+// written since the previous one.
+type MemoryTree struct {
+    mutex sync.RWMutex
+
+    keys   [][]byte
+    index  map[string]int
+    values map[string][]byte
+
+    // levels holds the hashes of each level, leaves first, as of the last root hash
+    levels [][][]byte
+    // dirty holds the leaves written since the last root hash
+    dirty map[int]bool
+
+    // savedCount is the number of leaves at the last flush
+    savedCount int
+    // undo holds the value at the last flush of each key written since
+    undo map[string]savedValue
+}
+
+// savedValue is the value of a key at the last flush
+type savedValue struct {
+    value   []byte
+    existed bool
+}
+
+// NewMemoryTree returns an empty in-memory tree
+func NewMemoryTree() *MemoryTree {
+    return &MemoryTree{
+        index:  make(map[string]int),
+        values: make(map[string][]byte),
+        dirty:  make(map[int]bool),
+        undo:   make(map[string]savedValue),
+    }
+}
+
+// UseMemoryTree installs a new in-memory tree as the database service backend
+func UseMemoryTree() *MemoryTree {
+    tree := NewMemoryTree()
+    dbservice.UseTree(tree)
+    return tree
+}
+
+// hashPair hashes two sibling nodes
+func hashPair(left, right []byte) []byte {
+    hasher := sha3.NewLegacyKeccak256()
+    hasher.Write(left)
+    hasher.Write(right)
+    return hasher.Sum(nil)
+}
+
+// resize returns level with n nodes, reusing its memory
+func resize(level [][]byte, n int) [][]byte {
+    if cap(level) >= n {
+        return level[:n]
+    }
+    return append(level[:cap(level)], make([][]byte, n-cap(level))...)
+}
+
+// GetRootHash returns the root hash of the current leaves, or nil for an empty tree
+func (t *MemoryTree) GetRootHash() ([]byte, error) {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    n := len(t.keys)
+    if n == 0 {
+        t.levels = nil
+        t.dirty = make(map[int]bool)
+        return nil, nil
+    }
+    if len(t.levels) == 0 {
+        t.levels = [][][]byte{nil}
+    }
+    if len(t.levels[0]) != n {
+        // The last node of each level may have gained or lost its sibling
+        t.dirty[n-1] = true
+    }
+
+    t.levels[0] = resize(t.levels[0], n)
+    changed := make([]int, 0, len(t.dirty))
+    for i := range t.dirty {
+        if i < n {
+            t.levels[0][i] = merkletree.CalculateLeafHash(t.keys[i], t.values[string(t.keys[i])])
+            changed = append(changed, i)
+        }
+    }
+    t.dirty = make(map[int]bool)
+    sort.Ints(changed)
+
+    depth := 0
+    for ; len(t.levels[depth]) > 1; depth++ {
+        level := t.levels[depth]
+        if len(t.levels) == depth+1 {
+            t.levels = append(t.levels, nil)
+        }
+        t.levels[depth+1] = resize(t.levels[depth+1], (len(level)+1)/2)
+        parents := changed[:0]
+        for _, i := range changed {
+            parent := i / 2
+            if len(parents) > 0 && parents[len(parents)-1] == parent {
+                continue
+            }
+            parents = append(parents, parent)
+            left, right := level[2*parent], level[2*parent]
+            if 2*parent+1 < len(level) {
+                right = level[2*parent+1]
+            }
+            t.levels[depth+1][parent] = hashPair(left, right)
+        }
+        changed = parents
+    }
+    t.levels = t.levels[:depth+1]
+    return bytes.Clone(t.levels[depth][0]), nil
+}
+
+// GetData returns the value stored under key
+func (t *MemoryTree) GetData(key []byte) ([]byte, error) {
+    t.mutex.RLock()
+    defer t.mutex.RUnlock()
+    return bytes.Clone(t.values[string(key)]), nil
+}
+
+// AddOrUpdateData stores value under key, appending a leaf for new keys
+func (t *MemoryTree) AddOrUpdateData(key, data []byte) error {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    old, existed := t.values[string(key)]
+    if _, ok := t.undo[string(key)]; !ok {
+        t.undo[string(key)] = savedValue{value: old, existed: existed}
+    }
+    if !existed {
+        t.index[string(key)] = len(t.keys)
+        t.keys = append(t.keys, bytes.Clone(key))
+    }
+    t.values[string(key)] = bytes.Clone(data)
+    t.dirty[t.index[string(key)]] = true
+    return nil
+}
+
+// FlushToDisk marks the current state as saved
+func (t *MemoryTree) FlushToDisk() error {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    t.savedCount = len(t.keys)
+    t.undo = make(map[string]savedValue)
+    return nil
+}
+
+// RevertUnsavedChanges restores the state of the last flush
+func (t *MemoryTree) RevertUnsavedChanges() error {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    for key, saved := range t.undo {
+        if saved.existed {
+            t.values[key] = saved.value
+            t.dirty[t.index[key]] = true
+        } else {
+            delete(t.values, key)
+            delete(t.index, key)
+        }
+    }
+    t.keys = t.keys[:t.savedCount]
+    t.undo = make(map[string]savedValue)
+    return nil
+}
+
+// Close does nothing for an in-memory tree
+func (t *MemoryTree) Close() error {
+    return nil
+}
@@ -0,0 +1,70 @@
+package testkit
+
+import (
+    "encoding/hex"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// Peer is an HTTP server answering /rootHash queries like a peer node
+type Peer struct {
+    server *httptest.Server
+}
+
+// NewPeer starts a peer that answers with the root hash returned by roots. A nil
+// root hash is answered with HTTP 400, like a node that has not reached the block.
+func NewPeer(roots func(blockNumber int) []byte) *Peer {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/rootHash", func(w http.ResponseWriter, r *http.Request) {
+        blockNumber, err := strconv.Atoi(r.URL.Query().Get("blockNumber"))
+        if err != nil {
+            http.Error(w, "invalid block number", http.StatusBadRequest)
+            return
+        }
+        root := roots(blockNumber)
+        if root == nil {
+            http.Error(w, "unknown block", http.StatusBadRequest)
+            return
+        }
+        w.Write([]byte(hex.EncodeToString(root)))
+    })
+    return &Peer{server: httptest.NewServer(mux)}
+}
+
+// AgreeingPeer starts a peer that reports the local node's current root hash
+func AgreeingPeer() *Peer {
+    return NewPeer(func(int) []byte {
+        root, _ := dbservice.GetRootHash()
+        return root
+    })
+}
+
+// FixedPeer starts a peer that reports the same root hash for every block
+func FixedPeer(root []byte) *Peer {
+    return NewPeer(func(int) []byte {
+        return root
+    })
+}
+
+// Addr returns the host:port of the peer, as used in the peer list
+func (p *Peer) Addr() string {
+    return strings.TrimPrefix(p.server.URL, "http://")
+}
+
+// Close stops the peer; it then counts as unreachable
+func (p *Peer) Close() {
+    p.server.Close()
+}
+
+// Addrs returns the addresses of peers
+func Addrs(peers ...*Peer) []string {
+    addrs := make([]string, len(peers))
+    for i, peer := range peers {
+        addrs[i] = peer.Addr()
+    }
+    return addrs
+}
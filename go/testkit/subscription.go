@@ -0,0 +1,210 @@
+package testkit
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "sort"
+    "sync"
+    "time"
+
+    "pwr-stateful-vida/sdk"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// maxBatch is the number of blocks the RPC subscription fetches per poll
+const maxBatch = 1000
+
+// pauseTimeout is how long Pause waits for the poll in progress before it reports
+// a deadlock
+const pauseTimeout = 5 * time.Second
+
+// Subscription replays blocks to a transaction handler and block saver the same way
+// rpc.VidaTransactionSubscription does, but synchronously and from blocks added by the test
+type Subscription struct {
+    handler    rpc.ProcessVidaTransactions
+    blockSaver rpc.BlockSaver
+
+    blocks             map[int][]rpc.VidaDataTransaction
+    latestBlock        int
+    latestCheckedBlock int
+    stopped            bool
+
+    // mutex guards paused and polling, which Pause waits on
+    mutex   sync.Mutex
+    paused  bool
+    polling bool
+
+    // Rewinds records every block passed to SetLatestCheckedBlock
+    Rewinds []int
+}
+
+// NewSubscription returns a subscription that starts delivering at fromBlock
+func NewSubscription(fromBlock int, handler rpc.ProcessVidaTransactions, blockSaver rpc.BlockSaver) *Subscription {
+    return &Subscription{
+        handler:            handler,
+        blockSaver:         blockSaver,
+        blocks:             make(map[int][]rpc.VidaDataTransaction),
+        latestBlock:        fromBlock - 1,
+        latestCheckedBlock: fromBlock - 1,
+    }
+}
+
+// AddBlock appends a block with the given transactions to the fake chain
+func (s *Subscription) AddBlock(blockNumber int, transactions ...rpc.VidaDataTransaction) {
+    for i := range transactions {
+        transactions[i].BlockNumber = blockNumber
+        transactions[i].PositionInTheBlock = i
+    }
+    s.blocks[blockNumber] = append(s.blocks[blockNumber], transactions...)
+    if blockNumber > s.latestBlock {
+        s.latestBlock = blockNumber
+    }
+}
+
+// Poll performs one polling round: it delivers the transactions of up to maxBatch
+// unchecked blocks and then calls the block saver with the last block of the batch.
+// It returns false when there was nothing to deliver.
+func (s *Subscription) Poll() bool {
+    s.mutex.Lock()
+    if s.paused || s.stopped || s.latestCheckedBlock >= s.latestBlock {
+        s.mutex.Unlock()
+        return false
+    }
+    s.polling = true
+    s.mutex.Unlock()
+    defer func() {
+        s.mutex.Lock()
+        s.polling = false
+        s.mutex.Unlock()
+    }()
+
+    from := s.latestCheckedBlock + 1
+    to := s.latestBlock
+    if to > s.latestCheckedBlock+maxBatch {
+        to = s.latestCheckedBlock + maxBatch
+    }
+
+    numbers := make([]int, 0)
+    for number := range s.blocks {
+        if number >= from && number <= to {
+            numbers = append(numbers, number)
+        }
+    }
+    sort.Ints(numbers)
+    for _, number := range numbers {
+        for _, transaction := range s.blocks[number] {
+            s.handler(transaction)
+        }
+    }
+
+    s.latestCheckedBlock = to
+    if s.blockSaver != nil {
+        s.blockSaver(to)
+    }
+    return true
+}
+
+// Sync polls until every block has been delivered or maxPolls rounds have run, and
+// returns the number of rounds. A handler that keeps rewinding stops at the limit.
+func (s *Subscription) Sync(maxPolls int) int {
+    polls := 0
+    for polls < maxPolls && s.Poll() {
+        polls++
+    }
+    return polls
+}
+
+// SetLatestCheckedBlock moves the delivery position, so blocks after it are delivered again
+func (s *Subscription) SetLatestCheckedBlock(blockNumber int) {
+    s.Rewinds = append(s.Rewinds, blockNumber)
+    s.latestCheckedBlock = blockNumber
+}
+
+// GetLatestCheckedBlock returns the last block delivered
+func (s *Subscription) GetLatestCheckedBlock() int {
+    return s.latestCheckedBlock
+}
+
+// Pause stops delivery until Resume is called. Like rpc.VidaTransactionSubscription
+// it returns only once no poll is in progress, so called from the handler or the
+// block saver it never returns. Rather than hang the test it then panics after
+// pauseTimeout, on a goroutine of its own so no recover of the caller hides it.
+func (s *Subscription) Pause() {
+    deadline := time.Now().Add(pauseTimeout)
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.paused = true
+    for s.polling {
+        if time.Now().After(deadline) {
+            go panic("testkit: Pause called during a poll, which deadlocks a real subscription")
+            select {}
+        }
+        s.mutex.Unlock()
+        time.Sleep(10 * time.Millisecond)
+        s.mutex.Lock()
+    }
+}
+
+// Resume continues delivery after Pause
+func (s *Subscription) Resume() {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.paused = false
+}
+
+// Stop ends delivery permanently
+func (s *Subscription) Stop() {
+    s.stopped = true
+}
+
+// IsPaused reports whether delivery is paused
+func (s *Subscription) IsPaused() bool {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    return s.paused
+}
+
+// IsStopped reports whether the subscription was stopped
+func (s *Subscription) IsStopped() bool {
+    return s.stopped
+}
+
+// Transaction returns a VIDA transaction from sender carrying payload as its data
+func Transaction(sender []byte, payload interface{}) rpc.VidaDataTransaction {
+    data, err := json.Marshal(payload)
+    if err != nil {
+        panic(fmt.Sprintf("testkit: cannot encode payload: %v", err))
+    }
+
+    var transaction rpc.VidaDataTransaction
+    transaction.Sender = "0x" + hex.EncodeToString(sender)
+    transaction.Data = hex.EncodeToString(data)
+    transaction.Hash = "0x" + hex.EncodeToString(hashPair(sender, data))
+    transaction.Type = "VIDA Data"
+    transaction.Success = true
+    return transaction
+}
+
+// Transfer returns a transfer transaction in the format the node processes
+func Transfer(sender, receiver []byte, amount *big.Int) rpc.VidaDataTransaction {
+    return Transaction(sender, map[string]string{
+        "action":   "transfer",
+        "receiver": hex.EncodeToString(receiver),
+        "amount":   amount.String(),
+    })
+}
+
+// RPC is an sdk.RPCClient whose subscriptions are Subscriptions the test drives
+type RPC struct {
+    // Subscription is the last subscription started
+    Subscription *Subscription
+}
+
+// Subscribe starts a subscription replaying the blocks the test adds from fromBlock
+func (r *RPC) Subscribe(vidaID, fromBlock int, handler rpc.ProcessVidaTransactions, progress rpc.BlockSaver) sdk.Subscription {
+    r.Subscription = NewSubscription(fromBlock, handler, progress)
+    return r.Subscription
+}
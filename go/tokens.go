@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "math/big"
+
+    "pwr-stateful-vida/logging"
+    "pwr-stateful-vida/reporting"
+    "pwr-stateful-vida/tokens"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// registerTokenPayload is the payload of a registerToken
+type registerTokenPayload struct {
+    envelope
+    Token    string `json:"token"`
+    Name     string `json:"name"`
+    Symbol   string `json:"symbol"`
+    IconURI  string `json:"iconUri"`
+    Decimals number `json:"decimals"`
+    // Supply is issued to the sender when the token is registered
+    Supply number `json:"supply"`
+
+    decimals int
+    supply   *big.Int
+}
+
+func (p *registerTokenPayload) validate(a *App) error {
+    if p.Decimals.set() {
+        decimals, ok := p.Decimals.integer()
+        if !ok || !decimals.IsInt64() {
+            return invalidField("decimals", "must be an integer")
+        }
+        p.decimals = int(decimals.Int64())
+    }
+    if p.Supply.set() {
+        if p.supply = parsePositiveAmount(p.Supply); p.supply == nil {
+            return invalidAmount("supply", "must be a positive integer")
+        }
+    }
+    return nil
+}
+
+// handleTokenRegistration registers or updates the metadata of a token, issuing the
+// given supply to the sender on registration. It returns the reason the
+// registration was rejected, or an empty string on success.
+func (a *App) handleTokenRegistration(ctx context.Context, p *registerTokenPayload, transaction rpc.VidaDataTransaction) string {
+    senderHex := transaction.Sender
+    sender := payloadAddress(senderHex)
+    if sender == nil {
+        return failureInvalidPayload
+    }
+
+    metadata := tokens.Metadata{ID: p.Token, Name: p.Name, Symbol: p.Symbol, IconURI: p.IconURI, Decimals: p.decimals}
+    err := tokens.Register(a.db, metadata, p.supply, sender, int64(transaction.BlockNumber))
+    switch {
+    case errors.Is(err, tokens.ErrNotOwner):
+        syncLogger.WarnContext(ctx, "token update from an account other than its owner", "token", metadata.ID, "sender", senderHex)
+        return failureUnauthorized
+    case errors.Is(err, tokens.ErrInvalidMetadata):
+        syncLogger.WarnContext(ctx, "skipping invalid token registration", "payload", p, "error", err)
+        return failureInvalidPayload
+    case err != nil:
+        reporting.Report(err, reporting.Context{
+            Module:        "handler",
+            Action:        "registerToken",
+            CorrelationID: logging.CorrelationID(ctx),
+            Extra:         map[string]string{"sender": senderHex, "token": metadata.ID},
+        })
+        return failureInvalidPayload
+    }
+    syncLogger.InfoContext(ctx, "token registered", "token", metadata.ID, "symbol", metadata.Symbol, "owner", senderHex)
+    return ""
+}
@@ -0,0 +1,86 @@
+package tokens
+
+import (
+    "encoding/hex"
+    "math/big"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// Native is the ID of the VIDA's own token, whose balances are the account balances
+// of the tree
+const Native = "native"
+
+// IsNative reports whether id names the native token; an empty ID does too
+func IsNative(id string) bool {
+    return id == "" || id == Native
+}
+
+// balanceKey returns the tree key of the balance of a registered token
+func balanceKey(id string, address []byte) []byte {
+    return []byte(dbservice.TokensPrefix + "balance/" + id + "/" + hex.EncodeToString(address))
+}
+
+// Balance returns the balance of address in token id
+func Balance(db *dbservice.DatabaseService, id string, address []byte) (*big.Int, error) {
+    if IsNative(id) {
+        return db.GetBalance(address)
+    }
+    data, err := db.GetData(balanceKey(id, address))
+    if err != nil {
+        return nil, err
+    }
+    return new(big.Int).SetBytes(data), nil
+}
+
+// setBalance stores the balance of address in token id
+func setBalance(db *dbservice.DatabaseService, id string, address []byte, balance *big.Int) error {
+    if IsNative(id) {
+        return db.SetBalance(address, balance)
+    }
+    return db.SetData(balanceKey(id, address), balance.Bytes())
+}
+
+// Credit adds amount to the balance of address in token id
+func Credit(db *dbservice.DatabaseService, id string, address []byte, amount *big.Int) error {
+    balance, err := Balance(db, id, address)
+    if err != nil {
+        return err
+    }
+    return setBalance(db, id, address, new(big.Int).Add(balance, amount))
+}
+
+// Transfer moves amount of token id from sender to receiver, returning false when
+// the sender's balance is too low
+func Transfer(db *dbservice.DatabaseService, id string, sender, receiver []byte, amount *big.Int) (bool, error) {
+    if IsNative(id) {
+        return db.Transfer(sender, receiver, amount)
+    }
+    if !ValidID(id) || sender == nil || receiver == nil || amount == nil {
+        return false, nil
+    }
+    balance, err := Balance(db, id, sender)
+    if err != nil {
+        return false, err
+    }
+    if balance.Cmp(amount) < 0 {
+        return false, nil
+    }
+    if err := setBalance(db, id, sender, new(big.Int).Sub(balance, amount)); err != nil {
+        return false, err
+    }
+    return true, Credit(db, id, receiver, amount)
+}
+
+// Debit removes amount of token id from the balance of address, returning false
+// when the balance is too low
+func Debit(db *dbservice.DatabaseService, id string, address []byte, amount *big.Int) (bool, error) {
+    balance, err := Balance(db, id, address)
+    if err != nil {
+        return false, err
+    }
+    if balance.Cmp(amount) < 0 {
+        return false, nil
+    }
+    return true, setBalance(db, id, address, new(big.Int).Sub(balance, amount))
+}
@@ -0,0 +1,69 @@
+package tokens
+
+import (
+    "bytes"
+    "math/big"
+    "testing"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// openTestDatabase opens a database service in a fresh directory
+func openTestDatabase(t *testing.T) *dbservice.DatabaseService {
+    t.Helper()
+    db := dbservice.New("database")
+    db.SetDir(t.TempDir())
+    t.Cleanup(func() { db.Close() })
+    return db
+}
+
+func TestDatabasesKeepTheirOwnBalances(t *testing.T) {
+    sender := bytes.Repeat([]byte{1}, dbservice.AddressLength)
+    receiver := bytes.Repeat([]byte{2}, dbservice.AddressLength)
+
+    tests := []struct {
+        name string
+        id   string
+    }{
+        {name: "native token", id: Native},
+        {name: "registered token", id: "gold"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            first, second := openTestDatabase(t), openTestDatabase(t)
+            for _, db := range []*dbservice.DatabaseService{first, second} {
+                if err := Credit(db, test.id, sender, big.NewInt(100)); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if ok, err := Transfer(first, test.id, sender, receiver, big.NewInt(30)); err != nil || !ok {
+                t.Fatalf("Transfer = %v, %v", ok, err)
+            }
+
+            want := map[*dbservice.DatabaseService][2]int64{first: {70, 30}, second: {100, 0}}
+            for db, balances := range want {
+                for i, address := range [][]byte{sender, receiver} {
+                    balance, err := Balance(db, test.id, address)
+                    if err != nil {
+                        t.Fatal(err)
+                    }
+                    if balance.Int64() != balances[i] {
+                        t.Errorf("balance of %x = %s, want %d", address, balance, balances[i])
+                    }
+                }
+            }
+
+            firstRoot, err := first.GetRootHash()
+            if err != nil {
+                t.Fatal(err)
+            }
+            secondRoot, err := second.GetRootHash()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if bytes.Equal(firstRoot, secondRoot) {
+                t.Errorf("databases agree on root %x after a transfer in one of them", firstRoot)
+            }
+        })
+    }
+}
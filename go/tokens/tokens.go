@@ -0,0 +1,133 @@
+// Package tokens is the registry of token metadata that wallets use to render
+// balances: the name, symbol, decimals and icon of each token ID, and holds the
+// balances of the registered tokens. Entries are part of the Merkle state. The first
+// account to register an ID owns it, receives its initial supply and is the only one
+// that can change it.
+package tokens
+
+import (
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/big"
+    "net/url"
+    "regexp"
+
+    "pwr-stateful-vida/dbservice"
+)
+
+// Errors of rejected registrations
+var (
+    ErrInvalidMetadata = errors.New("invalid token metadata")
+    ErrNotOwner        = errors.New("token is registered by another account")
+)
+
+// Limits of the registered fields
+const (
+    MaxNameLength    = 64
+    MaxIconURILength = 512
+    MaxDecimals      = 36
+)
+
+var (
+    idPattern     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+    symbolPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,16}$`)
+)
+
+// Metadata describes a token
+type Metadata struct {
+    ID       string `json:"id"`
+    Name     string `json:"name"`
+    Symbol   string `json:"symbol"`
+    Decimals int    `json:"decimals"`
+    IconURI  string `json:"iconUri,omitempty"`
+    // Supply is the amount issued to the owner at registration
+    Supply string `json:"supply"`
+    // Owner is the hex address that registered the token
+    Owner string `json:"owner"`
+    // RegisteredAt and UpdatedAt are the blocks of the registration and last change
+    RegisteredAt int64 `json:"registeredAt"`
+    UpdatedAt    int64 `json:"updatedAt"`
+}
+
+// entryKey returns the tree key of a token's metadata
+func entryKey(id string) []byte {
+    return []byte(dbservice.TokensPrefix + id)
+}
+
+// ValidID reports whether id can name a token
+func ValidID(id string) bool {
+    return idPattern.MatchString(id) && id != Native
+}
+
+// Lookup returns the metadata of a token, and false when it is not registered
+func Lookup(db *dbservice.DatabaseService, id string) (Metadata, bool, error) {
+    var metadata Metadata
+    if !ValidID(id) {
+        return metadata, false, nil
+    }
+    found, err := db.LoadJSON(entryKey(id), &metadata)
+    return metadata, found, err
+}
+
+// validate checks the fields of a registration
+func validate(metadata Metadata) error {
+    switch {
+    case !ValidID(metadata.ID):
+        return fmt.Errorf("%w: the id must be 1 to 64 letters, digits, '.', '_' or '-' and not %q", ErrInvalidMetadata, Native)
+    case metadata.Name == "" || len(metadata.Name) > MaxNameLength:
+        return fmt.Errorf("%w: the name must be 1 to %d bytes", ErrInvalidMetadata, MaxNameLength)
+    case !symbolPattern.MatchString(metadata.Symbol):
+        return fmt.Errorf("%w: the symbol must be 1 to 16 letters or digits", ErrInvalidMetadata)
+    case metadata.Decimals < 0 || metadata.Decimals > MaxDecimals:
+        return fmt.Errorf("%w: decimals must be between 0 and %d", ErrInvalidMetadata, MaxDecimals)
+    case len(metadata.IconURI) > MaxIconURILength:
+        return fmt.Errorf("%w: the icon URI must be at most %d bytes", ErrInvalidMetadata, MaxIconURILength)
+    }
+    if metadata.IconURI != "" {
+        icon, err := url.Parse(metadata.IconURI)
+        if err != nil || (icon.Scheme != "https" && icon.Scheme != "ipfs") {
+            return fmt.Errorf("%w: the icon URI must be an https or ipfs URI", ErrInvalidMetadata)
+        }
+    }
+    return nil
+}
+
+// Register stores the metadata of a token on behalf of owner at a block and credits
+// supply to the owner when the token is new. A token that is already registered can
+// only be changed by its owner, and its supply is fixed.
+func Register(db *dbservice.DatabaseService, metadata Metadata, supply *big.Int, owner []byte, block int64) error {
+    if err := validate(metadata); err != nil {
+        return err
+    }
+    if supply != nil && supply.Sign() < 0 {
+        return fmt.Errorf("%w: the supply must not be negative", ErrInvalidMetadata)
+    }
+    existing, found, err := Lookup(db, metadata.ID)
+    if err != nil {
+        return err
+    }
+    metadata.Owner = hex.EncodeToString(owner)
+    metadata.RegisteredAt, metadata.UpdatedAt = block, block
+    metadata.Supply = "0"
+    if supply != nil {
+        metadata.Supply = supply.String()
+    }
+    if found {
+        if existing.Owner != metadata.Owner {
+            return ErrNotOwner
+        }
+        if supply != nil && supply.Sign() > 0 {
+            return fmt.Errorf("%w: the supply of a registered token is fixed", ErrInvalidMetadata)
+        }
+        metadata.RegisteredAt, metadata.Supply = existing.RegisteredAt, existing.Supply
+    }
+
+    if err := db.SaveJSON(entryKey(metadata.ID), metadata); err != nil {
+        return err
+    }
+    if !found && supply != nil && supply.Sign() > 0 {
+        return Credit(db, metadata.ID, owner, supply)
+    }
+    return nil
+}
@@ -0,0 +1,188 @@
+// Package txlog keeps an append-only log of the VIDA transactions a node processed
+// and of each checkpoint, so that the state can be rebuilt offline.
+package txlog
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+
+    "github.com/pwrlabs/pwrgo/rpc"
+)
+
+// Record types
+const (
+    TypeTransaction = "tx"
+    TypeBlock       = "block"
+)
+
+// Record is a line of the log. Transaction records are written before the transaction
+// is applied; a block record closes the batch of transactions logged before it.
+type Record struct {
+    Type  string `json:"type"`
+    Block int64  `json:"block"`
+
+    // Transaction fields
+    Hash   string `json:"hash,omitempty"`
+    Sender string `json:"sender,omitempty"`
+    Data   string `json:"data,omitempty"`
+
+    // Block fields. RootHash is the hex root compared with peers; Reverted is set
+    // when the batch was discarded after a failed validation.
+    RootHash string `json:"rootHash,omitempty"`
+    Reverted bool   `json:"reverted,omitempty"`
+}
+
+// FromTransaction builds the record of a VIDA transaction
+func FromTransaction(transaction rpc.VidaDataTransaction) Record {
+    return Record{
+        Type:   TypeTransaction,
+        Block:  int64(transaction.BlockNumber),
+        Hash:   transaction.Hash,
+        Sender: transaction.Sender,
+        Data:   transaction.Data,
+    }
+}
+
+// Transaction returns the VIDA transaction described by a transaction record
+func (r Record) Transaction() rpc.VidaDataTransaction {
+    var transaction rpc.VidaDataTransaction
+    transaction.BlockNumber = int(r.Block)
+    transaction.Hash = r.Hash
+    transaction.Sender = r.Sender
+    transaction.Data = r.Data
+    return transaction
+}
+
+// Log appends records to a file
+type Log struct {
+    mutex sync.Mutex
+    path  string
+    file  *os.File
+}
+
+// Open opens the log at path for appending, creating it if needed
+func Open(path string) (*Log, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return nil, err
+    }
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open transaction log %s: %v", path, err)
+    }
+    return &Log{path: path, file: file}, nil
+}
+
+// Append writes a record. Block records are synced to disk before returning.
+func (l *Log) Append(record Record) error {
+    line, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+    if _, err := l.file.Write(append(line, '\n')); err != nil {
+        return err
+    }
+    if record.Type == TypeBlock {
+        return l.file.Sync()
+    }
+    return nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+    return l.file.Close()
+}
+
+// Compact removes the records of blocks up to and including keepAfter while the log
+// stays open, returning the number of records removed
+func (l *Log) Compact(keepAfter int64) (int, error) {
+    l.mutex.Lock()
+    defer l.mutex.Unlock()
+
+    removed, err := Compact(l.path, keepAfter)
+    if err != nil || removed == 0 {
+        return removed, err
+    }
+
+    // The old file was replaced, so appends must go to the new one
+    file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return removed, err
+    }
+    l.file.Close()
+    l.file = file
+    return removed, nil
+}
+
+// Compact rewrites the log at path without the records of blocks up to and including
+// keepAfter. The log must not be open for appending by another process.
+func Compact(path string, keepAfter int64) (int, error) {
+    tmp := path + ".compact"
+    out, err := os.Create(tmp)
+    if err != nil {
+        return 0, err
+    }
+    defer os.Remove(tmp)
+
+    writer := bufio.NewWriter(out)
+    removed := 0
+    err = Read(path, func(record Record) error {
+        if record.Block <= keepAfter {
+            removed++
+            return nil
+        }
+        line, err := json.Marshal(record)
+        if err != nil {
+            return err
+        }
+        _, err = writer.Write(append(line, '\n'))
+        return err
+    })
+    if err == nil {
+        err = writer.Flush()
+    }
+    if err == nil {
+        err = out.Sync()
+    }
+    out.Close()
+    if err != nil || removed == 0 {
+        return 0, err
+    }
+    return removed, os.Rename(tmp, path)
+}
+
+// Read calls fn for every record of the log at path in the order they were written.
+// A truncated last line, left by a crash while writing, is ignored.
+func Read(path string, fn func(Record) error) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    line := 0
+    var pending error
+    for scanner.Scan() {
+        line++
+        if pending != nil {
+            return pending
+        }
+        var record Record
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            pending = fmt.Errorf("%s:%d: %v", path, line, err)
+            continue
+        }
+        if err := fn(record); err != nil {
+            return err
+        }
+    }
+    return scanner.Err()
+}
@@ -0,0 +1,102 @@
+// Package verification writes the reports of a node running in auditor mode: for
+// every checkpoint, the root hash the node computed and whether the peers agreed,
+// signed with the node key so the report can be shown to third parties.
+package verification
+
+import (
+    "crypto/ed25519"
+    "encoding/json"
+    "errors"
+    "strconv"
+    "time"
+
+    "pwr-stateful-vida/logfile"
+    "pwr-stateful-vida/signing"
+)
+
+// signatureTarget is signed in place of a request target
+const signatureTarget = "verification-report"
+
+// PeerResult is the answer of one peer for a block
+type PeerResult struct {
+    Peer string `json:"peer"`
+    // RootHash is empty when the peer did not answer with a root hash
+    RootHash string `json:"rootHash,omitempty"`
+    Agreed   bool   `json:"agreed"`
+}
+
+// Report is a line of the verification log
+type Report struct {
+    Time      time.Time    `json:"time"`
+    Block     int64        `json:"block"`
+    LocalRoot string       `json:"localRoot"`
+    Peers     []PeerResult `json:"peers"`
+    Matches   int          `json:"matches"`
+    Quorum    int          `json:"quorum"`
+    // Agreed reports whether a quorum of the answering peers had the local root
+    Agreed bool `json:"agreed"`
+
+    Signature string `json:"signature,omitempty"`
+    Key       string `json:"key,omitempty"`
+    // PreviousSignature and PreviousKey are set while a key rotation overlaps
+    PreviousSignature string `json:"previousSignature,omitempty"`
+    PreviousKey       string `json:"previousKey,omitempty"`
+}
+
+// body returns the signed encoding of the report, without the signature fields
+func (r Report) body() []byte {
+    r.Signature, r.Key, r.PreviousSignature, r.PreviousKey = "", "", "", ""
+    data, _ := json.Marshal(r)
+    return data
+}
+
+// Sign signs the report with the node key, returning false when signing is disabled
+func (r *Report) Sign() bool {
+    signature, ok := signing.Sign(signatureTarget, r.body(), r.Time)
+    if ok {
+        r.Signature, r.Key = signature.Signature, signature.Key
+        r.PreviousSignature, r.PreviousKey = signature.PreviousSignature, signature.PreviousKey
+    }
+    return ok
+}
+
+// Verify checks the signature of a report against the public key of the auditor
+func Verify(publicKey ed25519.PublicKey, r Report) error {
+    if r.Signature == "" {
+        return errors.New("report is not signed")
+    }
+    signature := signing.Signature{
+        Signature:         r.Signature,
+        Timestamp:         strconv.FormatInt(r.Time.Unix(), 10),
+        PreviousSignature: r.PreviousSignature,
+        PreviousKey:       r.PreviousKey,
+    }
+    return signing.Verify(publicKey, signatureTarget, r.body(), signature)
+}
+
+// Log appends signed reports to a rotated JSON lines file
+type Log struct {
+    writer *logfile.Writer
+}
+
+// Open returns a log appending to path, rotated past maxSize bytes keeping
+// maxBackups files; zero values keep everything
+func Open(path string, maxSize int64, maxBackups int) *Log {
+    return &Log{writer: &logfile.Writer{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}}
+}
+
+// Append signs and writes a report
+func (l *Log) Append(r Report) error {
+    r.Sign()
+    line, err := json.Marshal(r)
+    if err != nil {
+        return err
+    }
+    _, err = l.writer.Write(append(line, '\n'))
+    return err
+}
+
+// Close closes the file
+func (l *Log) Close() error {
+    return l.writer.Close()
+}
@@ -0,0 +1,129 @@
+// Package verifier checks Merkle inclusion proofs produced by a stateful VIDA
+// node. It has no storage dependencies so it can be embedded in light clients.
+package verifier
+
+import (
+    "bytes"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "strings"
+
+    "golang.org/x/crypto/sha3"
+)
+
+// Step is one level of a proof, from the leaf towards the root
+type Step struct {
+    // Sibling is the hash of the other child of the parent node. It is nil when
+    // the parent has a single child, in which case that child is hashed twice.
+    Sibling []byte
+    // Left is true when the sibling is the left child of the parent node
+    Left bool
+}
+
+// Proof proves that Key holds Value in the tree with root RootHash
+type Proof struct {
+    Key      []byte
+    Value    []byte
+    RootHash []byte
+    Steps    []Step
+}
+
+// keccak256 hashes the concatenation of data
+func keccak256(data ...[]byte) []byte {
+    hasher := sha3.NewLegacyKeccak256()
+    for _, d := range data {
+        hasher.Write(d)
+    }
+    return hasher.Sum(nil)
+}
+
+// LeafHash returns the hash of the leaf storing value under key
+func LeafHash(key, value []byte) []byte {
+    return keccak256(key, value)
+}
+
+// ComputeRoot folds the proof steps over the leaf hash and returns the resulting root
+func (p *Proof) ComputeRoot() []byte {
+    hash := LeafHash(p.Key, p.Value)
+    for _, step := range p.Steps {
+        switch {
+        case step.Sibling == nil:
+            hash = keccak256(hash, hash)
+        case step.Left:
+            hash = keccak256(step.Sibling, hash)
+        default:
+            hash = keccak256(hash, step.Sibling)
+        }
+    }
+    return hash
+}
+
+// Verify reports whether the proof leads to rootHash
+func (p *Proof) Verify(rootHash []byte) bool {
+    return len(rootHash) > 0 && bytes.Equal(p.ComputeRoot(), rootHash)
+}
+
+// jsonStep and jsonProof are the hex encoded JSON form of a proof
+type jsonStep struct {
+    Sibling string `json:"sibling,omitempty"`
+    Left    bool   `json:"left,omitempty"`
+}
+
+type jsonProof struct {
+    Key      string     `json:"key"`
+    Value    string     `json:"value"`
+    RootHash string     `json:"rootHash"`
+    Steps    []jsonStep `json:"steps"`
+}
+
+func decodeHex(value string) ([]byte, error) {
+    return hex.DecodeString(strings.TrimPrefix(value, "0x"))
+}
+
+// MarshalJSON encodes the proof with hex strings
+func (p *Proof) MarshalJSON() ([]byte, error) {
+    out := jsonProof{
+        Key:      hex.EncodeToString(p.Key),
+        Value:    hex.EncodeToString(p.Value),
+        RootHash: hex.EncodeToString(p.RootHash),
+        Steps:    make([]jsonStep, len(p.Steps)),
+    }
+    for i, step := range p.Steps {
+        if step.Sibling != nil {
+            out.Steps[i].Sibling = hex.EncodeToString(step.Sibling)
+        }
+        out.Steps[i].Left = step.Left
+    }
+    return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a proof encoded by MarshalJSON
+func (p *Proof) UnmarshalJSON(data []byte) error {
+    var in jsonProof
+    if err := json.Unmarshal(data, &in); err != nil {
+        return err
+    }
+
+    var err error
+    if p.Key, err = decodeHex(in.Key); err != nil {
+        return errors.New("invalid key")
+    }
+    if p.Value, err = decodeHex(in.Value); err != nil {
+        return errors.New("invalid value")
+    }
+    if p.RootHash, err = decodeHex(in.RootHash); err != nil {
+        return errors.New("invalid root hash")
+    }
+
+    p.Steps = make([]Step, len(in.Steps))
+    for i, step := range in.Steps {
+        if step.Sibling != "" {
+            if p.Steps[i].Sibling, err = decodeHex(step.Sibling); err != nil {
+                return errors.New("invalid sibling hash")
+            }
+        }
+        p.Steps[i].Left = step.Left
+    }
+    return nil
+}